@@ -0,0 +1,156 @@
+// Package attest writes and checks the attestation badge of a tree:
+// INTEGRITY.mtfs.json, a small JSON document stating its root hash, the
+// hash algorithm, when the root hash was established and by which MTFS,
+// with a detached OpenPGP signature over the rest of the document, for
+// consumers of the tree to check. The backend leaves the badge out of the
+// tree, like the state directory, so writing it keeps the root hash.
+package attest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"MTFS/forensic"
+	"MTFS/signing"
+	"MTFS/state"
+)
+
+// FileName is the name of the badge in the root of a tree.
+const FileName = "INTEGRITY.mtfs.json"
+
+// KeyEnv names the environment variable holding the OpenPGP key badges are
+// signed with. When it is set, the TUI writes a badge after every
+// successful build and verification; "default" signs with gpg's default
+// key.
+const KeyEnv = "MTFS_ATTEST_KEY"
+
+// Format identifies the version of the badge document.
+const Format = "mtfs-integrity v1"
+
+// Badge is the attestation badge of a tree. The signature covers the
+// badge without it, as encoded by Statement.
+type Badge struct {
+	Format     string `json:"format"`
+	RootHash   string `json:"root_hash"`
+	Algorithm  string `json:"algorithm"`   // sha256, sha1, sha512, blake2b or blake3
+	HashFormat string `json:"hash_format"` // mtfs, git, git-sha256 or mtfs-ALGORITHM
+	Operation  string `json:"operation"`   // build or verify
+	Timestamp  string `json:"timestamp"`
+	Tool       string `json:"tool"`
+	Signature  string `json:"signature,omitempty"`
+}
+
+// Statement returns the bytes the signature of b is made over: b without
+// its signature, as compact JSON with the fields in the order above.
+func (b Badge) Statement() []byte {
+	b.Signature = ""
+	data, _ := json.Marshal(b)
+	return data
+}
+
+// Path returns where the badge of tree goes: in its root, or beside an
+// archive as <archive>.INTEGRITY.mtfs.json. In forensic mode, where
+// nothing is written inside a tree, it goes to the state directory kept
+// for the tree in the case directory.
+func Path(tree string) string {
+	if forensic.Dir() != "" {
+		return state.Path(tree, FileName)
+	}
+	if info, err := os.Stat(tree); err == nil && info.Mode().IsRegular() {
+		return tree + "." + FileName
+	}
+	return filepath.Join(tree, FileName)
+}
+
+// Algorithm returns the hash algorithm of a hash format.
+func Algorithm(hashFormat string) string {
+	if hashFormat == "git" {
+		return "sha1"
+	}
+	if algorithm, ok := strings.CutPrefix(hashFormat, "mtfs-"); ok {
+		return algorithm
+	}
+	return "sha256"
+}
+
+// Tool names the running MTFS with its module version and, when it was
+// built from a checkout, the commit.
+func Tool() string {
+	version := "devel"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v := info.Main.Version; v != "" && v != "(devel)" {
+			version = v
+		}
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				version += "+" + s.Value[:min(len(s.Value), 12)]
+			}
+		}
+	}
+	return "mtfs " + version
+}
+
+// Write signs a badge stating that root is the root hash of tree, as
+// established by op, and writes it. keyID selects the signing key;
+// "default" or "" uses gpg's default key.
+func Write(tree, root, op, keyID string) (string, error) {
+	if keyID == "default" {
+		keyID = ""
+	}
+	format := state.HashFormat(tree)
+	b := Badge{
+		Format:     Format,
+		RootHash:   root,
+		Algorithm:  Algorithm(format),
+		HashFormat: format,
+		Operation:  op,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		Tool:       Tool(),
+	}
+	sig, err := (&signing.GPG{KeyID: keyID}).Sign(b.Statement())
+	if err != nil {
+		return "", err
+	}
+	b.Signature = string(sig)
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	name := Path(tree)
+	if forensic.Dir() != "" {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return "", err
+		}
+	}
+	return name, os.WriteFile(name, append(data, '\n'), 0o644)
+}
+
+// Read reads the badge at name.
+func Read(name string) (*Badge, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var b Badge
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if b.Format != Format {
+		return nil, fmt.Errorf("%s: not an MTFS attestation badge (format %q)", name, b.Format)
+	}
+	return &b, nil
+}
+
+// Verify checks the signature of b against the keys of gpg.
+func (b *Badge) Verify(gpg *signing.GPG) (*signing.Verification, error) {
+	if b.Signature == "" {
+		return nil, errors.New("the badge is not signed")
+	}
+	return gpg.Verify(b.Statement(), []byte(b.Signature))
+}
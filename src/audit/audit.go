@@ -0,0 +1,206 @@
+// Package audit implements hashdeep's audit mode against an MTFS tree:
+// the files of the tree are compared with a known-files list written by
+// hashdeep (or md5deep -j/sha256deep in hashdeep format) and each is
+// classified as matched, moved, new or changed, while listed files that
+// are nowhere in the tree are reported missing. Checksum files written by
+// sha256sum serve as baselines too.
+package audit
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"MTFS/forensic"
+	"MTFS/manifest"
+)
+
+// Status classifies a file in an audit.
+type Status string
+
+const (
+	Matched Status = "matched" // same path and content as in the baseline
+	Moved   Status = "moved"   // content the baseline lists under another path
+	New     Status = "new"     // content and path the baseline does not list
+	Changed Status = "changed" // path the baseline lists with other content
+	Missing Status = "missing" // listed in the baseline but not in the tree
+)
+
+// Result is the audit of one file. Known is the baseline path the file
+// was matched with, if any.
+type Result struct {
+	Path   string
+	Status Status
+	Known  string
+}
+
+// Report is the outcome of an audit.
+type Report struct {
+	Examined int // files of the tree
+	Expected int // files of the baseline
+	Results  []Result
+}
+
+// Count returns the number of results with status s.
+func (r *Report) Count(s Status) int {
+	n := 0
+	for _, res := range r.Results {
+		if res.Status == s {
+			n++
+		}
+	}
+	return n
+}
+
+// Passed reports whether every file matched, as hashdeep requires for a
+// passed audit.
+func (r *Report) Passed() bool {
+	return r.Count(Matched) == r.Examined && r.Examined == r.Expected
+}
+
+// Summary returns hashdeep's audit summary with a count of changed files
+// added.
+func (r *Report) Summary() []string {
+	verdict := "Audit failed"
+	if r.Passed() {
+		verdict = "Audit passed"
+	}
+	return []string{
+		verdict,
+		fmt.Sprintf("   Input files examined: %d", r.Examined),
+		fmt.Sprintf("  Known files expecting: %d", r.Expected),
+		fmt.Sprintf("          Files matched: %d", r.Count(Matched)),
+		fmt.Sprintf("            Files moved: %d", r.Count(Moved)),
+		fmt.Sprintf("          Files changed: %d", r.Count(Changed)),
+		fmt.Sprintf("        New files found: %d", r.Count(New)),
+		fmt.Sprintf("  Known files not found: %d", r.Count(Missing)),
+	}
+}
+
+// Run audits the files of the tree described by root against b. Baseline
+// paths are taken relative to baseRoot if it is not empty, and otherwise
+// as described for resolve. Each file is hashed with the baseline's
+// algorithms and checked against its content hash while it is read.
+func Run(tree string, root *manifest.Node, b *Baseline, baseRoot string) (*Report, error) {
+	if err := b.resolve(tree, root.Name, baseRoot); err != nil {
+		return nil, err
+	}
+	byPath := map[string]*Known{}
+	byContent := map[string][]*Known{}
+	for i := range b.Files {
+		k := &b.Files[i]
+		byPath[k.Path] = k
+		key := b.key(k.Size, k.Hashes)
+		byContent[key] = append(byContent[key], k)
+	}
+
+	current := map[string]string{} // content key by path
+	var paths []string
+	err := root.Walk(func(p string, node *manifest.Node) error {
+		if !node.IsFile() {
+			return nil
+		}
+		hashes, err := b.hashFile(filepath.Join(tree, filepath.FromSlash(p)), node)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		current[p] = b.key(node.Size, hashes)
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Examined: len(paths), Expected: len(b.Files)}
+	used := map[*Known]bool{}
+	status := map[string]Result{}
+	for _, p := range paths {
+		if k := byPath[p]; k != nil && b.key(k.Size, k.Hashes) == current[p] {
+			status[p] = Result{Path: p, Status: Matched, Known: p}
+			used[k] = true
+		}
+	}
+	for _, p := range paths {
+		if _, done := status[p]; done {
+			continue
+		}
+		// Prefer a listed path whose content is gone from it
+		var from *Known
+		for _, k := range byContent[current[p]] {
+			if from == nil || (current[k.Path] != current[p] && !used[k]) {
+				from = k
+			}
+		}
+		switch k := byPath[p]; {
+		case from != nil:
+			status[p] = Result{Path: p, Status: Moved, Known: from.Path}
+			used[from] = true
+		case k != nil:
+			status[p] = Result{Path: p, Status: Changed, Known: p}
+			used[k] = true
+		default:
+			status[p] = Result{Path: p, Status: New}
+		}
+	}
+	for _, p := range paths {
+		report.Results = append(report.Results, status[p])
+	}
+
+	var missing []string
+	for i := range b.Files {
+		if k := &b.Files[i]; !used[k] {
+			missing = append(missing, k.Path)
+		}
+	}
+	sort.Strings(missing)
+	for _, p := range missing {
+		report.Results = append(report.Results, Result{Path: p, Status: Missing, Known: p})
+	}
+	return report, nil
+}
+
+// key identifies content by size, unless the baseline lists none, and the
+// baseline's hashes of it.
+func (b *Baseline) key(size int64, hashes map[string]string) string {
+	if b.NoSizes {
+		size = -1
+	}
+	parts := []string{fmt.Sprint(size)}
+	for _, alg := range b.Algorithms {
+		parts = append(parts, hashes[alg])
+	}
+	return strings.Join(parts, ",")
+}
+
+// hashFile hashes the file at name with the baseline's algorithms and
+// checks it against node.
+func (b *Baseline) hashFile(name string, node *manifest.Node) (map[string]string, error) {
+	f, err := forensic.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	check := node.NewContentCheck()
+	sums := map[string]hash.Hash{}
+	writers := []io.Writer{check}
+	for _, alg := range b.Algorithms {
+		sums[alg] = algorithms[alg]()
+		writers = append(writers, sums[alg])
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), f); err != nil {
+		return nil, err
+	}
+	if !check.Matches(node.ContentHash) {
+		return nil, fmt.Errorf("changed since the tree was built")
+	}
+	hashes := map[string]string{}
+	for alg, h := range sums {
+		hashes[alg] = fmt.Sprintf("%x", h.Sum(nil))
+	}
+	return hashes, nil
+}
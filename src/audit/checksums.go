@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// checksumAlgorithms tells the algorithm of a checksum file by the length
+// of its hashes, as sha256sum, sha1sum and md5sum write them.
+var checksumAlgorithms = map[int]string{32: "md5", 40: "sha1", 64: "sha256"}
+
+// bsdTags are the algorithm names of BSD-style checksum lines, as written
+// by sha256sum --tag or shasum --tag.
+var bsdTags = map[string]string{"MD5": "md5", "SHA1": "sha1", "SHA256": "sha256"}
+
+// ParseChecksums parses a checksum file as written by sha256sum (or
+// sha1sum or md5sum): "<hash>  <name>" lines, "<hash> *<name>" for files
+// hashed in binary mode, and the "SHA256 (<name>) = <hash>" lines of
+// --tag. Names with a backslash or newline are escaped as the coreutils
+// escape them. Checksum files list no sizes, so files are known by their
+// hash alone; all of them must be hashed with one algorithm.
+func ParseChecksums(r io.Reader) (*Baseline, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	b := &Baseline{NoSizes: true}
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		escaped := false
+		if rest, ok := strings.CutPrefix(line, `\`); ok {
+			line, escaped = rest, true
+		}
+
+		var alg, sum, name string
+		if tag, rest, ok := strings.Cut(line, " ("); ok && bsdTags[tag] != "" {
+			n, s, ok := cutLast(rest, ") = ")
+			if !ok {
+				return nil, fmt.Errorf("line %d: invalid %s line", lineNo, tag)
+			}
+			alg, sum, name = bsdTags[tag], s, n
+		} else {
+			s, n, ok := strings.Cut(line, " ")
+			if !ok || n == "" || (n[0] != ' ' && n[0] != '*') {
+				return nil, fmt.Errorf("line %d: not a checksum line", lineNo)
+			}
+			alg, sum, name = checksumAlgorithms[len(s)], s, n[1:]
+		}
+		sum = strings.ToLower(sum)
+		if alg == "" || strings.Trim(sum, "0123456789abcdef") != "" || len(sum) != 2*algorithms[alg]().Size() {
+			return nil, fmt.Errorf("line %d: invalid hash %q", lineNo, sum)
+		}
+		if escaped {
+			var err error
+			if name, err = unescapeChecksumName(name); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+		} else if strings.Contains(name, `\`) && !strings.Contains(name, "/") {
+			// Backslashes the coreutils would have escaped: written on Windows
+			name = strings.ReplaceAll(name, `\`, "/")
+		}
+		if name == "" {
+			return nil, fmt.Errorf("line %d: no file name", lineNo)
+		}
+
+		switch {
+		case b.Algorithms == nil:
+			b.Algorithms = []string{alg}
+		case b.Algorithms[0] != alg:
+			return nil, fmt.Errorf("line %d: %s hash in a file of %s hashes", lineNo, alg, b.Algorithms[0])
+		}
+		b.Files = append(b.Files, Known{Name: name, Size: -1, Hashes: map[string]string{alg: sum}})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(b.Files) == 0 {
+		return nil, errors.New("no checksum lines")
+	}
+	return b, nil
+}
+
+// cutLast slices s around the last instance of sep, as file names may
+// contain it.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// unescapeChecksumName undoes the escaping of a name on a checksum line
+// starting with a backslash.
+func unescapeChecksumName(name string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '\\' {
+			b.WriteByte(name[i])
+			continue
+		}
+		if i++; i == len(name) {
+			return "", errors.New("file name ends in a backslash")
+		}
+		switch name[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			return "", fmt.Errorf("unknown escape \\%c in file name", name[i])
+		}
+	}
+	return b.String(), nil
+}
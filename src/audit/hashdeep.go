@@ -0,0 +1,192 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// algorithms are the hashdeep hash columns that can be checked, with their
+// constructors. Tiger and Whirlpool columns are ignored.
+var algorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// Known is a file listed in a baseline.
+type Known struct {
+	Name   string            // as written in the baseline
+	Path   string            // slash-separated, relative to the audited tree
+	Size   int64             // -1 if the baseline lists no sizes
+	Hashes map[string]string // lower-case hex, by algorithm
+}
+
+// Baseline is a hashdeep known-files list, or a checksum file.
+type Baseline struct {
+	Algorithms  []string // the checkable hash columns, in file order
+	InvokedFrom string   // working directory hashdeep ran in, if recorded
+	NoSizes     bool     // files are known by their hashes alone
+	Files       []Known
+}
+
+// LoadBaseline reads the hashdeep file at name, or the checksum file as
+// ParseChecksums reads it if it has no hashdeep header.
+func LoadBaseline(name string) (*Baseline, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	parse := ParseChecksums
+	if head, _ := r.Peek(len("%%%% HASHDEEP-")); string(head) == "%%%% HASHDEEP-" {
+		parse = ParseBaseline
+	}
+	b, err := parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return b, nil
+}
+
+// ParseBaseline parses hashdeep output: a "%%%% HASHDEEP-1.0" header, a
+// "%%%% size,...,filename" column line, "##" comments and one line per
+// file. File names may contain commas, since they are the last column.
+func ParseBaseline(r io.Reader) (*Baseline, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	b := &Baseline{}
+	var columns []string
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case lineNo == 1:
+			if !strings.HasPrefix(line, "%%%% HASHDEEP-") {
+				return nil, errors.New("not a hashdeep file (no %%%% HASHDEEP header)")
+			}
+		case strings.HasPrefix(line, "%%%% "):
+			columns = strings.Split(strings.TrimPrefix(line, "%%%% "), ",")
+			if len(columns) < 2 || columns[0] != "size" || columns[len(columns)-1] != "filename" {
+				return nil, fmt.Errorf("line %d: unsupported columns %q", lineNo, line)
+			}
+			for _, c := range columns[1 : len(columns)-1] {
+				if _, ok := algorithms[c]; ok {
+					b.Algorithms = append(b.Algorithms, c)
+				}
+			}
+			if len(b.Algorithms) == 0 {
+				return nil, fmt.Errorf("line %d: none of the hashes %s can be checked", lineNo, strings.Join(columns[1:len(columns)-1], ", "))
+			}
+		case strings.HasPrefix(line, "## Invoked from: "):
+			b.InvokedFrom = strings.TrimPrefix(line, "## Invoked from: ")
+		case strings.HasPrefix(line, "#"), line == "":
+		case columns == nil:
+			return nil, fmt.Errorf("line %d: file listed before the column line", lineNo)
+		default:
+			fields := strings.SplitN(line, ",", len(columns))
+			if len(fields) != len(columns) {
+				return nil, fmt.Errorf("line %d: expected %d columns", lineNo, len(columns))
+			}
+			size, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil || size < 0 {
+				return nil, fmt.Errorf("line %d: invalid size %q", lineNo, fields[0])
+			}
+			k := Known{Name: fields[len(fields)-1], Size: size, Hashes: map[string]string{}}
+			for i, c := range columns[1 : len(columns)-1] {
+				if _, ok := algorithms[c]; ok {
+					k.Hashes[c] = strings.ToLower(fields[i+1])
+				}
+			}
+			b.Files = append(b.Files, k)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if columns == nil {
+		return nil, errors.New("no column line")
+	}
+	return b, nil
+}
+
+// resolve sets the Path of every known file relative to the audited tree.
+// Names under tree, once resolved against the recorded working directory,
+// are taken relative to it. Otherwise they are taken relative to root if
+// it is given, or else to the directory standing for the tree: the closest
+// directory containing them all that is named like the tree, failing that
+// the deepest one containing them all.
+func (b *Baseline) resolve(tree, treeName, root string) error {
+	absTree, err := filepath.Abs(tree)
+	if err != nil {
+		return err
+	}
+	if len(b.Files) == 0 {
+		return nil
+	}
+	names := make([]string, len(b.Files))
+	for i, k := range b.Files {
+		name := k.Name
+		if !b.NoSizes && strings.Contains(name, `\`) && !strings.Contains(name, "/") {
+			name = strings.ReplaceAll(name, `\`, "/") // Written on Windows
+		}
+		if !path.IsAbs(name) && b.InvokedFrom != "" {
+			name = path.Join(filepath.ToSlash(b.InvokedFrom), name)
+		}
+		names[i] = path.Clean(name)
+	}
+
+	under := func(name, dir string) (string, bool) {
+		if dir == "." && !path.IsAbs(name) {
+			return name, true
+		}
+		rel, ok := strings.CutPrefix(name, strings.TrimSuffix(dir, "/")+"/")
+		return rel, ok && rel != ""
+	}
+	base := filepath.ToSlash(root)
+	if base == "" {
+		base = filepath.ToSlash(absTree)
+		for _, name := range names {
+			if _, ok := under(name, base); !ok {
+				base = commonBase(names, treeName)
+				break
+			}
+		}
+	}
+	base = path.Clean(base)
+	for i, name := range names {
+		rel, ok := under(name, base)
+		if !ok {
+			return fmt.Errorf("baseline file %s is not below %s", b.Files[i].Name, base)
+		}
+		b.Files[i].Path = rel
+	}
+	return nil
+}
+
+// commonBase returns the closest directory containing every name that is
+// called treeName, or the deepest directory containing every name.
+func commonBase(names []string, treeName string) string {
+	dir := path.Dir(names[0])
+	for _, name := range names[1:] {
+		for dir != "." && dir != "/" && !strings.HasPrefix(name, dir+"/") {
+			dir = path.Dir(dir)
+		}
+	}
+	for d := dir; d != "." && d != "/"; d = path.Dir(d) {
+		if path.Base(d) == treeName {
+			return d
+		}
+	}
+	return dir
+}
@@ -0,0 +1,100 @@
+// Package backend is what the TUI builds and inspects trees with. A
+// Backend runs the operations of the menu on the tree it built last; the
+// engines speaking the protocol of package protocol are Backends through
+// Client, whether they run in process (the Go engine) or as a subprocess
+// (the C++ backend or another executable). Other implementations, such as
+// a daemon or a fake for tests, only need the interface.
+package backend
+
+import (
+	"io"
+	"os/exec"
+
+	"MTFS/pkg/merkle"
+	"MTFS/protocol"
+	"MTFS/remote"
+)
+
+// Progress receives the progress lines of an operation as it runs,
+// without their "Progress: " prefix, with the files the workers of a
+// build are hashing. It is called from another goroutine than the one
+// that started the operation.
+type Progress func(progress protocol.ProgressResult)
+
+// Backend builds a tree and answers for it. Its methods can be called
+// from several goroutines; operations on the tree fail until one was
+// built.
+type Backend interface {
+	// BuildTree builds the tree of dir, which the other operations then
+	// report on.
+	BuildTree(dir string, progress Progress) (*protocol.BuildResult, error)
+	// PreviewBuild reports what building dir would hash and skip, leaving
+	// the built tree as it is.
+	PreviewBuild(dir string, progress Progress) (*protocol.BuildResult, error)
+	// PrintTree lists the nodes of the tree, a line each.
+	PrintTree() (*protocol.Report, error)
+	// PrintFiles lists the distinct contents of the tree.
+	PrintFiles() (*protocol.Report, error)
+	// Stats summarizes the tree and its past builds and verifications.
+	Stats() (*protocol.Report, error)
+	// Verify recomputes the hashes of the tree.
+	Verify(progress Progress) (*protocol.VerifyResult, error)
+	// Export returns the tree as JSON.
+	Export() (*protocol.ExportResult, error)
+	// List lists the directory at path in the tree, slash-separated and
+	// "." for the root, without exporting the rest.
+	List(path string) (*protocol.ListResult, error)
+	// SetChunkSize sets the chunk size of the next builds.
+	SetChunkSize(bytes int64) (*protocol.ChunkSizeResult, error)
+	// Ready waits for the backend to start, returning why it could not.
+	Ready() error
+	// Close stops the backend. Operations still running fail.
+	Close() error
+}
+
+// Open starts the engine chosen by the environment, the Go engine or the
+// backend executable, with walkArgs on its command line. trace, if not
+// nil, receives the lines exchanged with it: "> " and a request, or "< "
+// and a response.
+func Open(walkArgs []string, trace func(line string)) (Backend, error) {
+	if remote.UseGoEngine() {
+		return Go(walkArgs, trace), nil
+	}
+	return Exec(remote.BackendPath(), walkArgs, trace)
+}
+
+// Go runs the Go engine in process, with walkArgs as its walk options.
+func Go(walkArgs []string, trace func(line string)) *Client {
+	return Pipe(func(in io.Reader, out io.Writer) {
+		merkle.Serve(append([]string{"mtfs"}, walkArgs...), in, out)
+	}, trace)
+}
+
+// Exec runs the executable at path with walkArgs, driving its numbered
+// menu through protocol.ServeMenu.
+func Exec(path string, walkArgs []string, trace func(line string)) (*Client, error) {
+	cmd := exec.Command(path, walkArgs...)
+	menuIn, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	// Build errors are reported on stderr, so both streams share one pipe
+	menuOut, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	c := Pipe(func(in io.Reader, out io.Writer) {
+		protocol.ServeMenu(in, out, menuIn, menuOut)
+		menuIn.Close()
+	}, trace)
+	c.stop = func() error {
+		cmd.Process.Kill()
+		return cmd.Wait()
+	}
+	return c, nil
+}
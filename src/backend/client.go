@@ -0,0 +1,261 @@
+package backend
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+
+	"MTFS/protocol"
+)
+
+// errStopped fails the operations of an engine that stopped answering.
+var errStopped = errors.New("the engine stopped")
+
+// Client is a Backend speaking the JSON-lines protocol with an engine.
+// Requests are sent as they are made and answered by ID, so an operation
+// does not wait for the one before it.
+type Client struct {
+	w     io.WriteCloser
+	r     io.ReadCloser
+	trace func(line string)
+	stop  func() error // stops the engine, nil if closing w does
+
+	ready    chan struct{} // closed once the engine said it is ready
+	readyErr error
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]*call
+	err     error // why requests fail, once the engine stopped
+}
+
+// call is a request waiting for its response.
+type call struct {
+	progress Progress
+	done     chan protocol.Response
+}
+
+// NewClient returns a Client sending requests on w and reading the
+// responses from r. trace is as for Open.
+func NewClient(w io.WriteCloser, r io.ReadCloser, trace func(line string)) *Client {
+	c := &Client{w: w, r: r, trace: trace, ready: make(chan struct{}), pending: make(map[int]*call)}
+	go c.read()
+	return c
+}
+
+// Pipe runs engine, which answers the requests read from in on out, in
+// process and returns a Client of it.
+func Pipe(engine func(in io.Reader, out io.Writer), trace func(line string)) *Client {
+	requests, input := io.Pipe()
+	responses, output := io.Pipe()
+	go func() {
+		engine(requests, output)
+		requests.Close()
+		output.Close()
+	}()
+	return NewClient(input, responses, trace)
+}
+
+// read dispatches the responses of the engine until it stops.
+func (c *Client) read() {
+	var once sync.Once
+	setReady := func(err error) {
+		once.Do(func() {
+			c.readyErr = err
+			close(c.ready)
+		})
+	}
+
+	reader := bufio.NewReader(c.r)
+	for {
+		data, err := reader.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		if c.trace != nil {
+			c.trace("< " + string(data[:len(data)-1]))
+		}
+		var resp protocol.Response
+		if err := json.Unmarshal(data, &resp); err != nil {
+			continue
+		}
+
+		switch {
+		case resp.Command == protocol.Ready:
+			setReady(resp.Decode(&protocol.ReadyResult{}))
+		case resp.Command == protocol.Progress:
+			c.mu.Lock()
+			pending := c.pending[resp.ID]
+			c.mu.Unlock()
+			var progress protocol.ProgressResult
+			if pending != nil && pending.progress != nil && resp.Decode(&progress) == nil {
+				pending.progress(progress)
+			}
+		default:
+			c.mu.Lock()
+			pending := c.pending[resp.ID]
+			delete(c.pending, resp.ID)
+			c.mu.Unlock()
+			if pending != nil {
+				pending.done <- resp
+			}
+		}
+	}
+
+	setReady(errStopped)
+	c.mu.Lock()
+	c.err = errStopped
+	for id, pending := range c.pending {
+		delete(c.pending, id)
+		pending.done <- protocol.Response{ID: id, Error: errStopped.Error()}
+	}
+	c.mu.Unlock()
+}
+
+// do sends command with args and decodes its result into result, which
+// is nil for commands without one.
+func (c *Client) do(result any, progress Progress, command string, args ...string) error {
+	if err := c.Ready(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	req := protocol.Request{ID: c.nextID, Command: command, Args: args}
+	pending := &call{progress: progress, done: make(chan protocol.Response, 1)}
+	c.pending[req.ID] = pending
+	data, err := json.Marshal(req)
+	if err == nil {
+		if c.trace != nil {
+			c.trace("> " + string(data))
+		}
+		_, err = c.w.Write(append(data, '\n'))
+	}
+	if err != nil {
+		delete(c.pending, req.ID)
+		c.mu.Unlock()
+		return err
+	}
+	c.mu.Unlock()
+
+	resp := <-pending.done
+	if result == nil {
+		return resp.Decode(&json.RawMessage{})
+	}
+	return resp.Decode(result)
+}
+
+// BuildTree implements Backend.
+func (c *Client) BuildTree(dir string, progress Progress) (*protocol.BuildResult, error) {
+	var res protocol.BuildResult
+	if err := c.do(&res, progress, protocol.Build, dir); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// PreviewBuild implements Backend.
+func (c *Client) PreviewBuild(dir string, progress Progress) (*protocol.BuildResult, error) {
+	var res protocol.BuildResult
+	if err := c.do(&res, progress, protocol.Preview, dir); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// PrintTree implements Backend.
+func (c *Client) PrintTree() (*protocol.Report, error) {
+	return c.report(protocol.Tree)
+}
+
+// PrintFiles implements Backend.
+func (c *Client) PrintFiles() (*protocol.Report, error) {
+	return c.report(protocol.Files)
+}
+
+// Stats implements Backend.
+func (c *Client) Stats() (*protocol.Report, error) {
+	return c.report(protocol.Stats)
+}
+
+// report runs a command answered with a Report.
+func (c *Client) report(command string) (*protocol.Report, error) {
+	var res protocol.Report
+	if err := c.do(&res, nil, command); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Verify implements Backend.
+func (c *Client) Verify(progress Progress) (*protocol.VerifyResult, error) {
+	var res protocol.VerifyResult
+	if err := c.do(&res, progress, protocol.Verify); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Export implements Backend.
+func (c *Client) Export() (*protocol.ExportResult, error) {
+	var res protocol.ExportResult
+	if err := c.do(&res, nil, protocol.Export); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// List implements Backend.
+func (c *Client) List(path string) (*protocol.ListResult, error) {
+	var res protocol.ListResult
+	if err := c.do(&res, nil, protocol.List, path); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// SetChunkSize implements Backend.
+func (c *Client) SetChunkSize(bytes int64) (*protocol.ChunkSizeResult, error) {
+	var res protocol.ChunkSizeResult
+	if err := c.do(&res, nil, protocol.ChunkSize, strconv.FormatInt(bytes, 10)); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Ready implements Backend.
+func (c *Client) Ready() error {
+	<-c.ready
+	return c.readyErr
+}
+
+// Close asks the engine to exit and stops it. Operations still running
+// fail.
+func (c *Client) Close() error {
+	select {
+	case <-c.ready:
+		if c.readyErr == nil {
+			// An engine busy with a request is stopped without waiting
+			c.mu.Lock()
+			idle := len(c.pending) == 0
+			c.mu.Unlock()
+			if idle {
+				c.do(nil, nil, protocol.Exit)
+			}
+		}
+	default:
+	}
+	c.w.Close()
+	c.r.Close()
+	if c.stop != nil {
+		return c.stop()
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Package bundle wraps exports in a password-protected container so tree
+// structure and file names are not leaked when reports are shared. The
+// container is AES-256-GCM with a key derived from the password by
+// PBKDF2-SHA256; the header is authenticated along with the contents.
+package bundle
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// PassphraseEnv names the environment variable headless commands read
+// the bundle password from.
+const PassphraseEnv = "MTFS_BUNDLE_PASSPHRASE"
+
+const (
+	magic      = "MTFS-BUNDLE\x00"
+	version    = 1
+	saltSize   = 16
+	rounds     = 600000
+	maxRounds  = 10 * rounds
+	headerSize = len(magic) + 1 + saltSize + 4 + 12
+)
+
+// ErrWrongPassword is returned when a bundle cannot be opened, either
+// because the password is wrong or the bundle was modified.
+var ErrWrongPassword = errors.New("bundle: wrong password or damaged bundle")
+
+// Bundle is a set of named files, such as an export and its signatures.
+type Bundle struct {
+	Files map[string][]byte `json:"files"`
+}
+
+// Names returns the file names in the bundle in sorted order.
+func (b *Bundle) Names() []string {
+	names := make([]string, 0, len(b.Files))
+	for name := range b.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Seal encrypts b with password.
+func Seal(b *Bundle, password string) ([]byte, error) {
+	if password == "" {
+		return nil, errors.New("bundle: the password cannot be empty")
+	}
+	plaintext, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, version)
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, rounds)
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+
+	aead, err := newAEAD(password, salt, rounds)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, nonce, plaintext, header), nil
+}
+
+// Open decrypts a bundle produced by Seal.
+func Open(data []byte, password string) (*Bundle, error) {
+	if len(data) < headerSize || !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, errors.New("bundle: not an MTFS bundle")
+	}
+	header := data[:headerSize]
+	if v := header[len(magic)]; v != version {
+		return nil, fmt.Errorf("bundle: unsupported version %d", v)
+	}
+	salt := header[len(magic)+1 : len(magic)+1+saltSize]
+	iterations := binary.BigEndian.Uint32(header[len(magic)+1+saltSize:])
+	nonce := header[headerSize-12:]
+	if iterations == 0 || iterations > maxRounds {
+		return nil, fmt.Errorf("bundle: implausible key derivation cost %d", iterations)
+	}
+
+	aead, err := newAEAD(password, salt, int(iterations))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, data[headerSize:], header)
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+
+	var b Bundle
+	if err := json.Unmarshal(plaintext, &b); err != nil {
+		return nil, fmt.Errorf("bundle: %w", err)
+	}
+	return &b, nil
+}
+
+func newAEAD(password string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
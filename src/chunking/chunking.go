@@ -0,0 +1,238 @@
+// Package chunking cuts file contents into the chunks the store keeps and
+// the duplicate finders compare. Fixed-size chunks are cut every Size
+// bytes, so a byte inserted near the start of a file moves every cut after
+// it and no chunk of the edited file matches the old one. Content-defined
+// chunks are cut with FastCDC where a rolling hash of the last bytes read
+// says so, between Min and Max bytes apart and Avg bytes on average; the
+// cuts move with the content, so only the chunks around an edit change.
+package chunking
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"MTFS/quota"
+)
+
+// MaxSize is the largest chunk a Params can ask for.
+const MaxSize = 100 * 1024 * 1024
+
+// MinCDCSize is the smallest content-defined chunk.
+const MinCDCSize = 64
+
+// Params says where content is cut. With CDC unset every chunk is Size
+// bytes long but the last.
+type Params struct {
+	Size          int
+	CDC           bool
+	Min, Avg, Max int
+}
+
+// Fixed returns the Params of chunks of size bytes.
+func Fixed(size int) Params {
+	return Params{Size: size}
+}
+
+// FastCDC returns the Params of content-defined chunks between min and max
+// bytes long, avg on average.
+func FastCDC(min, avg, max int) (Params, error) {
+	p := Params{CDC: true, Min: min, Avg: avg, Max: max}
+	return p, p.Check()
+}
+
+// DefaultCDC returns the Params of content-defined chunks of avg bytes on
+// average, from a quarter of that to four times as long.
+func DefaultCDC(avg int) (Params, error) {
+	return FastCDC(avg/4, avg, avg*4)
+}
+
+// Check reports whether p can cut content.
+func (p Params) Check() error {
+	if !p.CDC {
+		if p.Size <= 0 || p.Size > MaxSize {
+			return fmt.Errorf("invalid chunk size %d: must be between 1 and %d bytes", p.Size, MaxSize)
+		}
+		return nil
+	}
+	if p.Min < MinCDCSize || p.Min > p.Avg || p.Avg > p.Max || p.Max > MaxSize {
+		return fmt.Errorf("invalid content-defined chunk sizes %d/%d/%d: need %d <= min <= avg <= max <= %d bytes", p.Min, p.Avg, p.Max, MinCDCSize, MaxSize)
+	}
+	return nil
+}
+
+// Largest returns the size of the longest chunk p cuts.
+func (p Params) Largest() int {
+	if p.CDC {
+		return p.Max
+	}
+	return p.Size
+}
+
+// Whole returns the size up to which content is always a single chunk.
+func (p Params) Whole() int {
+	if p.CDC {
+		return p.Min
+	}
+	return p.Size
+}
+
+// String returns p as Parse reads it: the chunk size in bytes, or
+// fastcdc:MIN/AVG/MAX.
+func (p Params) String() string {
+	if !p.CDC {
+		return strconv.Itoa(p.Size)
+	}
+	return fmt.Sprintf("fastcdc:%d/%d/%d", p.Min, p.Avg, p.Max)
+}
+
+// Parse reads chunking parameters: a chunk size such as 1048576 or 64K
+// for fixed-size chunks, or fastcdc:MIN/AVG/MAX for content-defined ones,
+// such as fastcdc:16K/64K/256K. fastcdc:AVG takes a quarter and four
+// times AVG as MIN and MAX, and fastcdc alone an average of 64 KiB.
+func Parse(text string) (Params, error) {
+	text = strings.TrimSpace(text)
+	spec, found := strings.CutPrefix(strings.ToLower(text), "fastcdc")
+	if !found {
+		size, err := quota.ParseSize(text)
+		if err != nil || size > MaxSize {
+			return Params{}, fmt.Errorf("invalid chunk size %q", text)
+		}
+		p := Fixed(int(size))
+		return p, p.Check()
+	}
+	if spec == "" {
+		return DefaultCDC(64 * 1024)
+	}
+	spec, ok := strings.CutPrefix(spec, ":")
+	if !ok {
+		return Params{}, fmt.Errorf("invalid chunking %q: use fastcdc:MIN/AVG/MAX", text)
+	}
+	var sizes []int
+	for _, field := range strings.Split(spec, "/") {
+		size, err := quota.ParseSize(strings.TrimSpace(field))
+		if err != nil || size > MaxSize {
+			return Params{}, fmt.Errorf("invalid chunking %q: %q is not a size", text, field)
+		}
+		sizes = append(sizes, int(size))
+	}
+	switch len(sizes) {
+	case 1:
+		return DefaultCDC(sizes[0])
+	case 3:
+		return FastCDC(sizes[0], sizes[1], sizes[2])
+	}
+	return Params{}, fmt.Errorf("invalid chunking %q: use fastcdc:MIN/AVG/MAX", text)
+}
+
+// Splitter cuts contents with its Params, reusing one buffer. It is not
+// safe for use by several goroutines.
+type Splitter struct {
+	p            Params
+	buf          []byte
+	small, large uint64 // cut masks before and after Avg bytes
+}
+
+// NewSplitter returns a Splitter cutting with p, which must pass Check.
+func NewSplitter(p Params) *Splitter {
+	s := &Splitter{p: p, buf: make([]byte, p.Largest())}
+	if p.CDC {
+		// Cuts are harder to find before the average size and easier
+		// after it, which keeps most chunks near it (normalized chunking)
+		b := bits.Len(uint(p.Avg)) - 1
+		s.small, s.large = topBits(b+1), topBits(max(b-1, 1))
+	}
+	return s
+}
+
+// topBits returns a mask of the n high bits of a fingerprint, which
+// depend on more of the bytes rolled in than the low ones.
+func topBits(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
+
+// Split reads r to the end and calls chunk with each chunk in order. The
+// slice is only valid until chunk returns. An error of chunk stops Split
+// and is returned.
+func (s *Splitter) Split(r io.Reader, chunk func([]byte) error) error {
+	filled := 0
+	for {
+		n, err := io.ReadFull(r, s.buf[filled:])
+		filled += n
+		eof := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !eof {
+			return err
+		}
+		if filled == 0 {
+			return nil
+		}
+		// The buffer is full unless the content ended, so a chunk of
+		// the largest size can always be cut from it
+		cut := filled
+		if s.p.CDC {
+			cut = s.cut(s.buf[:filled])
+		}
+		if err := chunk(s.buf[:cut]); err != nil {
+			return err
+		}
+		filled = copy(s.buf, s.buf[cut:filled])
+		if eof && filled == 0 {
+			return nil
+		}
+		if eof {
+			// What is left is cut without reading again
+			r = eofReader{}
+		}
+	}
+}
+
+// eofReader is an exhausted reader.
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
+
+// cut returns the length of the first chunk of data, which holds Max
+// bytes unless the content ends sooner.
+func (s *Splitter) cut(data []byte) int {
+	p := s.p
+	n := len(data)
+	if n <= p.Min {
+		return n
+	}
+	n = min(n, p.Max)
+	normal := min(n, p.Avg)
+	var fp uint64
+	i := p.Min
+	for ; i < normal; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&s.small == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = fp<<1 + gear[data[i]]
+		if fp&s.large == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// gear maps every byte to a random value rolled into the fingerprint. It
+// is derived from a fixed seed, since changing it moves every cut and
+// with it the hash of every content-defined chunk stored.
+var gear = func() (table [256]uint64) {
+	// SplitMix64
+	x := uint64(0x4d544653) // "MTFS"
+	for i := range table {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		table[i] = z ^ z>>31
+	}
+	return table
+}()
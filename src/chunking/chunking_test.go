@@ -0,0 +1,178 @@
+package chunking
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"slices"
+	"testing"
+	"testing/iotest"
+)
+
+// content returns n bytes of xorshift noise, the same on every run.
+func content(n int) []byte {
+	out := make([]byte, n)
+	x := uint64(1)
+	for i := range out {
+		x ^= x << 13
+		x ^= x >> 7
+		x ^= x << 17
+		out[i] = byte(x >> 56)
+	}
+	return out
+}
+
+// split returns the chunks p cuts data into.
+func split(t *testing.T, p Params, data []byte) [][]byte {
+	t.Helper()
+	return splitFrom(t, p, bytes.NewReader(data))
+}
+
+func splitFrom(t *testing.T, p Params, r io.Reader) [][]byte {
+	t.Helper()
+	var chunks [][]byte
+	err := NewSplitter(p).Split(r, func(c []byte) error {
+		chunks = append(chunks, bytes.Clone(c))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chunks
+}
+
+func lengths(chunks [][]byte) []int {
+	var l []int
+	for _, c := range chunks {
+		l = append(l, len(c))
+	}
+	return l
+}
+
+// The cuts are part of what is stored: changing them changes the hash of
+// every content-defined chunk.
+func TestFastCDCVectors(t *testing.T) {
+	p, err := FastCDC(256, 1024, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want []int
+	}{
+		{"noise", content(32 * 1024), []int{1312, 3039, 1667, 1618, 1902, 1512, 1255, 1217, 1539, 1112, 1125, 271,
+			1489, 2340, 1200, 1015, 901, 1437, 1206, 1778, 717, 800, 1915, 401}},
+		// The fingerprint of zeros never matches, so they are cut at Max
+		{"zeros", make([]byte, 10000), []int{4096, 4096, 1808}},
+		{"min", content(256), []int{256}},
+		{"empty", nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := lengths(split(t, p, test.data)); !slices.Equal(got, test.want) {
+				t.Errorf("got chunks of %v bytes, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFastCDCBounds(t *testing.T) {
+	p, err := FastCDC(256, 1024, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := content(200 * 1024)
+	chunks := split(t, p, data)
+	for i, c := range chunks {
+		if len(c) > p.Max || len(c) < p.Min && i < len(chunks)-1 {
+			t.Errorf("chunk %d of %d bytes", i, len(c))
+		}
+	}
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Error("the chunks are not the content")
+	}
+	// However the content is read, it is cut in the same places
+	for name, wrap := range map[string]func(io.Reader) io.Reader{
+		"one byte":  iotest.OneByteReader,
+		"half":      iotest.HalfReader,
+		"eof early": iotest.DataErrReader,
+	} {
+		if got := lengths(splitFrom(t, p, wrap(bytes.NewReader(data)))); !slices.Equal(got, lengths(chunks)) {
+			t.Errorf("%s: got other cuts", name)
+		}
+	}
+}
+
+// A byte inserted into the content only changes the chunks around it.
+func TestFastCDCEditKeepsChunks(t *testing.T) {
+	p, err := DefaultCDC(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := content(64 * 1024)
+	edited := slices.Insert(slices.Clone(data), 20000, 'x')
+	stored := map[[32]byte]bool{}
+	for _, c := range split(t, p, data) {
+		stored[sha256.Sum256(c)] = true
+	}
+	chunks := split(t, p, edited)
+	changed := 0
+	for _, c := range chunks {
+		if !stored[sha256.Sum256(c)] {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 2 {
+		t.Errorf("%d of %d chunks changed, want 1 or 2", changed, len(chunks))
+	}
+
+	// Fixed-size chunks after the byte all change
+	fixed := Fixed(1024)
+	stored = map[[32]byte]bool{}
+	for _, c := range split(t, fixed, data) {
+		stored[sha256.Sum256(c)] = true
+	}
+	kept := 0
+	for _, c := range split(t, fixed, edited) {
+		if stored[sha256.Sum256(c)] {
+			kept++
+		}
+	}
+	if kept != 20000/1024 {
+		t.Errorf("%d fixed-size chunks kept, want %d", kept, 20000/1024)
+	}
+}
+
+func TestFixed(t *testing.T) {
+	if got := lengths(split(t, Fixed(4), []byte("0123456789"))); !slices.Equal(got, []int{4, 4, 2}) {
+		t.Errorf("got chunks of %v bytes", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text string
+		want Params
+	}{
+		{"4096", Fixed(4096)},
+		{"64K", Fixed(64 * 1024)},
+		{"fastcdc", Params{CDC: true, Min: 16 * 1024, Avg: 64 * 1024, Max: 256 * 1024}},
+		{"fastcdc:8K", Params{CDC: true, Min: 2 * 1024, Avg: 8 * 1024, Max: 32 * 1024}},
+		{"FastCDC:1K/4K/16K", Params{CDC: true, Min: 1024, Avg: 4096, Max: 16384}},
+	}
+	for _, test := range tests {
+		got, err := Parse(test.text)
+		if err != nil || got != test.want {
+			t.Errorf("Parse(%q) = %+v, %v, want %+v", test.text, got, err, test.want)
+		}
+		if again, err := Parse(got.String()); err != nil || again != got {
+			t.Errorf("Parse(%q) = %+v, %v, want %+v", got.String(), again, err, got)
+		}
+	}
+	for _, text := range []string{"0", "200M", "fastcdc:32/64/128", "fastcdc:4K/2K/8K", "fastcdc:1K/4K", "fastcdc4K", "lots"} {
+		if p, err := Parse(text); err == nil {
+			t.Errorf("Parse(%q) = %+v, want an error", text, p)
+		}
+	}
+}
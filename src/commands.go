@@ -0,0 +1,895 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"MTFS/audit"
+	"MTFS/chunking"
+	"MTFS/duplicates"
+	"MTFS/forensic"
+	"MTFS/manifest"
+	"MTFS/monitor"
+	"MTFS/oci"
+	"MTFS/oplog"
+	"MTFS/pkg/merkle"
+	"MTFS/registry"
+	"MTFS/remote"
+	"MTFS/script"
+	"MTFS/store"
+	"MTFS/watch"
+)
+
+// commands are the headless subcommands run as `mtfs_tui <command>`
+// instead of starting the interactive UI.
+var commands = map[string]struct {
+	run   func(args []string) int
+	usage string
+}{
+	"audit":              {auditTree, "[-root DIR] [-ignore-new] [-v] BASELINE [DIR]  compare a tree with a hashdeep known-files list or a sha256sum checksum file"},
+	"build":              {buildTree, "[walk options] [DIR]  build a tree and print its root hash, size and what was skipped"},
+	"chunk-map":          {chunkMap, "[-o FILE] [-shared] [-chunk-size BYTES|fastcdc:MIN/AVG/MAX] [-store STORE -key FILE -snapshot ROOT] [DIR]  write which files and offsets every chunk is found at, as JSON"},
+	"compare":            {compareTrees, "DIR DIR  list what was added, removed or modified in the second tree against the first"},
+	"daemon":             {daemon, "[-interval D] [-syslog ADDR] [-journald] [DIR]  rescan a tree and report integrity events to syslog or the journal"},
+	"diff":               {diffSnapshots, "[-tree DIR] OLD NEW  list what was added, removed or modified between two named snapshots of a tree or JSON exports"},
+	"duplicates":         {findDuplicates, "[-sort wasted|count|size|path] [DIR]  list groups of identical files and the space their copies waste"},
+	"export-state":       {exportState, "[-o FILE] [-with-store] [DIR]  bundle a tree's state, history and optionally its store to move it to another machine"},
+	"export":             {exportTree, "[walk options] [-o FILE] [-format json|cbor|protobuf|sha256sum|sha512sum|b2sum|b3sum] [DIR]  build a tree and write it as JSON, CBOR or protobuf, or its file hashes as the checksum tool of its hash format does, to standard output by default"},
+	"extract":            {extractFile, "[-key FILE] [-o FILE] [-force] STORE SNAPSHOT PATH  restore one file of a stored snapshot, checking every chunk"},
+	"forget":             {forgetSnapshots, "[-key FILE] STORE SNAPSHOT...  delete snapshots from a store; gc then frees the chunks only they referred to"},
+	"gc":                 {collectGarbage, "[-key FILE] [-dry-run] [-grace D] STORE  delete the chunks no snapshot of a store refers to and report the space reclaimed"},
+	"import-state":       {importState, "[-store DIR] [-name NAME] [-force] FILE [DIR]  restore a state bundle next to a copy of its tree"},
+	"keygen":             {generateKey, "[-o PREFIX] [-no-passphrase] [-force]  create an ed25519 key pair to sign exports with, as minisign key files"},
+	"mount":              {mountSnapshot, "[-key FILE] STORE SNAPSHOT MOUNTPOINT  mount a stored snapshot read-only with FUSE, checking every chunk read against its hash"},
+	"open-bundle":        {openBundle, "[-o DIR] FILE  decrypt a password-protected export bundle"},
+	"preview":            {previewBuild, "[walk options] [DIR]  list what a build would hash and skip, and why, without hashing anything"},
+	"prove-file":         {proveFile, "[-o FILE] PATH [DIR]  build a tree and prove one of its entries is part of it, with the sibling hashes up to the root"},
+	"prove-inclusion":    {proveInclusion, "[-super FILE] [-o FILE] NAME  prove a tree is covered by a super-root"},
+	"repair":             {repairStore, "[-key FILE] [-from STORE]... [-tree DIR]... STORE  write corrupt or missing chunks again from other stores or the stored trees"},
+	"restore":            {restoreSnapshot, "[-key FILE] [-force] [-path PREFIX] STORE SNAPSHOT DEST  restore the files of a stored snapshot below DEST, checking every chunk"},
+	"script":             {runScript, "FILE [ARG]...  run a Starlark automation script with the mtfs module (build, diff, export, snapshot, proofs)"},
+	"scrub":              {scrubStore, "[-key FILE] STORE  re-hash every stored chunk and list the snapshots and files corrupt or missing chunks affect"},
+	"serve":              {serve, "[-addr ADDR] [-token T] [-cert FILE -key FILE] [DIR]  serve a tree to sync clients"},
+	"sign-manifest":      {signManifest, "[-key FILE] [-o FILE] [-comment TEXT] EXPORT  sign a JSON export with an ed25519 key, writing a minisign signature that covers its root hash"},
+	"similar":            {findSimilar, "[-min PERCENT] [-chunk-size BYTES|fastcdc:MIN/AVG/MAX] [DIR]  list pairs of files sharing most of their chunks, such as edited copies"},
+	"snapshot":           {namedSnapshots, "save [walk options] NAME [DIR] | list [DIR] | show [-files] NAME [DIR] | delete NAME [DIR]  keep named snapshots of a tree's root hash and file list"},
+	"snapshots":          {listSnapshots, "[-key FILE] STORE  list the snapshots of a store and the new chunks each one stored"},
+	"stats":              {treeStats, "[walk options] [-json] [-store STORE -key FILE | -chunk-size BYTES|fastcdc:MIN/AVG/MAX] [-top N] [DIR]  build a tree and print its statistics, the trends of its past builds and how much deduplicating its chunks saves"},
+	"store":              {storeTree, "[walk options] [-key FILE] [-chunk-size BYTES|fastcdc:MIN/AVG/MAX] [-cipher aes-256-gcm|xchacha20-poly1305] [-compress zstd|none] STORE [DIR]  build a tree and store its chunks and a snapshot of it, creating the store if needed"},
+	"super-root":         {superRoot, "[-o FILE] [-build] [NAME...]  aggregate the root hashes of registered trees into one super-root"},
+	"sync":               {syncTree, "[-token T] URL [DIR]  make DIR a verified copy of a served tree"},
+	"trees":              {trees, "list | add [-name NAME] DIR | remove NAME | config NAME [KEY=VALUE]...  manage known trees and their settings"},
+	"verify":             {verifyTree, "[walk options] [-root HASH] [DIR]  build a tree, verify it and compare its root hash with HASH"},
+	"verify-attestation": {verifyAttestation, "[-keyring FILE] [-no-build] [DIR]  check the signed attestation badge of a tree against the tree"},
+	"verify-file-proof":  {verifyFileProof, "[-root HASH] [-file FILE] PROOF  check a proof of prove-file against a known root hash, and optionally the file it is about"},
+	"verify-export":      {verifyExport, "[walk options] EXPORT [DIR]  hash a directory again and list the paths that no longer match an earlier JSON, CBOR or protobuf export"},
+	"verify-image":       {verifyImage, "[-platform OS/ARCH] [-store STORE -key FILE -snapshot ROOT] IMAGE  check an OCI layout or docker save tarball against its digests and a snapshot"},
+	"verify-inclusion":   {verifyInclusion, "[-tree DIR] PROOF  check a proof that a tree is covered by a super-root"},
+	"verify-log":         {verifyLog, "[DIR]  confirm the published root hashes of a tree are in their transparency log"},
+	"verify-manifest":    {verifyManifest, "[-pub FILE] [-sig FILE] [-root HASH] EXPORT  check the minisign signature of a JSON export and the root hash it signs"},
+	"verify-oplog":       {verifyOplog, "[-head HASH] [FILE]  check the hash chain of the operation log"},
+	"verify-signature":   {verifySignature, "[-keyring FILE] [DIR]  check the signed root hash of a tree"},
+	"verify-snapshot":    {verifySnapshot, "[-key FILE] [-all] STORE SNAPSHOT [DIR]  compare a tree with a stored snapshot chunk by chunk, listing the byte ranges of the files that changed"},
+	"verify-store":       {scrubStore, "[-key FILE] STORE  the same as scrub"},
+	"verify-timestamp":   {verifyTimestamp, "[-ca FILE] [DIR]  check the trusted timestamps of a tree's root hashes against the TSA roots in FILE"},
+	"watch":              {watchTree, "[walk options] [-delay D] [DIR]  build a tree, then hash its entries again as they change and print each new root hash"},
+}
+
+// secretFlags are the flags whose values are left out of the operation
+// log.
+var secretFlags = map[string]bool{"token": true}
+
+// operation is the operation log entry of the running command, which
+// commands complete with the tree and root hash they worked on.
+var operation oplog.Entry
+
+func runCommand(name string, args []string) int {
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printUsage()
+		return 2
+	}
+	operation = oplog.Entry{Operation: name, Params: map[string]string{"args": strings.Join(redact(args), " ")}}
+	code := cmd.run(args)
+	operation.Result = oplog.OK
+	if code != 0 {
+		operation.Result = oplog.Failed
+		operation.Error = fmt.Sprintf("exit status %d", code)
+	}
+	if _, err := oplog.Record(operation); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: operation not recorded in the operation log: %v\n", err)
+	}
+	return code
+}
+
+// redact replaces the values of secretFlags in args.
+func redact(args []string) []string {
+	out := append([]string(nil), args...)
+	for i := 0; i < len(out); i++ {
+		name, _, inline := strings.Cut(strings.TrimLeft(out[i], "-"), "=")
+		if !strings.HasPrefix(out[i], "-") || !secretFlags[name] {
+			continue
+		}
+		if inline {
+			out[i] = out[i][:strings.Index(out[i], "=")+1] + "REDACTED"
+		} else if i+1 < len(out) {
+			out[i+1] = "REDACTED"
+			i++
+		}
+	}
+	return out
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: mtfs_tui [command] [arguments]")
+	fmt.Fprintln(os.Stderr, "       mtfs_tui [--include PATTERN]... [--exclude PATTERN]... [--symlinks POLICY] [--hidden include|skip]\n                [--apple-metadata skip|include] [--max-depth N] [--min-file-size SIZE]\n                [--max-file-size SIZE] [-x | --one-file-system]\n                [--xattrs] [--metadata FIELDS] [--special-files skip|record|error] [--normalize none|nfc|nfd]\n                [--case-insensitive] [--hash-format FORMAT]")
+	fmt.Fprintln(os.Stderr, "\nWithout a command the interactive UI is started, or the text menu of the\nbackend when standard output is not a terminal.\n\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nA DIR or STORE may also be the name of a tree in the registry (see trees).")
+}
+
+// treeArg returns the tree given as argument i, "." if there is none.
+// The name of a registered tree stands for its directory.
+func treeArg(flags *flag.FlagSet, i int) string {
+	if flags.NArg() <= i {
+		return "."
+	}
+	return registry.Resolve(flags.Arg(i))
+}
+
+func runScript(args []string) int {
+	flags := flag.NewFlagSet("script", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: give the script to run")
+		return 2
+	}
+	operation.Params["script"] = flags.Arg(0)
+	if err := script.Run(flags.Arg(0), flags.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func auditTree(args []string) int {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	root := flags.String("root", "", "directory the baseline's file names are relative to (default: inferred)")
+	ignoreNew := flags.Bool("ignore-new", false, "pass with files the baseline does not list, as sha256sum -c does")
+	verbose := flags.Bool("v", false, "also list matched files")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no baseline given")
+		return 2
+	}
+	tree := treeArg(flags, 1)
+
+	baseline, err := audit.LoadBaseline(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	remote.RehashAll()
+	node, err := remote.Build(tree)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Tree, operation.RootHash = tree, node.Hash
+	report, err := audit.Run(tree, node, baseline, *root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	for _, res := range report.Results {
+		switch {
+		case res.Status == audit.Moved:
+			fmt.Printf("%s: moved from %s\n", res.Path, res.Known)
+		case res.Status == audit.New && *ignoreNew:
+		case res.Status != audit.Matched || *verbose:
+			fmt.Printf("%s: %s\n", res.Path, res.Status)
+		}
+	}
+	summary := report.Summary()
+	// Only new files left: the listed ones all matched
+	passed := report.Passed() || *ignoreNew && report.Count(audit.Matched) == report.Expected
+	if passed && !report.Passed() {
+		summary[0] = "Audit passed"
+	}
+	for _, line := range summary {
+		fmt.Println(line)
+	}
+	if !passed {
+		return 1
+	}
+	return 0
+}
+
+func verifyImage(args []string) int {
+	flags := flag.NewFlagSet("verify-image", flag.ExitOnError)
+	platform := flags.String("platform", "", "image of a multi-platform layout to check, as os/arch")
+	dir := flags.String("store", os.Getenv(store.DirEnv), "store holding the snapshot (default $"+store.DirEnv+")")
+	keyFile := addKeyFlag(flags)
+	root := flags.String("snapshot", "", "root hash of the snapshot to compare the image's files with")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no image layout or tarball given")
+		return 2
+	}
+
+	layout, err := oci.Open(flags.Arg(0))
+	if err == nil && *platform != "" {
+		err = layout.Select(*platform)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	var snap *store.Snapshot
+	if *root != "" {
+		if *dir == "" {
+			fmt.Fprintln(os.Stderr, "Error: no store directory or bucket given")
+			return 2
+		}
+		key, err := storeKey(*keyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		s, err := store.Open(registry.ResolveStore(*dir), key)
+		if err == nil {
+			snap, err = s.LoadSnapshot(*root)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	report, err := layout.Verify(snap)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	for _, p := range report.Problems {
+		where := p.Image
+		if p.Layer != "" {
+			where += " layer " + p.Layer
+		}
+		if p.Path != "" {
+			where += ": " + p.Path
+		}
+		fmt.Printf("%s: %s\n", where, p.Message)
+	}
+	fmt.Printf("Checked %d images with %d layers", report.Images, report.Layers)
+	if snap != nil {
+		fmt.Printf("; %d of %d files match snapshot %s", report.Matched, report.Files, *root)
+	}
+	fmt.Printf(": %d problems\n", len(report.Problems))
+	if len(report.Problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func daemon(args []string) int {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	interval := flags.Duration("interval", 10*time.Minute, "time between scans")
+	syslogAddr := flags.String("syslog", "", `syslog daemon to send events to: "local", udp://HOST:PORT, tcp://HOST:PORT or unix://PATH`)
+	journald := flags.Bool("journald", false, "send events to the systemd journal")
+	flags.Parse(args)
+	tree := treeArg(flags, 0)
+	operation.Tree = tree
+	if *interval <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: -interval must be positive")
+		return 2
+	}
+
+	lock, err := monitor.Lock(tree)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer lock.Close()
+
+	remote.RehashAll()
+	m := &monitor.Monitor{Tree: tree, Errors: func(err error) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}}
+	if *syslogAddr != "" {
+		sink, err := monitor.NewSyslogSink(*syslogAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		m.Sinks = append(m.Sinks, sink)
+	}
+	if *journald {
+		sink, err := monitor.NewJournalSink()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		m.Sinks = append(m.Sinks, sink)
+	}
+	m.Sinks = append(m.Sinks, printSink{})
+	defer func() {
+		for _, sink := range m.Sinks {
+			sink.Close()
+		}
+	}()
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+	m.Run(*interval, stop)
+	return 0
+}
+
+// printSink prints events to standard output.
+type printSink struct{}
+
+func (printSink) Emit(e monitor.Event) error {
+	fmt.Printf("%s %-9s %s\n", e.Time.Format(time.RFC3339), e.Type, monitor.Describe(e))
+	return nil
+}
+
+func (printSink) Close() error { return nil }
+
+func compareTrees(args []string) int {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Error: give the two trees to compare")
+		return 2
+	}
+	c, err := monitor.Compare(treeArg(flags, 0), treeArg(flags, 1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	operation.Tree, operation.RootHash = c.Trees[0], c.Roots[0].Hash
+	operation.Params["with"] = c.Trees[1]
+	for i, tree := range c.Trees {
+		fmt.Printf("%s: root %s\n", tree, c.Roots[i].Hash)
+	}
+	if len(c.Changes) == 0 {
+		fmt.Println("The trees are identical")
+		return 0
+	}
+	for _, e := range c.Changes {
+		fmt.Printf("%-9s %s: %s\n", e.Type, e.Path, e.Message)
+	}
+	fmt.Printf("%d differences\n", len(c.Changes))
+	return 1
+}
+
+// previewBuild reports what a build of a tree would hash and skip, so
+// ignore rules and walk options can be checked before a long build.
+func previewBuild(args []string) int {
+	flags := flag.NewFlagSet("preview", flag.ExitOnError)
+	addWalkFlags(flags)
+	flags.Parse(args)
+	tree := treeArg(flags, 0)
+
+	report, err := remote.Preview(tree, walkArgs(flags))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Tree = tree
+	for _, line := range report {
+		fmt.Println(line)
+	}
+	return 0
+}
+
+// menuReport builds the tree of the command, with its walk options, runs
+// the menu options on it and prints what each printed. It returns the
+// tree and the reports, or nil after printing the error.
+func menuReport(name string, args []string, addFlags func(*flag.FlagSet), options ...int) (string, [][]string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	addWalkFlags(flags)
+	if addFlags != nil {
+		addFlags(flags)
+	}
+	flags.Parse(args)
+	tree := treeArg(flags, 0)
+
+	reports, err := remote.Report(tree, walkArgs(flags), options...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return tree, nil
+	}
+	operation.Tree = tree
+	for _, line := range reports[0] {
+		if hash, ok := strings.CutPrefix(line, "Root hash: "); ok {
+			operation.RootHash = hash
+		}
+	}
+	return tree, reports
+}
+
+// buildTree builds a tree as the menu does, for scripts and cron jobs.
+func buildTree(args []string) int {
+	_, reports := menuReport("build", args, nil)
+	if reports == nil {
+		return 1
+	}
+	for _, line := range reports[0] {
+		fmt.Println(line)
+	}
+	return 0
+}
+
+// verifyTree builds a tree and verifies it. With -root the root hash
+// must also be the one given, so a cron job notices a changed tree.
+func verifyTree(args []string) int {
+	var want string
+	remote.RehashAll()
+	_, reports := menuReport("verify", args, func(flags *flag.FlagSet) {
+		flags.StringVar(&want, "root", "", "root `HASH` the tree must have")
+	}, remote.Verify)
+	if reports == nil {
+		return 1
+	}
+	fmt.Printf("Root hash: %s\n", operation.RootHash)
+	ok := true
+	for _, line := range reports[1] {
+		fmt.Println(line)
+		ok = ok && line != "Tree integrity check FAILED!"
+	}
+	if want != "" && want != operation.RootHash {
+		fmt.Printf("Root hash differs from %s\n", want)
+		ok = false
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+// verifyExport builds a tree and compares it with an export written
+// earlier, so a tree can be audited long after it was exported. The tree
+// is hashed in the export's format, with the metadata and depth and size
+// filters it records, unless the matching flags say otherwise, and every
+// file is read again rather than taken from the hash cache.
+func verifyExport(args []string) int {
+	flags := flag.NewFlagSet("verify-export", flag.ExitOnError)
+	addWalkFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no export given")
+		return 2
+	}
+	tree := treeArg(flags, 1)
+	operation.Tree, operation.Params["export"] = tree, flags.Arg(0)
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	old, err := manifest.Parse(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	walk := walkArgs(flags)
+	set := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, recorded := range []struct{ flag, value string }{
+		{"hash-format", old.HashFormat},
+		{"metadata", old.MetadataFields},
+		{"max-depth", strconv.Itoa(old.MaxDepth)},
+		{"min-file-size", strconv.FormatInt(old.MinFileSize, 10)},
+		{"max-file-size", strconv.FormatInt(old.MaxFileSize, 10)},
+	} {
+		if !set[recorded.flag] && recorded.value != "" && recorded.value != "0" {
+			walk = append(walk, "--"+recorded.flag+"="+recorded.value)
+		}
+	}
+	remote.RehashAll()
+	reports, err := remote.Report(tree, walk, remote.ExportJSON)
+	var cur *manifest.Node
+	if err == nil {
+		cur, err = manifest.Parse([]byte(strings.Join(reports[1], "\n")))
+	}
+	var c *monitor.Comparison
+	if err == nil {
+		c, err = monitor.CompareRoots(flags.Arg(0), tree, old, cur)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	operation.RootHash = cur.Hash
+	fmt.Printf("Export: root %s\n", old.Hash)
+	fmt.Printf("Tree: root %s\n", cur.Hash)
+	if len(c.Changes) == 0 {
+		fmt.Println("The tree matches the export")
+		return 0
+	}
+	for _, e := range c.Changes {
+		fmt.Printf("%-9s %s: %s\n", e.Type, e.Path, e.Message)
+	}
+	fmt.Printf("%d paths no longer match the export\n", len(c.Changes))
+	return 1
+}
+
+// exportTree builds a tree and writes its JSON export.
+func exportTree(args []string) int {
+	var out, format string
+	tree, reports := menuReport("export", args, func(flags *flag.FlagSet) {
+		flags.StringVar(&out, "o", "", "`FILE` to write instead of standard output")
+		flags.StringVar(&format, "format", "", "json, cbor, protobuf, or sha256sum for \"<hash>  <path>\" lines (sha512sum, b2sum or b3sum for trees hashed with those); by default the format -o is named for (.cbor, .pb), otherwise json")
+	}, remote.ExportJSON)
+	if reports == nil {
+		return 1
+	}
+	if format == "" {
+		format = manifest.FormatOf(out)
+	}
+	export := []byte(strings.Join(reports[1], "\n") + "\n")
+	if format != manifest.FormatJSON {
+		root, err := manifest.Parse(export)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if slices.Contains(manifest.Formats, format) {
+			if export, err = root.Encode(format); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			return writeExport(tree, out, export)
+		}
+		if !slices.Contains(manifest.ChecksumTools, format) {
+			fmt.Fprintf(os.Stderr, "Error: unknown export format %q (%s, %s)\n", format, strings.Join(manifest.Formats, ", "), strings.Join(manifest.ChecksumTools, ", "))
+			return 2
+		}
+		if tool := manifest.ChecksumTool(root.HashFormat); tool != "" && tool != format {
+			fmt.Fprintf(os.Stderr, "Error: the file hashes of a tree hashed as %s are those of %s, not %s\n", root.HashFormat, tool, format)
+			return 2
+		}
+		var b bytes.Buffer
+		if err := root.WriteChecksums(&b); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		export = b.Bytes()
+	}
+	return writeExport(tree, out, export)
+}
+
+// writeExport writes an export of tree to out, or standard output if out
+// is empty.
+func writeExport(tree, out string, export []byte) int {
+	if out == "" {
+		os.Stdout.Write(export)
+		return 0
+	}
+	if err := forensic.CheckOutput(tree, out); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(out, export, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Exported %s to %s\n", operation.RootHash, out)
+	return 0
+}
+
+// treeStats builds a tree and prints the statistics of the menu.
+func treeStats(args []string) int {
+	var jsonOut *bool
+	var top *int
+	var storeDir, keyFile *string
+	chunks := &chunkingFlag{chunking.Fixed(script.DefaultChunkSize)}
+	var flags *flag.FlagSet
+	tree, reports := menuReport("stats", args, func(f *flag.FlagSet) {
+		flags = f
+		jsonOut = f.Bool("json", false, "print the statistics and the deduplication report as JSON")
+		storeDir = f.String("store", "", "store holding a snapshot of the tree to report deduplication of (default the store the tree was last stored in)")
+		keyFile = addKeyFlag(f)
+		f.Var(chunks, "chunk-size", "split the files into chunks of `SIZE`, or fastcdc:MIN/AVG/MAX, to report deduplication of instead of a stored snapshot")
+		top = f.Int("top", 10, "most duplicated files to list, 0 for all")
+	}, remote.Statistics)
+	if reports == nil {
+		return 1
+	}
+
+	// Deduplication is reported of the tree chunked now if a chunk size
+	// was given, otherwise of its stored snapshot, if there is one
+	var m *duplicates.ChunkMap
+	var err error
+	chunked := false
+	flags.Visit(func(f *flag.Flag) { chunked = chunked || f.Name == "chunk-size" })
+	if chunked {
+		var root *manifest.Node
+		if root, err = remote.Build(tree); err == nil {
+			m, err = duplicates.MapTree(tree, root, chunks.Params, nil)
+		}
+	} else {
+		m, err = storedChunkMap(tree, operation.RootHash, *storeDir, *keyFile)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	if !*jsonOut {
+		for _, line := range reports[1] {
+			fmt.Println(line)
+		}
+		if m != nil {
+			for _, line := range m.Dedup(*top).Summary() {
+				fmt.Println(line)
+			}
+		}
+		return 0
+	}
+	out := struct {
+		Tree       string                 `json:"tree"`
+		RootHash   string                 `json:"root_hash"`
+		Statistics map[string]any         `json:"statistics"`
+		Dedup      *duplicates.DedupStats `json:"dedup,omitempty"`
+	}{Tree: tree, RootHash: operation.RootHash, Statistics: map[string]any{}}
+	for _, line := range reports[1] {
+		name, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			continue
+		}
+		name = strings.ReplaceAll(strings.ToLower(name), " ", "_")
+		// Sizes are given in bytes
+		number, _, _ := strings.Cut(value, " bytes")
+		if n, err := strconv.ParseInt(number, 10, 64); err == nil {
+			out.Statistics[name] = n
+		} else {
+			out.Statistics[name] = value
+		}
+	}
+	if m != nil {
+		d := m.Dedup(*top)
+		out.Dedup = &d
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(data))
+	return 0
+}
+
+func findDuplicates(args []string) int {
+	flags := flag.NewFlagSet("duplicates", flag.ExitOnError)
+	sortBy := flags.String("sort", string(duplicates.ByWasted), "order of the groups: wasted, count, size or path")
+	flags.Parse(args)
+	order, err := duplicates.ParseOrder(*sortBy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	tree := treeArg(flags, 0)
+
+	root, err := remote.Build(tree)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Tree, operation.RootHash = tree, root.Hash
+	groups := duplicates.Find(root)
+	duplicates.Sort(groups, order)
+	for _, g := range groups {
+		fmt.Printf("%d bytes reclaimable: %d files of %d bytes, %d copies on disk (%s)\n", g.Wasted(), len(g.Paths), g.Size, g.Copies, g.Hash)
+		for _, p := range g.Paths {
+			fmt.Printf("  %s\n", p)
+		}
+	}
+	sum := duplicates.Summarize(groups)
+	if sum.Groups == 0 {
+		fmt.Println("No duplicate files")
+		return 0
+	}
+	fmt.Printf("%d groups, %d files, %d bytes reclaimable\n", sum.Groups, sum.Files, sum.Wasted)
+	return 0
+}
+
+func findSimilar(args []string) int {
+	flags := flag.NewFlagSet("similar", flag.ExitOnError)
+	minShare := flags.Float64("min", 50, "share of the larger file, in percent, two files must have in common")
+	chunks := &chunkingFlag{chunking.Fixed(1024 * 1024)}
+	flags.Var(chunks, "chunk-size", "`SIZE` of the chunks compared, or fastcdc:MIN/AVG/MAX for content-defined chunks")
+	flags.Parse(args)
+	if *minShare <= 0 || *minShare > 100 {
+		fmt.Fprintln(os.Stderr, "Error: -min must be above 0 and at most 100")
+		return 2
+	}
+	tree := treeArg(flags, 0)
+
+	root, err := remote.Build(tree)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Tree, operation.RootHash = tree, root.Hash
+	pairs, err := duplicates.Similar(tree, root, chunks.Params, *minShare/100, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	for _, p := range pairs {
+		fmt.Printf("%3.0f%% %s (%d bytes) ~ %s (%d bytes): %d chunks, %d bytes shared\n",
+			p.Similarity()*100, p.A, p.SizeA, p.B, p.SizeB, p.SharedChunks, p.SharedBytes)
+	}
+	fmt.Printf("%d pairs of files share at least %g%% of their chunks\n", len(pairs), *minShare)
+	return 0
+}
+
+func chunkMap(args []string) int {
+	flags := flag.NewFlagSet("chunk-map", flag.ExitOnError)
+	out := flags.String("o", "chunk-map.json", "file to write")
+	shared := flags.Bool("shared", false, "only list the chunks found at more than one place")
+	chunks := &chunkingFlag{chunking.Fixed(1024 * 1024)}
+	flags.Var(chunks, "chunk-size", "`SIZE` of the chunks mapped, or fastcdc:MIN/AVG/MAX for content-defined chunks")
+	dir := flags.String("store", os.Getenv(store.DirEnv), "store holding the snapshot (default $"+store.DirEnv+")")
+	keyFile := addKeyFlag(flags)
+	snapshot := flags.String("snapshot", "", "root hash, or a unique prefix of it, of a stored snapshot to map instead of the tree")
+	flags.Parse(args)
+
+	var m *duplicates.ChunkMap
+	if *snapshot != "" {
+		if *dir == "" {
+			fmt.Fprintln(os.Stderr, "Error: no store directory or bucket given")
+			return 2
+		}
+		key, err := storeKey(*keyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		s, err := store.OpenExisting(registry.ResolveStore(*dir), key)
+		var root string
+		if err == nil {
+			root, err = s.FindSnapshot(*snapshot)
+		}
+		var snap *store.Snapshot
+		if err == nil {
+			snap, err = s.LoadSnapshot(root)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		operation.RootHash = root
+		m = duplicates.MapSnapshot(snap)
+	} else {
+		tree := treeArg(flags, 0)
+		if err := forensic.CheckOutput(tree, *out); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		root, err := remote.Build(tree)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		operation.Tree, operation.RootHash = tree, root.Hash
+		if m, err = duplicates.MapTree(tree, root, chunks.Params, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	all := len(m.Chunks)
+	if *shared {
+		m = m.Shared()
+	}
+	if err := m.Write(*out); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Mapped %d of %d chunks of %s to %s\n", len(m.Chunks), all, m.RootHash, *out)
+	return 0
+}
+
+func verifyOplog(args []string) int {
+	flags := flag.NewFlagSet("verify-oplog", flag.ExitOnError)
+	head := flags.String("head", "", "hash of an entry noted earlier that must still be in the log")
+	flags.Parse(args)
+
+	name := flags.Arg(0)
+	if name == "" {
+		var err error
+		if name, err = oplog.Path(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if name == "" {
+			fmt.Fprintf(os.Stderr, "Error: the operation log is off (%s=off); give its file\n", oplog.FileEnv)
+			return 2
+		}
+	}
+	report, err := oplog.Verify(name, *head)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", name, err)
+		return 1
+	}
+	fmt.Printf("%s: %d entries, chain intact\n", name, report.Entries)
+	if report.Entries > 0 {
+		h := report.Head
+		fmt.Printf("Head: entry %d, %s %s by %s at %s\n", h.Seq, h.Operation, h.Result, h.User, h.Time)
+		fmt.Printf("Head hash: %s\n", h.Hash)
+	}
+	return 0
+}
+
+// watchTree builds a tree in process and follows its changes until
+// interrupted, printing the root hash after each batch of them.
+func watchTree(args []string) int {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	addWalkFlags(flags)
+	delay := flags.Duration("delay", watch.DefaultDelay, "how long changes must settle before they are hashed")
+	flags.Parse(args)
+	tree := treeArg(flags, 0)
+	operation.Tree = tree
+	if *delay <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: -delay must be positive")
+		return 2
+	}
+
+	builder, err := merkle.NewSession(append([]string{"mtfs_tui watch"}, walkArgs(flags)...))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	w, err := watch.Start(builder, tree, *delay, func(u watch.Update) {
+		if u.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s Error: %v\n", u.Time.Format(time.RFC3339), u.Err)
+		}
+		if len(u.Paths) > 0 {
+			fmt.Printf("%s %s  %s\n", u.Time.Format(time.RFC3339), u.Root, strings.Join(u.Paths, ", "))
+		}
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Watching %s\nRoot hash: %s\n", w.Dir, w.Root())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	if err := w.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	operation.RootHash = w.Root()
+	return 0
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"MTFS/remote"
+	"MTFS/settings"
+	"MTFS/superroot"
+)
+
+// isolate keeps the commands a test runs from the user's configuration
+// and has them build trees with the Go engine.
+func isolate(t *testing.T) {
+	config := t.TempDir()
+	t.Setenv("HOME", config)
+	t.Setenv("XDG_CONFIG_HOME", config)
+	t.Setenv(settings.FileEnv, filepath.Join(config, "mtfs.conf"))
+	t.Setenv(remote.EngineEnv, remote.GoEngine)
+	t.Setenv(remote.HashCacheEnv, "")
+}
+
+// writeOld writes content to name with the same modification time every
+// time, as touch -r leaves it, and one older than the hash cache, which
+// only keeps files older than itself.
+func writeOld(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	written := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(name, written, written); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// An edit that keeps the size and modification time of a file, which the
+// hash cache cannot see, still fails verify-export.
+func TestVerifyExportRehashes(t *testing.T) {
+	isolate(t)
+	tree := t.TempDir()
+	name := filepath.Join(tree, "a")
+	writeOld(t, name, "hi\n")
+	export := filepath.Join(t.TempDir(), "tree.json")
+	if code := runCommand("export", []string{"-o", export, tree}); code != 0 {
+		t.Fatalf("export exited with %d", code)
+	}
+	if code := runCommand("verify-export", []string{export, tree}); code != 0 {
+		t.Fatalf("verify-export of the unchanged tree exited with %d", code)
+	}
+
+	writeOld(t, name, "HO\n")
+
+	// A build taking the file from the hash cache misses the edit...
+	old, err := os.ReadFile(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(remote.HashCacheEnv, "")
+	if cached, err := remote.Build(tree); err != nil || !strings.Contains(string(old), cached.Hash) {
+		t.Fatalf("the hash cache saw the edit: %v", err)
+	}
+
+	// ...which verify-export does not
+	if code := runCommand("verify-export", []string{export, tree}); code != 1 {
+		t.Errorf("verify-export of the edited tree exited with %d, want 1", code)
+	}
+}
+
+// The same goes for verify-inclusion -tree.
+func TestVerifyInclusionRehashes(t *testing.T) {
+	isolate(t)
+	tree := t.TempDir()
+	name := filepath.Join(tree, "a")
+	writeOld(t, name, "hi\n")
+	// Built as the commands build, which fills the hash cache
+	root, err := remote.Build(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := superroot.Compute([]superroot.Tree{{Name: "other", RootHash: strings.Repeat("0", 64)}, {Name: "tree", RootHash: root.Hash}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := doc.Prove("tree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofFile := filepath.Join(t.TempDir(), "tree.proof.json")
+	if err := superroot.Save(proofFile, proof); err != nil {
+		t.Fatal(err)
+	}
+	if code := runCommand("verify-inclusion", []string{"-tree", tree, proofFile}); code != 0 {
+		t.Fatalf("verify-inclusion of the unchanged tree exited with %d", code)
+	}
+
+	writeOld(t, name, "HO\n")
+	t.Setenv(remote.HashCacheEnv, "")
+	if cached, err := remote.Build(tree); err != nil || cached.Hash != root.Hash {
+		t.Fatalf("the hash cache saw the edit: %v", err)
+	}
+	if code := runCommand("verify-inclusion", []string{"-tree", tree, proofFile}); code != 1 {
+		t.Errorf("verify-inclusion of the edited tree exited with %d, want 1", code)
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"127.0.0.1:8765": true,
+		"[::1]:8765":     true,
+		"localhost:8765": true,
+		":8765":          false,
+		"0.0.0.0:8765":   false,
+		"10.0.0.1:8765":  false,
+		"8765":           false,
+	} {
+		if got := loopback(addr); got != want {
+			t.Errorf("loopback(%q) = %v", addr, got)
+		}
+	}
+}
+
+// A proof written by prove-file checks with verify-file-proof against the
+// root hash, and against its file until the file changes.
+func TestProveFileRoundTrip(t *testing.T) {
+	isolate(t)
+	tree := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tree, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(tree, "sub", "a")
+	writeOld(t, name, "hi\n")
+	writeOld(t, filepath.Join(tree, "b"), "other\n")
+	root, err := remote.Build(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof := filepath.Join(t.TempDir(), "a.proof.json")
+	if code := runCommand("prove-file", []string{"-o", proof, "sub/a", tree}); code != 0 {
+		t.Fatalf("prove-file exited with %d", code)
+	}
+	if code := runCommand("verify-file-proof", []string{"-root", root.Hash, "-file", name, proof}); code != 0 {
+		t.Errorf("verify-file-proof exited with %d", code)
+	}
+	other := strings.Repeat("0", len(root.Hash))
+	if code := runCommand("verify-file-proof", []string{"-root", other, proof}); code != 1 {
+		t.Errorf("verify-file-proof against another root exited with %d, want 1", code)
+	}
+	writeOld(t, name, "HO\n")
+	if code := runCommand("verify-file-proof", []string{"-root", root.Hash, "-file", name, proof}); code != 1 {
+		t.Errorf("verify-file-proof of the edited file exited with %d, want 1", code)
+	}
+}
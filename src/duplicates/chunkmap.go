@@ -0,0 +1,142 @@
+package duplicates
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"MTFS/chunking"
+	"MTFS/forensic"
+	"MTFS/manifest"
+	"MTFS/store"
+)
+
+// MapVersion is the version of the chunk maps written by this package.
+const MapVersion = 1
+
+// ChunkMap maps every chunk of a tree to the places it is found at, so
+// other tools can see which files share which data.
+type ChunkMap struct {
+	Version   int           `json:"version"`
+	Tree      string        `json:"tree,omitempty"`
+	RootHash  string        `json:"root_hash"`
+	ChunkSize int           `json:"chunk_size"`         // the average of content-defined chunks
+	Chunking  string        `json:"chunking,omitempty"` // set for content-defined chunks
+	Chunks    []MappedChunk `json:"chunks"`             // by hash
+}
+
+// MappedChunk is a chunk and the places it is found at.
+type MappedChunk struct {
+	Hash string     `json:"hash"` // SHA-256 of the chunk, as stored
+	Size int64      `json:"size"`
+	Refs []ChunkRef `json:"refs"` // by path and offset
+}
+
+// ChunkRef is a place a chunk is found at: a file and the offset in it.
+type ChunkRef struct {
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+}
+
+// mapBuilder collects the chunks of a map.
+type mapBuilder map[string]*MappedChunk
+
+func (b mapBuilder) add(hash string, size int64, ref ChunkRef) {
+	c := b[hash]
+	if c == nil {
+		c = &MappedChunk{Hash: hash, Size: size}
+		b[hash] = c
+	}
+	c.Refs = append(c.Refs, ref)
+}
+
+func (b mapBuilder) chunks() []MappedChunk {
+	out := make([]MappedChunk, 0, len(b))
+	for _, c := range b {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Hash < out[j].Hash })
+	return out
+}
+
+// MapTree splits the files of the tree at dir described by root into
+// chunks as params says and maps them. progress, if not nil, is called
+// after each file.
+func MapTree(dir string, root *manifest.Node, params chunking.Params, progress func(files int)) (*ChunkMap, error) {
+	if err := params.Check(); err != nil {
+		return nil, err
+	}
+	b := mapBuilder{}
+	splitter := chunking.NewSplitter(params)
+	files := 0
+	err := root.Walk(func(p string, n *manifest.Node) error {
+		if !n.IsFile() {
+			return nil
+		}
+		f, err := forensic.Open(filepath.Join(dir, filepath.FromSlash(p)))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		var offset int64
+		err = splitter.Split(f, func(chunk []byte) error {
+			sum := sha256.Sum256(chunk)
+			b.add(hex.EncodeToString(sum[:]), int64(len(chunk)), ChunkRef{p, offset})
+			offset += int64(len(chunk))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		files++
+		if progress != nil {
+			progress(files)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	m := &ChunkMap{Version: MapVersion, Tree: dir, RootHash: root.Hash, ChunkSize: params.Size, Chunks: b.chunks()}
+	if params.CDC {
+		m.ChunkSize, m.Chunking = params.Avg, params.String()
+	}
+	return m, nil
+}
+
+// MapSnapshot maps the chunks of a stored snapshot without reading the
+// tree.
+func MapSnapshot(snap *store.Snapshot) *ChunkMap {
+	b := mapBuilder{}
+	for _, f := range snap.Files {
+		spans := snap.Spans(f)
+		for i, hash := range f.Chunks {
+			b.add(hash, spans[i].Length, ChunkRef{f.Path, spans[i].Offset})
+		}
+	}
+	return &ChunkMap{Version: MapVersion, RootHash: snap.Root, ChunkSize: snap.ChunkSize, Chunking: snap.Chunking, Chunks: b.chunks()}
+}
+
+// Shared returns m with only the chunks found at more than one place.
+func (m *ChunkMap) Shared() *ChunkMap {
+	shared := *m
+	shared.Chunks = nil
+	for _, c := range m.Chunks {
+		if len(c.Refs) > 1 {
+			shared.Chunks = append(shared.Chunks, c)
+		}
+	}
+	return &shared
+}
+
+// Write writes m as indented JSON to name.
+func (m *ChunkMap) Write(name string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0o644)
+}
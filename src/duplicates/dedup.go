@@ -0,0 +1,101 @@
+package duplicates
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DedupStats tells how much storing the chunks of a map once saves.
+type DedupStats struct {
+	RootHash        string      `json:"root_hash"`
+	ChunkSize       int         `json:"chunk_size"`
+	Chunking        string      `json:"chunking,omitempty"`
+	UniqueChunks    int         `json:"unique_chunks"`
+	DuplicateChunks int         `json:"duplicate_chunks"` // unique chunks found at more than one place
+	ChunkRefs       int         `json:"chunk_refs"`       // places chunks are found at
+	Bytes           int64       `json:"bytes"`            // of the files
+	StoredBytes     int64       `json:"stored_bytes"`     // of the unique chunks
+	SavedBytes      int64       `json:"saved_bytes"`
+	Files           []DedupFile `json:"files"` // most duplicated first
+}
+
+// DedupFile is a file and how much of it is found elsewhere too.
+type DedupFile struct {
+	Path            string `json:"path"`
+	Size            int64  `json:"size"`
+	Chunks          int    `json:"chunks"`
+	DuplicateChunks int    `json:"duplicate_chunks"`
+	DuplicateBytes  int64  `json:"duplicate_bytes"`
+}
+
+// Ratio returns how many bytes of the files each stored byte stands for,
+// 1 if there are none.
+func (s DedupStats) Ratio() float64 {
+	if s.StoredBytes == 0 {
+		return 1
+	}
+	return float64(s.Bytes) / float64(s.StoredBytes)
+}
+
+// Summary returns s as lines of a report, sizes in bytes.
+func (s DedupStats) Summary() []string {
+	lines := []string{
+		fmt.Sprintf("Deduplication: %d unique chunks of %d (%d found at more than one place)", s.UniqueChunks, s.ChunkRefs, s.DuplicateChunks),
+		fmt.Sprintf("Stored size: %d bytes of %d bytes", s.StoredBytes, s.Bytes),
+		fmt.Sprintf("Saved by deduplication: %d bytes (ratio %.2fx)", s.SavedBytes, s.Ratio()),
+	}
+	if len(s.Files) > 0 {
+		lines = append(lines, "Most duplicated files:")
+	}
+	for _, f := range s.Files {
+		lines = append(lines, fmt.Sprintf("  %s: %d bytes of %d bytes in %d of %d chunks found elsewhere", f.Path, f.DuplicateBytes, f.Size, f.DuplicateChunks, f.Chunks))
+	}
+	return lines
+}
+
+// Dedup totals the chunks of m and lists up to top files, all if top is
+// 0, with the most bytes in chunks found at other places too. Files
+// nothing of which is duplicated are left out.
+func (m *ChunkMap) Dedup(top int) DedupStats {
+	s := DedupStats{RootHash: m.RootHash, ChunkSize: m.ChunkSize, Chunking: m.Chunking, UniqueChunks: len(m.Chunks)}
+	files := map[string]*DedupFile{}
+	for _, c := range m.Chunks {
+		s.ChunkRefs += len(c.Refs)
+		s.Bytes += c.Size * int64(len(c.Refs))
+		s.StoredBytes += c.Size
+		if len(c.Refs) > 1 {
+			s.DuplicateChunks++
+		}
+		for _, ref := range c.Refs {
+			f := files[ref.Path]
+			if f == nil {
+				f = &DedupFile{Path: ref.Path}
+				files[ref.Path] = f
+			}
+			f.Size += c.Size
+			f.Chunks++
+			if len(c.Refs) > 1 {
+				f.DuplicateChunks++
+				f.DuplicateBytes += c.Size
+			}
+		}
+	}
+	s.SavedBytes = s.Bytes - s.StoredBytes
+
+	s.Files = []DedupFile{}
+	for _, f := range files {
+		if f.DuplicateBytes > 0 {
+			s.Files = append(s.Files, *f)
+		}
+	}
+	sort.Slice(s.Files, func(i, j int) bool {
+		if s.Files[i].DuplicateBytes != s.Files[j].DuplicateBytes {
+			return s.Files[i].DuplicateBytes > s.Files[j].DuplicateBytes
+		}
+		return s.Files[i].Path < s.Files[j].Path
+	})
+	if top > 0 && len(s.Files) > top {
+		s.Files = s.Files[:top]
+	}
+	return s
+}
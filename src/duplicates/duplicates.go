@@ -0,0 +1,131 @@
+// Package duplicates finds the files of a tree with identical content from
+// the leaf hashes of its export, and how much space removing the extra
+// copies would reclaim.
+package duplicates
+
+import (
+	"fmt"
+	"sort"
+
+	"MTFS/manifest"
+)
+
+// Group is a set of files with the same content.
+type Group struct {
+	Hash  string   // content hash of the files
+	Size  int64    // size of one file
+	Paths []string // in path order
+	// Copies is the number of copies on disk: hard links to a file of the
+	// group share its copy.
+	Copies int
+}
+
+// Wasted returns the bytes taken by all copies of g but one.
+func (g Group) Wasted() int64 {
+	return g.Size * int64(g.Copies-1)
+}
+
+// Order is an order of groups.
+type Order string
+
+const (
+	ByWasted Order = "wasted" // most reclaimable space first
+	ByCount  Order = "count"  // most files first
+	BySize   Order = "size"   // largest files first
+	ByPath   Order = "path"   // by first path
+)
+
+// Orders lists the orders groups can be sorted in.
+var Orders = []Order{ByWasted, ByCount, BySize, ByPath}
+
+// ParseOrder returns the order called name.
+func ParseOrder(name string) (Order, error) {
+	for _, o := range Orders {
+		if string(o) == name {
+			return o, nil
+		}
+	}
+	return "", fmt.Errorf("unknown order %q (wasted, count, size or path)", name)
+}
+
+// Find returns the groups of two or more files of root with the same
+// content, by most reclaimable space. The leaf hash of a file also covers
+// its extended attributes when they are recorded, so files are grouped by
+// their content hash, falling back to the leaf hash. Empty files take no
+// space and are left out.
+func Find(root *manifest.Node) []Group {
+	byHash := map[string]*Group{}
+	var hashes []string
+	root.Walk(func(p string, n *manifest.Node) error {
+		if !n.IsFile() || n.Size == 0 {
+			return nil
+		}
+		h := n.ContentHash
+		if h == "" {
+			h = n.Hash
+		}
+		g := byHash[h]
+		if g == nil {
+			g = &Group{Hash: h, Size: n.Size}
+			byHash[h] = g
+			hashes = append(hashes, h)
+		}
+		g.Paths = append(g.Paths, p)
+		if n.HardlinkOf == "" {
+			g.Copies++
+		}
+		return nil
+	})
+
+	var groups []Group
+	for _, h := range hashes {
+		if g := byHash[h]; len(g.Paths) > 1 {
+			if g.Copies == 0 {
+				// All links lead to a file outside the tree
+				g.Copies = 1
+			}
+			groups = append(groups, *g)
+		}
+	}
+	Sort(groups, ByWasted)
+	return groups
+}
+
+// Sort sorts groups in order o; ties are broken by first path.
+func Sort(groups []Group, o Order) {
+	key := func(g Group) int64 {
+		switch o {
+		case ByWasted:
+			return g.Wasted()
+		case ByCount:
+			return int64(len(g.Paths))
+		case BySize:
+			return g.Size
+		}
+		return 0
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if ki, kj := key(groups[i]), key(groups[j]); ki != kj {
+			return ki > kj
+		}
+		return groups[i].Paths[0] < groups[j].Paths[0]
+	})
+}
+
+// Summary totals groups.
+type Summary struct {
+	Groups int
+	Files  int
+	Wasted int64
+}
+
+// Summarize totals groups.
+func Summarize(groups []Group) Summary {
+	var s Summary
+	for _, g := range groups {
+		s.Groups++
+		s.Files += len(g.Paths)
+		s.Wasted += g.Wasted()
+	}
+	return s
+}
@@ -0,0 +1,157 @@
+package duplicates
+
+import (
+	"crypto/sha256"
+	"path/filepath"
+	"sort"
+
+	"MTFS/chunking"
+	"MTFS/forensic"
+	"MTFS/manifest"
+)
+
+// commonChunk is the number of files a chunk may be found in before it is
+// taken as common filler, such as a block of zeros, rather than evidence
+// of a shared origin. It also bounds the pairs one chunk adds.
+const commonChunk = 64
+
+// Pair is two files sharing chunks.
+type Pair struct {
+	A, B         string
+	SizeA, SizeB int64
+	SharedChunks int
+	// SharedBytes estimates the content the files have in common: the
+	// bytes of the chunks found in both.
+	SharedBytes int64
+}
+
+// Similarity returns the share of the larger file found in the other.
+func (p Pair) Similarity() float64 {
+	larger := max(p.SizeA, p.SizeB)
+	if larger == 0 {
+		return 0
+	}
+	return float64(p.SharedBytes) / float64(larger)
+}
+
+// chunks are the chunks of a file by hash, with how often the file holds
+// each and its length.
+type chunks map[[sha256.Size]byte]struct {
+	count  int
+	length int64
+}
+
+// Similar splits the files of the tree at dir described by root into
+// chunks as params says and returns the pairs of files sharing at least
+// threshold of the larger one's bytes, most similar first. Of identical
+// files only the first is compared, as Find lists the others, and files
+// of one chunk can only be identical or share nothing. Content-defined
+// chunks also find files with bytes inserted or removed. progress, if not
+// nil, is called after each file read.
+func Similar(dir string, root *manifest.Node, params chunking.Params, threshold float64, progress func(files int)) ([]Pair, error) {
+	if err := params.Check(); err != nil {
+		return nil, err
+	}
+	type file struct {
+		path   string
+		size   int64
+		chunks chunks
+	}
+	var files []file
+	seen := map[string]bool{}
+	err := root.Walk(func(p string, n *manifest.Node) error {
+		if n.IsFile() && n.Size > int64(params.Whole()) && !seen[n.ContentHash] {
+			seen[n.ContentHash] = true
+			files = append(files, file{path: p, size: n.Size})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	splitter := chunking.NewSplitter(params)
+	index := map[[sha256.Size]byte][]int{}
+	for i := range files {
+		found, err := chunkFile(filepath.Join(dir, filepath.FromSlash(files[i].path)), splitter)
+		if err != nil {
+			return nil, err
+		}
+		files[i].chunks = found
+		for h := range found {
+			index[h] = append(index[h], i)
+		}
+		if progress != nil {
+			progress(i + 1)
+		}
+	}
+
+	// Count the chunks each pair of files has in common, as many times as
+	// the file with fewer copies of a chunk holds it
+	type key struct{ a, b int }
+	shared := map[key]*Pair{}
+	for i, f := range files {
+		for h, c := range f.chunks {
+			if len(index[h]) > commonChunk {
+				continue
+			}
+			for _, j := range index[h] {
+				if j <= i {
+					continue
+				}
+				k := key{i, j}
+				p := shared[k]
+				if p == nil {
+					p = &Pair{A: f.path, B: files[j].path, SizeA: f.size, SizeB: files[j].size}
+					shared[k] = p
+				}
+				n := min(c.count, files[j].chunks[h].count)
+				p.SharedChunks += n
+				p.SharedBytes += int64(n) * c.length
+			}
+		}
+	}
+
+	var pairs []Pair
+	for _, p := range shared {
+		if p.Similarity() >= threshold {
+			pairs = append(pairs, *p)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		si, sj := pairs[i].Similarity(), pairs[j].Similarity()
+		switch {
+		case si != sj:
+			return si > sj
+		case pairs[i].SharedBytes != pairs[j].SharedBytes:
+			return pairs[i].SharedBytes > pairs[j].SharedBytes
+		case pairs[i].A != pairs[j].A:
+			return pairs[i].A < pairs[j].A
+		}
+		return pairs[i].B < pairs[j].B
+	})
+	return pairs, nil
+}
+
+// chunkFile returns the chunks of the file at name, cut by splitter.
+func chunkFile(name string, splitter *chunking.Splitter) (chunks, error) {
+	f, err := forensic.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	found := chunks{}
+	err = splitter.Split(f, func(chunk []byte) error {
+		h := sha256.Sum256(chunk)
+		c := found[h]
+		c.count++
+		c.length = int64(len(chunk))
+		found[h] = c
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
@@ -0,0 +1,10 @@
+// Package filelock takes advisory locks on whole files, with flock on Unix
+// and LockFileEx on Windows, so the logs, registry and watch locks of the
+// frontend work the same on both.
+package filelock
+
+import "errors"
+
+// ErrLocked is returned by TryLock and TryShare while another process
+// holds a conflicting lock.
+var ErrLocked = errors.New("file is locked by another process")
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package filelock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Lock takes an exclusive lock on f, waiting for other holders.
+func Lock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+// TryLock takes an exclusive lock on f, or fails with ErrLocked.
+func TryLock(f *os.File) error {
+	return try(f, syscall.LOCK_EX)
+}
+
+// TryShare takes a shared lock on f, or fails with ErrLocked.
+func TryShare(f *os.File) error {
+	return try(f, syscall.LOCK_SH)
+}
+
+// Unlock releases the lock on f.
+func Unlock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+func try(f *os.File, how int) error {
+	err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrLocked
+	}
+	return err
+}
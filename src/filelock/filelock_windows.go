@@ -0,0 +1,52 @@
+//go:build windows
+
+package filelock
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Windows locks are mandatory for the bytes they cover, so the lock is
+// taken on one byte far past the end of any file, which is never read or
+// written, rather than on the content. File offsets are signed.
+const (
+	lockOffsetLow  = 0xfffffffe
+	lockOffsetHigh = 0x7fffffff
+)
+
+// Lock takes an exclusive lock on f, waiting for other holders.
+func Lock(f *os.File) error {
+	return lock(f, windows.LOCKFILE_EXCLUSIVE_LOCK)
+}
+
+// TryLock takes an exclusive lock on f, or fails with ErrLocked.
+func TryLock(f *os.File) error {
+	return try(f, windows.LOCKFILE_EXCLUSIVE_LOCK)
+}
+
+// TryShare takes a shared lock on f, or fails with ErrLocked.
+func TryShare(f *os.File) error {
+	return try(f, 0)
+}
+
+// Unlock releases the lock on f.
+func Unlock(f *os.File) error {
+	ol := windows.Overlapped{Offset: lockOffsetLow, OffsetHigh: lockOffsetHigh}
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &ol)
+}
+
+func lock(f *os.File, flags uint32) error {
+	ol := windows.Overlapped{Offset: lockOffsetLow, OffsetHigh: lockOffsetHigh}
+	return windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, &ol)
+}
+
+func try(f *os.File, flags uint32) error {
+	err := lock(f, flags|windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return ErrLocked
+	}
+	return err
+}
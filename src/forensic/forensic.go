@@ -0,0 +1,122 @@
+// Package forensic implements the read-only mode for evidence handling.
+// With MTFS_FORENSIC naming a case directory, nothing is written inside
+// the trees examined: their state directories move to the case directory,
+// output files inside a tree are refused, tree files are opened without
+// updating their access times where the system allows it, and every
+// operation is recorded in a hash-chained chain-of-custody log kept in the
+// case directory.
+package forensic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"MTFS/paths"
+)
+
+const (
+	// Env names the environment variable holding the case directory; the
+	// mode is off when it is unset.
+	Env = "MTFS_FORENSIC"
+	// CustodyFile is the name of the chain-of-custody log inside the case
+	// directory.
+	CustodyFile = "custody.log"
+	// treesDir holds the state directories of the trees examined inside
+	// the case directory.
+	treesDir = "trees"
+)
+
+// Dir returns the absolute case directory, or "" if the mode is off.
+func Dir() string {
+	dir := os.Getenv(Env)
+	if dir == "" {
+		return ""
+	}
+	if abs, err := paths.Abs(dir); err == nil {
+		return abs
+	}
+	return dir
+}
+
+// StateDir returns where the state of tree is kept in the case directory:
+// trees/<name>-<hash>, the hash being the first 16 hex digits of the
+// SHA-256 of the absolute path of tree (lower-cased on Windows), so trees
+// of the same name stay apart. The backend derives the same name.
+func StateDir(tree string) string {
+	abs, err := paths.Abs(tree)
+	if err != nil {
+		abs = filepath.Clean(tree)
+	}
+	name := filepath.Base(abs)
+	if name == string(filepath.Separator) || name == "." {
+		name = "root"
+	}
+	sum := sha256.Sum256([]byte(paths.Key(abs)))
+	return filepath.Join(Dir(), treesDir, name+"-"+hex.EncodeToString(sum[:])[:16])
+}
+
+// CustodyLog returns the chain-of-custody log of the case.
+func CustodyLog() string {
+	return filepath.Join(Dir(), CustodyFile)
+}
+
+// Open opens name for reading. In forensic mode the file's access time
+// is left alone on Linux, which the system only allows the owner of the
+// file or a privileged user; for others, and elsewhere, it is opened as
+// usual.
+func Open(name string) (*os.File, error) {
+	if Dir() != "" && noAtime != 0 {
+		f, err := os.OpenFile(name, os.O_RDONLY|noAtime, 0)
+		if !errors.Is(err, syscall.EPERM) {
+			return f, err
+		}
+	}
+	return os.Open(name)
+}
+
+// Inside reports whether p is tree or lies below it.
+func Inside(p, tree string) bool {
+	absP, err := paths.Abs(p)
+	if err != nil {
+		return false
+	}
+	absTree, err := paths.Abs(tree)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absTree, absP)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// CheckTree fails in forensic mode if the case directory lies inside
+// tree, where its state and custody log would be written.
+func CheckTree(tree string) error {
+	if dir := Dir(); dir != "" && Inside(dir, tree) {
+		return fmt.Errorf("forensic mode: the case directory %s is inside the tree %s; keep it elsewhere", dir, tree)
+	}
+	return nil
+}
+
+// CheckOutput fails in forensic mode if name, a file or directory about
+// to be written, lies inside tree.
+func CheckOutput(tree, name string) error {
+	if Dir() != "" && tree != "" && Inside(name, tree) {
+		return fmt.Errorf("forensic mode: %s is inside the tree %s, which is never written to", name, tree)
+	}
+	return nil
+}
+
+// Refuse fails in forensic mode with what an operation that writes
+// inside a tree would have done.
+func Refuse(what string) error {
+	if Dir() != "" {
+		return fmt.Errorf("forensic mode: refusing to %s", what)
+	}
+	return nil
+}
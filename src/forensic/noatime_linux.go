@@ -0,0 +1,8 @@
+//go:build linux
+
+package forensic
+
+import "syscall"
+
+// noAtime is the open flag that leaves the access time alone.
+const noAtime = syscall.O_NOATIME
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package forensic
+
+// noAtime is zero where files cannot be opened without updating their
+// access time.
+const noAtime = 0
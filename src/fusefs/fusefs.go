@@ -0,0 +1,137 @@
+// Package fusefs mounts a snapshot of the chunk store as a read-only FUSE
+// file system. Nothing is extracted: every read decrypts the chunks it
+// spans from the store and checks each one against its hash, so a chunk
+// corrupted on disk makes the read fail with an I/O error instead of
+// serving bad data. The FUSE protocol is spoken directly on /dev/fuse, so
+// mounting needs no library, and only fusermount when not run as root.
+package fusefs
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"MTFS/store"
+)
+
+// ErrUnsupported is returned where FUSE is not available.
+var ErrUnsupported = errors.New("mounting is only supported on Linux")
+
+// rootID is the node id FUSE gives the root of a mount.
+const rootID = 1
+
+// node is a file or directory of the mounted snapshot.
+type node struct {
+	id       uint64
+	name     string
+	parent   *node
+	children map[string]*node // nil for files
+	names    []string         // of the children, sorted
+	file     store.SnapshotFile
+	spans    []store.Span
+	mtime    time.Time
+}
+
+// FS is the file system of a snapshot.
+type FS struct {
+	store *store.Store
+	snap  *store.Snapshot
+	nodes []*node // by id - 1
+	// ReadError, if not nil, is called with every read that failed, such
+	// as one spanning a corrupt chunk.
+	ReadError func(p string, err error)
+}
+
+// New returns the file system of snap, whose chunks are read from s.
+// Directories are those the paths of its files pass through.
+func New(s *store.Store, snap *store.Snapshot) *FS {
+	created, _ := time.Parse(time.RFC3339, snap.Created)
+	fs := &FS{store: s, snap: snap}
+	root := fs.add(nil, "", true, created)
+	for _, f := range snap.Files {
+		dir := root
+		parts := strings.Split(f.Path, "/")
+		for _, name := range parts[:len(parts)-1] {
+			child := dir.children[name]
+			if child == nil {
+				child = fs.add(dir, name, true, created)
+			}
+			dir = child
+		}
+		if dir.children[parts[len(parts)-1]] != nil {
+			continue
+		}
+		mtime := created
+		if f.ModTime != 0 {
+			mtime = time.Unix(0, f.ModTime)
+		}
+		file := fs.add(dir, parts[len(parts)-1], false, mtime)
+		file.file, file.spans = f, snap.Spans(f)
+	}
+	for _, n := range fs.nodes {
+		for name := range n.children {
+			n.names = append(n.names, name)
+		}
+		sort.Strings(n.names)
+	}
+	return fs
+}
+
+func (fs *FS) add(parent *node, name string, dir bool, mtime time.Time) *node {
+	n := &node{id: uint64(len(fs.nodes) + 1), name: name, parent: parent, mtime: mtime}
+	if dir {
+		n.children = map[string]*node{}
+	}
+	if parent != nil {
+		parent.children[name] = n
+	}
+	fs.nodes = append(fs.nodes, n)
+	return n
+}
+
+// node returns the node with id, nil if there is none.
+func (fs *FS) node(id uint64) *node {
+	if id < rootID || id > uint64(len(fs.nodes)) {
+		return nil
+	}
+	return fs.nodes[id-1]
+}
+
+// path returns the slash-separated path of n in the snapshot.
+func (n *node) path() string {
+	if n.parent == nil {
+		return "."
+	}
+	return path.Join(n.parent.path(), n.name)
+}
+
+// read returns up to size bytes of the file n from off, reading and
+// checking every chunk they span.
+func (fs *FS) read(n *node, off int64, size int) ([]byte, error) {
+	end := min(off+int64(size), n.file.Size)
+	if off >= end {
+		return nil, nil
+	}
+	buf := make([]byte, 0, end-off)
+	for i, span := range n.spans {
+		if span.Offset+span.Length <= off || span.Offset >= end {
+			continue
+		}
+		chunk, err := fs.store.Get(n.file.Chunks[i])
+		if err == nil && int64(len(chunk)) != span.Length {
+			err = errors.New("store: chunk " + n.file.Chunks[i] + " has the wrong length")
+		}
+		if err != nil {
+			if fs.ReadError != nil {
+				fs.ReadError(n.path(), err)
+			}
+			return nil, err
+		}
+		from := max(off, span.Offset) - span.Offset
+		to := min(end, span.Offset+span.Length) - span.Offset
+		buf = append(buf, chunk[from:to]...)
+	}
+	return buf, nil
+}
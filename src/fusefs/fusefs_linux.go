@@ -0,0 +1,387 @@
+//go:build linux
+
+package fusefs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Opcodes of the FUSE requests served.
+const (
+	opLookup      = 1
+	opForget      = 2
+	opGetattr     = 3
+	opReadlink    = 5
+	opOpen        = 14
+	opRead        = 15
+	opStatfs      = 17
+	opRelease     = 18
+	opFlush       = 25
+	opInit        = 26
+	opOpendir     = 27
+	opReaddir     = 28
+	opReleasedir  = 29
+	opAccess      = 34
+	opInterrupt   = 36
+	opDestroy     = 38
+	opBatchForget = 42
+)
+
+const (
+	// kernelMajor and kernelMinor are the protocol version spoken.
+	kernelMajor = 7
+	kernelMinor = 31
+	// maxRead is the most the kernel asks for in a request, and the
+	// buffer requests are read into holds it.
+	maxRead   = 128 * 1024
+	inHeader  = 40
+	outHeader = 16
+	// valid is how long the kernel may keep names and attributes; a
+	// snapshot never changes.
+	valid    = 60
+	blockLen = 4096
+)
+
+// Mount is a mounted file system.
+type Mount struct {
+	Dir        string
+	fs         *FS
+	fd         int
+	fusermount string // the program that mounted it, "" if mounted directly
+}
+
+// Mount mounts fs read-only at mountpoint, directly when allowed or else
+// with fusermount. The file system is served by Serve.
+func (fs *FS) Mount(mountpoint string) (*Mount, error) {
+	dir, err := filepath.Abs(mountpoint)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	m := &Mount{Dir: dir, fs: fs}
+	if m.fd, err = mountDirect(dir); err == nil {
+		return m, nil
+	}
+	program, lookErr := exec.LookPath("fusermount3")
+	if lookErr != nil {
+		program, lookErr = exec.LookPath("fusermount")
+	}
+	if lookErr != nil {
+		return nil, fmt.Errorf("mount %s: %w, and fusermount is not installed", dir, err)
+	}
+	if m.fd, err = mountFusermount(program, dir); err != nil {
+		return nil, fmt.Errorf("mount %s: %w", dir, err)
+	}
+	m.fusermount = program
+	return m, nil
+}
+
+// mountDirect opens a FUSE connection and mounts it at dir, which needs
+// CAP_SYS_ADMIN.
+func mountDirect(dir string) (int, error) {
+	fd, err := unix.Open("/dev/fuse", unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	options := fmt.Sprintf("fd=%d,rootmode=40000,user_id=%d,group_id=%d,default_permissions", fd, os.Getuid(), os.Getgid())
+	if err := unix.Mount("mtfs", dir, "fuse.mtfs", unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, options); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// mountFusermount has the setuid fusermount program mount dir and hand
+// back the FUSE connection over a socket.
+func mountFusermount(program, dir string) (int, error) {
+	pair, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, err
+	}
+	local, remote := pair[0], os.NewFile(uintptr(pair[1]), "fusermount")
+	defer unix.Close(local)
+
+	cmd := exec.Command(program, "-o", "ro,nosuid,nodev,default_permissions,fsname=mtfs,subtype=mtfs", "--", dir)
+	cmd.ExtraFiles = []*os.File{remote}
+	cmd.Env = append(os.Environ(), "_FUSE_COMMFD=3")
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	remote.Close()
+	if err != nil {
+		return -1, fmt.Errorf("%s: %w", filepath.Base(program), err)
+	}
+
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(local, make([]byte, 1), oob, 0)
+	if err != nil {
+		return -1, err
+	}
+	messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(messages) == 0 {
+		return -1, errors.New("fusermount sent no connection")
+	}
+	fds, err := unix.ParseUnixRights(&messages[0])
+	if err != nil || len(fds) == 0 {
+		return -1, errors.New("fusermount sent no connection")
+	}
+	unix.CloseOnExec(fds[0])
+	return fds[0], nil
+}
+
+// Unmount unmounts the file system, which ends Serve. Files still open
+// in it are cut off.
+func (m *Mount) Unmount() error {
+	if m.fusermount != "" {
+		return exec.Command(m.fusermount, "-u", "-z", m.Dir).Run()
+	}
+	return unix.Unmount(m.Dir, unix.MNT_DETACH)
+}
+
+// Serve answers the requests of the kernel until the file system is
+// unmounted.
+func (m *Mount) Serve() error {
+	defer unix.Close(m.fd)
+	buf := make([]byte, maxRead+blockLen)
+	for {
+		n, err := unix.Read(m.fd, buf)
+		switch {
+		case errors.Is(err, unix.EINTR), errors.Is(err, unix.EAGAIN), errors.Is(err, unix.ENOENT):
+			// ENOENT: the request was interrupted before it was read
+			continue
+		case errors.Is(err, unix.ENODEV):
+			return nil
+		case err != nil:
+			return err
+		case n < inHeader:
+			return fmt.Errorf("fuse: short request of %d bytes", n)
+		}
+		m.handle(buf[:n])
+	}
+}
+
+// request is the header of a request and what follows it.
+type request struct {
+	opcode uint32
+	unique uint64
+	nodeID uint64
+	data   []byte
+}
+
+func (m *Mount) handle(msg []byte) {
+	le := binary.LittleEndian
+	req := request{opcode: le.Uint32(msg[4:]), unique: le.Uint64(msg[8:]), nodeID: le.Uint64(msg[16:]), data: msg[inHeader:]}
+	switch req.opcode {
+	case opForget, opBatchForget, opInterrupt:
+		// Nodes live as long as the mount, and reads are not interrupted
+		return
+	case opInit:
+		m.init(req)
+		return
+	case opDestroy:
+		m.reply(req, 0, nil)
+		return
+	case opStatfs:
+		m.statfs(req)
+		return
+	}
+
+	n := m.fs.node(req.nodeID)
+	if n == nil {
+		m.reply(req, unix.ENOENT, nil)
+		return
+	}
+	switch req.opcode {
+	case opLookup:
+		name, _, _ := bytes.Cut(req.data, []byte{0})
+		child := n.children[string(name)]
+		if child == nil {
+			m.reply(req, unix.ENOENT, nil)
+			return
+		}
+		out := make([]byte, 40, 40+88)
+		le.PutUint64(out[0:], child.id)
+		le.PutUint64(out[16:], valid)
+		le.PutUint64(out[24:], valid)
+		m.reply(req, 0, append(out, m.attr(child)...))
+	case opGetattr:
+		out := make([]byte, 16, 16+88)
+		le.PutUint64(out[0:], valid)
+		m.reply(req, 0, append(out, m.attr(n)...))
+	case opOpen:
+		if n.children != nil {
+			m.reply(req, unix.EISDIR, nil)
+		} else if le.Uint32(req.data)&unix.O_ACCMODE != unix.O_RDONLY {
+			m.reply(req, unix.EROFS, nil)
+		} else {
+			m.reply(req, 0, make([]byte, 16))
+		}
+	case opOpendir:
+		if n.children == nil {
+			m.reply(req, unix.ENOTDIR, nil)
+		} else {
+			m.reply(req, 0, make([]byte, 16))
+		}
+	case opRead:
+		if n.children != nil {
+			m.reply(req, unix.EISDIR, nil)
+			return
+		}
+		data, err := m.fs.read(n, int64(le.Uint64(req.data[8:])), int(le.Uint32(req.data[16:])))
+		if err != nil {
+			m.reply(req, unix.EIO, nil)
+			return
+		}
+		m.reply(req, 0, data)
+	case opReaddir:
+		m.readdir(req, n)
+	case opAccess:
+		if le.Uint32(req.data)&unix.W_OK != 0 {
+			m.reply(req, unix.EROFS, nil)
+		} else {
+			m.reply(req, 0, nil)
+		}
+	case opRelease, opReleasedir, opFlush:
+		m.reply(req, 0, nil)
+	case opReadlink:
+		m.reply(req, unix.EINVAL, nil)
+	default:
+		m.reply(req, unix.ENOSYS, nil)
+	}
+}
+
+// init agrees on the protocol version with the kernel.
+func (m *Mount) init(req request) {
+	le := binary.LittleEndian
+	major, minor := le.Uint32(req.data[0:]), le.Uint32(req.data[4:])
+	if major < kernelMajor {
+		m.reply(req, unix.EPROTO, nil)
+		return
+	}
+	out := make([]byte, 64)
+	le.PutUint32(out[0:], kernelMajor)
+	le.PutUint32(out[4:], min(minor, kernelMinor))
+	le.PutUint32(out[8:], maxRead)           // max_readahead
+	le.PutUint16(out[16:], 16)               // max_background
+	le.PutUint16(out[18:], 12)               // congestion_threshold
+	le.PutUint32(out[20:], maxRead)          // max_write
+	le.PutUint32(out[24:], 1)                // time_gran
+	le.PutUint16(out[28:], maxRead/blockLen) // max_pages
+	m.reply(req, 0, out)
+}
+
+// attr returns the fuse_attr of n: files and directories are read-only,
+// owned by whoever mounted the snapshot.
+func (m *Mount) attr(n *node) []byte {
+	le := binary.LittleEndian
+	out := make([]byte, 88)
+	mode, nlink, size := uint32(unix.S_IFREG|0o444), uint32(1), n.file.Size
+	if n.children != nil {
+		mode, nlink, size = unix.S_IFDIR|0o555, 2, 0
+		for _, child := range n.children {
+			if child.children != nil {
+				nlink++
+			}
+		}
+	}
+	sec, nsec := uint64(n.mtime.Unix()), uint32(n.mtime.Nanosecond())
+	if n.mtime.IsZero() {
+		sec, nsec = 0, 0
+	}
+	le.PutUint64(out[0:], n.id)
+	le.PutUint64(out[8:], uint64(size))
+	le.PutUint64(out[16:], uint64(size+511)/512)
+	for _, at := range []int{24, 32, 40} {
+		le.PutUint64(out[at:], sec)
+	}
+	for _, at := range []int{48, 52, 56} {
+		le.PutUint32(out[at:], nsec)
+	}
+	le.PutUint32(out[60:], mode)
+	le.PutUint32(out[64:], nlink)
+	le.PutUint32(out[68:], uint32(os.Getuid()))
+	le.PutUint32(out[72:], uint32(os.Getgid()))
+	le.PutUint32(out[80:], blockLen)
+	return out
+}
+
+// readdir lists the entries of the directory n from the offset asked for,
+// as many as fit.
+func (m *Mount) readdir(req request, n *node) {
+	le := binary.LittleEndian
+	offset, size := le.Uint64(req.data[8:]), int(le.Uint32(req.data[16:]))
+	parent := n
+	if n.parent != nil {
+		parent = n.parent
+	}
+	var out []byte
+	for i := offset; i < uint64(len(n.names))+2; i++ {
+		name, entry, typ := ".", n, uint32(unix.DT_DIR)
+		switch {
+		case i == 1:
+			name, entry = "..", parent
+		case i > 1:
+			name = n.names[i-2]
+			entry = n.children[name]
+			if entry.children == nil {
+				typ = unix.DT_REG
+			}
+		}
+		// fuse_dirent: ino, offset of the next entry, name length, type,
+		// then the name padded to 8 bytes
+		length := (24 + len(name) + 7) &^ 7
+		if len(out)+length > size {
+			break
+		}
+		dirent := make([]byte, length)
+		le.PutUint64(dirent[0:], entry.id)
+		le.PutUint64(dirent[8:], i+1)
+		le.PutUint32(dirent[16:], uint32(len(name)))
+		le.PutUint32(dirent[20:], typ)
+		copy(dirent[24:], name)
+		out = append(out, dirent...)
+	}
+	m.reply(req, 0, out)
+}
+
+// statfs reports the size of the snapshot and that nothing is free.
+func (m *Mount) statfs(req request) {
+	le := binary.LittleEndian
+	var bytes int64
+	for _, f := range m.fs.snap.Files {
+		bytes += f.Size
+	}
+	out := make([]byte, 80)
+	le.PutUint64(out[0:], uint64(bytes+blockLen-1)/blockLen)
+	le.PutUint64(out[24:], uint64(len(m.fs.nodes)))
+	le.PutUint32(out[40:], blockLen)
+	le.PutUint32(out[44:], 255)
+	le.PutUint32(out[48:], blockLen)
+	m.reply(req, 0, out)
+}
+
+// reply answers req with errno, or with data if errno is 0.
+func (m *Mount) reply(req request, errno syscall.Errno, data []byte) {
+	out := make([]byte, outHeader, outHeader+len(data))
+	le := binary.LittleEndian
+	le.PutUint32(out[0:], uint32(outHeader+len(data)))
+	le.PutUint32(out[4:], uint32(-int32(errno)))
+	le.PutUint64(out[8:], req.unique)
+	out = append(out, data...)
+	if _, err := unix.Write(m.fd, out); err != nil && !errors.Is(err, unix.ENOENT) {
+		// ENOENT: the request was interrupted meanwhile
+		fmt.Fprintf(os.Stderr, "fuse: reply to request %d: %v\n", req.unique, err)
+	}
+}
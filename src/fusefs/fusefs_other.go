@@ -0,0 +1,23 @@
+//go:build !linux
+
+package fusefs
+
+// Mount is only available on Linux.
+func (fs *FS) Mount(mountpoint string) (*Mount, error) {
+	return nil, ErrUnsupported
+}
+
+// Mount is a mounted file system.
+type Mount struct {
+	Dir string // where it is mounted
+}
+
+// Serve is only available on Linux.
+func (m *Mount) Serve() error {
+	return ErrUnsupported
+}
+
+// Unmount is only available on Linux.
+func (m *Mount) Unmount() error {
+	return ErrUnsupported
+}
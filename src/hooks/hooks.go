@@ -0,0 +1,65 @@
+// Package hooks runs the commands the user set in the settings file to
+// be run around operations: before and after a build, after a
+// verification and when a tree's root hash changed, so MTFS can trigger
+// backups, notifications or CI steps. A hook is run by the shell in the
+// tree directory, with the operation described by MTFS_* variables in
+// its environment.
+package hooks
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"MTFS/oplog"
+	"MTFS/settings"
+)
+
+// The hooks, as named in MTFS_HOOK.
+const (
+	PreBuild   = "pre-build"   // before a build; failing stops the build
+	PostBuild  = "post-build"  // after a build, whether it succeeded or not
+	PostVerify = "post-verify" // after a verification
+	OnChange   = "on-change"   // after a build or daemon scan changed the root hash
+)
+
+// Context describes the operation a hook is run for.
+type Context struct {
+	Tree     string // tree directory or archive
+	Root     string // root hash, empty if the operation failed before one
+	Previous string // root hash before the operation, if known
+	Err      error  // why the operation failed, nil if it succeeded
+}
+
+// Command returns the command set for hook, "" if none is.
+func Command(hook string) string {
+	s, _, err := settings.Load()
+	if err != nil {
+		return ""
+	}
+	return s.Hooks[hook]
+}
+
+// Run runs command as hook for the operation c and returns what it
+// wrote to its standard output and error. The error is that of the
+// command, such as its exit status.
+func Run(hook, command string, c Context) ([]byte, error) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = c.Tree
+	if info, err := os.Stat(c.Tree); err != nil || !info.IsDir() {
+		cmd.Dir = filepath.Dir(c.Tree)
+	}
+	result, message := oplog.OK, ""
+	if c.Err != nil {
+		result, message = oplog.Failed, c.Err.Error()
+	}
+	cmd.Env = append(os.Environ(),
+		"MTFS_HOOK="+hook,
+		"MTFS_TREE="+c.Tree,
+		"MTFS_ROOT_HASH="+c.Root,
+		"MTFS_PREVIOUS_ROOT_HASH="+c.Previous,
+		"MTFS_RESULT="+result,
+		"MTFS_ERROR="+message,
+	)
+	return cmd.CombinedOutput()
+}
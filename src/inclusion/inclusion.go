@@ -0,0 +1,187 @@
+// Package inclusion proves that an entry is part of a tree: a proof holds
+// the hash of the entry and, for each directory from its parent up to the
+// root, the names and hashes of the other entries of the directory. The
+// verifier hashes its way up as a build does, so a proof checks against
+// a known root hash without the rest of the tree. Proofs are for trees of
+// the mtfs hash formats, whose directory hashes cover their children's
+// names and hashes.
+package inclusion
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"MTFS/manifest"
+)
+
+// Version is the version of the proofs written by this package.
+const Version = 1
+
+// Sibling is another entry of a directory on the path of a proof.
+type Sibling struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+}
+
+// Level is a directory on the path of a proof, with the entries next to
+// the one the path goes through, in name order.
+type Level struct {
+	Directory string    `json:"directory"` // relative to the root, "." for the root
+	Siblings  []Sibling `json:"siblings"`
+}
+
+// Proof shows that the entry at Path, with hash LeafHash, is part of the
+// tree with root hash RootHash.
+type Proof struct {
+	Version  int     `json:"version"`
+	Path     string  `json:"path"` // slash-separated, relative to the root
+	Type     string  `json:"type"`
+	Size     int64   `json:"size,omitempty"`
+	LeafHash string  `json:"leaf_hash"`
+	Levels   []Level `json:"levels"` // from the parent of the entry up to the root
+	RootHash string  `json:"root_hash"`
+	// HashFormat is the mtfs-ALGORITHM format of the tree, if not mtfs
+	HashFormat string `json:"hash_format,omitempty"`
+}
+
+// ErrNotIncluded is returned when a proof leads to another root hash than
+// the one it is checked against.
+var ErrNotIncluded = errors.New("the proof leads to another root hash")
+
+// Prove returns the proof that the entry at p, a slash-separated path
+// relative to root, is part of the exported tree root.
+func Prove(root *manifest.Node, p string) (*Proof, error) {
+	p = path.Clean(strings.TrimPrefix(p, "/"))
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return nil, fmt.Errorf("%q does not name an entry of the tree", p)
+	}
+
+	// Walk down, noting the directories passed
+	names := strings.Split(p, "/")
+	dirs := []*manifest.Node{root}
+	node := root
+	for i, name := range names {
+		child, ok := node.Children[name]
+		if !ok || child == nil {
+			return nil, fmt.Errorf("%s is not in the tree", path.Join(names[:i+1]...))
+		}
+		node = child
+		if i < len(names)-1 {
+			dirs = append(dirs, node)
+		}
+	}
+
+	proof := &Proof{Version: Version, Path: p, Type: node.Type, Size: node.Size, LeafHash: node.Hash, RootHash: root.Hash, HashFormat: root.HashFormat}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		level := Level{Directory: path.Join(append([]string{"."}, names[:i]...)...), Siblings: []Sibling{}}
+		for _, child := range dirs[i].SortedChildren() {
+			if child.Name != names[i] {
+				level.Siblings = append(level.Siblings, Sibling{Name: child.Name, Hash: child.Hash})
+			}
+		}
+		proof.Levels = append(proof.Levels, level)
+	}
+	// A tree hashed another way gives a proof leading elsewhere
+	if err := proof.Verify(root.Hash); err != nil {
+		return nil, fmt.Errorf("the tree is not hashed in an mtfs format, which proofs need: %w", err)
+	}
+	return proof, nil
+}
+
+// Root returns the root hash the levels of p lead to from its leaf hash.
+func (p *Proof) Root() (string, error) {
+	names := strings.Split(p.Path, "/")
+	if len(names) != len(p.Levels) {
+		return "", fmt.Errorf("proof of %s has %d levels, not %d", p.Path, len(p.Levels), len(names))
+	}
+	hash := p.LeafHash
+	for i, level := range p.Levels {
+		name := names[len(names)-1-i]
+		entries := append([]Sibling{{Name: name, Hash: hash}}, level.Siblings...)
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+		var combined []byte
+		for j, e := range entries {
+			if j > 0 && entries[j-1].Name == e.Name {
+				return "", fmt.Errorf("%s is listed twice in %s", e.Name, level.Directory)
+			}
+			combined = append(combined, e.Name+":"+e.Hash+";"...)
+		}
+		h := manifest.NewHash(p.HashFormat)
+		h.Write(combined)
+		hash = hex.EncodeToString(h.Sum(nil))
+	}
+	return hash, nil
+}
+
+// Verify checks that p leads from its leaf to rootHash, and that this is
+// the root hash it records.
+func (p *Proof) Verify(rootHash string) error {
+	got, err := p.Root()
+	if err != nil {
+		return err
+	}
+	if got != rootHash {
+		return fmt.Errorf("%w: %s, not %s", ErrNotIncluded, got, rootHash)
+	}
+	if p.RootHash != rootHash {
+		return fmt.Errorf("the proof records root hash %s, not %s", p.RootHash, rootHash)
+	}
+	return nil
+}
+
+// CheckFile checks that the file name has the content hash of the leaf
+// of p, so the proof is about that file.
+func (p *Proof) CheckFile(name string) error {
+	if p.Type != "file" {
+		return fmt.Errorf("the proof is about a %s, not a file", p.Type)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	check := manifest.NewContentCheck(info.Size(), p.HashFormat)
+	if _, err := io.Copy(check, f); err != nil {
+		return err
+	}
+	if !check.Matches(p.LeafHash) {
+		return fmt.Errorf("%s does not have the content hash %s of %s", name, p.LeafHash, p.Path)
+	}
+	return nil
+}
+
+// Save writes p as indented JSON to name.
+func (p *Proof) Save(name string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0o644)
+}
+
+// Load reads a proof written by Save.
+func Load(name string) (*Proof, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var p Proof
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if p.Version != Version {
+		return nil, fmt.Errorf("%s: proof version %d is not supported", name, p.Version)
+	}
+	return &p, nil
+}
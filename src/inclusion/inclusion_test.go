@@ -0,0 +1,178 @@
+package inclusion_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"MTFS/inclusion"
+	"MTFS/manifest"
+	"MTFS/pkg/merkle"
+)
+
+// buildTree builds n files, at depths 0 to 2 in turn, in format with the
+// Go engine and parses its export.
+func buildTree(t *testing.T, n int, format string) (string, *manifest.Node) {
+	t.Helper()
+	dir := t.TempDir()
+	for i := range n {
+		name := filepath.Join(dir, filepath.Join([]string{"", "d", "d/e"}[i%3]), fmt.Sprintf("f%d", i))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(fmt.Sprintf("file %d\n", i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o := merkle.DefaultOptions()
+	f, err := merkle.ParseHashFormat(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.HashFormat = f
+	b := merkle.NewBuilder()
+	b.Options = &o
+	tree, err := b.Build(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := manifest.Parse([]byte(tree.JSON()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, root
+}
+
+// flip changes the last digit of a hex hash.
+func flip(h string) string {
+	last := "0"
+	if strings.HasSuffix(h, "0") {
+		last = "1"
+	}
+	return h[:len(h)-1] + last
+}
+
+// clone copies p deeply enough to tamper with.
+func clone(p *inclusion.Proof) *inclusion.Proof {
+	c := *p
+	c.Levels = nil
+	for _, level := range p.Levels {
+		level.Siblings = slices.Clone(level.Siblings)
+		c.Levels = append(c.Levels, level)
+	}
+	return &c
+}
+
+// tamperings returns proofs each changed from p in one way, by what was
+// changed.
+func tamperings(p *inclusion.Proof) map[string]*inclusion.Proof {
+	out := map[string]*inclusion.Proof{}
+	add := func(name string, change func(c *inclusion.Proof)) {
+		c := clone(p)
+		change(c)
+		out[name] = c
+	}
+	add("leaf hash", func(c *inclusion.Proof) { c.LeafHash = flip(c.LeafHash) })
+	add("path", func(c *inclusion.Proof) { c.Path += "x" })
+	add("level dropped", func(c *inclusion.Proof) { c.Levels = c.Levels[:len(c.Levels)-1] })
+	add("level added", func(c *inclusion.Proof) {
+		c.Levels = append(c.Levels, inclusion.Level{Directory: "..", Siblings: []inclusion.Sibling{}})
+	})
+	add("hash format", func(c *inclusion.Proof) {
+		c.HashFormat = "mtfs-blake3"
+		if p.HashFormat == c.HashFormat {
+			c.HashFormat = "mtfs-blake2b"
+		}
+	})
+	for i, level := range p.Levels {
+		add(fmt.Sprintf("sibling added at %d", i), func(c *inclusion.Proof) {
+			c.Levels[i].Siblings = append(c.Levels[i].Siblings, inclusion.Sibling{Name: "forged", Hash: p.LeafHash})
+		})
+		for j := range level.Siblings {
+			add(fmt.Sprintf("sibling %d hash at %d", j, i), func(c *inclusion.Proof) {
+				c.Levels[i].Siblings[j].Hash = flip(c.Levels[i].Siblings[j].Hash)
+			})
+			add(fmt.Sprintf("sibling %d name at %d", j, i), func(c *inclusion.Proof) { c.Levels[i].Siblings[j].Name += "x" })
+			add(fmt.Sprintf("sibling %d dropped at %d", j, i), func(c *inclusion.Proof) {
+				c.Levels[i].Siblings = slices.Delete(c.Levels[i].Siblings, j, j+1)
+			})
+		}
+	}
+	return out
+}
+
+// Every entry of trees of several sizes and hash formats proves to be in
+// the tree, also once saved and loaded, and no change to a proof does.
+func TestProveVerify(t *testing.T) {
+	for _, format := range []string{"mtfs", "mtfs-sha512", "mtfs-blake2b", "mtfs-blake3"} {
+		for _, n := range []int{1, 2, 3, 7, 16} {
+			t.Run(fmt.Sprintf("%s/%d", format, n), func(t *testing.T) {
+				dir, root := buildTree(t, n, format)
+				root.Walk(func(p string, node *manifest.Node) error {
+					if node == root {
+						return nil
+					}
+					proof, err := inclusion.Prove(root, p)
+					if err != nil {
+						t.Fatalf("%s: %v", p, err)
+					}
+					name := filepath.Join(t.TempDir(), "proof.json")
+					if err := proof.Save(name); err != nil {
+						t.Fatal(err)
+					}
+					loaded, err := inclusion.Load(name)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err := loaded.Verify(root.Hash); err != nil {
+						t.Errorf("%s: %v", p, err)
+					}
+					if node.IsFile() {
+						if err := loaded.CheckFile(filepath.Join(dir, filepath.FromSlash(p))); err != nil {
+							t.Errorf("%s: %v", p, err)
+						}
+					}
+					if err := proof.Verify(flip(root.Hash)); err == nil {
+						t.Errorf("%s: verified against another root hash", p)
+					}
+					for what, tampered := range tamperings(proof) {
+						if err := tampered.Verify(root.Hash); err == nil {
+							t.Errorf("%s: verified with the %s tampered", p, what)
+						}
+					}
+					return nil
+				})
+			})
+		}
+	}
+}
+
+func TestProveRefuses(t *testing.T) {
+	dir, root := buildTree(t, 3, "mtfs")
+	for _, p := range []string{".", "/", "..", "../d", "missing", "d/missing", "f0/x"} {
+		if _, err := inclusion.Prove(root, p); err == nil {
+			t.Errorf("proved %q", p)
+		}
+	}
+	proof, err := inclusion.Prove(root, "d/f1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := proof.CheckFile(filepath.Join(dir, "f0")); err == nil {
+		t.Error("checked the proof of d/f1 against f0")
+	}
+	// Directories in the Git formats do not hash what proofs hold
+	_, git := buildTree(t, 3, "git")
+	if _, err := inclusion.Prove(git, "d/f1"); err == nil {
+		t.Error("proved an entry of a tree in the git format")
+	}
+	// A proof recording another root than the one it leads to
+	proof.RootHash = flip(proof.RootHash)
+	if err := proof.Verify(root.Hash); err == nil || errors.Is(err, inclusion.ErrNotIncluded) {
+		t.Errorf("got %v for a proof recording another root hash", err)
+	}
+}
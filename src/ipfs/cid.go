@@ -0,0 +1,112 @@
+// Package ipfs computes the content identifiers (CIDs) IPFS gives the files
+// and directories of a tree when it is added with `ipfs add -r`, so content
+// pinned to IPFS can be matched against the Merkle tree and nodes can be
+// looked up by CID.
+//
+// Files are split into 256 KiB chunks and linked in a balanced DAG of at
+// most 174 links per node, directories are plain UnixFS directories and
+// symbolic links are UnixFS symlinks, as with the defaults of Kubo.
+// Directories large enough to be sharded by IPFS are not supported.
+package ipfs
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Version selects the CID flavour, matching `ipfs add` (V0) or
+// `ipfs add --cid-version 1` (V1, which also stores raw leaves).
+type Version int
+
+const (
+	V0 Version = iota
+	V1
+)
+
+func (v Version) String() string {
+	if v == V1 {
+		return "v1"
+	}
+	return "v0"
+}
+
+// ParseVersion parses "v0" or "v1".
+func ParseVersion(s string) (Version, bool) {
+	switch strings.ToLower(s) {
+	case "v0":
+		return V0, true
+	case "v1":
+		return V1, true
+	}
+	return 0, false
+}
+
+// VersionOf reports which version a CID in text form has.
+func VersionOf(cid string) (Version, error) {
+	switch {
+	case len(cid) == 46 && strings.HasPrefix(cid, "Qm"):
+		return V0, nil
+	case len(cid) > 1 && cid[0] == 'b':
+		return V1, nil
+	}
+	return 0, errors.New("not a base58 CIDv0 or base32 CIDv1")
+}
+
+const (
+	codecRaw    = 0x55
+	codecDagPB  = 0x70
+	hashSHA256  = 0x12
+	base58Chars = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+)
+
+var base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// CID is a binary content identifier.
+type CID []byte
+
+// String returns the CID as IPFS prints it: base58btc for CIDv0, base32
+// with the multibase prefix "b" for CIDv1.
+func (c CID) String() string {
+	if len(c) > 0 && c[0] == hashSHA256 {
+		return base58(c)
+	}
+	return "b" + base32Lower.EncodeToString(c)
+}
+
+// newCID hashes block and returns its CID. CIDv0 is only defined for
+// dag-pb blocks.
+func newCID(v Version, codec uint64, block []byte) CID {
+	sum := sha256.Sum256(block)
+	var c []byte
+	if v == V1 {
+		c = binary.AppendUvarint(c, 1)
+		c = binary.AppendUvarint(c, codec)
+	}
+	c = append(c, hashSHA256, sha256.Size)
+	return append(c, sum[:]...)
+}
+
+func base58(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Chars[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Chars[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
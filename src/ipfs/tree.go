@@ -0,0 +1,98 @@
+package ipfs
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+
+	"MTFS/forensic"
+	"MTFS/manifest"
+)
+
+// Entry is the CID of one node of a tree.
+type Entry struct {
+	Path string // slash-separated and relative to the root, "." for the root
+	Type string
+	CID  string
+}
+
+// Compute reads the files of the tree described by root and returns the
+// CIDs of root and every node below it in depth-first, name-sorted order.
+// It fails if a file no longer matches its content hash, so every CID
+// describes content the Merkle tree vouches for.
+func Compute(tree string, root *manifest.Node, v Version) ([]Entry, error) {
+	cids := map[string]CID{}
+	if _, err := add(tree, ".", root, v, cids); err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	root.Walk(func(p string, node *manifest.Node) error {
+		entries = append(entries, Entry{Path: p, Type: node.Type, CID: cids[p].String()})
+		return nil
+	})
+	return entries, nil
+}
+
+// Find returns the entries with the given CID.
+func Find(entries []Entry, cid string) []Entry {
+	var found []Entry
+	for _, e := range entries {
+		if e.CID == cid {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func add(tree, p string, node *manifest.Node, v Version, cids map[string]CID) (link, error) {
+	var l link
+	switch node.Type {
+	case "directory":
+		var links []link
+		for _, child := range node.SortedChildren() {
+			cl, err := add(tree, path.Join(p, child.Name), child, v, cids)
+			if err != nil {
+				return link{}, err
+			}
+			cl.name = child.Name
+			links = append(links, cl)
+		}
+		l = newNode(v, links, directoryData())
+	case "symlink":
+		l = newNode(v, nil, symlinkData(node.Target))
+	case "file":
+		var err error
+		if l, err = addTreeFile(filepath.Join(tree, filepath.FromSlash(p)), node, v); err != nil {
+			return link{}, fmt.Errorf("%s: %w", p, err)
+		}
+	case "error":
+		return link{}, fmt.Errorf("%s could not be read when the tree was built (%s); rebuild it first", p, node.Error)
+	default:
+		return link{}, fmt.Errorf("%s: a %s cannot be stored in IPFS", p, node.Type)
+	}
+	cids[p] = l.cid
+	return l, nil
+}
+
+// addTreeFile adds the file at name and checks it against the content hash
+// of node, which is its hash in the algorithm of the tree's hash format or,
+// in the Git hash formats, its blob id.
+func addTreeFile(name string, node *manifest.Node, v Version) (link, error) {
+	f, err := forensic.Open(name)
+	if err != nil {
+		return link{}, err
+	}
+	defer f.Close()
+
+	check := node.NewContentCheck()
+	l, err := addFile(io.TeeReader(f, check), v)
+	if err != nil {
+		return link{}, err
+	}
+	if !check.Matches(node.ContentHash) {
+		return link{}, fmt.Errorf("changed since the tree was built")
+	}
+	return l, nil
+}
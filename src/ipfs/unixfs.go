@@ -0,0 +1,130 @@
+package ipfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// ChunkSize is the size of the file chunks, as with Kubo's default
+	// chunker.
+	ChunkSize = 256 * 1024
+	// MaxLinks is the most links a node of a file DAG has.
+	MaxLinks = 174
+
+	typeDirectory = 1
+	typeFile      = 2
+	typeSymlink   = 4
+)
+
+// link refers to a block and everything below it.
+type link struct {
+	name     string
+	cid      CID
+	tsize    uint64 // bytes of the block and all blocks below it
+	filesize uint64 // file bytes below the link, for file DAGs
+}
+
+// addFile splits r into chunks and returns the link to the root of its
+// balanced DAG. A file of a single chunk is its own root.
+func addFile(r io.Reader, v Version) (link, error) {
+	var level []link
+	buf := make([]byte, ChunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 || len(level) == 0 {
+			level = append(level, leaf(v, buf[:n]))
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return link{}, err
+		}
+	}
+
+	for len(level) > 1 {
+		var next []link
+		for i := 0; i < len(level); i += MaxLinks {
+			children := level[i:min(i+MaxLinks, len(level))]
+			blocksizes := make([]uint64, len(children))
+			for j, child := range children {
+				blocksizes[j] = child.filesize
+			}
+			next = append(next, newNode(v, children, fileData(nil, blocksizes)))
+		}
+		level = next
+	}
+	return level[0], nil
+}
+
+// leaf stores a chunk as a raw block for CIDv1 and as a UnixFS file node
+// for CIDv0.
+func leaf(v Version, chunk []byte) link {
+	if v == V1 {
+		return link{cid: newCID(V1, codecRaw, chunk), tsize: uint64(len(chunk)), filesize: uint64(len(chunk))}
+	}
+	l := newNode(V0, nil, fileData(chunk, nil))
+	l.filesize = uint64(len(chunk))
+	return l
+}
+
+// newNode encodes a dag-pb node and returns the link to it.
+func newNode(v Version, links []link, data []byte) link {
+	var block []byte
+	tsize := uint64(0)
+	for _, l := range links {
+		var pbLink []byte
+		pbLink = appendBytes(pbLink, 1, l.cid)
+		pbLink = appendBytes(pbLink, 2, []byte(l.name))
+		pbLink = appendVarint(pbLink, 3, l.tsize)
+		block = appendBytes(block, 2, pbLink)
+		tsize += l.tsize
+	}
+	block = appendBytes(block, 1, data)
+
+	l := link{cid: newCID(v, codecDagPB, block), tsize: tsize + uint64(len(block))}
+	for _, child := range links {
+		l.filesize += child.filesize
+	}
+	return l
+}
+
+// fileData encodes the UnixFS data of a file node holding data itself and
+// linking to children of the given sizes.
+func fileData(data []byte, blocksizes []uint64) []byte {
+	b := appendVarint(nil, 1, typeFile)
+	if len(data) > 0 {
+		b = appendBytes(b, 2, data)
+	}
+	size := uint64(len(data))
+	for _, s := range blocksizes {
+		size += s
+	}
+	b = appendVarint(b, 3, size)
+	for _, s := range blocksizes {
+		b = appendVarint(b, 4, s)
+	}
+	return b
+}
+
+func directoryData() []byte {
+	return appendVarint(nil, 1, typeDirectory)
+}
+
+func symlinkData(target string) []byte {
+	return appendBytes(appendVarint(nil, 1, typeSymlink), 2, []byte(target))
+}
+
+// appendVarint and appendBytes encode protobuf fields.
+func appendVarint(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendBytes(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
@@ -0,0 +1,228 @@
+// Package logging is the structured, leveled logger of the TUI. Every
+// event goes to a rotating JSON log file, and events at DisplayLevel and
+// above are also handed to the output pane, so what the user saw and what
+// the log holds come from the same records.
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// FileEnv names the environment variable overriding the log file;
+	// "off" disables it.
+	FileEnv = "MTFS_LOG"
+	// LevelEnv names the environment variable setting the lowest level
+	// written to the log file: debug (the default), info, warn or error.
+	LevelEnv = "MTFS_LOG_LEVEL"
+	// DisplayLevel is the lowest level shown in the output pane.
+	DisplayLevel = slog.LevelInfo
+	// MaxSize is the size at which the log file is rotated.
+	MaxSize = 10 << 20
+	// Backups is the number of rotated log files kept.
+	Backups = 3
+	// DisplayKey is the attribute holding an event as the output pane
+	// shows it, color tags included. It is left out of the log file.
+	DisplayKey = "display"
+)
+
+// Logger is a slog.Logger writing to the log file and a display.
+type Logger struct {
+	*slog.Logger
+	// Path is the log file, empty if logging to a file is off.
+	Path string
+
+	file        *RotatingFile
+	fileHandler slog.Handler
+}
+
+// DefaultPath returns the log file used unless FileEnv says otherwise.
+func DefaultPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "mtfs", "mtfs.log"), nil
+}
+
+// Open returns a logger for display, which may be nil. If the log file
+// cannot be opened the logger still feeds display, and the error says
+// why the file is missing.
+func Open(display slog.Handler) (*Logger, error) {
+	var handlers []slog.Handler
+	if display != nil {
+		handlers = append(handlers, display)
+	}
+	l := &Logger{}
+	file, err := l.openFile()
+	if file != nil {
+		handlers = append(handlers, file)
+	}
+	l.fileHandler = file
+	l.Logger = slog.New(fanout(handlers))
+	return l, err
+}
+
+// WithDisplay returns a logger writing to the log file of l and to
+// display instead of the display of l. Closing it leaves the file open;
+// l still owns it.
+func (l *Logger) WithDisplay(display slog.Handler) *Logger {
+	handlers := []slog.Handler{display}
+	if l.fileHandler != nil {
+		handlers = append(handlers, l.fileHandler)
+	}
+	return &Logger{Logger: slog.New(fanout(handlers)), Path: l.Path}
+}
+
+func (l *Logger) openFile() (slog.Handler, error) {
+	name := os.Getenv(FileEnv)
+	if name == "off" {
+		return nil, nil
+	}
+	level := slog.LevelDebug
+	if text := os.Getenv(LevelEnv); text != "" {
+		if err := level.UnmarshalText([]byte(text)); err != nil {
+			return nil, fmt.Errorf("%s: %w", LevelEnv, err)
+		}
+	}
+	if name == "" {
+		var err error
+		if name, err = DefaultPath(); err != nil {
+			return nil, fmt.Errorf("log file: %w", err)
+		}
+	}
+	f, err := OpenRotating(name, MaxSize, Backups)
+	if err != nil {
+		return nil, fmt.Errorf("log file: %w", err)
+	}
+	l.file, l.Path = f, name
+	return slog.NewJSONHandler(f, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == DisplayKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}), nil
+}
+
+// Close closes the log file.
+func (l *Logger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// DisplayHandler hands records at or above its level to a function that
+// shows them. Groups are flattened into the attribute keys.
+type DisplayHandler struct {
+	level  slog.Leveler
+	show   func(slog.Record)
+	attrs  []slog.Attr
+	prefix string
+}
+
+// NewDisplayHandler returns a handler showing records at level and above
+// with show.
+func NewDisplayHandler(level slog.Leveler, show func(slog.Record)) *DisplayHandler {
+	return &DisplayHandler{level: level, show: show}
+}
+
+func (h *DisplayHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *DisplayHandler) Handle(_ context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	out.AddAttrs(h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.prefixed(a))
+		return true
+	})
+	h.show(out)
+	return nil
+}
+
+func (h *DisplayHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		c.attrs = append(c.attrs, h.prefixed(a))
+	}
+	return &c
+}
+
+func (h *DisplayHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+func (h *DisplayHandler) prefixed(a slog.Attr) slog.Attr {
+	if h.prefix != "" {
+		a.Key = h.prefix + a.Key
+	}
+	return a
+}
+
+// Text renders the attributes of r as space-separated key=value pairs,
+// leaving out DisplayKey.
+func Text(r slog.Record) string {
+	var parts []string
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key != DisplayKey {
+			parts = append(parts, a.Key+"="+a.Value.String())
+		}
+		return true
+	})
+	return strings.Join(parts, " ")
+}
+
+// fanout passes records on to every handler that takes their level.
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range f {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range f {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := make(fanout, len(f))
+	for i, h := range f {
+		c[i] = h.WithAttrs(attrs)
+	}
+	return c
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	c := make(fanout, len(f))
+	for i, h := range f {
+		c[i] = h.WithGroup(name)
+	}
+	return c
+}
@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotatingFile is a log file that is moved aside once it reaches its
+// maximum size: name becomes name.1, name.1 becomes name.2 and so on,
+// and the oldest backup is dropped.
+type RotatingFile struct {
+	Name    string
+	MaxSize int64
+	Backups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotating opens name for appending, creating it and its directory
+// if needed.
+func OpenRotating(name string, maxSize int64, backups int) (*RotatingFile, error) {
+	r := &RotatingFile{Name: name, MaxSize: maxSize, Backups: backups}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return nil, err
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.Name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if p would take the file past its
+// maximum size. A record is never split across files.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && r.size+int64(len(p)) > r.MaxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+	for i := r.Backups; i > 0; i-- {
+		from := r.Name
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", r.Name, i-1)
+		}
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", r.Name, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if r.Backups == 0 {
+		if err := os.Remove(r.Name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return r.open()
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
@@ -1,18 +1,45 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode"
+
+	"MTFS/logging"
+	"MTFS/pkg/merkle"
+	"MTFS/remote"
+	"MTFS/settings"
 	ui "MTFS/ui"
 
 	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/term"
 )
 
+// launcher lists the options of the landing screen. Each but Quit opens
+// the workspaces and starts the first one on its task.
+var launcher = []struct {
+	label string
+	key   rune
+	start func(*ui.Workspaces)
+}{
+	{"Open recent tree", 'o', (*ui.Workspaces).OpenRecent},
+	{"New tree", 'n', (*ui.Workspaces).NewTree},
+	{"Attach to daemon", 'a', (*ui.Workspaces).AttachDaemon},
+	{"Settings", 's', (*ui.Workspaces).EditSettings},
+	{"Quit", 'q', nil},
+}
+
 type App struct {
-	screen tcell.Screen
-	quit   chan struct{}
-	focus  int
+	screen      tcell.Screen
+	quit        chan struct{}
+	focus       int
+	backendArgs []string
 }
 
 func NewApp() *App {
@@ -39,15 +66,34 @@ func (a *App) Init() error {
 	return nil
 }
 
-func (a *App) drawText(x, y int, text string, style tcell.Style) {
-	for i, r := range text {
-		a.screen.SetContent(x+i, y, r, nil, style)
+// drawText draws text from column x and returns the columns it took. Wide
+// characters take two columns and zero-width ones (combining marks,
+// variation selectors, joiners) are drawn in the cell before them, the way
+// tcell measures them.
+func (a *App) drawText(x, y int, text string, style tcell.Style) int {
+	start := x
+	var cell []rune
+	flush := func() {
+		if len(cell) > 0 {
+			a.screen.SetContent(x, y, cell[0], cell[1:], style)
+			x += max(runewidth.RuneWidth(cell[0]), 1)
+		}
 	}
+	for _, r := range text {
+		if len(cell) > 0 && runewidth.RuneWidth(r) == 0 {
+			cell = append(cell, r)
+			continue
+		}
+		flush()
+		cell = []rune{r}
+	}
+	flush()
+	return x - start
 }
 
 func (a *App) drawCenteredText(y int, text string, style tcell.Style) {
 	width, _ := a.screen.Size()
-	x := (width - len(text)) / 2
+	x := (width - runewidth.StringWidth(text)) / 2
 	if x < 0 {
 		x = 0
 	}
@@ -55,27 +101,31 @@ func (a *App) drawCenteredText(y int, text string, style tcell.Style) {
 }
 
 func (a *App) drawButton(x, y, width int, text string, focused bool) {
-	style := tcell.StyleDefault.Background(tcell.ColorLime).Foreground(tcell.ColorBlack)
+	style := tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorLime)
 	if focused {
-		style = style.Bold(true).Reverse(true)
+		style = tcell.StyleDefault.Background(tcell.ColorLime).Foreground(tcell.ColorBlack).Bold(true)
 	}
 
 	for i := 0; i < width; i++ {
 		a.screen.SetContent(x+i, y, ' ', nil, style)
 	}
 
-	textX := x + (width-len(text))/2
+	textX := x + (width-runewidth.StringWidth(text))/2
 	a.drawText(textX, y, text, style)
 }
 
-func (a *App) drawCenteredButton(y int, text string, focused bool) {
+// drawMenu draws the launcher options one under the other from y, as
+// buttons as wide as the widest, with the focused one highlighted.
+func (a *App) drawMenu(y int) {
 	width, _ := a.screen.Size()
-	buttonWidth := len(text) + 4 // Add padding
-	x := (width - buttonWidth) / 2
-	if x < 0 {
-		x = 0
+	buttonWidth := 0
+	for _, item := range launcher {
+		buttonWidth = max(buttonWidth, runewidth.StringWidth(item.label)+4) // Add padding
+	}
+	x := max((width-buttonWidth)/2, 0)
+	for i, item := range launcher {
+		a.drawButton(x, y+i, buttonWidth, item.label, i == a.focus)
 	}
-	a.drawButton(x, y, buttonWidth, text, focused)
 }
 
 func (a *App) draw() {
@@ -111,7 +161,7 @@ func (a *App) draw() {
 		"MERKLE TREE based FILE SYSTEM",
 	}
 
-	totalContentHeight := len(titleLines) + 2 + 1 + 2 + 1 + 2 + 1 // title + spacing + subtitle + spacing + button + spacing + help
+	totalContentHeight := len(titleLines) + 2 + 1 + 2 + len(launcher) + 1 + 1 // title + spacing + subtitle + spacing + menu + spacing + help
 	startY := (height - totalContentHeight) / 2
 	if startY < 0 {
 		startY = 0
@@ -126,12 +176,12 @@ func (a *App) draw() {
 	subtitleStyle := tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorGray).Italic(true)
 	a.drawCenteredText(subtitleY, "A cryptographically secure file system", subtitleStyle)
 
-	buttonY := subtitleY + 3
-	a.drawCenteredButton(buttonY, "🚀 GET STARTED", a.focus == 0)
+	menuY := subtitleY + 3
+	a.drawMenu(menuY)
 
-	helpY := buttonY + 2
+	helpY := menuY + len(launcher) + 1
 	helpStyle := tcell.StyleDefault.Background(tcell.ColorBlack).Foreground(tcell.ColorDarkGray)
-	a.drawCenteredText(helpY, "Press ENTER to get started • Press q or ESC to quit", helpStyle)
+	a.drawCenteredText(helpY, "↑/↓ or Tab to choose • ENTER to open • ESC to quit", helpStyle)
 
 	a.screen.Show()
 }
@@ -142,17 +192,21 @@ func (a *App) handleEvent(ev tcell.Event) {
 		switch ev.Key() {
 		case tcell.KeyEscape, tcell.KeyCtrlC:
 			close(a.quit)
+		case tcell.KeyUp, tcell.KeyLeft, tcell.KeyBacktab:
+			a.focus = (a.focus + len(launcher) - 1) % len(launcher)
+		case tcell.KeyDown, tcell.KeyRight, tcell.KeyTab:
+			a.focus = (a.focus + 1) % len(launcher)
 		case tcell.KeyEnter:
-			if a.focus == 0 {
-				ui.NewMerkleTUI().Run()
-			}
+			a.choose(a.focus)
 		case tcell.KeyRune:
-			switch ev.Rune() {
-			case 'q', 'Q':
-				close(a.quit)
-			case ' ':
-				if a.focus == 0 {
-					close(a.quit)
+			if ev.Rune() == ' ' {
+				a.choose(a.focus)
+				return
+			}
+			for i, item := range launcher {
+				if unicode.ToLower(ev.Rune()) == item.key {
+					a.focus = i
+					a.choose(i)
 				}
 			}
 		}
@@ -161,8 +215,36 @@ func (a *App) handleEvent(ev tcell.Event) {
 	}
 }
 
+// choose runs launcher option i.
+func (a *App) choose(i int) {
+	start := launcher[i].start
+	if start == nil {
+		close(a.quit)
+		return
+	}
+	workspaces := ui.NewWorkspaces(a.backendArgs...)
+	// The TUI runs inside this screen, so a crash report is
+	// only readable once both are gone
+	workspaces.OnCrash(func() {
+		a.screen.Fini()
+		fmt.Print("\033[0m\033[2J\033[H")
+	})
+	start(workspaces)
+	// Both screens would read the keys otherwise
+	a.screen.Suspend()
+	defer a.screen.Resume()
+	workspaces.Run()
+}
+
 func (a *App) Run() {
 	defer a.screen.Fini()
+	// Put the terminal back before the panic is printed
+	defer func() {
+		if p := recover(); p != nil {
+			a.screen.Fini()
+			panic(p)
+		}
+	}()
 
 	a.draw()
 
@@ -180,15 +262,60 @@ func (a *App) Run() {
 	}
 }
 
+// runPlain runs the text menu of the engine on the standard streams, with
+// the walk options in backendArgs, and returns its exit status.
+func runPlain(backendArgs []string) int {
+	if remote.UseGoEngine() {
+		return merkle.RunMenu(append([]string{os.Args[0]}, backendArgs...), os.Stdin, os.Stdout, os.Stderr)
+	}
+	cmd := exec.Command(remote.BackendPath(), backendArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	var exit *exec.ExitError
+	if err := cmd.Run(); errors.As(err, &exit) {
+		return exit.ExitCode()
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
 func main() {
+	// The settings are defaults for the MTFS_* variables; the TUI runs the
+	// setup wizard when there are none yet
+	if s, _, err := settings.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: settings not loaded: %v\n", err)
+	} else {
+		settings.Apply(s)
+	}
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
+	backendArgs, err := parseWalkFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		os.Exit(2)
+	}
+
+	// Piped, under cron or in CI there is nothing to draw on, so the text
+	// menu of the backend takes the commands from standard input instead
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		os.Exit(runPlain(backendArgs))
+	}
+
 	app := NewApp()
+	app.backendArgs = backendArgs
 
 	if err := app.Init(); err != nil {
-		log.Fatalf("Failed to initialize: %v", err)
+		logger, _ := logging.Open(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logging.DisplayLevel}))
+		logger.Error("failed to initialize the screen", "err", err)
+		logger.Close()
+		os.Exit(1)
 	}
 
 	fmt.Print("\033[40m\033[2J\033[H")
 	defer fmt.Print("\033[0m\033[2J\033[H")
 
 	app.Run()
-}
\ No newline at end of file
+}
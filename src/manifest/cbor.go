@@ -0,0 +1,401 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// CBOR exports (RFC 8949) are a map of the root's name to its node, each
+// node a map with the keys of the JSON export, hashes as byte strings.
+// They start with the self-describe tag and are written in the core
+// deterministic encoding, so a tree always encodes to the same bytes.
+
+// cborMagic is the self-describe tag 55799.
+var cborMagic = []byte{0xd9, 0xd9, 0xf7}
+
+// CBOR major types.
+const (
+	cborUint  = 0
+	cborNeg   = 1
+	cborBytes = 2
+	cborText  = 3
+	cborArray = 4
+	cborMap   = 5
+	cborTag   = 6
+)
+
+// cborHead appends the head of an item of major type major and argument n.
+func cborHead(b []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= 0xff:
+		return append(b, major|24, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major|27), n)
+}
+
+func cborString(b []byte, s string) []byte {
+	return append(cborHead(b, cborText, uint64(len(s))), s...)
+}
+
+// cborPairs appends a map of encoded keys and values, ordered by key as the
+// deterministic encoding requires.
+func cborPairs(b []byte, pairs [][2][]byte) []byte {
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i][0], pairs[j][0]) < 0 })
+	b = cborHead(b, cborMap, uint64(len(pairs)))
+	for _, p := range pairs {
+		b = append(append(b, p[0]...), p[1]...)
+	}
+	return b
+}
+
+func (n *Node) encodeCBOR() ([]byte, error) {
+	node, err := n.cbor()
+	if err != nil {
+		return nil, err
+	}
+	b := append([]byte(nil), cborMagic...)
+	return cborPairs(b, [][2][]byte{{cborString(nil, n.Name), node}}), nil
+}
+
+func (n *Node) cbor() ([]byte, error) {
+	var pairs [][2][]byte
+	text := func(key, value string) {
+		if value != "" {
+			pairs = append(pairs, [2][]byte{cborString(nil, key), cborString(nil, value)})
+		}
+	}
+	hash := func(key, value string) error {
+		if value == "" {
+			return nil
+		}
+		h, err := hashBytes(key, value)
+		if err != nil {
+			return err
+		}
+		pairs = append(pairs, [2][]byte{cborString(nil, key), append(cborHead(nil, cborBytes, uint64(len(h))), h...)})
+		return nil
+	}
+	number := func(key string, value int64) {
+		switch {
+		case value > 0:
+			pairs = append(pairs, [2][]byte{cborString(nil, key), cborHead(nil, cborUint, uint64(value))})
+		case value < 0:
+			pairs = append(pairs, [2][]byte{cborString(nil, key), cborHead(nil, cborNeg, uint64(-1-value))})
+		}
+	}
+
+	text("type", n.Type)
+	if err := hash("hash", n.Hash); err != nil {
+		return nil, err
+	}
+	number("size", n.Size)
+	number("chunks", int64(n.Chunks))
+	if err := hash("content_hash", n.ContentHash); err != nil {
+		return nil, err
+	}
+	text("target", n.Target)
+	text("device", n.Device)
+	text("error", n.Error)
+	text("hardlink_of", n.HardlinkOf)
+	if err := hash("xattrs_hash", n.XattrsHash); err != nil {
+		return nil, err
+	}
+	textMap := func(key string, m map[string]string) {
+		if len(m) > 0 {
+			var entries [][2][]byte
+			for name, value := range m {
+				entries = append(entries, [2][]byte{cborString(nil, name), cborString(nil, value)})
+			}
+			pairs = append(pairs, [2][]byte{cborString(nil, key), cborPairs(nil, entries)})
+		}
+	}
+	textMap("xattrs", n.Xattrs)
+	if err := hash("metadata_hash", n.MetadataHash); err != nil {
+		return nil, err
+	}
+	textMap("metadata", n.Metadata)
+	if len(n.Children) > 0 {
+		var children [][2][]byte
+		for name, child := range n.Children {
+			c, err := child.cbor()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			children = append(children, [2][]byte{cborString(nil, name), c})
+		}
+		pairs = append(pairs, [2][]byte{cborString(nil, "children"), cborPairs(nil, children)})
+	}
+	text("hash_format", n.HashFormat)
+	text("metadata_fields", n.MetadataFields)
+	number("max_depth", int64(n.MaxDepth))
+	number("min_file_size", n.MinFileSize)
+	number("max_file_size", n.MaxFileSize)
+	return cborPairs(nil, pairs), nil
+}
+
+// cborDecoder reads the items of a CBOR export. Only definite lengths are
+// accepted, as the deterministic encoding has no others.
+type cborDecoder struct {
+	data []byte
+}
+
+func (d *cborDecoder) head() (major byte, n uint64, err error) {
+	if len(d.data) == 0 {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	major, info := d.data[0]>>5, d.data[0]&0x1f
+	d.data = d.data[1:]
+	size := 0
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info <= 27:
+		size = 1 << (info - 24)
+	default:
+		return 0, 0, fmt.Errorf("unsupported item 0x%02x", major<<5|info)
+	}
+	if len(d.data) < size {
+		return 0, 0, errors.New("unexpected end of data")
+	}
+	for _, c := range d.data[:size] {
+		n = n<<8 | uint64(c)
+	}
+	d.data = d.data[size:]
+	return major, n, nil
+}
+
+// expect reads the head of an item of major type want.
+func (d *cborDecoder) expect(want byte, what string) (uint64, error) {
+	major, n, err := d.head()
+	if err != nil {
+		return 0, err
+	}
+	if major != want {
+		return 0, fmt.Errorf("%s is of major type %d, not %d", what, major, want)
+	}
+	return n, nil
+}
+
+func (d *cborDecoder) bytes(major byte, what string) ([]byte, error) {
+	n, err := d.expect(major, what)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(d.data)) {
+		return nil, errors.New("unexpected end of data")
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+func (d *cborDecoder) text(what string) (string, error) {
+	b, err := d.bytes(cborText, what)
+	return string(b), err
+}
+
+func (d *cborDecoder) number(what string) (int64, error) {
+	major, n, err := d.head()
+	switch {
+	case err != nil:
+		return 0, err
+	case n > 1<<63-1:
+		return 0, fmt.Errorf("%s is out of range", what)
+	case major == cborUint:
+		return int64(n), nil
+	case major == cborNeg:
+		return -1 - int64(n), nil
+	}
+	return 0, fmt.Errorf("%s is not an integer", what)
+}
+
+// mapLen reads the head of a map and checks it can hold its pairs.
+func (d *cborDecoder) mapLen(what string) (int, error) {
+	n, err := d.expect(cborMap, what)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.data)/2) {
+		return 0, errors.New("unexpected end of data")
+	}
+	return int(n), nil
+}
+
+func parseCBOR(data []byte) (*Node, error) {
+	d := &cborDecoder{data: data[len(cborMagic):]}
+	n, err := d.mapLen("the export")
+	if err != nil {
+		return nil, err
+	}
+	if n != 1 {
+		return nil, fmt.Errorf("expected one root node, found %d", n)
+	}
+	name, err := d.text("the root name")
+	if err != nil {
+		return nil, err
+	}
+	root, err := d.node(0)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.data) > 0 {
+		return nil, errors.New("data after the root node")
+	}
+	root.setNames(name, root.HashFormat)
+	return root, nil
+}
+
+func (d *cborDecoder) node(depth int) (*Node, error) {
+	if depth > maxDepth {
+		return nil, errors.New("nodes nested too deeply")
+	}
+	n, err := d.mapLen("a node")
+	if err != nil {
+		return nil, err
+	}
+	node := &Node{}
+	for range n {
+		key, err := d.text("a node key")
+		if err != nil {
+			return nil, err
+		}
+		var h []byte
+		switch key {
+		case "type":
+			node.Type, err = d.text(key)
+		case "hash":
+			h, err = d.bytes(cborBytes, key)
+			node.Hash = hashString(h)
+		case "size":
+			node.Size, err = d.number(key)
+		case "chunks":
+			var chunks int64
+			chunks, err = d.number(key)
+			node.Chunks = int(chunks)
+		case "content_hash":
+			h, err = d.bytes(cborBytes, key)
+			node.ContentHash = hashString(h)
+		case "target":
+			node.Target, err = d.text(key)
+		case "device":
+			node.Device, err = d.text(key)
+		case "error":
+			node.Error, err = d.text(key)
+		case "hardlink_of":
+			node.HardlinkOf, err = d.text(key)
+		case "xattrs_hash":
+			h, err = d.bytes(cborBytes, key)
+			node.XattrsHash = hashString(h)
+		case "xattrs":
+			node.Xattrs, err = d.textMap(key, "an xattr")
+		case "metadata_hash":
+			h, err = d.bytes(cborBytes, key)
+			node.MetadataHash = hashString(h)
+		case "metadata":
+			node.Metadata, err = d.textMap(key, "a metadata field")
+		case "children":
+			node.Children, err = d.children(depth)
+		case "hash_format":
+			node.HashFormat, err = d.text(key)
+		case "metadata_fields":
+			node.MetadataFields, err = d.text(key)
+		case "max_depth":
+			var depth int64
+			depth, err = d.number(key)
+			node.MaxDepth = int(depth)
+		case "min_file_size":
+			node.MinFileSize, err = d.number(key)
+		case "max_file_size":
+			node.MaxFileSize, err = d.number(key)
+		default:
+			// Added to the schema since; left out
+			err = d.skip(depth)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// textMap reads a map of text, such as the xattrs of a node, each entry
+// of which is what.
+func (d *cborDecoder) textMap(key, what string) (map[string]string, error) {
+	n, err := d.mapLen(key)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, n)
+	for range n {
+		name, err := d.text(what + " name")
+		if err != nil {
+			return nil, err
+		}
+		if m[name], err = d.text(what + " value"); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (d *cborDecoder) children(depth int) (map[string]*Node, error) {
+	n, err := d.mapLen("children")
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[string]*Node, n)
+	for range n {
+		name, err := d.text("a child name")
+		if err != nil {
+			return nil, err
+		}
+		if children[name], err = d.node(depth + 1); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return children, nil
+}
+
+// skip reads past an item of any type.
+func (d *cborDecoder) skip(depth int) error {
+	if depth > maxDepth {
+		return errors.New("items nested too deeply")
+	}
+	major, n, err := d.head()
+	if err != nil {
+		return err
+	}
+	items := uint64(0)
+	switch major {
+	case cborBytes, cborText:
+		if n > uint64(len(d.data)) {
+			return errors.New("unexpected end of data")
+		}
+		d.data = d.data[n:]
+	case cborArray:
+		items = n
+	case cborMap:
+		items = 2 * n
+	case cborTag:
+		items = 1
+	}
+	if items > uint64(len(d.data)) {
+		return errors.New("unexpected end of data")
+	}
+	for range items {
+		if err := d.skip(depth + 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package manifest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ChecksumTools are the checksum tools whose lines WriteChecksums writes
+// for some hash format.
+var ChecksumTools = []string{"sha256sum", "sha512sum", "b2sum", "b3sum"}
+
+// ChecksumTool returns the checksum tool of the coreutils (or b2sum,
+// b3sum) that hashes contents as a tree in hashFormat does, "" if there is
+// none: the content hashes of the Git formats are blob ids.
+func ChecksumTool(hashFormat string) string {
+	switch hashFormat {
+	case "", "mtfs":
+		return "sha256sum"
+	case "mtfs-sha512":
+		return "sha512sum"
+	case "mtfs-blake2b":
+		return "b2sum"
+	case "mtfs-blake3":
+		return "b3sum"
+	}
+	return ""
+}
+
+// WriteChecksums writes the content hash of every file below n as
+// sha256sum (or the tool ChecksumTool names) writes it, "<hash>  <path>"
+// with paths relative to n, so the tool checks them with -c from the
+// tree's directory. Names with a backslash or newline are escaped as the
+// coreutils escape them, on lines starting with a backslash.
+func (n *Node) WriteChecksums(w io.Writer) error {
+	if ChecksumTool(n.format) == "" {
+		return fmt.Errorf("the content hashes of %s trees are Git blob ids, which no checksum tool writes", n.format)
+	}
+	bw := bufio.NewWriter(w)
+	err := n.Walk(func(p string, node *Node) error {
+		if !node.IsFile() {
+			return nil
+		}
+		if node.ContentHash == "" {
+			return fmt.Errorf("%s: no content hash in the export", p)
+		}
+		if strings.ContainsAny(p, "\\\n\r") {
+			p = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(p)
+			bw.WriteString(`\`)
+		}
+		_, err := fmt.Fprintf(bw, "%s  %s\n", node.ContentHash, p)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
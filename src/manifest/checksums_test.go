@@ -0,0 +1,105 @@
+package manifest_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"MTFS/manifest"
+	"MTFS/pkg/merkle"
+
+	"golang.org/x/crypto/blake2b"
+	"lukechampine.com/blake3"
+)
+
+// buildExport builds a tree of files in format with the Go engine and
+// parses its export.
+func buildExport(t *testing.T, format string, files map[string]string) (string, *manifest.Node) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o := merkle.DefaultOptions()
+	f, err := merkle.ParseHashFormat(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.HashFormat = f
+	b := merkle.NewBuilder()
+	b.Options = &o
+	tree, err := b.Build(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := manifest.Parse([]byte(tree.JSON()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, root
+}
+
+// The checksum lines must be those the tool named for a format writes,
+// whatever algorithm the tree hashed with.
+func TestWriteChecksums(t *testing.T) {
+	content := []byte("hi\n")
+	tests := []struct {
+		format, tool string
+		sum          func([]byte) []byte
+	}{
+		{"mtfs", "sha256sum", func(b []byte) []byte { s := sha256.Sum256(b); return s[:] }},
+		{"mtfs-sha512", "sha512sum", func(b []byte) []byte { s := sha512.Sum512(b); return s[:] }},
+		{"mtfs-blake2b", "b2sum", func(b []byte) []byte { s := blake2b.Sum512(b); return s[:] }},
+		{"mtfs-blake3", "b3sum", func(b []byte) []byte { s := blake3.Sum256(b); return s[:] }},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			_, root := buildExport(t, test.format, map[string]string{"a": string(content)})
+			if tool := manifest.ChecksumTool(root.HashFormat); tool != test.tool {
+				t.Errorf("ChecksumTool(%q) = %q, want %q", root.HashFormat, tool, test.tool)
+			}
+			var b bytes.Buffer
+			if err := root.WriteChecksums(&b); err != nil {
+				t.Fatal(err)
+			}
+			if want := hex.EncodeToString(test.sum(content)) + "  a\n"; b.String() != want {
+				t.Errorf("got %q, want %q", b.String(), want)
+			}
+		})
+	}
+}
+
+func TestWriteChecksumsRefusesGitFormats(t *testing.T) {
+	for _, format := range []string{"git", "git-sha256"} {
+		_, root := buildExport(t, format, map[string]string{"a": "hi\n"})
+		if manifest.ChecksumTool(root.HashFormat) != "" {
+			t.Errorf("%s: a checksum tool writes blob ids", format)
+		}
+		if err := root.WriteChecksums(new(bytes.Buffer)); err == nil || !strings.Contains(err.Error(), "blob ids") {
+			t.Errorf("%s: got %v, want blob ids refused", format, err)
+		}
+	}
+}
+
+// A content check matches the content the tree hashed in every format,
+// and nothing else of the same size.
+func TestContentCheck(t *testing.T) {
+	for _, format := range []string{"mtfs", "git", "git-sha256", "mtfs-sha512", "mtfs-blake2b", "mtfs-blake3"} {
+		_, root := buildExport(t, format, map[string]string{"a": "hi\n"})
+		a := root.Children["a"]
+		for content, want := range map[string]bool{"hi\n": true, "HO\n": false} {
+			check := a.NewContentCheck()
+			check.Write([]byte(content))
+			if got := check.Matches(a.ContentHash); got != want {
+				t.Errorf("%s: %q matches %v, want %v", format, content, got, want)
+			}
+		}
+	}
+}
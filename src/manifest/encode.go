@@ -0,0 +1,79 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Export formats. JSON is what the engines write; CBOR and protobuf carry
+// the same tree with hashes as bytes, for large trees. The protobuf schema
+// is export.proto.
+const (
+	FormatJSON     = "json"
+	FormatCBOR     = "cbor"
+	FormatProtobuf = "protobuf"
+)
+
+// Formats lists the export formats Encode writes and Parse reads.
+var Formats = []string{FormatJSON, FormatCBOR, FormatProtobuf}
+
+// maxDepth bounds the nesting of a binary export, deeper than any path can
+// go, so a forged one cannot exhaust the stack.
+const maxDepth = 4096
+
+// FormatOf returns the export format a file is named for: CBOR for .cbor,
+// protobuf for .pb and .binpb, and JSON otherwise.
+func FormatOf(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".cbor":
+		return FormatCBOR
+	case ".pb", ".binpb":
+		return FormatProtobuf
+	}
+	return FormatJSON
+}
+
+// detect returns the format of an export by its first bytes: the CBOR
+// self-describe tag, or the protobuf key of the root's name, which is a
+// newline to JSON.
+func detect(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, cborMagic):
+		return FormatCBOR
+	case len(data) > 0 && data[0] == protoNameKey && !bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("{")):
+		return FormatProtobuf
+	}
+	return FormatJSON
+}
+
+// Encode returns the export of the tree n is the root of in format.
+func (n *Node) Encode(format string) ([]byte, error) {
+	switch format {
+	case FormatJSON:
+		data, err := json.MarshalIndent(map[string]*Node{n.Name: n}, "", "  ")
+		return append(data, '\n'), err
+	case FormatCBOR:
+		return n.encodeCBOR()
+	case FormatProtobuf:
+		return n.encodeProtobuf()
+	}
+	return nil, fmt.Errorf("unknown export format %q (%s)", format, strings.Join(Formats, ", "))
+}
+
+// hashBytes and hashString convert the hex hashes of nodes to the bytes
+// the binary formats hold and back.
+func hashBytes(field, h string) ([]byte, error) {
+	b, err := hex.DecodeString(h)
+	if err != nil || hex.EncodeToString(b) != h {
+		return nil, fmt.Errorf("%s %q is not a lower-case hex hash", field, h)
+	}
+	return b, nil
+}
+
+func hashString(b []byte) string {
+	return hex.EncodeToString(b)
+}
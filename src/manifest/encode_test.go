@@ -0,0 +1,162 @@
+package manifest_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"MTFS/manifest"
+)
+
+// fullExport has every field of a node set somewhere.
+const fullExport = `{
+  "tree": {
+    "type": "directory",
+    "hash": "e5c0249172b3e324f6927898116a20f02c918bf2e8eaf8a59962854aaa30be5d",
+    "metadata_hash": "9b3938de2fc2a325d8ad260e0bde4cfb90d8d699",
+    "metadata": {"mode": "0755", "owner": "0:0"},
+    "metadata_fields": "mode,owner",
+    "max_depth": 3,
+    "min_file_size": 1,
+    "max_file_size": 1099511627776,
+    "children": {
+      "a": {
+        "type": "file",
+        "hash": "98ea6e4f216f2fb4b69fff9b3a44842c38686ca685f3f55dc48c5d3fb1107be4",
+        "size": 3,
+        "chunks": 1,
+        "content_hash": "98ea6e4f216f2fb4b69fff9b3a44842c38686ca685f3f55dc48c5d3fb1107be4",
+        "xattrs_hash": "1f60ce96dcc7c847e1b58e37cbe4b63fbc4e9669d03997abc3ab44728bd4bdc3",
+        "xattrs": {"user.note": "6869"}
+      },
+      "b": {"type": "file", "hash": "98ea6e4f216f2fb4b69fff9b3a44842c38686ca685f3f55dc48c5d3fb1107be4", "size": 3, "hardlink_of": "a"},
+      "dev": {"type": "char_device", "hash": "00", "device": "1:3"},
+      "link": {"type": "symlink", "hash": "01", "target": "a → ü"},
+      "locked": {"type": "error", "hash": "02", "error": "permission denied"},
+      "sub": {"type": "directory", "hash": "03", "children": {"empty": {"type": "directory", "hash": "04"}}}
+    }
+  }
+}
+`
+
+// An export read back from every format is the tree it was written from,
+// and encodes to the same bytes again.
+func TestEncodeRoundTrip(t *testing.T) {
+	_, built := buildExport(t, "git-sha256", map[string]string{"a": "hi\n", "b": ""})
+	full, err := manifest.Parse([]byte(fullExport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, root := range map[string]*manifest.Node{"built": built, "full": full} {
+		for _, format := range manifest.Formats {
+			t.Run(name+"/"+format, func(t *testing.T) {
+				data, err := root.Encode(format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				again, err := manifest.Parse(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(again, root) {
+					t.Errorf("read back %+v\nwant %+v", again, root)
+				}
+				if data2, err := again.Encode(format); err != nil || !bytes.Equal(data2, data) {
+					t.Errorf("encoded again to other bytes: %v", err)
+				}
+			})
+		}
+	}
+}
+
+// Every truncation of a binary export fails to parse.
+func TestParseTruncated(t *testing.T) {
+	root, err := manifest.Parse([]byte(fullExport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{manifest.FormatCBOR, manifest.FormatProtobuf} {
+		data, err := root.Encode(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := range len(data) {
+			if _, err := manifest.Parse(data[:n]); err == nil {
+				t.Errorf("%s: parsed the first %d of %d bytes", format, n, len(data))
+			}
+		}
+	}
+}
+
+// Lengths past the end of the data, however large, and nesting deeper
+// than any path are refused rather than allocated or recursed into.
+func TestParseOversized(t *testing.T) {
+	cbor := []byte{0xd9, 0xd9, 0xf7}
+	huge := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"cbor map", append(append(cbor, 0xbb), huge...), "unexpected end of data"},
+		{"cbor name", append(append(cbor, 0xa1, 0x7b), huge...), "unexpected end of data"},
+		{"cbor hash", append(append(cbor, 0xa1, 0x61, 't', 0xa1, 0x64, 'h', 'a', 's', 'h', 0x5b), huge...), "unexpected end of data"},
+		{"cbor size", append(append(cbor, 0xa1, 0x61, 't', 0xa1, 0x64, 's', 'i', 'z', 'e', 0x1b), huge...), "out of range"},
+		{"protobuf name", []byte{0x0a, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 't'}, "unexpected end of data"},
+		{"protobuf node", []byte{0x0a, 0x01, 't', 0x12, 0x80, 0x80, 0x80, 0x80, 0x08, 0x0a}, "unexpected end of data"},
+		{"protobuf varint", []byte{0x0a, 0x01, 't', 0x12, 0x02, 0x18, 0xff}, "invalid varint"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := manifest.Parse(test.data); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want an error containing %q", err, test.want)
+			}
+		})
+	}
+
+	deep := &manifest.Node{Name: "tree", Type: "directory", Hash: "00"}
+	for range 5000 {
+		deep = &manifest.Node{Name: "tree", Type: "directory", Hash: "00", Children: map[string]*manifest.Node{"d": deep}}
+	}
+	for _, format := range []string{manifest.FormatCBOR, manifest.FormatProtobuf} {
+		data, err := deep.Encode(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := manifest.Parse(data); err == nil || !strings.Contains(err.Error(), "nested too deeply") {
+			t.Errorf("%s: got %v for 5000 nested directories", format, err)
+		}
+	}
+}
+
+// Whatever it is given, Parse returns an error or a tree, which exports
+// to JSON that parses again.
+func FuzzParse(f *testing.F) {
+	root, err := manifest.Parse([]byte(fullExport))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, format := range manifest.Formats {
+		data, err := root.Encode(format)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte(`{"a": null}`))
+	f.Add([]byte{0x0a, 0x7b, '"'})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		root, err := manifest.Parse(data)
+		if err != nil {
+			return
+		}
+		again, err := root.Encode(manifest.FormatJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := manifest.Parse(again); err != nil {
+			t.Fatalf("the JSON export of a parsed tree fails to parse: %v", err)
+		}
+	})
+}
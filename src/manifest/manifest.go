@@ -0,0 +1,188 @@
+// Package manifest models the JSON tree exported by the MTFS backend
+// (menu option 6) so other parts of the frontend can work with it.
+package manifest
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"path"
+	"sort"
+
+	"golang.org/x/crypto/blake2b"
+	"lukechampine.com/blake3"
+)
+
+// Node is a file, directory, recorded symbolic link or recorded special
+// file of an exported tree, or an error leaf standing for an entry the
+// build could not read.
+type Node struct {
+	Name           string            `json:"-"`
+	Type           string            `json:"type"`
+	Hash           string            `json:"hash"`
+	Size           int64             `json:"size,omitempty"`
+	Chunks         int               `json:"chunks,omitempty"`
+	ContentHash    string            `json:"content_hash,omitempty"`
+	Target         string            `json:"target,omitempty"`
+	Device         string            `json:"device,omitempty"`
+	Error          string            `json:"error,omitempty"` // why an error leaf could not be read
+	HardlinkOf     string            `json:"hardlink_of,omitempty"`
+	XattrsHash     string            `json:"xattrs_hash,omitempty"`
+	Xattrs         map[string]string `json:"xattrs,omitempty"` // hex-encoded values
+	MetadataHash   string            `json:"metadata_hash,omitempty"`
+	Metadata       map[string]string `json:"metadata,omitempty"` // mode, owner and mtime as hashed
+	Children       map[string]*Node  `json:"children,omitempty"`
+	HashFormat     string            `json:"hash_format,omitempty"`     // of trees not in the mtfs format (root only)
+	MetadataFields string            `json:"metadata_fields,omitempty"` // hashed with each entry (root only)
+	MaxDepth       int               `json:"max_depth,omitempty"`       // filters of the build (root only)
+	MinFileSize    int64             `json:"min_file_size,omitempty"`
+	MaxFileSize    int64             `json:"max_file_size,omitempty"`
+
+	format string // hash format of the tree, as recorded by its root
+}
+
+// IsFile reports whether n is a file leaf.
+func (n *Node) IsFile() bool {
+	return n.Type == "file"
+}
+
+// Parse decodes an export, which is a single-key object mapping the name
+// of the root directory to its node, in any of the formats Encode writes.
+func Parse(data []byte) (*Node, error) {
+	var root *Node
+	var err error
+	switch detect(data) {
+	case FormatCBOR:
+		root, err = parseCBOR(data)
+	case FormatProtobuf:
+		root, err = parseProtobuf(data)
+	default:
+		if root, err = parseJSON(data); err != nil && len(data) > 0 && data[0] == protoNameKey {
+			// A root name whose length looks like the start of JSON
+			if r, perr := parseProtobuf(data); perr == nil {
+				root, err = r, nil
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse export: %w", err)
+	}
+	return root, nil
+}
+
+func parseJSON(data []byte) (*Node, error) {
+	var top map[string]*Node
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, err
+	}
+	if len(top) != 1 {
+		return nil, fmt.Errorf("expected one root node, found %d", len(top))
+	}
+	for name, root := range top {
+		if root == nil {
+			return nil, fmt.Errorf("root node %q is null", name)
+		}
+		root.setNames(name, root.HashFormat)
+		return root, nil
+	}
+	return nil, nil
+}
+
+func (n *Node) setNames(name, format string) {
+	n.Name, n.format = name, format
+	for childName, child := range n.Children {
+		child.setNames(childName, format)
+	}
+}
+
+// SortedChildren returns the children of n ordered by name, matching the
+// order the backend hashes them in.
+func (n *Node) SortedChildren() []*Node {
+	children := make([]*Node, 0, len(n.Children))
+	for _, child := range n.Children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].Name < children[j].Name })
+	return children
+}
+
+// Walk calls fn for n and every node below it in depth-first, name-sorted
+// order. Paths are slash-separated and relative to n, which itself is
+// visited with the path ".". Returning an error from fn stops the walk.
+func (n *Node) Walk(fn func(p string, node *Node) error) error {
+	return n.walk(".", fn)
+}
+
+func (n *Node) walk(p string, fn func(string, *Node) error) error {
+	if err := fn(p, n); err != nil {
+		return err
+	}
+	for _, child := range n.SortedChildren() {
+		if err := child.walk(path.Join(p, child.Name), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewHash returns the hash contents, chunks and nodes are hashed with in
+// hashFormat: the algorithm an mtfs-ALGORITHM format names, or SHA-256.
+func NewHash(hashFormat string) hash.Hash {
+	switch hashFormat {
+	case "mtfs-sha512":
+		return sha512.New()
+	case "mtfs-blake2b":
+		h, _ := blake2b.New512(nil)
+		return h
+	case "mtfs-blake3":
+		return blake3.New(32, nil)
+	}
+	return sha256.New()
+}
+
+// ContentCheck hashes the content of a file the ways a tree may record its
+// content hash: plain SHA-256 or, in the Git hash formats, the blob id;
+// or with the algorithm of the hash format its export records.
+type ContentCheck struct {
+	hashes []hash.Hash
+}
+
+// NewContentCheck returns a check for the content of n, which is written
+// to it.
+func (n *Node) NewContentCheck() *ContentCheck {
+	return NewContentCheck(n.Size, n.format)
+}
+
+// NewContentCheck returns a check for size bytes of content of a tree in
+// hashFormat, "" if its export records none.
+func NewContentCheck(size int64, hashFormat string) *ContentCheck {
+	if hashFormat != "" && hashFormat != "mtfs" && hashFormat != "git" && hashFormat != "git-sha256" {
+		return &ContentCheck{hashes: []hash.Hash{NewHash(hashFormat)}}
+	}
+	c := &ContentCheck{hashes: []hash.Hash{sha256.New(), sha1.New(), sha256.New()}}
+	for _, h := range c.hashes[1:] {
+		fmt.Fprintf(h, "blob %d\x00", size)
+	}
+	return c
+}
+
+func (c *ContentCheck) Write(p []byte) (int, error) {
+	for _, h := range c.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// Matches reports whether the content written so far has contentHash.
+func (c *ContentCheck) Matches(contentHash string) bool {
+	for _, h := range c.hashes {
+		if hex.EncodeToString(h.Sum(nil)) == contentHash {
+			return true
+		}
+	}
+	return false
+}
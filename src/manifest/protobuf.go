@@ -0,0 +1,321 @@
+package manifest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Protobuf exports are an Export message of export.proto. Fields are
+// written in field number order and map entries in key order, so a tree
+// always encodes to the same bytes.
+
+// Wire types.
+const (
+	wireVarint = 0
+	wireI64    = 1
+	wireLen    = 2
+	wireI32    = 5
+)
+
+// protoNameKey is the key of Export.name, which exports start with.
+const protoNameKey = 1<<3 | wireLen
+
+// Field numbers of Node.
+const (
+	fieldType = iota + 1
+	fieldHash
+	fieldSize
+	fieldChunks
+	fieldContentHash
+	fieldTarget
+	fieldDevice
+	fieldError
+	fieldHardlinkOf
+	fieldXattrsHash
+	fieldXattrs
+	fieldChildren
+	fieldHashFormat
+	fieldMetadataHash
+	fieldMetadata
+	fieldMetadataFields
+	fieldMaxDepth
+	fieldMinFileSize
+	fieldMaxFileSize
+)
+
+func protoKey(b []byte, field int, wire byte) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wire))
+}
+
+func protoBytes(b []byte, field int, data []byte) []byte {
+	b = protoKey(b, field, wireLen)
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// protoString leaves out empty strings, as proto3 does.
+func protoString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return protoBytes(b, field, []byte(s))
+}
+
+func protoVarint(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.AppendUvarint(protoKey(b, field, wireVarint), uint64(v))
+}
+
+func protoHash(b []byte, field int, name, h string) ([]byte, error) {
+	if h == "" {
+		return b, nil
+	}
+	data, err := hashBytes(name, h)
+	if err != nil {
+		return nil, err
+	}
+	return protoBytes(b, field, data), nil
+}
+
+func (n *Node) encodeProtobuf() ([]byte, error) {
+	node, err := n.protobuf()
+	if err != nil {
+		return nil, err
+	}
+	return protoBytes(protoBytes(nil, 1, []byte(n.Name)), 2, node), nil
+}
+
+func (n *Node) protobuf() ([]byte, error) {
+	b := protoString(nil, fieldType, n.Type)
+	b, err := protoHash(b, fieldHash, "hash", n.Hash)
+	if err != nil {
+		return nil, err
+	}
+	b = protoVarint(b, fieldSize, n.Size)
+	b = protoVarint(b, fieldChunks, int64(n.Chunks))
+	if b, err = protoHash(b, fieldContentHash, "content_hash", n.ContentHash); err != nil {
+		return nil, err
+	}
+	b = protoString(b, fieldTarget, n.Target)
+	b = protoString(b, fieldDevice, n.Device)
+	b = protoString(b, fieldError, n.Error)
+	b = protoString(b, fieldHardlinkOf, n.HardlinkOf)
+	if b, err = protoHash(b, fieldXattrsHash, "xattrs_hash", n.XattrsHash); err != nil {
+		return nil, err
+	}
+	b = protoMap(b, fieldXattrs, n.Xattrs)
+	for _, name := range sortedKeys(n.Children) {
+		child, err := n.Children[name].protobuf()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		b = protoBytes(b, fieldChildren, protoBytes(protoBytes(nil, 1, []byte(name)), 2, child))
+	}
+	b = protoString(b, fieldHashFormat, n.HashFormat)
+	if b, err = protoHash(b, fieldMetadataHash, "metadata_hash", n.MetadataHash); err != nil {
+		return nil, err
+	}
+	b = protoMap(b, fieldMetadata, n.Metadata)
+	b = protoString(b, fieldMetadataFields, n.MetadataFields)
+	b = protoVarint(b, fieldMaxDepth, int64(n.MaxDepth))
+	b = protoVarint(b, fieldMinFileSize, n.MinFileSize)
+	return protoVarint(b, fieldMaxFileSize, n.MaxFileSize), nil
+}
+
+// protoMap appends the entries of a map<string, string> field in key order.
+func protoMap(b []byte, field int, m map[string]string) []byte {
+	for _, name := range sortedKeys(m) {
+		b = protoBytes(b, field, protoBytes(protoBytes(nil, 1, []byte(name)), 2, []byte(m[name])))
+	}
+	return b
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// protoField is a field read from a message: its number and wire type,
+// and its value as a varint or the bytes of a length-delimited field.
+type protoField struct {
+	num    int
+	wire   byte
+	varint uint64
+	data   []byte
+}
+
+// protoFields calls fn for every field of the message data. Fields of
+// fixed size are passed with no value; no field of the schema has one.
+func protoFields(data []byte, fn func(protoField) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("invalid field key")
+		}
+		data = data[n:]
+		f := protoField{num: int(key >> 3), wire: byte(key & 7)}
+		switch f.wire {
+		case wireVarint:
+			if f.varint, n = binary.Uvarint(data); n <= 0 {
+				return fmt.Errorf("field %d: invalid varint", f.num)
+			}
+			data = data[n:]
+		case wireLen:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return fmt.Errorf("field %d: unexpected end of data", f.num)
+			}
+			f.data, data = data[n:n+int(size)], data[n+int(size):]
+		case wireI64, wireI32:
+			size := 8
+			if f.wire == wireI32 {
+				size = 4
+			}
+			if len(data) < size {
+				return fmt.Errorf("field %d: unexpected end of data", f.num)
+			}
+			data = data[size:]
+		default:
+			return fmt.Errorf("field %d: unsupported wire type %d", f.num, f.wire)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// want checks f has the wire type of its field in the schema.
+func (f protoField) want(wire byte) error {
+	if f.wire != wire {
+		return fmt.Errorf("field %d has wire type %d, not %d", f.num, f.wire, wire)
+	}
+	return nil
+}
+
+func parseProtobuf(data []byte) (*Node, error) {
+	var name string
+	var root *Node
+	err := protoFields(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			name = string(f.data)
+			return f.want(wireLen)
+		case 2:
+			if err := f.want(wireLen); err != nil {
+				return err
+			}
+			var err error
+			root, err = parseProtoNode(f.data, 0)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, errors.New("no root node")
+	}
+	root.setNames(name, root.HashFormat)
+	return root, nil
+}
+
+func parseProtoNode(data []byte, depth int) (*Node, error) {
+	if depth > maxDepth {
+		return nil, errors.New("nodes nested too deeply")
+	}
+	node := &Node{}
+	err := protoFields(data, func(f protoField) error {
+		wire := byte(wireLen)
+		switch f.num {
+		case fieldType:
+			node.Type = string(f.data)
+		case fieldHash:
+			node.Hash = hashString(f.data)
+		case fieldSize:
+			wire, node.Size = wireVarint, int64(f.varint)
+		case fieldChunks:
+			wire, node.Chunks = wireVarint, int(f.varint)
+		case fieldContentHash:
+			node.ContentHash = hashString(f.data)
+		case fieldTarget:
+			node.Target = string(f.data)
+		case fieldDevice:
+			node.Device = string(f.data)
+		case fieldError:
+			node.Error = string(f.data)
+		case fieldHardlinkOf:
+			node.HardlinkOf = string(f.data)
+		case fieldXattrsHash:
+			node.XattrsHash = hashString(f.data)
+		case fieldXattrs, fieldMetadata:
+			name, value, err := protoEntry(f.data)
+			if err != nil {
+				return err
+			}
+			m := &node.Xattrs
+			if f.num == fieldMetadata {
+				m = &node.Metadata
+			}
+			if *m == nil {
+				*m = map[string]string{}
+			}
+			(*m)[name] = string(value)
+		case fieldChildren:
+			name, value, err := protoEntry(f.data)
+			if err != nil {
+				return err
+			}
+			child, err := parseProtoNode(value, depth+1)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			if node.Children == nil {
+				node.Children = map[string]*Node{}
+			}
+			node.Children[name] = child
+		case fieldHashFormat:
+			node.HashFormat = string(f.data)
+		case fieldMetadataHash:
+			node.MetadataHash = hashString(f.data)
+		case fieldMetadataFields:
+			node.MetadataFields = string(f.data)
+		case fieldMaxDepth:
+			wire, node.MaxDepth = wireVarint, int(f.varint)
+		case fieldMinFileSize:
+			wire, node.MinFileSize = wireVarint, int64(f.varint)
+		case fieldMaxFileSize:
+			wire, node.MaxFileSize = wireVarint, int64(f.varint)
+		default:
+			// Added to the schema since; left out
+			return nil
+		}
+		return f.want(wire)
+	})
+	return node, err
+}
+
+// protoEntry reads the key and value of a map entry.
+func protoEntry(data []byte) (key string, value []byte, err error) {
+	err = protoFields(data, func(f protoField) error {
+		switch f.num {
+		case 1:
+			key = string(f.data)
+		case 2:
+			value = f.data
+		default:
+			return nil
+		}
+		return f.want(wireLen)
+	})
+	return key, value, err
+}
@@ -0,0 +1,569 @@
+#include "merkle.hpp"
+#include <zlib.h>
+#include <cstring>
+#include <ctime>
+#include <climits>
+
+static const size_t TAR_BLOCK = 512;
+
+// Little-endian integers of zip headers
+static uint64_t littleEndian(const char *p, size_t bytes)
+{
+    uint64_t value = 0;
+    for (size_t i = bytes; i > 0; --i)
+    {
+        value = value << 8 | static_cast<unsigned char>(p[i - 1]);
+    }
+    return value;
+}
+
+// Numeric tar header field: octal, or base-256 if the high bit is set (GNU)
+static unsigned long long tarNumber(const char *field, size_t length)
+{
+    unsigned long long value = 0;
+    if (static_cast<unsigned char>(field[0]) & 0x80)
+    {
+        value = static_cast<unsigned char>(field[0]) & 0x7f;
+        for (size_t i = 1; i < length; ++i)
+        {
+            value = value << 8 | static_cast<unsigned char>(field[i]);
+        }
+        return value;
+    }
+    size_t i = 0;
+    while (i < length && field[i] == ' ')
+    {
+        ++i;
+    }
+    for (; i < length && field[i] >= '0' && field[i] <= '7'; ++i)
+    {
+        value = value * 8 + (field[i] - '0');
+    }
+    return value;
+}
+
+// NUL-terminated (or full-width) tar header string
+static string tarString(const char *field, size_t length)
+{
+    return string(field, strnlen(field, length));
+}
+
+// Parse the "length key=value\n" records of a pax extended header
+static void parsePax(const string &data, map<string, string> &records)
+{
+    size_t pos = 0;
+    while (pos < data.size())
+    {
+        size_t space = data.find(' ', pos);
+        if (space == string::npos)
+        {
+            break;
+        }
+        size_t length = 0;
+        try
+        {
+            length = stoul(data.substr(pos, space - pos));
+        }
+        catch (const exception &)
+        {
+            break;
+        }
+        if (length == 0 || pos + length > data.size())
+        {
+            break;
+        }
+        string record = data.substr(space + 1, pos + length - space - 2);
+        size_t equals = record.find('=');
+        if (equals != string::npos)
+        {
+            records[record.substr(0, equals)] = record.substr(equals + 1);
+        }
+        pos += length;
+    }
+}
+
+/**
+ * @brief Check whether a file is an archive this reader understands
+ * @param path Filesystem path
+ * @return True for regular files starting like a zip, gzip or tar archive
+ */
+bool ArchiveReader::isArchive(const fs::path &path)
+{
+    if (!fs::is_regular_file(path))
+    {
+        return false;
+    }
+    ifstream in(path, ios::binary);
+    char head[TAR_BLOCK] = {};
+    in.read(head, sizeof(head));
+    size_t got = in.gcount();
+    if (got >= 4 && (memcmp(head, "PK\x03\x04", 4) == 0 || memcmp(head, "PK\x05\x06", 4) == 0))
+    {
+        return true;
+    }
+    if (got >= 2 && static_cast<unsigned char>(head[0]) == 0x1f && static_cast<unsigned char>(head[1]) == 0x8b)
+    {
+        return true;
+    }
+    return got == TAR_BLOCK && memcmp(head + 257, "ustar", 5) == 0;
+}
+
+/**
+ * @brief Open an archive
+ * @param path Filesystem path of the archive
+ * @throws runtime_error If the archive cannot be opened or its directory is damaged
+ */
+ArchiveReader::ArchiveReader(const fs::path &path) : path(path)
+{
+    ifstream in(path, ios::binary);
+    char magic[2] = {};
+    in.read(magic, sizeof(magic));
+    zip = in.gcount() == 2 && magic[0] == 'P' && magic[1] == 'K';
+    if (zip)
+    {
+        zipFile.open(path, ios::binary);
+        if (!zipFile.is_open())
+        {
+            throw runtime_error("Cannot open archive: " + path.string());
+        }
+        readZipDirectory();
+        return;
+    }
+
+    // gzip streams are decompressed transparently, plain tar is passed through
+    gz = gzopen(path.c_str(), "rb");
+    if (!gz)
+    {
+        throw runtime_error("Cannot open archive: " + path.string());
+    }
+}
+
+/**
+ * @brief Close the archive
+ */
+ArchiveReader::~ArchiveReader()
+{
+    if (gz)
+    {
+        gzclose(gz);
+    }
+}
+
+/**
+ * @brief Advance to the next member
+ * @param entry Receives the member's metadata
+ * @return False at the end of the archive
+ * @throws runtime_error If the archive is damaged
+ */
+bool ArchiveReader::next(ArchiveEntry &entry)
+{
+    if (!zip)
+    {
+        return nextTar(entry);
+    }
+    if (nextMember >= members.size())
+    {
+        return false;
+    }
+    const ZipMember &member = members[nextMember++];
+    entry = member.entry;
+    // Zip stores the target of a symlink as its content
+    if (entry.type == 'l')
+    {
+        entry.linkTarget = readZipMember(member);
+    }
+    return true;
+}
+
+/**
+ * @brief Read the content of the current member
+ * @return Content of a file, or the target of a zip symlink
+ * @throws runtime_error If the content is damaged or cannot be decompressed
+ */
+string ArchiveReader::readData()
+{
+    if (zip)
+    {
+        if (nextMember == 0)
+        {
+            return "";
+        }
+        return readZipMember(members[nextMember - 1]);
+    }
+    string data = readTar(remaining);
+    remaining = 0;
+    return data;
+}
+
+/**
+ * @brief Advance to the next tar member, reading extension headers on the way
+ * @param entry Receives the member's metadata
+ * @return False at the end of the archive
+ */
+bool ArchiveReader::nextTar(ArchiveEntry &entry)
+{
+    // Skip whatever the caller did not read of the previous member
+    readTar(remaining + padding);
+    remaining = 0;
+    padding = 0;
+
+    string longName, longLink;
+    map<string, string> pax;
+    while (true)
+    {
+        char header[TAR_BLOCK];
+        int got = gzread(gz, header, TAR_BLOCK);
+        if (got <= 0)
+        {
+            // Some writers leave out the end-of-archive blocks
+            if (got < 0 || !longName.empty() || !longLink.empty() || !pax.empty())
+            {
+                throw runtime_error("Unexpected end of archive: " + path.string());
+            }
+            return false;
+        }
+        if (static_cast<size_t>(got) < TAR_BLOCK)
+        {
+            throw runtime_error("Unexpected end of archive: " + path.string());
+        }
+        if (all_of(header, header + TAR_BLOCK, [](char c) { return c == 0; }))
+        {
+            return false;
+        }
+
+        // The checksum is the byte sum of the header with its own field as spaces
+        unsigned long long sum = 0;
+        for (size_t i = 0; i < TAR_BLOCK; ++i)
+        {
+            sum += (i >= 148 && i < 156) ? ' ' : static_cast<unsigned char>(header[i]);
+        }
+        if (sum != tarNumber(header + 148, 8))
+        {
+            throw runtime_error("Damaged tar header in " + path.string());
+        }
+
+        char type = header[156];
+        size_t size = tarNumber(header + 124, 12);
+        padding = (TAR_BLOCK - size % TAR_BLOCK) % TAR_BLOCK;
+        if (type == 'L' || type == 'K' || type == 'x' || type == 'g')
+        {
+            string data = readTar(size);
+            readTar(padding);
+            padding = 0;
+            if (type == 'L')
+            {
+                longName = tarString(data.data(), data.size());
+            }
+            else if (type == 'K')
+            {
+                longLink = tarString(data.data(), data.size());
+            }
+            else if (type == 'x')
+            {
+                parsePax(data, pax);
+            }
+            continue;
+        }
+
+        entry = ArchiveEntry();
+        string prefix = memcmp(header + 257, "ustar", 5) == 0 ? tarString(header + 345, 155) : "";
+        string name = tarString(header, 100);
+        entry.path = pax.count("path")   ? pax["path"]
+                     : !longName.empty() ? longName
+                     : prefix.empty()    ? name
+                                         : prefix + "/" + name;
+        entry.linkTarget = pax.count("linkpath") ? pax["linkpath"] : !longLink.empty() ? longLink : tarString(header + 157, 100);
+        entry.mode = tarNumber(header + 100, 8);
+        entry.uid = tarNumber(header + 108, 8);
+        entry.gid = tarNumber(header + 116, 8);
+        entry.mtime = tarNumber(header + 136, 12);
+        if (pax.count("size"))
+        {
+            size = stoull(pax["size"]);
+            padding = (TAR_BLOCK - size % TAR_BLOCK) % TAR_BLOCK;
+        }
+        if (pax.count("mtime"))
+        {
+            entry.mtime = stoll(pax["mtime"]);
+        }
+        if (pax.count("uid"))
+        {
+            entry.uid = stoll(pax["uid"]);
+        }
+        if (pax.count("gid"))
+        {
+            entry.gid = stoll(pax["gid"]);
+        }
+        for (const auto &[key, value] : pax)
+        {
+            if (key.rfind("SCHILY.xattr.", 0) == 0)
+            {
+                entry.xattrs[key.substr(13)] = value;
+            }
+        }
+
+        switch (type)
+        {
+        case '1':
+            entry.type = 'h';
+            break;
+        case '2':
+            entry.type = 'l';
+            break;
+        case '3':
+        case '4':
+            entry.type = type == '3' ? 'c' : 'b';
+            entry.deviceNumber = to_string(tarNumber(header + 329, 8)) + ":" + to_string(tarNumber(header + 337, 8));
+            break;
+        case '5':
+            entry.type = 'd';
+            break;
+        case '6':
+            entry.type = 'p';
+            break;
+        default:
+            // POSIX reads unknown types as regular files
+            entry.type = 'f';
+            entry.size = size;
+        }
+        remaining = size;
+        return true;
+    }
+}
+
+/**
+ * @brief Read bytes from the tar stream
+ * @param size Number of bytes
+ * @return The bytes read
+ * @throws runtime_error If the stream ends early or is damaged
+ */
+string ArchiveReader::readTar(size_t size)
+{
+    string data;
+    data.reserve(size);
+    char buffer[64 * 1024];
+    while (data.size() < size)
+    {
+        unsigned wanted = static_cast<unsigned>(min(size - data.size(), sizeof(buffer)));
+        int got = gzread(gz, buffer, wanted);
+        if (got <= 0)
+        {
+            int code = 0;
+            const char *message = gzerror(gz, &code);
+            throw runtime_error((code < 0 ? string(message) : string("Unexpected end of archive")) + ": " + path.string());
+        }
+        data.append(buffer, got);
+    }
+    return data;
+}
+
+/**
+ * @brief Load the member list from the zip central directory
+ */
+void ArchiveReader::readZipDirectory()
+{
+    zipFile.seekg(0, ios::end);
+    size_t fileSize = zipFile.tellg();
+    size_t tailSize = min<size_t>(fileSize, 22 + 0xffff);
+    string tail(tailSize, '\0');
+    zipFile.seekg(fileSize - tailSize);
+    zipFile.read(&tail[0], tailSize);
+
+    // The end of central directory record is followed only by its comment
+    size_t end = tail.rfind("PK\x05\x06");
+    if (end == string::npos || tailSize - end < 22)
+    {
+        throw runtime_error("Not a zip archive (no central directory): " + path.string());
+    }
+    uint64_t count = littleEndian(&tail[end + 10], 2);
+    uint64_t directorySize = littleEndian(&tail[end + 12], 4);
+    uint64_t directoryOffset = littleEndian(&tail[end + 16], 4);
+    if ((count == 0xffff || directorySize == 0xffffffff || directoryOffset == 0xffffffff) && end >= 20 &&
+        tail.compare(end - 20, 4, "PK\x06\x07") == 0)
+    {
+        char record[56];
+        zipFile.seekg(littleEndian(&tail[end - 20 + 8], 8));
+        if (!zipFile.read(record, sizeof(record)) || memcmp(record, "PK\x06\x06", 4) != 0)
+        {
+            throw runtime_error("Damaged zip64 directory in " + path.string());
+        }
+        count = littleEndian(record + 32, 8);
+        directorySize = littleEndian(record + 40, 8);
+        directoryOffset = littleEndian(record + 48, 8);
+    }
+    if (directoryOffset + directorySize > fileSize)
+    {
+        throw runtime_error("Damaged zip directory in " + path.string());
+    }
+
+    string directory(directorySize, '\0');
+    zipFile.seekg(directoryOffset);
+    zipFile.read(&directory[0], directorySize);
+    size_t pos = 0;
+    for (uint64_t i = 0; i < count; ++i)
+    {
+        if (pos + 46 > directory.size() || directory.compare(pos, 4, "PK\x01\x02") != 0)
+        {
+            throw runtime_error("Damaged zip directory in " + path.string());
+        }
+        const char *header = &directory[pos];
+        size_t nameLength = littleEndian(header + 28, 2);
+        size_t extraLength = littleEndian(header + 30, 2);
+        size_t commentLength = littleEndian(header + 32, 2);
+        if (pos + 46 + nameLength + extraLength + commentLength > directory.size())
+        {
+            throw runtime_error("Damaged zip directory in " + path.string());
+        }
+
+        ZipMember member;
+        member.encrypted = littleEndian(header + 8, 2) & 1;
+        member.method = littleEndian(header + 10, 2);
+        member.crc = littleEndian(header + 16, 4);
+        member.compressedSize = littleEndian(header + 20, 4);
+        member.entry.size = littleEndian(header + 24, 4);
+        member.localOffset = littleEndian(header + 42, 4);
+        member.entry.path = directory.substr(pos + 46, nameLength);
+
+        // DOS timestamps are local time, with two-second resolution
+        uint64_t dosTime = littleEndian(header + 12, 2);
+        uint64_t dosDate = littleEndian(header + 14, 2);
+        struct tm local = {};
+        local.tm_year = (dosDate >> 9) + 80;
+        local.tm_mon = ((dosDate >> 5) & 0xf) - 1;
+        local.tm_mday = dosDate & 0x1f;
+        local.tm_hour = dosTime >> 11;
+        local.tm_min = (dosTime >> 5) & 0x3f;
+        local.tm_sec = (dosTime & 0x1f) * 2;
+        local.tm_isdst = -1;
+        member.entry.mtime = mktime(&local);
+
+        // Zip64 sizes and offsets, and the Unix modification time
+        const char *extra = header + 46 + nameLength;
+        for (size_t at = 0; at + 4 <= extraLength;)
+        {
+            uint64_t id = littleEndian(extra + at, 2);
+            size_t length = littleEndian(extra + at + 2, 2);
+            const char *field = extra + at + 4;
+            if (at + 4 + length > extraLength)
+            {
+                break;
+            }
+            if (id == 0x0001)
+            {
+                size_t used = 0;
+                if (member.entry.size == 0xffffffff && used + 8 <= length)
+                {
+                    member.entry.size = littleEndian(field + used, 8);
+                    used += 8;
+                }
+                if (member.compressedSize == 0xffffffff && used + 8 <= length)
+                {
+                    member.compressedSize = littleEndian(field + used, 8);
+                    used += 8;
+                }
+                if (member.localOffset == 0xffffffff && used + 8 <= length)
+                {
+                    member.localOffset = littleEndian(field + used, 8);
+                }
+            }
+            else if (id == 0x5455 && length >= 5 && (field[0] & 1))
+            {
+                member.entry.mtime = static_cast<int32_t>(littleEndian(field + 1, 4));
+            }
+            at += 4 + length;
+        }
+
+        // Unix permissions and file type are kept in the external attributes
+        if (littleEndian(header + 4, 2) >> 8 == 3)
+        {
+            member.entry.mode = littleEndian(header + 38, 4) >> 16;
+        }
+        if (!member.entry.path.empty() && member.entry.path.back() == '/')
+        {
+            member.entry.type = 'd';
+            member.entry.size = 0;
+        }
+        else if (S_ISLNK(member.entry.mode))
+        {
+            member.entry.type = 'l';
+            member.entry.size = 0;
+        }
+        members.push_back(member);
+        pos += 46 + nameLength + extraLength + commentLength;
+    }
+}
+
+/**
+ * @brief Read and decompress the content of a zip member
+ * @param member Member to read
+ * @return Content of the member
+ */
+string ArchiveReader::readZipMember(const ZipMember &member)
+{
+    const string &name = member.entry.path;
+    if (member.encrypted)
+    {
+        throw runtime_error("Encrypted zip member: " + name);
+    }
+
+    char local[30];
+    zipFile.clear();
+    zipFile.seekg(member.localOffset);
+    if (!zipFile.read(local, sizeof(local)) || memcmp(local, "PK\x03\x04", 4) != 0)
+    {
+        throw runtime_error("Damaged zip member: " + name);
+    }
+    zipFile.seekg(littleEndian(local + 26, 2) + littleEndian(local + 28, 2), ios::cur);
+    string stored(member.compressedSize, '\0');
+    if (!zipFile.read(&stored[0], stored.size()))
+    {
+        throw runtime_error("Unexpected end of archive in zip member: " + name);
+    }
+
+    string data;
+    // The size of symlink members is not known before they are read
+    size_t expected = member.entry.type == 'l' ? SIZE_MAX : member.entry.size;
+    if (member.method == 0)
+    {
+        data = stored;
+    }
+    else if (member.method == 8)
+    {
+        z_stream stream = {};
+        if (inflateInit2(&stream, -MAX_WBITS) != Z_OK)
+        {
+            throw runtime_error("Cannot inflate zip member: " + name);
+        }
+        stream.next_in = reinterpret_cast<Bytef *>(&stored[0]);
+        stream.avail_in = stored.size();
+        char buffer[64 * 1024];
+        int status = Z_OK;
+        while (status == Z_OK)
+        {
+            stream.next_out = reinterpret_cast<Bytef *>(buffer);
+            stream.avail_out = sizeof(buffer);
+            status = inflate(&stream, Z_NO_FLUSH);
+            data.append(buffer, sizeof(buffer) - stream.avail_out);
+            if (data.size() > expected)
+            {
+                break;
+            }
+        }
+        inflateEnd(&stream);
+        if (status != Z_STREAM_END)
+        {
+            throw runtime_error("Damaged deflate data in zip member: " + name);
+        }
+    }
+    else
+    {
+        throw runtime_error("Unsupported compression method " + to_string(member.method) + " in zip member: " + name);
+    }
+
+    if ((member.entry.type != 'l' && data.size() != member.entry.size) ||
+        crc32(0, reinterpret_cast<const Bytef *>(data.data()), data.size()) != member.crc)
+    {
+        throw runtime_error("CRC mismatch in zip member: " + name);
+    }
+    return data;
+}
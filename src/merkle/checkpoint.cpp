@@ -0,0 +1,227 @@
+#include "merkle.hpp"
+
+/**
+ * @brief Open (or create) the checkpoint of a tree
+ * @param treeRoot Root directory of the tree being built
+ * @param chunkSize Chunk size of the build; older checkpoints with a different size are discarded
+ * @param format Hash format of the build; older checkpoints with a different format are discarded
+ * @param useCache True to take unchanged files from the hash cache of the last build
+ * @return True if checkpointing is active, false if the state directory is not writable
+ */
+bool BuildCheckpoint::open(const fs::path &treeRoot, size_t chunkSize, HashFormat format, bool useCache)
+{
+    if (out.is_open())
+    {
+        out.close();
+    }
+    loaded.clear();
+    cached.clear();
+
+    fs::path stateDir = stateDirectory(treeRoot);
+    filePath = stateDir / MTFSConstants::CHECKPOINT_FILE;
+    cachePath = stateDir / MTFSConstants::HASH_CACHE_FILE;
+
+    error_code ec;
+    fs::create_directories(stateDir, ec);
+    if (ec)
+    {
+        return false;
+    }
+
+    // Modification times are in nanoseconds since the epoch, as the Go
+    // engine writes them too
+    string header = "MTFS-CHECKPOINT 2 " + to_string(chunkSize) + " " + hashFormatName(format);
+    struct stat st;
+    if (useCache && load(cachePath, header, cached) && stat(cachePath.c_str(), &st) == 0)
+    {
+        long long written = st.st_mtim.tv_sec * 1000000000LL + st.st_mtim.tv_nsec;
+        for (auto it = cached.begin(); it != cached.end();)
+        {
+            it = it->second.mtime + MTFSConstants::HASH_CACHE_RACY * 1000000000LL > written ? cached.erase(it) : next(it);
+        }
+    }
+    else
+    {
+        cached.clear();
+    }
+
+    if (load(filePath, header, loaded))
+    {
+        out.open(filePath, ios::app);
+    }
+    else
+    {
+        loaded.clear();
+        out.open(filePath, ios::trunc);
+        out << header << "\n";
+    }
+
+    lastFlush = std::chrono::steady_clock::now();
+    return out.is_open();
+}
+
+/**
+ * @brief Look up a completed file
+ * @param relPath Path of the file relative to the tree root
+ * @param fileSize Current size of the file
+ * @param mtime Current modification time of the file
+ * @return Pointer to the entry if the file is unchanged, nullptr otherwise
+ */
+const CheckpointEntry *BuildCheckpoint::find(const string &relPath, size_t fileSize, long long mtime) const
+{
+    auto it = loaded.find(relPath);
+    if (it == loaded.end() || it->second.fileSize != fileSize || it->second.mtime != mtime)
+    {
+        return nullptr;
+    }
+    return &it->second;
+}
+
+/**
+ * @brief Look up a file hashed by the last successful build
+ * @param relPath Path of the file relative to the tree root
+ * @param fileSize Current size of the file
+ * @param mtime Current modification time of the file
+ * @return Pointer to the entry if the file is unchanged, nullptr otherwise
+ */
+const CheckpointEntry *BuildCheckpoint::findCached(const string &relPath, size_t fileSize, long long mtime) const
+{
+    auto it = cached.find(relPath);
+    if (it == cached.end() || it->second.fileSize != fileSize || it->second.mtime != mtime)
+    {
+        return nullptr;
+    }
+    return &it->second;
+}
+
+/**
+ * @brief Record a completed file
+ * @param relPath Path of the file relative to the tree root
+ * @param entry Hashes and stat data of the file
+ */
+void BuildCheckpoint::record(const string &relPath, const CheckpointEntry &entry)
+{
+    if (!out.is_open())
+    {
+        return;
+    }
+
+    out << "F\t" << entry.fileSize << "\t" << entry.mtime << "\t" << entry.contentHash << "\t";
+    for (size_t i = 0; i < entry.chunkHashes.size(); ++i)
+    {
+        out << (i > 0 ? "," : "") << entry.chunkHashes[i];
+    }
+    out << "\t" << escapeField(relPath) << "\n";
+
+    auto now = std::chrono::steady_clock::now();
+    if (std::chrono::duration<double>(now - lastFlush).count() >= MTFSConstants::CHECKPOINT_INTERVAL)
+    {
+        out.flush();
+        lastFlush = now;
+    }
+}
+
+/**
+ * @brief Keep the checkpoint of a successful build as the hash cache
+ */
+void BuildCheckpoint::complete()
+{
+    bool written = out.is_open();
+    if (written)
+    {
+        out.close();
+        written = !out.fail();
+    }
+    loaded.clear();
+    cached.clear();
+
+    error_code ec;
+    if (written)
+    {
+        fs::rename(filePath, cachePath, ec);
+    }
+    if (!written || ec)
+    {
+        fs::remove(filePath, ec);
+        fs::remove(cachePath, ec);
+    }
+}
+
+/**
+ * @brief Get the number of files loaded from an earlier checkpoint
+ * @return Number of resumable files
+ */
+size_t BuildCheckpoint::resumableCount() const
+{
+    return loaded.size();
+}
+
+/**
+ * @brief Get the number of files loaded from the hash cache
+ * @return Number of files the last successful build hashed
+ */
+size_t BuildCheckpoint::cachedCount() const
+{
+    return cached.size();
+}
+
+/**
+ * @brief Load entries from an existing checkpoint file
+ * @param file Checkpoint or hash cache to read
+ * @param header Header line the checkpoint must have been written with
+ * @param entries Map receiving the entries, by path
+ * @return True if the file existed and matched the chunk size and hash format
+ */
+bool BuildCheckpoint::load(const fs::path &file, const string &header, map<string, CheckpointEntry> &entries)
+{
+    ifstream in(file);
+    if (!in.is_open())
+    {
+        return false;
+    }
+
+    string line;
+    if (!getline(in, line) || line != header)
+    {
+        return false;
+    }
+
+    while (getline(in, line))
+    {
+        // A record cut short by an interruption is simply ignored
+        vector<string> fields;
+        stringstream ss(line);
+        string field;
+        while (getline(ss, field, '\t'))
+        {
+            fields.push_back(field);
+        }
+        if (fields.size() != 6 || fields[0] != "F" || fields[3].empty())
+        {
+            continue;
+        }
+
+        try
+        {
+            CheckpointEntry entry;
+            entry.fileSize = stoull(fields[1]);
+            entry.mtime = stoll(fields[2]);
+            entry.contentHash = fields[3];
+
+            stringstream chunks(fields[4]);
+            string chunk;
+            while (getline(chunks, chunk, ','))
+            {
+                entry.chunkHashes.push_back(chunk);
+            }
+
+            entries[unescapeField(fields[5])] = entry;
+        }
+        catch (const exception &)
+        {
+            continue;
+        }
+    }
+
+    return true;
+}
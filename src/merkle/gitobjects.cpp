@@ -0,0 +1,155 @@
+#include "merkle.hpp"
+#include <openssl/evp.h>
+
+/**
+ * @brief Parse a hash format name
+ * @param name "mtfs", "git", "git-sha256", "mtfs-sha512", "mtfs-blake2b"
+ *             or "mtfs-blake3"
+ * @param format Receives the parsed format
+ * @return True if the name is known
+ */
+bool parseHashFormat(const string &name, HashFormat &format)
+{
+    if (name == "mtfs")
+    {
+        format = HashFormat::Mtfs;
+    }
+    else if (name == "git")
+    {
+        format = HashFormat::GitSha1;
+    }
+    else if (name == "git-sha256")
+    {
+        format = HashFormat::GitSha256;
+    }
+    else if (name == "mtfs-sha512")
+    {
+        format = HashFormat::MtfsSha512;
+    }
+    else if (name == "mtfs-blake2b")
+    {
+        format = HashFormat::MtfsBlake2b;
+    }
+    else if (name == "mtfs-blake3")
+    {
+        format = HashFormat::MtfsBlake3;
+    }
+    else
+    {
+        return false;
+    }
+    return true;
+}
+
+/**
+ * @brief Get the name of a hash format
+ * @param format Format to name
+ * @return Name accepted by parseHashFormat
+ */
+string hashFormatName(HashFormat format)
+{
+    switch (format)
+    {
+    case HashFormat::GitSha1:
+        return "git";
+    case HashFormat::GitSha256:
+        return "git-sha256";
+    case HashFormat::MtfsSha512:
+        return "mtfs-sha512";
+    case HashFormat::MtfsBlake2b:
+        return "mtfs-blake2b";
+    case HashFormat::MtfsBlake3:
+        return "mtfs-blake3";
+    default:
+        return "mtfs";
+    }
+}
+
+/**
+ * @brief Check whether a hash format hashes nodes into Git object ids
+ * @param format Format to check
+ * @return True for GitSha1 and GitSha256
+ */
+bool isGitFormat(HashFormat format)
+{
+    return format == HashFormat::GitSha1 || format == HashFormat::GitSha256;
+}
+
+/**
+ * @brief Hash data with the algorithm of an mtfs hash format
+ * @param format Hash format; the Git formats hash chunks with SHA-256
+ * @param data Data to hash
+ * @return Hexadecimal digest
+ * @throws runtime_error If the algorithm is not available
+ */
+string formatDigest(HashFormat format, const string &data)
+{
+    const EVP_MD *md = EVP_sha256();
+    switch (format)
+    {
+    case HashFormat::MtfsSha512:
+        md = EVP_sha512();
+        break;
+    case HashFormat::MtfsBlake2b:
+        md = EVP_blake2b512();
+        break;
+    case HashFormat::MtfsBlake3:
+        // OpenSSL has no BLAKE3
+        throw runtime_error("The mtfs-blake3 hash format needs the Go engine (MTFS_ENGINE=go)");
+    default:
+        break;
+    }
+    unsigned char digest[EVP_MAX_MD_SIZE];
+    unsigned int length = 0;
+    if (EVP_Digest(data.data(), data.size(), digest, &length, md, nullptr) != 1)
+    {
+        throw runtime_error("Cannot calculate a " + hashFormatName(format) + " digest");
+    }
+    return hexEncode(string(reinterpret_cast<char *>(digest), length));
+}
+
+/**
+ * @brief Calculate the id of a Git object
+ * @param format GitSha1 or GitSha256, the object format of the repository
+ * @param type Object type, "blob" or "tree"
+ * @param content Object content without the header
+ * @return Hexadecimal object id, as printed by git hash-object
+ * @throws runtime_error If the digest cannot be calculated
+ */
+string gitObjectId(HashFormat format, const string &type, const string &content)
+{
+    string header = type + " " + to_string(content.size());
+    unsigned char digest[EVP_MAX_MD_SIZE];
+    unsigned int length = 0;
+
+    EVP_MD_CTX *ctx = EVP_MD_CTX_new();
+    bool ok = ctx != nullptr &&
+              EVP_DigestInit_ex(ctx, format == HashFormat::GitSha256 ? EVP_sha256() : EVP_sha1(), nullptr) == 1 &&
+              EVP_DigestUpdate(ctx, header.c_str(), header.size() + 1) == 1 && // The NUL ends the header
+              EVP_DigestUpdate(ctx, content.data(), content.size()) == 1 &&
+              EVP_DigestFinal_ex(ctx, digest, &length) == 1;
+    EVP_MD_CTX_free(ctx);
+    if (!ok)
+    {
+        throw runtime_error("Cannot calculate " + type + " object id");
+    }
+    return hexEncode(string(reinterpret_cast<char *>(digest), length));
+}
+
+/**
+ * @brief Encode one entry of a Git tree object
+ * @param mode Git file mode, e.g. "100644" or "40000"
+ * @param name Entry name
+ * @param objectId Hexadecimal id of the entry's object
+ * @return "mode name", a NUL byte and the binary object id
+ */
+string gitTreeEntry(const string &mode, const string &name, const string &objectId)
+{
+    string entry = mode + " " + name;
+    entry += '\0';
+    for (size_t i = 0; i + 1 < objectId.size(); i += 2)
+    {
+        entry += static_cast<char>(stoi(objectId.substr(i, 2), nullptr, 16));
+    }
+    return entry;
+}
@@ -1,5 +1,6 @@
 #include "merkle.hpp"
 #include <iostream>
+#include <limits>
 #include <string>
 
 using namespace std;
@@ -15,12 +16,413 @@ void print_menu()
     cout << "6. Export tree to JSON\n";
     cout << "7. Set chunk size\n";
     cout << "8. Exit\n";
+    cout << "9. Preview build (dry run)\n";
+    cout << "10. List directory (JSON)\n";
     cout << "Choose an option: ";
 }
 
-int main() 
+void print_usage(const char *program)
+{
+    cerr << "Usage: " << program << " [--include PATTERN]... [--exclude PATTERN]... [--symlinks POLICY]\n";
+    cerr << "       [--hidden include|skip] [--apple-metadata include|skip] [--max-depth N]\n";
+    cerr << "       [--min-file-size SIZE] [--max-file-size SIZE] [-x | --one-file-system]\n";
+    cerr << "       [--xattrs] [--metadata FIELDS] [--special-files skip|record|error] [--normalize none|nfc|nfd]\n";
+    cerr << "       [--case-insensitive] [--hash-format FORMAT]\n";
+    cerr << "  --include PATTERN     only hash files matching PATTERN\n";
+    cerr << "  --exclude PATTERN     skip files and directories matching PATTERN\n";
+    cerr << "  --symlinks POLICY     follow (default), record (hash the link target) or skip\n";
+    cerr << "  --hidden MODE         include (default) or skip dotfiles and dot-directories\n";
+    cerr << "  --apple-metadata MODE skip (default) or include AppleDouble ._ files, .DS_Store,\n";
+    cerr << "                        __MACOSX and com.apple.* extended attributes\n";
+    cerr << "  --max-depth N         do not read directories more than N levels below the root\n";
+    cerr << "  --min-file-size SIZE  do not hash files smaller than SIZE (e.g. 1, 4K)\n";
+    cerr << "  --max-file-size SIZE  do not hash files larger than SIZE (e.g. 512M, 2G)\n";
+    cerr << "  -x, --one-file-system do not read directories on other file systems than the root\n";
+    cerr << "  --xattrs              hash extended attributes and ACLs with each entry\n";
+    cerr << "  --metadata FIELDS     hash mode, owner and/or mtime with each entry, e.g. mode,owner\n";
+    cerr << "  --special-files MODE  skip (default), record or error on devices, sockets and FIFOs\n";
+    cerr << "  --normalize FORM      hash names as stored (none, default) or in Unicode NFC or NFD\n";
+    cerr << "  --case-insensitive    skip names that only differ in case from an earlier one\n";
+    cerr << "  --hash-format FORMAT  mtfs (default, SHA-256), mtfs-sha512 or mtfs-blake2b, or\n";
+    cerr << "                        git / git-sha256 for Git blob and tree ids\n";
+    cerr << "Patterns containing '/' match the path from the tree root, others match the name.\n";
+    cerr << "Without options a tree is built with the options recorded by its last build.\n";
+    cerr << "Options can also be set as MTFS_* environment variables (MTFS_HASH_FORMAT=git,\n";
+    cerr << "MTFS_EXCLUDE='*.tmp:build'), with MTFS_CHUNK_SIZE and MTFS_WORKERS besides.\n";
+    cerr << "MTFS_IO_LIMIT (bytes/s) and MTFS_IOPS_LIMIT (reads/s) throttle reading files, and\n";
+    cerr << "MTFS_NICE (0-19) and MTFS_IONICE (idle, best-effort[:0-7]) lower the priority.\n";
+    cerr << "MTFS_PRESCAN=0 skips totalling the files before a build, which gives its ETA.\n";
+    cerr << "MTFS_HASH_CACHE=0 hashes every file again instead of the changed ones only.\n";
+}
+
+/**
+ * @brief Parse walk options from the command line
+ * @param args Arguments without the program name
+ * @param options Receives the parsed options
+ * @return True if any walk option was given
+ */
+bool parse_walk_options(const vector<string> &args, WalkOptions &options)
+{
+    bool given = false;
+    for (size_t i = 0; i < args.size(); ++i)
+    {
+        // -x stays on one file system, as with find, du and rsync
+        string arg = args[i] == "-x" ? "--one-file-system" : args[i];
+        string value;
+        size_t eq = arg.find('=');
+        if (arg.rfind("--", 0) == 0 && eq != string::npos)
+        {
+            value = arg.substr(eq + 1);
+            arg = arg.substr(0, eq);
+        }
+        else if (arg == "--include" || arg == "--exclude" || arg == "--symlinks" || arg == "--hidden" || arg == "--apple-metadata" ||
+                 arg == "--max-depth" || arg == "--min-file-size" || arg == "--max-file-size" || arg == "--special-files" || arg == "--metadata" ||
+                 arg == "--normalize" || arg == "--hash-format")
+        {
+            if (i + 1 >= args.size())
+            {
+                throw invalid_argument(arg + " needs a value");
+            }
+            value = args[++i];
+        }
+
+        if (arg == "--one-file-system" || arg == "--xattrs" || arg == "--case-insensitive")
+        {
+            if (eq != string::npos && value != "true" && value != "false")
+            {
+                throw invalid_argument(arg + " takes no value");
+            }
+            (arg == "--xattrs"             ? options.xattrs
+             : arg == "--case-insensitive" ? options.caseInsensitive
+                                           : options.oneFileSystem) = eq == string::npos || value == "true";
+        }
+        else if (arg == "--include")
+        {
+            options.includes.push_back(value);
+        }
+        else if (arg == "--exclude")
+        {
+            options.excludes.push_back(value);
+        }
+        else if (arg == "--symlinks")
+        {
+            if (!parseSymlinkPolicy(value, options.symlinks))
+            {
+                throw invalid_argument("unknown symlink policy " + value);
+            }
+        }
+        else if (arg == "--hidden")
+        {
+            if (value != "include" && value != "skip")
+            {
+                throw invalid_argument("--hidden must be include or skip");
+            }
+            options.hidden = value == "include";
+        }
+        else if (arg == "--apple-metadata")
+        {
+            if (value != "include" && value != "skip")
+            {
+                throw invalid_argument("--apple-metadata must be include or skip");
+            }
+            options.appleMetadata = value == "include";
+        }
+        else if (arg == "--max-depth")
+        {
+            if (value.empty() || value.find_first_not_of("0123456789") != string::npos ||
+                !(istringstream(value) >> options.maxDepth))
+            {
+                throw invalid_argument("--max-depth needs a number of levels");
+            }
+        }
+        else if (arg == "--metadata")
+        {
+            if (!parseMetadataFields(value, options.metadata, options.xattrs))
+            {
+                throw invalid_argument("unknown metadata fields " + value + " (mode, owner, mtime, xattrs or none)");
+            }
+        }
+        else if (arg == "--special-files")
+        {
+            if (!parseSpecialFilePolicy(value, options.specialFiles))
+            {
+                throw invalid_argument("unknown special file policy " + value);
+            }
+        }
+        else if (arg == "--normalize")
+        {
+            if (!parseNameNormalization(value, options.normalization))
+            {
+                throw invalid_argument("unknown normalization form " + value);
+            }
+        }
+        else if (arg == "--hash-format")
+        {
+            if (!parseHashFormat(value, options.hashFormat))
+            {
+                throw invalid_argument("unknown hash format " + value);
+            }
+        }
+        else if (arg == "--min-file-size" || arg == "--max-file-size")
+        {
+            if (!parseFileSize(value, arg == "--min-file-size" ? options.minFileSize : options.maxFileSize))
+            {
+                throw invalid_argument("invalid size " + value);
+            }
+        }
+        else
+        {
+            throw invalid_argument("unknown option " + arg);
+        }
+        given = true;
+    }
+    return given;
+}
+
+/**
+ * @brief Get the value of an environment variable
+ * @param name Name of the variable
+ * @return Its value, empty if it is unset
+ */
+string env_value(const string &name)
+{
+    const char *value = getenv(name.c_str());
+    return value ? value : "";
+}
+
+/**
+ * @brief Apply walk options set through MTFS_* environment variables
+ *
+ * Every option has a variable named after it (--hash-format is
+ * MTFS_HASH_FORMAT); MTFS_INCLUDE and MTFS_EXCLUDE hold ':'-separated
+ * patterns and the switches take 1/0, true/false or yes/no. They are
+ * applied before the command line, which overrides them.
+ *
+ * @param options Receives the parsed options
+ * @return True if any variable was set
+ */
+bool env_walk_options(WalkOptions &options)
+{
+    static const vector<string> names = {"include", "exclude", "symlinks", "hidden", "apple-metadata", "max-depth", "min-file-size", "max-file-size",
+                                         "one-file-system", "xattrs", "metadata", "special-files", "normalize", "case-insensitive",
+                                         "hash-format"};
+    bool given = false;
+    for (const auto &name : names)
+    {
+        string variable = "MTFS_";
+        for (char c : name)
+        {
+            variable += c == '-' ? '_' : static_cast<char>(toupper(static_cast<unsigned char>(c)));
+        }
+        string value = env_value(variable);
+        if (value.empty())
+        {
+            continue;
+        }
+
+        vector<string> args;
+        if (name == "include" || name == "exclude")
+        {
+            istringstream patterns(value);
+            for (string pattern; getline(patterns, pattern, ':');)
+            {
+                if (!pattern.empty())
+                {
+                    args.push_back("--" + name + "=" + pattern);
+                }
+            }
+        }
+        else if (name == "one-file-system" || name == "xattrs" || name == "case-insensitive")
+        {
+            bool on = value == "1" || value == "true" || value == "yes";
+            if (!on && value != "0" && value != "false" && value != "no")
+            {
+                throw invalid_argument(variable + " must be 1 or 0");
+            }
+            args.push_back("--" + name + "=" + (on ? "true" : "false"));
+        }
+        else
+        {
+            args.push_back("--" + name + "=" + value);
+        }
+        try
+        {
+            given = parse_walk_options(args, options) || given;
+        }
+        catch (const invalid_argument &e)
+        {
+            throw invalid_argument(variable + ": " + e.what());
+        }
+    }
+    return given;
+}
+
+/**
+ * @brief Apply the chunk size, worker count, I/O limits, pre-scan and hash cache set through the environment
+ * @param mtree Tree to configure
+ * @throws invalid_argument If MTFS_CHUNK_SIZE, MTFS_WORKERS, MTFS_IO_LIMIT, MTFS_IOPS_LIMIT, MTFS_PRESCAN or MTFS_HASH_CACHE is invalid
+ */
+void env_tree_settings(MerkleTree &mtree)
+{
+    string chunk = env_value("MTFS_CHUNK_SIZE");
+    if (!chunk.empty())
+    {
+        size_t bytes = 0;
+        if (!parseFileSize(chunk, bytes))
+        {
+            throw invalid_argument("MTFS_CHUNK_SIZE: invalid size " + chunk);
+        }
+        try
+        {
+            mtree.setChunkSize(bytes);
+        }
+        catch (const runtime_error &e)
+        {
+            throw invalid_argument(string("MTFS_CHUNK_SIZE: ") + e.what());
+        }
+    }
+    string workers = env_value("MTFS_WORKERS");
+    if (!workers.empty())
+    {
+        size_t count = 0;
+        if (workers.find_first_not_of("0123456789") != string::npos || !(istringstream(workers) >> count) || count == 0)
+        {
+            throw invalid_argument("MTFS_WORKERS must be a positive number");
+        }
+        mtree.setWorkers(count);
+    }
+    IoLimits limits;
+    string bytes = env_value("MTFS_IO_LIMIT");
+    if (!bytes.empty() && bytes != "0" && !parseFileSize(bytes, limits.bytesPerSecond))
+    {
+        throw invalid_argument("MTFS_IO_LIMIT: invalid size " + bytes);
+    }
+    string reads = env_value("MTFS_IOPS_LIMIT");
+    if (!reads.empty() && (reads.find_first_not_of("0123456789") != string::npos || !(istringstream(reads) >> limits.readsPerSecond)))
+    {
+        throw invalid_argument("MTFS_IOPS_LIMIT must be a number of reads per second");
+    }
+    mtree.setIoLimits(limits);
+    string prescan = env_value("MTFS_PRESCAN");
+    if (!prescan.empty())
+    {
+        bool on = prescan == "1" || prescan == "true" || prescan == "yes";
+        if (!on && prescan != "0" && prescan != "false" && prescan != "no")
+        {
+            throw invalid_argument("MTFS_PRESCAN must be 1 or 0");
+        }
+        mtree.setPrescan(on);
+    }
+    string cache = env_value("MTFS_HASH_CACHE");
+    if (!cache.empty())
+    {
+        bool on = cache == "1" || cache == "true" || cache == "yes";
+        if (!on && cache != "0" && cache != "false" && cache != "no")
+        {
+            throw invalid_argument("MTFS_HASH_CACHE must be 1 or 0");
+        }
+        mtree.setHashCache(on);
+    }
+}
+
+/**
+ * @brief Lower the priority of the backend as set through the environment
+ * @throws invalid_argument If MTFS_NICE or MTFS_IONICE is invalid
+ * @throws runtime_error If the kernel refuses the priority
+ */
+void env_priority()
+{
+    optional<int> niceness;
+    string nice = env_value("MTFS_NICE");
+    if (!nice.empty())
+    {
+        if (nice.size() > 2 || nice.find_first_not_of("0123456789") != string::npos || stoi(nice) > 19)
+        {
+            throw invalid_argument("MTFS_NICE must be from 0 to 19");
+        }
+        niceness = stoi(nice);
+    }
+    try
+    {
+        lowerPriority(niceness, env_value("MTFS_IONICE"));
+    }
+    catch (const invalid_argument &e)
+    {
+        // The niceness is checked above, so the class is at fault
+        throw invalid_argument(string("MTFS_IONICE: ") + e.what());
+    }
+}
+
+/**
+ * @brief Report what the last build left out of the tree
+ * @param mtree Tree that was built
+ * @param directory Directory it was built from
+ * @param recorded False for a dry run, whose skipped entries are not recorded
+ */
+void print_skipped(const MerkleTree &mtree, const string &directory, bool recorded = true)
+{
+    auto unreadable = mtree.getUnreadableEntries();
+    if (!unreadable.empty())
+    {
+        cout << "Unreadable entries: " << unreadable.size() << " (recorded as error leaves)\n";
+    }
+    for (const auto &[path, reason] : unreadable)
+    {
+        cout << "Unreadable: " << path << " (" << reason << ")\n";
+    }
+    for (const auto &[reason, count] : mtree.getSkippedEntries())
+    {
+        cout << "Skipped " << count << " entries (" << reason << ").\n";
+    }
+    for (const auto &cycle : mtree.getSymlinkCycles())
+    {
+        cout << "Symlink cycle: " << cycle << " (not followed)\n";
+    }
+    if (recorded && !mtree.getSkippedEntries().empty())
+    {
+        cout << "Skipped entries are listed in " << stateDirectory(directory).filename().string() << "/" << MTFSConstants::SKIPPED_FILE << ".\n";
+    }
+}
+
+void print_config(const MerkleTree &mtree)
+{
+    if (mtree.getIoLimits().active())
+    {
+        cout << "I/O limit: " << mtree.getIoLimits().describe() << endl;
+    }
+    if (!mtree.getConfigFile().empty())
+    {
+        cout << "Tree config: " << mtree.getConfigFile().string() << " (chunk size " << mtree.getChunkSize() << " bytes)\n";
+    }
+}
+
+int main(int argc, char **argv) 
 {
     MerkleTree mtree;
+    WalkOptions options;
+    bool optionsGiven = false;
+    try
+    {
+        bool fromEnv = env_walk_options(options);
+        optionsGiven = parse_walk_options(vector<string>(argv + 1, argv + argc), options) || fromEnv;
+        if (optionsGiven)
+        {
+            mtree.setWalkOptions(options);
+        }
+        env_tree_settings(mtree);
+        env_priority();
+    }
+    catch (const invalid_argument &e)
+    {
+        cerr << "Error: " << e.what() << endl;
+        print_usage(argv[0]);
+        return 2;
+    }
+    catch (const runtime_error &e)
+    {
+        // Running at the normal priority is better than not running
+        cerr << "Warning: " << e.what() << endl;
+    }
     shared_ptr<MerkleNode> root = nullptr;
     string directory;
     bool tree_built = false;
@@ -29,8 +431,18 @@ int main()
     {
         print_menu();
         int choice;
-        cin >> choice;
-        cin.ignore();
+        if (!(cin >> choice))
+        {
+            // Input ran out (a pipe or /dev/null): nothing more will come
+            if (cin.eof())
+                return 0;
+            // Not a number: drop the line and ask again
+            cin.clear();
+            cin.ignore(numeric_limits<streamsize>::max(), '\n');
+            choice = 0;
+        }
+        else
+            cin.ignore();
 
         switch (choice) 
         {
@@ -43,6 +455,22 @@ int main()
                     root = mtree.build_tree(directory);
                     tree_built = true;
                     cout << "Merkle tree built successfully.\n";
+                    cout << "Root hash: " << root->hash << endl;
+                    auto [totalFiles, totalDirs, totalSize] = mtree.getTreeStats();
+                    cout << "Tree size: " << totalFiles << " files, " << totalDirs << " directories, "
+                         << totalSize << " bytes" << endl;
+                    if (mtree.getResumedFiles() > 0)
+                    {
+                        cout << "Resumed " << mtree.getResumedFiles() << " files from checkpoint.\n";
+                    }
+                    if (mtree.getCachedFiles() > 0)
+                    {
+                        cout << "Hash cache: " << mtree.getCachedFiles() << " unchanged files not hashed again.\n";
+                    }
+                    print_skipped(mtree, directory);
+                    cout << "Scope: " << mtree.getWalkOptions().describe() << endl;
+                    print_config(mtree);
+                    cout << "Build throughput: " << formatThroughput(mtree.getBuildThroughput()) << endl;
                 } 
                 catch (const exception &e) 
                 {
@@ -80,9 +508,41 @@ int main()
                 auto [totalFiles, totalDirs, totalSize] = mtree.getTreeStats();
                 cout << "Total files: " << totalFiles << endl;
                 cout << "Total directories: " << totalDirs << endl;
-                cout << "Total size: " << formatFileSize(totalSize) << endl;
+                cout << "Total size: " << totalSize << " bytes (" << formatFileSize(totalSize) << ")" << endl;
+                if (mtree.getSpecialFileCount() > 0)
+                {
+                    cout << "Special files: " << mtree.getSpecialFileCount() << " recorded" << endl;
+                }
+                auto [hardlinks, linkedBytes] = mtree.getHardlinkStats();
+                if (hardlinks > 0)
+                {
+                    cout << "Hardlinks: " << hardlinks << " (" << linkedBytes << " bytes not counted again)" << endl;
+                }
                 cout << "Tree depth: " << root->getDepth() << endl;
                 cout << "Root hash: " << root->hash << endl;
+                cout << "Scope: " << mtree.getWalkOptions().describe() << endl;
+                print_config(mtree);
+                print_skipped(mtree, directory);
+
+                ThroughputSummary build = mtree.getBuildThroughput();
+                cout << "Build throughput: " << formatThroughput(build) << endl;
+                cout << "Build time: " << fixed << setprecision(2) << build.seconds << " s" << endl;
+
+                ThroughputSummary verify = mtree.getVerifyThroughput();
+                if (verify.files > 0)
+                {
+                    cout << "Verify throughput: " << formatThroughput(verify) << endl;
+                    cout << "Verify time: " << fixed << setprecision(2) << verify.seconds << " s" << endl;
+                }
+
+                vector<OperationMetrics> history = mtree.getMetricsHistory();
+                for (const string operation : {"build", "verify"})
+                {
+                    for (const auto &line : describeTrend(history, operation, MTFSConstants::TREND_RUNS))
+                    {
+                        cout << line << endl;
+                    }
+                }
                 break;
             }
             case 5: 
@@ -94,6 +554,7 @@ int main()
                 }
                 bool valid = mtree.verifyTreeIntegrity();
                 cout << (valid ? "Tree integrity verified: OK" : "Tree integrity check FAILED!") << endl;
+                cout << "Verify throughput: " << formatThroughput(mtree.getVerifyThroughput()) << endl;
                 break;
             }
             case 6: 
@@ -126,6 +587,60 @@ int main()
                 cout << "Exiting.\n";
                 return 0;
             }
+            case 9: 
+            {
+                cout << "Enter directory path: ";
+                string previewed;
+                getline(cin, previewed);
+                try 
+                {
+                    // A tree of its own leaves the built one as it is
+                    MerkleTree dry;
+                    if (optionsGiven)
+                    {
+                        dry.setWalkOptions(options);
+                    }
+                    env_tree_settings(dry);
+                    dry.setDryRun(true);
+                    dry.build_tree(previewed);
+                    cout << "Dry run: nothing was hashed or recorded.\n";
+                    auto [totalFiles, totalDirs, totalSize] = dry.getTreeStats();
+                    cout << "Would hash: " << totalFiles << " files, " << totalDirs << " directories, "
+                         << totalSize << " bytes" << endl;
+                    print_skipped(dry, previewed, false);
+                    for (const auto &[reason, path] : dry.getSkippedPaths())
+                    {
+                        cout << "Would skip: " << path << " (" << reason << ")\n";
+                    }
+                    cout << "Scope: " << dry.getWalkOptions().describe() << endl;
+                    print_config(dry);
+                } 
+                catch (const exception &e) 
+                {
+                    cerr << "Error: " << e.what() << endl;
+                }
+                break;
+            }
+            case 10:
+            {
+                cout << "Enter path in the tree: ";
+                string path;
+                getline(cin, path);
+                if (!tree_built)
+                {
+                    cout << "Build the tree first (option 1).\n";
+                    break;
+                }
+                try
+                {
+                    cout << mtree.listDirectoryJson(path) << endl;
+                }
+                catch (const exception &e)
+                {
+                    cerr << "Error: " << e.what() << endl;
+                }
+                break;
+            }
             default:
                 cout << "Invalid option. Try again.\n";
                 break;
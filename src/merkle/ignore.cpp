@@ -0,0 +1,260 @@
+#include "merkle.hpp"
+
+// Recursive matcher behind globMatch
+static bool globMatchAt(const char *p, const char *s)
+{
+    while (*p)
+    {
+        if (p[0] == '*' && p[1] == '*')
+        {
+            while (*p == '*')
+            {
+                ++p;
+            }
+            if (*p == '/')
+            {
+                // "**/" matches zero or more whole directories
+                ++p;
+                if (globMatchAt(p, s))
+                {
+                    return true;
+                }
+                for (const char *c = s; *c; ++c)
+                {
+                    if (*c == '/' && globMatchAt(p, c + 1))
+                    {
+                        return true;
+                    }
+                }
+                return false;
+            }
+            for (const char *c = s;; ++c)
+            {
+                if (globMatchAt(p, c))
+                {
+                    return true;
+                }
+                if (!*c)
+                {
+                    return false;
+                }
+            }
+        }
+
+        switch (*p)
+        {
+        case '*':
+            ++p;
+            for (const char *c = s;; ++c)
+            {
+                if (globMatchAt(p, c))
+                {
+                    return true;
+                }
+                if (!*c || *c == '/')
+                {
+                    return false;
+                }
+            }
+        case '?':
+            if (!*s || *s == '/')
+            {
+                return false;
+            }
+            ++p;
+            ++s;
+            break;
+        case '[':
+        {
+            if (!*s || *s == '/')
+            {
+                return false;
+            }
+            const char *c = p + 1;
+            bool negate = (*c == '!' || *c == '^');
+            if (negate)
+            {
+                ++c;
+            }
+            bool matched = false;
+            bool first = true;
+            while (*c && (first || *c != ']'))
+            {
+                first = false;
+                char lo = *c;
+                char hi = lo;
+                if (c[1] == '-' && c[2] && c[2] != ']')
+                {
+                    hi = c[2];
+                    c += 2;
+                }
+                if (*s >= lo && *s <= hi)
+                {
+                    matched = true;
+                }
+                ++c;
+            }
+            if (*c != ']')
+            {
+                // Unterminated class: treat '[' literally
+                if (*s != '[')
+                {
+                    return false;
+                }
+                ++p;
+                ++s;
+                break;
+            }
+            if (matched == negate)
+            {
+                return false;
+            }
+            p = c + 1;
+            ++s;
+            break;
+        }
+        case '\\':
+            if (p[1])
+            {
+                ++p;
+            }
+            [[fallthrough]];
+        default:
+            if (*p != *s)
+            {
+                return false;
+            }
+            ++p;
+            ++s;
+        }
+    }
+    return *s == '\0';
+}
+
+/**
+ * @brief Match a path against a gitignore-style glob
+ * @param pattern Glob using *, ?, [...], ** and backslash escapes
+ * @param path Slash-separated path
+ * @return True if the whole path matches
+ */
+bool globMatch(const string &pattern, const string &path)
+{
+    return globMatchAt(pattern.c_str(), path.c_str());
+}
+
+/**
+ * @brief Extend parent rules with the ignore file of a directory
+ * @param parent Rules in effect for the parent directory (may be null)
+ * @param dir Filesystem path of the directory
+ * @param relDir Path of the directory relative to the tree root ("" for the root)
+ * @return Rules in effect inside the directory
+ */
+shared_ptr<const IgnoreRules> IgnoreRules::forDirectory(const shared_ptr<const IgnoreRules> &parent,
+                                                        const fs::path &dir, const string &relDir)
+{
+    ifstream in(dir / MTFSConstants::IGNORE_FILE);
+    if (!in.is_open())
+    {
+        return parent ? parent : make_shared<const IgnoreRules>();
+    }
+
+    auto rules = parent ? make_shared<IgnoreRules>(*parent) : make_shared<IgnoreRules>();
+    string line;
+    while (getline(in, line))
+    {
+        if (!line.empty() && line.back() == '\r')
+        {
+            line.pop_back();
+        }
+        // Trailing spaces are ignored unless escaped
+        while (!line.empty() && line.back() == ' ' && (line.size() < 2 || line[line.size() - 2] != '\\'))
+        {
+            line.pop_back();
+        }
+        if (line.empty() || line[0] == '#')
+        {
+            continue;
+        }
+
+        IgnoreRule rule;
+        rule.base = relDir;
+        if (line[0] == '!')
+        {
+            rule.negate = true;
+            line.erase(0, 1);
+        }
+        else if (line[0] == '\\' && line.size() > 1 && (line[1] == '!' || line[1] == '#'))
+        {
+            line.erase(0, 1);
+        }
+        if (!line.empty() && line.back() == '/')
+        {
+            rule.dirOnly = true;
+            line.pop_back();
+        }
+        // A slash anywhere but the end anchors the pattern to this directory
+        rule.anchored = line.find('/') != string::npos;
+        if (!line.empty() && line[0] == '/')
+        {
+            line.erase(0, 1);
+        }
+        if (line.empty())
+        {
+            continue;
+        }
+        rule.pattern = line;
+        rules->rules.push_back(rule);
+    }
+    return rules;
+}
+
+/**
+ * @brief Check whether an entry is ignored
+ * @param relPath Path of the entry relative to the tree root
+ * @param isDir True if the entry is a directory
+ * @return True if the last matching rule excludes the entry
+ */
+bool IgnoreRules::ignored(const string &relPath, bool isDir) const
+{
+    bool result = false;
+    for (const auto &rule : rules)
+    {
+        if (rule.dirOnly && !isDir)
+        {
+            continue;
+        }
+
+        string candidate = relPath;
+        if (!rule.base.empty())
+        {
+            if (relPath.compare(0, rule.base.size() + 1, rule.base + "/") != 0)
+            {
+                continue;
+            }
+            candidate = relPath.substr(rule.base.size() + 1);
+        }
+        if (!rule.anchored)
+        {
+            size_t slash = candidate.rfind('/');
+            if (slash != string::npos)
+            {
+                candidate = candidate.substr(slash + 1);
+            }
+        }
+
+        if (globMatch(rule.pattern, candidate))
+        {
+            result = !rule.negate;
+        }
+    }
+    return result;
+}
+
+/**
+ * @brief Check whether any rules are in effect
+ * @return True if no ignore file contributed rules
+ */
+bool IgnoreRules::empty() const
+{
+    return rules.empty();
+}
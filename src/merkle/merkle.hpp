@@ -19,6 +19,7 @@
 #include <vector>
 #include <memory>
 #include <map>
+#include <set>
 #include <tuple>
 #include <filesystem>
 #include <iostream>
@@ -27,6 +28,13 @@
 #include <iomanip>
 #include <algorithm>
 #include <stdexcept>
+#include <chrono>
+#include <mutex>
+#include <atomic>
+#include <future>
+#include <thread>
+#include <optional>
+#include <sys/stat.h>
 
 #pragma once
 
@@ -34,6 +42,52 @@ using namespace std;
 
 namespace fs = std::filesystem;
 
+/**
+ * @enum HashFormat
+ * @brief How leaves and directories are hashed into the tree
+ */
+enum class HashFormat
+{
+    Mtfs,        // SHA-256 of contents and of the sorted child hashes
+    GitSha1,     // Git blob and tree object ids, as in a SHA-1 repository
+    GitSha256,   // Git blob and tree object ids, as in a SHA-256 repository
+    MtfsSha512,  // The mtfs format with SHA-512
+    MtfsBlake2b, // The mtfs format with BLAKE2b-512
+    MtfsBlake3   // The mtfs format with BLAKE3, which only the Go engine hashes
+};
+
+/**
+ * @brief Parse a hash format name
+ * @param name "mtfs", "git", "git-sha256", "mtfs-sha512", "mtfs-blake2b"
+ *             or "mtfs-blake3"
+ * @param format Receives the parsed format
+ * @return True if the name is known
+ */
+bool parseHashFormat(const string &name, HashFormat &format);
+
+/**
+ * @brief Check whether a hash format hashes nodes into Git object ids
+ * @param format Format to check
+ * @return True for GitSha1 and GitSha256
+ */
+bool isGitFormat(HashFormat format);
+
+/**
+ * @brief Hash data with the algorithm of an mtfs hash format
+ * @param format Hash format; the Git formats hash chunks with SHA-256
+ * @param data Data to hash
+ * @return Hexadecimal digest
+ * @throws runtime_error If the algorithm is not available
+ */
+string formatDigest(HashFormat format, const string &data);
+
+/**
+ * @brief Get the name of a hash format
+ * @param format Format to name
+ * @return Name accepted by parseHashFormat
+ */
+string hashFormatName(HashFormat format);
+
 /**
  * @struct MerkleNode
  * @brief Represents a node in the Merkle tree structure
@@ -52,8 +106,21 @@ public:
 
     map<string, shared_ptr<MerkleNode>> children; // Child nodes (for directories)
 
-    bool isFile;     // Flag indicating if this is a file (true) or directory (false)
-    size_t fileSize; // Size of the file in bytes (for files only)
+    bool isFile;       // Flag indicating if this is a file (true) or directory (false)
+    size_t fileSize;   // Size of the file in bytes (for files only)
+    bool isSymlink;    // Leaf recording a symbolic link rather than file content
+    bool executable;   // File is executable by its owner (Git mode 100755)
+    string linkTarget; // Target of the link as stored (for symlinks only)
+    dev_t device;      // Device of a file with several hard links (0 otherwise)
+    ino_t inode;       // Inode of a file with several hard links (0 otherwise)
+    string hardlinkOf; // Earlier path in the tree sharing this file's inode
+    string specialType;         // "fifo", "socket", "char-device" or "block-device" for recorded special files
+    string deviceNumber;        // "major:minor" of a recorded device
+    map<string, string> xattrs; // Extended attributes, ACLs included (when captured)
+    string xattrsHash;          // Hash of the serialized attributes, the metadata leaf
+    map<string, string> metadata; // Mode, owner and modification time (when captured)
+    string metadataHash;          // Hash of the serialized metadata
+    string error;               // Why the entry could not be read (error leaves only)
 
     /**
      * @brief Constructor for MerkleNode
@@ -74,9 +141,15 @@ public:
      * @return String containing the calculated hash
      *
      * For files: Returns the content hash
+     * For symlinks: Hash of the link target, distinct from a file holding it
+     * For special files: Hash of the file type and device number
+     * For error leaves: Hash of the reason the entry could not be read
      * For directories: Calculates hash based on sorted children hashes
+     * Captured metadata and extended attributes are hashed into leaves
+     * that are combined with the hash above, in that order.
+     * In the Git formats the hash is the node's Git object id instead.
      */
-    string calculateHash();
+    string calculateHash(HashFormat format = HashFormat::Mtfs);
 
     /**
      * @brief Get the depth of this node in the tree
@@ -104,15 +177,812 @@ public:
 
 private:
     /**
-     * @brief Helper function to calculate SHA-256 hash of data
-     * @param data Input data to hash
-     * @return Hexadecimal string representation of the hash
+     * @brief Calculate the Git object id of this node
+     * @param format GitSha1 or GitSha256
+     * @return Blob id of a file or link target, tree id of a directory
      */
-    string sha256(const string &data);
+    string calculateGitId(HashFormat format);
 
     mutable int cachedDepth; // Cached depth value for performance
 };
 
+/**
+ * @struct ThroughputSummary
+ * @brief Aggregate throughput figures for a completed operation
+ */
+struct ThroughputSummary
+{
+    size_t bytes = 0;           // Total bytes processed
+    size_t files = 0;           // Total files processed
+    double seconds = 0;         // Wall-clock duration of the operation
+    double avgBytesPerSec = 0;  // Average bytes per second over the whole run
+    double avgFilesPerSec = 0;  // Average files per second over the whole run
+    double peakBytesPerSec = 0; // Highest rolling bytes per second observed
+    double peakFilesPerSec = 0; // Highest rolling files per second observed
+};
+
+/**
+ * @class ThroughputMeter
+ * @brief Tracks rolling and aggregate throughput of hashing operations
+ *
+ * The meter samples its counters every REPORT_INTERVAL and prints a
+ * progress line with the rolling MB/s and files/s, keeping track of the
+ * peak rates so they can be reported once the operation finishes. Once
+ * told what the operation is expected to total, the line also shows the
+ * share done and an ETA from the smoothed rate of the recent windows, so
+ * the estimate follows the throughput actually reached.
+ */
+class ThroughputMeter
+{
+public:
+    /**
+     * @brief Constructor for ThroughputMeter
+     * @param label Operation name used in progress lines (e.g. "Build")
+     */
+    explicit ThroughputMeter(const string &label = "");
+
+    /**
+     * @brief Reset all counters and start timing a new operation
+     */
+    void start();
+
+    /**
+     * @brief Record processed data, printing a progress line when due
+     * @param bytes Number of bytes processed
+     * @param files Number of files processed
+     */
+    void record(size_t bytes, size_t files);
+
+    /**
+     * @brief Set the totals the operation is expected to reach
+     * @param files Number of files a pre-scan found
+     * @param bytes Number of bytes a pre-scan found
+     */
+    void expect(size_t files, size_t bytes);
+
+    /**
+     * @brief Count bytes as done without processing them
+     * @param bytes Number of bytes, such as those of a file resumed from a checkpoint
+     */
+    void skip(size_t bytes);
+
+    /**
+     * @brief Give a file being hashed the first free lane
+     * @param path Path of the file relative to the tree root
+     * @param size Size of the file in bytes
+     * @return Lane of the file
+     */
+    size_t begin(const string &path, size_t size);
+
+    /**
+     * @brief Record bytes hashed of the file in a lane, as record does
+     * @param lane Lane returned by begin
+     * @param bytes Number of bytes hashed
+     */
+    void advance(size_t lane, size_t bytes);
+
+    /**
+     * @brief Free a lane once its file is hashed
+     * @param lane Lane returned by begin
+     */
+    void end(size_t lane);
+
+    /**
+     * @brief Stop timing and compute the final summary
+     * @return Aggregate figures for the operation
+     */
+    ThroughputSummary finish();
+
+private:
+    using Clock = std::chrono::steady_clock;
+
+    string label;                  // Operation name used in progress lines
+    Clock::time_point startTime;   // When the operation started
+    Clock::time_point sampleTime;  // When the current sampling window started
+    size_t totalBytes;             // Bytes processed since start
+    size_t totalFiles;             // Files processed since start
+    size_t sampleBytes;            // Bytes processed in the current window
+    size_t sampleFiles;            // Files processed in the current window
+    double peakBytesPerSec;        // Highest rolling byte rate so far
+    double peakFilesPerSec;        // Highest rolling file rate so far
+    size_t expectedFiles;          // Files the operation is expected to total (0 if unknown)
+    size_t expectedBytes;          // Bytes the operation is expected to total
+    size_t skippedBytes;           // Bytes counted as done without being processed
+    double smoothedBytesPerSec;    // Moving average of the window byte rates
+    double smoothedFilesPerSec;    // Moving average of the window file rates
+
+    /**
+     * @struct Lane
+     * @brief File a worker is hashing
+     */
+    struct Lane
+    {
+        bool busy = false;
+        string path;
+        size_t done = 0;
+        size_t size = 0;
+    };
+    vector<Lane> lanes;            // Files being hashed, by worker
+
+    /**
+     * @brief Close the current sampling window and update peak rates
+     * @param now Time at which the window is closed
+     * @return Tuple containing (bytes_per_sec, files_per_sec) of the window
+     */
+    tuple<double, double> closeWindow(Clock::time_point now);
+};
+
+/**
+ * @struct IoLimits
+ * @brief Bounds on the rate files are read at during builds
+ */
+struct IoLimits
+{
+    size_t bytesPerSecond = 0; // Bytes read per second (0 for no limit)
+    size_t readsPerSecond = 0; // Reads per second (0 for no limit)
+
+    /**
+     * @brief Check whether any limit is set
+     * @return True if reads are throttled
+     */
+    bool active() const;
+
+    /**
+     * @brief Describe the limits for the build report
+     * @return Text such as "10 MB/s, 200 reads/s"
+     */
+    string describe() const;
+};
+
+/**
+ * @class Throttle
+ * @brief Paces the reads of all walker threads to the IoLimits of a build
+ *
+ * Every read reserves the next free slot of the shared schedule, as long
+ * as its bytes or its share of the reads allowed per second take, and
+ * waits for the slot outside the lock, so concurrent readers together
+ * stay within the limits.
+ */
+class Throttle
+{
+public:
+    /**
+     * @brief Set the limits of the following reads
+     * @param limits Limits to apply; no limits turn throttling off
+     */
+    void configure(const IoLimits &limits);
+
+    /**
+     * @brief Account for a read, waiting until the limits allow it
+     * @param bytes Number of bytes the read returned
+     */
+    void acquire(size_t bytes);
+
+private:
+    using Clock = std::chrono::steady_clock;
+
+    IoLimits limits;             // Limits in force
+    Clock::time_point next;      // When the next read may go ahead
+    mutex scheduleMutex;         // Guards next between walker threads
+};
+
+/**
+ * @brief Lower the CPU and I/O priority of the backend
+ *
+ * Called before any worker thread starts, so they all inherit it.
+ *
+ * @param niceness CPU niceness from 0 (normal) to 19 (lowest)
+ * @param ioClass "idle", "best-effort" or "best-effort:N" with N from 0 to 7, empty to keep the I/O class
+ * @throws invalid_argument If a value is out of range
+ * @throws runtime_error If the kernel refuses the priority
+ */
+void lowerPriority(optional<int> niceness, const string &ioClass);
+
+/**
+ * @struct OperationMetrics
+ * @brief Figures of one past build or verification of a tree
+ */
+struct OperationMetrics
+{
+    long long time = 0;        // Unix time the operation finished
+    string operation;          // "build" or "verify"
+    bool ok = true;            // The verification passed (always true for builds)
+    ThroughputSummary summary; // Duration, volume and rates of the operation
+};
+
+/**
+ * @brief Add the figures of an operation to the metrics history of a tree
+ *
+ * The history is kept in METRICS_FILE in the state directory and holds
+ * the last METRICS_HISTORY operations.
+ *
+ * @param treeRoot Root directory of the tree
+ * @param metrics Figures of the operation
+ * @return True if the history was written
+ */
+bool appendMetrics(const fs::path &treeRoot, const OperationMetrics &metrics);
+
+/**
+ * @brief Read the metrics history of a tree
+ * @param treeRoot Root directory of the tree
+ * @return Past operations, oldest first (empty if there is no history)
+ */
+vector<OperationMetrics> loadMetrics(const fs::path &treeRoot);
+
+/**
+ * @brief Summarize how an operation developed over its recent runs
+ * @param history Metrics history of a tree, oldest first
+ * @param operation "build" or "verify"
+ * @param runs Number of most recent runs to consider
+ * @return Lines with duration and throughput ranges and sparklines (empty without runs)
+ */
+vector<string> describeTrend(const vector<OperationMetrics> &history, const string &operation, size_t runs);
+
+/**
+ * @struct CheckpointEntry
+ * @brief A completed file recorded in a build checkpoint
+ */
+struct CheckpointEntry
+{
+    size_t fileSize = 0;        // Size of the file when it was hashed
+    long long mtime = 0;        // Modification time when it was hashed
+    string contentHash;         // Hash of the file content
+    vector<string> chunkHashes; // Hashes of individual chunks
+};
+
+/**
+ * @class BuildCheckpoint
+ * @brief Persists build progress so interrupted builds can resume
+ *
+ * Completed files are appended to <tree>/.mtfs/checkpoint as they are
+ * hashed and flushed every CHECKPOINT_INTERVAL seconds. When a build of
+ * the same tree with the same chunk size starts while a checkpoint
+ * exists, files whose size and modification time are unchanged are taken
+ * from the checkpoint instead of being hashed again, so whole completed
+ * subtrees are skipped. Once a build succeeds its checkpoint is kept as
+ * <tree>/.mtfs/hashcache, and the next build takes the files whose size
+ * and modification time are unchanged from it in the same way, so only
+ * the files that changed are hashed again. Files modified less than
+ * HASH_CACHE_RACY seconds before the cache was written could have changed
+ * again within the same timestamp, so they are always hashed.
+ */
+class BuildCheckpoint
+{
+public:
+    /**
+     * @brief Open (or create) the checkpoint of a tree
+     * @param treeRoot Root directory of the tree being built
+     * @param chunkSize Chunk size of the build; older checkpoints with a different size are discarded
+     * @param format Hash format of the build; older checkpoints with a different format are discarded
+     * @param useCache True to take unchanged files from the hash cache of the last build
+     * @return True if checkpointing is active, false if the state directory is not writable
+     */
+    bool open(const fs::path &treeRoot, size_t chunkSize, HashFormat format, bool useCache);
+
+    /**
+     * @brief Look up a completed file
+     * @param relPath Path of the file relative to the tree root
+     * @param fileSize Current size of the file
+     * @param mtime Current modification time of the file
+     * @return Pointer to the entry if the file is unchanged, nullptr otherwise
+     */
+    const CheckpointEntry *find(const string &relPath, size_t fileSize, long long mtime) const;
+
+    /**
+     * @brief Look up a file hashed by the last successful build
+     * @param relPath Path of the file relative to the tree root
+     * @param fileSize Current size of the file
+     * @param mtime Current modification time of the file
+     * @return Pointer to the entry if the file is unchanged, nullptr otherwise
+     */
+    const CheckpointEntry *findCached(const string &relPath, size_t fileSize, long long mtime) const;
+
+    /**
+     * @brief Record a completed file
+     * @param relPath Path of the file relative to the tree root
+     * @param entry Hashes and stat data of the file
+     */
+    void record(const string &relPath, const CheckpointEntry &entry);
+
+    /**
+     * @brief Keep the checkpoint of a successful build as the hash cache
+     */
+    void complete();
+
+    /**
+     * @brief Get the number of files loaded from an earlier checkpoint
+     * @return Number of resumable files
+     */
+    size_t resumableCount() const;
+
+    /**
+     * @brief Get the number of files loaded from the hash cache
+     * @return Number of files the last successful build hashed
+     */
+    size_t cachedCount() const;
+
+private:
+    fs::path filePath;                               // Location of the checkpoint file
+    fs::path cachePath;                              // Location of the hash cache
+    map<string, CheckpointEntry> loaded;             // Entries loaded from an earlier run
+    map<string, CheckpointEntry> cached;             // Entries of the last successful build
+    ofstream out;                                    // Append stream for new entries
+    std::chrono::steady_clock::time_point lastFlush; // Time of the last flush
+
+    /**
+     * @brief Load entries from an existing checkpoint file
+     * @param file Checkpoint or hash cache to read
+     * @param header Header line the checkpoint must have been written with
+     * @param entries Map receiving the entries, by path
+     * @return True if the file existed and matched the chunk size and hash format
+     */
+    static bool load(const fs::path &file, const string &header, map<string, CheckpointEntry> &entries);
+};
+
+/**
+ * @struct IgnoreRule
+ * @brief One pattern line of an ignore file
+ */
+struct IgnoreRule
+{
+    string base;           // Directory of the ignore file, relative to the tree root
+    string pattern;        // Glob pattern without leading '!' or trailing '/'
+    bool negate = false;   // Pattern re-includes matches ("!pattern")
+    bool dirOnly = false;  // Pattern only matches directories ("pattern/")
+    bool anchored = false; // Pattern is matched against the path below base, not just the name
+};
+
+/**
+ * @class IgnoreRules
+ * @brief gitignore-style exclusion rules from .mtfsignore files
+ *
+ * Every directory may contain an IGNORE_FILE whose rules apply to the
+ * entries below it. Rules of deeper files are appended after those of
+ * their parents and the last matching rule decides, as in git. Instances
+ * are immutable, so walker threads can share them.
+ */
+class IgnoreRules
+{
+public:
+    /**
+     * @brief Extend parent rules with the ignore file of a directory
+     * @param parent Rules in effect for the parent directory (may be null)
+     * @param dir Filesystem path of the directory
+     * @param relDir Path of the directory relative to the tree root ("" for the root)
+     * @return Rules in effect inside the directory
+     */
+    static shared_ptr<const IgnoreRules> forDirectory(const shared_ptr<const IgnoreRules> &parent,
+                                                      const fs::path &dir, const string &relDir);
+
+    /**
+     * @brief Check whether an entry is ignored
+     * @param relPath Path of the entry relative to the tree root
+     * @param isDir True if the entry is a directory
+     * @return True if the last matching rule excludes the entry
+     */
+    bool ignored(const string &relPath, bool isDir) const;
+
+    /**
+     * @brief Check whether any rules are in effect
+     * @return True if no ignore file contributed rules
+     */
+    bool empty() const;
+
+private:
+    vector<IgnoreRule> rules; // Rules in evaluation order
+};
+
+/**
+ * @enum SymlinkPolicy
+ * @brief How symbolic links met during a walk are treated
+ */
+enum class SymlinkPolicy
+{
+    Follow, // Hash what the link points to (cycles are detected and skipped)
+    Record, // Add a leaf hashing the link target string
+    Skip    // Leave links out of the tree
+};
+
+/**
+ * @enum NameNormalization
+ * @brief Unicode normalization applied to file names before they are hashed
+ */
+enum class NameNormalization
+{
+    None, // Names are hashed as the raw bytes stored by the file system
+    NFC,  // Composed form, as Linux tools usually write names
+    NFD   // Decomposed form, as HFS+ stores names
+};
+
+/**
+ * @brief Parse a name normalization form
+ * @param name "none", "nfc" or "nfd"
+ * @param form Receives the parsed form
+ * @return True if the name is known
+ */
+bool parseNameNormalization(const string &name, NameNormalization &form);
+
+/**
+ * @brief Get the name of a normalization form
+ * @param form Form to name
+ * @return Name accepted by parseNameNormalization
+ */
+string nameNormalizationName(NameNormalization form);
+
+/**
+ * @brief Get the canonical form of a file name
+ * @param raw Name as stored by the file system
+ * @param form Normalization applied to valid UTF-8
+ * @return The raw name for NameNormalization::None; otherwise the normalized
+ *         name with bytes that are not valid UTF-8 written as "\xHH" and
+ *         backslashes doubled, so distinct raw names stay distinct
+ */
+string canonicalName(const string &raw, NameNormalization form);
+
+/**
+ * @brief Get the key under which names collide on a case-insensitive file system
+ * @param name Name as returned by canonicalName
+ * @return The name with every character case-folded, bytes that are not
+ *         valid UTF-8 written as "\xHH" and backslashes doubled
+ */
+string caseFoldedName(const string &name);
+
+/**
+ * @brief Check whether a name is one macOS keeps its metadata under
+ * @param name File or directory name
+ * @return True for AppleDouble files ("._name", holding the resource fork
+ *         and Finder info on file systems without attributes), .DS_Store
+ *         and the __MACOSX directory of zip archives
+ */
+bool isAppleMetadataName(const string &name);
+
+/**
+ * @enum SpecialFilePolicy
+ * @brief How devices, sockets and FIFOs met during a walk are treated
+ */
+enum class SpecialFilePolicy
+{
+    Skip,   // Leave them out of the tree
+    Record, // Add a leaf hashing the file type and device number only
+    Error   // Fail the build
+};
+
+/**
+ * @brief Parse a special file policy name
+ * @param name "skip", "record" or "error"
+ * @param policy Receives the parsed policy
+ * @return True if the name is known
+ */
+bool parseSpecialFilePolicy(const string &name, SpecialFilePolicy &policy);
+
+/**
+ * @brief Get the name of a special file policy
+ * @param policy Policy to name
+ * @return Name accepted by parseSpecialFilePolicy
+ */
+string specialFilePolicyName(SpecialFilePolicy policy);
+
+/**
+ * @enum MetadataField
+ * @brief File metadata hashed with each entry, as bits of WalkOptions::metadata
+ */
+enum MetadataField : unsigned
+{
+    MetadataMode = 1,  // Permission bits, setuid, setgid and sticky included
+    MetadataOwner = 2, // Numeric user and group ids
+    MetadataMtime = 4  // Modification time in whole seconds
+};
+
+/**
+ * @brief Parse a list of metadata fields
+ * @param list Comma-separated "mode", "owner", "mtime" and "xattrs", or "none"
+ * @param fields Receives the MetadataField bits
+ * @param xattrs Set if the list names extended attributes, which --xattrs hashes
+ * @return True if every name is known
+ */
+bool parseMetadataFields(const string &list, unsigned &fields, bool &xattrs);
+
+/**
+ * @brief Get the names of metadata fields
+ * @param fields MetadataField bits
+ * @return Comma-separated names in a fixed order, "none" if there are none
+ */
+string metadataFieldsName(unsigned fields);
+
+/**
+ * @brief Collect the metadata of an entry that is hashed
+ * @param fields MetadataField bits to collect
+ * @param mode Mode of the entry; only permission bits are kept
+ * @param uid Owning user id
+ * @param gid Owning group id
+ * @param mtime Modification time in seconds since the epoch
+ * @return "mode" (4 octal digits), "owner" ("uid:gid") and "mtime" values by name
+ */
+map<string, string> entryMetadata(unsigned fields, unsigned mode, long long uid, long long gid, long long mtime);
+
+/**
+ * @brief Serialize metadata in the canonical form that is hashed
+ * @param metadata Values by name
+ * @return One "name=value" line per field in name order
+ */
+string serializeMetadata(const map<string, string> &metadata);
+
+/**
+ * @brief Parse a symlink policy name
+ * @param name "follow", "record" or "skip"
+ * @param policy Receives the parsed policy
+ * @return True if the name is known
+ */
+bool parseSymlinkPolicy(const string &name, SymlinkPolicy &policy);
+
+/**
+ * @brief Get the name of a symlink policy
+ * @param policy Policy to name
+ * @return Name accepted by parseSymlinkPolicy
+ */
+string symlinkPolicyName(SymlinkPolicy policy);
+
+/**
+ * @struct WalkOptions
+ * @brief Scoping options applied while walking a tree
+ *
+ * Options are recorded in the state directory of a tree after a build and
+ * reused by later builds that are not given options of their own, so a
+ * tree is always rebuilt and verified with the scope it was built with.
+ */
+struct WalkOptions
+{
+    vector<string> includes; // Only files matching one of these are hashed (all if empty)
+    vector<string> excludes; // Files and directories matching any of these are skipped
+    SymlinkPolicy symlinks = SymlinkPolicy::Follow; // Treatment of symbolic links
+    bool hidden = true;      // Dotfiles and dot-directories are part of the tree
+    bool appleMetadata = false; // AppleDouble files, .DS_Store, __MACOSX and com.apple.* attributes are hashed
+    size_t maxDepth = 0;     // Directories deeper than this are not walked (0 for no limit)
+    size_t minFileSize = 0;  // Smaller files are not hashed (0 for no limit)
+    size_t maxFileSize = 0;  // Larger files are not hashed (0 for no limit)
+    bool oneFileSystem = false; // Directories on other file systems than the root are not read
+    bool xattrs = false;     // Extended attributes and ACLs are hashed with each entry
+    unsigned metadata = 0;   // MetadataField bits hashed with each entry below the root
+    SpecialFilePolicy specialFiles = SpecialFilePolicy::Skip; // Treatment of devices, sockets and FIFOs
+    NameNormalization normalization = NameNormalization::None; // Form of the names that are hashed
+    bool caseInsensitive = false; // Names that only differ in case collide, as on Windows and macOS
+    HashFormat hashFormat = HashFormat::Mtfs; // How leaves and directories are hashed
+
+    /**
+     * @brief Check whether a path matches an include pattern
+     * @param relPath Path relative to the tree root
+     * @return True if there are no include patterns or one matches
+     */
+    bool isIncluded(const string &relPath) const;
+
+    /**
+     * @brief Check whether a path matches an exclude pattern
+     * @param relPath Path relative to the tree root
+     * @return True if any exclude pattern matches
+     */
+    bool isExcluded(const string &relPath) const;
+
+    /**
+     * @brief Check a file size against the size limits
+     * @param size Size of a file in bytes
+     * @return "min file size" or "max file size" if the file is not hashed, nullptr if it is
+     */
+    const char *sizeLimit(size_t size) const;
+
+    /**
+     * @brief Load the options recorded for a tree
+     * @param treeRoot Root directory of the tree
+     * @return True if recorded options were found
+     */
+    bool load(const fs::path &treeRoot);
+
+    /**
+     * @brief Record the options in the state directory of a tree
+     * @param treeRoot Root directory of the tree
+     * @return True if the options were written
+     */
+    bool save(const fs::path &treeRoot) const;
+
+    /**
+     * @brief Describe the options in one line
+     * @return Human-readable summary, "default" if nothing is restricted
+     */
+    string describe() const;
+};
+
+/**
+ * @struct TreeConfig
+ * @brief Settings a tree pins for itself in its CONFIG_FILE
+ *
+ * Unlike the recorded options, the config file is written by hand and
+ * takes precedence over the options given on the command line and the
+ * chunk size chosen in the menu, so every build of the tree uses the
+ * same parameters. Settings the file leaves out keep their global value.
+ */
+struct TreeConfig
+{
+    fs::path path;                             // Config file the settings were read from
+    bool includesSet = false;                  // The file replaces the include patterns
+    vector<string> includes;                   // Include patterns of the file
+    bool excludesSet = false;                  // The file replaces the exclude patterns
+    vector<string> excludes;                   // Exclude patterns of the file
+    optional<SymlinkPolicy> symlinks;          // Treatment of symbolic links
+    optional<bool> hidden;                     // Dotfiles are part of the tree
+    optional<bool> appleMetadata;              // macOS metadata files and attributes are hashed
+    optional<size_t> maxDepth;                 // Directory depth limit (0 for no limit)
+    optional<size_t> minFileSize;              // Smallest file hashed (0 for no limit)
+    optional<size_t> maxFileSize;              // File size limit (0 for no limit)
+    optional<bool> oneFileSystem;              // Stay on the file system of the root
+    optional<bool> xattrs;                     // Hash extended attributes and ACLs
+    optional<unsigned> metadata;               // MetadataField bits hashed with each entry
+    optional<SpecialFilePolicy> specialFiles;  // Treatment of devices, sockets and FIFOs
+    optional<NameNormalization> normalization; // Form of the names that are hashed
+    optional<bool> caseInsensitive;            // Names that only differ in case collide
+    optional<HashFormat> hashFormat;           // How leaves and directories are hashed
+    optional<size_t> chunkSize;                // Size of the chunks files are hashed in
+    optional<size_t> ioLimit;                  // Bytes read per second (0 for no limit)
+    optional<size_t> iopsLimit;                // Reads per second (0 for no limit)
+
+    /**
+     * @brief Read the config file of a tree
+     * @param treeRoot Root directory of the tree
+     * @return True if the tree has a config file
+     * @throws runtime_error If the file has an unknown key or an invalid value
+     */
+    bool load(const fs::path &treeRoot);
+
+    /**
+     * @brief Override global settings with those of the file
+     * @param options Walk options to override
+     * @param chunk Chunk size to override
+     * @param limits I/O limits to override
+     */
+    void apply(WalkOptions &options, size_t &chunk, IoLimits &limits) const;
+};
+
+/**
+ * @struct WalkContext
+ * @brief State handed down from a directory to its entries during a build
+ */
+struct WalkContext
+{
+    /**
+     * @struct Directory
+     * @brief Identity of a directory on the current path from the tree root
+     */
+    struct Directory
+    {
+        dev_t device;                       // Device holding the directory
+        ino_t inode;                        // Inode of the directory
+        string relPath;                     // Path relative to the tree root ("" for the root)
+        shared_ptr<const Directory> parent; // Next directory towards the root
+    };
+
+    shared_ptr<const IgnoreRules> ignore;  // Ignore rules in effect for the parent directory
+    bool included = false;                 // An ancestor directory matched an include pattern
+    size_t depth = 0;                      // Depth of the entry below the tree root
+    shared_ptr<const Directory> ancestors; // Directories from the parent up to the tree root
+};
+
+/**
+ * @struct ArchiveEntry
+ * @brief One member of a tar or zip archive
+ */
+struct ArchiveEntry
+{
+    string path;                // Slash-separated path as stored in the archive
+    char type = 'f';            // 'f' file, 'd' directory, 'l' symlink, 'h' hard link, 'c'/'b' device, 'p' FIFO
+    size_t size = 0;            // Content size of a file
+    unsigned mode = 0;          // Permission bits (and file type bits for zip members)
+    long long uid = 0;          // Owning user id (tar members only)
+    long long gid = 0;          // Owning group id (tar members only)
+    long long mtime = 0;        // Modification time in seconds since the epoch
+    string linkTarget;          // Target of a symlink, or archive path of a hard link's file
+    string deviceNumber;        // "major:minor" of a device
+    map<string, string> xattrs; // Extended attributes stored with the member (pax archives)
+};
+
+/**
+ * @class ArchiveReader
+ * @brief Streams the members of a tar or zip archive without extracting it
+ *
+ * Tar archives may be plain or gzip-compressed and use the ustar, GNU
+ * long name and pax extensions. Zip archives are read through their
+ * central directory; stored and deflated members are supported and
+ * checked against their CRC-32.
+ */
+class ArchiveReader
+{
+public:
+    /**
+     * @brief Check whether a file is an archive this reader understands
+     * @param path Filesystem path
+     * @return True for regular files starting like a zip, gzip or tar archive
+     */
+    static bool isArchive(const fs::path &path);
+
+    /**
+     * @brief Open an archive
+     * @param path Filesystem path of the archive
+     * @throws runtime_error If the archive cannot be opened or its directory is damaged
+     */
+    explicit ArchiveReader(const fs::path &path);
+
+    /**
+     * @brief Close the archive
+     */
+    ~ArchiveReader();
+
+    ArchiveReader(const ArchiveReader &) = delete;
+    ArchiveReader &operator=(const ArchiveReader &) = delete;
+
+    /**
+     * @brief Advance to the next member
+     * @param entry Receives the member's metadata
+     * @return False at the end of the archive
+     * @throws runtime_error If the archive is damaged
+     */
+    bool next(ArchiveEntry &entry);
+
+    /**
+     * @brief Read the content of the current member
+     * @return Content of a file, or the target of a zip symlink
+     * @throws runtime_error If the content is damaged or cannot be decompressed
+     */
+    string readData();
+
+private:
+    /**
+     * @struct ZipMember
+     * @brief Location and encoding of a zip member from the central directory
+     */
+    struct ZipMember
+    {
+        ArchiveEntry entry;        // Metadata reported by next()
+        uint16_t method = 0;       // 0 stored, 8 deflated
+        bool encrypted = false;    // Member is encrypted
+        uint32_t crc = 0;          // CRC-32 of the content
+        size_t compressedSize = 0; // Size of the stored data
+        size_t localOffset = 0;    // Offset of the local file header
+    };
+
+    fs::path path;                 // Location of the archive
+    bool zip = false;              // Zip archive, tar otherwise
+    struct gzFile_s *gz = nullptr; // Tar stream (gzip-compressed or plain)
+    size_t remaining = 0;          // Unread content bytes of the current tar member
+    size_t padding = 0;            // Bytes after the content up to the next tar header
+    ifstream zipFile;              // Zip archive
+    vector<ZipMember> members;     // Zip members in central directory order
+    size_t nextMember = 0;         // Index of the zip member next() returns next
+
+    /**
+     * @brief Advance to the next tar member, reading extension headers on the way
+     * @param entry Receives the member's metadata
+     * @return False at the end of the archive
+     */
+    bool nextTar(ArchiveEntry &entry);
+
+    /**
+     * @brief Read bytes from the tar stream
+     * @param size Number of bytes
+     * @return The bytes read
+     * @throws runtime_error If the stream ends early or is damaged
+     */
+    string readTar(size_t size);
+
+    /**
+     * @brief Load the member list from the zip central directory
+     */
+    void readZipDirectory();
+
+    /**
+     * @brief Read and decompress the content of a zip member
+     * @param member Member to read
+     * @return Content of the member
+     */
+    string readZipMember(const ZipMember &member);
+};
+
 /**
  * @class MerkleTree
  * @brief Main class for building and managing Merkle tree file systems
@@ -142,24 +1012,18 @@ public:
      */
     ~MerkleTree() = default;
 
-    /**
-     * @brief Calculate SHA-256 hash of input data
-     * @param data Input data to hash
-     * @return Hexadecimal string representation of the hash
-     */
-    string sha256(const string &data);
-
     /**
      * @brief Hash file content and split into chunks
      * @param file_path Path to the file to process
+     * @param name Path of the file relative to the tree root, shown in progress lines
      * @return Tuple containing (content_hash, file_size, chunk_hashes)
      * @throws runtime_error If file cannot be opened or read
      */
-    tuple<string, size_t, vector<string>> hash_file_content(const string &file_path);
+    tuple<string, size_t, vector<string>> hash_file_content(const string &file_path, const string &name);
 
     /**
      * @brief Build Merkle tree from directory path
-     * @param directory_path Path to the directory to process
+     * @param directory_path Path to the directory, or to a tar or zip archive, to process
      * @return Shared pointer to the root node of the built tree
      * @throws runtime_error If directory path is invalid
      */
@@ -168,10 +1032,13 @@ public:
     /**
      * @brief Build a single node from filesystem path
      * @param path Filesystem path to process
+     * @param ctx Walk state of the parent directory
+     *
+     * Safe to call from several walker threads at once.
      * @return Shared pointer to the created node
      * @throws runtime_error If path is invalid or inaccessible
      */
-    shared_ptr<MerkleNode> build_node(const fs::path &path);
+    shared_ptr<MerkleNode> build_node(const fs::path &path, const WalkContext &ctx = {});
 
     /**
      * @brief Print detailed tree structure
@@ -222,6 +1089,14 @@ public:
      */
     string exportToJson() const;
 
+    /**
+     * @brief List one directory of the tree as JSON, without its subdirectories' entries
+     * @param path Slash-separated path of the directory, "." for the root
+     * @return JSON object with the directory and its entries
+     * @throws runtime_error if path is not a directory of the tree
+     */
+    string listDirectoryJson(const string &path) const;
+
     /**
      * @brief Set custom chunk size for file processing
      * @param chunkSize New chunk size in bytes
@@ -234,11 +1109,171 @@ public:
      */
     size_t getChunkSize() const;
 
+    /**
+     * @brief Set the number of threads walking directories in a build
+     * @param workers Upper bound for concurrent walker threads (at least 1)
+     */
+    void setWorkers(size_t workers);
+
+    /**
+     * @brief Limit the rate files are read at in the following builds
+     * @param limits Limits for trees whose config sets none
+     */
+    void setIoLimits(const IoLimits &limits);
+
+    /**
+     * @brief Get the I/O limits of the last build
+     * @return Limits in force, after the tree's config applied
+     */
+    IoLimits getIoLimits() const;
+
+    /**
+     * @brief Get throughput figures of the last build
+     * @return Summary of the last build (zeroed if none)
+     */
+    ThroughputSummary getBuildThroughput() const;
+
+    /**
+     * @brief Get throughput figures of the last verification
+     * @return Summary of the last verification (zeroed if none)
+     */
+    ThroughputSummary getVerifyThroughput() const;
+
+    /**
+     * @brief Get the number of files reused from a checkpoint in the last build
+     * @return Number of files that did not need hashing
+     */
+    size_t getResumedFiles() const;
+
+    /**
+     * @brief Get the number of unchanged files taken from the hash cache in the last build
+     * @return Number of files that did not need hashing
+     */
+    size_t getCachedFiles() const;
+
+    /**
+     * @brief Get the entries skipped in the last build
+     * @return Number of skipped files and directories by reason
+     */
+    map<string, size_t> getSkippedEntries() const;
+
+    /**
+     * @brief Get hard link figures of the current tree
+     * @return Pair of (paths that are hard links to an earlier path, bytes not counted again)
+     */
+    pair<size_t, size_t> getHardlinkStats() const;
+
+    /**
+     * @brief Get the number of special files recorded in the current tree
+     * @return Number of device, socket and FIFO leaves
+     */
+    size_t getSpecialFileCount() const;
+
+    /**
+     * @brief Get the symlink cycles found in the last build
+     * @return One "link -> ancestor" description per skipped link
+     */
+    vector<string> getSymlinkCycles() const;
+
+    /**
+     * @brief Get the entries the last build could not read
+     * @return Path and reason of every error leaf, by path
+     */
+    vector<pair<string, string>> getUnreadableEntries() const;
+
+    /**
+     * @brief Get the entries skipped in the last build
+     * @return Reason and path of every skipped entry, by path
+     */
+    vector<pair<string, string>> getSkippedPaths() const;
+
+    /**
+     * @brief Make the following builds dry runs
+     * @param dryRun True to walk and filter without hashing or recording anything
+     *
+     * A dry run applies every walk option and policy and sizes the files
+     * in scope, but reads no content and writes no checkpoint, walk
+     * options, skipped list or metrics.
+     */
+    void setDryRun(bool dryRun);
+
+    /**
+     * @brief Turn the pre-scan of the following builds on or off
+     * @param prescan True to total the files to hash before hashing them (the default)
+     *
+     * The pre-scan is a dry run of the build, walking the directory with
+     * the same filters, so progress lines can show the share done and an
+     * ETA. Archives are not pre-scanned, as that would read them twice.
+     */
+    void setPrescan(bool prescan);
+
+    /**
+     * @brief Turn the hash cache of the following builds on or off
+     * @param hashCache True to take unchanged files from the last build (the default)
+     *
+     * Without it every file is hashed again; the cache is still written
+     * for the builds after.
+     */
+    void setHashCache(bool hashCache);
+
+    /**
+     * @brief Set the walk options for the following builds
+     * @param options Options to apply instead of those recorded with a tree
+     */
+    void setWalkOptions(const WalkOptions &options);
+
+    /**
+     * @brief Get the walk options of the last build
+     * @return Options the current tree was built with
+     */
+    const WalkOptions &getWalkOptions() const;
+
+    /**
+     * @brief Get the config file the last build applied
+     * @return Path of the tree's config file, empty if it has none
+     */
+    const fs::path &getConfigFile() const;
+
+    /**
+     * @brief Get the metrics history of the current tree
+     * @return Past builds and verifications, oldest first
+     */
+    vector<OperationMetrics> getMetricsHistory() const;
+
 private:
     shared_ptr<MerkleNode> root;                      // Root node of the Merkle tree
     map<string, shared_ptr<MerkleNode>> file_objects; // Map of content hash to file nodes
     vector<shared_ptr<MerkleNode>> nodes;             // Vector of all nodes in the tree
     size_t CHUNK_SIZE;                                // Size of chunks for file processing (default: 1MB)
+    size_t requestedChunkSize;                        // Chunk size chosen for trees without their own
+    IoLimits ioLimits;                                // I/O limits of the current build
+    IoLimits requestedLimits;                         // I/O limits chosen for trees without their own
+    Throttle throttle;                                // Paces the reads of the current build
+    ThroughputMeter buildMeter;                       // Throughput tracking for builds
+    ThroughputMeter verifyMeter;                      // Throughput tracking for verifications
+    ThroughputSummary buildThroughput;                // Figures of the last completed build
+    ThroughputSummary verifyThroughput;               // Figures of the last completed verification
+    BuildCheckpoint checkpoint;                       // Progress checkpoint of the current build
+    fs::path treeRoot;                                // Root directory of the current build
+    dev_t rootDevice;                                 // Device holding the root of the current build
+    atomic<size_t> resumedFiles;                      // Files reused from a checkpoint in the last build
+    atomic<size_t> cachedFiles;                       // Files taken from the hash cache in the last build
+    map<string, size_t> skippedEntries;               // Entries skipped in the last build, by reason
+    vector<pair<string, string>> skippedPaths;        // Reason and path of every skipped entry
+    vector<string> symlinkCycles;                     // Links skipped because they lead back to an ancestor
+    vector<string> rejectedSpecialFiles;              // Special files found while the policy is "error"
+    vector<pair<string, string>> unreadablePaths;     // Path and reason of every error leaf of the last build
+    map<pair<dev_t, ino_t>, shared_future<tuple<string, size_t, vector<string>>>> linkedContent; // Hashes of hard-linked inodes
+    WalkOptions walkOptions;                          // Scope of the current build
+    bool walkOptionsSet;                              // Options were given explicitly, not loaded from the tree
+    WalkOptions requestedOptions;                     // Options given explicitly, before a tree's config applies
+    fs::path configFile;                              // Config file applied by the current build (empty if none)
+    atomic<size_t> activeWorkers;                     // Walker threads currently running
+    size_t maxWorkers;                                // Upper bound for threads walking at once, the calling one included
+    bool dryRun = false;                              // Builds only walk and filter, hashing nothing
+    bool prescan = true;                              // Builds total their files before hashing them
+    bool hashCache = true;                            // Builds take unchanged files from the hash cache
+    mutex buildMutex;                                 // Guards the meter and checkpoint during parallel builds
 
     /**
      * @brief Recursive helper for finding nodes
@@ -248,6 +1283,112 @@ private:
      */
     shared_ptr<MerkleNode> findNodeRecursive(shared_ptr<MerkleNode> node, const string &name);
 
+    /**
+     * @brief Add an operation on the current tree to its metrics history
+     * @param operation "build" or "verify"
+     * @param ok True unless a verification failed
+     * @param summary Throughput figures of the operation
+     */
+    void recordMetrics(const string &operation, bool ok, const ThroughputSummary &summary);
+
+    /**
+     * @brief Build the children of a directory node
+     * @param node Directory node to populate
+     * @param path Filesystem path of the directory
+     * @param ctx Walk state of the parent directory
+     *
+     * Sibling subtrees are handed to worker threads while slots are free
+     * and built inline otherwise. Children are always attached in sorted
+     * name order, so the result does not depend on thread scheduling.
+     */
+    void build_children(const shared_ptr<MerkleNode> &node, const fs::path &path, const WalkContext &ctx);
+
+    /**
+     * @brief Build a tree mirroring the layout of an archive
+     * @param archive Filesystem path of a tar or zip archive
+     * @return Root node named after the archive without its extensions
+     *
+     * Members are hashed as they are streamed, with the walk options
+     * applied to their paths inside the archive. Directories missing from
+     * the archive are implied by the paths of their members. Followed
+     * symlinks are resolved once every member is read, as they would be
+     * in the extracted directory.
+     */
+    shared_ptr<MerkleNode> build_archive(const fs::path &archive);
+
+    /**
+     * @brief Hash a stream of content and split it into chunks
+     * @param in Stream positioned at the start of the content
+     * @param name Path of the content relative to the tree root, shown in progress lines
+     * @param size Expected size of the content in bytes
+     * @return Tuple containing (content_hash, file_size, chunk_hashes)
+     */
+    tuple<string, size_t, vector<string>> hash_stream(istream &in, const string &name, size_t size);
+
+    /**
+     * @brief Check whether a directory entry leads back to a directory being walked
+     * @param path Filesystem path of the entry
+     * @param relPath Path of the entry relative to the tree root
+     * @param ancestors Directories from the parent of the entry up to the tree root
+     * @return True if following the entry would recurse forever; the cycle is recorded
+     */
+    bool leadsToAncestor(const fs::path &path, const string &relPath,
+                         const shared_ptr<const WalkContext::Directory> &ancestors);
+
+    /**
+     * @brief Hash a file with several hard links only once per build
+     * @param path Filesystem path of the file
+     * @param st Status of the file
+     * @param relPath Path of the file relative to the tree root
+     * @return Tuple containing (content_hash, file_size, chunk_hashes)
+     *
+     * Walker threads reaching the same inode through another path wait for
+     * the thread hashing it instead of reading the content again.
+     */
+    tuple<string, size_t, vector<string>> hash_linked_file(const fs::path &path, const struct stat &st,
+                                                           const string &relPath);
+
+    /**
+     * @brief Mark files sharing an inode with an earlier path of the tree
+     * @param node Node to start from
+     * @param relPath Path of the node relative to the tree root
+     * @param seen First path found for each hard-linked inode
+     */
+    void markHardlinks(const shared_ptr<MerkleNode> &node, const string &relPath,
+                       map<pair<dev_t, ino_t>, string> &seen);
+
+    /**
+     * @brief Record an entry left out of the tree
+     * @param reason Why the entry was skipped
+     * @param relPath Path of the entry relative to the tree root
+     */
+    void recordSkip(const string &reason, const string &relPath);
+
+    /**
+     * @brief Write the entries skipped by the last build to the state directory
+     * @return True if the list was written
+     */
+    bool saveSkipped() const;
+
+    /**
+     * @brief Claim a walker thread slot if one is free
+     * @return True if the caller may start a new walker thread
+     */
+    bool acquireWorker();
+
+    /**
+     * @brief Record hashing progress from any walker thread
+     * @param bytes Number of bytes hashed
+     * @param files Number of files completed
+     */
+    void recordProgress(size_t bytes, size_t files);
+
+    /**
+     * @brief Rebuild the node list and file object index in canonical order
+     * @param node Current node
+     */
+    void indexNodes(const shared_ptr<MerkleNode> &node);
+
     /**
      * @brief Helper function to export node to JSON
      * @param node Node to export
@@ -280,6 +1421,14 @@ private:
  */
 string formatFileSize(size_t bytes);
 
+/**
+ * @brief Utility function to parse a human-readable size
+ * @param text Size such as "512", "64K" or "2G" (binary multiples)
+ * @param bytes Receives the size in bytes
+ * @return True if the text is a valid size
+ */
+bool parseFileSize(const string &text, size_t &bytes);
+
 /**
  * @brief Utility function to get file extension
  * @param filename Name of the file
@@ -294,6 +1443,128 @@ string getFileExtension(const string &filename);
  */
 bool isBinaryFile(const string &filepath);
 
+/**
+ * @brief Utility function to format a throughput summary on one line
+ * @param summary Throughput figures to format
+ * @return Formatted string (e.g., "avg 12.1 MB/s (peak 20 MB/s), 45.0 files/s (peak 60.0 files/s)")
+ */
+string formatThroughput(const ThroughputSummary &summary);
+
+/**
+ * @brief Format a duration for progress lines
+ * @param seconds Duration in seconds
+ * @return Text such as "45s", "3m 20s" or "1h 05m"
+ */
+string formatDuration(double seconds);
+
+/**
+ * @brief Utility function to escape a string for use inside JSON quotes
+ * @param value Raw string
+ * @return Escaped string
+ */
+string jsonEscape(const string &value);
+
+/**
+ * @brief Utility function to escape tabs, newlines and backslashes
+ * @param field Raw field value
+ * @return Escaped value safe for tab-separated records
+ */
+string escapeField(const string &field);
+
+/**
+ * @brief Utility function to reverse escapeField
+ * @param field Escaped field value
+ * @return Original field value
+ */
+string unescapeField(const string &field);
+
+/**
+ * @brief Utility function to locate the state directory of a tree
+ * @param treeRoot Root directory of the tree, or the archive it was built from
+ * @return treeRoot/.mtfs, <archive>.mtfs beside an archive, or the tree's directory in the forensic case
+ */
+fs::path stateDirectory(const fs::path &treeRoot);
+
+/**
+ * @brief Utility function to get the case directory of forensic mode
+ * @return Absolute directory named by MTFS_FORENSIC, empty if the mode is off
+ */
+fs::path forensicDirectory();
+
+/**
+ * @brief Utility function to open a file for reading, without updating its access time in forensic mode
+ * @param path File to open
+ * @return File descriptor, or -1 with errno set if it cannot be opened
+ */
+int openForReading(const string &path);
+
+/**
+ * @brief Read the extended attributes of a file or directory
+ * @param path Filesystem path (symbolic links are followed)
+ * @return Attribute values by name, empty if the file system has none
+ * @throws runtime_error If the attributes cannot be read
+ */
+map<string, string> readXattrs(const fs::path &path);
+
+/**
+ * @brief Remove the attributes macOS manages on its own from a set
+ * @param attrs Attribute values by name
+ *
+ * com.apple.* attributes (quarantine flags, Finder info, resource forks,
+ * Spotlight data) change as files are downloaded, opened or indexed; they
+ * appear as user.com.apple.* on Linux file systems.
+ */
+void removeAppleXattrs(map<string, string> &attrs);
+
+/**
+ * @brief Serialize extended attributes in the canonical form that is hashed
+ * @param attrs Attribute values by name
+ * @return One "name=hexvalue" line per attribute in name order
+ */
+string serializeXattrs(const map<string, string> &attrs);
+
+/**
+ * @brief Format an extended attribute value for display
+ * @param name Attribute name
+ * @param value Raw attribute value
+ * @return ACLs in short text form, printable values quoted, others as hex
+ */
+string formatXattrValue(const string &name, const string &value);
+
+/**
+ * @brief Hex-encode a byte string
+ * @param data Bytes to encode
+ * @return Lowercase hexadecimal string
+ */
+string hexEncode(const string &data);
+
+/**
+ * @brief Calculate the id of a Git object
+ * @param format GitSha1 or GitSha256, the object format of the repository
+ * @param type Object type, "blob" or "tree"
+ * @param content Object content without the header
+ * @return Hexadecimal object id, as printed by git hash-object
+ * @throws runtime_error If the digest cannot be calculated
+ */
+string gitObjectId(HashFormat format, const string &type, const string &content);
+
+/**
+ * @brief Encode one entry of a Git tree object
+ * @param mode Git file mode, e.g. "100644" or "40000"
+ * @param name Entry name
+ * @param objectId Hexadecimal id of the entry's object
+ * @return "mode name", a NUL byte and the binary object id
+ */
+string gitTreeEntry(const string &mode, const string &name, const string &objectId);
+
+/**
+ * @brief Match a path against a gitignore-style glob
+ * @param pattern Glob using *, ?, [...], ** and backslash escapes
+ * @param path Slash-separated path
+ * @return True if the whole path matches
+ */
+bool globMatch(const string &pattern, const string &path);
+
 // Constants
 namespace MTFSConstants
 {
@@ -302,6 +1573,22 @@ namespace MTFSConstants
     const size_t MIN_CHUNK_SIZE = 1024;              // Minimum chunk size (1KB)
     const int MAX_TREE_DEPTH = 10;                   // Maximum allowed tree depth
     const string MTFS_VERSION = "1.0";               // MTFS version
+    const double REPORT_INTERVAL = 0.5;              // Seconds between progress lines
+    const string STATE_DIR = ".mtfs";                // Per-tree state directory (never hashed)
+    const string CHECKPOINT_FILE = "checkpoint";     // Build checkpoint inside STATE_DIR
+    const string HASH_CACHE_FILE = "hashcache";      // Checkpoint of the last successful build inside STATE_DIR
+    const long long HASH_CACHE_RACY = 2;             // Seconds before the cache was written its files are not trusted
+    const string ATTESTATION_FILE = "INTEGRITY.mtfs.json"; // Signed root hash in the tree root (never hashed)
+    const double CHECKPOINT_INTERVAL = 5.0;          // Seconds between checkpoint flushes
+    const string IGNORE_FILE = ".mtfsignore";        // gitignore-style exclusions, per directory
+    const string OPTIONS_FILE = "options";           // Walk options of the tree inside STATE_DIR
+    const string SKIPPED_FILE = "skipped";           // Entries left out by the last build inside STATE_DIR
+    const string CONFIG_FILE = "config";             // Hand-written per-tree settings inside STATE_DIR
+    const string METRICS_FILE = "metrics";           // Figures of past builds and verifications inside STATE_DIR
+    const size_t METRICS_HISTORY = 100;              // Operations kept in METRICS_FILE
+    const size_t TREND_RUNS = 30;                    // Runs summarized by the statistics
+    const string FORENSIC_ENV = "MTFS_FORENSIC";     // Case directory of forensic mode, which never writes in a tree
+    const string FORENSIC_TREES_DIR = "trees";       // State directories of the examined trees inside the case directory
 }
 
 #endif
\ No newline at end of file
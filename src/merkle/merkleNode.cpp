@@ -1,5 +1,4 @@
 #include "merkle.hpp"
-#include <openssl/sha.h>
 #include <sstream>
 #include <iomanip>
 #include <algorithm>
@@ -11,7 +10,7 @@
  * @param isFile True if this represents a file, false for directory
  */
 MerkleNode::MerkleNode(const string &name, bool isFile)
-    : name(name), isFile(isFile), fileSize(0), cachedDepth(-1)
+    : name(name), isFile(isFile), fileSize(0), isSymlink(false), executable(false), device(0), inode(0), cachedDepth(-1)
 {
     // Initialize empty hash - will be calculated later
     hash = "";
@@ -47,26 +46,49 @@ void MerkleNode::addChild(const shared_ptr<MerkleNode> &child)
  * @return String containing the calculated hash
  *
  * For files: Returns the content hash
+ * For symlinks: Hash of the link target, distinct from a file holding it
+ * For special files: Hash of the file type and device number
+ * For error leaves: Hash of the reason the entry could not be read
  * For directories: Calculates hash based on sorted children hashes
+ * Captured metadata and extended attributes are hashed into leaves that
+ * are combined with the hash above, in that order.
+ * In the Git formats the hash is the node's Git object id instead.
  */
-string MerkleNode::calculateHash()
+string MerkleNode::calculateHash(HashFormat format)
 {
-    if (isFile)
+    if (isGitFormat(format))
+    {
+        hash = calculateGitId(format);
+        return hash;
+    }
+
+    metadataHash = metadata.empty() ? "" : formatDigest(format, serializeMetadata(metadata));
+    xattrsHash = xattrs.empty() ? "" : formatDigest(format, serializeXattrs(xattrs));
+
+    if (isSymlink)
+    {
+        hash = formatDigest(format, "symlink:" + linkTarget);
+    }
+    else if (!specialType.empty())
+    {
+        hash = formatDigest(format, "special:" + specialType + ":" + deviceNumber);
+    }
+    else if (!error.empty())
+    {
+        hash = formatDigest(format, "error:" + error);
+    }
+    else if (isFile)
     {
         // For files, the hash is the content hash
         hash = contentHash;
-        return hash;
+    }
+    else if (children.empty())
+    {
+        // Empty directory gets hash of its name
+        hash = formatDigest(format, name);
     }
     else
     {
-        // For directories, calculate hash based on sorted children
-        if (children.empty())
-        {
-            // Empty directory gets hash of its name
-            hash = sha256(name);
-            return hash;
-        }
-
         // Sort children by name for consistent hashing
         vector<string> sortedNames;
         for (const auto &child : children)
@@ -80,14 +102,23 @@ string MerkleNode::calculateHash()
         for (const string &childName : sortedNames)
         {
             auto child = children[childName];
-            string childHash = child->calculateHash();
+            string childHash = child->calculateHash(format);
             combined += childName + ":" + childHash + ";";
         }
 
         // Hash the combined string
-        hash = sha256(combined);
-        return hash;
+        hash = formatDigest(format, combined);
     }
+
+    if (!metadataHash.empty())
+    {
+        hash = formatDigest(format, hash + ";metadata:" + metadataHash);
+    }
+    if (!xattrsHash.empty())
+    {
+        hash = formatDigest(format, hash + ";xattrs:" + xattrsHash);
+    }
+    return hash;
 }
 
 /**
@@ -168,24 +199,49 @@ size_t MerkleNode::getFileCount() const
 }
 
 /**
- * @brief Helper function to calculate SHA-256 hash of data
- * @param data Input data to hash
- * @return Hexadecimal string representation of the hash
+ * @brief Calculate the Git object id of this node
+ * @param format GitSha1 or GitSha256
+ * @return Blob id of a file or link target, tree id of a directory
  */
-string MerkleNode::sha256(const string &data)
+string MerkleNode::calculateGitId(HashFormat format)
 {
-    unsigned char hash[SHA256_DIGEST_LENGTH];
-    SHA256_CTX sha256_ctx;
-    SHA256_Init(&sha256_ctx);
-    SHA256_Update(&sha256_ctx, data.c_str(), data.length());
-    SHA256_Final(hash, &sha256_ctx);
+    if (isSymlink)
+    {
+        return gitObjectId(format, "blob", linkTarget);
+    }
+    if (!specialType.empty())
+    {
+        throw runtime_error("Special files cannot be stored in a Git tree: " + name);
+    }
+    if (isFile)
+    {
+        // Files are hashed as blobs in the Git formats
+        return contentHash;
+    }
 
-    // Convert to hex string
-    stringstream ss;
-    for (int i = 0; i < SHA256_DIGEST_LENGTH; ++i)
+    // Git orders entries as if directory names ended in '/'
+    string emptyTree = gitObjectId(format, "tree", "");
+    vector<pair<string, string>> entries;
+    for (const auto &[childName, child] : children)
     {
-        ss << hex << setw(2) << setfill('0') << (int)hash[i];
+        if (!child->error.empty())
+        {
+            continue; // Git has no entry for what could not be read
+        }
+        string childId = child->calculateHash(format);
+        if (!child->isFile && childId == emptyTree)
+        {
+            continue; // Git does not record empty directories
+        }
+        string mode = child->isSymlink ? "120000" : !child->isFile ? "40000" : child->executable ? "100755" : "100644";
+        entries.emplace_back(child->isFile ? childName : childName + "/", gitTreeEntry(mode, childName, childId));
     }
+    sort(entries.begin(), entries.end());
 
-    return ss.str();
+    string content;
+    for (const auto &entry : entries)
+    {
+        content += entry.second;
+    }
+    return gitObjectId(format, "tree", content);
 }
\ No newline at end of file
@@ -1,15 +1,22 @@
 #include "merkle.hpp"
-#include <openssl/sha.h>
 #include <sstream>
 #include <iomanip>
 #include <algorithm>
 #include <stdexcept>
+#include <cstring>
 #include <fstream>
+#include <sys/sysmacros.h>
+#include <unistd.h>
+#include <functional>
+#include <deque>
+#include <ext/stdio_filebuf.h>
 
 /**
  * @brief Default constructor for MerkleTree
  */
-MerkleTree::MerkleTree() : CHUNK_SIZE(MTFSConstants::DEFAULT_CHUNK_SIZE)
+MerkleTree::MerkleTree()
+    : CHUNK_SIZE(MTFSConstants::DEFAULT_CHUNK_SIZE), requestedChunkSize(MTFSConstants::DEFAULT_CHUNK_SIZE), buildMeter("Build"), verifyMeter("Verify"), rootDevice(0), resumedFiles(0), cachedFiles(0), walkOptionsSet(false),
+      activeWorkers(0), maxWorkers(max(1u, thread::hardware_concurrency()))
 {
     root = nullptr;
     file_objects.clear();
@@ -20,7 +27,9 @@ MerkleTree::MerkleTree() : CHUNK_SIZE(MTFSConstants::DEFAULT_CHUNK_SIZE)
  * @brief Constructor with custom chunk size
  * @param chunkSize Size of chunks for file processing (default: 1MB)
  */
-MerkleTree::MerkleTree(size_t chunkSize) : CHUNK_SIZE(chunkSize)
+MerkleTree::MerkleTree(size_t chunkSize)
+    : CHUNK_SIZE(chunkSize), requestedChunkSize(chunkSize), buildMeter("Build"), verifyMeter("Verify"), rootDevice(0), resumedFiles(0), cachedFiles(0), walkOptionsSet(false),
+      activeWorkers(0), maxWorkers(max(1u, thread::hardware_concurrency()))
 {
     if (chunkSize < MTFSConstants::MIN_CHUNK_SIZE || chunkSize > MTFSConstants::MAX_CHUNK_SIZE)
     {
@@ -35,82 +44,85 @@ MerkleTree::MerkleTree(size_t chunkSize) : CHUNK_SIZE(chunkSize)
 }
 
 /**
- * @brief Calculate SHA-256 hash of input data
- * @param data Input data to hash
- * @return Hexadecimal string representation of the hash
+ * @brief Hash file content and split into chunks
+ * @param file_path Path to the file to process
+ * @param name Path of the file relative to the tree root, shown in progress lines
+ * @return Tuple containing (content_hash, file_size, chunk_hashes)
+ * @throws runtime_error If file cannot be opened or read
  */
-string MerkleTree::sha256(const string &data)
+tuple<string, size_t, vector<string>> MerkleTree::hash_file_content(const string &file_path, const string &name)
 {
-    unsigned char hash[SHA256_DIGEST_LENGTH];
-    SHA256_CTX sha256_ctx;
-    SHA256_Init(&sha256_ctx);
-    SHA256_Update(&sha256_ctx, data.c_str(), data.length());
-    SHA256_Final(hash, &sha256_ctx);
-
-    // Convert to hex string
-    stringstream ss;
-    for (int i = 0; i < SHA256_DIGEST_LENGTH; ++i)
+    int fd = openForReading(file_path);
+    if (fd < 0)
     {
-        ss << hex << setw(2) << setfill('0') << (int)hash[i];
+        throw runtime_error(strerror(errno));
     }
-
-    return ss.str();
+    struct stat st;
+    size_t size = fstat(fd, &st) == 0 ? st.st_size : 0;
+    // The buffer owns the descriptor and closes it
+    __gnu_cxx::stdio_filebuf<char> buffer(fd, ios::in | ios::binary);
+    istream file(&buffer);
+
+    auto hashed = hash_stream(file, name, size);
+    // A failed read ends the stream like the end of the file would
+    if (file.bad())
+    {
+        throw runtime_error("read failed");
+    }
+    return hashed;
 }
 
 /**
- * @brief Hash file content and split into chunks
- * @param file_path Path to the file to process
+ * @brief Hash a stream of content and split it into chunks
+ * @param in Stream positioned at the start of the content
+ * @param name Path of the content relative to the tree root, shown in progress lines
+ * @param size Expected size of the content in bytes
  * @return Tuple containing (content_hash, file_size, chunk_hashes)
- * @throws runtime_error If file cannot be opened or read
  */
-tuple<string, size_t, vector<string>> MerkleTree::hash_file_content(const string &file_path)
+tuple<string, size_t, vector<string>> MerkleTree::hash_stream(istream &in, const string &name, size_t size)
 {
-    ifstream file(file_path, ios::binary);
-    if (!file.is_open())
+    // The lane is freed however hashing ends
+    struct Lane
+    {
+        MerkleTree *tree;
+        size_t id;
+        ~Lane()
+        {
+            lock_guard<mutex> lock(tree->buildMutex);
+            tree->buildMeter.end(id);
+        }
+    } lane{this, 0};
     {
-        throw runtime_error("Cannot open file: " + file_path);
+        lock_guard<mutex> lock(buildMutex);
+        lane.id = buildMeter.begin(name, size);
     }
 
-    // Get file size
-    file.seekg(0, ios::end);
-    size_t fileSize = file.tellg();
-    file.seekg(0, ios::beg);
-
     vector<string> chunkHashes;
     string entireContent;
+    vector<char> buffer(CHUNK_SIZE);
 
-    // Read file in chunks
-    char *buffer = new char[CHUNK_SIZE];
-    size_t bytesRead;
-
-    try
+    // Read in chunks
+    while (in.read(buffer.data(), CHUNK_SIZE) || in.gcount() > 0)
     {
-        while (file.read(buffer, CHUNK_SIZE) || file.gcount() > 0)
-        {
-            bytesRead = file.gcount();
-            string chunk(buffer, bytesRead);
+        size_t bytesRead = in.gcount();
+        throttle.acquire(bytesRead);
+        string chunk(buffer.data(), bytesRead);
 
-            // Add to entire content for overall hash
-            entireContent += chunk;
+        // Add to entire content for overall hash
+        entireContent += chunk;
 
-            // Calculate chunk hash
-            string chunkHash = sha256(chunk);
-            chunkHashes.push_back(chunkHash);
-        }
-    }
-    catch (const exception &e)
-    {
-        delete[] buffer;
-        throw runtime_error("Error reading file: " + file_path + " - " + e.what());
-    }
+        // Calculate chunk hash
+        chunkHashes.push_back(formatDigest(walkOptions.hashFormat, chunk));
 
-    delete[] buffer;
-    file.close();
+        lock_guard<mutex> lock(buildMutex);
+        buildMeter.advance(lane.id, bytesRead);
+    }
 
-    // Calculate hash of entire content
-    string contentHash = sha256(entireContent);
+    // Calculate hash of entire content, the blob id in the Git formats
+    string contentHash = isGitFormat(walkOptions.hashFormat) ? gitObjectId(walkOptions.hashFormat, "blob", entireContent)
+                                                             : formatDigest(walkOptions.hashFormat, entireContent);
 
-    return make_tuple(contentHash, fileSize, chunkHashes);
+    return make_tuple(contentHash, entireContent.size(), chunkHashes);
 }
 
 /**
@@ -126,91 +138,1306 @@ shared_ptr<MerkleNode> MerkleTree::build_tree(const string &directory_path)
         throw runtime_error("Directory does not exist: " + directory_path);
     }
 
-    if (!fs::is_directory(directory_path))
+    bool archive = ArchiveReader::isArchive(directory_path);
+    if (!archive && !fs::is_directory(directory_path))
     {
-        throw runtime_error("Path is not a directory: " + directory_path);
+        throw runtime_error("Path is not a directory or archive: " + directory_path);
+    }
+
+    // Total the files to hash first, so progress lines can show an ETA
+    optional<tuple<size_t, size_t, size_t>> expected;
+    if (!dryRun && prescan && !archive)
+    {
+        MerkleTree scan;
+        if (walkOptionsSet)
+        {
+            scan.setWalkOptions(requestedOptions);
+        }
+        scan.setWorkers(maxWorkers);
+        scan.setDryRun(true);
+        try
+        {
+            scan.build_tree(directory_path);
+            expected = scan.getTreeStats();
+            cout << "Pre-scan: " << get<0>(*expected) << " files, " << formatFileSize(get<2>(*expected)) << " to hash" << endl;
+        }
+        catch (const exception &)
+        {
+            // The build runs into the same problem and reports it
+        }
     }
 
     // Clear previous tree data
     file_objects.clear();
     nodes.clear();
 
+    buildMeter.start();
+    if (expected)
+    {
+        buildMeter.expect(get<0>(*expected), get<2>(*expected));
+    }
+
+    treeRoot = fs::path(directory_path);
+    resumedFiles = 0;
+    cachedFiles = 0;
+    skippedEntries.clear();
+    skippedPaths.clear();
+    symlinkCycles.clear();
+    unreadablePaths.clear();
+    rejectedSpecialFiles.clear();
+    linkedContent.clear();
+    walkOptions = walkOptionsSet ? requestedOptions : WalkOptions();
+    if (!walkOptionsSet)
+    {
+        walkOptions.load(treeRoot);
+    }
+    CHUNK_SIZE = requestedChunkSize;
+    ioLimits = requestedLimits;
+    TreeConfig config;
+    configFile = config.load(treeRoot) ? config.path : fs::path();
+    config.apply(walkOptions, CHUNK_SIZE, ioLimits);
+    throttle.configure(ioLimits);
+    if (walkOptions.hashFormat == HashFormat::MtfsBlake3)
+    {
+        throw runtime_error("The mtfs-blake3 hash format needs the Go engine (MTFS_ENGINE=go)");
+    }
+    if (isGitFormat(walkOptions.hashFormat) &&
+        (walkOptions.xattrs || walkOptions.metadata || walkOptions.specialFiles == SpecialFilePolicy::Record))
+    {
+        throw runtime_error("Git trees cannot hold metadata, extended attributes or special files; "
+                            "build without --metadata, --xattrs and --special-files record");
+    }
+    struct stat rootStat;
+    rootDevice = stat(treeRoot.c_str(), &rootStat) == 0 ? rootStat.st_dev : 0;
+    // Members of an archive keep their size and time when it is replaced,
+    // so they are only taken from the checkpoint of an interrupted build
+    if (!dryRun && checkpoint.open(treeRoot, CHUNK_SIZE, walkOptions.hashFormat, hashCache && !archive) &&
+        checkpoint.resumableCount() > 0)
+    {
+        cout << "Resuming from checkpoint: " << checkpoint.resumableCount() << " files already hashed" << endl;
+    }
+
     // Build tree from directory
-    root = build_node(fs::path(directory_path));
+    auto built = archive ? build_archive(treeRoot) : build_node(treeRoot);
+    if (!rejectedSpecialFiles.empty())
+    {
+        sort(rejectedSpecialFiles.begin(), rejectedSpecialFiles.end());
+        string listed;
+        for (size_t i = 0; i < rejectedSpecialFiles.size() && i < 5; ++i)
+        {
+            listed += (i > 0 ? ", " : "") + rejectedSpecialFiles[i];
+        }
+        if (rejectedSpecialFiles.size() > 5)
+        {
+            listed += " and " + to_string(rejectedSpecialFiles.size() - 5) + " more";
+        }
+        linkedContent.clear();
+        throw runtime_error("Special files are not allowed by the tree's policy: " + listed);
+    }
+    root = built;
+    if (dryRun)
+    {
+        // Hard links still count once, as in a build
+        map<pair<dev_t, ino_t>, string> seen;
+        markHardlinks(root, "", seen);
+        linkedContent.clear();
+        buildThroughput = buildMeter.finish();
+        return root;
+    }
 
     // Calculate all hashes
     if (root)
     {
-        root->calculateHash();
+        root->calculateHash(walkOptions.hashFormat);
+        indexNodes(root);
+        map<pair<dev_t, ino_t>, string> seen;
+        markHardlinks(root, "", seen);
     }
+    linkedContent.clear();
 
+    checkpoint.complete();
+    if (!walkOptions.save(treeRoot) || !saveSkipped())
+    {
+        cerr << "Warning: could not record walk options and skipped entries in " << stateDirectory(treeRoot).string() << endl;
+    }
+    buildThroughput = buildMeter.finish();
+    recordMetrics("build", true, buildThroughput);
     return root;
 }
 
+/**
+ * @brief Read the metadata of an entry that is hashed
+ * @param path Filesystem path of the entry
+ * @param fields MetadataField bits to read
+ * @param follow Read what a symbolic link points to rather than the link
+ * @return Values by name, as entryMetadata returns them
+ * @throws runtime_error If the entry cannot be examined
+ */
+static map<string, string> readMetadata(const fs::path &path, unsigned fields, bool follow)
+{
+    struct stat st;
+    if ((follow ? stat(path.c_str(), &st) : lstat(path.c_str(), &st)) != 0)
+    {
+        throw runtime_error(strerror(errno));
+    }
+    return entryMetadata(fields, st.st_mode, st.st_uid, st.st_gid, st.st_mtim.tv_sec);
+}
+
 /**
  * @brief Build a single node from filesystem path
  * @param path Filesystem path to process
+ * @param ctx Walk state of the parent directory
  * @return Shared pointer to the created node
  * @throws runtime_error If path is invalid or inaccessible
  */
-shared_ptr<MerkleNode> MerkleTree::build_node(const fs::path &path)
+shared_ptr<MerkleNode> MerkleTree::build_node(const fs::path &path, const WalkContext &ctx)
 {
+    // The tree root is always followed, even when it is a link itself
+    if (walkOptions.symlinks == SymlinkPolicy::Record && path != treeRoot && fs::is_symlink(path))
+    {
+        auto node = make_shared<MerkleNode>(canonicalName(path.filename().string(), walkOptions.normalization), true);
+        node->isSymlink = true;
+        node->linkTarget = fs::read_symlink(path).string();
+        if (walkOptions.metadata)
+        {
+            node->metadata = readMetadata(path, walkOptions.metadata, false);
+        }
+        return node;
+    }
+
     if (!fs::exists(path))
     {
-        throw runtime_error("Path does not exist: " + path.string());
+        throw runtime_error("vanished during the walk");
     }
 
-    string nodeName = path.filename().string();
+    string nodeName = canonicalName(path.filename().string(), walkOptions.normalization);
     bool isFile = fs::is_regular_file(path);
+    // Devices, sockets and FIFOs only get here when they are recorded
+    bool isSpecial = !isFile && !fs::is_directory(path);
 
-    auto node = make_shared<MerkleNode>(nodeName, isFile);
-    nodes.push_back(node);
+    auto node = make_shared<MerkleNode>(nodeName, isFile || isSpecial);
+    // The root's own metadata belongs to where the tree is kept
+    if (walkOptions.metadata && path != treeRoot)
+    {
+        node->metadata = readMetadata(path, walkOptions.metadata, true);
+    }
+    if (walkOptions.xattrs)
+    {
+        try
+        {
+            node->xattrs = readXattrs(path);
+            if (!walkOptions.appleMetadata)
+            {
+                removeAppleXattrs(node->xattrs);
+            }
+        }
+        catch (const exception &e)
+        {
+            throw runtime_error(path.string() + ": " + e.what());
+        }
+    }
 
-    if (isFile)
+    if (isSpecial)
+    {
+        struct stat st;
+        if (stat(path.c_str(), &st) != 0)
+        {
+            throw runtime_error("Cannot stat " + path.string());
+        }
+        node->specialType = S_ISFIFO(st.st_mode)   ? "fifo"
+                            : S_ISSOCK(st.st_mode) ? "socket"
+                            : S_ISCHR(st.st_mode)  ? "char-device"
+                            : S_ISBLK(st.st_mode)  ? "block-device"
+                                                   : "unknown";
+        if (S_ISCHR(st.st_mode) || S_ISBLK(st.st_mode))
+        {
+            node->deviceNumber = to_string(major(st.st_rdev)) + ":" + to_string(minor(st.st_rdev));
+        }
+    }
+    else if (isFile)
     {
         // Process file
-        try
+        string relPath = path.lexically_relative(treeRoot).generic_string();
+        size_t currentSize = fs::file_size(path);
+        struct stat st;
+        bool statted = stat(path.c_str(), &st) == 0;
+        long long mtime = statted ? st.st_mtim.tv_sec * 1000000000LL + st.st_mtim.tv_nsec : -1;
+        bool linked = statted && st.st_nlink > 1;
+        node->executable = statted && (st.st_mode & S_IXUSR);
+        if (linked)
+        {
+            node->device = st.st_dev;
+            node->inode = st.st_ino;
+        }
+
+        // A dry run only sizes the file; a build reuses the hashes of a
+        // file completed before an interruption or unchanged since the
+        // last build
+        if (dryRun)
         {
-            auto [contentHash, fileSize, chunkHashes] = hash_file_content(path.string());
+            // Opening reads nothing, but finds what a build could not read
+            int fd = openForReading(path.string());
+            if (fd < 0)
+            {
+                throw runtime_error(strerror(errno));
+            }
+            close(fd);
+            node->fileSize = currentSize;
+        }
+        else if (const CheckpointEntry *done = checkpoint.find(relPath, currentSize, mtime))
+        {
+            node->contentHash = done->contentHash;
+            node->fileSize = done->fileSize;
+            node->chunkHashes = done->chunkHashes;
+            resumedFiles++;
+            lock_guard<mutex> lock(buildMutex);
+            buildMeter.skip(done->fileSize);
+        }
+        else if (const CheckpointEntry *unchanged = checkpoint.findCached(relPath, currentSize, mtime))
+        {
+            node->contentHash = unchanged->contentHash;
+            node->fileSize = unchanged->fileSize;
+            node->chunkHashes = unchanged->chunkHashes;
+            cachedFiles++;
+            lock_guard<mutex> lock(buildMutex);
+            buildMeter.skip(unchanged->fileSize);
+            checkpoint.record(relPath, *unchanged);
+        }
+        else
+        {
+            auto [contentHash, fileSize, chunkHashes] =
+                linked ? hash_linked_file(path, st, relPath) : hash_file_content(path.string(), relPath);
 
             node->contentHash = contentHash;
             node->fileSize = fileSize;
             node->chunkHashes = chunkHashes;
 
-            // Store in file_objects map
-            file_objects[contentHash] = node;
-        }
-        catch (const exception &e)
-        {
-            throw runtime_error("Error processing file " + path.string() + ": " + e.what());
-        }
+            lock_guard<mutex> lock(buildMutex);
+            checkpoint.record(relPath, {fileSize, mtime, contentHash, chunkHashes});
+        }
+
+        recordProgress(0, 1);
+    }
+    else if (fs::is_directory(path))
+    {
+        // Process directory
+        build_children(node, path, ctx);
+    }
+
+    return node;
+}
+
+/**
+ * @brief Build the children of a directory node
+ * @param node Directory node to populate
+ * @param path Filesystem path of the directory
+ * @param ctx Walk state of the parent directory
+ */
+void MerkleTree::build_children(const shared_ptr<MerkleNode> &node, const fs::path &path, const WalkContext &ctx)
+{
+    string relDir = path.lexically_relative(treeRoot).generic_string();
+    if (relDir == ".")
+    {
+        relDir.clear();
+    }
+    WalkContext dirCtx = ctx;
+    dirCtx.ignore = IgnoreRules::forDirectory(ctx.ignore, path, relDir);
+    // Below the depth limit or at a mount point a directory is kept, but
+    // its entries are not read
+    if (walkOptions.maxDepth > 0 && ctx.depth >= walkOptions.maxDepth)
+    {
+        recordSkip("max depth", relDir);
+        return;
+    }
+
+    struct stat dirStat;
+    if (stat(path.c_str(), &dirStat) == 0)
+    {
+        if (walkOptions.oneFileSystem && dirStat.st_dev != rootDevice)
+        {
+            recordSkip("other file system", relDir);
+            return;
+        }
+        dirCtx.ancestors = make_shared<const WalkContext::Directory>(
+            WalkContext::Directory{dirStat.st_dev, dirStat.st_ino, relDir, ctx.ancestors});
+    }
+
+    vector<pair<fs::path, WalkContext>> entries;
+    for (const auto &entry : fs::directory_iterator(path))
+    {
+        // MTFS state (checkpoints etc.) is never part of the tree
+        if (entry.path().filename() == MTFSConstants::STATE_DIR)
+        {
+            continue;
+        }
+        // Nor is the attestation badge stating its root hash
+        if (relDir.empty() && entry.path().filename() == MTFSConstants::ATTESTATION_FILE)
+        {
+            continue;
+        }
+        // Git never stores the repository itself in its trees
+        if (isGitFormat(walkOptions.hashFormat) && entry.path().filename() == ".git")
+        {
+            continue;
+        }
+        string name = entry.path().filename().string();
+        string relPath = relDir.empty() ? name : relDir + "/" + name;
+        if (!walkOptions.hidden && name[0] == '.')
+        {
+            recordSkip("hidden", relPath);
+            continue;
+        }
+        if (!walkOptions.appleMetadata && isAppleMetadataName(name))
+        {
+            recordSkip("apple metadata", relPath);
+            continue;
+        }
+        bool isLink = entry.is_symlink();
+        if (isLink && walkOptions.symlinks == SymlinkPolicy::Skip)
+        {
+            recordSkip("symlink", relPath);
+            continue;
+        }
+        // Recorded links are leaves, whatever they point to
+        bool isDir = !(isLink && walkOptions.symlinks == SymlinkPolicy::Record) && entry.is_directory();
+        if (!dirCtx.ignore->empty() && dirCtx.ignore->ignored(relPath, isDir))
+        {
+            recordSkip(MTFSConstants::IGNORE_FILE, relPath);
+            continue;
+        }
+        if (walkOptions.isExcluded(relPath))
+        {
+            recordSkip("excluded", relPath);
+            continue;
+        }
+        if (isLink && walkOptions.symlinks == SymlinkPolicy::Follow && !fs::exists(entry.path()))
+        {
+            recordSkip("broken symlink", relPath);
+            continue;
+        }
+        if (isDir && leadsToAncestor(entry.path(), relPath, dirCtx.ancestors))
+        {
+            continue;
+        }
+
+        // Directories are walked even if they do not match an include
+        // pattern, since files further down still might
+        WalkContext childCtx = dirCtx;
+        childCtx.included = ctx.included || walkOptions.isIncluded(relPath);
+        childCtx.depth = ctx.depth + 1;
+        if (!childCtx.included && !isDir)
+        {
+            recordSkip("not included", relPath);
+            continue;
+        }
+        if (!isDir && !(isLink && walkOptions.symlinks == SymlinkPolicy::Record) && !entry.is_regular_file())
+        {
+            if (walkOptions.specialFiles == SpecialFilePolicy::Skip)
+            {
+                recordSkip("special file", relPath);
+                continue;
+            }
+            if (walkOptions.specialFiles == SpecialFilePolicy::Error)
+            {
+                lock_guard<mutex> lock(buildMutex);
+                rejectedSpecialFiles.push_back(relPath);
+                continue;
+            }
+        }
+        if ((walkOptions.minFileSize > 0 || walkOptions.maxFileSize > 0) && !isDir &&
+            !(isLink && walkOptions.symlinks == SymlinkPolicy::Record) && entry.is_regular_file())
+        {
+            if (const char *limit = walkOptions.sizeLimit(entry.file_size()))
+            {
+                recordSkip(limit, relPath);
+                continue;
+            }
+        }
+        entries.emplace_back(entry.path(), childCtx);
+    }
+    sort(entries.begin(), entries.end(), [](const auto &a, const auto &b)
+         { return a.first < b.first; });
+
+    // Names that only differ in their normalization would replace each
+    // other, and those that only differ in case could not be restored side
+    // by side on a case-insensitive file system, so only the first in raw
+    // name order is kept
+    if (walkOptions.normalization != NameNormalization::None || walkOptions.caseInsensitive)
+    {
+        set<string> canonicalNames;
+        auto collides = [&](const pair<fs::path, WalkContext> &entry)
+        {
+            string name = entry.first.filename().string();
+            string key = canonicalName(name, walkOptions.normalization);
+            if (canonicalNames.insert(walkOptions.caseInsensitive ? caseFoldedName(key) : key).second)
+            {
+                return false;
+            }
+            recordSkip("name collision", relDir.empty() ? name : relDir + "/" + name);
+            return true;
+        };
+        entries.erase(remove_if(entries.begin(), entries.end(), collides), entries.end());
+    }
+
+    // Start sibling subtrees on free worker threads, build the rest inline
+    vector<future<shared_ptr<MerkleNode>>> children;
+    for (const auto &entry : entries)
+    {
+        const fs::path &entryPath = entry.first;
+        const WalkContext &childCtx = entry.second;
+        if (acquireWorker())
+        {
+            auto walk = [this, entryPath, childCtx]()
+            {
+                try
+                {
+                    auto child = build_node(entryPath, childCtx);
+                    activeWorkers--;
+                    return child;
+                }
+                catch (...)
+                {
+                    activeWorkers--;
+                    throw;
+                }
+            };
+            children.push_back(async(launch::async, walk));
+        }
+        else
+        {
+            promise<shared_ptr<MerkleNode>> built;
+            try
+            {
+                built.set_value(build_node(entryPath, childCtx));
+            }
+            catch (...)
+            {
+                built.set_exception(current_exception());
+            }
+            children.push_back(built.get_future());
+        }
+    }
+
+    // Assemble in canonical order regardless of completion order
+    for (size_t i = 0; i < children.size(); ++i)
+    {
+        try
+        {
+            auto child = children[i].get();
+            // Directories holding nothing in scope are left out entirely
+            if (!child->isFile && child->children.empty() && !entries[i].second.included)
+            {
+                continue;
+            }
+            node->addChild(child);
+        }
+        catch (const exception &e)
+        {
+            // An entry that cannot be read becomes an error leaf holding
+            // the reason, and the build goes on with the others
+            auto failure = dynamic_cast<const fs::filesystem_error *>(&e);
+            string name = entries[i].first.filename().string();
+            auto leaf = make_shared<MerkleNode>(canonicalName(name, walkOptions.normalization), true);
+            leaf->error = failure ? failure->code().message() : e.what();
+            node->addChild(leaf);
+            lock_guard<mutex> lock(buildMutex);
+            unreadablePaths.emplace_back(relDir.empty() ? name : relDir + "/" + name, leaf->error);
+        }
+    }
+}
+
+// Split an archive member path into its names, rejecting paths that leave the archive
+static bool archivePathParts(const string &path, vector<string> &parts)
+{
+    parts.clear();
+    stringstream in(path);
+    string part;
+    while (getline(in, part, '/'))
+    {
+        if (part == "..")
+        {
+            return false;
+        }
+        if (!part.empty() && part != ".")
+        {
+            parts.push_back(part);
+        }
+    }
+    return true;
+}
+
+// Join the names of an archive member path
+static string archivePath(const vector<string> &parts)
+{
+    string path;
+    for (const auto &part : parts)
+    {
+        path += (path.empty() ? "" : "/") + part;
+    }
+    return path;
+}
+
+// Resolve the member a symlink of an archive leads to as the file system
+// would once the archive is extracted, through the links on the way;
+// reason tells why a link leads nowhere
+static bool resolveArchiveLink(const string &relPath, const map<string, string> &symlinks, string &resolved, string &reason)
+{
+    auto split = [](const string &path)
+    {
+        vector<string> parts;
+        stringstream in(path);
+        string part;
+        while (getline(in, part, '/'))
+        {
+            parts.push_back(part);
+        }
+        return parts;
+    };
+    vector<string> done = split(relPath);
+    done.pop_back();
+    string target = symlinks.at(relPath);
+    deque<string> todo;
+    // As many links as Linux follows in one lookup
+    for (int hops = 0; hops <= 40; ++hops)
+    {
+        if (target.empty())
+        {
+            reason = "broken symlink";
+            return false;
+        }
+        if (target[0] == '/')
+        {
+            reason = "symlink outside archive";
+            return false;
+        }
+        auto parts = split(target);
+        todo.insert(todo.begin(), parts.begin(), parts.end());
+        bool linked = false;
+        while (!todo.empty() && !linked)
+        {
+            string part = todo.front();
+            todo.pop_front();
+            if (part.empty() || part == ".")
+            {
+                continue;
+            }
+            if (part == "..")
+            {
+                if (done.empty())
+                {
+                    reason = "symlink outside archive";
+                    return false;
+                }
+                done.pop_back();
+                continue;
+            }
+            done.push_back(part);
+            auto link = symlinks.find(archivePath(done));
+            if (link != symlinks.end())
+            {
+                done.pop_back();
+                target = link->second;
+                linked = true;
+            }
+        }
+        if (!linked)
+        {
+            resolved = archivePath(done);
+            return true;
+        }
+    }
+    reason = "broken symlink";
+    return false;
+}
+
+/**
+ * @brief Build a tree mirroring the layout of an archive
+ * @param archive Filesystem path of a tar or zip archive
+ * @return Root node named after the archive without its extensions
+ */
+shared_ptr<MerkleNode> MerkleTree::build_archive(const fs::path &archive)
+{
+    string rootName = archive.filename().string();
+    for (bool stripped = true; stripped;)
+    {
+        stripped = false;
+        for (const string &extension : {string(".gz"), string(".tgz"), string(".tar"), string(".zip")})
+        {
+            if (rootName.size() > extension.size() &&
+                rootName.compare(rootName.size() - extension.size(), extension.size(), extension) == 0)
+            {
+                rootName.erase(rootName.size() - extension.size());
+                stripped = true;
+            }
+        }
+    }
+    auto root = make_shared<MerkleNode>(canonicalName(rootName, walkOptions.normalization), false);
+
+    map<string, shared_ptr<MerkleNode>> dirs{{"", root}}; // Directory nodes by archive path
+    set<MerkleNode *> includedDirs;                        // Directories kept even when empty
+    map<string, shared_ptr<MerkleNode>> files;             // File nodes by archive path, for hard links
+    map<string, ino_t> linkedFiles;                        // Stand-in inode of each hard-linked file
+    set<string> leftOut;                                   // Skipped directories, with all their members
+    set<string> depthLimited;                              // Directories at the depth limit
+    map<string, vector<pair<string, shared_ptr<MerkleNode>>>> pendingLinks; // Hard links to members not hashed, by target
+    map<string, char> members;                             // Type of every member by archive path
+    map<string, string> symlinks;                          // Target of every symlink member by archive path
+    vector<pair<string, bool>> followedLinks;              // Symlinks to follow, and whether they are included
+
+    // Find the node of a directory, creating it and its parents if the
+    // archive holds no member for them
+    function<shared_ptr<MerkleNode>(const string &)> directory = [&](const string &relDir)
+    {
+        auto found = dirs.find(relDir);
+        if (found != dirs.end())
+        {
+            return found->second;
+        }
+        size_t slash = relDir.rfind('/');
+        string parentDir = slash == string::npos ? "" : relDir.substr(0, slash);
+        auto parent = directory(parentDir);
+        string name = canonicalName(relDir.substr(slash == string::npos ? 0 : slash + 1), walkOptions.normalization);
+        // Names that only differ in their normalization share one directory
+        auto existing = parent->children.find(name);
+        shared_ptr<MerkleNode> node = existing != parent->children.end() && !existing->second->isFile
+                                          ? existing->second
+                                          : make_shared<MerkleNode>(name, false);
+        parent->addChild(node);
+        dirs[relDir] = node;
+        for (size_t end = relDir.find('/');; end = relDir.find('/', end + 1))
+        {
+            if (walkOptions.isIncluded(relDir.substr(0, end)))
+            {
+                includedDirs.insert(node.get());
+                break;
+            }
+            if (end == string::npos)
+            {
+                break;
+            }
+        }
+        return node;
+    };
+
+    ArchiveReader reader(archive);
+    ArchiveEntry entry;
+    vector<string> parts;
+    while (reader.next(entry))
+    {
+        if (!archivePathParts(entry.path, parts))
+        {
+            recordSkip("unsafe path", entry.path);
+            continue;
+        }
+        if (parts.empty())
+        {
+            continue;
+        }
+        // Links resolve through members left out as well
+        members[archivePath(parts)] = entry.type;
+        if (entry.type == 'l')
+        {
+            symlinks[archivePath(parts)] = entry.linkTarget;
+        }
+        if (!walkOptions.appleMetadata)
+        {
+            removeAppleXattrs(entry.xattrs);
+        }
+
+        // Check the directories on the way as a walk would have, down to
+        // the depth limit
+        size_t checked = walkOptions.maxDepth > 0 ? min(parts.size(), walkOptions.maxDepth) : parts.size();
+        string relPath;
+        bool included = false;
+        bool skipped = false;
+        for (size_t i = 0; i < checked && !skipped; ++i)
+        {
+            const string &name = parts[i];
+            relPath = i == 0 ? name : relPath + "/" + name;
+            if (leftOut.count(relPath) || name == MTFSConstants::STATE_DIR || relPath == MTFSConstants::ATTESTATION_FILE ||
+                (isGitFormat(walkOptions.hashFormat) && name == ".git"))
+            {
+                skipped = true;
+                break;
+            }
+            string reason = !walkOptions.hidden && name[0] == '.'                  ? "hidden"
+                            : !walkOptions.appleMetadata && isAppleMetadataName(name) ? "apple metadata"
+                            : walkOptions.isExcluded(relPath)                         ? "excluded"
+                                                                                      : "";
+            if (!reason.empty())
+            {
+                recordSkip(reason, relPath);
+                leftOut.insert(relPath);
+                skipped = true;
+            }
+            included = included || walkOptions.isIncluded(relPath);
+        }
+        if (skipped)
+        {
+            continue;
+        }
+        // Below the depth limit a directory is kept, but its members are not
+        if (checked < parts.size())
+        {
+            if (depthLimited.insert(relPath).second)
+            {
+                recordSkip("max depth", relPath);
+            }
+            directory(relPath);
+            continue;
+        }
+
+        if (entry.type == 'd')
+        {
+            if (walkOptions.maxDepth > 0 && parts.size() == walkOptions.maxDepth && depthLimited.insert(relPath).second)
+            {
+                recordSkip("max depth", relPath);
+            }
+            auto node = directory(relPath);
+            if (walkOptions.xattrs)
+            {
+                node->xattrs = entry.xattrs;
+            }
+            node->metadata = entryMetadata(walkOptions.metadata, entry.mode, entry.uid, entry.gid, entry.mtime);
+            continue;
+        }
+        if (entry.type == 'l' && walkOptions.symlinks == SymlinkPolicy::Follow)
+        {
+            // Followed once every member is known; a link to a directory
+            // is walked like one even if not included
+            followedLinks.emplace_back(relPath, included);
+            continue;
+        }
+        if (!included)
+        {
+            recordSkip("not included", relPath);
+            continue;
+        }
+
+        auto node = make_shared<MerkleNode>(canonicalName(parts.back(), walkOptions.normalization), true);
+        if (walkOptions.xattrs)
+        {
+            node->xattrs = entry.xattrs;
+        }
+        node->metadata = entryMetadata(walkOptions.metadata, entry.mode, entry.uid, entry.gid, entry.mtime);
+        if (entry.type == 'l')
+        {
+            if (walkOptions.symlinks == SymlinkPolicy::Skip)
+            {
+                recordSkip("symlink", relPath);
+                continue;
+            }
+            node->isSymlink = true;
+            node->linkTarget = entry.linkTarget;
+        }
+        else if (entry.type == 'c' || entry.type == 'b' || entry.type == 'p')
+        {
+            if (walkOptions.specialFiles == SpecialFilePolicy::Skip)
+            {
+                recordSkip("special file", relPath);
+                continue;
+            }
+            if (walkOptions.specialFiles == SpecialFilePolicy::Error)
+            {
+                rejectedSpecialFiles.push_back(relPath);
+                continue;
+            }
+            node->specialType = entry.type == 'c' ? "char-device" : entry.type == 'b' ? "block-device" : "fifo";
+            node->deviceNumber = entry.deviceNumber;
+        }
+        else if (entry.type == 'h')
+        {
+            // A hard link shares the content of a member stored earlier
+            vector<string> targetParts;
+            string target;
+            if (archivePathParts(entry.linkTarget, targetParts))
+            {
+                for (const auto &part : targetParts)
+                {
+                    target += (target.empty() ? "" : "/") + part;
+                }
+            }
+            auto linked = files.find(target);
+            if (linked == files.end())
+            {
+                // The member was left out, so its content is read in a second pass
+                pendingLinks[target].emplace_back(relPath, node);
+                continue;
+            }
+            const auto &file = linked->second;
+            if (const char *limit = walkOptions.sizeLimit(file->fileSize))
+            {
+                recordSkip(limit, relPath);
+                continue;
+            }
+            node->contentHash = file->contentHash;
+            node->fileSize = file->fileSize;
+            node->chunkHashes = file->chunkHashes;
+            node->executable = file->executable;
+            // Stand-in inodes let markHardlinks pair the paths as on disk
+            auto [id, added] = linkedFiles.emplace(target, linkedFiles.size() + 1);
+            file->inode = id->second;
+            node->inode = id->second;
+            files[relPath] = node;
+        }
+        else
+        {
+            if (const char *limit = walkOptions.sizeLimit(entry.size))
+            {
+                recordSkip(limit, relPath);
+                continue;
+            }
+            node->executable = entry.mode & S_IXUSR;
+            try
+            {
+                if (dryRun)
+                {
+                    node->fileSize = entry.size;
+                }
+                else if (const CheckpointEntry *done = checkpoint.find(relPath, entry.size, entry.mtime))
+                {
+                    node->contentHash = done->contentHash;
+                    node->fileSize = done->fileSize;
+                    node->chunkHashes = done->chunkHashes;
+                    resumedFiles++;
+                }
+                else
+                {
+                    istringstream content(reader.readData());
+                    auto [contentHash, fileSize, chunkHashes] = hash_stream(content, relPath, entry.size);
+                    node->contentHash = contentHash;
+                    node->fileSize = fileSize;
+                    node->chunkHashes = chunkHashes;
+                    checkpoint.record(relPath, {fileSize, entry.mtime, contentHash, chunkHashes});
+                }
+            }
+            catch (const exception &e)
+            {
+                throw runtime_error("Error processing archive member " + relPath + ": " + e.what());
+            }
+            recordProgress(0, 1);
+            files[relPath] = node;
+        }
+
+        size_t slash = relPath.rfind('/');
+        directory(slash == string::npos ? "" : relPath.substr(0, slash))->addChild(node);
+    }
+
+    // Links are followed as the file system follows them once the archive
+    // is extracted: a link to a file takes its content, and one to a
+    // directory holds a copy of its members, made below
+    map<MerkleNode *, vector<pair<string, MerkleNode *>>> dirLinks; // Links to directories, with their names, by the directory holding them
+    map<string, vector<pair<string, shared_ptr<MerkleNode>>>> pendingTargets; // Links to files not hashed, by target
+    for (const auto &[relPath, included] : followedLinks)
+    {
+        size_t slash = relPath.rfind('/');
+        string parentDir = slash == string::npos ? "" : relPath.substr(0, slash);
+        string name = canonicalName(relPath.substr(slash == string::npos ? 0 : slash + 1), walkOptions.normalization);
+        string target, reason;
+        if (!resolveArchiveLink(relPath, symlinks, target, reason))
+        {
+            recordSkip(reason, relPath);
+            continue;
+        }
+        auto dir = dirs.find(target);
+        if (dir != dirs.end())
+        {
+            dirLinks[directory(parentDir).get()].emplace_back(name, dir->second.get());
+            continue;
+        }
+        if (!included)
+        {
+            recordSkip("not included", relPath);
+            continue;
+        }
+        auto file = files.find(target);
+        auto member = members.find(target);
+        if (file != files.end())
+        {
+            auto node = make_shared<MerkleNode>(*file->second);
+            node->name = name;
+            directory(parentDir)->addChild(node);
+        }
+        else if (member != members.end() && member->second == 'f')
+        {
+            pendingTargets[target].emplace_back(relPath, make_shared<MerkleNode>(name, true));
+        }
+        else
+        {
+            recordSkip(member == members.end() ? "broken symlink" : "symlink target left out", relPath);
+        }
+    }
+
+    if (!pendingLinks.empty() || !pendingTargets.empty())
+    {
+        ArchiveReader again(archive);
+        while (again.next(entry))
+        {
+            string target;
+            if (entry.type != 'f' || !archivePathParts(entry.path, parts))
+            {
+                continue;
+            }
+            for (const auto &part : parts)
+            {
+                target += (target.empty() ? "" : "/") + part;
+            }
+            auto links = pendingLinks.find(target);
+            auto targets = pendingTargets.find(target);
+            if (links == pendingLinks.end() && targets == pendingTargets.end())
+            {
+                continue;
+            }
+            tuple<string, size_t, vector<string>> hashed{"", entry.size, {}};
+            if (!dryRun)
+            {
+                istringstream content(again.readData());
+                hashed = hash_stream(content, target, entry.size);
+            }
+            auto [contentHash, fileSize, chunkHashes] = hashed;
+            if (targets != pendingTargets.end())
+            {
+                for (const auto &[relPath, node] : targets->second)
+                {
+                    if (const char *limit = walkOptions.sizeLimit(fileSize))
+                    {
+                        recordSkip(limit, relPath);
+                        continue;
+                    }
+                    node->contentHash = contentHash;
+                    node->fileSize = fileSize;
+                    node->chunkHashes = chunkHashes;
+                    node->executable = entry.mode & S_IXUSR;
+                    node->metadata = entryMetadata(walkOptions.metadata, entry.mode, entry.uid, entry.gid, entry.mtime);
+                    size_t slash = relPath.rfind('/');
+                    directory(slash == string::npos ? "" : relPath.substr(0, slash))->addChild(node);
+                }
+                pendingTargets.erase(targets);
+            }
+            if (links == pendingLinks.end())
+            {
+                continue;
+            }
+            ino_t id = linkedFiles.emplace(target, linkedFiles.size() + 1).first->second;
+            for (const auto &[relPath, node] : links->second)
+            {
+                if (const char *limit = walkOptions.sizeLimit(fileSize))
+                {
+                    recordSkip(limit, relPath);
+                    continue;
+                }
+                node->contentHash = contentHash;
+                node->fileSize = fileSize;
+                node->chunkHashes = chunkHashes;
+                node->executable = entry.mode & S_IXUSR;
+                node->inode = id;
+                size_t slash = relPath.rfind('/');
+                directory(slash == string::npos ? "" : relPath.substr(0, slash))->addChild(node);
+            }
+            pendingLinks.erase(links);
+        }
+        for (const auto &[target, links] : pendingLinks)
+        {
+            for (const auto &link : links)
+            {
+                recordSkip("hard link", link.first);
+            }
+        }
+    }
+
+    // Copies of directories follow the links to directories in them in
+    // turn, and leave out directories the copy is already inside of
+    using Ancestors = vector<pair<MerkleNode *, string>>;
+    function<shared_ptr<MerkleNode>(MerkleNode *, const string &, const string &, Ancestors, size_t)> copyDirectory;
+    auto followDirLinks = [&](MerkleNode *dir, const string &relDir, const Ancestors &ancestors, size_t depth)
+    {
+        vector<shared_ptr<MerkleNode>> copies;
+        auto links = dirLinks.find(dir);
+        if (links == dirLinks.end())
+        {
+            return copies;
+        }
+        for (const auto &[name, target] : links->second)
+        {
+            if (auto copy = copyDirectory(target, name, relDir.empty() ? name : relDir + "/" + name, ancestors, depth + 1))
+            {
+                copies.push_back(copy);
+            }
+        }
+        return copies;
+    };
+    copyDirectory = [&](MerkleNode *original, const string &name, const string &relPath, Ancestors ancestors, size_t depth)
+    {
+        auto cycle = find_if(ancestors.begin(), ancestors.end(), [&](const auto &ancestor)
+                             { return ancestor.first == original; });
+        if (cycle != ancestors.end())
+        {
+            {
+                lock_guard<mutex> lock(buildMutex);
+                symlinkCycles.push_back(relPath + " -> " + (cycle->second.empty() ? "." : cycle->second));
+            }
+            recordSkip("symlink cycle", relPath);
+            return shared_ptr<MerkleNode>();
+        }
+        auto copy = make_shared<MerkleNode>(*original);
+        copy->name = name;
+        copy->children.clear();
+        if (includedDirs.count(original))
+        {
+            includedDirs.insert(copy.get());
+        }
+        if (walkOptions.maxDepth > 0 && depth >= walkOptions.maxDepth)
+        {
+            recordSkip("max depth", relPath);
+            return copy;
+        }
+        ancestors.emplace_back(original, relPath);
+        for (const auto &[childName, child] : original->children)
+        {
+            auto childCopy = child->isFile ? make_shared<MerkleNode>(*child)
+                                           : copyDirectory(child.get(), childName, relPath + "/" + childName, ancestors, depth + 1);
+            if (childCopy)
+            {
+                copy->addChild(childCopy);
+            }
+        }
+        for (const auto &linked : followDirLinks(original, relPath, ancestors, depth))
+        {
+            copy->addChild(linked);
+        }
+        return copy;
+    };
+    // The copies are added once all are made, so none holds another twice
+    vector<pair<MerkleNode *, shared_ptr<MerkleNode>>> linkedDirs;
+    for (const auto &[relDir, dir] : dirs)
+    {
+        if (!dirLinks.count(dir.get()))
+        {
+            continue;
+        }
+        Ancestors ancestors{{root.get(), ""}};
+        vector<string> parts;
+        archivePathParts(relDir, parts);
+        for (const auto &part : parts)
+        {
+            string path = ancestors.back().second.empty() ? part : ancestors.back().second + "/" + part;
+            ancestors.emplace_back(dirs[path].get(), path);
+        }
+        for (const auto &linked : followDirLinks(dir.get(), relDir, ancestors, parts.size()))
+        {
+            linkedDirs.emplace_back(dir.get(), linked);
+        }
+    }
+    for (const auto &[dir, linked] : linkedDirs)
+    {
+        dir->addChild(linked);
+    }
+
+    // Directories holding nothing in scope are left out entirely
+    function<void(const shared_ptr<MerkleNode> &)> prune = [&](const shared_ptr<MerkleNode> &node)
+    {
+        for (auto it = node->children.begin(); it != node->children.end();)
+        {
+            const auto &child = it->second;
+            if (!child->isFile)
+            {
+                prune(child);
+            }
+            if (!child->isFile && child->children.empty() && !includedDirs.count(child.get()))
+            {
+                it = node->children.erase(it);
+            }
+            else
+            {
+                ++it;
+            }
+        }
+    };
+    prune(root);
+    return root;
+}
+
+/**
+ * @brief Claim a walker thread slot if one is free
+ * @return True if the caller may start a new walker thread
+ */
+bool MerkleTree::acquireWorker()
+{
+    // The calling thread walks too, so it takes one of the slots
+    size_t active = activeWorkers.load();
+    while (active + 1 < maxWorkers)
+    {
+        if (activeWorkers.compare_exchange_weak(active, active + 1))
+        {
+            return true;
+        }
+    }
+    return false;
+}
+
+/**
+ * @brief Record hashing progress from any walker thread
+ * @param bytes Number of bytes hashed
+ * @param files Number of files completed
+ */
+void MerkleTree::recordProgress(size_t bytes, size_t files)
+{
+    lock_guard<mutex> lock(buildMutex);
+    buildMeter.record(bytes, files);
+}
+
+/**
+ * @brief Check whether a directory entry leads back to a directory being walked
+ * @param path Filesystem path of the entry
+ * @param relPath Path of the entry relative to the tree root
+ * @param ancestors Directories from the parent of the entry up to the tree root
+ * @return True if following the entry would recurse forever; the cycle is recorded
+ */
+bool MerkleTree::leadsToAncestor(const fs::path &path, const string &relPath,
+                                 const shared_ptr<const WalkContext::Directory> &ancestors)
+{
+    struct stat target;
+    if (stat(path.c_str(), &target) != 0)
+    {
+        return false;
+    }
+    for (auto dir = ancestors; dir; dir = dir->parent)
+    {
+        if (dir->device == target.st_dev && dir->inode == target.st_ino)
+        {
+            {
+                lock_guard<mutex> lock(buildMutex);
+                symlinkCycles.push_back(relPath + " -> " + (dir->relPath.empty() ? "." : dir->relPath));
+            }
+            recordSkip("symlink cycle", relPath);
+            return true;
+        }
+    }
+    return false;
+}
+
+/**
+ * @brief Hash a file with several hard links only once per build
+ * @param path Filesystem path of the file
+ * @param st Status of the file
+ * @param relPath Path of the file relative to the tree root
+ * @return Tuple containing (content_hash, file_size, chunk_hashes)
+ */
+tuple<string, size_t, vector<string>> MerkleTree::hash_linked_file(const fs::path &path, const struct stat &st,
+                                                                   const string &relPath)
+{
+    promise<tuple<string, size_t, vector<string>>> hashed;
+    auto result = hashed.get_future().share();
+    bool first = false;
+    {
+        lock_guard<mutex> lock(buildMutex);
+        auto [it, inserted] = linkedContent.emplace(make_pair(st.st_dev, st.st_ino), result);
+        result = it->second;
+        first = inserted;
+    }
+
+    if (first)
+    {
+        try
+        {
+            hashed.set_value(hash_file_content(path.string(), relPath));
+        }
+        catch (...)
+        {
+            hashed.set_exception(current_exception());
+        }
+    }
+    return result.get();
+}
+
+/**
+ * @brief Mark files sharing an inode with an earlier path of the tree
+ * @param node Node to start from
+ * @param relPath Path of the node relative to the tree root
+ * @param seen First path found for each hard-linked inode
+ */
+void MerkleTree::markHardlinks(const shared_ptr<MerkleNode> &node, const string &relPath,
+                               map<pair<dev_t, ino_t>, string> &seen)
+{
+    if (node->isFile)
+    {
+        node->hardlinkOf.clear();
+        if (node->inode != 0)
+        {
+            auto [it, inserted] = seen.emplace(make_pair(node->device, node->inode), relPath);
+            if (!inserted)
+            {
+                node->hardlinkOf = it->second;
+            }
+        }
+        return;
+    }
+
+    // Children are kept in name order, so the first path is deterministic
+    for (const auto &[name, child] : node->children)
+    {
+        markHardlinks(child, relPath.empty() ? name : relPath + "/" + name, seen);
+    }
+}
+
+/**
+ * @brief Record an entry left out of the tree
+ * @param reason Why the entry was skipped
+ * @param relPath Path of the entry relative to the tree root
+ */
+void MerkleTree::recordSkip(const string &reason, const string &relPath)
+{
+    lock_guard<mutex> lock(buildMutex);
+    skippedEntries[reason]++;
+    skippedPaths.emplace_back(reason, relPath);
+}
+
+/**
+ * @brief Write the entries skipped by the last build to the state directory
+ * @return True if the list was written
+ */
+bool MerkleTree::saveSkipped() const
+{
+    vector<pair<string, string>> sorted = skippedPaths;
+    sort(sorted.begin(), sorted.end(), [](const auto &a, const auto &b)
+         { return a.second < b.second; });
+
+    ofstream out(stateDirectory(treeRoot) / MTFSConstants::SKIPPED_FILE, ios::trunc);
+    if (!out.is_open())
+    {
+        return false;
+    }
+    out << "MTFS-SKIPPED 1\n";
+    for (const auto &[reason, relPath] : sorted)
+    {
+        out << reason << "\t" << escapeField(relPath) << "\n";
+    }
+    return out.good();
+}
+
+/**
+ * @brief Rebuild the node list and file object index in canonical order
+ * @param node Current node
+ */
+void MerkleTree::indexNodes(const shared_ptr<MerkleNode> &node)
+{
+    nodes.push_back(node);
+
+    // The first file in sorted order owns a content hash shared by duplicates
+    if (node->isSymlink || !node->specialType.empty() || !node->error.empty())
+    {
+        return;
     }
-    else if (fs::is_directory(path))
+    if (node->isFile)
     {
-        // Process directory
-        try
-        {
-            for (const auto &entry : fs::directory_iterator(path))
-            {
-                try
-                {
-                    auto childNode = build_node(entry.path());
-                    node->addChild(childNode);
-                }
-                catch (const exception &e)
-                {
-                    // Log error but continue processing other entries
-                    cerr << "Warning: Skipping " << entry.path().string() << " - " << e.what() << endl;
-                }
-            }
-        }
-        catch (const exception &e)
-        {
-            throw runtime_error("Error reading directory " + path.string() + ": " + e.what());
-        }
+        file_objects.emplace(node->contentHash, node);
+        return;
     }
 
-    return node;
+    for (const auto &child : node->children)
+    {
+        indexNodes(child.second);
+    }
 }
 
 /**
@@ -226,11 +1453,33 @@ void MerkleTree::print_tree_details(shared_ptr<MerkleNode> node, int depth)
     string indent(depth * 2, ' ');
     cout << indent << node->name;
 
-    if (node->isFile)
+    if (node->isSymlink)
+    {
+        cout << " (Symlink -> " << node->linkTarget << ", Hash: " << node->hash.substr(0, 8) << "...)";
+    }
+    else if (!node->specialType.empty())
+    {
+        cout << " (Special: " << node->specialType;
+        if (!node->deviceNumber.empty())
+        {
+            cout << " " << node->deviceNumber;
+        }
+        cout << ", Hash: " << node->hash.substr(0, 8) << "...)";
+    }
+    else if (!node->error.empty())
+    {
+        cout << " (Unreadable: " << node->error << ")";
+    }
+    else if (node->isFile)
     {
         cout << " (File, Size: " << node->fileSize << " bytes, Hash: "
              << node->contentHash.substr(0, 8) << "...)";
 
+        if (!node->hardlinkOf.empty())
+        {
+            cout << " [hardlink to " << node->hardlinkOf << "]";
+        }
+
         if (node->chunkHashes.size() > 1)
         {
             cout << " [" << node->chunkHashes.size() << " chunks]";
@@ -240,6 +1489,20 @@ void MerkleTree::print_tree_details(shared_ptr<MerkleNode> node, int depth)
     {
         cout << " (Directory, Children: " << node->children.size() << ")";
     }
+    if (!node->metadata.empty())
+    {
+        cout << " [";
+        size_t i = 0;
+        for (const auto &[name, value] : node->metadata)
+        {
+            cout << (i++ > 0 ? " " : "") << name << " " << value;
+        }
+        cout << "]";
+    }
+    if (!node->xattrs.empty())
+    {
+        cout << " [" << node->xattrs.size() << " xattrs]";
+    }
 
     cout << endl;
 
@@ -277,6 +1540,23 @@ void MerkleTree::print_file_objects()
         cout << "  Size: " << node->fileSize << " bytes" << endl;
         cout << "  Chunks: " << node->chunkHashes.size() << endl;
 
+        if (!node->metadata.empty())
+        {
+            cout << "  Metadata Hash: " << node->metadataHash << endl;
+            for (const auto &[name, value] : node->metadata)
+            {
+                cout << "    " << name << " = " << value << endl;
+            }
+        }
+        if (!node->xattrs.empty())
+        {
+            cout << "  Xattrs Hash: " << node->xattrsHash << endl;
+            for (const auto &[name, value] : node->xattrs)
+            {
+                cout << "    " << name << " = " << formatXattrValue(name, value) << endl;
+            }
+        }
+
         if (node->chunkHashes.size() > 1)
         {
             cout << "  Chunk Hashes:" << endl;
@@ -358,7 +1638,40 @@ bool MerkleTree::verifyTreeIntegrity()
         return true; // Empty tree is valid
     }
 
-    return verifyNodeIntegrity(root);
+    verifyMeter.start();
+    bool valid = verifyNodeIntegrity(root);
+    verifyThroughput = verifyMeter.finish();
+    recordMetrics("verify", valid, verifyThroughput);
+
+    return valid;
+}
+
+/**
+ * @brief Add an operation on the current tree to its metrics history
+ * @param operation "build" or "verify"
+ * @param ok True unless a verification failed
+ * @param summary Throughput figures of the operation
+ */
+void MerkleTree::recordMetrics(const string &operation, bool ok, const ThroughputSummary &summary)
+{
+    OperationMetrics metrics;
+    metrics.time = chrono::system_clock::to_time_t(chrono::system_clock::now());
+    metrics.operation = operation;
+    metrics.ok = ok;
+    metrics.summary = summary;
+    if (!appendMetrics(treeRoot, metrics))
+    {
+        cerr << "Warning: could not record " << operation << " metrics in " << stateDirectory(treeRoot).string() << endl;
+    }
+}
+
+/**
+ * @brief Get the metrics history of the current tree
+ * @return Past builds and verifications, oldest first
+ */
+vector<OperationMetrics> MerkleTree::getMetricsHistory() const
+{
+    return loadMetrics(treeRoot);
 }
 
 /**
@@ -390,6 +1703,114 @@ string MerkleTree::exportToJson() const
     return "{\n" + nodeToJson(root, 1) + "\n}";
 }
 
+/**
+ * @brief Type of a node as the export names it
+ * @param node Node to name the type of
+ * @return "directory", "file", "symlink", "error" or the special file type
+ */
+static string nodeType(const shared_ptr<MerkleNode> &node)
+{
+    return node->isSymlink ? "symlink" : !node->specialType.empty() ? node->specialType : !node->error.empty() ? "error" : node->isFile ? "file" : "directory";
+}
+
+/**
+ * @brief Total size of the files under a directory, hardlinks counted once
+ * @param dir Directory node
+ * @return Size in bytes
+ */
+static size_t filesSize(const shared_ptr<MerkleNode> &dir)
+{
+    size_t size = 0;
+    for (const auto &[name, child] : dir->children)
+    {
+        string type = nodeType(child);
+        if (type == "directory")
+            size += filesSize(child);
+        else if (type == "file" && child->hardlinkOf.empty())
+            size += child->fileSize;
+    }
+    return size;
+}
+
+/**
+ * @brief Describe a node for a directory listing, as one JSON object
+ * @param node Node to describe
+ * @return What the export records of the node, a directory with its entry count and size instead of its children
+ */
+static string listEntryJson(const shared_ptr<MerkleNode> &node)
+{
+    string type = nodeType(node);
+    stringstream ss;
+    ss << "{\"name\": \"" << jsonEscape(node->name) << "\", \"type\": \"" << type << "\", \"hash\": \"" << node->hash << "\"";
+    if (type == "directory")
+    {
+        ss << ", \"entries\": " << node->children.size() << ", \"size\": " << filesSize(node);
+    }
+    else if (type == "file")
+    {
+        ss << ", \"size\": " << node->fileSize << ", \"chunks\": " << node->chunkHashes.size()
+           << ", \"content_hash\": \"" << node->contentHash << "\"";
+        if (!node->hardlinkOf.empty())
+            ss << ", \"hardlink_of\": \"" << jsonEscape(node->hardlinkOf) << "\"";
+    }
+    else if (type == "symlink")
+        ss << ", \"target\": \"" << jsonEscape(node->linkTarget) << "\"";
+    else if (type == "error")
+        ss << ", \"error\": \"" << jsonEscape(node->error) << "\"";
+    else if (!node->deviceNumber.empty())
+        ss << ", \"device\": \"" << node->deviceNumber << "\"";
+
+    if (!node->metadata.empty())
+    {
+        ss << ", \"metadata_hash\": \"" << node->metadataHash << "\", \"metadata\": {";
+        size_t i = 0;
+        for (const auto &[name, value] : node->metadata)
+        {
+            ss << (i++ > 0 ? ", " : "") << "\"" << jsonEscape(name) << "\": \"" << jsonEscape(value) << "\"";
+        }
+        ss << "}";
+    }
+    if (!node->xattrs.empty())
+        ss << ", \"xattrs_hash\": \"" << node->xattrsHash << "\"";
+    ss << "}";
+    return ss.str();
+}
+
+/**
+ * @brief List one directory of the tree as JSON, so it is browsed without exporting the tree
+ * @param path Slash-separated path of the directory, "." for the root
+ * @return JSON object with the directory and its entries in name order, on one line
+ * @throws runtime_error if path is not a directory of the tree
+ */
+string MerkleTree::listDirectoryJson(const string &path) const
+{
+    shared_ptr<MerkleNode> dir = root;
+    if (path != ".")
+    {
+        stringstream parts(path);
+        string name;
+        while (dir && getline(parts, name, '/'))
+        {
+            auto child = dir->children.find(name);
+            dir = nodeType(dir) == "directory" && child != dir->children.end() ? child->second : nullptr;
+        }
+    }
+    if (!dir || nodeType(dir) != "directory")
+    {
+        throw runtime_error(path + " is not a directory of the tree");
+    }
+
+    stringstream ss;
+    ss << "{\"directory\": " << listEntryJson(dir) << ", \"entries\": [";
+    size_t i = 0;
+    for (const auto &[name, child] : dir->children)
+    {
+        ss << (i++ > 0 ? ", " : "") << listEntryJson(child);
+    }
+    ss << "]}";
+    return ss.str();
+}
+
 /**
  * @brief Set custom chunk size for file processing
  * @param chunkSize New chunk size in bytes
@@ -404,6 +1825,34 @@ void MerkleTree::setChunkSize(size_t chunkSize)
     }
 
     CHUNK_SIZE = chunkSize;
+    requestedChunkSize = chunkSize;
+}
+
+/**
+ * @brief Set the number of threads walking directories in a build
+ * @param workers Upper bound for concurrent walker threads (at least 1)
+ */
+void MerkleTree::setWorkers(size_t workers)
+{
+    maxWorkers = max<size_t>(1, workers);
+}
+
+/**
+ * @brief Limit the rate files are read at in the following builds
+ * @param limits Limits for trees whose config sets none
+ */
+void MerkleTree::setIoLimits(const IoLimits &limits)
+{
+    requestedLimits = limits;
+}
+
+/**
+ * @brief Get the I/O limits of the last build
+ * @return Limits in force, after the tree's config applied
+ */
+IoLimits MerkleTree::getIoLimits() const
+{
+    return ioLimits;
 }
 
 /**
@@ -415,6 +1864,171 @@ size_t MerkleTree::getChunkSize() const
     return CHUNK_SIZE;
 }
 
+/**
+ * @brief Get throughput figures of the last build
+ * @return Summary of the last build (zeroed if none)
+ */
+ThroughputSummary MerkleTree::getBuildThroughput() const
+{
+    return buildThroughput;
+}
+
+/**
+ * @brief Get throughput figures of the last verification
+ * @return Summary of the last verification (zeroed if none)
+ */
+ThroughputSummary MerkleTree::getVerifyThroughput() const
+{
+    return verifyThroughput;
+}
+
+/**
+ * @brief Get the number of files reused from a checkpoint in the last build
+ * @return Number of files that did not need hashing
+ */
+size_t MerkleTree::getResumedFiles() const
+{
+    return resumedFiles;
+}
+
+/**
+ * @brief Get the number of unchanged files taken from the hash cache in the last build
+ * @return Number of files that did not need hashing
+ */
+size_t MerkleTree::getCachedFiles() const
+{
+    return cachedFiles;
+}
+
+/**
+ * @brief Get the entries skipped in the last build
+ * @return Number of skipped files and directories by reason
+ */
+map<string, size_t> MerkleTree::getSkippedEntries() const
+{
+    return skippedEntries;
+}
+
+/**
+ * @brief Get hard link figures of the current tree
+ * @return Pair of (paths that are hard links to an earlier path, bytes not counted again)
+ */
+pair<size_t, size_t> MerkleTree::getHardlinkStats() const
+{
+    size_t links = 0, bytes = 0;
+    for (const auto &node : nodes)
+    {
+        if (!node->hardlinkOf.empty())
+        {
+            links++;
+            bytes += node->fileSize;
+        }
+    }
+    return make_pair(links, bytes);
+}
+
+/**
+ * @brief Get the number of special files recorded in the current tree
+ * @return Number of device, socket and FIFO leaves
+ */
+size_t MerkleTree::getSpecialFileCount() const
+{
+    return count_if(nodes.begin(), nodes.end(), [](const shared_ptr<MerkleNode> &node)
+                    { return !node->specialType.empty(); });
+}
+
+/**
+ * @brief Get the symlink cycles found in the last build
+ * @return One "link -> ancestor" description per skipped link
+ */
+vector<string> MerkleTree::getSymlinkCycles() const
+{
+    // Walker threads find cycles in any order
+    vector<string> cycles = symlinkCycles;
+    sort(cycles.begin(), cycles.end());
+    return cycles;
+}
+
+/**
+ * @brief Get the entries the last build could not read
+ * @return Path and reason of every error leaf, by path
+ */
+vector<pair<string, string>> MerkleTree::getUnreadableEntries() const
+{
+    vector<pair<string, string>> entries = unreadablePaths;
+    sort(entries.begin(), entries.end());
+    return entries;
+}
+
+/**
+ * @brief Get the entries skipped in the last build
+ * @return Reason and path of every skipped entry, by path
+ */
+vector<pair<string, string>> MerkleTree::getSkippedPaths() const
+{
+    vector<pair<string, string>> sorted = skippedPaths;
+    sort(sorted.begin(), sorted.end(), [](const auto &a, const auto &b)
+         { return a.second < b.second; });
+    return sorted;
+}
+
+/**
+ * @brief Make the following builds dry runs
+ * @param dryRun True to walk and filter without hashing or recording anything
+ */
+void MerkleTree::setDryRun(bool dryRun)
+{
+    this->dryRun = dryRun;
+    buildMeter = ThroughputMeter(dryRun ? "Scan" : "Build");
+}
+
+/**
+ * @brief Turn the pre-scan of the following builds on or off
+ * @param prescan True to total the files to hash before hashing them (the default)
+ */
+void MerkleTree::setPrescan(bool prescan)
+{
+    this->prescan = prescan;
+}
+
+/**
+ * @brief Turn the hash cache of the following builds on or off
+ * @param hashCache True to take unchanged files from the last build (the default)
+ */
+void MerkleTree::setHashCache(bool hashCache)
+{
+    this->hashCache = hashCache;
+}
+
+/**
+ * @brief Set the walk options for the following builds
+ * @param options Options to apply instead of those recorded with a tree
+ */
+void MerkleTree::setWalkOptions(const WalkOptions &options)
+{
+    walkOptions = options;
+    requestedOptions = options;
+    walkOptionsSet = true;
+}
+
+/**
+ * @brief Get the walk options of the last build
+ * @return Options the current tree was built with
+ */
+const WalkOptions &MerkleTree::getWalkOptions() const
+{
+    return walkOptions;
+}
+
+/**
+ * @brief Get the config file the last build applied
+ * @return Path of the tree's config file, empty if it has none
+ */
+const fs::path &MerkleTree::getConfigFile() const
+{
+    return configFile;
+}
+
 /**
  * @brief Recursive helper for finding nodes
  * @param node Current node to search in
@@ -463,11 +2077,81 @@ string MerkleTree::nodeToJson(shared_ptr<MerkleNode> node, int depth) const
     string childIndent((depth + 1) * 2, ' ');
 
     stringstream ss;
-    ss << indent << "\"" << node->name << "\": {\n";
-    ss << childIndent << "\"type\": \"" << (node->isFile ? "file" : "directory") << "\",\n";
+    ss << indent << "\"" << jsonEscape(node->name) << "\": {\n";
+    ss << childIndent << "\"type\": \"" << (node->isSymlink ? "symlink" : !node->specialType.empty() ? node->specialType : !node->error.empty() ? "error" : node->isFile ? "file" : "directory") << "\",\n";
     ss << childIndent << "\"hash\": \"" << node->hash << "\"";
+    if (node == root && walkOptions.hashFormat != HashFormat::Mtfs)
+    {
+        // The root records the format, so content checks know how to hash
+        ss << ",\n"
+           << childIndent << "\"hash_format\": \"" << hashFormatName(walkOptions.hashFormat) << "\"";
+    }
+    if (node == root && walkOptions.metadata)
+    {
+        // The root records the metadata hashed too, so checks read the same fields
+        ss << ",\n"
+           << childIndent << "\"metadata_fields\": \"" << metadataFieldsName(walkOptions.metadata) << "\"";
+    }
+    if (node == root)
+    {
+        // And the depth and size filters, so verification applies them again
+        for (const auto &[key, limit] : {pair<const char *, size_t>{"max_depth", walkOptions.maxDepth},
+                                         {"min_file_size", walkOptions.minFileSize},
+                                         {"max_file_size", walkOptions.maxFileSize}})
+        {
+            if (limit > 0)
+            {
+                ss << ",\n"
+                   << childIndent << "\"" << key << "\": " << limit;
+            }
+        }
+    }
 
-    if (node->isFile)
+    if (!node->metadata.empty())
+    {
+        ss << ",\n"
+           << childIndent << "\"metadata_hash\": \"" << node->metadataHash << "\",\n"
+           << childIndent << "\"metadata\": {";
+        size_t i = 0;
+        for (const auto &[name, value] : node->metadata)
+        {
+            ss << (i++ > 0 ? ", " : "") << "\"" << name << "\": \"" << value << "\"";
+        }
+        ss << "}";
+    }
+
+    if (!node->xattrs.empty())
+    {
+        ss << ",\n"
+           << childIndent << "\"xattrs_hash\": \"" << node->xattrsHash << "\",\n"
+           << childIndent << "\"xattrs\": {";
+        size_t i = 0;
+        for (const auto &[name, value] : node->xattrs)
+        {
+            ss << (i++ > 0 ? ", " : "") << "\"" << jsonEscape(name) << "\": \"" << hexEncode(value) << "\"";
+        }
+        ss << "}";
+    }
+
+    if (node->isSymlink)
+    {
+        ss << ",\n"
+           << childIndent << "\"target\": \"" << jsonEscape(node->linkTarget) << "\"";
+    }
+    else if (!node->specialType.empty())
+    {
+        if (!node->deviceNumber.empty())
+        {
+            ss << ",\n"
+               << childIndent << "\"device\": \"" << node->deviceNumber << "\"";
+        }
+    }
+    else if (!node->error.empty())
+    {
+        ss << ",\n"
+           << childIndent << "\"error\": \"" << jsonEscape(node->error) << "\"";
+    }
+    else if (node->isFile)
     {
         ss << ",\n"
            << childIndent << "\"size\": " << node->fileSize;
@@ -475,6 +2159,11 @@ string MerkleTree::nodeToJson(shared_ptr<MerkleNode> node, int depth) const
            << childIndent << "\"chunks\": " << node->chunkHashes.size();
         ss << ",\n"
            << childIndent << "\"content_hash\": \"" << node->contentHash << "\"";
+        if (!node->hardlinkOf.empty())
+        {
+            ss << ",\n"
+               << childIndent << "\"hardlink_of\": \"" << jsonEscape(node->hardlinkOf) << "\"";
+        }
     }
     else if (!node->children.empty())
     {
@@ -520,10 +2209,18 @@ void MerkleTree::calculateStatsRecursive(shared_ptr<MerkleNode> node, size_t &fi
         return;
     }
 
+    if (!node->specialType.empty() || !node->error.empty())
+    {
+        return; // Counted separately
+    }
     if (node->isFile)
     {
         files++;
-        totalSize += node->fileSize;
+        // The content of a hard link was counted with the first path
+        if (node->hardlinkOf.empty())
+        {
+            totalSize += node->fileSize;
+        }
     }
     else
     {
@@ -551,7 +2248,7 @@ bool MerkleTree::verifyNodeIntegrity(shared_ptr<MerkleNode> node)
     string originalHash = node->hash;
 
     // Recalculate hash
-    string calculatedHash = node->calculateHash();
+    string calculatedHash = node->calculateHash(walkOptions.hashFormat);
 
     // Verify hash matches
     if (originalHash != calculatedHash)
@@ -559,6 +2256,11 @@ bool MerkleTree::verifyNodeIntegrity(shared_ptr<MerkleNode> node)
         return false;
     }
 
+    if (node->isFile)
+    {
+        verifyMeter.record(node->fileSize, 1);
+    }
+
     // Verify all children recursively
     for (const auto &child : node->children)
     {
@@ -0,0 +1,108 @@
+#include "merkle.hpp"
+
+// Names of the metadata fields, in the order they are listed
+static const vector<pair<MetadataField, string>> metadataFieldNames = {
+    {MetadataMode, "mode"},
+    {MetadataOwner, "owner"},
+    {MetadataMtime, "mtime"},
+};
+
+/**
+ * @brief Parse a list of metadata fields
+ * @param list Comma-separated "mode", "owner", "mtime" and "xattrs", or "none"
+ * @param fields Receives the MetadataField bits
+ * @param xattrs Set if the list names extended attributes, which --xattrs hashes
+ * @return True if every name is known
+ */
+bool parseMetadataFields(const string &list, unsigned &fields, bool &xattrs)
+{
+    unsigned parsed = 0;
+    bool withXattrs = false;
+    if (list != "none")
+    {
+        istringstream in(list);
+        for (string name; getline(in, name, ',');)
+        {
+            if (name == "xattrs")
+            {
+                withXattrs = true;
+                continue;
+            }
+            auto field = find_if(metadataFieldNames.begin(), metadataFieldNames.end(), [&](const auto &known)
+                                 { return known.second == name; });
+            if (field == metadataFieldNames.end())
+            {
+                return false;
+            }
+            parsed |= field->first;
+        }
+    }
+    fields = parsed;
+    xattrs = xattrs || withXattrs;
+    return true;
+}
+
+/**
+ * @brief Get the names of metadata fields
+ * @param fields MetadataField bits
+ * @return Comma-separated names in a fixed order, "none" if there are none
+ */
+string metadataFieldsName(unsigned fields)
+{
+    string names;
+    for (const auto &[field, name] : metadataFieldNames)
+    {
+        if (fields & field)
+        {
+            names += (names.empty() ? "" : ",") + name;
+        }
+    }
+    return names.empty() ? "none" : names;
+}
+
+/**
+ * @brief Collect the metadata of an entry that is hashed
+ * @param fields MetadataField bits to collect
+ * @param mode Mode of the entry; only permission bits are kept
+ * @param uid Owning user id
+ * @param gid Owning group id
+ * @param mtime Modification time in seconds since the epoch
+ * @return "mode" (4 octal digits), "owner" ("uid:gid") and "mtime" values by name
+ *
+ * Owners are numeric, as names differ from one system to the next, and
+ * times are whole seconds, as archives and copies keep no more.
+ */
+map<string, string> entryMetadata(unsigned fields, unsigned mode, long long uid, long long gid, long long mtime)
+{
+    map<string, string> metadata;
+    if (fields & MetadataMode)
+    {
+        ostringstream octal;
+        octal << oct << setw(4) << setfill('0') << (mode & 07777);
+        metadata["mode"] = octal.str();
+    }
+    if (fields & MetadataOwner)
+    {
+        metadata["owner"] = to_string(uid) + ":" + to_string(gid);
+    }
+    if (fields & MetadataMtime)
+    {
+        metadata["mtime"] = to_string(mtime);
+    }
+    return metadata;
+}
+
+/**
+ * @brief Serialize metadata in the canonical form that is hashed
+ * @param metadata Values by name
+ * @return One "name=value" line per field in name order
+ */
+string serializeMetadata(const map<string, string> &metadata)
+{
+    string serialized;
+    for (const auto &[name, value] : metadata)
+    {
+        serialized += name + "=" + value + "\n";
+    }
+    return serialized;
+}
@@ -0,0 +1,177 @@
+#include "merkle.hpp"
+
+/**
+ * @brief Add the figures of an operation to the metrics history of a tree
+ *
+ * The history is kept in METRICS_FILE in the state directory and holds
+ * the last METRICS_HISTORY operations.
+ *
+ * @param treeRoot Root directory of the tree
+ * @param metrics Figures of the operation
+ * @return True if the history was written
+ */
+bool appendMetrics(const fs::path &treeRoot, const OperationMetrics &metrics)
+{
+    vector<OperationMetrics> history = loadMetrics(treeRoot);
+    history.push_back(metrics);
+    if (history.size() > MTFSConstants::METRICS_HISTORY)
+    {
+        history.erase(history.begin(), history.end() - MTFSConstants::METRICS_HISTORY);
+    }
+
+    fs::path stateDir = stateDirectory(treeRoot);
+    error_code ec;
+    fs::create_directories(stateDir, ec);
+    fs::path file = stateDir / MTFSConstants::METRICS_FILE;
+    fs::path temp = file.string() + ".tmp";
+    {
+        ofstream out(temp, ios::trunc);
+        if (!out.is_open())
+        {
+            return false;
+        }
+        out << "MTFS-METRICS 1\n";
+        for (const auto &m : history)
+        {
+            const ThroughputSummary &s = m.summary;
+            out << m.time << "\t" << m.operation << "\t" << (m.ok ? "ok" : "failed") << "\t"
+                << s.seconds << "\t" << s.bytes << "\t" << s.files << "\t"
+                << s.avgBytesPerSec << "\t" << s.avgFilesPerSec << "\t"
+                << s.peakBytesPerSec << "\t" << s.peakFilesPerSec << "\n";
+        }
+        if (!out.good())
+        {
+            return false;
+        }
+    }
+    fs::rename(temp, file, ec);
+    return !ec;
+}
+
+/**
+ * @brief Read the metrics history of a tree
+ * @param treeRoot Root directory of the tree
+ * @return Past operations, oldest first (empty if there is no history)
+ */
+vector<OperationMetrics> loadMetrics(const fs::path &treeRoot)
+{
+    vector<OperationMetrics> history;
+    ifstream in(stateDirectory(treeRoot) / MTFSConstants::METRICS_FILE);
+    string line;
+    if (!in.is_open() || !getline(in, line) || line != "MTFS-METRICS 1")
+    {
+        return history;
+    }
+
+    while (getline(in, line))
+    {
+        istringstream fields(line);
+        OperationMetrics m;
+        string result;
+        ThroughputSummary &s = m.summary;
+        if (fields >> m.time >> m.operation >> result >> s.seconds >> s.bytes >> s.files >>
+            s.avgBytesPerSec >> s.avgFilesPerSec >> s.peakBytesPerSec >> s.peakFilesPerSec)
+        {
+            m.ok = result == "ok";
+            history.push_back(m);
+        }
+    }
+    return history;
+}
+
+/**
+ * @brief Draw values as a line of block characters scaled to their range
+ * @param values Values to draw, oldest first
+ * @return One block per value, higher blocks for larger values
+ */
+static string sparkline(const vector<double> &values)
+{
+    static const char *blocks[] = {"▁", "▂", "▃", "▄", "▅", "▆", "▇", "█"};
+    auto [lo, hi] = minmax_element(values.begin(), values.end());
+    string line;
+    for (double v : values)
+    {
+        size_t level = *hi > *lo ? static_cast<size_t>((v - *lo) / (*hi - *lo) * 7 + 0.5) : 3;
+        line += blocks[level];
+    }
+    return line;
+}
+
+/**
+ * @brief Format a duration in seconds with a precision suited to its size
+ * @param seconds Duration to format
+ * @return Formatted duration (e.g., "42.0 ms", "12.5 s")
+ */
+static string formatSeconds(double seconds)
+{
+    ostringstream oss;
+    if (seconds < 1)
+    {
+        oss << fixed << setprecision(1) << seconds * 1000 << " ms";
+    }
+    else
+    {
+        oss << fixed << setprecision(seconds < 100 ? 1 : 0) << seconds << " s";
+    }
+    return oss.str();
+}
+
+/**
+ * @brief Summarize how an operation developed over its recent runs
+ * @param history Metrics history of a tree, oldest first
+ * @param operation "build" or "verify"
+ * @param runs Number of most recent runs to consider
+ * @return Lines with duration and throughput ranges and sparklines (empty without runs)
+ */
+vector<string> describeTrend(const vector<OperationMetrics> &history, const string &operation, size_t runs)
+{
+    vector<const OperationMetrics *> recent;
+    for (auto it = history.rbegin(); it != history.rend() && recent.size() < runs; ++it)
+    {
+        if (it->operation == operation)
+        {
+            recent.insert(recent.begin(), &*it);
+        }
+    }
+    if (recent.empty())
+    {
+        return {};
+    }
+
+    vector<double> seconds, rates;
+    size_t failed = 0;
+    for (const auto *m : recent)
+    {
+        seconds.push_back(m->summary.seconds);
+        rates.push_back(m->summary.avgBytesPerSec);
+        failed += m->ok ? 0 : 1;
+    }
+    double total = 0;
+    for (double s : seconds)
+    {
+        total += s;
+    }
+    double avg = total / seconds.size();
+    auto [lo, hi] = minmax_element(seconds.begin(), seconds.end());
+
+    string label = operation;
+    label[0] = static_cast<char>(toupper(static_cast<unsigned char>(label[0])));
+    ostringstream summary;
+    summary << label << " history: last " << recent.size() << (recent.size() == 1 ? " run" : " runs")
+            << ", time min " << formatSeconds(*lo) << ", avg " << formatSeconds(avg)
+            << ", max " << formatSeconds(*hi) << ", last " << formatSeconds(seconds.back());
+    if (failed > 0)
+    {
+        summary << ", " << failed << " failed";
+    }
+
+    vector<string> lines = {summary.str()};
+    if (recent.size() > 1)
+    {
+        lines.push_back(label + " time trend: " + sparkline(seconds) + " (oldest to newest)");
+        lines.push_back(label + " throughput trend: " + sparkline(rates) + " (" +
+                        formatFileSize(static_cast<size_t>(rates.front())) + "/s to " +
+                        formatFileSize(static_cast<size_t>(rates.back())) + "/s)");
+    }
+    return lines;
+}
@@ -0,0 +1,170 @@
+#include "merkle.hpp"
+#include <unicode/normalizer2.h>
+#include <unicode/unistr.h>
+#include <unicode/utf8.h>
+#include <unicode/uchar.h>
+
+/**
+ * @brief Parse a name normalization form
+ * @param name "none", "nfc" or "nfd"
+ * @param form Receives the parsed form
+ * @return True if the name is known
+ */
+bool parseNameNormalization(const string &name, NameNormalization &form)
+{
+    if (name == "none")
+    {
+        form = NameNormalization::None;
+    }
+    else if (name == "nfc")
+    {
+        form = NameNormalization::NFC;
+    }
+    else if (name == "nfd")
+    {
+        form = NameNormalization::NFD;
+    }
+    else
+    {
+        return false;
+    }
+    return true;
+}
+
+/**
+ * @brief Get the name of a normalization form
+ * @param form Form to name
+ * @return Name accepted by parseNameNormalization
+ */
+string nameNormalizationName(NameNormalization form)
+{
+    switch (form)
+    {
+    case NameNormalization::NFC:
+        return "nfc";
+    case NameNormalization::NFD:
+        return "nfd";
+    default:
+        return "none";
+    }
+}
+
+// Normalize a run of valid UTF-8
+static string normalizeRun(const string &run, NameNormalization form)
+{
+    UErrorCode status = U_ZERO_ERROR;
+    const icu::Normalizer2 *normalizer = form == NameNormalization::NFC ? icu::Normalizer2::getNFCInstance(status)
+                                                                        : icu::Normalizer2::getNFDInstance(status);
+    if (U_FAILURE(status))
+    {
+        throw runtime_error("Unicode normalizer unavailable: " + string(u_errorName(status)));
+    }
+    icu::UnicodeString normalized = normalizer->normalize(icu::UnicodeString::fromUTF8(run), status);
+    if (U_FAILURE(status))
+    {
+        throw runtime_error("Cannot normalize name: " + string(u_errorName(status)));
+    }
+    string out;
+    normalized.toUTF8String(out);
+    return out;
+}
+
+/**
+ * @brief Get the canonical form of a file name
+ * @param raw Name as stored by the file system
+ * @param form Normalization applied to valid UTF-8
+ * @return The raw name for NameNormalization::None; otherwise the normalized
+ *         name with bytes that are not valid UTF-8 written as "\xHH" and
+ *         backslashes doubled, so distinct raw names stay distinct
+ */
+string canonicalName(const string &raw, NameNormalization form)
+{
+    if (form == NameNormalization::None)
+    {
+        return raw;
+    }
+
+    string out;
+    string run;
+    const uint8_t *bytes = reinterpret_cast<const uint8_t *>(raw.data());
+    int32_t length = static_cast<int32_t>(raw.size());
+    for (int32_t i = 0; i < length;)
+    {
+        int32_t start = i;
+        UChar32 c;
+        U8_NEXT(bytes, i, length, c);
+        if (c < 0)
+        {
+            out += normalizeRun(run, form);
+            run.clear();
+            for (int32_t j = start; j < i; ++j)
+            {
+                char escaped[5];
+                snprintf(escaped, sizeof(escaped), "\\x%02x", bytes[j]);
+                out += escaped;
+            }
+        }
+        else if (c == '\\')
+        {
+            run += "\\\\";
+        }
+        else
+        {
+            run.append(raw, start, i - start);
+        }
+    }
+    return out + normalizeRun(run, form);
+}
+
+/**
+ * @brief Get the key under which names collide on a case-insensitive file system
+ * @param name Name as returned by canonicalName
+ * @return The name with every character case-folded, bytes that are not
+ *         valid UTF-8 written as "\xHH" and backslashes doubled
+ */
+string caseFoldedName(const string &name)
+{
+    string out;
+    const uint8_t *bytes = reinterpret_cast<const uint8_t *>(name.data());
+    int32_t length = static_cast<int32_t>(name.size());
+    for (int32_t i = 0; i < length;)
+    {
+        int32_t start = i;
+        UChar32 c;
+        U8_NEXT(bytes, i, length, c);
+        if (c < 0)
+        {
+            for (int32_t j = start; j < i; ++j)
+            {
+                char escaped[5];
+                snprintf(escaped, sizeof(escaped), "\\x%02x", bytes[j]);
+                out += escaped;
+            }
+            continue;
+        }
+        if (c == '\\')
+        {
+            out += "\\\\";
+            continue;
+        }
+        // Simple folding maps one character to one, as NTFS and APFS compare names
+        UChar32 folded = u_foldCase(c, U_FOLD_CASE_DEFAULT);
+        char encoded[U8_MAX_LENGTH];
+        int32_t n = 0;
+        U8_APPEND_UNSAFE(encoded, n, folded);
+        out.append(encoded, n);
+    }
+    return out;
+}
+
+/**
+ * @brief Check whether a name is one macOS keeps its metadata under
+ * @param name File or directory name
+ * @return True for AppleDouble files ("._name", holding the resource fork
+ *         and Finder info on file systems without attributes), .DS_Store
+ *         and the __MACOSX directory of zip archives
+ */
+bool isAppleMetadataName(const string &name)
+{
+    return name.rfind("._", 0) == 0 || name == ".DS_Store" || name == "__MACOSX";
+}
@@ -0,0 +1,628 @@
+#include "merkle.hpp"
+
+/**
+ * @brief Match a scope pattern against a path
+ * @param pattern Pattern; anchored to the tree root if it contains a slash
+ * @param relPath Path relative to the tree root
+ * @return True if the pattern matches
+ */
+static bool scopeMatch(const string &pattern, const string &relPath)
+{
+    if (pattern.find('/') != string::npos)
+    {
+        return globMatch(pattern[0] == '/' ? pattern.substr(1) : pattern, relPath);
+    }
+    size_t slash = relPath.rfind('/');
+    return globMatch(pattern, slash == string::npos ? relPath : relPath.substr(slash + 1));
+}
+
+/**
+ * @brief Parse a symlink policy name
+ * @param name "follow", "record" or "skip"
+ * @param policy Receives the parsed policy
+ * @return True if the name is known
+ */
+bool parseSymlinkPolicy(const string &name, SymlinkPolicy &policy)
+{
+    if (name == "follow")
+    {
+        policy = SymlinkPolicy::Follow;
+    }
+    else if (name == "record")
+    {
+        policy = SymlinkPolicy::Record;
+    }
+    else if (name == "skip")
+    {
+        policy = SymlinkPolicy::Skip;
+    }
+    else
+    {
+        return false;
+    }
+    return true;
+}
+
+/**
+ * @brief Get the name of a symlink policy
+ * @param policy Policy to name
+ * @return Name accepted by parseSymlinkPolicy
+ */
+string symlinkPolicyName(SymlinkPolicy policy)
+{
+    switch (policy)
+    {
+    case SymlinkPolicy::Record:
+        return "record";
+    case SymlinkPolicy::Skip:
+        return "skip";
+    default:
+        return "follow";
+    }
+}
+
+/**
+ * @brief Parse a special file policy name
+ * @param name "skip", "record" or "error"
+ * @param policy Receives the parsed policy
+ * @return True if the name is known
+ */
+bool parseSpecialFilePolicy(const string &name, SpecialFilePolicy &policy)
+{
+    if (name == "skip")
+    {
+        policy = SpecialFilePolicy::Skip;
+    }
+    else if (name == "record")
+    {
+        policy = SpecialFilePolicy::Record;
+    }
+    else if (name == "error")
+    {
+        policy = SpecialFilePolicy::Error;
+    }
+    else
+    {
+        return false;
+    }
+    return true;
+}
+
+/**
+ * @brief Get the name of a special file policy
+ * @param policy Policy to name
+ * @return Name accepted by parseSpecialFilePolicy
+ */
+string specialFilePolicyName(SpecialFilePolicy policy)
+{
+    switch (policy)
+    {
+    case SpecialFilePolicy::Record:
+        return "record";
+    case SpecialFilePolicy::Error:
+        return "error";
+    default:
+        return "skip";
+    }
+}
+
+/**
+ * @brief Check whether a path matches an include pattern
+ * @param relPath Path relative to the tree root
+ * @return True if there are no include patterns or one matches
+ */
+bool WalkOptions::isIncluded(const string &relPath) const
+{
+    if (includes.empty())
+    {
+        return true;
+    }
+    for (const auto &pattern : includes)
+    {
+        if (scopeMatch(pattern, relPath))
+        {
+            return true;
+        }
+    }
+    return false;
+}
+
+/**
+ * @brief Check whether a path matches an exclude pattern
+ * @param relPath Path relative to the tree root
+ * @return True if any exclude pattern matches
+ */
+bool WalkOptions::isExcluded(const string &relPath) const
+{
+    for (const auto &pattern : excludes)
+    {
+        if (scopeMatch(pattern, relPath))
+        {
+            return true;
+        }
+    }
+    return false;
+}
+
+/**
+ * @brief Check a file size against the size limits
+ * @param size Size of a file in bytes
+ * @return "min file size" or "max file size" if the file is not hashed, nullptr if it is
+ */
+const char *WalkOptions::sizeLimit(size_t size) const
+{
+    if (minFileSize > 0 && size < minFileSize)
+    {
+        return "min file size";
+    }
+    if (maxFileSize > 0 && size > maxFileSize)
+    {
+        return "max file size";
+    }
+    return nullptr;
+}
+
+/**
+ * @brief Load the options recorded for a tree
+ * @param treeRoot Root directory of the tree
+ * @return True if recorded options were found
+ */
+bool WalkOptions::load(const fs::path &treeRoot)
+{
+    ifstream in(stateDirectory(treeRoot) / MTFSConstants::OPTIONS_FILE);
+    string header;
+    if (!in.is_open() || !getline(in, header) || header != "MTFS-OPTIONS 1")
+    {
+        return false;
+    }
+
+    *this = WalkOptions();
+    // Trees recorded before the setting hashed macOS metadata like any file
+    appleMetadata = true;
+    string line;
+    while (getline(in, line))
+    {
+        size_t tab = line.find('\t');
+        if (tab == string::npos)
+        {
+            continue;
+        }
+        string key = line.substr(0, tab);
+        string value = unescapeField(line.substr(tab + 1));
+        if (key == "include")
+        {
+            includes.push_back(value);
+        }
+        else if (key == "exclude")
+        {
+            excludes.push_back(value);
+        }
+        else if (key == "symlinks")
+        {
+            parseSymlinkPolicy(value, symlinks);
+        }
+        else if (key == "hidden")
+        {
+            hidden = value != "skip";
+        }
+        else if (key == "apple_metadata")
+        {
+            appleMetadata = value != "skip";
+        }
+        else if (key == "max_depth")
+        {
+            istringstream(value) >> maxDepth;
+        }
+        else if (key == "min_file_size")
+        {
+            istringstream(value) >> minFileSize;
+        }
+        else if (key == "max_file_size")
+        {
+            istringstream(value) >> maxFileSize;
+        }
+        else if (key == "one_file_system")
+        {
+            oneFileSystem = value == "1";
+        }
+        else if (key == "xattrs")
+        {
+            xattrs = value == "1";
+        }
+        else if (key == "metadata")
+        {
+            bool listed = false;
+            parseMetadataFields(value, metadata, listed);
+        }
+        else if (key == "special_files")
+        {
+            parseSpecialFilePolicy(value, specialFiles);
+        }
+        else if (key == "normalize")
+        {
+            parseNameNormalization(value, normalization);
+        }
+        else if (key == "case_insensitive")
+        {
+            caseInsensitive = value == "1";
+        }
+        else if (key == "hash_format")
+        {
+            parseHashFormat(value, hashFormat);
+        }
+    }
+    return true;
+}
+
+/**
+ * @brief Record the options in the state directory of a tree
+ * @param treeRoot Root directory of the tree
+ * @return True if the options were written
+ */
+bool WalkOptions::save(const fs::path &treeRoot) const
+{
+    fs::path stateDir = stateDirectory(treeRoot);
+    error_code ec;
+    fs::create_directories(stateDir, ec);
+    ofstream out(stateDir / MTFSConstants::OPTIONS_FILE, ios::trunc);
+    if (!out.is_open())
+    {
+        return false;
+    }
+
+    out << "MTFS-OPTIONS 1\n";
+    for (const auto &pattern : includes)
+    {
+        out << "include\t" << escapeField(pattern) << "\n";
+    }
+    for (const auto &pattern : excludes)
+    {
+        out << "exclude\t" << escapeField(pattern) << "\n";
+    }
+    out << "symlinks\t" << symlinkPolicyName(symlinks) << "\n";
+    out << "hidden\t" << (hidden ? "include" : "skip") << "\n";
+    out << "apple_metadata\t" << (appleMetadata ? "include" : "skip") << "\n";
+    out << "max_depth\t" << maxDepth << "\n";
+    out << "min_file_size\t" << minFileSize << "\n";
+    out << "max_file_size\t" << maxFileSize << "\n";
+    out << "one_file_system\t" << (oneFileSystem ? 1 : 0) << "\n";
+    out << "xattrs\t" << (xattrs ? 1 : 0) << "\n";
+    out << "metadata\t" << metadataFieldsName(metadata) << "\n";
+    out << "special_files\t" << specialFilePolicyName(specialFiles) << "\n";
+    out << "normalize\t" << nameNormalizationName(normalization) << "\n";
+    out << "case_insensitive\t" << (caseInsensitive ? 1 : 0) << "\n";
+    out << "hash_format\t" << hashFormatName(hashFormat) << "\n";
+    return out.good();
+}
+
+/**
+ * @brief Describe the options in one line
+ * @return Human-readable summary, "default" if nothing is restricted
+ */
+string WalkOptions::describe() const
+{
+    ostringstream oss;
+    for (const auto &pattern : includes)
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << "include " << pattern;
+    }
+    for (const auto &pattern : excludes)
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << "exclude " << pattern;
+    }
+    if (symlinks != SymlinkPolicy::Follow)
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << "symlinks " << symlinkPolicyName(symlinks);
+    }
+    if (!hidden)
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << "skip hidden";
+    }
+    if (appleMetadata)
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << "apple metadata";
+    }
+    if (maxDepth > 0)
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << "max depth " << maxDepth;
+    }
+    if (minFileSize > 0)
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << "min file size " << formatFileSize(minFileSize);
+    }
+    if (maxFileSize > 0)
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << "max file size " << formatFileSize(maxFileSize);
+    }
+    if (oneFileSystem)
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << "one file system";
+    }
+    if (metadata)
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << "metadata " << metadataFieldsName(metadata);
+    }
+    if (xattrs)
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << "xattrs";
+    }
+    if (specialFiles != SpecialFilePolicy::Skip)
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << "special files " << specialFilePolicyName(specialFiles);
+    }
+    if (normalization != NameNormalization::None)
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << "names " << nameNormalizationName(normalization);
+    }
+    if (caseInsensitive)
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << "case-insensitive names";
+    }
+    if (isGitFormat(hashFormat))
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << hashFormatName(hashFormat) << " objects";
+    }
+    else if (hashFormat != HashFormat::Mtfs)
+    {
+        oss << (oss.tellp() > 0 ? ", " : "") << hashFormatName(hashFormat) << " hashes";
+    }
+    return oss.tellp() > 0 ? oss.str() : "default";
+}
+
+/**
+ * @brief Parse a yes/no setting of a config file
+ * @param value "true", "yes", "on" or "1", or "false", "no", "off" or "0"
+ * @param flag Receives the parsed setting
+ * @return True if the value is known
+ */
+static bool parseFlag(const string &value, bool &flag)
+{
+    if (value == "true" || value == "yes" || value == "on" || value == "1")
+    {
+        flag = true;
+    }
+    else if (value == "false" || value == "no" || value == "off" || value == "0")
+    {
+        flag = false;
+    }
+    else
+    {
+        return false;
+    }
+    return true;
+}
+
+/**
+ * @brief Remove leading and trailing blanks
+ * @param text Text to trim
+ * @return Text without surrounding spaces and tabs
+ */
+static string trimmed(const string &text)
+{
+    size_t begin = text.find_first_not_of(" \t\r");
+    if (begin == string::npos)
+    {
+        return "";
+    }
+    return text.substr(begin, text.find_last_not_of(" \t\r") - begin + 1);
+}
+
+/**
+ * @brief Read the config file of a tree
+ *
+ * The file holds "key = value" lines; blank lines and lines starting with
+ * '#' are ignored. include and exclude may be repeated and replace the
+ * global patterns, every other key may appear once.
+ *
+ * @param treeRoot Root directory of the tree
+ * @return True if the tree has a config file
+ * @throws runtime_error If the file has an unknown key or an invalid value
+ */
+bool TreeConfig::load(const fs::path &treeRoot)
+{
+    *this = TreeConfig();
+    path = stateDirectory(treeRoot) / MTFSConstants::CONFIG_FILE;
+    ifstream in(path);
+    if (!in.is_open())
+    {
+        return false;
+    }
+
+    set<string> seen;
+    string line;
+    for (size_t number = 1; getline(in, line); ++number)
+    {
+        line = trimmed(line);
+        if (line.empty() || line[0] == '#')
+        {
+            continue;
+        }
+        string where = path.string() + ":" + to_string(number) + ": ";
+        size_t eq = line.find('=');
+        if (eq == string::npos)
+        {
+            throw runtime_error(where + "expected \"key = value\"");
+        }
+        string key = trimmed(line.substr(0, eq));
+        string value = trimmed(line.substr(eq + 1));
+        if (key != "include" && key != "exclude" && !seen.insert(key).second)
+        {
+            throw runtime_error(where + key + " is set twice");
+        }
+
+        bool valid = true;
+        if (key == "include")
+        {
+            includesSet = true;
+            if (!value.empty())
+            {
+                includes.push_back(value);
+            }
+        }
+        else if (key == "exclude")
+        {
+            excludesSet = true;
+            if (!value.empty())
+            {
+                excludes.push_back(value);
+            }
+        }
+        else if (key == "symlinks")
+        {
+            SymlinkPolicy policy;
+            valid = parseSymlinkPolicy(value, policy);
+            symlinks = policy;
+        }
+        else if (key == "hidden")
+        {
+            bool flag = value == "include";
+            valid = flag || value == "skip" || parseFlag(value, flag);
+            hidden = flag;
+        }
+        else if (key == "apple_metadata")
+        {
+            bool flag = value == "include";
+            valid = flag || value == "skip" || parseFlag(value, flag);
+            appleMetadata = flag;
+        }
+        else if (key == "max_depth")
+        {
+            size_t depth = 0;
+            valid = !value.empty() && value.find_first_not_of("0123456789") == string::npos;
+            if (valid)
+            {
+                istringstream(value) >> depth;
+            }
+            maxDepth = depth;
+        }
+        else if (key == "min_file_size" || key == "max_file_size")
+        {
+            size_t bytes = 0;
+            valid = value == "0" || parseFileSize(value, bytes);
+            (key == "min_file_size" ? minFileSize : maxFileSize) = bytes;
+        }
+        else if (key == "one_file_system")
+        {
+            bool flag = false;
+            valid = parseFlag(value, flag);
+            oneFileSystem = flag;
+        }
+        else if (key == "xattrs")
+        {
+            bool flag = false;
+            valid = parseFlag(value, flag);
+            xattrs = flag;
+        }
+        else if (key == "metadata")
+        {
+            unsigned fields = 0;
+            bool listed = false;
+            valid = parseMetadataFields(value, fields, listed);
+            metadata = fields;
+            if (listed)
+            {
+                xattrs = true;
+            }
+        }
+        else if (key == "special_files")
+        {
+            SpecialFilePolicy policy;
+            valid = parseSpecialFilePolicy(value, policy);
+            specialFiles = policy;
+        }
+        else if (key == "normalize")
+        {
+            NameNormalization form;
+            valid = parseNameNormalization(value, form);
+            normalization = form;
+        }
+        else if (key == "case_insensitive")
+        {
+            bool flag = false;
+            valid = parseFlag(value, flag);
+            caseInsensitive = flag;
+        }
+        else if (key == "hash_format")
+        {
+            HashFormat format;
+            valid = parseHashFormat(value, format);
+            hashFormat = format;
+        }
+        else if (key == "chunk_size")
+        {
+            size_t bytes = 0;
+            valid = parseFileSize(value, bytes) && bytes >= MTFSConstants::MIN_CHUNK_SIZE &&
+                    bytes <= MTFSConstants::MAX_CHUNK_SIZE;
+            chunkSize = bytes;
+        }
+        else if (key == "io_limit")
+        {
+            size_t bytes = 0;
+            valid = value == "0" || parseFileSize(value, bytes);
+            ioLimit = bytes;
+        }
+        else if (key == "iops_limit")
+        {
+            size_t reads = 0;
+            valid = !value.empty() && value.size() <= 15 && value.find_first_not_of("0123456789") == string::npos;
+            if (valid)
+            {
+                istringstream(value) >> reads;
+            }
+            iopsLimit = reads;
+        }
+        else if (key == "max_size" || key == "max_growth_per_day")
+        {
+            // Quotas are checked by the frontend after each build
+            size_t bytes = 0;
+            valid = parseFileSize(value, bytes);
+        }
+        else if (key == "max_files")
+        {
+            valid = !value.empty() && value.size() <= 15 && value.find_first_not_of("0123456789") == string::npos;
+        }
+        else
+        {
+            throw runtime_error(where + "unknown key " + key);
+        }
+        if (!valid)
+        {
+            throw runtime_error(where + "invalid " + key + " \"" + value + "\"");
+        }
+    }
+    return true;
+}
+
+/**
+ * @brief Override global settings with those of the file
+ * @param options Walk options to override
+ * @param chunk Chunk size to override
+ * @param limits I/O limits to override
+ */
+void TreeConfig::apply(WalkOptions &options, size_t &chunk, IoLimits &limits) const
+{
+    if (includesSet)
+    {
+        options.includes = includes;
+    }
+    if (excludesSet)
+    {
+        options.excludes = excludes;
+    }
+    options.symlinks = symlinks.value_or(options.symlinks);
+    options.hidden = hidden.value_or(options.hidden);
+    options.appleMetadata = appleMetadata.value_or(options.appleMetadata);
+    options.maxDepth = maxDepth.value_or(options.maxDepth);
+    options.minFileSize = minFileSize.value_or(options.minFileSize);
+    options.maxFileSize = maxFileSize.value_or(options.maxFileSize);
+    options.oneFileSystem = oneFileSystem.value_or(options.oneFileSystem);
+    options.xattrs = xattrs.value_or(options.xattrs);
+    options.metadata = metadata.value_or(options.metadata);
+    options.specialFiles = specialFiles.value_or(options.specialFiles);
+    options.normalization = normalization.value_or(options.normalization);
+    options.caseInsensitive = caseInsensitive.value_or(options.caseInsensitive);
+    options.hashFormat = hashFormat.value_or(options.hashFormat);
+    chunk = chunkSize.value_or(chunk);
+    limits.bytesPerSecond = ioLimit.value_or(limits.bytesPerSecond);
+    limits.readsPerSecond = iopsLimit.value_or(limits.readsPerSecond);
+}
@@ -0,0 +1,137 @@
+#include "merkle.hpp"
+#include <cerrno>
+#include <cstring>
+#include <sys/resource.h>
+#ifdef __linux__
+#include <sys/syscall.h>
+#include <unistd.h>
+#endif
+
+/**
+ * @brief Check whether any limit is set
+ * @return True if reads are throttled
+ */
+bool IoLimits::active() const
+{
+    return bytesPerSecond > 0 || readsPerSecond > 0;
+}
+
+/**
+ * @brief Describe the limits for the build report
+ * @return Text such as "10 MB/s, 200 reads/s"
+ */
+string IoLimits::describe() const
+{
+    vector<string> parts;
+    if (bytesPerSecond > 0)
+    {
+        parts.push_back(formatFileSize(bytesPerSecond) + "/s");
+    }
+    if (readsPerSecond > 0)
+    {
+        parts.push_back(to_string(readsPerSecond) + " reads/s");
+    }
+    string text;
+    for (const auto &part : parts)
+    {
+        text += (text.empty() ? "" : ", ") + part;
+    }
+    return text.empty() ? "none" : text;
+}
+
+/**
+ * @brief Set the limits of the following reads
+ * @param limits Limits to apply; no limits turn throttling off
+ */
+void Throttle::configure(const IoLimits &limits)
+{
+    lock_guard<mutex> lock(scheduleMutex);
+    this->limits = limits;
+    next = Clock::now();
+}
+
+/**
+ * @brief Account for a read, waiting until the limits allow it
+ * @param bytes Number of bytes the read returned
+ */
+void Throttle::acquire(size_t bytes)
+{
+    Clock::time_point slot;
+    {
+        lock_guard<mutex> lock(scheduleMutex);
+        if (!limits.active())
+        {
+            return;
+        }
+        // The read takes as long as the slower of the two limits allows
+        double seconds = 0;
+        if (limits.bytesPerSecond > 0)
+        {
+            seconds = static_cast<double>(bytes) / limits.bytesPerSecond;
+        }
+        if (limits.readsPerSecond > 0)
+        {
+            seconds = max(seconds, 1.0 / limits.readsPerSecond);
+        }
+        // Time left unused while idle is not saved up for a burst
+        slot = max(next, Clock::now());
+        next = slot + chrono::duration_cast<Clock::duration>(chrono::duration<double>(seconds));
+    }
+    this_thread::sleep_until(slot);
+}
+
+/**
+ * @brief Lower the CPU and I/O priority of the backend
+ * @param niceness CPU niceness from 0 (normal) to 19 (lowest)
+ * @param ioClass "idle", "best-effort" or "best-effort:N" with N from 0 to 7, empty to keep the I/O class
+ * @throws invalid_argument If a value is out of range
+ * @throws runtime_error If the kernel refuses the priority
+ */
+void lowerPriority(optional<int> niceness, const string &ioClass)
+{
+    if (niceness)
+    {
+        if (*niceness < 0 || *niceness > 19)
+        {
+            throw invalid_argument("niceness must be from 0 to 19");
+        }
+        if (setpriority(PRIO_PROCESS, 0, *niceness) != 0)
+        {
+            throw runtime_error(string("cannot set niceness: ") + strerror(errno));
+        }
+    }
+    if (ioClass.empty())
+    {
+        return;
+    }
+
+    // The classes and layout of ioprio_set(2)
+    const int classIdle = 3, classBestEffort = 2, classShift = 13;
+    int priority;
+    if (ioClass == "idle")
+    {
+        priority = classIdle << classShift;
+    }
+    else if (ioClass == "best-effort")
+    {
+        priority = (classBestEffort << classShift) | 4;
+    }
+    else if (ioClass.size() == 13 && ioClass.compare(0, 12, "best-effort:") == 0 && ioClass[12] >= '0' && ioClass[12] <= '7')
+    {
+        priority = (classBestEffort << classShift) | (ioClass[12] - '0');
+    }
+    else
+    {
+        throw invalid_argument("I/O class must be idle, best-effort or best-effort:0 to best-effort:7");
+    }
+#ifdef __linux__
+    const int whoProcess = 1;
+    if (syscall(SYS_ioprio_set, whoProcess, 0, priority) != 0)
+    {
+        throw runtime_error(string("cannot set I/O class: ") + strerror(errno));
+    }
+#else
+    (void)priority;
+    throw runtime_error("I/O classes are only supported on Linux");
+#endif
+}
@@ -0,0 +1,214 @@
+#include "merkle.hpp"
+
+/**
+ * @brief Constructor for ThroughputMeter
+ * @param label Operation name used in progress lines (e.g. "Build")
+ */
+ThroughputMeter::ThroughputMeter(const string &label)
+    : label(label), totalBytes(0), totalFiles(0), sampleBytes(0), sampleFiles(0),
+      peakBytesPerSec(0), peakFilesPerSec(0), expectedFiles(0), expectedBytes(0), skippedBytes(0),
+      smoothedBytesPerSec(0), smoothedFilesPerSec(0)
+{
+    startTime = Clock::now();
+    sampleTime = startTime;
+}
+
+/**
+ * @brief Reset all counters and start timing a new operation
+ */
+void ThroughputMeter::start()
+{
+    startTime = Clock::now();
+    sampleTime = startTime;
+    totalBytes = totalFiles = 0;
+    sampleBytes = sampleFiles = 0;
+    peakBytesPerSec = peakFilesPerSec = 0;
+    expectedFiles = expectedBytes = skippedBytes = 0;
+    smoothedBytesPerSec = smoothedFilesPerSec = 0;
+    lanes.clear();
+}
+
+/**
+ * @brief Set the totals the operation is expected to reach
+ * @param files Number of files a pre-scan found
+ * @param bytes Number of bytes a pre-scan found
+ */
+void ThroughputMeter::expect(size_t files, size_t bytes)
+{
+    expectedFiles = files;
+    expectedBytes = bytes;
+}
+
+/**
+ * @brief Count bytes as done without processing them
+ * @param bytes Number of bytes, such as those of a file resumed from a checkpoint
+ */
+void ThroughputMeter::skip(size_t bytes)
+{
+    skippedBytes += bytes;
+}
+
+/**
+ * @brief Give a file being hashed the first free lane
+ * @param path Path of the file relative to the tree root
+ * @param size Size of the file in bytes
+ * @return Lane of the file
+ */
+size_t ThroughputMeter::begin(const string &path, size_t size)
+{
+    Lane lane{true, path, 0, size};
+    for (size_t i = 0; i < lanes.size(); i++)
+    {
+        if (!lanes[i].busy)
+        {
+            lanes[i] = lane;
+            return i;
+        }
+    }
+    lanes.push_back(lane);
+    return lanes.size() - 1;
+}
+
+/**
+ * @brief Record bytes hashed of the file in a lane, as record does
+ * @param lane Lane returned by begin
+ * @param bytes Number of bytes hashed
+ */
+void ThroughputMeter::advance(size_t lane, size_t bytes)
+{
+    lanes[lane].done += bytes;
+    record(bytes, 0);
+}
+
+/**
+ * @brief Free a lane once its file is hashed
+ * @param lane Lane returned by begin
+ */
+void ThroughputMeter::end(size_t lane)
+{
+    lanes[lane].busy = false;
+}
+
+/**
+ * @brief Record processed data, printing a progress line when due
+ * @param bytes Number of bytes processed
+ * @param files Number of files processed
+ */
+void ThroughputMeter::record(size_t bytes, size_t files)
+{
+    totalBytes += bytes;
+    totalFiles += files;
+    sampleBytes += bytes;
+    sampleFiles += files;
+
+    auto now = Clock::now();
+    double elapsed = std::chrono::duration<double>(now - sampleTime).count();
+    if (elapsed < MTFSConstants::REPORT_INTERVAL)
+    {
+        return;
+    }
+
+    auto [bytesPerSec, filesPerSec] = closeWindow(now);
+    // Recent windows weigh most, but one slow window does not swing the ETA
+    const double weight = 0.3;
+    bool first = smoothedBytesPerSec == 0 && smoothedFilesPerSec == 0;
+    smoothedBytesPerSec = first ? bytesPerSec : weight * bytesPerSec + (1 - weight) * smoothedBytesPerSec;
+    smoothedFilesPerSec = first ? filesPerSec : weight * filesPerSec + (1 - weight) * smoothedFilesPerSec;
+
+    for (size_t i = 0; i < lanes.size(); i++)
+    {
+        if (lanes[i].busy)
+        {
+            cout << "Worker " << i + 1 << ": " << lanes[i].done << "/" << lanes[i].size
+                 << " bytes of " << lanes[i].path << "\n";
+        }
+    }
+
+    stringstream ss;
+    if (expectedFiles == 0)
+    {
+        ss << "Progress: " << label << " " << totalFiles << " files, "
+           << formatFileSize(totalBytes) << " | "
+           << formatFileSize(static_cast<size_t>(bytesPerSec)) << "/s, "
+           << fixed << setprecision(1) << filesPerSec << " files/s";
+        cout << ss.str() << endl;
+        return;
+    }
+
+    // Files changed since the pre-scan can take the counts past the totals
+    size_t doneBytes = min(totalBytes + skippedBytes, expectedBytes);
+    size_t doneFiles = min(totalFiles, expectedFiles);
+    double share = expectedBytes > 0 ? static_cast<double>(doneBytes) / expectedBytes
+                                     : static_cast<double>(doneFiles) / expectedFiles;
+    ss << "Progress: " << label << " " << doneFiles << "/" << expectedFiles << " files, "
+       << formatFileSize(doneBytes) << "/" << formatFileSize(expectedBytes)
+       << " (" << fixed << setprecision(1) << share * 100 << "%) | "
+       << formatFileSize(static_cast<size_t>(bytesPerSec)) << "/s, "
+       << filesPerSec << " files/s";
+    double eta = -1;
+    if (expectedBytes > 0 && smoothedBytesPerSec > 0)
+    {
+        eta = (expectedBytes - doneBytes) / smoothedBytesPerSec;
+    }
+    else if (expectedBytes == 0 && smoothedFilesPerSec > 0)
+    {
+        eta = (expectedFiles - doneFiles) / smoothedFilesPerSec;
+    }
+    if (eta >= 0)
+    {
+        ss << " | ETA " << formatDuration(eta);
+    }
+    cout << ss.str() << endl;
+}
+
+/**
+ * @brief Stop timing and compute the final summary
+ * @return Aggregate figures for the operation
+ */
+ThroughputSummary ThroughputMeter::finish()
+{
+    auto now = Clock::now();
+
+    // Fold the last partial window into the peaks only if nothing was
+    // sampled yet, otherwise a tiny tail window would skew the peak.
+    if (peakBytesPerSec == 0 && peakFilesPerSec == 0)
+    {
+        closeWindow(now);
+    }
+
+    ThroughputSummary summary;
+    summary.bytes = totalBytes;
+    summary.files = totalFiles;
+    summary.seconds = std::chrono::duration<double>(now - startTime).count();
+    if (summary.seconds > 0)
+    {
+        summary.avgBytesPerSec = totalBytes / summary.seconds;
+        summary.avgFilesPerSec = totalFiles / summary.seconds;
+    }
+    summary.peakBytesPerSec = max(peakBytesPerSec, summary.avgBytesPerSec);
+    summary.peakFilesPerSec = max(peakFilesPerSec, summary.avgFilesPerSec);
+    return summary;
+}
+
+/**
+ * @brief Close the current sampling window and update peak rates
+ * @param now Time at which the window is closed
+ * @return Tuple containing (bytes_per_sec, files_per_sec) of the window
+ */
+tuple<double, double> ThroughputMeter::closeWindow(Clock::time_point now)
+{
+    double elapsed = std::chrono::duration<double>(now - sampleTime).count();
+    double bytesPerSec = 0, filesPerSec = 0;
+    if (elapsed > 0)
+    {
+        bytesPerSec = sampleBytes / elapsed;
+        filesPerSec = sampleFiles / elapsed;
+    }
+
+    peakBytesPerSec = max(peakBytesPerSec, bytesPerSec);
+    peakFilesPerSec = max(peakFilesPerSec, filesPerSec);
+
+    sampleTime = now;
+    sampleBytes = sampleFiles = 0;
+    return make_tuple(bytesPerSec, filesPerSec);
+}
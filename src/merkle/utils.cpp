@@ -3,16 +3,20 @@
 #include <sstream>
 #include <iomanip>
 #include <fstream>
+#include <limits>
+#include <cerrno>
+#include <fcntl.h>
+#include <openssl/sha.h>
 
 /**
  * @brief Returns a human-readable representation of file size
  * 
  * @param bytes  Size in bytes
- * @return Formatted size with appropriate unit (B, KB, MB, GB) 
+ * @return Formatted size with appropriate unit (B, KB, MB, GB, TB) 
  */
 std::string formatFileSize(size_t bytes)
 {
-    const char *units[] = {"B", "KB", "MB", "GB"};
+    const char *units[] = {"B", "KB", "MB", "GB", "TB"};
     double size = static_cast<double>(bytes);
     int unit = 0;
     while (size >= 1024 && unit < 4)
@@ -25,6 +29,63 @@ std::string formatFileSize(size_t bytes)
     return oss.str();
 }
 
+/**
+ * @brief Parses a size such as "512", "64K" or "2G"
+ * 
+ * Suffixes K, M, G and T (optionally followed by "B" or "iB") are binary
+ * multiples, matching formatFileSize.
+ * 
+ * @param text Size to parse
+ * @param bytes Receives the size in bytes
+ * @return True if the text is a valid size
+ */
+bool parseFileSize(const std::string &text, size_t &bytes)
+{
+    size_t digits = 0;
+    while (digits < text.size() && isdigit(static_cast<unsigned char>(text[digits])))
+    {
+        ++digits;
+    }
+    if (digits == 0 || digits > 15)
+    {
+        return false;
+    }
+
+    std::string suffix = text.substr(digits);
+    for (char &c : suffix)
+    {
+        c = static_cast<char>(toupper(static_cast<unsigned char>(c)));
+    }
+    if (suffix.size() > 1 && (suffix.substr(1) == "B" || suffix.substr(1) == "IB"))
+    {
+        suffix = suffix.substr(0, 1);
+    }
+
+    const std::string units = "KMGT";
+    size_t multiplier = 1;
+    if (suffix == "B")
+    {
+        suffix.clear();
+    }
+    if (!suffix.empty())
+    {
+        size_t unit = units.find(suffix);
+        if (suffix.size() != 1 || unit == std::string::npos)
+        {
+            return false;
+        }
+        multiplier = size_t(1) << (10 * (unit + 1));
+    }
+
+    size_t value = std::stoull(text.substr(0, digits));
+    if (value > std::numeric_limits<size_t>::max() / multiplier)
+    {
+        return false;
+    }
+    bytes = value * multiplier;
+    return true;
+}
+
 /**
  * @brief Get the File Extension
  * 
@@ -67,3 +128,218 @@ bool isBinaryFile(const std::string &filepath)
     }
     return false;
 }
+
+
+/**
+ * @brief Format a throughput summary on one line
+ *
+ * @param summary Throughput figures to format
+ * @return Formatted string with average and peak byte and file rates
+ */
+std::string formatThroughput(const ThroughputSummary &summary)
+{
+    std::ostringstream oss;
+    oss << "avg " << formatFileSize(static_cast<size_t>(summary.avgBytesPerSec)) << "/s"
+        << " (peak " << formatFileSize(static_cast<size_t>(summary.peakBytesPerSec)) << "/s), "
+        << std::fixed << std::setprecision(1) << summary.avgFilesPerSec << " files/s"
+        << " (peak " << summary.peakFilesPerSec << " files/s)";
+    return oss.str();
+}
+
+/**
+ * @brief Format a duration for progress lines
+ *
+ * @param seconds Duration in seconds
+ * @return Text such as "45s", "3m 20s" or "1h 05m"
+ */
+std::string formatDuration(double seconds)
+{
+    long long total = static_cast<long long>(seconds + 0.5);
+    std::ostringstream oss;
+    if (total >= 3600)
+    {
+        oss << total / 3600 << "h " << std::setw(2) << std::setfill('0') << total % 3600 / 60 << "m";
+    }
+    else if (total >= 60)
+    {
+        oss << total / 60 << "m " << std::setw(2) << std::setfill('0') << total % 60 << "s";
+    }
+    else
+    {
+        oss << total << "s";
+    }
+    return oss.str();
+}
+
+/**
+ * @brief Escape a string for use inside JSON quotes
+ *
+ * @param value Raw string
+ * @return Escaped string
+ */
+std::string jsonEscape(const std::string &value)
+{
+    std::ostringstream oss;
+    for (char c : value)
+    {
+        unsigned char uc = static_cast<unsigned char>(c);
+        if (c == '"' || c == '\\')
+        {
+            oss << '\\' << c;
+        }
+        else if (uc < 0x20)
+        {
+            oss << "\\u" << std::hex << std::setw(4) << std::setfill('0') << static_cast<int>(uc) << std::dec;
+        }
+        else
+        {
+            oss << c;
+        }
+    }
+    return oss.str();
+}
+
+/**
+ * @brief Escape tabs, newlines and backslashes in a record field
+ *
+ * @param field Raw field value
+ * @return Escaped value safe for tab-separated records
+ */
+std::string escapeField(const std::string &field)
+{
+    std::string escaped;
+    for (char c : field)
+    {
+        switch (c)
+        {
+        case '\\':
+            escaped += "\\\\";
+            break;
+        case '\t':
+            escaped += "\\t";
+            break;
+        case '\n':
+            escaped += "\\n";
+            break;
+        default:
+            escaped += c;
+        }
+    }
+    return escaped;
+}
+
+/**
+ * @brief Reverse escapeField
+ *
+ * @param field Escaped field value
+ * @return Original field value
+ */
+std::string unescapeField(const std::string &field)
+{
+    std::string raw;
+    for (size_t i = 0; i < field.size(); ++i)
+    {
+        if (field[i] == '\\' && i + 1 < field.size())
+        {
+            char next = field[++i];
+            raw += next == 't' ? '\t' : next == 'n' ? '\n' : next;
+        }
+        else
+        {
+            raw += field[i];
+        }
+    }
+    return raw;
+}
+
+/**
+ * @brief Make a path absolute and clean it the way the frontend does
+ *
+ * @param path Path to clean
+ * @return Absolute path without "." or ".." elements or a trailing separator
+ */
+static string cleanAbsolute(const fs::path &path)
+{
+    error_code ec;
+    fs::path absolute = fs::absolute(path, ec);
+    string clean = (ec ? path : absolute).lexically_normal().string();
+    while (clean.size() > 1 && clean.back() == '/')
+    {
+        clean.pop_back();
+    }
+    return clean;
+}
+
+/**
+ * @brief Get the case directory of forensic mode
+ *
+ * @return Absolute directory named by MTFS_FORENSIC, empty if the mode is off
+ */
+fs::path forensicDirectory()
+{
+    const char *dir = getenv(MTFSConstants::FORENSIC_ENV.c_str());
+    if (!dir || !*dir)
+    {
+        return {};
+    }
+    return cleanAbsolute(dir);
+}
+
+/**
+ * @brief Open a file for reading, leaving its access time alone in forensic mode
+ *
+ * Only the owner of a file or a privileged user may open it with
+ * O_NOATIME; for others it is opened as usual.
+ *
+ * @param path File to open
+ * @return File descriptor, or -1 with errno set if it cannot be opened
+ */
+int openForReading(const string &path)
+{
+    if (!forensicDirectory().empty())
+    {
+        int fd = open(path.c_str(), O_RDONLY | O_CLOEXEC | O_NOATIME);
+        if (fd >= 0 || errno != EPERM)
+        {
+            return fd;
+        }
+    }
+    return open(path.c_str(), O_RDONLY | O_CLOEXEC);
+}
+
+/**
+ * @brief Locate the state directory of a tree
+ *
+ * In forensic mode the state is kept in the case directory instead, under
+ * trees/<name>-<first 16 hex digits of the SHA-256 of the absolute path>,
+ * the name the frontend derives.
+ *
+ * @param treeRoot Root directory of the tree, or the archive it was built from
+ * @return treeRoot/.mtfs, <archive>.mtfs beside an archive, or the tree's directory in the case
+ */
+fs::path stateDirectory(const fs::path &treeRoot)
+{
+    fs::path caseDir = forensicDirectory();
+    if (!caseDir.empty())
+    {
+        string absolute = cleanAbsolute(treeRoot);
+        string name = fs::path(absolute).filename().string();
+        if (name.empty())
+        {
+            name = "root";
+        }
+        unsigned char digest[SHA256_DIGEST_LENGTH];
+        SHA256(reinterpret_cast<const unsigned char *>(absolute.data()), absolute.size(), digest);
+        ostringstream hash;
+        for (int i = 0; i < 8; ++i)
+        {
+            hash << hex << setw(2) << setfill('0') << static_cast<int>(digest[i]);
+        }
+        return caseDir / MTFSConstants::FORENSIC_TREES_DIR / (name + "-" + hash.str());
+    }
+    if (fs::is_regular_file(treeRoot))
+    {
+        return fs::path(treeRoot.string() + MTFSConstants::STATE_DIR);
+    }
+    return treeRoot / MTFSConstants::STATE_DIR;
+}
@@ -0,0 +1,193 @@
+#include "merkle.hpp"
+#include <sys/xattr.h>
+#include <cerrno>
+#include <cstring>
+
+/**
+ * @brief Read the extended attributes of a file or directory
+ * @param path Filesystem path (symbolic links are followed)
+ * @return Attribute values by name, empty if the file system has none
+ * @throws runtime_error If the attributes cannot be read
+ *
+ * POSIX ACLs, SELinux labels and file capabilities are stored as
+ * attributes too, so they are covered as well.
+ */
+map<string, string> readXattrs(const fs::path &path)
+{
+    map<string, string> attrs;
+    ssize_t size = listxattr(path.c_str(), nullptr, 0);
+    if (size < 0 && (errno == ENOTSUP || errno == ENODATA))
+    {
+        return attrs;
+    }
+    if (size < 0)
+    {
+        throw runtime_error("Cannot list extended attributes: " + string(strerror(errno)));
+    }
+
+    string names(size, '\0');
+    size = listxattr(path.c_str(), names.data(), names.size());
+    if (size < 0)
+    {
+        throw runtime_error("Cannot list extended attributes: " + string(strerror(errno)));
+    }
+    names.resize(size);
+
+    for (size_t start = 0; start < names.size();)
+    {
+        size_t end = names.find('\0', start);
+        if (end == string::npos)
+        {
+            end = names.size();
+        }
+        string name = names.substr(start, end - start);
+        start = end + 1;
+        if (name.empty())
+        {
+            continue;
+        }
+
+        ssize_t valueSize = getxattr(path.c_str(), name.c_str(), nullptr, 0);
+        if (valueSize < 0 && errno == ENODATA)
+        {
+            continue; // Removed since it was listed
+        }
+        string value(valueSize > 0 ? valueSize : 0, '\0');
+        if (valueSize < 0 || getxattr(path.c_str(), name.c_str(), value.data(), value.size()) < 0)
+        {
+            throw runtime_error("Cannot read extended attribute " + name + ": " + string(strerror(errno)));
+        }
+        attrs[name] = value;
+    }
+    return attrs;
+}
+
+/**
+ * @brief Remove the attributes macOS manages on its own from a set
+ * @param attrs Attribute values by name
+ *
+ * com.apple.* attributes (quarantine flags, Finder info, resource forks,
+ * Spotlight data) change as files are downloaded, opened or indexed; they
+ * appear as user.com.apple.* on Linux file systems.
+ */
+void removeAppleXattrs(map<string, string> &attrs)
+{
+    for (auto it = attrs.begin(); it != attrs.end();)
+    {
+        const string &name = it->first;
+        bool apple = name.rfind("com.apple.", 0) == 0 || name.rfind("user.com.apple.", 0) == 0;
+        it = apple ? attrs.erase(it) : next(it);
+    }
+}
+
+/**
+ * @brief Hex-encode a byte string
+ * @param data Bytes to encode
+ * @return Lowercase hexadecimal string
+ */
+string hexEncode(const string &data)
+{
+    static const char digits[] = "0123456789abcdef";
+    string hex;
+    hex.reserve(data.size() * 2);
+    for (unsigned char c : data)
+    {
+        hex += digits[c >> 4];
+        hex += digits[c & 0x0f];
+    }
+    return hex;
+}
+
+/**
+ * @brief Serialize extended attributes in the canonical form that is hashed
+ * @param attrs Attribute values by name
+ * @return One "name=hexvalue" line per attribute in name order
+ */
+string serializeXattrs(const map<string, string> &attrs)
+{
+    string out;
+    for (const auto &[name, value] : attrs)
+    {
+        out += name + "=" + hexEncode(value) + "\n";
+    }
+    return out;
+}
+
+// Render a POSIX ACL attribute (struct posix_acl_xattr_header + entries)
+static bool formatAcl(const string &value, string &text)
+{
+    if (value.size() < 4 || (value.size() - 4) % 8 != 0)
+    {
+        return false;
+    }
+    auto u16 = [&value](size_t at)
+    { return static_cast<unsigned>(static_cast<unsigned char>(value[at])) |
+             static_cast<unsigned>(static_cast<unsigned char>(value[at + 1])) << 8; };
+    auto u32 = [&u16](size_t at)
+    { return u16(at) | u16(at + 2) << 16; };
+    if (u32(0) != 2)
+    {
+        return false;
+    }
+
+    ostringstream oss;
+    for (size_t at = 4; at < value.size(); at += 8)
+    {
+        unsigned tag = u16(at), perm = u16(at + 2), id = u32(at + 4);
+        if (at > 4)
+        {
+            oss << ",";
+        }
+        switch (tag)
+        {
+        case 0x01:
+            oss << "user:";
+            break;
+        case 0x02:
+            oss << "user:" << id;
+            break;
+        case 0x04:
+            oss << "group:";
+            break;
+        case 0x08:
+            oss << "group:" << id;
+            break;
+        case 0x10:
+            oss << "mask:";
+            break;
+        case 0x20:
+            oss << "other:";
+            break;
+        default:
+            return false;
+        }
+        oss << ":" << (perm & 4 ? 'r' : '-') << (perm & 2 ? 'w' : '-') << (perm & 1 ? 'x' : '-');
+    }
+    text = oss.str();
+    return true;
+}
+
+/**
+ * @brief Format an extended attribute value for display
+ * @param name Attribute name
+ * @param value Raw attribute value
+ * @return ACLs in short text form, printable values quoted, others as hex
+ */
+string formatXattrValue(const string &name, const string &value)
+{
+    string text;
+    if ((name == "system.posix_acl_access" || name == "system.posix_acl_default") && formatAcl(value, text))
+    {
+        return text;
+    }
+
+    // Text values such as SELinux labels usually end in a NUL byte
+    string shown = value;
+    if (!shown.empty() && shown.back() == '\0')
+    {
+        shown.pop_back();
+    }
+    bool printable = all_of(shown.begin(), shown.end(), [](char c)
+                            { return c >= 0x20 && c < 0x7f; });
+    return printable ? "\"" + shown + "\"" : "0x" + hexEncode(value);
+}
@@ -0,0 +1,70 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// JournalSocket is the socket of journald's native protocol.
+const JournalSocket = "/run/systemd/journal/socket"
+
+// JournalSink sends events to the systemd journal with journald's native
+// protocol, as MESSAGE and PRIORITY fields plus MTFS_* fields for the
+// event's details, which journalctl can match on (MTFS_EVENT=modified).
+type JournalSink struct {
+	conn *net.UnixConn
+}
+
+// NewJournalSink returns a sink for the local journal.
+func NewJournalSink() (*JournalSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: JournalSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("journald: %w", err)
+	}
+	return &JournalSink{conn: conn}, nil
+}
+
+func (j *JournalSink) Emit(e Event) error {
+	fields := map[string]string{
+		"MESSAGE":           Describe(e),
+		"PRIORITY":          strconv.Itoa(int(e.Type.Severity())),
+		"SYSLOG_FACILITY":   strconv.Itoa(facility),
+		"SYSLOG_IDENTIFIER": appName,
+		"MTFS_EVENT":        string(e.Type),
+		"MTFS_TREE":         e.Tree,
+	}
+	for name, value := range map[string]string{"MTFS_PATH": e.Path, "MTFS_KIND": e.Kind, "MTFS_ROOT": e.Root, "MTFS_OLD_HASH": e.OldHash, "MTFS_NEW_HASH": e.NewHash} {
+		if value != "" {
+			fields[name] = value
+		}
+	}
+	if _, err := j.conn.Write(journalEntry(fields)); err != nil {
+		return fmt.Errorf("journald: %w", err)
+	}
+	return nil
+}
+
+func (j *JournalSink) Close() error {
+	return j.conn.Close()
+}
+
+// journalEntry encodes fields in the native protocol. Values holding a
+// newline are written with their length in binary, as the protocol
+// requires.
+func journalEntry(fields map[string]string) []byte {
+	var b bytes.Buffer
+	for name, value := range fields {
+		if !strings.Contains(value, "\n") {
+			b.WriteString(name + "=" + value + "\n")
+			continue
+		}
+		b.WriteString(name + "\n")
+		binary.Write(&b, binary.LittleEndian, uint64(len(value)))
+		b.WriteString(value + "\n")
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,440 @@
+// Package monitor keeps watch over a tree in daemon mode: the tree is
+// rebuilt at an interval, compared with the previous scan, and every
+// difference is emitted as a structured integrity event to the configured
+// sinks, such as syslog or the systemd journal, so existing log pipelines
+// and alert rules pick up tampering.
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"MTFS/filelock"
+	"MTFS/hooks"
+	"MTFS/manifest"
+	"MTFS/quota"
+	"MTFS/remote"
+	"MTFS/state"
+	"MTFS/trends"
+)
+
+// StateFile is the name of the last scan inside the state directory of a
+// tree, so a restarted daemon reports what changed while it was down.
+const StateFile = "monitor.json"
+
+// LockFile is the name of the lock a daemon holds inside the state
+// directory of the tree it watches, for as long as it runs.
+const LockFile = "monitor.lock"
+
+// Type is the kind of an integrity event.
+type Type string
+
+const (
+	Started   Type = "started"   // first scan, or the first after a restart
+	Unchanged Type = "unchanged" // scan found the root hash unchanged
+	Added     Type = "added"     // path that was not in the previous scan
+	Removed   Type = "removed"   // path that is gone since the previous scan
+	Modified  Type = "modified"  // path whose content or type changed
+	Failed    Type = "failed"    // scan could not be completed
+	Quota     Type = "quota"     // tree went over a quota of its .mtfs/config
+)
+
+// Severity is a syslog severity; lower is more severe.
+type Severity int
+
+const (
+	Emergency Severity = iota
+	Alert
+	Critical
+	Error
+	Warning
+	Notice
+	Info
+	Debug
+)
+
+// Severity maps the type of an event to the syslog severity it is
+// reported with: changed content is critical, disappearing paths and
+// failed scans are errors, and new paths and exceeded quotas are warnings.
+func (t Type) Severity() Severity {
+	switch t {
+	case Modified:
+		return Critical
+	case Removed, Failed:
+		return Error
+	case Added, Quota:
+		return Warning
+	case Started:
+		return Notice
+	}
+	return Info
+}
+
+// Event is a structured integrity event.
+type Event struct {
+	Time    time.Time
+	Type    Type
+	Tree    string // tree directory
+	Root    string // root hash of the scan, empty if it failed
+	Path    string // slash-separated path in the tree, empty for the tree itself
+	Kind    string // node type of Path: file, directory, symlink, ...
+	OldHash string
+	NewHash string
+	Message string
+}
+
+// Sink receives events.
+type Sink interface {
+	Emit(Event) error
+	Close() error
+}
+
+// Monitor scans a tree and emits its events to Sinks.
+type Monitor struct {
+	Tree  string
+	Sinks []Sink
+	// Errors, if not nil, is told about events a sink failed to take.
+	Errors func(error)
+
+	previous *manifest.Node
+	exceeded map[string]bool // quotas over at the last scan
+}
+
+// Scan builds the tree, compares it with the previous scan, records it
+// and emits the events found.
+func (m *Monitor) Scan() []Event {
+	events := m.scan(time.Now())
+	for _, e := range events {
+		for _, sink := range m.Sinks {
+			if err := sink.Emit(e); err != nil && m.Errors != nil {
+				m.Errors(err)
+			}
+		}
+	}
+	return events
+}
+
+func (m *Monitor) scan(now time.Time) []Event {
+	failed := func(err error) []Event {
+		return []Event{{Time: now, Type: Failed, Tree: m.Tree, Message: "scan failed: " + err.Error()}}
+	}
+	root, err := remote.Build(m.Tree)
+	if err != nil {
+		return failed(err)
+	}
+
+	var events []Event
+	changed := int64(-1)
+	if m.previous == nil {
+		previous, err := m.load()
+		if err != nil {
+			return failed(err)
+		}
+		msg := "watching tree with root hash " + root.Hash
+		if previous != nil {
+			msg = fmt.Sprintf("resuming watch; root hash was %s and is %s", previous.Hash, root.Hash)
+		}
+		events = append(events, Event{Time: now, Type: Started, Tree: m.Tree, Root: root.Hash, Message: msg})
+		m.previous = previous
+	}
+	if m.previous != nil {
+		changes := Diff(m.previous, root)
+		for i := range changes {
+			changes[i].Time, changes[i].Tree, changes[i].Root = now, m.Tree, root.Hash
+		}
+		events = append(events, changes...)
+		changed = int64(len(changes))
+		if len(changes) > 0 {
+			events = append(events, m.onChange(now, root.Hash)...)
+		}
+		if len(changes) == 0 && len(events) == 0 {
+			events = append(events, Event{Time: now, Type: Unchanged, Tree: m.Tree, Root: root.Hash, Message: "root hash unchanged: " + root.Hash})
+		}
+	}
+
+	// A quota is reported when the tree goes over it, not at every scan
+	usage := quota.Of(root)
+	alerts, err := quota.Check(m.Tree, usage, now)
+	if err != nil {
+		events = append(events, Event{Time: now, Type: Failed, Tree: m.Tree, Root: root.Hash, Message: "quotas not checked: " + err.Error()})
+	}
+	if err := trends.Record(m.Tree, trends.Sample{Time: now, Operation: "scan", Files: usage.Files, Bytes: usage.Bytes, Changed: changed}); err != nil {
+		events = append(events, Event{Time: now, Type: Failed, Tree: m.Tree, Root: root.Hash, Message: "trends not recorded: " + err.Error()})
+	}
+	exceeded := map[string]bool{}
+	for _, a := range alerts {
+		exceeded[a.Key] = true
+		if !m.exceeded[a.Key] {
+			events = append(events, Event{Time: now, Type: Quota, Tree: m.Tree, Root: root.Hash, Message: "quota exceeded: " + a.Message})
+		}
+	}
+	m.exceeded = exceeded
+
+	if err := m.save(root); err != nil {
+		events = append(events, failed(err)...)
+	}
+	m.previous = root
+	return events
+}
+
+// onChange runs the on-change hook, if one is set, for the scan of now
+// that changed the root hash to root, and reports it if it failed.
+func (m *Monitor) onChange(now time.Time, root string) []Event {
+	command := hooks.Command(hooks.OnChange)
+	if command == "" {
+		return nil
+	}
+	output, err := hooks.Run(hooks.OnChange, command, hooks.Context{Tree: m.Tree, Root: root, Previous: m.previous.Hash})
+	if err == nil {
+		return nil
+	}
+	msg := "on-change hook failed: " + err.Error()
+	if out := strings.TrimSpace(string(output)); out != "" {
+		msg += ": " + out
+	}
+	return []Event{{Time: now, Type: Failed, Tree: m.Tree, Root: root, Message: msg}}
+}
+
+// Lock takes the watch lock of tree, so Watching reports it as watched
+// until the returned file is closed. It fails if another daemon watches
+// tree already.
+func Lock(tree string) (*os.File, error) {
+	name := state.Path(tree, LockFile)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := filelock.TryLock(f); err != nil {
+		f.Close()
+		if errors.Is(err, filelock.ErrLocked) {
+			return nil, fmt.Errorf("%s is watched by another daemon already", tree)
+		}
+		return nil, fmt.Errorf("locking %s: %w", name, err)
+	}
+	return f, nil
+}
+
+// Watching reports whether a daemon is watching tree.
+func Watching(tree string) bool {
+	f, err := os.Open(state.Path(tree, LockFile))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	err = filelock.TryShare(f)
+	if err == nil {
+		filelock.Unlock(f)
+	}
+	return errors.Is(err, filelock.ErrLocked)
+}
+
+// Run scans the tree every interval until stop is closed.
+func (m *Monitor) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.Scan()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// LastScan returns tree as the last scan of a daemon found it, nil if no
+// daemon scanned it yet.
+func LastScan(tree string) (*manifest.Node, error) {
+	return (&Monitor{Tree: tree}).load()
+}
+
+func (m *Monitor) load() (*manifest.Node, error) {
+	data, err := state.ReadFile(m.Tree, StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return manifest.Parse(data)
+}
+
+func (m *Monitor) save(root *manifest.Node) error {
+	data, err := json.Marshal(map[string]*manifest.Node{root.Name: root})
+	if err != nil {
+		return err
+	}
+	return state.WriteFile(m.Tree, StateFile, data)
+}
+
+// Comparison is what turns one tree into another.
+type Comparison struct {
+	Trees   [2]string
+	Roots   [2]*manifest.Node
+	Changes []Event // empty if the root hashes match
+}
+
+// Compare builds the trees at a and b side by side, with the walk options
+// recorded for each, and returns what turns a into b. Trees hashed in
+// different formats have no hash in common, so they are not compared.
+func Compare(a, b string) (*Comparison, error) {
+	if fa, fb := state.HashFormat(a), state.HashFormat(b); fa != fb {
+		return nil, fmt.Errorf("%s is hashed in the %s format and %s in the %s format; rebuild one with --hash-format %s", a, fa, b, fb, fa)
+	}
+	var roots [2]*manifest.Node
+	var errs [2]error
+	var wg sync.WaitGroup
+	for i, dir := range [2]string{a, b} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			roots[i], errs[i] = remote.Build(dir)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs[:]...); err != nil {
+		return nil, err
+	}
+	return CompareRoots(a, b, roots[0], roots[1])
+}
+
+// CompareRoots returns what turns the tree a into the tree b, both
+// already built or saved, such as two exports or named snapshots. Trees
+// hashed in different formats have no hash in common, so they are not
+// compared.
+func CompareRoots(a, b string, ra, rb *manifest.Node) (*Comparison, error) {
+	if fa, fb := formatOf(ra), formatOf(rb); fa != fb {
+		return nil, fmt.Errorf("%s is hashed in the %s format and %s in the %s format", a, fa, b, fb)
+	}
+	c := &Comparison{Trees: [2]string{a, b}, Roots: [2]*manifest.Node{ra, rb}}
+	if ra.Hash != rb.Hash {
+		c.Changes = Diff(ra, rb)
+	}
+	return c, nil
+}
+
+func formatOf(root *manifest.Node) string {
+	if root.HashFormat == "" {
+		return "mtfs"
+	}
+	return root.HashFormat
+}
+
+// Diff returns the events that turn old into cur, in path order with the
+// removals last. Added and removed directories are reported once, not
+// with everything below them. Directories with the same hash in both
+// trees hold the same entries, so they are not descended into. Only Type,
+// Path, Kind, the hashes and Message are set.
+func Diff(old, cur *manifest.Node) []Event {
+	var events []Event
+	diff(".", old, cur, &events)
+	sort.SliceStable(events, func(i, j int) bool {
+		if ri, rj := events[i].Type == Removed, events[j].Type == Removed; ri != rj {
+			return rj
+		}
+		return events[i].Path < events[j].Path
+	})
+	return events
+}
+
+// diff adds the events that turn o into n, both at p, to events.
+func diff(p string, o, n *manifest.Node, events *[]Event) {
+	if o.Type == n.Type && o.Hash == n.Hash {
+		return
+	}
+	switch {
+	case p == ".":
+	case o.Type != n.Type:
+		*events = append(*events, Event{Type: Modified, Path: p, Kind: n.Type, OldHash: o.Hash, NewHash: n.Hash,
+			Message: fmt.Sprintf("%s replaced by a %s", describe(o, ""), n.Type)})
+	case n.Type != "directory":
+		message := fmt.Sprintf("%s modified: hash %s, was %s", n.Type, n.Hash, o.Hash)
+		if changes := metadataChanges(o, n); changes != "" && o.ContentHash == n.ContentHash &&
+			o.Target == n.Target && o.Device == n.Device && o.XattrsHash == n.XattrsHash {
+			message = fmt.Sprintf("%s metadata changed: %s", n.Type, changes)
+		}
+		*events = append(*events, Event{Type: Modified, Path: p, Kind: n.Type, OldHash: o.Hash, NewHash: n.Hash,
+			Message: message})
+	default:
+		if changes := metadataChanges(o, n); changes != "" {
+			*events = append(*events, Event{Type: Modified, Path: p, Kind: n.Type, OldHash: o.Hash, NewHash: n.Hash,
+				Message: "directory metadata changed: " + changes})
+		}
+	}
+	// An entry replaced by a directory, or the other way round, has the
+	// entries of the directory added or removed
+	for _, child := range n.SortedChildren() {
+		cp := path.Join(p, child.Name)
+		if before, existed := o.Children[child.Name]; existed {
+			diff(cp, before, child, events)
+		} else {
+			*events = append(*events, Event{Type: Added, Path: cp, Kind: child.Type, NewHash: child.Hash,
+				Message: describe(child, "added")})
+		}
+	}
+	for _, child := range o.SortedChildren() {
+		if _, exists := n.Children[child.Name]; !exists {
+			*events = append(*events, Event{Type: Removed, Path: path.Join(p, child.Name), Kind: child.Type, OldHash: child.Hash,
+				Message: describe(child, "removed")})
+		}
+	}
+}
+
+// metadataChanges describes the metadata hashed with o and n that
+// differs, such as "mode 0600, was 0644", "" if none does.
+func metadataChanges(o, n *manifest.Node) string {
+	var names []string
+	for name := range o.Metadata {
+		names = append(names, name)
+	}
+	for name := range n.Metadata {
+		if _, ok := o.Metadata[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	value := func(m map[string]string, name string) string {
+		if v, ok := m[name]; ok {
+			return v
+		}
+		return "not hashed"
+	}
+	var changes []string
+	for _, name := range names {
+		if before, now := value(o.Metadata, name), value(n.Metadata, name); before != now {
+			changes = append(changes, fmt.Sprintf("%s %s, was %s", name, now, before))
+		}
+	}
+	return strings.Join(changes, "; ")
+}
+
+// describe names node, counting what a directory holds.
+func describe(n *manifest.Node, verb string) string {
+	s := n.Type
+	if n.Type == "directory" {
+		count := -1
+		n.Walk(func(string, *manifest.Node) error {
+			count++
+			return nil
+		})
+		s = fmt.Sprintf("directory with %d entries", count)
+		if count == 1 {
+			s = "directory with 1 entry"
+		}
+	}
+	if verb != "" {
+		s += " " + verb
+	}
+	return s
+}
@@ -0,0 +1,165 @@
+package monitor
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// facility is the syslog facility events are logged under (daemon).
+	facility = 3
+	// appName is the APP-NAME of syslog messages and the journal's
+	// SYSLOG_IDENTIFIER.
+	appName = "mtfs"
+	// sdID names the structured data element of events. 32473 is the
+	// private enterprise number RFC 5612 reserves for documentation, used
+	// as MTFS has none of its own.
+	sdID = "mtfs@32473"
+)
+
+// localSyslog are the sockets the local syslog daemon listens on.
+var localSyslog = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogSink sends events as RFC 5424 messages, with the event's fields
+// as structured data.
+type SyslogSink struct {
+	network, address string
+	hostname         string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogSink returns a sink for the syslog daemon at addr: "local" for
+// the local daemon's socket, or a URL such as udp://host:514,
+// tcp://host:601 or unix:///dev/log.
+func NewSyslogSink(addr string) (*SyslogSink, error) {
+	hostname, _ := os.Hostname()
+	s := &SyslogSink{hostname: hostname}
+	if addr == "local" {
+		for _, socket := range localSyslog {
+			if _, err := os.Stat(socket); err == nil {
+				s.network, s.address = "unixgram", socket
+				break
+			}
+		}
+		if s.address == "" {
+			return nil, fmt.Errorf("syslog: no local syslog socket (tried %s)", strings.Join(localSyslog, ", "))
+		}
+	} else {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("syslog: %w", err)
+		}
+		switch u.Scheme {
+		case "udp", "tcp":
+			s.network, s.address = u.Scheme, u.Host
+		case "unix", "unixgram":
+			s.network, s.address = u.Scheme, u.Path
+		default:
+			return nil, fmt.Errorf("syslog: unsupported address %q", addr)
+		}
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SyslogSink) connect() error {
+	conn, err := net.DialTimeout(s.network, s.address, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("syslog: %w", err)
+	}
+	s.conn = conn
+	return nil
+}
+
+// Emit sends e, reconnecting once if the connection was lost, as happens
+// when the syslog daemon restarts.
+func (s *SyslogSink) Emit(e Event) error {
+	msg := s.format(e)
+	switch s.network {
+	case "tcp":
+		msg = fmt.Sprintf("%d %s", len(msg), msg) // Octet counting (RFC 6587)
+	case "unix":
+		msg += "\n"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
+		s.conn.Close()
+		if err := s.connect(); err != nil {
+			s.conn = nil
+			return err
+		}
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			return fmt.Errorf("syslog: %w", err)
+		}
+	}
+	return nil
+}
+
+// format renders e as an RFC 5424 message whose MSGID is the event type.
+func (s *SyslogSink) format(e Event) string {
+	pri := facility*8 + int(e.Type.Severity())
+	params := [][2]string{{"event", string(e.Type)}, {"tree", e.Tree}}
+	for _, p := range [][2]string{{"path", e.Path}, {"kind", e.Kind}, {"root", e.Root}, {"oldHash", e.OldHash}, {"newHash", e.NewHash}} {
+		if p[1] != "" {
+			params = append(params, p)
+		}
+	}
+	var sd strings.Builder
+	sd.WriteString("[" + sdID)
+	for _, p := range params {
+		fmt.Fprintf(&sd, " %s=\"%s\"", p[0], sdEscape(p[1]))
+	}
+	sd.WriteString("]")
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s", pri, e.Time.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		headerField(s.hostname), appName, os.Getpid(), e.Type, sd.String(), Describe(e))
+}
+
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// sdEscape escapes a structured data parameter value.
+func sdEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
+
+// headerField returns v, or the nil value "-" if it is empty.
+func headerField(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// Describe renders e as a one-line message.
+func Describe(e Event) string {
+	where := e.Tree
+	if e.Path != "" {
+		where += ": " + e.Path
+	}
+	return fmt.Sprintf("%s %s", where, e.Message)
+}
@@ -0,0 +1,391 @@
+// Package oci verifies container images against their own digests and an
+// MTFS snapshot. It reads OCI image layouts, as a directory or a tar file,
+// and the tarballs written by docker save, checks every manifest, config
+// and layer blob against the digest it is referred to by and every layer's
+// uncompressed content against the config's diff_ids, and compares the
+// files of the image, with its layers applied in order, to those of a
+// snapshot taken of the extracted filesystem.
+package oci
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"MTFS/forensic"
+)
+
+// Media types of the documents an image is described by.
+const (
+	mediaIndex        = "application/vnd.oci.image.index.v1+json"
+	mediaManifest     = "application/vnd.oci.image.manifest.v1+json"
+	mediaDockerList   = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaDockerImage  = "application/vnd.docker.distribution.manifest.v2+json"
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+)
+
+// Descriptor refers to a blob by digest.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *Platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Platform is the platform of an image in an index.
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p *Platform) String() string {
+	if p == nil {
+		return ""
+	}
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// index is an image index or Docker manifest list.
+type index struct {
+	Manifests []Descriptor `json:"manifests"`
+}
+
+// manifest is an image manifest.
+type manifest struct {
+	Config Descriptor   `json:"config"`
+	Layers []Descriptor `json:"layers"`
+}
+
+// config is the part of an image config that is checked.
+type config struct {
+	RootFS struct {
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// dockerManifest is an entry of the manifest.json written by docker save.
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// source reads the files of an image layout or docker save tarball by
+// slash-separated name.
+type source interface {
+	open(name string) (io.ReadCloser, error)
+}
+
+// dirSource is an image layout directory.
+type dirSource string
+
+func (d dirSource) open(name string) (io.ReadCloser, error) {
+	return forensic.Open(filepath.Join(string(d), filepath.FromSlash(name)))
+}
+
+// tarSource is a tar file, optionally gzip-compressed. Every open scans it
+// from the start, so images are read with one pass per blob.
+type tarSource string
+
+func (t tarSource) open(name string) (io.ReadCloser, error) {
+	f, err := forensic.Open(string(t))
+	if err != nil {
+		return nil, err
+	}
+	r, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			f.Close()
+			return nil, fmt.Errorf("%s: %s: %w", t, name, os.ErrNotExist)
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", t, err)
+		}
+		if hdr.Typeflag == tar.TypeReg && path.Clean(strings.TrimPrefix(hdr.Name, "./")) == name {
+			return struct {
+				io.Reader
+				io.Closer
+			}{tr, f}, nil
+		}
+	}
+}
+
+// decompress returns r, or a reader of its decompressed content if it is
+// gzip-compressed.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
+// Image is one image of a layout.
+type Image struct {
+	Name     string // reference name or tag, if recorded
+	Platform string // os/arch, if recorded
+	Manifest string // digest of the manifest, empty for docker save tarballs
+	Config   Descriptor
+	Layers   []Descriptor
+	DiffIDs  []string
+
+	layerFiles []string // layers of docker save tarballs, which have no digests
+}
+
+// Layout is an opened image layout or docker save tarball.
+type Layout struct {
+	Path   string
+	Images []Image
+	src    source
+}
+
+// Open reads the image layout or docker save tarball at name and checks
+// the digests of the indexes, manifests and configs that describe its
+// images. Layers are only checked by Verify.
+func Open(name string) (*Layout, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	l := &Layout{Path: name, src: tarSource(name)}
+	if info.IsDir() {
+		l.src = dirSource(name)
+	}
+
+	// Layouts written by docker save 25 and later have both files; the
+	// index is preferred as it carries digests for everything
+	data, err := readAll(l.src, "index.json")
+	switch {
+	case err == nil:
+		var idx index
+		if err := json.Unmarshal(data, &idx); err != nil {
+			return nil, fmt.Errorf("index.json: %w", err)
+		}
+		if err := l.addIndex(idx, "", 0); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, os.ErrNotExist):
+		if err := l.addDockerImages(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	if len(l.Images) == 0 {
+		return nil, fmt.Errorf("%s: no images", name)
+	}
+	return l, nil
+}
+
+// addIndex adds the images of idx, following nested indexes.
+func (l *Layout) addIndex(idx index, name string, depth int) error {
+	if depth > 4 {
+		return errors.New("image indexes nested too deeply")
+	}
+	for _, desc := range idx.Manifests {
+		ref := name
+		if annotated := desc.Annotations[refNameAnnotation]; annotated != "" {
+			ref = annotated
+		}
+		data, err := l.readBlob(desc)
+		if err != nil {
+			return err
+		}
+		switch mediaType(desc, data) {
+		case mediaIndex, mediaDockerList:
+			var nested index
+			if err := json.Unmarshal(data, &nested); err != nil {
+				return fmt.Errorf("index %s: %w", desc.Digest, err)
+			}
+			if err := l.addIndex(nested, ref, depth+1); err != nil {
+				return err
+			}
+		case mediaManifest, mediaDockerImage:
+			// Attestations and other artifacts are stored as images for
+			// the platform unknown/unknown
+			if desc.Platform != nil && desc.Platform.OS == "unknown" {
+				continue
+			}
+			if err := l.addManifest(desc, data, ref); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mediaType returns the media type of the document desc refers to, which
+// the document itself tells if desc does not.
+func mediaType(desc Descriptor, data []byte) string {
+	if desc.MediaType != "" {
+		return desc.MediaType
+	}
+	var doc struct {
+		MediaType string          `json:"mediaType"`
+		Manifests json.RawMessage `json:"manifests"`
+		Layers    json.RawMessage `json:"layers"`
+	}
+	json.Unmarshal(data, &doc)
+	switch {
+	case doc.MediaType != "":
+		return doc.MediaType
+	case doc.Manifests != nil:
+		return mediaIndex
+	case doc.Layers != nil:
+		return mediaManifest
+	}
+	return ""
+}
+
+func (l *Layout) addManifest(desc Descriptor, data []byte, name string) error {
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("manifest %s: %w", desc.Digest, err)
+	}
+	cfgData, err := l.readBlob(m.Config)
+	if err != nil {
+		return err
+	}
+	var cfg config
+	if err := json.Unmarshal(cfgData, &cfg); err != nil {
+		return fmt.Errorf("config %s: %w", m.Config.Digest, err)
+	}
+	l.Images = append(l.Images, Image{
+		Name:     name,
+		Platform: desc.Platform.String(),
+		Manifest: desc.Digest,
+		Config:   m.Config,
+		Layers:   m.Layers,
+		DiffIDs:  cfg.RootFS.DiffIDs,
+	})
+	return nil
+}
+
+// addDockerImages adds the images listed in the manifest.json of a docker
+// save tarball. Its layers are named by path rather than digest, so they
+// are only checked against the config's diff_ids.
+func (l *Layout) addDockerImages() error {
+	data, err := readAll(l.src, "manifest.json")
+	if err != nil {
+		return fmt.Errorf("%s is neither an OCI layout nor a docker save tarball: %w", l.Path, err)
+	}
+	var entries []dockerManifest
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("manifest.json: %w", err)
+	}
+	for _, e := range entries {
+		cfgData, err := readAll(l.src, e.Config)
+		if err != nil {
+			return err
+		}
+		// Configs are named after their digest
+		digest := "sha256:" + strings.TrimSuffix(path.Base(e.Config), ".json")
+		if err := checkDigest(cfgData, digest); err != nil {
+			return fmt.Errorf("config %s: %w", e.Config, err)
+		}
+		var cfg config
+		if err := json.Unmarshal(cfgData, &cfg); err != nil {
+			return fmt.Errorf("config %s: %w", e.Config, err)
+		}
+		img := Image{Config: Descriptor{Digest: digest, Size: int64(len(cfgData))}, DiffIDs: cfg.RootFS.DiffIDs}
+		if len(e.RepoTags) > 0 {
+			img.Name = e.RepoTags[0]
+		}
+		img.layerFiles = e.Layers
+		img.Layers = make([]Descriptor, len(e.Layers))
+		l.Images = append(l.Images, img)
+	}
+	return nil
+}
+
+// readBlob reads the blob desc refers to and checks its size and digest.
+func (l *Layout) readBlob(desc Descriptor) ([]byte, error) {
+	name, err := blobName(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readAll(l.src, name)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != desc.Size {
+		return nil, fmt.Errorf("blob %s: %d bytes, expected %d", desc.Digest, len(data), desc.Size)
+	}
+	if err := checkDigest(data, desc.Digest); err != nil {
+		return nil, fmt.Errorf("blob %s: %w", desc.Digest, err)
+	}
+	return data, nil
+}
+
+// blobName returns the name of the blob with digest in a layout.
+func blobName(digest string) (string, error) {
+	alg, hexDigest, ok := strings.Cut(digest, ":")
+	if !ok || hexDigest == "" || strings.ContainsAny(hexDigest, "/.") {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return "blobs/" + alg + "/" + hexDigest, nil
+}
+
+// newDigester returns a hash for the algorithm of digest.
+func newDigester(digest string) (hash.Hash, error) {
+	alg, _, _ := strings.Cut(digest, ":")
+	switch alg {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported digest algorithm in %q", digest)
+}
+
+func checkDigest(data []byte, digest string) error {
+	h, err := newDigester(digest)
+	if err != nil {
+		return err
+	}
+	h.Write(data)
+	return matchDigest(h, digest)
+}
+
+func matchDigest(h hash.Hash, digest string) error {
+	alg, _, _ := strings.Cut(digest, ":")
+	if got := alg + ":" + hex.EncodeToString(h.Sum(nil)); got != digest {
+		return fmt.Errorf("digest is %s, expected %s", got, digest)
+	}
+	return nil
+}
+
+func readAll(src source, name string) ([]byte, error) {
+	r, err := src.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
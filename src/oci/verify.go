@@ -0,0 +1,375 @@
+package oci
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"MTFS/store"
+)
+
+// Problem is a discrepancy found by Verify. Path is empty for problems
+// with a blob rather than a file, and Layer is empty for files only the
+// snapshot has.
+type Problem struct {
+	Image   string
+	Layer   string
+	Path    string
+	Message string
+}
+
+// Report is the outcome of Verify.
+type Report struct {
+	Images   int
+	Layers   int
+	Files    int // files of the images with their layers applied, and symlinks the snapshot has as files
+	Matched  int // files matching the snapshot
+	Problems []Problem
+}
+
+// entry is a path of an image's filesystem.
+type entry struct {
+	layer  int
+	kind   byte // tar type flag: regular file, directory, symlink or other
+	size   int64
+	hash   string // SHA-256 of regular files
+	target string // of symlinks
+}
+
+// Select keeps the images for platform, given as os/arch or
+// os/arch/variant. Images without a recorded platform are kept.
+func (l *Layout) Select(platform string) error {
+	var kept []Image
+	for _, img := range l.Images {
+		if img.Platform == "" || img.Platform == platform || strings.HasPrefix(img.Platform, platform+"/") {
+			kept = append(kept, img)
+		}
+	}
+	if len(kept) == 0 {
+		return fmt.Errorf("%s: no image for platform %s", l.Path, platform)
+	}
+	l.Images = kept
+	return nil
+}
+
+// Verify checks every layer of every image against its digest and diff_id
+// and, if snap is not nil, compares the files of the image with those of
+// the snapshot. A snapshot can only be compared with a single image, so
+// layouts with several need Select first.
+func (l *Layout) Verify(snap *store.Snapshot) (*Report, error) {
+	if snap != nil && len(l.Images) > 1 {
+		return nil, fmt.Errorf("%s has %d images; choose one platform to compare with the snapshot", l.Path, len(l.Images))
+	}
+	report := &Report{Images: len(l.Images)}
+	for _, img := range l.Images {
+		label := img.Name
+		if label == "" {
+			label = img.Config.Digest
+		}
+		if len(img.DiffIDs) != len(img.Layers) {
+			report.problem(label, "", "", fmt.Sprintf("config lists %d diff_ids for %d layers", len(img.DiffIDs), len(img.Layers)))
+		}
+
+		fs := map[string]*entry{}
+		for i, layer := range img.Layers {
+			report.Layers++
+			diffID := ""
+			if i < len(img.DiffIDs) {
+				diffID = img.DiffIDs[i]
+			}
+			if err := l.applyLayer(fs, i, img.layerName(i), layer, diffID, func(p, msg string) {
+				report.problem(label, img.layerName(i), p, msg)
+			}); err != nil {
+				return nil, err
+			}
+		}
+		if snap != nil {
+			report.compare(label, img, fs, snap)
+		}
+	}
+	return report, nil
+}
+
+func (r *Report) problem(image, layer, p, msg string) {
+	r.Problems = append(r.Problems, Problem{Image: image, Layer: layer, Path: p, Message: msg})
+}
+
+// layerName names layer i of img in reports: its digest, or its path in
+// a docker save tarball.
+func (img *Image) layerName(i int) string {
+	if img.layerFiles != nil {
+		return img.layerFiles[i]
+	}
+	return shortDigest(img.Layers[i].Digest)
+}
+
+// applyLayer reads layer i of an image in one pass, checking its digest,
+// size and diff_id, and applies its entries to fs, including whiteouts.
+// Problems with the layer are passed to report; the error is only set if
+// the layer cannot be read at all.
+func (l *Layout) applyLayer(fs map[string]*entry, i int, label string, layer Descriptor, diffID string, report func(p, msg string)) error {
+	var name string
+	var digester hash.Hash
+	if layer.Digest == "" {
+		name = label // Layers of docker save tarballs are named by path
+	} else {
+		var err error
+		if name, err = blobName(layer.Digest); err != nil {
+			return err
+		}
+		if digester, err = newDigester(layer.Digest); err != nil {
+			return err
+		}
+	}
+	raw, err := l.src.open(name)
+	if err != nil {
+		return fmt.Errorf("layer %s: %w", label, err)
+	}
+	defer raw.Close()
+
+	counter := &countingReader{r: raw}
+	var blob io.Reader = counter
+	if digester != nil {
+		blob = io.TeeReader(counter, digester)
+	}
+	br := bufio.NewReader(blob)
+	var content io.Reader = br
+	magic, _ := br.Peek(4)
+	switch {
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			report("", fmt.Sprintf("corrupt gzip stream: %v", err))
+			return nil
+		}
+		content = gz
+	case len(magic) == 4 && string(magic) == "\x28\xb5\x2f\xfd":
+		report("", "zstd-compressed layers are not supported")
+		return nil
+	}
+
+	var diffHash hash.Hash
+	if diffID != "" {
+		if diffHash, err = newDigester(diffID); err != nil {
+			return err
+		}
+		content = io.TeeReader(content, diffHash)
+	}
+
+	// The hashes cover the padding after the end of the archive too, and
+	// are checked even if the archive cannot be read, so damage to the
+	// blob is reported as such
+	err = applyTar(fs, i, tar.NewReader(content), report)
+	if err == nil {
+		_, err = io.Copy(io.Discard, content)
+	}
+	if err != nil {
+		report("", fmt.Sprintf("unreadable layer: %v", err))
+	}
+	io.Copy(io.Discard, br)
+
+	if digester != nil {
+		if counter.n != layer.Size {
+			report("", fmt.Sprintf("blob is %d bytes, expected %d", counter.n, layer.Size))
+		}
+		if err := matchDigest(digester, layer.Digest); err != nil {
+			report("", "blob "+err.Error())
+		}
+	}
+	if diffHash != nil {
+		if err := matchDigest(diffHash, diffID); err != nil {
+			report("", "uncompressed content does not match diff_id: "+err.Error())
+		}
+	}
+	return nil
+}
+
+// applyTar applies the entries of a layer to fs.
+func applyTar(fs map[string]*entry, layer int, tr *tar.Reader, report func(p, msg string)) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p := cleanPath(hdr.Name)
+		if p == "" {
+			continue
+		}
+		dir, base := path.Split(p)
+		dir = strings.TrimSuffix(dir, "/")
+
+		// An opaque whiteout hides what lower layers have in its
+		// directory, a whiteout hides the path it names
+		if base == ".wh..wh..opq" {
+			removeBelow(fs, dir, layer)
+			continue
+		}
+		if hidden, ok := strings.CutPrefix(base, ".wh."); ok {
+			target := path.Join(dir, hidden)
+			delete(fs, target)
+			removeBelow(fs, target, layer+1)
+			continue
+		}
+
+		e := &entry{layer: layer, kind: hdr.Typeflag}
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			h := sha256.New()
+			n, err := io.Copy(h, tr)
+			if err != nil {
+				return err
+			}
+			e.size = n
+			e.hash = hex.EncodeToString(h.Sum(nil))
+		case tar.TypeSymlink:
+			e.target = hdr.Linkname
+		case tar.TypeLink:
+			target, ok := fs[cleanPath(hdr.Linkname)]
+			if !ok || target.kind != tar.TypeReg {
+				report(p, fmt.Sprintf("hard link to %s, which is not a file of the image", hdr.Linkname))
+				continue
+			}
+			e.kind, e.size, e.hash = tar.TypeReg, target.size, target.hash
+		}
+		if old, ok := fs[p]; ok && old.kind == tar.TypeDir && e.kind != tar.TypeDir {
+			removeBelow(fs, p, layer+1)
+		}
+		fs[p] = e
+	}
+}
+
+// removeBelow removes the paths below dir added by layers before layer.
+func removeBelow(fs map[string]*entry, dir string, layer int) {
+	prefix := dir + "/"
+	if dir == "" {
+		prefix = ""
+	}
+	for p, e := range fs {
+		if strings.HasPrefix(p, prefix) && p != dir && e.layer < layer {
+			delete(fs, p)
+		}
+	}
+}
+
+// cleanPath makes a tar member name relative to the image's root.
+func cleanPath(name string) string {
+	p := path.Clean("/" + name)
+	return strings.TrimPrefix(p, "/")
+}
+
+// compare checks the files of fs against snap.
+func (r *Report) compare(label string, img Image, fs map[string]*entry, snap *store.Snapshot) {
+	files := map[string]store.SnapshotFile{}
+	for _, f := range snap.Files {
+		files[f.Path] = f
+	}
+	layerOf := func(e *entry) string {
+		return img.layerName(e.layer)
+	}
+
+	paths := make([]string, 0, len(fs))
+	for p := range fs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		e := fs[p]
+		f, inSnapshot := files[p]
+		if e.kind == tar.TypeSymlink && inSnapshot {
+			// Trees built following symlinks record them as files
+			if target := resolve(fs, p); target != nil {
+				e = target
+			}
+		}
+		if e.kind != tar.TypeReg {
+			if inSnapshot {
+				r.problem(label, layerOf(e), p, fmt.Sprintf("is a %s in the image but a file in the snapshot", kindName(e.kind)))
+			}
+			continue
+		}
+		r.Files++
+		switch {
+		case !inSnapshot:
+			r.problem(label, layerOf(e), p, "not in the snapshot")
+		case f.Size != e.size || f.ContentHash != e.hash:
+			r.problem(label, layerOf(e), p, fmt.Sprintf("content %s (%d bytes) differs from the snapshot's %s (%d bytes)", e.hash, e.size, f.ContentHash, f.Size))
+		default:
+			r.Matched++
+		}
+	}
+
+	var missing []string
+	for p := range files {
+		if _, ok := fs[p]; !ok {
+			missing = append(missing, p)
+		}
+	}
+	sort.Strings(missing)
+	for _, p := range missing {
+		r.problem(label, "", p, "in the snapshot but not in the image")
+	}
+}
+
+// resolve follows the symlink at p within the image's filesystem and
+// returns the entry it ends at, or nil if it dangles or loops.
+func resolve(fs map[string]*entry, p string) *entry {
+	for hops := 0; hops < 40; hops++ {
+		e, ok := fs[p]
+		if !ok {
+			return nil
+		}
+		if e.kind != tar.TypeSymlink {
+			return e
+		}
+		target := e.target
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		p = cleanPath(target)
+	}
+	return nil
+}
+
+func kindName(kind byte) string {
+	switch kind {
+	case tar.TypeDir:
+		return "directory"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeChar, tar.TypeBlock:
+		return "device"
+	case tar.TypeFifo:
+		return "fifo"
+	}
+	return "special file"
+}
+
+// shortDigest abbreviates a digest for reports.
+func shortDigest(digest string) string {
+	if alg, hexDigest, ok := strings.Cut(digest, ":"); ok && len(hexDigest) > 12 {
+		return alg + ":" + hexDigest[:12]
+	}
+	return digest
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,275 @@
+// Package oplog keeps the operation log: an append-only record of every
+// operation run on a tree, with who ran it, when, its parameters, its
+// result and the root hash it produced. Each entry holds the hash of the
+// entry before it and its own hash over both, so editing, removing or
+// reordering entries breaks the chain, and pinning the hash of the last
+// entry (the head) also exposes entries cut off the end.
+package oplog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"MTFS/filelock"
+	"MTFS/forensic"
+)
+
+// FileEnv names the environment variable overriding the location of the
+// operation log; "off" disables it.
+const FileEnv = "MTFS_OPLOG"
+
+// Results of an operation.
+const (
+	OK     = "ok"
+	Failed = "failed"
+)
+
+// Entry is an operation in the log.
+type Entry struct {
+	Seq       int64             `json:"seq"`
+	Time      string            `json:"time"`
+	User      string            `json:"user"`
+	Host      string            `json:"host"`
+	Operation string            `json:"operation"`
+	Tree      string            `json:"tree,omitempty"`
+	Params    map[string]string `json:"params,omitempty"`
+	Result    string            `json:"result"`
+	Error     string            `json:"error,omitempty"`
+	RootHash  string            `json:"root_hash,omitempty"`
+	Duration  string            `json:"duration,omitempty"`
+	Report    string            `json:"report,omitempty"`        // report file in the tree's state directory
+	ReportSum string            `json:"report_sha256,omitempty"` // SHA-256 of the report file
+	Prev      string            `json:"prev"`
+	Hash      string            `json:"hash,omitempty"`
+}
+
+// digest returns the hash of e, computed over every field but Hash.
+func (e Entry) digest() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// DefaultPath returns the operation log used unless FileEnv says
+// otherwise.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "mtfs", "operations.log"), nil
+}
+
+// Path returns the operation log in use, or "" if it is off. In forensic
+// mode it is the case's chain-of-custody log, which cannot be turned off.
+func Path() (string, error) {
+	if forensic.Dir() != "" {
+		return forensic.CustodyLog(), nil
+	}
+	switch name := os.Getenv(FileEnv); name {
+	case "off":
+		return "", nil
+	case "":
+		return DefaultPath()
+	default:
+		return name, nil
+	}
+}
+
+// Record appends e to the operation log in use, unless it is off. In
+// forensic mode the case directory must exist already and lie outside
+// the tree of e.
+func Record(e Entry) (Entry, error) {
+	name, err := Path()
+	if err != nil || name == "" {
+		return e, err
+	}
+	if dir := forensic.Dir(); dir != "" {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return e, fmt.Errorf("forensic mode: the case directory %s does not exist", dir)
+		}
+		if err := forensic.CheckTree(e.Tree); e.Tree != "" && err != nil {
+			return e, err
+		}
+	}
+	return Append(name, e)
+}
+
+// Append adds e to the log at name, filling in its sequence number, the
+// time and user if unset, and the chain hashes. Writers are serialized
+// with an exclusive lock on the file, so concurrent runs extend one chain.
+func Append(name string, e Entry) (Entry, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+		return e, err
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return e, err
+	}
+	defer f.Close()
+	if err := filelock.Lock(f); err != nil {
+		return e, fmt.Errorf("locking %s: %w", name, err)
+	}
+	defer filelock.Unlock(f)
+
+	last, err := lastLine(f)
+	if err != nil {
+		return e, fmt.Errorf("%s: %w", name, err)
+	}
+	e.Seq, e.Prev = 1, ""
+	if last != nil {
+		var prev Entry
+		if err := json.Unmarshal(last, &prev); err != nil {
+			return e, fmt.Errorf("%s: last entry: %w", name, err)
+		}
+		e.Seq, e.Prev = prev.Seq+1, prev.Hash
+	}
+	if e.Time == "" {
+		e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	if e.User == "" {
+		e.User = currentUser()
+	}
+	if e.Host == "" {
+		e.Host, _ = os.Hostname()
+	}
+	if e.Hash, err = e.digest(); err != nil {
+		return e, err
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return e, err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return e, err
+	}
+	return e, f.Sync()
+}
+
+// lastLine returns the last entry of f, nil if f is empty.
+func lastLine(f *os.File) ([]byte, error) {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return nil, err
+	}
+	for window := int64(64 << 10); ; window *= 4 {
+		start := max(info.Size()-window, 0)
+		buf := make([]byte, info.Size()-start)
+		if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+		if buf[len(buf)-1] != '\n' {
+			return nil, errors.New("the last entry is incomplete")
+		}
+		buf = buf[:len(buf)-1]
+		if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+			return buf[i+1:], nil
+		}
+		if start == 0 {
+			return buf, nil
+		}
+	}
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// Read returns the entries of the log at name, oldest first, without
+// checking the chain; Verify does that.
+func Read(name string) ([]Entry, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return entries, fmt.Errorf("%s: entry %d: %w", name, n, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Report is the outcome of verifying a log.
+type Report struct {
+	Entries int
+	Head    Entry // last entry, zero if the log is empty
+}
+
+// Verify checks every entry of the log at name: its hash, its link to
+// the entry before it and its sequence number. If head is not empty, the
+// log must also hold an entry with that hash, so entries removed from
+// the end since head was noted are detected.
+func Verify(name, head string) (*Report, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	report := &Report{}
+	foundHead := head == ""
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err == io.EOF {
+			return report, fmt.Errorf("entry %d is incomplete", n)
+		}
+		if err != nil {
+			return report, err
+		}
+		line = line[:len(line)-1]
+
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return report, fmt.Errorf("entry %d: %w", n, err)
+		}
+		if canonical, err := json.Marshal(e); err != nil || !bytes.Equal(canonical, line) {
+			return report, fmt.Errorf("entry %d was altered: it is not as it was written", n)
+		}
+		if e.Seq != int64(n) {
+			return report, fmt.Errorf("entry %d has sequence number %d: entries were removed or reordered", n, e.Seq)
+		}
+		if e.Prev != report.Head.Hash {
+			return report, fmt.Errorf("entry %d does not follow entry %d: the chain is broken", n, n-1)
+		}
+		if digest, err := e.digest(); err != nil || digest != e.Hash {
+			return report, fmt.Errorf("entry %d was altered: its hash does not match", n)
+		}
+		report.Entries++
+		report.Head = e
+		foundHead = foundHead || e.Hash == head
+	}
+	if !foundHead {
+		return report, fmt.Errorf("no entry has hash %s: entries were removed from the end", head)
+	}
+	return report, nil
+}
@@ -0,0 +1,52 @@
+// Package paths gives a tree one identity however its path is spelled.
+// On Windows a directory can be named with either drive letter case,
+// with the long-path prefix \\?\ and, on the usual case-insensitive
+// volumes, with any case at all; elsewhere the absolute path is enough.
+package paths
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Abs returns the absolute, cleaned form of p. On Windows the long-path
+// prefix is dropped, \\?\UNC\server\share becoming \\server\share, and the
+// drive letter is upper-cased.
+func Abs(p string) (string, error) {
+	if runtime.GOOS == "windows" {
+		p = stripLongPrefix(p)
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "windows" && len(abs) >= 2 && abs[1] == ':' {
+		abs = strings.ToUpper(abs[:1]) + abs[1:]
+	}
+	return abs, nil
+}
+
+// Key returns what identifies abs, a path returned by Abs: on Windows the
+// path in lower case, elsewhere abs itself.
+func Key(abs string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToLower(abs)
+	}
+	return abs
+}
+
+// Same reports whether a and b, both as returned by Abs, name the same
+// file.
+func Same(a, b string) bool {
+	return Key(a) == Key(b)
+}
+
+// stripLongPrefix removes the \\?\ prefix, which Windows uses to lift the
+// limit of 260 characters on paths.
+func stripLongPrefix(p string) string {
+	if unc, ok := strings.CutPrefix(p, `\\?\UNC\`); ok {
+		return `\\` + unc
+	}
+	return strings.TrimPrefix(p, `\\?\`)
+}
@@ -0,0 +1,682 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"MTFS/forensic"
+	"MTFS/quota"
+	"MTFS/state"
+)
+
+const (
+	skippedFile     = "skipped" // entries left out by the last build, in the state directory
+	skippedHeader   = "MTFS-SKIPPED 1"
+	attestationFile = "INTEGRITY.mtfs.json" // signed root hash in the tree root, never hashed
+	rejectedListed  = 5                     // special files named when the policy rejects them
+)
+
+// Builder builds trees. Its zero value is not ready for use; NewBuilder
+// returns one with the backend's defaults.
+type Builder struct {
+	Options   *Options // walk options; nil for those recorded by the tree's last build
+	ChunkSize int64
+	Workers   int  // entries walked and hashed at once, the calling goroutine's included
+	DryRun    bool // only size files, recording nothing
+	Prescan   bool // total the files first, so progress lines have an ETA
+	HashCache bool // take the files unchanged since the last build from its hash cache
+
+	// Progress receives progress lines during a build and the trees it
+	// builds verify; nil for none.
+	Progress func(line string)
+}
+
+// NewBuilder returns a Builder with the default chunk size, a worker per
+// CPU and the pre-scan and hash cache on.
+func NewBuilder() *Builder {
+	return &Builder{ChunkSize: DefaultChunkSize, Workers: runtime.NumCPU(), Prescan: true, HashCache: true}
+}
+
+// SetChunkSize sets the chunk size, which must be within MinChunkSize
+// and MaxChunkSize.
+func (b *Builder) SetChunkSize(size int64) error {
+	if size < MinChunkSize || size > MaxChunkSize {
+		return fmt.Errorf("Invalid chunk size. Must be between %d and %d bytes", MinChunkSize, MaxChunkSize)
+	}
+	b.ChunkSize = size
+	return nil
+}
+
+// LoadEnv applies MTFS_CHUNK_SIZE, MTFS_WORKERS, MTFS_PRESCAN and
+// MTFS_HASH_CACHE to b, as the backend reads them.
+func (b *Builder) LoadEnv() error {
+	if chunk := os.Getenv("MTFS_CHUNK_SIZE"); chunk != "" {
+		size, err := quota.ParseSize(chunk)
+		if err != nil {
+			return fmt.Errorf("MTFS_CHUNK_SIZE: invalid size %s", chunk)
+		}
+		if err := b.SetChunkSize(size); err != nil {
+			return fmt.Errorf("MTFS_CHUNK_SIZE: %w", err)
+		}
+	}
+	if workers := os.Getenv("MTFS_WORKERS"); workers != "" {
+		count, err := strconv.Atoi(workers)
+		if err != nil || count <= 0 || strings.Trim(workers, "0123456789") != "" {
+			return errors.New("MTFS_WORKERS must be a positive number")
+		}
+		b.Workers = count
+	}
+	if prescan := os.Getenv("MTFS_PRESCAN"); prescan != "" {
+		on, ok := parseSwitch(prescan)
+		if !ok {
+			return errors.New("MTFS_PRESCAN must be 1 or 0")
+		}
+		b.Prescan = on
+	}
+	if cache := os.Getenv("MTFS_HASH_CACHE"); cache != "" {
+		on, ok := parseSwitch(cache)
+		if !ok {
+			return errors.New("MTFS_HASH_CACHE must be 1 or 0")
+		}
+		b.HashCache = on
+	}
+	return nil
+}
+
+// Build walks dir and hashes what its options keep in scope. Unless b is
+// a dry run, the walk options and skipped entries are recorded in the
+// state directory and the build is added to the tree's metrics.
+func (b *Builder) Build(dir string) (*Tree, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Directory does not exist: %s", dir)
+	}
+	if !info.IsDir() {
+		if isArchive(dir) {
+			return nil, fmt.Errorf("archive %s: %w", dir, ErrUnsupported)
+		}
+		return nil, fmt.Errorf("Path is not a directory or archive: %s", dir)
+	}
+
+	// Total the files to hash first, so progress lines can show an ETA
+	var expected *Stats
+	if !b.DryRun && b.Prescan {
+		scan := *b
+		scan.DryRun, scan.Progress = true, nil
+		if t, err := scan.Build(dir); err == nil {
+			s := t.Stats()
+			expected = &s
+			b.progress(fmt.Sprintf("Pre-scan: %d files, %s to hash", s.Files, FormatSize(s.Bytes)))
+		}
+		// Otherwise the build runs into the same problem and reports it
+	}
+
+	t := &Tree{Dir: dir, ChunkSize: b.ChunkSize, DryRun: b.DryRun, progress: b.Progress, links: map[string]string{}}
+	if b.Options != nil {
+		t.Options = *b.Options
+	} else {
+		t.Options, _ = LoadOptions(dir)
+	}
+	config, found, err := loadTreeConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		t.ConfigFile = config.path
+		config.apply(&t.Options, &t.ChunkSize)
+	}
+	if t.Options.HashFormat.Git() && (t.Options.Xattrs || t.Options.SpecialFiles == RecordSpecialFiles) {
+		return nil, errors.New("Git trees cannot hold extended attributes or special files; " +
+			"build without --xattrs and --special-files record")
+	}
+	if names := t.Options.unsupported(); names != "" {
+		return nil, fmt.Errorf("%s: %w", names, ErrUnsupported)
+	}
+
+	w := &walker{tree: t, root: dir, dryRun: b.DryRun, meter: newMeter("Build", b.Progress),
+		slots: make(chan struct{}, max(0, b.Workers-1)), linked: map[[2]uint64]*linkedHash{}}
+	if expected != nil {
+		w.meter.expect(int64(expected.Files), expected.Bytes)
+	}
+	if !b.DryRun {
+		// Without the cache every file is hashed, and the cache written
+		// for the builds after
+		w.cache = loadHashCache(dir, t.ChunkSize, t.Options.HashFormat, b.HashCache)
+	}
+	w.rootDev, _, _ = fileIdentity(info)
+
+	root, err := w.buildNode(dir, walkContext{})
+	if len(w.rejected) > 0 {
+		sort.Strings(w.rejected)
+		listed := strings.Join(w.rejected[:min(len(w.rejected), rejectedListed)], ", ")
+		if len(w.rejected) > rejectedListed {
+			listed += fmt.Sprintf(" and %d more", len(w.rejected)-rejectedListed)
+		}
+		return nil, errors.New("Special files are not allowed by the tree's policy: " + listed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.Root = root
+	// Hard links count once, in a dry run as in a build
+	markHardlinks(root, "", map[[2]uint64]string{})
+	if b.DryRun {
+		t.BuildThroughput = w.meter.finish()
+		return t, nil
+	}
+
+	root.CalculateHash(t.Options.HashFormat)
+	if t.Options.Save(dir) != nil || t.saveSkipped() != nil {
+		t.Warnings = append(t.Warnings, "could not record walk options and skipped entries in "+state.Dir(dir))
+	}
+	if w.cache.save(dir) != nil {
+		t.Warnings = append(t.Warnings, "could not write the hash cache in "+state.Dir(dir))
+	}
+	t.BuildThroughput = w.meter.finish()
+	t.recordMetrics("build", true, t.BuildThroughput)
+	return t, nil
+}
+
+// progress reports line if b has a Progress function.
+func (b *Builder) progress(line string) {
+	if b.Progress != nil {
+		b.Progress(line)
+	}
+}
+
+// isArchive reports whether path is a zip, gzip or tar file, which only
+// the backend can build trees of.
+func isArchive(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	return bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")) ||
+		bytes.HasPrefix(head, []byte{0x1f, 0x8b}) || (n == 512 && string(head[257:262]) == "ustar")
+}
+
+// walker holds the state of one build.
+type walker struct {
+	tree    *Tree
+	root    string
+	rootDev uint64
+	dryRun  bool
+	meter   *meter
+	cache   *hashCache    // nil for dry runs and updates
+	slots   chan struct{} // a token per goroutine walking a subtree besides the calling one
+
+	mu       sync.Mutex // guards the fields below and the skips of tree
+	rejected []string   // special files the policy rejects
+	linked   map[[2]uint64]*linkedHash
+}
+
+// walkContext is what a directory hands down to its entries.
+type walkContext struct {
+	ignore    ignoreRules // rules in effect in the parent directory
+	included  bool        // an ancestor directory matched an include pattern
+	depth     int         // depth of the entry below the tree root
+	ancestors *ancestor   // directories from the parent up to the root
+}
+
+// ancestor is a directory on the path from the tree root to an entry.
+type ancestor struct {
+	dev, ino uint64
+	relPath  string // "" for the root
+	parent   *ancestor
+}
+
+// linkedHash is the content of a file with several hard links, hashed
+// by the first path of it the walk reaches.
+type linkedHash struct {
+	done   chan struct{}
+	hashed fileHash
+	err    error
+}
+
+// fileHash is the hashed content of a file.
+type fileHash struct {
+	content string
+	size    int64
+	chunks  []string
+}
+
+// relPath returns the slash-separated path of p below the tree root.
+func (w *walker) relPath(p string) string {
+	rel, err := filepath.Rel(w.root, p)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// buildNode builds the node of the entry at p.
+func (w *walker) buildNode(p string, ctx walkContext) (*Node, error) {
+	name := filepath.Base(p)
+	o := &w.tree.Options
+	// The tree root is always followed, even when it is a link itself
+	if o.Symlinks == RecordSymlinks && p != w.root {
+		if lst, err := os.Lstat(p); err == nil && lst.Mode()&fs.ModeSymlink != 0 {
+			target, err := os.Readlink(p)
+			if err != nil {
+				return nil, err
+			}
+			return &Node{Name: name, IsFile: true, Symlink: true, Target: target}, nil
+		}
+	}
+
+	info, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errors.New("vanished during the walk")
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case info.Mode().IsRegular():
+		node := &Node{Name: name, IsFile: true, Executable: info.Mode()&0o100 != 0}
+		dev, ino, links := fileIdentity(info)
+		if links > 1 {
+			node.dev, node.ino = dev, ino
+		}
+		if w.dryRun {
+			// Opening reads nothing, but finds what a build could not read
+			f, err := forensic.Open(p)
+			if err != nil {
+				return nil, err
+			}
+			f.Close()
+			node.Size = info.Size()
+		} else {
+			rel, mtime := w.relPath(p), info.ModTime().UnixNano()
+			hashed, cached := fileHash{}, false
+			if w.cache != nil {
+				hashed, cached = w.cache.find(rel, info.Size(), mtime)
+			}
+			switch {
+			case cached:
+				w.meter.skip(hashed.size)
+				w.mu.Lock()
+				w.tree.Cached++
+				w.mu.Unlock()
+			case links > 1:
+				hashed, err = w.hashLinkedFile(p, dev, ino)
+			default:
+				hashed, err = w.hashFile(p)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if w.cache != nil {
+				w.cache.record(rel, mtime, hashed)
+			}
+			node.ContentHash, node.Size, node.ChunkHashes = hashed.content, hashed.size, hashed.chunks
+		}
+		w.meter.record(0, 1)
+		return node, nil
+	case info.IsDir():
+		node := &Node{Name: name, Children: map[string]*Node{}}
+		return node, w.buildChildren(node, p, ctx)
+	}
+
+	// Devices, sockets and FIFOs only get here when they are recorded
+	node := &Node{Name: name, IsFile: true, Special: "unknown"}
+	switch mode := info.Mode(); {
+	case mode&fs.ModeNamedPipe != 0:
+		node.Special = "fifo"
+	case mode&fs.ModeSocket != 0:
+		node.Special = "socket"
+	case mode&fs.ModeCharDevice != 0:
+		node.Special, node.Device = "char-device", deviceNumber(info)
+	case mode&fs.ModeDevice != 0:
+		node.Special, node.Device = "block-device", deviceNumber(info)
+	}
+	return node, nil
+}
+
+// buildChildren adds the entries of the directory at p in scope to node,
+// walking sibling subtrees on free workers.
+func (w *walker) buildChildren(node *Node, p string, ctx walkContext) error {
+	o := &w.tree.Options
+	relDir := w.relPath(p)
+	dirCtx := ctx
+	dirCtx.ignore = ctx.ignore.forDirectory(p, relDir)
+	// Below the depth limit or at a mount point a directory is kept, but
+	// its entries are not read
+	if o.MaxDepth > 0 && ctx.depth >= o.MaxDepth {
+		w.recordSkip("max depth", relDir)
+		return nil
+	}
+	if info, err := os.Stat(p); err == nil {
+		dev, ino, _ := fileIdentity(info)
+		if o.OneFileSystem && dev != w.rootDev {
+			w.recordSkip("other file system", relDir)
+			return nil
+		}
+		dirCtx.ancestors = &ancestor{dev: dev, ino: ino, relPath: relDir, parent: ctx.ancestors}
+	}
+
+	dirEntries, err := os.ReadDir(p)
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		path string
+		ctx  walkContext
+	}
+	var entries []entry
+	for _, e := range dirEntries {
+		entryPath := filepath.Join(p, e.Name())
+		if childCtx, ok := w.admit(entryPath, e.Type()&fs.ModeSymlink != 0, ctx, dirCtx); ok {
+			entries = append(entries, entry{entryPath, childCtx})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].path < entries[j].path })
+
+	// Start sibling subtrees on free workers, build the rest inline
+	type result struct {
+		node *Node
+		err  error
+		done chan struct{}
+	}
+	results := make([]*result, len(entries))
+	for i, e := range entries {
+		r := &result{done: make(chan struct{})}
+		results[i] = r
+		select {
+		case w.slots <- struct{}{}:
+			go func() {
+				defer close(r.done)
+				r.node, r.err = w.buildNode(e.path, e.ctx)
+				<-w.slots
+			}()
+		default:
+			r.node, r.err = w.buildNode(e.path, e.ctx)
+			close(r.done)
+		}
+	}
+
+	// Assemble in canonical order regardless of completion order
+	for i, r := range results {
+		<-r.done
+		if r.err == nil {
+			// Directories holding nothing in scope are left out entirely
+			if !r.node.IsFile && len(r.node.Children) == 0 && !entries[i].ctx.included {
+				continue
+			}
+			node.Children[r.node.Name] = r.node
+			continue
+		}
+		// An entry that cannot be read becomes an error leaf holding the
+		// reason, and the build goes on with the others
+		name := filepath.Base(entries[i].path)
+		leaf := &Node{Name: name, IsFile: true, Error: errorReason(r.err)}
+		node.Children[name] = leaf
+		relPath := name
+		if relDir != "" {
+			relPath = relDir + "/" + name
+		}
+		w.mu.Lock()
+		w.tree.Unreadable = append(w.tree.Unreadable, Skip{Reason: leaf.Error, Path: relPath})
+		w.mu.Unlock()
+	}
+	return nil
+}
+
+// admit reports whether the entry at entryPath, a link if isLink, is in
+// the scope of the walk options, recording why if it is not. ctx is the
+// context of the directory holding it and dirCtx the one the directory
+// hands down; the context of the entry is returned.
+func (w *walker) admit(entryPath string, isLink bool, ctx, dirCtx walkContext) (walkContext, bool) {
+	o := &w.tree.Options
+	name := filepath.Base(entryPath)
+	relPath := w.relPath(entryPath)
+	relDir := path.Dir(relPath)
+	// MTFS state is never part of the tree, nor is the attestation badge
+	// stating its root hash, and Git never stores the repository itself
+	// in its trees
+	if name == state.DirName || (relDir == "." && name == attestationFile) || (o.HashFormat.Git() && name == ".git") {
+		return dirCtx, false
+	}
+	if !o.Hidden && name[0] == '.' {
+		w.recordSkip("hidden", relPath)
+		return dirCtx, false
+	}
+	if !o.AppleMetadata && isAppleMetadataName(name) {
+		w.recordSkip("apple metadata", relPath)
+		return dirCtx, false
+	}
+	if isLink && o.Symlinks == SkipSymlinks {
+		w.recordSkip("symlink", relPath)
+		return dirCtx, false
+	}
+	recordedLink := isLink && o.Symlinks == RecordSymlinks
+	target, statErr := os.Stat(entryPath)
+	// Recorded links are leaves, whatever they point to
+	isDir := !recordedLink && statErr == nil && target.IsDir()
+	if len(dirCtx.ignore) > 0 && dirCtx.ignore.ignored(relPath, isDir) {
+		w.recordSkip(IgnoreFile, relPath)
+		return dirCtx, false
+	}
+	if o.isExcluded(relPath) {
+		w.recordSkip("excluded", relPath)
+		return dirCtx, false
+	}
+	if isLink && o.Symlinks == FollowSymlinks && errors.Is(statErr, fs.ErrNotExist) {
+		w.recordSkip("broken symlink", relPath)
+		return dirCtx, false
+	}
+	if isDir && w.leadsToAncestor(target, relPath, dirCtx.ancestors) {
+		return dirCtx, false
+	}
+	if isLink && o.Symlinks == FollowSymlinks {
+		// Updates to what a link leads to are updates to the link
+		if real, err := filepath.EvalSymlinks(entryPath); err == nil {
+			w.mu.Lock()
+			w.tree.links[relPath] = real
+			w.mu.Unlock()
+		}
+	}
+
+	// Directories are walked even if they do not match an include
+	// pattern, since files further down still might
+	childCtx := dirCtx
+	childCtx.included = ctx.included || o.isIncluded(relPath)
+	childCtx.depth = ctx.depth + 1
+	if !childCtx.included && !isDir {
+		w.recordSkip("not included", relPath)
+		return dirCtx, false
+	}
+	regular := statErr == nil && target.Mode().IsRegular()
+	if !isDir && !recordedLink && statErr == nil && !regular {
+		if o.SpecialFiles == SkipSpecialFiles {
+			w.recordSkip("special file", relPath)
+			return dirCtx, false
+		}
+		if o.SpecialFiles == RejectSpecialFiles {
+			w.mu.Lock()
+			w.rejected = append(w.rejected, relPath)
+			w.mu.Unlock()
+			return dirCtx, false
+		}
+	}
+	if !isDir && !recordedLink && regular {
+		if limit := o.sizeLimit(target.Size()); limit != "" {
+			w.recordSkip(limit, relPath)
+			return dirCtx, false
+		}
+	}
+	return childCtx, true
+}
+
+// errorReason returns why an entry could not be read, worded as the
+// backend words system errors, such as "Permission denied".
+func errorReason(err error) string {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	reason := err.Error()
+	if reason == "" {
+		return reason
+	}
+	return strings.ToUpper(reason[:1]) + reason[1:]
+}
+
+// isAppleMetadataName reports whether name is AppleDouble metadata
+// (._name), a .DS_Store file or a __MACOSX directory.
+func isAppleMetadataName(name string) bool {
+	return strings.HasPrefix(name, "._") || name == ".DS_Store" || name == "__MACOSX"
+}
+
+// leadsToAncestor reports whether target, the directory an entry leads
+// to, is one being walked, recording the cycle if it is.
+func (w *walker) leadsToAncestor(target os.FileInfo, relPath string, ancestors *ancestor) bool {
+	dev, ino, _ := fileIdentity(target)
+	if ino == 0 {
+		return false
+	}
+	for dir := ancestors; dir != nil; dir = dir.parent {
+		if dir.dev == dev && dir.ino == ino {
+			to := dir.relPath
+			if to == "" {
+				to = "."
+			}
+			w.mu.Lock()
+			w.tree.Cycles = append(w.tree.Cycles, relPath+" -> "+to)
+			w.mu.Unlock()
+			w.recordSkip("symlink cycle", relPath)
+			return true
+		}
+	}
+	return false
+}
+
+// recordSkip records an entry left out of the tree.
+func (w *walker) recordSkip(reason, relPath string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.tree.Skipped = append(w.tree.Skipped, Skip{Reason: reason, Path: relPath})
+}
+
+// hashLinkedFile hashes a file with several hard links only once per
+// build.
+func (w *walker) hashLinkedFile(p string, dev, ino uint64) (fileHash, error) {
+	key := [2]uint64{dev, ino}
+	w.mu.Lock()
+	l, seen := w.linked[key]
+	if !seen {
+		l = &linkedHash{done: make(chan struct{})}
+		w.linked[key] = l
+	}
+	w.mu.Unlock()
+
+	if !seen {
+		l.hashed, l.err = w.hashFile(p)
+		close(l.done)
+	}
+	<-l.done
+	return l.hashed, l.err
+}
+
+// hashFile hashes the content of the file at p and each of its chunks.
+func (w *walker) hashFile(p string) (fileHash, error) {
+	f, err := forensic.Open(p)
+	if err != nil {
+		return fileHash{}, err
+	}
+	defer f.Close()
+
+	// The blob id hashes the size first, and progress shows the share
+	// of the file hashed, so it is taken up front
+	info, err := f.Stat()
+	if err != nil {
+		return fileHash{}, err
+	}
+	size := info.Size()
+	format := w.tree.Options.HashFormat
+	var content hash.Hash
+	if !format.Git() {
+		content = format.New()
+	} else {
+		content = newGitHasher(format)
+		content.Write([]byte("blob " + strconv.FormatInt(size, 10) + "\x00"))
+	}
+	lane := w.meter.begin(w.relPath(p), size)
+	defer w.meter.end(lane)
+
+	var hashed fileHash
+	buffer := make([]byte, w.tree.ChunkSize)
+	for {
+		n, err := io.ReadFull(f, buffer)
+		if n > 0 {
+			hashed.chunks = append(hashed.chunks, format.Sum(buffer[:n]))
+			content.Write(buffer[:n])
+			hashed.size += int64(n)
+			w.meter.advance(lane, int64(n))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return fileHash{}, errors.New("read failed")
+		}
+	}
+	if format.Git() && hashed.size != size {
+		return fileHash{}, errors.New("changed during the walk")
+	}
+	hashed.content = hex.EncodeToString(content.Sum(nil))
+	return hashed, nil
+}
+
+// markHardlinks marks the files sharing an inode with an earlier path of
+// the tree, seen holding the first path of each inode.
+func markHardlinks(n *Node, relPath string, seen map[[2]uint64]string) {
+	if n.IsFile {
+		n.HardlinkOf = ""
+		if n.ino != 0 {
+			key := [2]uint64{n.dev, n.ino}
+			if first, ok := seen[key]; ok {
+				n.HardlinkOf = first
+			} else {
+				seen[key] = relPath
+			}
+		}
+		return
+	}
+	// Children are visited in name order, so the first path is
+	// deterministic
+	for _, name := range n.SortedNames() {
+		p := name
+		if relPath != "" {
+			p = relPath + "/" + name
+		}
+		markHardlinks(n.Children[name], p, seen)
+	}
+}
+
+// saveSkipped writes the entries the build of t skipped to the state
+// directory, sorted by path.
+func (t *Tree) saveSkipped() error {
+	var b strings.Builder
+	b.WriteString(skippedHeader + "\n")
+	for _, s := range t.SkippedPaths() {
+		fmt.Fprintf(&b, "%s\t%s\n", s.Reason, escapeField(s.Path))
+	}
+	return state.WriteFile(t.Dir, skippedFile, []byte(b.String()))
+}
@@ -0,0 +1,239 @@
+package merkle
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"MTFS/protocol"
+)
+
+// JSON exports t in the backend's JSON layout: the root object keyed by
+// its name, each node with its type, hash and what its type records,
+// children in name order. The root records the hash format of trees not
+// in the mtfs format, and the depth and size filters of the build. A tree
+// without a root exports as "{}".
+func (t *Tree) JSON() string {
+	if t.Root == nil {
+		return "{}"
+	}
+	var b strings.Builder
+	b.WriteString("{\n")
+	writeJSON(&b, t.Root, 1, &t.Options)
+	b.WriteString("\n}")
+	return b.String()
+}
+
+// writeJSON writes n as a member of a JSON object indented by depth, with
+// what the root records of o, which is nil below the root.
+func writeJSON(b *strings.Builder, n *Node, depth int, o *Options) {
+	indent := strings.Repeat("  ", depth)
+	childIndent := indent + "  "
+	fmt.Fprintf(b, "%s\"%s\": {\n", indent, jsonEscape(n.Name))
+	fmt.Fprintf(b, "%s\"type\": \"%s\",\n", childIndent, nodeType(n))
+	fmt.Fprintf(b, "%s\"hash\": \"%s\"", childIndent, n.Hash)
+	if o != nil && o.HashFormat != Mtfs {
+		fmt.Fprintf(b, ",\n%s\"hash_format\": \"%s\"", childIndent, o.HashFormat)
+	}
+	if o != nil {
+		for _, filter := range []struct {
+			key   string
+			limit int64
+		}{{"max_depth", int64(o.MaxDepth)}, {"min_file_size", o.MinFileSize}, {"max_file_size", o.MaxFileSize}} {
+			if filter.limit > 0 {
+				fmt.Fprintf(b, ",\n%s\"%s\": %d", childIndent, filter.key, filter.limit)
+			}
+		}
+	}
+
+	switch {
+	case n.Symlink:
+		fmt.Fprintf(b, ",\n%s\"target\": \"%s\"", childIndent, jsonEscape(n.Target))
+	case n.Special != "":
+		if n.Device != "" {
+			fmt.Fprintf(b, ",\n%s\"device\": \"%s\"", childIndent, n.Device)
+		}
+	case n.Error != "":
+		fmt.Fprintf(b, ",\n%s\"error\": \"%s\"", childIndent, jsonEscape(n.Error))
+	case n.IsFile:
+		fmt.Fprintf(b, ",\n%s\"size\": %d", childIndent, n.Size)
+		fmt.Fprintf(b, ",\n%s\"chunks\": %d", childIndent, len(n.ChunkHashes))
+		fmt.Fprintf(b, ",\n%s\"content_hash\": \"%s\"", childIndent, n.ContentHash)
+		if n.HardlinkOf != "" {
+			fmt.Fprintf(b, ",\n%s\"hardlink_of\": \"%s\"", childIndent, jsonEscape(n.HardlinkOf))
+		}
+	case len(n.Children) > 0:
+		fmt.Fprintf(b, ",\n%s\"children\": {\n", childIndent)
+		names := n.SortedNames()
+		for i, name := range names {
+			writeJSON(b, n.Children[name], depth+2, nil)
+			if i < len(names)-1 {
+				b.WriteString(",")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(childIndent + "}")
+	}
+	fmt.Fprintf(b, "\n%s}", indent)
+}
+
+// nodeType is the type of n as the export names it.
+func nodeType(n *Node) string {
+	switch {
+	case n.Symlink:
+		return "symlink"
+	case n.Special != "":
+		return n.Special
+	case n.Error != "":
+		return "error"
+	case n.IsFile:
+		return "file"
+	}
+	return "directory"
+}
+
+// List lists the directory at p in t, slash-separated and "." for the
+// root, as the list command of the protocol answers.
+func (t *Tree) List(p string) (*protocol.ListResult, error) {
+	dir := t.Root
+	if p != "." {
+		for _, name := range strings.Split(p, "/") {
+			if dir == nil || dir.IsFile {
+				break
+			}
+			dir = dir.Children[name]
+		}
+	}
+	if dir == nil || dir.IsFile {
+		return nil, fmt.Errorf("%s is not a directory of the tree", p)
+	}
+	res := &protocol.ListResult{Directory: listEntry(dir), Entries: []protocol.ListEntry{}}
+	for _, name := range dir.SortedNames() {
+		res.Entries = append(res.Entries, listEntry(dir.Children[name]))
+	}
+	return res, nil
+}
+
+// listEntry describes n for a listing.
+func listEntry(n *Node) protocol.ListEntry {
+	e := protocol.ListEntry{Name: n.Name, Type: nodeType(n), Hash: n.Hash}
+	switch e.Type {
+	case "directory":
+		e.Entries = len(n.Children)
+		e.Size = filesSize(n)
+	case "file":
+		e.Size, e.Chunks, e.ContentHash, e.HardlinkOf = n.Size, len(n.ChunkHashes), n.ContentHash, n.HardlinkOf
+	case "symlink":
+		e.Target = n.Target
+	case "error":
+		e.Error = n.Error
+	default:
+		e.Device = n.Device
+	}
+	return e
+}
+
+// filesSize totals the size of the files under the directory n, each
+// content shared by hardlinks once.
+func filesSize(n *Node) int64 {
+	var size int64
+	for _, child := range n.Children {
+		switch {
+		case !child.IsFile:
+			size += filesSize(child)
+		case child.HardlinkOf == "" && !child.Symlink && child.Special == "" && child.Error == "":
+			size += child.Size
+		}
+	}
+	return size
+}
+
+// jsonEscape escapes quotes, backslashes and control characters in a
+// JSON string, as the backend does.
+func jsonEscape(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20:
+			fmt.Fprintf(&b, "\\u%04x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// PrintTree writes the structure of t to out, a line per node indented
+// by its depth.
+func (t *Tree) PrintTree(out io.Writer) {
+	if t.Root != nil {
+		printNode(out, t.Root, 0)
+	}
+}
+
+// printNode writes n and the nodes below it.
+func printNode(out io.Writer, n *Node, depth int) {
+	line := strings.Repeat("  ", depth) + n.Name
+	switch {
+	case n.Symlink:
+		line += fmt.Sprintf(" (Symlink -> %s, Hash: %s...)", n.Target, prefix(n.Hash))
+	case n.Special != "":
+		line += " (Special: " + n.Special
+		if n.Device != "" {
+			line += " " + n.Device
+		}
+		line += fmt.Sprintf(", Hash: %s...)", prefix(n.Hash))
+	case n.Error != "":
+		line += " (Unreadable: " + n.Error + ")"
+	case n.IsFile:
+		line += fmt.Sprintf(" (File, Size: %d bytes, Hash: %s...)", n.Size, prefix(n.ContentHash))
+		if n.HardlinkOf != "" {
+			line += " [hardlink to " + n.HardlinkOf + "]"
+		}
+		if len(n.ChunkHashes) > 1 {
+			line += fmt.Sprintf(" [%d chunks]", len(n.ChunkHashes))
+		}
+	default:
+		line += fmt.Sprintf(" (Directory, Children: %d)", len(n.Children))
+	}
+	fmt.Fprintln(out, line)
+	for _, name := range n.SortedNames() {
+		printNode(out, n.Children[name], depth+1)
+	}
+}
+
+// prefix returns the first 8 characters of a hash.
+func prefix(hash string) string {
+	return hash[:min(len(hash), 8)]
+}
+
+// PrintFiles writes each distinct content of t to out, in content hash
+// order, with the file holding it and its chunk hashes.
+func (t *Tree) PrintFiles(out io.Writer) {
+	fmt.Fprintln(out, "\n=== File Objects ===")
+	files := t.Files()
+	hashes := make([]string, 0, len(files))
+	for h := range files {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+	for _, h := range hashes {
+		n := files[h]
+		fmt.Fprintf(out, "Content Hash: %s\n", h)
+		fmt.Fprintf(out, "  File: %s\n", n.Name)
+		fmt.Fprintf(out, "  Size: %d bytes\n", n.Size)
+		fmt.Fprintf(out, "  Chunks: %d\n", len(n.ChunkHashes))
+		if len(n.ChunkHashes) > 1 {
+			fmt.Fprintln(out, "  Chunk Hashes:")
+			for i, c := range n.ChunkHashes {
+				fmt.Fprintf(out, "    [%d] %s\n", i, c)
+			}
+		}
+		fmt.Fprintln(out)
+	}
+}
@@ -0,0 +1,194 @@
+package merkle
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFile is the gitignore-style list of exclusions a directory can
+// hold for itself and the directories below it.
+const IgnoreFile = ".mtfsignore"
+
+// Match reports whether the whole of path, slash-separated, matches
+// pattern, a gitignore-style glob using *, ?, [...], ** and backslash
+// escapes, as the backend matches them.
+func Match(pattern, path string) bool {
+	for len(pattern) > 0 {
+		if strings.HasPrefix(pattern, "**") {
+			pattern = strings.TrimLeft(pattern, "*")
+			if strings.HasPrefix(pattern, "/") {
+				// "**/" matches zero or more whole directories
+				pattern = pattern[1:]
+				if Match(pattern, path) {
+					return true
+				}
+				for i := 0; i < len(path); i++ {
+					if path[i] == '/' && Match(pattern, path[i+1:]) {
+						return true
+					}
+				}
+				return false
+			}
+			for i := 0; i <= len(path); i++ {
+				if Match(pattern, path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		switch pattern[0] {
+		case '*':
+			pattern = pattern[1:]
+			for i := 0; ; i++ {
+				if Match(pattern, path[i:]) {
+					return true
+				}
+				if i == len(path) || path[i] == '/' {
+					return false
+				}
+			}
+		case '?':
+			if path == "" || path[0] == '/' {
+				return false
+			}
+			pattern, path = pattern[1:], path[1:]
+		case '[':
+			if path == "" || path[0] == '/' {
+				return false
+			}
+			c := 1
+			negate := c < len(pattern) && (pattern[c] == '!' || pattern[c] == '^')
+			if negate {
+				c++
+			}
+			matched, first := false, true
+			for c < len(pattern) && (first || pattern[c] != ']') {
+				first = false
+				lo, hi := pattern[c], pattern[c]
+				if c+2 < len(pattern) && pattern[c+1] == '-' && pattern[c+2] != ']' {
+					hi = pattern[c+2]
+					c += 2
+				}
+				if path[0] >= lo && path[0] <= hi {
+					matched = true
+				}
+				c++
+			}
+			if c >= len(pattern) {
+				// Unterminated class: '[' is taken literally
+				if path[0] != '[' {
+					return false
+				}
+				pattern, path = pattern[1:], path[1:]
+				break
+			}
+			if matched == negate {
+				return false
+			}
+			pattern, path = pattern[c+1:], path[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if path == "" || pattern[0] != path[0] {
+				return false
+			}
+			pattern, path = pattern[1:], path[1:]
+		}
+	}
+	return path == ""
+}
+
+// scopeMatch matches an include or exclude pattern: patterns holding a
+// slash match the path from the tree root, others the name.
+func scopeMatch(pattern, relPath string) bool {
+	if strings.Contains(pattern, "/") {
+		return Match(strings.TrimPrefix(pattern, "/"), relPath)
+	}
+	return Match(pattern, relPath[strings.LastIndex(relPath, "/")+1:])
+}
+
+// ignoreRule is a line of an ignore file.
+type ignoreRule struct {
+	base     string // directory of the ignore file, relative to the tree root
+	pattern  string
+	negate   bool // "!pattern" brings back what an earlier rule ignored
+	dirOnly  bool // "pattern/" only matches directories
+	anchored bool // a slash but at the end ties the pattern to base
+}
+
+// ignoreRules are the rules in effect in a directory, those of the
+// directories above it first.
+type ignoreRules []ignoreRule
+
+// forDirectory returns the rules in effect inside dir, at relDir in the
+// tree: r followed by those of the ignore file of dir, if it has one.
+func (r ignoreRules) forDirectory(dir, relDir string) ignoreRules {
+	f, err := os.Open(filepath.Join(dir, IgnoreFile))
+	if err != nil {
+		return r
+	}
+	defer f.Close()
+
+	rules := append(ignoreRules(nil), r...)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		// Trailing spaces are ignored unless escaped
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		rule := ignoreRule{base: relDir}
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		} else if len(line) > 1 && line[0] == '\\' && (line[1] == '!' || line[1] == '#') {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = line[:len(line)-1]
+		}
+		rule.anchored = strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ignored reports whether the last rule matching the entry at relPath
+// excludes it.
+func (r ignoreRules) ignored(relPath string, isDir bool) bool {
+	result := false
+	for _, rule := range r {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		candidate := relPath
+		if rule.base != "" {
+			rest, ok := strings.CutPrefix(relPath, rule.base+"/")
+			if !ok {
+				continue
+			}
+			candidate = rest
+		}
+		if !rule.anchored {
+			candidate = candidate[strings.LastIndex(candidate, "/")+1:]
+		}
+		if Match(rule.pattern, candidate) {
+			result = !rule.negate
+		}
+	}
+	return result
+}
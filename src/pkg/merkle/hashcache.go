@@ -0,0 +1,110 @@
+package merkle
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"MTFS/state"
+)
+
+const (
+	hashCacheFile = "hashcache" // hashes of the files of the last build, in the state directory
+	// hashCacheRacy is how long before the cache was written a file must
+	// have been modified to be trusted: a file changed again within the
+	// same timestamp keeps its size and modification time
+	hashCacheRacy = 2 * time.Second
+)
+
+// hashCache holds the hashes of the files of the last build of a tree, so
+// a build only hashes the files whose size or modification time changed.
+// It is written in the format of the backend's build checkpoint, which the
+// backend keeps as the cache once a build succeeds, so either engine
+// rebuilds from the cache of the other.
+type hashCache struct {
+	header string
+	last   map[string]cacheEntry // of the last build
+
+	mu   sync.Mutex
+	next map[string]cacheEntry // of this build
+}
+
+// cacheEntry is a file of the cache.
+type cacheEntry struct {
+	size, mtime int64 // modification time in nanoseconds since the epoch
+	hashed      fileHash
+}
+
+// loadHashCache returns the cache of the tree at dir for files cut into
+// chunks of chunkSize bytes and hashed in format, with the entries of the
+// last build unless it is not to be used.
+func loadHashCache(dir string, chunkSize int64, format HashFormat, use bool) *hashCache {
+	c := &hashCache{header: fmt.Sprintf("MTFS-CHECKPOINT 2 %d %s", chunkSize, format),
+		last: map[string]cacheEntry{}, next: map[string]cacheEntry{}}
+	info, err := os.Stat(state.Path(dir, hashCacheFile))
+	if !use || err != nil {
+		return c
+	}
+	data, err := state.ReadFile(dir, hashCacheFile)
+	if err != nil {
+		return c
+	}
+	written := info.ModTime().Add(-hashCacheRacy).UnixNano()
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 64*1024*1024)
+	if !scanner.Scan() || scanner.Text() != c.header {
+		return c
+	}
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 6 || fields[0] != "F" || fields[3] == "" {
+			continue
+		}
+		size, err1 := strconv.ParseInt(fields[1], 10, 64)
+		mtime, err2 := strconv.ParseInt(fields[2], 10, 64)
+		if err1 != nil || err2 != nil || mtime >= written {
+			continue
+		}
+		e := cacheEntry{size: size, mtime: mtime, hashed: fileHash{content: fields[3], size: size}}
+		if fields[4] != "" {
+			e.hashed.chunks = strings.Split(fields[4], ",")
+		}
+		c.last[unescapeField(fields[5])] = e
+	}
+	return c
+}
+
+// find returns the hashes of the file at relPath if it has the size and
+// modification time it had in the last build.
+func (c *hashCache) find(relPath string, size, mtime int64) (fileHash, bool) {
+	e, ok := c.last[relPath]
+	if !ok || e.size != size || e.mtime != mtime {
+		return fileHash{}, false
+	}
+	return e.hashed, true
+}
+
+// record keeps the hashes of the file at relPath for the next build. It
+// is safe to call from several goroutines.
+func (c *hashCache) record(relPath string, mtime int64, hashed fileHash) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.next[relPath] = cacheEntry{size: hashed.size, mtime: mtime, hashed: hashed}
+}
+
+// save writes the files recorded in this build as the cache of the tree
+// at dir.
+func (c *hashCache) save(dir string) error {
+	var b strings.Builder
+	b.WriteString(c.header + "\n")
+	for relPath, e := range c.next {
+		fmt.Fprintf(&b, "F\t%d\t%d\t%s\t%s\t%s\n", e.size, e.mtime, e.hashed.content,
+			strings.Join(e.hashed.chunks, ","), escapeField(relPath))
+	}
+	return state.WriteFile(dir, hashCacheFile, []byte(b.String()))
+}
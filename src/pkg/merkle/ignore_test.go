@@ -0,0 +1,122 @@
+package merkle_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"MTFS/manifest"
+	"MTFS/pkg/merkle"
+	"MTFS/remote"
+)
+
+// ignoreTree has .mtfsignore files at the root and in sub, with negated,
+// anchored and directory-only patterns.
+var ignoreTree = map[string]string{
+	".mtfsignore":          "*.log\nbuild/\n**/cache/\n!keep.log\n!build/wanted\n",
+	"a.log":                "ignored\n",
+	"keep.log":             "negated\n",
+	"build/out":            "ignored directory\n",
+	"build/wanted":         "in an ignored directory\n",
+	"src/build":            "a file, not a directory\n",
+	"src/deep/cache/x":     "ignored at any depth\n",
+	"sub/.mtfsignore":      "*.tmp\n!important.log\n/local\n",
+	"sub/x.tmp":            "ignored below sub\n",
+	"sub/important.log":    "negated below sub\n",
+	"sub/other.log":        "ignored by the root\n",
+	"sub/local":            "anchored to sub\n",
+	"sub/deeper/local":     "not at the anchor\n",
+	"sub/deeper/y.tmp":     "ignored below sub\n",
+	"other/x.tmp":          "outside sub\n",
+	"other/.mtfsignore":    "!*.log\n",
+	"other/again.log":      "negated again\n",
+	"other/cache/file.txt": "ignored by **/cache/\n",
+}
+
+// ignoreKept are the files of ignoreTree the rules leave.
+var ignoreKept = []string{
+	".mtfsignore",
+	"keep.log",
+	"other/.mtfsignore",
+	"other/again.log",
+	"other/x.tmp",
+	"src/build",
+	"sub/.mtfsignore",
+	"sub/deeper/local",
+	"sub/important.log",
+}
+
+func writeIgnoreTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range ignoreTree {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// files returns the paths of the files of root, sorted.
+func files(root *manifest.Node) []string {
+	var paths []string
+	root.Walk(func(p string, node *manifest.Node) error {
+		if node.IsFile() {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	slices.Sort(paths)
+	return paths
+}
+
+// Nested .mtfsignore files add to the rules of the directories above,
+// and negations take back what an earlier rule ignored, but not below an
+// ignored directory.
+func TestIgnoreFiles(t *testing.T) {
+	dir := writeIgnoreTree(t)
+	tree, err := merkle.NewBuilder().Build(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := manifest.Parse([]byte(tree.JSON()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := files(root); !slices.Equal(got, ignoreKept) {
+		t.Errorf("kept %s\nwant %s", strings.Join(got, " "), strings.Join(ignoreKept, " "))
+	}
+}
+
+// The backend applies the same rules: its tree has the root hash of the
+// Go engine's. The backend to compare with is the one $MTFS_BACKEND
+// names.
+func TestIgnoreFilesBackend(t *testing.T) {
+	backend := os.Getenv(remote.BackendEnv)
+	if backend == "" {
+		t.Skip("set " + remote.BackendEnv + " to compare with the backend")
+	}
+	dir := writeIgnoreTree(t)
+	t.Setenv(remote.EngineEnv, "")
+	t.Setenv(remote.HashCacheEnv, "0")
+	root, err := remote.Build(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := files(root); !slices.Equal(got, ignoreKept) {
+		t.Errorf("the backend kept %s\nwant %s", strings.Join(got, " "), strings.Join(ignoreKept, " "))
+	}
+	tree, err := merkle.NewBuilder().Build(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Hash != tree.Root.Hash {
+		t.Errorf("the backend built root %s, the Go engine %s", root.Hash, tree.Root.Hash)
+	}
+}
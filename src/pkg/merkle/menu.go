@@ -0,0 +1,286 @@
+package merkle
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"MTFS/state"
+)
+
+const menu = `
+==== Merkle Tree File System CLI ====
+1. Build Merkle tree from directory
+2. Print tree structure
+3. Print file objects
+4. Show statistics
+5. Verify tree integrity
+6. Export tree to JSON
+7. Set chunk size
+8. Exit
+9. Preview build (dry run)
+10. List directory (JSON)
+Choose an option: `
+
+const usage = `Usage: %s [--include PATTERN]... [--exclude PATTERN]... [--symlinks POLICY]
+       [--hidden include|skip] [--apple-metadata include|skip] [--max-depth N]
+       [--min-file-size SIZE] [--max-file-size SIZE] [-x | --one-file-system]
+       [--special-files skip|record|error] [--hash-format FORMAT]
+  --include PATTERN     only hash files matching PATTERN
+  --exclude PATTERN     skip files and directories matching PATTERN
+  --symlinks POLICY     follow (default), record (hash the link target) or skip
+  --hidden MODE         include (default) or skip dotfiles and dot-directories
+  --apple-metadata MODE skip (default) or include AppleDouble ._ files, .DS_Store
+                        and __MACOSX
+  --max-depth N         do not read directories more than N levels below the root
+  --min-file-size SIZE  do not hash files smaller than SIZE (e.g. 1, 4K)
+  --max-file-size SIZE  do not hash files larger than SIZE (e.g. 512M, 2G)
+  -x, --one-file-system do not read directories on other file systems than the root
+  --special-files MODE  skip (default), record or error on devices, sockets and FIFOs
+  --hash-format FORMAT  mtfs (default, SHA-256), mtfs-sha512, mtfs-blake2b or
+                        mtfs-blake3, or git / git-sha256 for Git blob and tree ids
+Patterns containing '/' match the path from the tree root, others match the name.
+Without options a tree is built with the options recorded by its last build.
+Options can also be set as MTFS_* environment variables (MTFS_HASH_FORMAT=git,
+MTFS_EXCLUDE='*.tmp:build'), with MTFS_CHUNK_SIZE and MTFS_WORKERS besides.
+MTFS_PRESCAN=0 skips totalling the files before a build, which gives its ETA.
+MTFS_HASH_CACHE=0 hashes every file again instead of the changed ones only.
+`
+
+// RunMenu runs the numbered menu of the backend on stdin and stdout, so
+// the frontend can drive the Go engine as it drives the backend. args
+// are the walk options of its command line, program name first. It
+// returns the exit status: 0 when the menu is left or its input ends, 2
+// for invalid options.
+func RunMenu(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	builder, err := NewSession(args)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 2
+	}
+	builder.Progress = func(line string) { fmt.Fprintln(stdout, line) }
+
+	in := bufio.NewReader(stdin)
+	readLine := func() (string, bool) {
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", false
+		}
+		return strings.TrimRight(line, "\r\n"), true
+	}
+	warn := func(t *Tree) {
+		for _, w := range t.Warnings {
+			fmt.Fprintf(stderr, "Warning: %s\n", w)
+		}
+		t.Warnings = nil
+	}
+
+	var tree *Tree
+	for {
+		fmt.Fprint(stdout, menu)
+		line, ok := readLine()
+		if !ok {
+			// Input ran out (a pipe or /dev/null): nothing more will come
+			return 0
+		}
+		choice, err := strconv.Atoi(strings.TrimSpace(line))
+		if err != nil {
+			choice = 0
+		}
+		if tree == nil && (choice >= 2 && choice <= 6 || choice == 10) {
+			fmt.Fprintln(stdout, "Build the tree first (option 1).")
+			continue
+		}
+
+		switch choice {
+		case 1:
+			fmt.Fprint(stdout, "Enter directory path: ")
+			dir, _ := readLine()
+			built, err := builder.Build(dir)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error: %v\n", err)
+				break
+			}
+			tree = built
+			warn(tree)
+			s := tree.Stats()
+			fmt.Fprintln(stdout, "Merkle tree built successfully.")
+			fmt.Fprintf(stdout, "Root hash: %s\n", tree.Root.Hash)
+			fmt.Fprintf(stdout, "Tree size: %d files, %d directories, %d bytes\n", s.Files, s.Directories, s.Bytes)
+			if tree.Cached > 0 {
+				fmt.Fprintf(stdout, "Hash cache: %d unchanged files not hashed again.\n", tree.Cached)
+			}
+			printSkipped(stdout, tree, true)
+			fmt.Fprintf(stdout, "Scope: %s\n", tree.Options.Describe())
+			printConfig(stdout, tree)
+			fmt.Fprintf(stdout, "Build throughput: %s\n", FormatThroughput(tree.BuildThroughput))
+		case 2:
+			tree.PrintTree(stdout)
+		case 3:
+			tree.PrintFiles(stdout)
+		case 4:
+			printStats(stdout, tree)
+		case 5:
+			if tree.Verify() {
+				fmt.Fprintln(stdout, "Tree integrity verified: OK")
+			} else {
+				fmt.Fprintln(stdout, "Tree integrity check FAILED!")
+			}
+			warn(tree)
+			fmt.Fprintf(stdout, "Verify throughput: %s\n", FormatThroughput(tree.VerifyThroughput))
+		case 6:
+			fmt.Fprintln(stdout, tree.JSON())
+		case 7:
+			fmt.Fprint(stdout, "Enter new chunk size in bytes: ")
+			line, _ := readLine()
+			size, _ := strconv.ParseInt(strings.TrimSpace(line), 10, 64)
+			if err := builder.SetChunkSize(size); err != nil {
+				fmt.Fprintf(stderr, "Error: %v\n", err)
+				break
+			}
+			fmt.Fprintf(stdout, "Chunk size set to %d bytes.\n", builder.ChunkSize)
+		case 8:
+			fmt.Fprintln(stdout, "Exiting.")
+			return 0
+		case 9:
+			fmt.Fprint(stdout, "Enter directory path: ")
+			dir, _ := readLine()
+			previewed, err := preview(builder, dir)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error: %v\n", err)
+				break
+			}
+			s := previewed.Stats()
+			fmt.Fprintln(stdout, "Dry run: nothing was hashed or recorded.")
+			fmt.Fprintf(stdout, "Would hash: %d files, %d directories, %d bytes\n", s.Files, s.Directories, s.Bytes)
+			printSkipped(stdout, previewed, false)
+			for _, skip := range previewed.SkippedPaths() {
+				fmt.Fprintf(stdout, "Would skip: %s (%s)\n", skip.Path, skip.Reason)
+			}
+			fmt.Fprintf(stdout, "Scope: %s\n", previewed.Options.Describe())
+			printConfig(stdout, previewed)
+		case 10:
+			fmt.Fprint(stdout, "Enter path in the tree: ")
+			p, _ := readLine()
+			listing, err := tree.List(p)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error: %v\n", err)
+				break
+			}
+			data, _ := json.Marshal(listing)
+			fmt.Fprintln(stdout, string(data))
+		default:
+			fmt.Fprintln(stdout, "Invalid option. Try again.")
+		}
+	}
+}
+
+// NewSession makes the builder of a menu or protocol session, or of a
+// watch, from the walk options of args, program name first, and the
+// environment. The error of invalid options comes with the usage.
+func NewSession(args []string) (*Builder, error) {
+	program := "mtfs"
+	if len(args) > 0 {
+		program, args = args[0], args[1:]
+	}
+	options := DefaultOptions()
+	builder := NewBuilder()
+	fromEnv, err := ParseEnv(&options)
+	var given bool
+	if err == nil {
+		given, err = ParseArgs(args, &options)
+		given = given || fromEnv
+	}
+	if err == nil {
+		err = builder.LoadEnv()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w\n%s", err, strings.TrimSuffix(fmt.Sprintf(usage, program), "\n"))
+	}
+	if given {
+		builder.Options = &options
+	}
+	return builder, nil
+}
+
+// preview runs a dry run of dir with the walk options of builder. A build
+// of its own leaves the tree builder built as it is.
+func preview(builder *Builder, dir string) (*Tree, error) {
+	dry := NewBuilder()
+	dry.Options, dry.DryRun = builder.Options, true
+	if err := dry.LoadEnv(); err != nil {
+		return nil, err
+	}
+	return dry.Build(dir)
+}
+
+// printStats writes the statistics of option 4.
+func printStats(out io.Writer, t *Tree) {
+	s := t.Stats()
+	fmt.Fprintf(out, "Total files: %d\n", s.Files)
+	fmt.Fprintf(out, "Total directories: %d\n", s.Directories)
+	fmt.Fprintf(out, "Total size: %d bytes (%s)\n", s.Bytes, FormatSize(s.Bytes))
+	if special := t.SpecialFiles(); special > 0 {
+		fmt.Fprintf(out, "Special files: %d recorded\n", special)
+	}
+	if links, bytes := t.HardlinkStats(); links > 0 {
+		fmt.Fprintf(out, "Hardlinks: %d (%d bytes not counted again)\n", links, bytes)
+	}
+	fmt.Fprintf(out, "Tree depth: %d\n", t.Root.Depth())
+	fmt.Fprintf(out, "Root hash: %s\n", t.Root.Hash)
+	fmt.Fprintf(out, "Scope: %s\n", t.Options.Describe())
+	printConfig(out, t)
+	printSkipped(out, t, true)
+
+	fmt.Fprintf(out, "Build throughput: %s\n", FormatThroughput(t.BuildThroughput))
+	fmt.Fprintf(out, "Build time: %.2f s\n", t.BuildThroughput.Seconds)
+	if t.VerifyThroughput.Files > 0 {
+		fmt.Fprintf(out, "Verify throughput: %s\n", FormatThroughput(t.VerifyThroughput))
+		fmt.Fprintf(out, "Verify time: %.2f s\n", t.VerifyThroughput.Seconds)
+	}
+
+	history := loadMetrics(t.Dir)
+	for _, operation := range []string{"build", "verify"} {
+		for _, line := range describeTrend(history, operation, trendRuns) {
+			fmt.Fprintln(out, line)
+		}
+	}
+}
+
+// printSkipped writes what the build of t left out of it. recorded is
+// false for a dry run, whose skipped entries are not recorded.
+func printSkipped(out io.Writer, t *Tree, recorded bool) {
+	if len(t.Unreadable) > 0 {
+		fmt.Fprintf(out, "Unreadable entries: %d (recorded as error leaves)\n", len(t.Unreadable))
+	}
+	for _, u := range t.Unreadable {
+		fmt.Fprintf(out, "Unreadable: %s (%s)\n", u.Path, u.Reason)
+	}
+	counts := t.SkipCounts()
+	reasons := make([]string, 0, len(counts))
+	for reason := range counts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(out, "Skipped %d entries (%s).\n", counts[reason], reason)
+	}
+	for _, cycle := range t.Cycles {
+		fmt.Fprintf(out, "Symlink cycle: %s (not followed)\n", cycle)
+	}
+	if recorded && len(counts) > 0 {
+		fmt.Fprintf(out, "Skipped entries are listed in %s/%s.\n", filepath.Base(state.Dir(t.Dir)), skippedFile)
+	}
+}
+
+// printConfig writes the config file that applied to the build of t.
+func printConfig(out io.Writer, t *Tree) {
+	if t.ConfigFile != "" {
+		fmt.Fprintf(out, "Tree config: %s (chunk size %d bytes)\n", t.ConfigFile, t.ChunkSize)
+	}
+}
@@ -0,0 +1,401 @@
+// Package merkle is the Go engine of MTFS: it builds the Merkle tree of a
+// directory, hashing files in fixed-size chunks, and verifies, summarizes
+// and exports it the way the C++ backend in merkle/ does, with the same
+// root hashes, walk options and state files, so the frontend can build
+// trees without running the backend. Trees of archives and options only
+// the backend has, extended attributes and name normalization, are
+// refused with ErrUnsupported; builds are neither checkpointed nor
+// throttled.
+package merkle
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"strconv"
+
+	"MTFS/manifest"
+)
+
+// Chunk sizes, in bytes.
+const (
+	DefaultChunkSize = 1024 * 1024
+	MinChunkSize     = 1024
+	MaxChunkSize     = 100 * 1024 * 1024
+)
+
+// ErrUnsupported is returned for trees and options only the C++ backend
+// can build.
+var ErrUnsupported = errors.New("not supported by the Go engine")
+
+// HashFormat is how leaves and directories are hashed into the tree.
+type HashFormat int
+
+const (
+	Mtfs        HashFormat = iota // SHA-256 of contents and of the sorted child hashes
+	GitSHA1                       // Git blob and tree object ids, as in a SHA-1 repository
+	GitSHA256                     // Git blob and tree object ids, as in a SHA-256 repository
+	MtfsSHA512                    // the mtfs format with SHA-512
+	MtfsBLAKE2b                   // the mtfs format with BLAKE2b-512
+	MtfsBLAKE3                    // the mtfs format with BLAKE3, 256 bits
+)
+
+// hashFormatNames are the names of the formats, in HashFormat order.
+var hashFormatNames = []string{"mtfs", "git", "git-sha256", "mtfs-sha512", "mtfs-blake2b", "mtfs-blake3"}
+
+// ParseHashFormat parses "mtfs", "git", "git-sha256", "mtfs-sha512",
+// "mtfs-blake2b" or "mtfs-blake3".
+func ParseHashFormat(name string) (HashFormat, error) {
+	for i, known := range hashFormatNames {
+		if name == known {
+			return HashFormat(i), nil
+		}
+	}
+	return Mtfs, fmt.Errorf("unknown hash format %s", name)
+}
+
+// String returns the name ParseHashFormat accepts.
+func (f HashFormat) String() string {
+	if f < 0 || int(f) >= len(hashFormatNames) {
+		return "mtfs"
+	}
+	return hashFormatNames[f]
+}
+
+// Git reports whether f hashes nodes into Git object ids.
+func (f HashFormat) Git() bool {
+	return f == GitSHA1 || f == GitSHA256
+}
+
+// New returns the hash of contents, chunks and nodes of the mtfs format
+// f. Git formats hash chunks with SHA-256.
+func (f HashFormat) New() hash.Hash {
+	return manifest.NewHash(f.String())
+}
+
+// Sum returns the hex hash of data with f.New.
+func (f HashFormat) Sum(data []byte) string {
+	h := f.New()
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Node is a file, directory or other entry of a tree.
+type Node struct {
+	Name        string
+	Hash        string   // Merkle hash of the node
+	ContentHash string   // hash of the content (files only)
+	ChunkHashes []string // hash of each chunk of the content (files only)
+	Children    map[string]*Node
+
+	IsFile     bool   // a leaf: a file, link, special file or error leaf
+	Size       int64  // bytes of content (files only)
+	Symlink    bool   // the leaf records a symbolic link rather than content
+	Target     string // target of the link as stored (links only)
+	Executable bool   // executable by its owner (Git mode 100755)
+	Special    string // "fifo", "socket", "char-device" or "block-device" for recorded special files
+	Device     string // "major:minor" of a recorded device
+	Error      string // why the entry could not be read (error leaves only)
+	HardlinkOf string // earlier path of the tree sharing the file's inode
+
+	dev, ino uint64 // identity of a file with several hard links
+}
+
+// SortedNames returns the names of the children of n in order.
+func (n *Node) SortedNames() []string {
+	names := make([]string, 0, len(n.Children))
+	for name := range n.Children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CalculateHash computes and sets the hash of n and the nodes below it.
+// A file's hash is its content hash, a link's and a special file's the
+// hash of what they record, an error leaf's that of its reason, and a
+// directory's that of its sorted children's names and hashes, all with
+// the hash of format. In the Git formats it is the Git object id of the
+// node instead.
+func (n *Node) CalculateHash(format HashFormat) string {
+	return n.calculateHash(format, true)
+}
+
+// calculateHash computes and sets the hash of n, recomputing those of the
+// nodes below it if recurse is set and taking them as they are if not.
+func (n *Node) calculateHash(format HashFormat, recurse bool) string {
+	if format.Git() {
+		n.Hash = n.gitID(format, recurse)
+		return n.Hash
+	}
+	switch {
+	case n.Symlink:
+		n.Hash = format.Sum([]byte("symlink:" + n.Target))
+	case n.Special != "":
+		n.Hash = format.Sum([]byte("special:" + n.Special + ":" + n.Device))
+	case n.Error != "":
+		n.Hash = format.Sum([]byte("error:" + n.Error))
+	case n.IsFile:
+		n.Hash = n.ContentHash
+	case len(n.Children) == 0:
+		// An empty directory is hashed by its name
+		n.Hash = format.Sum([]byte(n.Name))
+	default:
+		var combined []byte
+		for _, name := range n.SortedNames() {
+			child := n.Children[name]
+			if recurse {
+				child.calculateHash(format, true)
+			}
+			combined = append(combined, name+":"+child.Hash+";"...)
+		}
+		n.Hash = format.Sum(combined)
+	}
+	return n.Hash
+}
+
+// gitID returns the Git object id of n: the blob id of a file or link
+// target, the tree id of a directory.
+func (n *Node) gitID(format HashFormat, recurse bool) string {
+	if n.Symlink {
+		return gitObjectID(format, "blob", []byte(n.Target))
+	}
+	if n.IsFile {
+		return n.ContentHash
+	}
+
+	// Git orders entries as if directory names ended in '/'
+	emptyTree := gitObjectID(format, "tree", nil)
+	type entry struct {
+		key  string
+		data []byte
+	}
+	var entries []entry
+	for name, child := range n.Children {
+		if child.Error != "" {
+			continue // Git has no entry for what could not be read
+		}
+		id := child.Hash
+		if recurse {
+			id = child.calculateHash(format, true)
+		}
+		if !child.IsFile && id == emptyTree {
+			continue // Git does not record empty directories
+		}
+		mode, key := "100644", name
+		switch {
+		case child.Symlink:
+			mode = "120000"
+		case !child.IsFile:
+			mode, key = "40000", name+"/"
+		case child.Executable:
+			mode = "100755"
+		}
+		raw, _ := hex.DecodeString(id)
+		entries = append(entries, entry{key, append([]byte(mode+" "+name+"\x00"), raw...)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	var content []byte
+	for _, e := range entries {
+		content = append(content, e.data...)
+	}
+	return gitObjectID(format, "tree", content)
+}
+
+// newGitHasher returns a hash for Git objects of format.
+func newGitHasher(format HashFormat) hash.Hash {
+	if format == GitSHA256 {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+// gitObjectID returns the id of a Git object, as git hash-object prints
+// it.
+func gitObjectID(format HashFormat, kind string, content []byte) string {
+	h := newGitHasher(format)
+	h.Write([]byte(kind + " " + strconv.Itoa(len(content)) + "\x00"))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Depth returns the height of the tree below n: 0 for a leaf or an
+// empty directory.
+func (n *Node) Depth() int {
+	depth := 0
+	for _, child := range n.Children {
+		if d := child.Depth() + 1; d > depth {
+			depth = d
+		}
+	}
+	return depth
+}
+
+// Skip is an entry left out of a tree, or one that could not be read.
+type Skip struct {
+	Reason string
+	Path   string // relative to the tree root
+}
+
+// Stats are the totals of a tree.
+type Stats struct {
+	Files       int
+	Directories int
+	Bytes       int64 // hard links counted once
+}
+
+// Tree is a built tree with what its build found.
+type Tree struct {
+	Root       *Node
+	Dir        string // directory it was built from
+	Options    Options
+	ChunkSize  int64
+	ConfigFile string // config file of the tree that applied, "" for none
+	DryRun     bool   // nothing was hashed, only sized
+	Cached     int    // files taken from the hash cache of the last build
+
+	Skipped    []Skip   // entries left out, in walk order
+	Unreadable []Skip   // entries recorded as error leaves
+	Cycles     []string // symlink cycles not followed, as "path -> ancestor"
+	Warnings   []string // state that could not be recorded
+
+	BuildThroughput  Throughput
+	VerifyThroughput Throughput
+
+	progress func(string)      // receives the progress lines of verifications
+	links    map[string]string // real paths of the followed links, by path
+}
+
+// Stats returns the files, directories and bytes of t. Special files and
+// error leaves are counted apart.
+func (t *Tree) Stats() Stats {
+	var s Stats
+	var walk func(*Node)
+	walk = func(n *Node) {
+		switch {
+		case n.Special != "" || n.Error != "":
+		case n.IsFile:
+			s.Files++
+			// The content of a hard link was counted with the first path
+			if n.HardlinkOf == "" {
+				s.Bytes += n.Size
+			}
+		default:
+			s.Directories++
+			for _, child := range n.Children {
+				walk(child)
+			}
+		}
+	}
+	if t.Root != nil {
+		walk(t.Root)
+	}
+	return s
+}
+
+// SkipCounts returns how many entries were skipped for each reason.
+func (t *Tree) SkipCounts() map[string]int {
+	counts := map[string]int{}
+	for _, s := range t.Skipped {
+		counts[s.Reason]++
+	}
+	return counts
+}
+
+// SkippedPaths returns the skipped entries sorted by path.
+func (t *Tree) SkippedPaths() []Skip {
+	sorted := append([]Skip(nil), t.Skipped...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+	return sorted
+}
+
+// HardlinkStats returns the files of t that are hard links to an earlier
+// one and the bytes they are not counted again with.
+func (t *Tree) HardlinkStats() (links int, bytes int64) {
+	t.walk(func(_ string, n *Node) {
+		if n.HardlinkOf != "" {
+			links++
+			bytes += n.Size
+		}
+	})
+	return links, bytes
+}
+
+// SpecialFiles returns the number of special files recorded in t.
+func (t *Tree) SpecialFiles() int {
+	count := 0
+	t.walk(func(_ string, n *Node) {
+		if n.Special != "" {
+			count++
+		}
+	})
+	return count
+}
+
+// walk calls fn for each node of t in order, with its path relative to
+// the root ("" for the root).
+func (t *Tree) walk(fn func(relPath string, n *Node)) {
+	var visit func(string, *Node)
+	visit = func(relPath string, n *Node) {
+		fn(relPath, n)
+		for _, name := range n.SortedNames() {
+			p := name
+			if relPath != "" {
+				p = relPath + "/" + name
+			}
+			visit(p, n.Children[name])
+		}
+	}
+	if t.Root != nil {
+		visit("", t.Root)
+	}
+}
+
+// Verify recalculates the hash of every node of t and reports whether
+// they all match those it was built with, adding the verification to the
+// tree's metrics.
+func (t *Tree) Verify() bool {
+	if t.Root == nil {
+		return true
+	}
+	m := newMeter("Verify", t.progress)
+	var verify func(*Node) bool
+	verify = func(n *Node) bool {
+		original := n.Hash
+		if n.CalculateHash(t.Options.HashFormat) != original {
+			return false
+		}
+		if n.IsFile {
+			m.record(n.Size, 1)
+		}
+		for _, child := range n.Children {
+			if !verify(child) {
+				return false
+			}
+		}
+		return true
+	}
+	ok := verify(t.Root)
+	t.VerifyThroughput = m.finish()
+	t.recordMetrics("verify", ok, t.VerifyThroughput)
+	return ok
+}
+
+// Files returns a file of t for each distinct content, the first in path
+// order, by content hash.
+func (t *Tree) Files() map[string]*Node {
+	files := map[string]*Node{}
+	t.walk(func(_ string, n *Node) {
+		if n.IsFile && !n.Symlink && n.Special == "" && n.Error == "" {
+			if _, ok := files[n.ContentHash]; !ok {
+				files[n.ContentHash] = n
+			}
+		}
+	})
+	return files
+}
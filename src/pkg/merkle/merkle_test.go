@@ -0,0 +1,166 @@
+package merkle
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTree writes files, by slash-separated path, under a new directory.
+// A content starting with "->" makes a symlink to the rest, one ending in
+// "/" an empty directory.
+func writeTree(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		switch {
+		case strings.HasSuffix(name, "/"):
+			err = os.MkdirAll(path, 0o755)
+		case strings.HasPrefix(content, "->"):
+			err = os.Symlink(content[2:], path)
+		case strings.HasSuffix(name, ".sh"):
+			err = os.WriteFile(path, []byte(content), 0o755)
+		default:
+			err = os.WriteFile(path, []byte(content), 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func build(t *testing.T, dir string, o Options) *Tree {
+	t.Helper()
+	b := NewBuilder()
+	b.Options = &o
+	tree, err := b.Build(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// The root hashes of a small tree in every format, as the C++ backend
+// computes them too (but for mtfs-blake3, which only the Go engine has).
+func TestKnownRootHashes(t *testing.T) {
+	files := map[string]string{"a": "hi\n", "sub/b": "hello\n", "empty": ""}
+	want := map[string]string{
+		"mtfs":         "e5c0249172b3e324f6927898116a20f02c918bf2e8eaf8a59962854aaa30be5d",
+		"mtfs-sha512":  "9a7d7d920126f195cc49b06981f7ccf2e60f1d14612d6ffa85f88e508f8f0bf71f28dee042aea0f20212320abbc0b596dc04539a2aaa2f0573cf02bc636c7def",
+		"mtfs-blake2b": "e4318da4caa51aff2e1d647cbef5c20b7d2dd3d46b9b7fe48defd6ebb1cbd33726ca07051be1311232bae90e517e15e9b151862b66a5fd6b86dc0285656e5c0a",
+		"mtfs-blake3":  "43d7b72abf526a4b1464a3c539ee8ee517860e37889718e64c43ecd754312620",
+		"git":          "9b3938de2fc2a325d8ad260e0bde4cfb90d8d699",
+		"git-sha256":   "1f60ce96dcc7c847e1b58e37cbe4b63fbc4e9669d03997abc3ab44728bd4bdc3",
+	}
+	for _, name := range hashFormatNames {
+		t.Run(name, func(t *testing.T) {
+			o := DefaultOptions()
+			o.HashFormat, _ = ParseHashFormat(name)
+			tree := build(t, writeTree(t, files), o)
+			if tree.Root.Hash != want[name] {
+				t.Errorf("got root hash %s, want %s", tree.Root.Hash, want[name])
+			}
+			if !tree.Verify() {
+				t.Error("the tree does not verify")
+			}
+		})
+	}
+}
+
+// In the Git formats the root hash is the id git write-tree gives the
+// same files, with executables, symlinks, empty directories and names
+// Git orders differently from their bytes.
+func TestGitRootHashIsWriteTree(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	files := map[string]string{
+		"a.b":        "dot\n",
+		"a=b":        "equals\n",
+		"a-b/c":      "dash\n",
+		"a/b":        "nested\n",
+		"a/c/d":      "deeper\n",
+		"run.sh":     "#!/bin/sh\n",
+		"link":       "->a.b",
+		"empty/":     "",
+		"sub/empty/": "",
+		"sub/file":   "",
+		"z/only.txt": strings.Repeat("x", 100000),
+	}
+	for _, format := range []HashFormat{GitSHA1, GitSHA256} {
+		t.Run(format.String(), func(t *testing.T) {
+			dir := writeTree(t, files)
+			// Before the build, which adds its state directory
+			want := gitWriteTree(t, dir, format)
+			o := DefaultOptions()
+			o.HashFormat, o.Symlinks = format, RecordSymlinks
+			if got := build(t, dir, o).Root.Hash; got != want {
+				t.Errorf("got root hash %s, git write-tree %s", got, want)
+			}
+		})
+	}
+}
+
+// gitWriteTree returns the id git write-tree gives the files of dir.
+func gitWriteTree(t *testing.T, dir string, format HashFormat) string {
+	t.Helper()
+	objectFormat := "sha1"
+	if format == GitSHA256 {
+		objectFormat = "sha256"
+	}
+	gitDir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"--git-dir", gitDir, "--work-tree", dir}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q", "--object-format", objectFormat)
+	git("add", "-A", ".")
+	return git("write-tree")
+}
+
+// A listing has one directory of the tree, each subdirectory with the
+// size of its files and its number of entries.
+func TestList(t *testing.T) {
+	o := DefaultOptions()
+	o.Symlinks = RecordSymlinks
+	tree := build(t, writeTree(t, map[string]string{
+		"a":       "hi\n",
+		"sub/b":   "hello\n",
+		"sub/c":   "->b",
+		"sub/d/e": "x",
+	}), o)
+	root, err := tree.List(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Directory.Hash != tree.Root.Hash || root.Directory.Size != 10 || root.Directory.Entries != 2 {
+		t.Errorf("listed the root as %+v", root.Directory)
+	}
+	if len(root.Entries) != 2 || root.Entries[0].Name != "a" || root.Entries[1].Name != "sub" || root.Entries[1].Entries != 3 {
+		t.Errorf("listed the root with %+v", root.Entries)
+	}
+	sub, err := tree.List("sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sub.Entries) != 3 || sub.Entries[1].Type != "symlink" || sub.Entries[1].Target != "b" || sub.Entries[2].Size != 1 {
+		t.Errorf("listed sub with %+v", sub.Entries)
+	}
+	for _, p := range []string{"a", "missing", "sub/b/x"} {
+		if _, err := tree.List(p); err == nil {
+			t.Errorf("listed %s", p)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package merkle
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"MTFS/state"
+)
+
+const (
+	metricsFile    = "metrics" // figures of past builds and verifications, in the state directory
+	metricsHeader  = "MTFS-METRICS 1"
+	metricsHistory = 100 // operations kept in metricsFile
+	trendRuns      = 30  // runs summarized by the statistics
+)
+
+// operationMetrics are the figures of one past build or verification of
+// a tree.
+type operationMetrics struct {
+	time      int64  // Unix time the operation finished
+	operation string // "build" or "verify"
+	ok        bool   // the verification passed (always true for builds)
+	summary   Throughput
+}
+
+// appendMetrics adds m to the metrics history of tree, which keeps the
+// last metricsHistory operations in the format the backend writes.
+func appendMetrics(tree string, m operationMetrics) error {
+	history := append(loadMetrics(tree), m)
+	if len(history) > metricsHistory {
+		history = history[len(history)-metricsHistory:]
+	}
+
+	g := func(v float64) string { return strconv.FormatFloat(v, 'g', 6, 64) }
+	var b strings.Builder
+	b.WriteString(metricsHeader + "\n")
+	for _, m := range history {
+		result := "ok"
+		if !m.ok {
+			result = "failed"
+		}
+		s := m.summary
+		fmt.Fprintf(&b, "%d\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\n", m.time, m.operation, result,
+			g(s.Seconds), s.Bytes, s.Files, g(s.AvgBytesPerSec), g(s.AvgFilesPerSec), g(s.PeakBytesPerSec), g(s.PeakFilesPerSec))
+	}
+	if err := state.WriteFile(tree, metricsFile+".tmp", []byte(b.String())); err != nil {
+		return err
+	}
+	return os.Rename(state.Path(tree, metricsFile+".tmp"), state.Path(tree, metricsFile))
+}
+
+// loadMetrics reads the metrics history of tree, oldest first.
+func loadMetrics(tree string) []operationMetrics {
+	data, err := state.ReadFile(tree, metricsFile)
+	lines := strings.Split(string(data), "\n")
+	if err != nil || lines[0] != metricsHeader {
+		return nil
+	}
+	var history []operationMetrics
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) != 10 {
+			continue
+		}
+		m := operationMetrics{operation: fields[1], ok: fields[2] == "ok"}
+		var errs [8]error
+		m.time, errs[0] = strconv.ParseInt(fields[0], 10, 64)
+		m.summary.Seconds, errs[1] = strconv.ParseFloat(fields[3], 64)
+		m.summary.Bytes, errs[2] = strconv.ParseInt(fields[4], 10, 64)
+		m.summary.Files, errs[3] = strconv.ParseInt(fields[5], 10, 64)
+		m.summary.AvgBytesPerSec, errs[4] = strconv.ParseFloat(fields[6], 64)
+		m.summary.AvgFilesPerSec, errs[5] = strconv.ParseFloat(fields[7], 64)
+		m.summary.PeakBytesPerSec, errs[6] = strconv.ParseFloat(fields[8], 64)
+		m.summary.PeakFilesPerSec, errs[7] = strconv.ParseFloat(fields[9], 64)
+		valid := true
+		for _, err := range errs {
+			valid = valid && err == nil
+		}
+		if valid {
+			history = append(history, m)
+		}
+	}
+	return history
+}
+
+// recordMetrics adds an operation on t to the metrics history of its
+// tree, noting a warning if it cannot be written.
+func (t *Tree) recordMetrics(operation string, ok bool, summary Throughput) {
+	m := operationMetrics{time: time.Now().Unix(), operation: operation, ok: ok, summary: summary}
+	if err := appendMetrics(t.Dir, m); err != nil {
+		t.Warnings = append(t.Warnings, fmt.Sprintf("could not record %s metrics in %s", operation, state.Dir(t.Dir)))
+	}
+}
+
+// sparkline draws values as block characters scaled to their range.
+func sparkline(values []float64) string {
+	blocks := []rune("▁▂▃▄▅▆▇█")
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	var line []rune
+	for _, v := range values {
+		level := 3
+		if hi > lo {
+			level = int((v-lo)/(hi-lo)*7 + 0.5)
+		}
+		line = append(line, blocks[level])
+	}
+	return string(line)
+}
+
+// formatSeconds formats a duration with a precision suited to its size,
+// as "42.0 ms" or "12.5 s".
+func formatSeconds(seconds float64) string {
+	switch {
+	case seconds < 1:
+		return fmt.Sprintf("%.1f ms", seconds*1000)
+	case seconds < 100:
+		return fmt.Sprintf("%.1f s", seconds)
+	}
+	return fmt.Sprintf("%.0f s", seconds)
+}
+
+// describeTrend summarizes the last runs of operation in history: the
+// range of their durations and sparklines of their time and throughput.
+func describeTrend(history []operationMetrics, operation string, runs int) []string {
+	var recent []operationMetrics
+	for i := len(history) - 1; i >= 0 && len(recent) < runs; i-- {
+		if history[i].operation == operation {
+			recent = append([]operationMetrics{history[i]}, recent...)
+		}
+	}
+	if len(recent) == 0 {
+		return nil
+	}
+
+	var seconds, rates []float64
+	failed := 0
+	total := 0.0
+	for _, m := range recent {
+		seconds = append(seconds, m.summary.Seconds)
+		rates = append(rates, m.summary.AvgBytesPerSec)
+		total += m.summary.Seconds
+		if !m.ok {
+			failed++
+		}
+	}
+	lo, hi := seconds[0], seconds[0]
+	for _, s := range seconds {
+		lo, hi = min(lo, s), max(hi, s)
+	}
+
+	label := strings.ToUpper(operation[:1]) + operation[1:]
+	plural := "runs"
+	if len(recent) == 1 {
+		plural = "run"
+	}
+	summary := fmt.Sprintf("%s history: last %d %s, time min %s, avg %s, max %s, last %s", label, len(recent), plural,
+		formatSeconds(lo), formatSeconds(total/float64(len(seconds))), formatSeconds(hi), formatSeconds(seconds[len(seconds)-1]))
+	if failed > 0 {
+		summary += fmt.Sprintf(", %d failed", failed)
+	}
+
+	lines := []string{summary}
+	if len(recent) > 1 {
+		lines = append(lines,
+			label+" time trend: "+sparkline(seconds)+" (oldest to newest)",
+			fmt.Sprintf("%s throughput trend: %s (%s/s to %s/s)", label, sparkline(rates),
+				FormatSize(int64(rates[0])), FormatSize(int64(rates[len(rates)-1]))))
+	}
+	return lines
+}
@@ -0,0 +1,727 @@
+package merkle
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"MTFS/quota"
+	"MTFS/state"
+)
+
+// Symlink policies.
+const (
+	FollowSymlinks = "follow" // walk into what a link points to
+	RecordSymlinks = "record" // hash the link target as a leaf
+	SkipSymlinks   = "skip"
+)
+
+// Special file policies, for devices, sockets and FIFOs.
+const (
+	SkipSpecialFiles   = "skip"
+	RecordSpecialFiles = "record" // hash the file type and device number
+	RejectSpecialFiles = "error"  // fail the build
+)
+
+// Options scope the walk of a tree, as the backend's walk options do. A
+// build records them in the state directory of the tree, so later builds
+// given none walk it the same way.
+type Options struct {
+	Includes        []string // only files matching one of these are hashed (all if empty)
+	Excludes        []string // files and directories matching any of these are skipped
+	Symlinks        string   // FollowSymlinks, RecordSymlinks or SkipSymlinks
+	Hidden          bool     // dotfiles and dot-directories are part of the tree
+	AppleMetadata   bool     // AppleDouble files, .DS_Store and __MACOSX are hashed
+	MaxDepth        int      // directories deeper than this are not read (0 for no limit)
+	MinFileSize     int64    // smaller files are not hashed (0 for no limit)
+	MaxFileSize     int64    // larger files are not hashed (0 for no limit)
+	OneFileSystem   bool     // directories on other file systems than the root are not read
+	Xattrs          bool     // extended attributes are hashed with each entry
+	Metadata        string   // fields hashed with each entry below the root, as MetadataFields returns
+	SpecialFiles    string   // SkipSpecialFiles, RecordSpecialFiles or RejectSpecialFiles
+	Normalize       string   // form names are hashed in: none, nfc or nfd
+	CaseInsensitive bool     // names that only differ in case collide
+	HashFormat      HashFormat
+}
+
+// DefaultOptions returns the options of a tree built without any.
+func DefaultOptions() Options {
+	return Options{Symlinks: FollowSymlinks, Hidden: true, SpecialFiles: SkipSpecialFiles, Normalize: "none"}
+}
+
+// isIncluded reports whether there are no include patterns or one
+// matches relPath.
+func (o *Options) isIncluded(relPath string) bool {
+	if len(o.Includes) == 0 {
+		return true
+	}
+	for _, pattern := range o.Includes {
+		if scopeMatch(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExcluded reports whether an exclude pattern matches relPath.
+func (o *Options) isExcluded(relPath string) bool {
+	for _, pattern := range o.Excludes {
+		if scopeMatch(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// sizeLimit returns "min file size" or "max file size" if a file of size
+// is not hashed, "" if it is.
+func (o *Options) sizeLimit(size int64) string {
+	switch {
+	case o.MinFileSize > 0 && size < o.MinFileSize:
+		return "min file size"
+	case o.MaxFileSize > 0 && size > o.MaxFileSize:
+		return "max file size"
+	}
+	return ""
+}
+
+// unsupported returns the options the Go engine cannot build with, ""
+// if there are none.
+func (o *Options) unsupported() string {
+	var names []string
+	if o.Xattrs {
+		names = append(names, "xattrs")
+	}
+	if o.Metadata != "" {
+		names = append(names, "metadata "+o.Metadata)
+	}
+	if o.Normalize != "none" {
+		names = append(names, "normalize "+o.Normalize)
+	}
+	if o.CaseInsensitive {
+		names = append(names, "case-insensitive")
+	}
+	return strings.Join(names, ", ")
+}
+
+// metadataFields are the names of the metadata fields, in the order they
+// are listed.
+var metadataFields = []string{"mode", "owner", "mtime"}
+
+// MetadataFields parses a comma-separated list of metadata fields, as
+// --metadata takes it, into the fields in canonical order, "" for none.
+// xattrs is set if the list names extended attributes, which are hashed
+// as with --xattrs.
+func MetadataFields(list string) (fields string, xattrs bool, err error) {
+	if list == "none" {
+		return "", false, nil
+	}
+	named := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		if name == "xattrs" {
+			xattrs = true
+		} else if !slices.Contains(metadataFields, name) {
+			return "", false, fmt.Errorf("unknown metadata fields %s (mode, owner, mtime, xattrs or none)", list)
+		}
+		named[name] = true
+	}
+	var names []string
+	for _, name := range metadataFields {
+		if named[name] {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ","), xattrs, nil
+}
+
+// ParseArgs applies the walk options on the command line of the backend,
+// such as --exclude PATTERN or --hash-format=git, to o and reports
+// whether there were any.
+func ParseArgs(args []string, o *Options) (bool, error) {
+	given := false
+	for i := 0; i < len(args); i++ {
+		arg, value, hasValue := args[i], "", false
+		if arg == "-x" {
+			arg = "--one-file-system"
+		}
+		if name, v, ok := strings.Cut(arg, "="); ok && strings.HasPrefix(arg, "--") {
+			arg, value, hasValue = name, v, true
+		} else if takesValue(arg) {
+			if i+1 >= len(args) {
+				return given, fmt.Errorf("%s needs a value", arg)
+			}
+			i++
+			value = args[i]
+		}
+
+		var err error
+		switch arg {
+		case "--one-file-system", "--xattrs", "--case-insensitive":
+			if hasValue && value != "true" && value != "false" {
+				return given, fmt.Errorf("%s takes no value", arg)
+			}
+			on := !hasValue || value == "true"
+			switch arg {
+			case "--xattrs":
+				o.Xattrs = on
+			case "--case-insensitive":
+				o.CaseInsensitive = on
+			default:
+				o.OneFileSystem = on
+			}
+		case "--include":
+			o.Includes = append(o.Includes, value)
+		case "--exclude":
+			o.Excludes = append(o.Excludes, value)
+		case "--symlinks":
+			if value != FollowSymlinks && value != RecordSymlinks && value != SkipSymlinks {
+				err = fmt.Errorf("unknown symlink policy %s", value)
+			}
+			o.Symlinks = value
+		case "--hidden", "--apple-metadata":
+			if value != "include" && value != "skip" {
+				err = fmt.Errorf("%s must be include or skip", arg)
+			} else if arg == "--hidden" {
+				o.Hidden = value == "include"
+			} else {
+				o.AppleMetadata = value == "include"
+			}
+		case "--max-depth":
+			depth, convErr := strconv.ParseUint(value, 10, 31)
+			if convErr != nil {
+				err = errors.New("--max-depth needs a number of levels")
+			}
+			o.MaxDepth = int(depth)
+		case "--special-files":
+			if value != SkipSpecialFiles && value != RecordSpecialFiles && value != RejectSpecialFiles {
+				err = fmt.Errorf("unknown special file policy %s", value)
+			}
+			o.SpecialFiles = value
+		case "--metadata":
+			var xattrs bool
+			if o.Metadata, xattrs, err = MetadataFields(value); xattrs {
+				o.Xattrs = true
+			}
+		case "--normalize":
+			if value != "none" && value != "nfc" && value != "nfd" {
+				err = fmt.Errorf("unknown normalization form %s", value)
+			}
+			o.Normalize = value
+		case "--hash-format":
+			if o.HashFormat, err = ParseHashFormat(value); err != nil {
+				err = fmt.Errorf("unknown hash format %s", value)
+			}
+		case "--min-file-size":
+			if o.MinFileSize, err = quota.ParseSize(value); err != nil {
+				err = fmt.Errorf("invalid size %s", value)
+			}
+		case "--max-file-size":
+			if o.MaxFileSize, err = quota.ParseSize(value); err != nil {
+				err = fmt.Errorf("invalid size %s", value)
+			}
+		default:
+			err = fmt.Errorf("unknown option %s", arg)
+		}
+		if err != nil {
+			return given, err
+		}
+		given = true
+	}
+	return given, nil
+}
+
+// takesValue reports whether the walk option arg is followed by its
+// value when it is not given as --option=value.
+func takesValue(arg string) bool {
+	switch arg {
+	case "--include", "--exclude", "--symlinks", "--hidden", "--apple-metadata", "--max-depth",
+		"--min-file-size", "--max-file-size", "--special-files", "--metadata", "--normalize", "--hash-format":
+		return true
+	}
+	return false
+}
+
+// envOptions are the walk options also read from MTFS_* variables, the
+// variable of each named after it.
+var envOptions = []string{"include", "exclude", "symlinks", "hidden", "apple-metadata", "max-depth", "min-file-size", "max-file-size",
+	"one-file-system", "xattrs", "metadata", "special-files", "normalize", "case-insensitive", "hash-format"}
+
+// ParseEnv applies the walk options set through MTFS_* variables to o,
+// as the backend does before reading its command line: MTFS_INCLUDE and
+// MTFS_EXCLUDE hold ':'-separated patterns and the switches take 1/0,
+// true/false or yes/no. It reports whether any was set.
+func ParseEnv(o *Options) (bool, error) {
+	given := false
+	for _, name := range envOptions {
+		variable := "MTFS_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		value := os.Getenv(variable)
+		if value == "" {
+			continue
+		}
+
+		var args []string
+		switch name {
+		case "include", "exclude":
+			for _, pattern := range strings.Split(value, ":") {
+				if pattern != "" {
+					args = append(args, "--"+name+"="+pattern)
+				}
+			}
+		case "one-file-system", "xattrs", "case-insensitive":
+			on, ok := parseSwitch(value)
+			if !ok {
+				return given, fmt.Errorf("%s must be 1 or 0", variable)
+			}
+			args = append(args, "--"+name+"="+strconv.FormatBool(on))
+		default:
+			args = append(args, "--"+name+"="+value)
+		}
+		set, err := ParseArgs(args, o)
+		if err != nil {
+			return given, fmt.Errorf("%s: %w", variable, err)
+		}
+		given = given || set
+	}
+	return given, nil
+}
+
+// parseSwitch parses the value of a switch variable: 1, true or yes, or
+// 0, false or no.
+func parseSwitch(value string) (on, ok bool) {
+	switch value {
+	case "1", "true", "yes":
+		return true, true
+	case "0", "false", "no":
+		return false, true
+	}
+	return false, false
+}
+
+// Describe summarizes o in one line, as the Scope line of the backend
+// does: "default" if nothing is restricted.
+func (o *Options) Describe() string {
+	var parts []string
+	for _, pattern := range o.Includes {
+		parts = append(parts, "include "+pattern)
+	}
+	for _, pattern := range o.Excludes {
+		parts = append(parts, "exclude "+pattern)
+	}
+	if o.Symlinks != FollowSymlinks {
+		parts = append(parts, "symlinks "+o.Symlinks)
+	}
+	if !o.Hidden {
+		parts = append(parts, "skip hidden")
+	}
+	if o.AppleMetadata {
+		parts = append(parts, "apple metadata")
+	}
+	if o.MaxDepth > 0 {
+		parts = append(parts, fmt.Sprintf("max depth %d", o.MaxDepth))
+	}
+	if o.MinFileSize > 0 {
+		parts = append(parts, "min file size "+FormatSize(o.MinFileSize))
+	}
+	if o.MaxFileSize > 0 {
+		parts = append(parts, "max file size "+FormatSize(o.MaxFileSize))
+	}
+	if o.OneFileSystem {
+		parts = append(parts, "one file system")
+	}
+	if o.Metadata != "" {
+		parts = append(parts, "metadata "+o.Metadata)
+	}
+	if o.Xattrs {
+		parts = append(parts, "xattrs")
+	}
+	if o.SpecialFiles != SkipSpecialFiles {
+		parts = append(parts, "special files "+o.SpecialFiles)
+	}
+	if o.Normalize != "none" {
+		parts = append(parts, "names "+o.Normalize)
+	}
+	if o.CaseInsensitive {
+		parts = append(parts, "case-insensitive names")
+	}
+	switch {
+	case o.HashFormat.Git():
+		parts = append(parts, o.HashFormat.String()+" objects")
+	case o.HashFormat != Mtfs:
+		parts = append(parts, o.HashFormat.String()+" hashes")
+	}
+	if len(parts) == 0 {
+		return "default"
+	}
+	return strings.Join(parts, ", ")
+}
+
+const optionsHeader = "MTFS-OPTIONS 1"
+
+// LoadOptions reads the options recorded for tree by its last build.
+// found is false if there are none.
+func LoadOptions(tree string) (o Options, found bool) {
+	data, err := state.ReadFile(tree, state.OptionsFile)
+	if err != nil {
+		return DefaultOptions(), false
+	}
+	o, err = parseOptions(data, false)
+	return o, err == nil
+}
+
+// ParseOptions reads walk options recorded as a build records them, such
+// as those a sync server sends. Unlike LoadOptions it fails on any line
+// it does not know and any value out of range, rather than skipping them.
+func ParseOptions(data []byte) (Options, error) {
+	return parseOptions(data, true)
+}
+
+// parseOptions reads recorded options, failing on unknown lines and
+// invalid values only if strict is set.
+func parseOptions(data []byte, strict bool) (Options, error) {
+	o := DefaultOptions()
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if lines[0] != optionsHeader {
+		return o, errors.New("no walk options header")
+	}
+
+	// Trees recorded before the setting hashed macOS metadata like any file
+	o.AppleMetadata = true
+	for _, line := range lines[1:] {
+		key, value, ok := strings.Cut(line, "\t")
+		if !ok {
+			if strict {
+				return o, fmt.Errorf("invalid walk options line %q", line)
+			}
+			continue
+		}
+		value = unescapeField(value)
+		valid := true
+		number := func(n *int64) {
+			v, err := strconv.ParseInt(value, 10, 64)
+			if valid = err == nil && v >= 0; valid {
+				*n = v
+			}
+		}
+		switch key {
+		case "include":
+			o.Includes = append(o.Includes, value)
+		case "exclude":
+			o.Excludes = append(o.Excludes, value)
+		case "symlinks":
+			if valid = value == RecordSymlinks || value == SkipSymlinks || value == FollowSymlinks; valid {
+				o.Symlinks = value
+			}
+		case "hidden":
+			o.Hidden = value != "skip"
+			valid = value == "skip" || value == "include"
+		case "apple_metadata":
+			o.AppleMetadata = value != "skip"
+			valid = value == "skip" || value == "include"
+		case "max_depth":
+			var depth int64
+			if number(&depth); valid {
+				o.MaxDepth = int(depth)
+			}
+		case "max_file_size":
+			number(&o.MaxFileSize)
+		case "min_file_size":
+			number(&o.MinFileSize)
+		case "one_file_system":
+			o.OneFileSystem = value == "1"
+			valid = value == "0" || value == "1"
+		case "xattrs":
+			o.Xattrs = value == "1"
+			valid = value == "0" || value == "1"
+		case "metadata":
+			fields, _, err := MetadataFields(value)
+			if valid = err == nil; valid {
+				o.Metadata = fields
+			}
+		case "special_files":
+			if valid = value == RecordSpecialFiles || value == RejectSpecialFiles || value == SkipSpecialFiles; valid {
+				o.SpecialFiles = value
+			}
+		case "normalize":
+			if valid = value == "nfc" || value == "nfd" || value == "none"; valid {
+				o.Normalize = value
+			}
+		case "case_insensitive":
+			o.CaseInsensitive = value == "1"
+			valid = value == "0" || value == "1"
+		case "hash_format":
+			format, err := ParseHashFormat(value)
+			if valid = err == nil; valid {
+				o.HashFormat = format
+			}
+		default:
+			valid = false
+		}
+		if strict && !valid {
+			return o, fmt.Errorf("invalid walk option %s %q", key, value)
+		}
+	}
+	return o, nil
+}
+
+// Save records o in the state directory of tree, as a build records the
+// options it walked with.
+func (o *Options) Save(tree string) error {
+	var b strings.Builder
+	b.WriteString(optionsHeader + "\n")
+	for _, pattern := range o.Includes {
+		fmt.Fprintf(&b, "include\t%s\n", escapeField(pattern))
+	}
+	for _, pattern := range o.Excludes {
+		fmt.Fprintf(&b, "exclude\t%s\n", escapeField(pattern))
+	}
+	flag := func(on bool) int {
+		if on {
+			return 1
+		}
+		return 0
+	}
+	mode := func(include bool) string {
+		if include {
+			return "include"
+		}
+		return "skip"
+	}
+	fmt.Fprintf(&b, "symlinks\t%s\n", o.Symlinks)
+	fmt.Fprintf(&b, "hidden\t%s\n", mode(o.Hidden))
+	fmt.Fprintf(&b, "apple_metadata\t%s\n", mode(o.AppleMetadata))
+	fmt.Fprintf(&b, "max_depth\t%d\n", o.MaxDepth)
+	fmt.Fprintf(&b, "min_file_size\t%d\n", o.MinFileSize)
+	fmt.Fprintf(&b, "max_file_size\t%d\n", o.MaxFileSize)
+	fmt.Fprintf(&b, "one_file_system\t%d\n", flag(o.OneFileSystem))
+	fmt.Fprintf(&b, "xattrs\t%d\n", flag(o.Xattrs))
+	metadata := o.Metadata
+	if metadata == "" {
+		metadata = "none"
+	}
+	fmt.Fprintf(&b, "metadata\t%s\n", metadata)
+	fmt.Fprintf(&b, "special_files\t%s\n", o.SpecialFiles)
+	fmt.Fprintf(&b, "normalize\t%s\n", o.Normalize)
+	fmt.Fprintf(&b, "case_insensitive\t%d\n", flag(o.CaseInsensitive))
+	fmt.Fprintf(&b, "hash_format\t%s\n", o.HashFormat)
+	return state.WriteFile(tree, state.OptionsFile, []byte(b.String()))
+}
+
+// escapeField escapes backslashes, tabs and newlines in a field of a
+// tab-separated state file.
+func escapeField(field string) string {
+	return strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`).Replace(field)
+}
+
+// unescapeField reverses escapeField.
+func unescapeField(field string) string {
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+1 < len(field) {
+			i++
+			switch field[i] {
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(field[i])
+			}
+			continue
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
+
+// treeConfig holds what the hand-written config file of a tree pins,
+// which takes precedence over the options and chunk size of a build.
+// Unset fields keep their global value.
+type treeConfig struct {
+	path                           string
+	includes, excludes             []string
+	includesSet, excludesSet       bool
+	symlinks, specialFiles, norm   *string
+	metadata                       *string
+	hidden, appleMetadata          *bool
+	oneFileSystem, xattrs, caseIns *bool
+	maxDepth                       *int
+	minFileSize, maxFileSize       *int64
+	chunkSize                      *int64
+	hashFormat                     *HashFormat
+}
+
+// loadTreeConfig reads the config file of tree, checking it as strictly
+// as the backend does. found is false if the tree has none.
+func loadTreeConfig(tree string) (c treeConfig, found bool, err error) {
+	c.path = state.Path(tree, state.ConfigFile)
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, false, nil
+	} else if err != nil {
+		return c, false, err
+	}
+
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		where := fmt.Sprintf("%s:%d: ", c.path, number)
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return c, true, errors.New(where + `expected "key = value"`)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if key != "include" && key != "exclude" && seen[key] {
+			return c, true, errors.New(where + key + " is set twice")
+		}
+		seen[key] = true
+
+		valid := true
+		str := func(allowed ...string) *string {
+			valid = false
+			for _, a := range allowed {
+				valid = valid || value == a
+			}
+			return &value
+		}
+		flag := func(words ...string) *bool {
+			on, ok := parseConfigFlag(value)
+			if len(words) == 2 && (value == words[0] || value == words[1]) {
+				on, ok = value == words[0], true
+			}
+			valid = ok
+			return &on
+		}
+		size := func(zeroOK bool, lo, hi int64) *int64 {
+			n, err := quota.ParseSize(value)
+			valid = (err == nil && n >= lo && (hi == 0 || n <= hi)) || (zeroOK && value == "0")
+			return &n
+		}
+		count := func() bool {
+			return value != "" && len(value) <= 15 && strings.Trim(value, "0123456789") == ""
+		}
+		switch key {
+		case "include", "exclude":
+			if value != "" {
+				if key == "include" {
+					c.includes = append(c.includes, value)
+				} else {
+					c.excludes = append(c.excludes, value)
+				}
+			}
+			c.includesSet = c.includesSet || key == "include"
+			c.excludesSet = c.excludesSet || key == "exclude"
+		case "symlinks":
+			c.symlinks = str(FollowSymlinks, RecordSymlinks, SkipSymlinks)
+		case "hidden":
+			c.hidden = flag("include", "skip")
+		case "apple_metadata":
+			c.appleMetadata = flag("include", "skip")
+		case "max_depth":
+			valid = value != "" && strings.Trim(value, "0123456789") == ""
+			depth, _ := strconv.Atoi(value)
+			c.maxDepth = &depth
+		case "max_file_size":
+			c.maxFileSize = size(true, 0, 0)
+		case "min_file_size":
+			c.minFileSize = size(true, 0, 0)
+		case "one_file_system":
+			c.oneFileSystem = flag()
+		case "xattrs":
+			c.xattrs = flag()
+		case "metadata":
+			fields, xattrs, err := MetadataFields(value)
+			valid = err == nil
+			c.metadata = &fields
+			if xattrs {
+				c.xattrs = &xattrs
+			}
+		case "special_files":
+			c.specialFiles = str(SkipSpecialFiles, RecordSpecialFiles, RejectSpecialFiles)
+		case "normalize":
+			c.norm = str("none", "nfc", "nfd")
+		case "case_insensitive":
+			c.caseIns = flag()
+		case "hash_format":
+			format, err := ParseHashFormat(value)
+			valid = err == nil
+			c.hashFormat = &format
+		case "chunk_size":
+			c.chunkSize = size(false, MinChunkSize, MaxChunkSize)
+		case "io_limit", "max_size", "max_growth_per_day":
+			// Throttling is up to the backend and quotas to the frontend
+			size(key == "io_limit", 0, 0)
+		case "iops_limit", "max_files":
+			valid = count()
+		default:
+			return c, true, errors.New(where + "unknown key " + key)
+		}
+		if !valid {
+			return c, true, fmt.Errorf("%sinvalid %s %q", where, key, value)
+		}
+	}
+	return c, true, scanner.Err()
+}
+
+// parseConfigFlag parses a yes/no setting of a config file.
+func parseConfigFlag(value string) (on, ok bool) {
+	switch value {
+	case "true", "yes", "on", "1":
+		return true, true
+	case "false", "no", "off", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// apply overrides o and chunk with what c pins.
+func (c *treeConfig) apply(o *Options, chunk *int64) {
+	if c.includesSet {
+		o.Includes = c.includes
+	}
+	if c.excludesSet {
+		o.Excludes = c.excludes
+	}
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	setBool := func(dst *bool, src *bool) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	set(&o.Symlinks, c.symlinks)
+	set(&o.SpecialFiles, c.specialFiles)
+	set(&o.Normalize, c.norm)
+	set(&o.Metadata, c.metadata)
+	setBool(&o.Hidden, c.hidden)
+	setBool(&o.AppleMetadata, c.appleMetadata)
+	setBool(&o.OneFileSystem, c.oneFileSystem)
+	setBool(&o.Xattrs, c.xattrs)
+	setBool(&o.CaseInsensitive, c.caseIns)
+	if c.maxDepth != nil {
+		o.MaxDepth = *c.maxDepth
+	}
+	if c.minFileSize != nil {
+		o.MinFileSize = *c.minFileSize
+	}
+	if c.maxFileSize != nil {
+		o.MaxFileSize = *c.maxFileSize
+	}
+	if c.hashFormat != nil {
+		o.HashFormat = *c.hashFormat
+	}
+	if c.chunkSize != nil {
+		*chunk = *c.chunkSize
+	}
+}
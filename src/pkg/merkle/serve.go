@@ -0,0 +1,170 @@
+package merkle
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"MTFS/protocol"
+	"MTFS/state"
+)
+
+// Serve answers the requests of the frontend protocol read from in on
+// out, as RunMenu answers the numbered menu. args are the walk options
+// of its command line, program name first. It returns the exit status: 0
+// when asked to exit or when its input ends, 2 for invalid options.
+func Serve(args []string, in io.Reader, out io.Writer) int {
+	w := protocol.NewWriter(out)
+	builder, err := NewSession(args)
+	if err != nil {
+		w.Send(0, protocol.Ready, nil, err)
+		return 2
+	}
+	if err := w.Send(0, protocol.Ready, protocol.ReadyResult{Engine: "go"}, nil); err != nil {
+		return 0
+	}
+
+	s := &session{builder: builder}
+	for req := range protocol.ReadRequests(in) {
+		var lines protocol.ProgressLines
+		builder.Progress = func(line string) {
+			if progress, _ := lines.Add(line); progress != nil {
+				w.Send(req.ID, protocol.Progress, *progress, nil)
+			}
+		}
+		result, err := s.run(req)
+		if err := w.Send(req.ID, req.Command, result, err); err != nil {
+			return 0
+		}
+		if req.Command == protocol.Exit && req.Err == nil {
+			return 0
+		}
+	}
+	return 0
+}
+
+// session is the state of Serve between requests.
+type session struct {
+	builder *Builder
+	tree    *Tree // the built tree, nil before the first build
+}
+
+// run runs req and returns its result.
+func (s *session) run(req protocol.Request) (any, error) {
+	if req.Err != nil {
+		return nil, req.Err
+	}
+	switch req.Command {
+	case protocol.Build, protocol.Preview, protocol.ChunkSize, protocol.List:
+		if len(req.Args) != 1 {
+			return nil, fmt.Errorf("%s takes one argument", req.Command)
+		}
+	}
+	switch req.Command {
+	case protocol.Tree, protocol.Files, protocol.Stats, protocol.Verify, protocol.Export, protocol.List:
+		if s.tree == nil {
+			return nil, errors.New("no tree has been built")
+		}
+	}
+
+	switch req.Command {
+	case protocol.Build:
+		built, err := s.builder.Build(req.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		s.tree = built
+		res := buildResult(built, true)
+		res.RootHash = built.Root.Hash
+		res.Cached = int64(built.Cached)
+		res.Throughput = FormatThroughput(built.BuildThroughput)
+		return res, nil
+	case protocol.Preview:
+		previewed, err := preview(s.builder, req.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		res := buildResult(previewed, false)
+		for _, skip := range previewed.SkippedPaths() {
+			res.WouldSkip = append(res.WouldSkip, protocol.Entry{Path: skip.Path, Reason: skip.Reason})
+		}
+		return res, nil
+	case protocol.Tree:
+		return &protocol.Report{Lines: reportLines(s.tree.PrintTree)}, nil
+	case protocol.Files:
+		return &protocol.Report{Lines: reportLines(s.tree.PrintFiles)}, nil
+	case protocol.Stats:
+		return &protocol.Report{Lines: reportLines(func(out io.Writer) { printStats(out, s.tree) })}, nil
+	case protocol.Verify:
+		s.tree.progress = s.builder.Progress
+		res := &protocol.VerifyResult{OK: s.tree.Verify()}
+		res.Throughput = FormatThroughput(s.tree.VerifyThroughput)
+		res.Warnings = takeWarnings(s.tree)
+		return res, nil
+	case protocol.Export:
+		return &protocol.ExportResult{Document: s.tree.JSON()}, nil
+	case protocol.List:
+		return s.tree.List(req.Args[0])
+	case protocol.ChunkSize:
+		size, err := strconv.ParseInt(req.Args[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk size %q", req.Args[0])
+		}
+		if err := s.builder.SetChunkSize(size); err != nil {
+			return nil, err
+		}
+		return &protocol.ChunkSizeResult{ChunkSize: s.builder.ChunkSize}, nil
+	case protocol.Exit:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown command %q", req.Command)
+}
+
+// buildResult describes t for the protocol as printSkipped and the build
+// report of the menu do. recorded is false for a dry run.
+func buildResult(t *Tree, recorded bool) *protocol.BuildResult {
+	st := t.Stats()
+	res := &protocol.BuildResult{
+		Files:       int64(st.Files),
+		Directories: int64(st.Directories),
+		Bytes:       st.Bytes,
+		Cycles:      t.Cycles,
+		Scope:       t.Options.Describe(),
+		ConfigFile:  t.ConfigFile,
+	}
+	res.Warnings = takeWarnings(t)
+	if t.ConfigFile != "" {
+		res.ConfigChunkSize = t.ChunkSize
+	}
+	for _, u := range t.Unreadable {
+		res.Unreadable = append(res.Unreadable, protocol.Entry{Path: u.Path, Reason: u.Reason})
+	}
+	counts := t.SkipCounts()
+	for reason, count := range counts {
+		res.Skipped = append(res.Skipped, protocol.SkipCount{Reason: reason, Count: count})
+	}
+	sort.Slice(res.Skipped, func(i, j int) bool { return res.Skipped[i].Reason < res.Skipped[j].Reason })
+	if recorded && len(counts) > 0 {
+		res.SkippedList = filepath.Base(state.Dir(t.Dir)) + "/" + skippedFile
+	}
+	return res
+}
+
+// takeWarnings returns the warnings of t and clears them, so they are
+// reported once.
+func takeWarnings(t *Tree) []string {
+	warnings := t.Warnings
+	t.Warnings = nil
+	return warnings
+}
+
+// reportLines returns what print writes, a line each.
+func reportLines(print func(io.Writer)) []string {
+	var b strings.Builder
+	print(&b)
+	return strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package merkle
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fileIdentity returns the device and inode of info and its number of
+// hard links.
+func fileIdentity(info os.FileInfo) (dev, ino, links uint64) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, 1
+	}
+	return uint64(st.Dev), uint64(st.Ino), uint64(st.Nlink)
+}
+
+// deviceNumber returns the "major:minor" number of the device info
+// describes.
+func deviceNumber(info os.FileInfo) string {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	rdev := uint64(st.Rdev)
+	return strconv.FormatUint(uint64(unix.Major(rdev)), 10) + ":" + strconv.FormatUint(uint64(unix.Minor(rdev)), 10)
+}
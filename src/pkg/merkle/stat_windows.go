@@ -0,0 +1,15 @@
+package merkle
+
+import "os"
+
+// fileIdentity returns zeros and a single link: file identities are not
+// reported by the FileInfo of Windows, so hard links are hashed as
+// separate files.
+func fileIdentity(os.FileInfo) (dev, ino, links uint64) {
+	return 0, 0, 1
+}
+
+// deviceNumber returns "", as Windows has no device files.
+func deviceNumber(os.FileInfo) string {
+	return ""
+}
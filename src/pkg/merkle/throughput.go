@@ -0,0 +1,236 @@
+package merkle
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// reportInterval is the time between progress lines.
+const reportInterval = 500 * time.Millisecond
+
+// Throughput are the figures of a completed build or verification.
+type Throughput struct {
+	Bytes           int64
+	Files           int64
+	Seconds         float64 // wall-clock duration
+	AvgBytesPerSec  float64
+	AvgFilesPerSec  float64
+	PeakBytesPerSec float64 // highest rate of a sampling window
+	PeakFilesPerSec float64
+}
+
+// meter tracks the rolling and overall throughput of an operation and
+// reports progress lines as the backend's throughput meter does. Once
+// told what the operation is expected to total, the lines also show the
+// share done and an ETA from the smoothed rate of the recent windows.
+// Each file being hashed holds a lane, the worker hashing it, and the
+// busy lanes are reported a line each before every progress line.
+type meter struct {
+	label    string
+	progress func(line string) // receives progress lines, nil for none
+
+	mu                           sync.Mutex
+	start, sampleTime            time.Time
+	bytes, files                 int64
+	sampleBytes, sampleFiles     int64
+	peakBytes, peakFiles         float64
+	expectedFiles, expectedBytes int64
+	smoothedBytes, smoothedFiles float64
+	skipped                      int64 // bytes done without being hashed
+	lanes                        []lane
+}
+
+// lane is the file a worker is hashing.
+type lane struct {
+	busy        bool
+	path        string
+	done, total int64
+}
+
+// newMeter starts timing an operation named label, such as "Build".
+func newMeter(label string, progress func(string)) *meter {
+	now := time.Now()
+	return &meter{label: label, progress: progress, start: now, sampleTime: now}
+}
+
+// expect sets the files and bytes the operation is expected to total.
+func (m *meter) expect(files, bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expectedFiles, m.expectedBytes = files, bytes
+}
+
+// skip counts bytes as done without hashing them, such as those of a
+// file taken from the hash cache.
+func (m *meter) skip(bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.skipped += bytes
+}
+
+// begin gives the file at relPath, of size bytes, the first free lane
+// and returns it.
+func (m *meter) begin(relPath string, size int64) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := lane{busy: true, path: relPath, total: size}
+	for i := range m.lanes {
+		if !m.lanes[i].busy {
+			m.lanes[i] = l
+			return i
+		}
+	}
+	m.lanes = append(m.lanes, l)
+	return len(m.lanes) - 1
+}
+
+// advance counts bytes hashed of the file in lane i, as record does.
+func (m *meter) advance(i int, bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lanes[i].done += bytes
+	m.count(bytes, 0)
+}
+
+// end frees lane i.
+func (m *meter) end(i int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lanes[i].busy = false
+}
+
+// record counts processed bytes and files, reporting a progress line
+// when one is due. It is safe to call from several goroutines.
+func (m *meter) record(bytes, files int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.count(bytes, files)
+}
+
+// count is record with m locked.
+func (m *meter) count(bytes, files int64) {
+	m.bytes += bytes
+	m.files += files
+	m.sampleBytes += bytes
+	m.sampleFiles += files
+
+	now := time.Now()
+	if now.Sub(m.sampleTime) < reportInterval {
+		return
+	}
+	bytesPerSec, filesPerSec := m.closeWindow(now)
+	// Recent windows weigh most, but one slow window does not swing the ETA
+	const weight = 0.3
+	if m.smoothedBytes == 0 && m.smoothedFiles == 0 {
+		m.smoothedBytes, m.smoothedFiles = bytesPerSec, filesPerSec
+	} else {
+		m.smoothedBytes = weight*bytesPerSec + (1-weight)*m.smoothedBytes
+		m.smoothedFiles = weight*filesPerSec + (1-weight)*m.smoothedFiles
+	}
+	if m.progress == nil {
+		return
+	}
+	for i, l := range m.lanes {
+		if l.busy {
+			m.progress(fmt.Sprintf("Worker %d: %d/%d bytes of %s", i+1, l.done, l.total, l.path))
+		}
+	}
+
+	if m.expectedFiles == 0 {
+		m.progress(fmt.Sprintf("Progress: %s %d files, %s | %s/s, %.1f files/s",
+			m.label, m.files, FormatSize(m.bytes), FormatSize(int64(bytesPerSec)), filesPerSec))
+		return
+	}
+
+	// Files changed since the pre-scan can take the counts past the totals
+	doneBytes, doneFiles := min(m.bytes+m.skipped, m.expectedBytes), min(m.files, m.expectedFiles)
+	share := float64(doneFiles) / float64(m.expectedFiles)
+	if m.expectedBytes > 0 {
+		share = float64(doneBytes) / float64(m.expectedBytes)
+	}
+	line := fmt.Sprintf("Progress: %s %d/%d files, %s/%s (%.1f%%) | %s/s, %.1f files/s",
+		m.label, doneFiles, m.expectedFiles, FormatSize(doneBytes), FormatSize(m.expectedBytes), share*100,
+		FormatSize(int64(bytesPerSec)), filesPerSec)
+	eta := -1.0
+	if m.expectedBytes > 0 && m.smoothedBytes > 0 {
+		eta = float64(m.expectedBytes-doneBytes) / m.smoothedBytes
+	} else if m.expectedBytes == 0 && m.smoothedFiles > 0 {
+		eta = float64(m.expectedFiles-doneFiles) / m.smoothedFiles
+	}
+	if eta >= 0 {
+		line += " | ETA " + formatDuration(eta)
+	}
+	m.progress(line)
+}
+
+// finish stops timing and returns the figures of the operation.
+func (m *meter) finish() Throughput {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	// The last partial window only counts if nothing was sampled yet,
+	// otherwise a tiny tail window would skew the peak
+	if m.peakBytes == 0 && m.peakFiles == 0 {
+		m.closeWindow(now)
+	}
+
+	t := Throughput{Bytes: m.bytes, Files: m.files, Seconds: now.Sub(m.start).Seconds()}
+	if t.Seconds > 0 {
+		t.AvgBytesPerSec = float64(t.Bytes) / t.Seconds
+		t.AvgFilesPerSec = float64(t.Files) / t.Seconds
+	}
+	t.PeakBytesPerSec = max(m.peakBytes, t.AvgBytesPerSec)
+	t.PeakFilesPerSec = max(m.peakFiles, t.AvgFilesPerSec)
+	return t
+}
+
+// closeWindow ends the current sampling window at now, updates the
+// peaks and returns the rates of the window.
+func (m *meter) closeWindow(now time.Time) (bytesPerSec, filesPerSec float64) {
+	if elapsed := now.Sub(m.sampleTime).Seconds(); elapsed > 0 {
+		bytesPerSec = float64(m.sampleBytes) / elapsed
+		filesPerSec = float64(m.sampleFiles) / elapsed
+	}
+	m.peakBytes = max(m.peakBytes, bytesPerSec)
+	m.peakFiles = max(m.peakFiles, filesPerSec)
+	m.sampleTime = now
+	m.sampleBytes, m.sampleFiles = 0, 0
+	return bytesPerSec, filesPerSec
+}
+
+// FormatSize formats bytes as the backend does, as "1.4 GB" or "512 B".
+func FormatSize(bytes int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	size := float64(bytes)
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	precision := 0
+	if size < 10 && unit > 0 {
+		precision = 1
+	}
+	return fmt.Sprintf("%.*f %s", precision, size, units[unit])
+}
+
+// FormatThroughput formats t on one line, as "avg 12.1 MB/s (peak 20 MB/s),
+// 45.0 files/s (peak 60.0 files/s)".
+func FormatThroughput(t Throughput) string {
+	return fmt.Sprintf("avg %s/s (peak %s/s), %.1f files/s (peak %.1f files/s)",
+		FormatSize(int64(t.AvgBytesPerSec)), FormatSize(int64(t.PeakBytesPerSec)), t.AvgFilesPerSec, t.PeakFilesPerSec)
+}
+
+// formatDuration formats seconds for progress lines, as "45s", "3m 20s"
+// or "1h 05m".
+func formatDuration(seconds float64) string {
+	total := int64(seconds + 0.5)
+	switch {
+	case total >= 3600:
+		return fmt.Sprintf("%dh %02dm", total/3600, total%3600/60)
+	case total >= 60:
+		return fmt.Sprintf("%dm %02ds", total/60, total%60)
+	}
+	return fmt.Sprintf("%ds", total)
+}
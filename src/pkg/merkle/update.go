@@ -0,0 +1,279 @@
+package merkle
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"MTFS/state"
+)
+
+// Update brings t up to date with changes to the entries at relPaths,
+// slash-separated paths relative to the tree root such as a file watcher
+// reports. Each entry is walked again as a build walks it, or taken out of
+// the tree if it is gone or no longer in scope, and only the directories
+// above it are hashed again, from the hashes of their other entries. A
+// change to an ignore file walks its directory again. Nothing is recorded
+// in the state directory.
+func (b *Builder) Update(t *Tree, relPaths ...string) error {
+	if t.Root == nil || t.DryRun {
+		return errors.New("no tree has been built")
+	}
+	info, err := os.Stat(t.Dir)
+	if err != nil {
+		return fmt.Errorf("Directory does not exist: %s", t.Dir)
+	}
+	if t.links == nil {
+		t.links = map[string]string{}
+	}
+	w := &walker{tree: t, root: t.Dir, meter: newMeter("Update", nil),
+		slots: make(chan struct{}, max(0, b.Workers-1)), linked: map[[2]uint64]*linkedHash{}}
+	w.rootDev, _, _ = fileIdentity(info)
+
+	targets := updateTargets(relPaths)
+	for _, rel := range targets {
+		if err := w.update(rel); err != nil {
+			return err
+		}
+	}
+	// Hard links and followed links elsewhere in the tree share the
+	// content of what was walked again
+	for _, rel := range t.linkedPaths(targets) {
+		if err := w.update(rel); err != nil {
+			return err
+		}
+	}
+	if len(w.rejected) > 0 {
+		sort.Strings(w.rejected)
+		return errors.New("Special files are not allowed by the tree's policy: " + strings.Join(w.rejected, ", "))
+	}
+	markHardlinks(t.Root, "", map[[2]uint64]string{})
+	return nil
+}
+
+// updateTargets returns the entries to walk again for changes to
+// relPaths: the directory of a changed ignore file, and no entry below
+// another one walked again or inside the state directory.
+func updateTargets(relPaths []string) []string {
+	var targets []string
+	for _, rel := range relPaths {
+		rel = path.Clean(strings.Trim(filepath.ToSlash(rel), "/"))
+		if rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		if path.Base(rel) == IgnoreFile {
+			rel = path.Dir(rel)
+		}
+		if rel == state.DirName || strings.HasPrefix(rel, state.DirName+"/") {
+			continue
+		}
+		targets = append(targets, rel)
+	}
+	sort.Strings(targets)
+
+	var kept []string
+	for _, rel := range targets {
+		if n := len(kept); n > 0 && (kept[n-1] == "." || rel == kept[n-1] || strings.HasPrefix(rel, kept[n-1]+"/")) {
+			continue
+		}
+		kept = append(kept, rel)
+	}
+	return kept
+}
+
+// level is a directory on the path from the tree root to an updated
+// entry.
+type level struct {
+	node   *Node
+	ctx    walkContext // context of the directory itself
+	dirCtx walkContext // context it hands down to its entries
+}
+
+// update walks the entry at rel again and hashes the directories above
+// it.
+func (w *walker) update(rel string) error {
+	t := w.tree
+	if rel == "." {
+		w.forget("")
+		root, err := w.buildNode(w.root, walkContext{})
+		if err != nil {
+			return err
+		}
+		root.CalculateHash(t.Options.HashFormat)
+		t.Root = root
+		return nil
+	}
+
+	// Go down to the first entry the tree does not hold as a directory:
+	// entries below one that is new or gone are walked with it
+	names := strings.Split(rel, "/")
+	levels := []level{{node: t.Root}}
+	p := w.root
+	for i, name := range names {
+		dir := &levels[len(levels)-1]
+		var ok bool
+		if dir.dirCtx, ok = w.enter(p, dir.ctx); !ok {
+			// The entries of the directory are not read
+			return nil
+		}
+		p = filepath.Join(p, name)
+		child := dir.node.Children[name]
+		if i == len(names)-1 || child == nil || child.IsFile {
+			return w.replace(levels, p)
+		}
+		lst, err := os.Lstat(p)
+		if err != nil {
+			return w.replace(levels, p)
+		}
+		ctx, ok := w.admit(p, lst.Mode()&fs.ModeSymlink != 0, dir.ctx, dir.dirCtx)
+		if !ok {
+			return w.replace(levels, p)
+		}
+		levels = append(levels, level{node: child, ctx: ctx})
+	}
+	return nil
+}
+
+// enter returns the context the directory at p, with context ctx, hands
+// down to its entries, as buildChildren does, or false if its entries
+// are left out.
+func (w *walker) enter(p string, ctx walkContext) (walkContext, bool) {
+	o := &w.tree.Options
+	relDir := w.relPath(p)
+	dirCtx := ctx
+	dirCtx.ignore = ctx.ignore.forDirectory(p, relDir)
+	if o.MaxDepth > 0 && ctx.depth >= o.MaxDepth {
+		return dirCtx, false
+	}
+	if info, err := os.Stat(p); err == nil {
+		dev, ino, _ := fileIdentity(info)
+		if o.OneFileSystem && dev != w.rootDev {
+			return dirCtx, false
+		}
+		dirCtx.ancestors = &ancestor{dev: dev, ino: ino, relPath: relDir, parent: ctx.ancestors}
+	}
+	return dirCtx, true
+}
+
+// replace walks the entry at p again into the last directory of levels,
+// or takes it out, and hashes the directories again from there up.
+func (w *walker) replace(levels []level, p string) error {
+	t := w.tree
+	rel := w.relPath(p)
+	name := filepath.Base(p)
+	dir := levels[len(levels)-1]
+	w.forget(rel)
+	delete(dir.node.Children, name)
+
+	if lst, err := os.Lstat(p); err == nil {
+		if ctx, ok := w.admit(p, lst.Mode()&fs.ModeSymlink != 0, dir.ctx, dir.dirCtx); ok {
+			node, err := w.buildNode(p, ctx)
+			switch {
+			case err != nil:
+				node = &Node{Name: name, IsFile: true, Error: errorReason(err)}
+				t.Unreadable = append(t.Unreadable, Skip{Reason: node.Error, Path: rel})
+			case !node.IsFile && len(node.Children) == 0 && !ctx.included:
+				// Directories holding nothing in scope are left out
+				node = nil
+			}
+			if node != nil {
+				node.CalculateHash(t.Options.HashFormat)
+				dir.node.Children[name] = node
+			}
+		}
+	}
+
+	// Directories left holding nothing in scope go too
+	for i := len(levels) - 1; i > 0; i-- {
+		l := levels[i]
+		if len(l.node.Children) == 0 && !l.ctx.included {
+			delete(levels[i-1].node.Children, l.node.Name)
+		}
+	}
+	for i := len(levels) - 1; i >= 0; i-- {
+		levels[i].node.calculateHash(t.Options.HashFormat, false)
+	}
+	return nil
+}
+
+// forget drops what the walks of t recorded about the entry at rel and
+// those below it, "" for all of them, before it is walked again.
+func (w *walker) forget(rel string) {
+	t := w.tree
+	under := func(p string) bool {
+		return rel == "" || p == rel || strings.HasPrefix(p, rel+"/")
+	}
+	keep := func(skips []Skip) []Skip {
+		kept := skips[:0]
+		for _, s := range skips {
+			if !under(s.Path) {
+				kept = append(kept, s)
+			}
+		}
+		return kept
+	}
+	t.Skipped, t.Unreadable = keep(t.Skipped), keep(t.Unreadable)
+	for link := range t.links {
+		if under(link) {
+			delete(t.links, link)
+		}
+	}
+	cycles := t.Cycles[:0]
+	for _, c := range t.Cycles {
+		from, _, _ := strings.Cut(c, " -> ")
+		if !under(from) {
+			cycles = append(cycles, c)
+		}
+	}
+	t.Cycles = cycles
+}
+
+// linkedPaths returns the paths of t outside of targets that are hard
+// links to files at or below targets, or followed links leading to or
+// into them.
+func (t *Tree) linkedPaths(targets []string) []string {
+	inTargets := func(p string) bool {
+		for _, rel := range targets {
+			if rel == "." || p == rel || strings.HasPrefix(p, rel+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	var linked []string
+	if root, err := filepath.EvalSymlinks(t.Dir); err == nil {
+		for link, real := range t.links {
+			if inTargets(link) {
+				continue
+			}
+			for _, rel := range targets {
+				changed := filepath.Join(root, filepath.FromSlash(rel))
+				if below, err := filepath.Rel(real, changed); err == nil && below != ".." && !strings.HasPrefix(below, ".."+string(filepath.Separator)) {
+					linked = append(linked, path.Join(link, filepath.ToSlash(below)))
+				} else if strings.HasPrefix(real, changed+string(filepath.Separator)) {
+					linked = append(linked, link)
+				}
+			}
+		}
+	}
+
+	inodes := map[[2]uint64]bool{}
+	t.walk(func(p string, n *Node) {
+		if n.IsFile && n.ino != 0 && inTargets(p) {
+			inodes[[2]uint64{n.dev, n.ino}] = true
+		}
+	})
+	if len(inodes) > 0 {
+		t.walk(func(p string, n *Node) {
+			if n.IsFile && inodes[[2]uint64{n.dev, n.ino}] && !inTargets(p) {
+				linked = append(linked, p)
+			}
+		})
+	}
+	return updateTargets(linked)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"MTFS/inclusion"
+	"MTFS/registry"
+	"MTFS/remote"
+	"MTFS/state"
+	"MTFS/superroot"
+)
+
+func superRoot(args []string) int {
+	flags := flag.NewFlagSet("super-root", flag.ExitOnError)
+	out := flags.String("o", "super-root.json", "file to write the super-root and the trees it covers to")
+	build := flags.Bool("build", false, "rebuild each tree first instead of taking its last root hash")
+	flags.Parse(args)
+
+	known, err := registry.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	byName := map[string]registry.Tree{}
+	for _, t := range known {
+		byName[t.Name] = t
+	}
+	// The order of the trees is part of the super-root, so without names
+	// they are taken in name order
+	names := flags.Args()
+	if len(names) == 0 {
+		for name := range byName {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	var trees []superroot.Tree
+	for _, name := range names {
+		t, ok := byName[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error: no tree is registered as %q\n", name)
+			return 1
+		}
+		if *build {
+			root, err := remote.Build(t.Path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			t.RootHash = root.Hash
+			if err := registry.Record(t.Path, root.Hash, ""); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %s not updated in the registry: %v\n", t.Name, err)
+			}
+		}
+		trees = append(trees, superroot.Tree{Name: t.Name, RootHash: t.RootHash})
+	}
+
+	doc, err := superroot.Compute(trees)
+	if err == nil {
+		err = superroot.Save(*out, doc)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.RootHash = doc.SuperRoot
+	operation.Params["trees"] = strings.Join(names, " ")
+	for i, t := range doc.Trees {
+		fmt.Printf("%3d  %s  %s\n", i, t.RootHash, t.Name)
+	}
+	fmt.Printf("Super-root: %s (%d trees), written to %s\n", doc.SuperRoot, len(doc.Trees), *out)
+	return 0
+}
+
+func proveInclusion(args []string) int {
+	flags := flag.NewFlagSet("prove-inclusion", flag.ExitOnError)
+	super := flags.String("super", "super-root.json", "super-root written by super-root")
+	out := flags.String("o", "", "file to write the proof to (default: NAME.proof.json)")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no tree name given")
+		return 2
+	}
+	name := flags.Arg(0)
+	if *out == "" {
+		*out = name + ".proof.json"
+	}
+
+	var doc superroot.Document
+	err := superroot.Load(*super, &doc)
+	var proof *superroot.Proof
+	if err == nil {
+		proof, err = doc.Prove(name)
+	}
+	if err == nil {
+		err = superroot.Save(*out, proof)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.RootHash = doc.SuperRoot
+	fmt.Printf("Tree %s (root %s) is leaf %d of %d in super-root %s\n", name, proof.Tree.RootHash, proof.LeafIndex, proof.TreeSize, doc.SuperRoot)
+	fmt.Printf("Proof written to %s\n", *out)
+	return 0
+}
+
+func verifyInclusion(args []string) int {
+	flags := flag.NewFlagSet("verify-inclusion", flag.ExitOnError)
+	dir := flags.String("tree", "", "also rebuild this tree and check it still has the proven root hash")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no proof given")
+		return 2
+	}
+
+	var proof superroot.Proof
+	if err := superroot.Load(flags.Arg(0), &proof); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.RootHash = proof.SuperRoot
+	if err := proof.Verify(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Tree %s (root %s) is covered by super-root %s\n", proof.Tree.Name, proof.Tree.RootHash, proof.SuperRoot)
+	if *dir == "" {
+		return 0
+	}
+
+	tree := registry.Resolve(*dir)
+	operation.Tree = tree
+	remote.RehashAll()
+	root, err := remote.Build(tree)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if root.Hash != proof.Tree.RootHash {
+		fmt.Printf("%s now has root %s, not the proven one\n", tree, root.Hash)
+		return 1
+	}
+	fmt.Printf("%s still has the proven root hash\n", tree)
+	return 0
+}
+
+func proveFile(args []string) int {
+	flags := flag.NewFlagSet("prove-file", flag.ExitOnError)
+	out := flags.String("o", "", "file to write the proof to (default: the base name of PATH with .proof.json)")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no path given")
+		return 2
+	}
+	tree := "."
+	if flags.NArg() > 1 {
+		tree = registry.Resolve(flags.Arg(1))
+	}
+	operation.Tree = tree
+	if format := state.HashFormat(tree); strings.HasPrefix(format, "git") {
+		fmt.Fprintf(os.Stderr, "Error: %s is hashed in the %s format; proofs need an mtfs format\n", tree, format)
+		return 1
+	}
+	rel, err := treeRelative(tree, flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+	if *out == "" {
+		*out = path.Base(rel) + ".proof.json"
+	}
+
+	root, err := remote.Build(tree)
+	var proof *inclusion.Proof
+	if err == nil {
+		proof, err = inclusion.Prove(root, rel)
+	}
+	if err == nil {
+		err = proof.Save(*out)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.RootHash = root.Hash
+	operation.Params["path"] = rel
+	fmt.Printf("%s (%s, hash %s) is in the tree with root %s\n", rel, proof.Type, proof.LeafHash, root.Hash)
+	fmt.Printf("Proof written to %s\n", *out)
+	return 0
+}
+
+// treeRelative returns p, a path inside tree given relative to it or as
+// an absolute path, as a slash-separated path relative to tree.
+func treeRelative(tree, p string) (string, error) {
+	if !filepath.IsAbs(p) {
+		return filepath.ToSlash(filepath.Clean(p)), nil
+	}
+	root, err := filepath.Abs(tree)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, p)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not inside %s", p, tree)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func verifyFileProof(args []string) int {
+	flags := flag.NewFlagSet("verify-file-proof", flag.ExitOnError)
+	rootHash := flags.String("root", "", "known root hash to check the proof against (default: the one the proof records)")
+	file := flags.String("file", "", "also check that this file has the content hash the proof is about")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no proof given")
+		return 2
+	}
+
+	proof, err := inclusion.Load(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if *rootHash == "" {
+		*rootHash = proof.RootHash
+		fmt.Println("Warning: no -root given; the proof is only checked against the root hash it records")
+	}
+	operation.RootHash = *rootHash
+	operation.Params["path"] = proof.Path
+	if err := proof.Verify(*rootHash); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", proof.Path, err)
+		return 1
+	}
+	fmt.Printf("%s (hash %s) is in the tree with root %s\n", proof.Path, proof.LeafHash, *rootHash)
+	if *file == "" {
+		return 0
+	}
+	if err := proof.CheckFile(*file); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%s has the proven content\n", *file)
+	return 0
+}
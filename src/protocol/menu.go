@@ -0,0 +1,306 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// What engines that only speak the numbered menu, such as the C++
+// backend, print around their reports.
+const (
+	menuBanner = "==== Merkle Tree File System CLI ===="
+	menuPrompt = "Choose an option: "
+	notBuilt   = "Build the tree first (option 1)."
+)
+
+// menuChoices are the menu options running the commands.
+var menuChoices = map[string]string{
+	Build:     "1",
+	Tree:      "2",
+	Files:     "3",
+	Stats:     "4",
+	Verify:    "5",
+	Export:    "6",
+	ChunkSize: "7",
+	Exit:      "8",
+	Preview:   "9",
+	List:      "10",
+}
+
+// subPrompts are printed without a trailing newline, so they end up in
+// front of the next line of output.
+var subPrompts = []string{"Enter directory path: ", "Enter new chunk size in bytes: ", "Enter path in the tree: "}
+
+// ServeMenu answers the requests read from in on out by driving an engine
+// that only speaks the numbered menu: menuIn is its input, and menuOut
+// its output and errors together. The reports of the menu are turned into
+// typed results here, so the frontend does not depend on their wording.
+// It returns when in ends, the engine exits or out cannot be written.
+func ServeMenu(in io.Reader, out io.Writer, menuIn io.Writer, menuOut io.Reader) error {
+	m := &menuEngine{in: menuIn, out: menuOut, w: NewWriter(out)}
+	if lines, err := m.read(0); err != nil {
+		// The engine did not come up, such as for invalid options
+		return m.w.Send(0, Ready, nil, exited(lines, err))
+	}
+	if err := m.w.Send(0, Ready, ReadyResult{Engine: "menu"}, nil); err != nil {
+		return err
+	}
+
+	for req := range ReadRequests(in) {
+		result, err := m.run(req)
+		if err := m.w.Send(req.ID, req.Command, result, err); err != nil {
+			return err
+		}
+		if m.gone || req.Command == Exit && req.Err == nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// menuEngine is an engine driven through its menu.
+type menuEngine struct {
+	in       io.Writer
+	out      io.Reader
+	w        *Writer
+	pending  []byte // output read past the last line
+	progress ProgressLines
+	gone     bool // the engine exited
+}
+
+// run runs req on the engine and returns its result.
+func (m *menuEngine) run(req Request) (any, error) {
+	if req.Err != nil {
+		return nil, req.Err
+	}
+	choice, ok := menuChoices[req.Command]
+	if !ok {
+		return nil, fmt.Errorf("unknown command %q", req.Command)
+	}
+	input := choice + "\n"
+	switch req.Command {
+	case Build, Preview, ChunkSize, List:
+		if len(req.Args) != 1 {
+			return nil, fmt.Errorf("%s takes one argument", req.Command)
+		}
+		if strings.ContainsAny(req.Args[0], "\r\n") {
+			return nil, fmt.Errorf("%s: %q contains a line break", req.Command, req.Args[0])
+		}
+		// The menu reads the size as a number and chokes on anything else
+		if _, err := strconv.ParseInt(req.Args[0], 10, 64); req.Command == ChunkSize && err != nil {
+			return nil, fmt.Errorf("invalid chunk size %q", req.Args[0])
+		}
+		input += req.Args[0] + "\n"
+	}
+	if _, err := io.WriteString(m.in, input); err != nil {
+		m.gone = true
+		return nil, fmt.Errorf("the engine exited: %w", err)
+	}
+
+	lines, err := m.read(req.ID)
+	if req.Command == Exit {
+		return nil, nil
+	}
+	if err != nil {
+		m.gone = true
+		return nil, exited(lines, err)
+	}
+	var report Report
+	lines, report.Warnings, err = split(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.Command {
+	case Build, Preview:
+		res := parseBuild(lines)
+		res.Warnings = report.Warnings
+		if req.Command == Build && res.RootHash == "" {
+			return nil, errors.New("the engine did not report the root hash")
+		}
+		return res, nil
+	case Verify:
+		res := &VerifyResult{Report: report}
+		for _, line := range lines {
+			if rest, ok := strings.CutPrefix(line, "Verify throughput: "); ok {
+				res.Throughput = rest
+			} else if line == "Tree integrity verified: OK" {
+				res.OK = true
+			}
+		}
+		return res, nil
+	case Export:
+		return &ExportResult{Report: report, Document: strings.Join(lines, "\n")}, nil
+	case List:
+		res := &ListResult{Report: report}
+		if err := json.Unmarshal([]byte(strings.Join(lines, "\n")), res); err != nil {
+			return nil, fmt.Errorf("the engine listed %s as: %w", req.Args[0], err)
+		}
+		return res, nil
+	case ChunkSize:
+		res := &ChunkSizeResult{Report: report}
+		for _, line := range lines {
+			if _, err := fmt.Sscanf(line, "Chunk size set to %d bytes.", &res.ChunkSize); err == nil {
+				return res, nil
+			}
+		}
+		return nil, errors.New("the engine did not confirm the chunk size")
+	}
+	report.Lines = lines
+	return &report, nil
+}
+
+// read reads the output of the engine up to its next menu, sending the
+// progress lines on the way as progress of request id. It returns the
+// other lines, without the prompts and the menu itself.
+func (m *menuEngine) read(id int) ([]string, error) {
+	var lines []string
+	buf := make([]byte, 32*1024)
+	for {
+		for {
+			i := bytes.IndexByte(m.pending, '\n')
+			if i < 0 {
+				break
+			}
+			line := stripSubPrompts(string(m.pending[:i]))
+			m.pending = m.pending[i+1:]
+			if progress, ok := m.progress.Add(line); ok {
+				if progress != nil {
+					m.w.Send(id, Progress, *progress, nil)
+				}
+				continue
+			}
+			lines = append(lines, line)
+		}
+		if string(m.pending) == menuPrompt {
+			m.pending = nil
+			return withoutMenu(lines), nil
+		}
+
+		n, err := m.out.Read(buf)
+		m.pending = append(m.pending, buf[:n]...)
+		if err != nil {
+			if len(m.pending) > 0 {
+				lines = append(lines, stripSubPrompts(string(m.pending)))
+				m.pending = nil
+			}
+			return lines, err
+		}
+	}
+}
+
+// withoutMenu drops the menu ending lines, with the blank line before it.
+func withoutMenu(lines []string) []string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if lines[i] == menuBanner {
+			if i > 0 && lines[i-1] == "" {
+				i--
+			}
+			return lines[:i]
+		}
+	}
+	return lines
+}
+
+// stripSubPrompts removes the prompts glued to the front of line.
+func stripSubPrompts(line string) string {
+	for trimmed := true; trimmed; {
+		trimmed = false
+		for _, prompt := range subPrompts {
+			if rest, ok := strings.CutPrefix(line, prompt); ok {
+				line, trimmed = rest, true
+			}
+		}
+	}
+	return line
+}
+
+// split takes the warnings and the first error out of the lines of a
+// report.
+func split(lines []string) (report, warnings []string, err error) {
+	for _, line := range lines {
+		if msg, ok := strings.CutPrefix(line, "Error: "); ok && err == nil {
+			err = errors.New(msg)
+		} else if msg, ok := strings.CutPrefix(line, "Warning: "); ok {
+			warnings = append(warnings, msg)
+		} else if line == notBuilt {
+			err = errors.New("no tree has been built")
+		} else {
+			report = append(report, line)
+		}
+	}
+	return report, warnings, err
+}
+
+// exited describes an engine that stopped, with the last lines it wrote.
+func exited(lines []string, err error) error {
+	if err == io.EOF {
+		err = errors.New("the engine exited")
+	}
+	if len(lines) == 0 {
+		return err
+	}
+	return fmt.Errorf("%w:\n%s", err, strings.Join(lines, "\n"))
+}
+
+// parseBuild reads the report of a build or dry run.
+func parseBuild(lines []string) *BuildResult {
+	res := &BuildResult{}
+	for _, line := range lines {
+		switch {
+		case line == "Merkle tree built successfully.", strings.HasPrefix(line, "Dry run: "),
+			strings.HasPrefix(line, "Unreadable entries: "), line == "":
+		case strings.HasPrefix(line, "Root hash: "):
+			res.RootHash = strings.TrimPrefix(line, "Root hash: ")
+		case strings.HasPrefix(line, "Tree size: "), strings.HasPrefix(line, "Would hash: "):
+			_, rest, _ := strings.Cut(line, ": ")
+			fmt.Sscanf(rest, "%d files, %d directories, %d bytes", &res.Files, &res.Directories, &res.Bytes)
+		case strings.HasPrefix(line, "Hash cache: "):
+			fmt.Sscanf(strings.TrimPrefix(line, "Hash cache: "), "%d unchanged files", &res.Cached)
+		case strings.HasPrefix(line, "Unreadable: "):
+			res.Unreadable = append(res.Unreadable, parseEntry(strings.TrimPrefix(line, "Unreadable: ")))
+		case strings.HasPrefix(line, "Would skip: "):
+			res.WouldSkip = append(res.WouldSkip, parseEntry(strings.TrimPrefix(line, "Would skip: ")))
+		case strings.HasPrefix(line, "Skipped entries are listed in "):
+			res.SkippedList = strings.TrimSuffix(strings.TrimPrefix(line, "Skipped entries are listed in "), ".")
+		case strings.HasPrefix(line, "Skipped ") && strings.HasSuffix(line, ")."):
+			count, reason, ok := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(line, "Skipped "), ")."), " entries (")
+			n, err := strconv.Atoi(count)
+			if !ok || err != nil {
+				res.Notes = append(res.Notes, line)
+				break
+			}
+			res.Skipped = append(res.Skipped, SkipCount{Reason: reason, Count: n})
+		case strings.HasPrefix(line, "Symlink cycle: "):
+			res.Cycles = append(res.Cycles, strings.TrimSuffix(strings.TrimPrefix(line, "Symlink cycle: "), " (not followed)"))
+		case strings.HasPrefix(line, "Scope: "):
+			res.Scope = strings.TrimPrefix(line, "Scope: ")
+		case strings.HasPrefix(line, "Tree config: "):
+			file := strings.TrimPrefix(line, "Tree config: ")
+			if i := strings.LastIndex(file, " (chunk size "); i >= 0 {
+				fmt.Sscanf(file[i:], " (chunk size %d bytes)", &res.ConfigChunkSize)
+				file = file[:i]
+			}
+			res.ConfigFile = file
+		case strings.HasPrefix(line, "Build throughput: "):
+			res.Throughput = strings.TrimPrefix(line, "Build throughput: ")
+		default:
+			res.Notes = append(res.Notes, line)
+		}
+	}
+	return res
+}
+
+// parseEntry reads "path (reason)".
+func parseEntry(text string) Entry {
+	i := strings.LastIndex(text, " (")
+	if i < 0 || !strings.HasSuffix(text, ")") {
+		return Entry{Path: text}
+	}
+	return Entry{Path: text[:i], Reason: text[i+2 : len(text)-1]}
+}
@@ -0,0 +1,58 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseWorker reads the line an engine reports a busy worker of a build
+// with, as "Worker 2: 1048576/4194304 bytes of a/big.iso".
+func ParseWorker(line string) (WorkerProgress, bool) {
+	var w WorkerProgress
+	rest, ok := strings.CutPrefix(line, "Worker ")
+	if !ok {
+		return w, false
+	}
+	head, path, ok := strings.Cut(rest, " bytes of ")
+	if !ok {
+		return w, false
+	}
+	if n, err := fmt.Sscanf(head, "%d: %d/%d", &w.Worker, &w.Bytes, &w.Size); err != nil || n != 3 {
+		return w, false
+	}
+	w.Path = path
+	return w, true
+}
+
+// ProgressLines gathers the progress lines of an engine into results. An
+// engine reports its busy workers a line each right before the progress
+// line they go with. It is not safe for use by several goroutines.
+type ProgressLines struct {
+	workers []WorkerProgress
+}
+
+// Add reads a line of the engine and reports whether it is about
+// progress. The result is that of a progress line, with the workers
+// reported before it, and nil for a worker line.
+func (p *ProgressLines) Add(line string) (*ProgressResult, bool) {
+	if w, ok := ParseWorker(line); ok {
+		p.workers = append(p.workers, w)
+		return nil, true
+	}
+	progress, ok := progressLine(line)
+	if !ok {
+		return nil, false
+	}
+	res := &ProgressResult{Line: progress, Workers: p.workers}
+	p.workers = nil
+	return res, true
+}
+
+// progressLine returns the progress a line of the engine reports, if it
+// is a progress line or the total of a pre-scan.
+func progressLine(line string) (string, bool) {
+	if rest, ok := strings.CutPrefix(line, "Progress: "); ok {
+		return rest, true
+	}
+	return line, strings.HasPrefix(line, "Pre-scan: ")
+}
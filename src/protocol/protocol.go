@@ -0,0 +1,229 @@
+// Package protocol is the JSON-lines protocol the frontend speaks with an
+// engine. The frontend writes a Request a line, and the engine answers
+// each with a Response carrying the same ID and command: the typed result
+// of the command, or the error it failed with. While a request runs the
+// engine may send Progress responses for it. The first response, with ID
+// 0, is Ready once the engine can take requests.
+package protocol
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Commands of requests, with the arguments they take and the result they
+// are answered with.
+const (
+	Build     = "build"      // args: directory; result: BuildResult
+	Preview   = "preview"    // args: directory; result: BuildResult of a dry run
+	Tree      = "tree"       // result: Report with a line per node
+	Files     = "files"      // result: Report with the file objects
+	Stats     = "stats"      // result: Report with the statistics
+	Verify    = "verify"     // result: VerifyResult
+	Export    = "export"     // result: ExportResult
+	List      = "list"       // args: path of a directory in the tree, "." for the root; result: ListResult
+	ChunkSize = "chunk_size" // args: bytes; result: ChunkSizeResult
+	Exit      = "exit"       // no result; the engine stops once it answered
+
+	Progress = "progress" // sent by the engine while a request runs; result: ProgressResult
+	Ready    = "ready"    // sent by the engine when it starts; result: ReadyResult
+)
+
+// maxRequest is the longest request line read.
+const maxRequest = 1 << 20
+
+// Request asks the engine to run a command.
+type Request struct {
+	ID      int      `json:"id"`
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+
+	Err error `json:"-"` // set by ReadRequests for a line that is not a request
+}
+
+// Response answers a request, or reports its progress.
+type Response struct {
+	ID      int             `json:"id"`
+	Command string          `json:"command"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Decode stores the result of r in result, or returns the error r
+// reports.
+func (r Response) Decode(result any) error {
+	if r.Error != "" {
+		return errors.New(r.Error)
+	}
+	if len(r.Result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(r.Result, result); err != nil {
+		return fmt.Errorf("%s result: %w", r.Command, err)
+	}
+	return nil
+}
+
+// Report is what every result has: the warnings the command raised, and
+// the lines the engine prints for commands that only report.
+type Report struct {
+	Lines    []string `json:"lines,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Entry is a path the walk left out, with the reason.
+type Entry struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// SkipCount is how many entries the walk skipped for a reason.
+type SkipCount struct {
+	Reason string `json:"reason"`
+	Count  int    `json:"count"`
+}
+
+// BuildResult describes a built tree, or for a dry run what a build would
+// hash and skip.
+type BuildResult struct {
+	Report
+	RootHash        string      `json:"root_hash,omitempty"` // empty for a dry run
+	Files           int64       `json:"files"`
+	Directories     int64       `json:"directories"`
+	Bytes           int64       `json:"bytes"`
+	Cached          int64       `json:"cached,omitempty"`     // files taken from the hash cache of the last build
+	Unreadable      []Entry     `json:"unreadable,omitempty"` // recorded as error leaves
+	Skipped         []SkipCount `json:"skipped,omitempty"`    // in reason order
+	SkippedList     string      `json:"skipped_list,omitempty"`
+	WouldSkip       []Entry     `json:"would_skip,omitempty"` // dry runs only
+	Cycles          []string    `json:"cycles,omitempty"`     // as "path -> ancestor"
+	Scope           string      `json:"scope,omitempty"`
+	ConfigFile      string      `json:"config_file,omitempty"`
+	ConfigChunkSize int64       `json:"config_chunk_size,omitempty"`
+	Throughput      string      `json:"throughput,omitempty"`
+	Notes           []string    `json:"notes,omitempty"` // lines of the report not understood
+}
+
+// VerifyResult is the outcome of a verification.
+type VerifyResult struct {
+	Report
+	OK         bool   `json:"ok"`
+	Throughput string `json:"throughput,omitempty"`
+}
+
+// ExportResult holds the JSON export of the tree.
+type ExportResult struct {
+	Report
+	Document string `json:"document"`
+}
+
+// ListResult is a directory of the tree and its entries, without theirs,
+// so a directory is shown without exporting the tree.
+type ListResult struct {
+	Report
+	Directory ListEntry   `json:"directory"`
+	Entries   []ListEntry `json:"entries"` // in name order
+}
+
+// ListEntry is what the export records of an entry, but that a directory
+// comes with the number of its entries and the size of the files under
+// it rather than its children.
+type ListEntry struct {
+	Name         string            `json:"name"`
+	Type         string            `json:"type"`
+	Hash         string            `json:"hash"`
+	Size         int64             `json:"size,omitempty"`    // of a file, or of the files under a directory but hardlinks
+	Entries      int               `json:"entries,omitempty"` // of a directory
+	Chunks       int               `json:"chunks,omitempty"`
+	ContentHash  string            `json:"content_hash,omitempty"`
+	Target       string            `json:"target,omitempty"`
+	Device       string            `json:"device,omitempty"`
+	Error        string            `json:"error,omitempty"`
+	HardlinkOf   string            `json:"hardlink_of,omitempty"`
+	MetadataHash string            `json:"metadata_hash,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	XattrsHash   string            `json:"xattrs_hash,omitempty"`
+}
+
+// ChunkSizeResult is the chunk size the next builds use.
+type ChunkSizeResult struct {
+	Report
+	ChunkSize int64 `json:"chunk_size"`
+}
+
+// ProgressResult is a progress line, without its "Progress: " prefix,
+// with what the busy workers of a build were hashing at the time.
+type ProgressResult struct {
+	Line    string           `json:"line"`
+	Workers []WorkerProgress `json:"workers,omitempty"`
+}
+
+// WorkerProgress is the file a worker of a build is hashing, and how much
+// of it is hashed.
+type WorkerProgress struct {
+	Worker int    `json:"worker"` // from 1
+	Path   string `json:"path"`   // slash-separated, relative to the tree root
+	Bytes  int64  `json:"bytes"`
+	Size   int64  `json:"size"`
+}
+
+// ReadyResult names the engine that answers.
+type ReadyResult struct {
+	Engine string `json:"engine"`
+}
+
+// ReadRequests reads the requests of in as they come, so that a client
+// sending one while the previous runs is not held up. The channel is
+// closed when in ends.
+func ReadRequests(in io.Reader) <-chan Request {
+	requests := make(chan Request, 16)
+	go func() {
+		defer close(requests)
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(nil, maxRequest)
+		for scanner.Scan() {
+			var req Request
+			if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+				req.Err = fmt.Errorf("invalid request: %w", err)
+			}
+			requests <- req
+		}
+	}()
+	return requests
+}
+
+// Writer writes responses a line each. It is safe for concurrent use, so
+// progress can be sent from the goroutines doing the work.
+type Writer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewWriter returns a Writer writing to out.
+func NewWriter(out io.Writer) *Writer {
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	return &Writer{enc: enc}
+}
+
+// Send answers request id running command with result, or with err if it
+// failed.
+func (w *Writer) Send(id int, command string, result any, err error) error {
+	resp := Response{ID: id, Command: command}
+	if err != nil {
+		resp.Error = err.Error()
+	} else if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		resp.Result = data
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.enc.Encode(resp)
+}
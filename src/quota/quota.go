@@ -0,0 +1,229 @@
+// Package quota checks a tree against the thresholds pinned in its
+// .mtfs/config: max_size, the total size of its files, max_files and
+// max_growth_per_day. Checks of a tree with thresholds note its size in
+// the state directory, at most hourly, so growth is measured against the
+// tree as it was a day earlier.
+package quota
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"MTFS/manifest"
+	"MTFS/state"
+)
+
+// Keys of .mtfs/config holding the thresholds.
+const (
+	SizeKey   = "max_size"
+	FilesKey  = "max_files"
+	GrowthKey = "max_growth_per_day"
+)
+
+// HistoryFile is the name of the sizes noted by checks, inside the state
+// directory.
+const HistoryFile = "sizes"
+
+const (
+	header = "MTFS-SIZES 1"
+	// keep is how long noted sizes are kept.
+	keep = 7 * 24 * time.Hour
+	// every is the least time between two noted sizes, which bounds the
+	// history of trees a daemon scans often.
+	every = time.Hour
+)
+
+// Limits are the thresholds of a tree; zero means none.
+type Limits struct {
+	MaxSize   int64 // bytes
+	MaxFiles  int64
+	MaxGrowth int64 // bytes a day
+}
+
+// Usage is what a tree holds.
+type Usage struct {
+	Files int64
+	Bytes int64
+}
+
+// Of returns the usage of the tree under root.
+func Of(root *manifest.Node) Usage {
+	var u Usage
+	root.Walk(func(_ string, node *manifest.Node) error {
+		if node.IsFile() {
+			u.Files++
+			u.Bytes += node.Size
+		}
+		return nil
+	})
+	return u
+}
+
+// Alert is a threshold a tree exceeds.
+type Alert struct {
+	Key     string
+	Limit   int64
+	Value   int64
+	Message string
+}
+
+// Load reads the thresholds of tree from its .mtfs/config.
+func Load(tree string) (Limits, error) {
+	var l Limits
+	data, err := state.ReadFile(tree, state.ConfigFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	} else if err != nil {
+		return l, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		var err error
+		switch key {
+		case SizeKey:
+			l.MaxSize, err = ParseSize(value)
+		case GrowthKey:
+			l.MaxGrowth, err = ParseSize(value)
+		case FilesKey:
+			l.MaxFiles, err = strconv.ParseInt(value, 10, 64)
+			if err == nil && l.MaxFiles < 0 {
+				err = errors.New("negative")
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return l, fmt.Errorf("%s:%d: invalid %s %q", state.Path(tree, state.ConfigFile), line, key, value)
+		}
+	}
+	return l, scanner.Err()
+}
+
+// ParseSize parses a size the way the backend does: a number of bytes,
+// optionally followed by K, M, G or T, with or without iB or B, for
+// powers of 1024.
+func ParseSize(text string) (int64, error) {
+	digits := 0
+	for digits < len(text) && text[digits] >= '0' && text[digits] <= '9' {
+		digits++
+	}
+	if digits == 0 || digits > 15 {
+		return 0, fmt.Errorf("invalid size %q", text)
+	}
+	n, _ := strconv.ParseInt(text[:digits], 10, 64)
+	suffix := strings.ToUpper(text[digits:])
+	if len(suffix) > 1 {
+		suffix = strings.TrimSuffix(strings.TrimSuffix(suffix, "B"), "I")
+	}
+	if suffix == "" || suffix == "B" {
+		return n, nil
+	}
+	unit := strings.Index("KMGT", suffix)
+	if len(suffix) != 1 || unit < 0 || n > (1<<63-1)>>(10*(unit+1)) {
+		return 0, fmt.Errorf("invalid size %q", text)
+	}
+	return n << (10 * (unit + 1)), nil
+}
+
+// Check compares u, the usage of tree at now, with the thresholds of tree
+// and notes it for later growth checks. Trees without thresholds are left
+// alone.
+func Check(tree string, u Usage, now time.Time) ([]Alert, error) {
+	l, err := Load(tree)
+	if err != nil || l == (Limits{}) {
+		return nil, err
+	}
+
+	var alerts []Alert
+	if l.MaxSize > 0 && u.Bytes > l.MaxSize {
+		alerts = append(alerts, Alert{SizeKey, l.MaxSize, u.Bytes,
+			fmt.Sprintf("total size %d bytes is over %s = %d bytes", u.Bytes, SizeKey, l.MaxSize)})
+	}
+	if l.MaxFiles > 0 && u.Files > l.MaxFiles {
+		alerts = append(alerts, Alert{FilesKey, l.MaxFiles, u.Files,
+			fmt.Sprintf("%d files is over %s = %d", u.Files, FilesKey, l.MaxFiles)})
+	}
+
+	history, err := loadHistory(tree, now)
+	if err != nil {
+		return alerts, err
+	}
+	if base, ok := baseline(history, now); ok && l.MaxGrowth > 0 && u.Bytes-base.Bytes > l.MaxGrowth {
+		growth := u.Bytes - base.Bytes
+		alerts = append(alerts, Alert{GrowthKey, l.MaxGrowth, growth,
+			fmt.Sprintf("grew by %d bytes since %s, over %s = %d bytes", growth, base.Time.Local().Format("2006-01-02 15:04"), GrowthKey, l.MaxGrowth)})
+	}
+	if len(history) > 0 && now.Sub(history[len(history)-1].Time) < every {
+		return alerts, nil
+	}
+	return alerts, saveHistory(tree, append(history, sample{now, u}))
+}
+
+// sample is the usage of a tree at a time.
+type sample struct {
+	Time time.Time
+	Usage
+}
+
+// baseline returns the sample growth over the last day is measured from:
+// the newest one a day old or older, or failing that the oldest one.
+func baseline(history []sample, now time.Time) (sample, bool) {
+	if len(history) == 0 {
+		return sample{}, false
+	}
+	base := history[0]
+	for _, s := range history {
+		if now.Sub(s.Time) >= 24*time.Hour {
+			base = s
+		}
+	}
+	return base, true
+}
+
+// loadHistory reads the sizes noted for tree, oldest first, without those
+// older than keep.
+func loadHistory(tree string, now time.Time) ([]sample, error) {
+	data, err := state.ReadFile(tree, HistoryFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if lines[0] != header {
+		return nil, fmt.Errorf("%s: not a size history", state.Path(tree, HistoryFile))
+	}
+	var history []sample
+	for _, line := range lines[1:] {
+		var s sample
+		var when string
+		if _, err := fmt.Sscanf(line, "%s\t%d\t%d", &when, &s.Files, &s.Bytes); err != nil {
+			continue
+		}
+		if s.Time, err = time.Parse(time.RFC3339, when); err != nil || now.Sub(s.Time) > keep {
+			continue
+		}
+		history = append(history, s)
+	}
+	return history, nil
+}
+
+func saveHistory(tree string, history []sample) error {
+	var b strings.Builder
+	b.WriteString(header + "\n")
+	for _, s := range history {
+		fmt.Fprintf(&b, "%s\t%d\t%d\n", s.Time.UTC().Format(time.RFC3339), s.Files, s.Bytes)
+	}
+	return state.WriteFile(tree, HistoryFile, []byte(b.String()))
+}
@@ -0,0 +1,315 @@
+// Package registry keeps the registry of known trees: the directories
+// fingerprinted before, under a short name, with the stores their chunks
+// went to and the last root hash built. It backs "Open recent" in the TUI
+// and lets commands take a tree's name in place of its directory.
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"MTFS/filelock"
+	"MTFS/paths"
+)
+
+// FileEnv names the environment variable overriding the location of the
+// registry; "off" disables it.
+const FileEnv = "MTFS_TREES"
+
+// ErrOff is returned when changing the registry while it is off.
+var ErrOff = errors.New("the registry is off (" + FileEnv + "=off)")
+
+// Tree is a registered tree.
+type Tree struct {
+	Name     string   `json:"name"`
+	Path     string   `json:"path"`
+	RootHash string   `json:"root_hash,omitempty"`
+	Stores   []string `json:"stores,omitempty"` // most recently used first
+	Updated  string   `json:"updated"`
+}
+
+// Store returns the store the chunks of t last went to, or "".
+func (t Tree) Store() string {
+	if len(t.Stores) == 0 {
+		return ""
+	}
+	return t.Stores[0]
+}
+
+// DefaultPath returns the registry used unless FileEnv says otherwise.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "mtfs", "trees.json"), nil
+}
+
+// Path returns the registry in use, or "" if it is off.
+func Path() (string, error) {
+	switch name := os.Getenv(FileEnv); name {
+	case "off":
+		return "", nil
+	case "":
+		return DefaultPath()
+	default:
+		return name, nil
+	}
+}
+
+// Load returns the registered trees, most recently updated first.
+func Load() ([]Tree, error) {
+	name, err := Path()
+	if err != nil || name == "" {
+		return nil, err
+	}
+	return read(name)
+}
+
+func read(name string) ([]Tree, error) {
+	data, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var trees []Tree
+	if err := json.Unmarshal(data, &trees); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	sort.SliceStable(trees, func(i, j int) bool { return trees[i].Updated > trees[j].Updated })
+	return trees, nil
+}
+
+// update changes the registry with f. Writers are serialized with an
+// exclusive lock beside the registry, and the new registry replaces the
+// old one in one rename.
+func update(f func([]Tree) ([]Tree, error)) error {
+	name, err := Path()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return ErrOff
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o700); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(name+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := filelock.Lock(lock); err != nil {
+		return fmt.Errorf("locking %s: %w", name, err)
+	}
+	defer filelock.Unlock(lock)
+
+	trees, err := read(name)
+	if err != nil {
+		return err
+	}
+	if trees, err = f(trees); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(trees, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".trees-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// validName reports why name cannot name a tree, if it cannot.
+func validName(name string) error {
+	switch {
+	case name == "" || name == "." || name == "..":
+		return fmt.Errorf("%q is not a tree name", name)
+	case strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("tree name %q contains a path separator", name)
+	}
+	return nil
+}
+
+// uniqueName returns base, or base with a number added if another tree
+// has that name.
+func uniqueName(trees []Tree, base, path string) string {
+	taken := func(name string) bool {
+		for _, t := range trees {
+			if t.Name == name && t.Path != path {
+				return true
+			}
+		}
+		return false
+	}
+	if validName(base) != nil {
+		base = "tree"
+	}
+	name := base
+	for n := 2; taken(name); n++ {
+		name = base + "-" + strconv.Itoa(n)
+	}
+	return name
+}
+
+// Add registers the tree at path under name, or under the name of its
+// directory if name is empty. A tree already registered is renamed.
+func Add(path, name string) (Tree, error) {
+	abs, err := paths.Abs(path)
+	if err != nil {
+		return Tree{}, err
+	}
+	if name != "" {
+		if err := validName(name); err != nil {
+			return Tree{}, err
+		}
+	}
+	var added Tree
+	err = update(func(trees []Tree) ([]Tree, error) {
+		i := index(trees, abs)
+		if i < 0 {
+			trees = append(trees, Tree{Path: abs})
+			i = len(trees) - 1
+		}
+		switch {
+		case name != "":
+			if other := find(trees, name); other >= 0 && other != i {
+				return nil, fmt.Errorf("the name %q is already used by %s", name, trees[other].Path)
+			}
+			trees[i].Name = name
+		case trees[i].Name == "":
+			trees[i].Name = uniqueName(trees, filepath.Base(abs), abs)
+		}
+		trees[i].Updated = now()
+		added = trees[i]
+		return trees, nil
+	})
+	return added, err
+}
+
+// Remove unregisters the tree called name.
+func Remove(name string) (Tree, error) {
+	var removed Tree
+	err := update(func(trees []Tree) ([]Tree, error) {
+		i := find(trees, name)
+		if i < 0 {
+			return nil, fmt.Errorf("no tree is registered as %q", name)
+		}
+		removed = trees[i]
+		return append(trees[:i], trees[i+1:]...), nil
+	})
+	return removed, err
+}
+
+// Record notes that the tree at path was built with root hash root, and
+// stored in store unless it is empty, registering the tree if it was
+// not. Nothing is noted while the registry is off.
+func Record(path, root, store string) error {
+	abs, err := paths.Abs(path)
+	if err != nil {
+		return err
+	}
+	err = update(func(trees []Tree) ([]Tree, error) {
+		i := index(trees, abs)
+		if i < 0 {
+			trees = append(trees, Tree{Name: uniqueName(trees, filepath.Base(abs), abs), Path: abs})
+			i = len(trees) - 1
+		}
+		t := &trees[i]
+		if root != "" {
+			t.RootHash = root
+		}
+		if store != "" {
+			stores := []string{store}
+			for _, s := range t.Stores {
+				if s != store {
+					stores = append(stores, s)
+				}
+			}
+			t.Stores = stores
+		}
+		t.Updated = now()
+		return trees, nil
+	})
+	if errors.Is(err, ErrOff) {
+		return nil
+	}
+	return err
+}
+
+// Lookup returns the tree called name.
+func Lookup(name string) (Tree, bool, error) {
+	trees, err := Load()
+	if err != nil {
+		return Tree{}, false, err
+	}
+	if i := find(trees, name); i >= 0 {
+		return trees[i], true, nil
+	}
+	return Tree{}, false, nil
+}
+
+// Resolve returns the directory of the tree called arg, or arg itself if
+// it exists as a file or directory or names no tree, so a directory
+// always wins over a name.
+func Resolve(arg string) string {
+	if _, err := os.Lstat(arg); !errors.Is(err, os.ErrNotExist) {
+		return arg
+	}
+	if t, ok, _ := Lookup(arg); ok {
+		return t.Path
+	}
+	return arg
+}
+
+// ResolveStore returns the store the tree called arg was last stored in,
+// or arg itself like Resolve.
+func ResolveStore(arg string) string {
+	if _, err := os.Lstat(arg); !errors.Is(err, os.ErrNotExist) || strings.Contains(arg, "://") {
+		return arg
+	}
+	if t, ok, _ := Lookup(arg); ok && t.Store() != "" {
+		return t.Store()
+	}
+	return arg
+}
+
+func index(trees []Tree, path string) int {
+	for i, t := range trees {
+		if paths.Same(t.Path, path) {
+			return i
+		}
+	}
+	return -1
+}
+
+func find(trees []Tree, name string) int {
+	for i, t := range trees {
+		if t.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
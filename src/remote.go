@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"MTFS/remote"
+)
+
+func serve(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:8765", "address to listen on; other than loopback ones need a token")
+	token := flags.String("token", os.Getenv(remote.TokenEnv), "bearer token clients must present (default $"+remote.TokenEnv+")")
+	cert := flags.String("cert", "", "TLS certificate file")
+	key := flags.String("key", "", "TLS key file")
+	flags.Parse(args)
+	tree := treeArg(flags, 0)
+	if (*cert == "") != (*key == "") {
+		fmt.Fprintln(os.Stderr, "Error: -cert and -key go together")
+		return 2
+	}
+	if *token == "" && !loopback(*addr) {
+		fmt.Fprintf(os.Stderr, "Error: serving on %s needs -token or $%s\n", *addr, remote.TokenEnv)
+		return 2
+	}
+
+	server := &remote.Server{Tree: tree, Token: *token}
+	info, err := server.Rebuild()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Tree, operation.RootHash = tree, info.Hash
+	fmt.Printf("Serving %s (root %s) on %s\n", tree, info.Hash, *addr)
+	if *token == "" {
+		fmt.Println("Warning: no token set, anyone on this machine can read the tree")
+	}
+	if *cert != "" {
+		err = http.ListenAndServeTLS(*addr, *cert, *key, server.Handler())
+	} else {
+		err = http.ListenAndServe(*addr, server.Handler())
+	}
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	return 1
+}
+
+// loopback reports whether addr only listens on a loopback interface.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func syncTree(args []string) int {
+	flags := flag.NewFlagSet("sync", flag.ExitOnError)
+	token := flags.String("token", os.Getenv(remote.TokenEnv), "bearer token to present (default $"+remote.TokenEnv+")")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no server URL given")
+		return 2
+	}
+	tree := treeArg(flags, 1)
+
+	client := remote.NewClient(flags.Arg(0), *token)
+	stats, err := client.Sync(tree, func(msg string) { fmt.Println(msg) })
+	operation.Tree, operation.RootHash = tree, stats.Root
+	for _, p := range stats.Skipped {
+		fmt.Printf("Skipped %s\n", p)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Compared %d directories in %d round trips; wrote %d files and %d links, removed %d entries\n",
+		stats.Directories, stats.Levels, stats.Files, stats.Links, stats.Removed)
+	fmt.Printf("Chunks: %d, fetched %d (%d bytes), reused %d bytes\n", stats.Chunks, stats.Fetched, stats.FetchedBytes, stats.ReusedBytes)
+	return 0
+}
@@ -0,0 +1,480 @@
+package remote
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"MTFS/forensic"
+	"MTFS/manifest"
+	"MTFS/pkg/merkle"
+)
+
+// Stats summarises a sync.
+type Stats struct {
+	Root         string   // root hash of the served tree
+	Levels       int      // round trips comparing directory hashes
+	Directories  int      // directories whose listings were compared
+	Files        int      // files written
+	Links        int      // symbolic links written
+	Removed      int      // local entries removed
+	Chunks       int      // chunks of the files written
+	Fetched      int      // chunks transferred from the server
+	FetchedBytes int64    // bytes transferred from the server
+	ReusedBytes  int64    // bytes taken from local files
+	Skipped      []string // remote entries that cannot be recreated, such as devices
+}
+
+// Client talks to a sync server.
+type Client struct {
+	URL   string
+	Token string
+	HTTP  *http.Client
+}
+
+// NewClient returns a client for the server at url.
+func NewClient(url, token string) *Client {
+	return &Client{URL: strings.TrimSuffix(url, "/"), Token: token, HTTP: &http.Client{Timeout: 5 * time.Minute}}
+}
+
+// Sync makes dir a copy of the served tree. It adopts the walk options of
+// the remote tree, builds dir with them, and then only descends into
+// directories whose hashes differ. Local entries missing from the remote
+// tree are removed. progress, if not nil, is told what is being done.
+func (c *Client) Sync(dir string, progress func(string)) (Stats, error) {
+	var stats Stats
+	if err := forensic.Refuse("sync into " + dir); err != nil {
+		return stats, err
+	}
+	say := func(format string, args ...any) {
+		if progress != nil {
+			progress(fmt.Sprintf(format, args...))
+		}
+	}
+
+	var info RootInfo
+	if err := c.call(http.MethodGet, "/v1/root", nil, &info); err != nil {
+		return stats, err
+	}
+	stats.Root = info.Hash
+	if info.ChunkSize != ChunkSize {
+		return stats, fmt.Errorf("sync: server uses %d byte chunks, not %d", info.ChunkSize, ChunkSize)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return stats, err
+	}
+	if info.Options != "" {
+		// Recorded as a build records them, once they are known to parse
+		options, err := merkle.ParseOptions([]byte(info.Options))
+		if err != nil {
+			return stats, fmt.Errorf("sync: the server sent %w", err)
+		}
+		if err := options.Save(dir); err != nil {
+			return stats, err
+		}
+	}
+	say("Building %s", dir)
+	local, err := Build(dir)
+	if err != nil {
+		return stats, err
+	}
+	if local.Hash == info.Hash {
+		return stats, nil
+	}
+
+	s := &session{client: c, dir: dir, root: info.Hash, stats: &stats, format: local.HashFormat,
+		local: map[string]*manifest.Node{}, byContent: map[string]string{}}
+	local.Walk(func(p string, node *manifest.Node) error {
+		s.local[p] = node
+		if node.IsFile() {
+			s.byContent[node.ContentHash] = p
+		}
+		return nil
+	})
+
+	// Compare one level of differing directories per round trip
+	for level := []string{"."}; len(level) > 0; {
+		stats.Levels++
+		say("Comparing %d directories", len(level))
+		listings := map[string][]Entry{}
+		if err := c.call(http.MethodPost, "/v1/level", PathsRequest{Root: info.Hash, Paths: level}, &listings); err != nil {
+			return stats, err
+		}
+		var next, files []string
+		remoteFiles := map[string]Entry{}
+		for _, p := range level {
+			stats.Directories++
+			differing, err := s.reconcile(p, listings[p])
+			if err != nil {
+				return stats, err
+			}
+			for _, e := range differing {
+				child := path.Join(p, e.Name)
+				if e.Type == "directory" {
+					next = append(next, child)
+				} else {
+					files = append(files, child)
+					remoteFiles[child] = e
+				}
+			}
+		}
+		if err := s.transfer(files, remoteFiles, say); err != nil {
+			return stats, err
+		}
+		level = next
+	}
+
+	// The copy is only done when it hashes like the original
+	say("Verifying %s", dir)
+	synced, err := Build(dir)
+	if err != nil {
+		return stats, err
+	}
+	if synced.Hash != info.Hash && !(len(synced.Children) == 0 && isEmpty(info.Name, info.Hash)) {
+		return stats, fmt.Errorf("sync: root hash %s of %s does not match the remote root %s", synced.Hash, dir, info.Hash)
+	}
+	return stats, nil
+}
+
+// isEmpty reports whether hash is that of an empty directory called name.
+// Empty directories are hashed from their name, so an empty root and its
+// empty copy only match in that way.
+func isEmpty(name, hash string) bool {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:]) == hash
+}
+
+// session is the state of one sync.
+type session struct {
+	client    *Client
+	dir       string
+	root      string // root hash of the served build being synced
+	stats     *Stats
+	format    string                    // hash format of both trees, as exported
+	local     map[string]*manifest.Node // local tree before the sync, by path
+	byContent map[string]string         // path of a local file by content hash
+}
+
+func (s *session) file(p string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(p))
+}
+
+// checkName checks that name, sent by the server, names an entry of a
+// directory rather than a path leading elsewhere.
+func checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return fmt.Errorf("sync: the server sent the invalid name %q", name)
+	}
+	return nil
+}
+
+// noLinks fails if a directory on the way to p, p included, is a symbolic
+// link, which writing to or removing below p would follow out of the
+// tree.
+func (s *session) noLinks(p string) error {
+	if p == "." {
+		return nil
+	}
+	parts := strings.Split(p, "/")
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		info, err := os.Lstat(s.file(prefix))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("sync: %s is a symbolic link, not written through", prefix)
+		}
+	}
+	return nil
+}
+
+// reconcile compares the listing of the remote directory p with the
+// local one. It removes local entries the remote lacks, writes symbolic
+// links and returns the directories and files that still differ. Names
+// that are not those of entries, or listed twice, fail the sync.
+func (s *session) reconcile(p string, entries []Entry) ([]Entry, error) {
+	if err := s.noLinks(p); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.file(p), 0o755); err != nil {
+		return nil, err
+	}
+	remote := map[string]bool{}
+	var differing []Entry
+	for _, e := range entries {
+		if err := checkName(e.Name); err != nil {
+			return nil, err
+		}
+		if remote[e.Name] {
+			return nil, fmt.Errorf("sync: the server listed %s twice", path.Join(p, e.Name))
+		}
+		remote[e.Name] = true
+		child := path.Join(p, e.Name)
+		localNode := s.local[child]
+		if localNode != nil && localNode.Type == e.Type && localNode.Hash == e.Hash {
+			continue
+		}
+		if localNode != nil && localNode.Type != e.Type {
+			if err := s.remove(child); err != nil {
+				return nil, err
+			}
+		}
+		switch e.Type {
+		case "directory", "file":
+			// A local link followed by the build is replaced, not
+			// written through
+			if info, err := os.Lstat(s.file(child)); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				if err := s.remove(child); err != nil {
+					return nil, err
+				}
+			}
+			differing = append(differing, e)
+		case "symlink":
+			if strings.ContainsRune(e.Target, 0) {
+				return nil, fmt.Errorf("sync: the server sent the invalid link target %q", e.Target)
+			}
+			os.Remove(s.file(child))
+			if err := os.Symlink(e.Target, s.file(child)); err != nil {
+				return nil, err
+			}
+			s.stats.Links++
+		default:
+			s.stats.Skipped = append(s.stats.Skipped, child+" ("+e.Type+")")
+		}
+	}
+
+	if dir := s.local[p]; dir != nil && dir.Type == "directory" {
+		for name := range dir.Children {
+			if !remote[name] {
+				if err := s.remove(path.Join(p, name)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return differing, nil
+}
+
+func (s *session) remove(p string) error {
+	if err := os.RemoveAll(s.file(p)); err != nil {
+		return err
+	}
+	s.stats.Removed++
+	return nil
+}
+
+// transfer writes the given remote files, reusing local content where it
+// can and fetching the remaining chunks.
+func (s *session) transfer(files []string, entries map[string]Entry, say func(string, ...any)) error {
+	var needChunks []string
+	for _, p := range files {
+		e := entries[p]
+		// A file elsewhere in the tree may already have the content
+		if from, ok := s.byContent[e.ContentHash]; ok && s.copyLocal(from, p, e) == nil {
+			continue
+		}
+		needChunks = append(needChunks, p)
+	}
+	if len(needChunks) == 0 {
+		return nil
+	}
+
+	lists := map[string][]string{}
+	if err := s.client.call(http.MethodPost, "/v1/chunks", PathsRequest{Root: s.root, Paths: needChunks}, &lists); err != nil {
+		return err
+	}
+	for _, p := range needChunks {
+		e := entries[p]
+		// Files with the same content are only transferred once
+		if from, ok := s.byContent[e.ContentHash]; ok && s.copyLocal(from, p, e) == nil {
+			continue
+		}
+		say("Transferring %s", p)
+		if err := s.assemble(p, e, lists[p]); err != nil {
+			return fmt.Errorf("sync: %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// copyLocal copies the local file from to p if it still has the content
+// of e.
+func (s *session) copyLocal(from, p string, e Entry) error {
+	src, err := os.Open(s.file(from))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	n, err := s.write(p, e, func(w io.Writer) error {
+		_, err := io.Copy(w, src)
+		return err
+	})
+	if err == nil {
+		s.stats.ReusedBytes += n
+	}
+	return err
+}
+
+// assemble writes p from the given chunks, taking those the old local
+// version of p has from it and fetching the others.
+func (s *session) assemble(p string, e Entry, chunks []string) error {
+	basis := map[string]chunkRef{}
+	if old := s.local[p]; old != nil && old.IsFile() {
+		// A basis that changed since the build only contributes the
+		// chunks that still match, which readChunk checks
+		chunkFile(s.file(p), old, func(hash string, offset int64, length int) {
+			basis[hash] = chunkRef{path: p, offset: offset, length: length}
+		})
+	}
+
+	_, err := s.write(p, e, func(w io.Writer) error {
+		for _, hash := range chunks {
+			s.stats.Chunks++
+			if ref, ok := basis[hash]; ok {
+				if data, err := readChunk(s.file(ref.path), ref.offset, ref.length, hash); err == nil {
+					w.Write(data)
+					s.stats.ReusedBytes += int64(len(data))
+					continue
+				}
+			}
+			data, err := s.client.fetch(s.root, hash)
+			if err != nil {
+				return err
+			}
+			w.Write(data)
+			s.stats.Fetched++
+			s.stats.FetchedBytes += int64(len(data))
+		}
+		return nil
+	})
+	return err
+}
+
+// write creates p from the content fill writes, checks it against e and
+// only then moves it into place.
+func (s *session) write(p string, e Entry, fill func(io.Writer) error) (int64, error) {
+	if err := s.noLinks(path.Dir(p)); err != nil {
+		return 0, err
+	}
+	target := s.file(p)
+	if info, err := os.Lstat(target); err == nil && info.IsDir() {
+		if err := s.remove(p); err != nil {
+			return 0, err
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".mtfs-sync-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	check := manifest.NewContentCheck(e.Size, s.format)
+	counter := &countingWriter{}
+	err = fill(io.MultiWriter(tmp, check, counter))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !check.Matches(e.ContentHash) {
+		return 0, errors.New("assembled content does not match the remote content hash")
+	}
+	mode := os.FileMode(0o644)
+	if e.Executable {
+		mode = 0o755
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return 0, err
+	}
+	s.stats.Files++
+	s.byContent[e.ContentHash] = p
+	return counter.n, nil
+}
+
+type countingWriter struct{ n int64 }
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// fetch downloads a chunk of the build with root hash root and checks it
+// against its hash.
+func (c *Client) fetch(root, hash string) ([]byte, error) {
+	resp, err := c.do(http.MethodGet, "/v1/chunk/"+hash+"?root="+url.QueryEscape(root), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<30))
+	if err != nil {
+		return nil, fmt.Errorf("sync: chunk %s: %w", hash, err)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("sync: chunk %s does not match its hash", hash)
+	}
+	return data, nil
+}
+
+// call sends body as JSON, if not nil, and decodes the response into out.
+func (c *Client) call(method, path string, body, out any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	resp, err := c.do(method, path, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("sync: %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Client) do(method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("sync: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, fmt.Errorf("sync: %s returned %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
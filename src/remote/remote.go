@@ -0,0 +1,260 @@
+// Package remote synchronizes a tree with a copy served by another
+// machine, rsync style but driven by the Merkle tree: directory hashes
+// are compared one level at a time so identical subtrees are never
+// descended into, and files that differ are rebuilt from the chunks
+// already present locally plus the chunks fetched from the server. Every
+// chunk and every file is checked against its hash before it is used,
+// and the synchronized tree is rebuilt and compared with the remote root
+// hash at the end.
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"MTFS/forensic"
+	"MTFS/manifest"
+	"MTFS/pkg/merkle"
+)
+
+const (
+	// Backend is the MTFS backend trees are built with, relative to the
+	// working directory as for the TUI, unless BackendEnv names another.
+	Backend = "merkle/mtfs"
+	// BackendEnv names the environment variable overriding Backend.
+	BackendEnv = "MTFS_BACKEND"
+	// EngineEnv names the environment variable selecting the engine:
+	// GoEngine builds trees in process with package merkle, anything
+	// else runs the backend.
+	EngineEnv = "MTFS_ENGINE"
+	// GoEngine is the value of EngineEnv selecting the Go engine.
+	GoEngine = "go"
+	// HashCacheEnv names the environment variable turning the hash cache
+	// of both engines off when "0".
+	HashCacheEnv = "MTFS_HASH_CACHE"
+	// ChunkSize is the size of the chunks files are compared and
+	// transferred in.
+	ChunkSize = 256 * 1024
+	// TokenEnv names the environment variable holding the bearer token
+	// the server requires and the client presents.
+	TokenEnv = "MTFS_SYNC_TOKEN"
+	// OptionsFile is the name of the recorded walk options inside the
+	// state directory of a tree.
+	OptionsFile = "options"
+)
+
+// RootInfo describes the served tree.
+type RootInfo struct {
+	Name      string `json:"name"`
+	Hash      string `json:"hash"`
+	ChunkSize int    `json:"chunk_size"`
+	// Options are the recorded walk options of the tree, so the client
+	// builds its copy with the same scope and hash format.
+	Options string `json:"options,omitempty"`
+}
+
+// Entry is a child of a directory in a listing.
+type Entry struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Hash        string `json:"hash"`
+	Size        int64  `json:"size,omitempty"`
+	ContentHash string `json:"content_hash,omitempty"`
+	Target      string `json:"target,omitempty"`
+	Executable  bool   `json:"executable,omitempty"`
+}
+
+// PathsRequest names the directories to list or the files whose chunks
+// to return, in the build of the tree with root hash Root, the latest if
+// empty.
+type PathsRequest struct {
+	Root  string   `json:"root,omitempty"`
+	Paths []string `json:"paths"`
+}
+
+// backendPrompts are printed by the backend without a trailing newline.
+var backendPrompts = []string{"Choose an option: ", "Enter directory path: "}
+
+// BackendPath returns the backend to run: $MTFS_BACKEND, or Backend.
+func BackendPath() string {
+	if path := os.Getenv(BackendEnv); path != "" {
+		return path
+	}
+	return Backend
+}
+
+// UseGoEngine reports whether trees are built by the Go engine rather
+// than the backend.
+func UseGoEngine() bool {
+	return os.Getenv(EngineEnv) == GoEngine
+}
+
+// RehashAll turns the hash cache off for the builds that follow, so every
+// file is hashed again: a file edited in place with its size and mtime
+// kept would otherwise keep its cached hash, as verifications must not.
+func RehashAll() {
+	os.Setenv(HashCacheEnv, "0")
+}
+
+// RunEngine runs the text menu of the engine, with walkArgs on its command
+// line, on stdin and writes what it prints to stdout and stderr. The Go
+// engine runs in process, the backend as a subprocess.
+func RunEngine(walkArgs []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	if UseGoEngine() {
+		if status := merkle.RunMenu(append([]string{"mtfs"}, walkArgs...), stdin, stdout, stderr); status != 0 {
+			return fmt.Errorf("exit status %d", status)
+		}
+		return nil
+	}
+	cmd := exec.Command(BackendPath(), walkArgs...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	return cmd.Run()
+}
+
+// Preview runs a dry run of the engine on dir, with walkArgs in addition
+// to the walk options recorded for it, and returns its report: what a
+// build would hash and what it would skip, and why. Nothing is hashed or
+// recorded.
+func Preview(dir string, walkArgs []string) ([]string, error) {
+	if err := forensic.CheckTree(dir); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := RunEngine(walkArgs, strings.NewReader("9\n"+dir+"\n8\n"), &out, &out); err != nil {
+		return nil, fmt.Errorf("backend: %w", err)
+	}
+
+	var report []string
+	started := false
+	for _, line := range strings.Split(out.String(), "\n") {
+		for _, prompt := range backendPrompts {
+			for strings.HasPrefix(line, prompt) {
+				line = strings.TrimPrefix(line, prompt)
+			}
+		}
+		if msg, ok := strings.CutPrefix(line, "Error: "); ok {
+			return nil, fmt.Errorf("preview %s: %s", dir, msg)
+		}
+		if strings.HasPrefix(line, "Dry run: ") {
+			started = true
+		}
+		if !started {
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "====") {
+			return report, nil
+		}
+		report = append(report, line)
+	}
+	if !started {
+		return nil, errors.New("backend: no dry run was reported")
+	}
+	return report, nil
+}
+
+// Build runs the engine on dir with the walk options recorded for it and
+// returns the exported tree.
+func Build(dir string) (*manifest.Node, error) {
+	if err := forensic.CheckTree(dir); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := RunEngine(nil, strings.NewReader("1\n"+dir+"\n6\n8\n"), &out, &out); err != nil {
+		return nil, fmt.Errorf("backend: %w", err)
+	}
+
+	var export []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		for _, prompt := range backendPrompts {
+			for strings.HasPrefix(line, prompt) {
+				line = strings.TrimPrefix(line, prompt)
+			}
+		}
+		if export == nil {
+			if msg, ok := strings.CutPrefix(line, "Error: "); ok {
+				return nil, fmt.Errorf("build %s: %s", dir, msg)
+			}
+			if line != "{" {
+				continue
+			}
+		}
+		export = append(export, line)
+		if line == "}" {
+			return manifest.Parse([]byte(strings.Join(export, "\n")))
+		}
+	}
+	return nil, errors.New("backend: no tree was exported")
+}
+
+// Menu options run after a build by Report.
+const (
+	PrintTree  = 2
+	PrintFiles = 3
+	Statistics = 4
+	Verify     = 5
+	ExportJSON = 6
+)
+
+// Report builds dir with the engine, with walkArgs in addition to the
+// walk options recorded for it, then runs each of options on the built
+// tree. It returns the lines the build printed followed by those of each
+// option, without prompts or progress lines.
+func Report(dir string, walkArgs []string, options ...int) ([][]string, error) {
+	if err := forensic.CheckTree(dir); err != nil {
+		return nil, err
+	}
+	input := "1\n" + dir + "\n"
+	for _, option := range options {
+		input += strconv.Itoa(option) + "\n"
+	}
+	var out bytes.Buffer
+	if err := RunEngine(walkArgs, strings.NewReader(input+"8\n"), &out, &out); err != nil {
+		return nil, fmt.Errorf("backend: %w", err)
+	}
+
+	// Each menu ends with the option prompt, followed by what the option
+	// printed up to the next menu
+	sections := strings.Split(out.String(), backendPrompts[0])
+	if len(sections) < len(options)+2 {
+		return nil, errors.New("backend: the menu ended early")
+	}
+	var reports [][]string
+	for _, section := range sections[1 : len(options)+2] {
+		section, _, _ = strings.Cut(section, "\n==== ")
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSuffix(section, "\n"), "\n") {
+			for _, prompt := range backendPrompts {
+				for strings.HasPrefix(line, prompt) {
+					line = strings.TrimPrefix(line, prompt)
+				}
+			}
+			if !strings.HasPrefix(line, "Progress: ") {
+				lines = append(lines, line)
+			}
+		}
+		reports = append(reports, lines)
+	}
+	for _, line := range reports[0] {
+		if msg, ok := strings.CutPrefix(line, "Error: "); ok {
+			return nil, fmt.Errorf("build %s: %s", dir, msg)
+		}
+	}
+	return reports, nil
+}
+
+func entryOf(node *manifest.Node) Entry {
+	return Entry{
+		Name:        node.Name,
+		Type:        node.Type,
+		Hash:        node.Hash,
+		Size:        node.Size,
+		ContentHash: node.ContentHash,
+		Target:      node.Target,
+	}
+}
@@ -0,0 +1,299 @@
+package remote
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"MTFS/forensic"
+	"MTFS/manifest"
+	"MTFS/state"
+)
+
+// maxRequest bounds the JSON bodies the server reads.
+const maxRequest = 16 << 20
+
+// keptSnapshots is how many builds of the tree the server keeps serving,
+// so a sync started before the tree changed can still finish.
+const keptSnapshots = 4
+
+// Server serves a tree to sync clients. The tree is rebuilt whenever a
+// client starts a sync, so every session sees its current state; the
+// session then asks for the build it started with by its root hash.
+type Server struct {
+	Tree  string // root directory of the served tree
+	Token string // bearer token clients must present, none if empty
+
+	build     sync.Mutex // held while the tree is rebuilt
+	mu        sync.RWMutex
+	snapshots []*snapshot // the latest last
+}
+
+// snapshot is the tree as one build found it.
+type snapshot struct {
+	info  RootInfo
+	nodes map[string]*manifest.Node // by slash-separated path, "." for the root
+
+	mu     sync.RWMutex
+	chunks map[string]chunkRef // chunks of the files listed so far, by hash
+}
+
+// chunkRef locates a chunk in a file of the tree.
+type chunkRef struct {
+	path   string
+	offset int64
+	length int
+}
+
+// Handler returns the HTTP handler of the sync protocol.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/root", s.handleRoot)
+	mux.HandleFunc("POST /v1/level", s.handleLevel)
+	mux.HandleFunc("POST /v1/chunks", s.handleChunks)
+	mux.HandleFunc("GET /v1/chunk/{hash}", s.handleChunk)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := "Bearer " + s.Token
+		if s.Token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+			http.Error(w, "missing or wrong sync token", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// Rebuild builds the tree again. A build with a new root hash is served
+// alongside the last ones; one with the hash of a kept build keeps that
+// build, and the chunks listed in it.
+func (s *Server) Rebuild() (RootInfo, error) {
+	s.build.Lock()
+	defer s.build.Unlock()
+	root, err := Build(s.Tree)
+	if err != nil {
+		return RootInfo{}, err
+	}
+	options, err := state.ReadFile(s.Tree, OptionsFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return RootInfo{}, err
+	}
+
+	nodes := map[string]*manifest.Node{}
+	root.Walk(func(p string, node *manifest.Node) error {
+		nodes[p] = node
+		return nil
+	})
+	info := RootInfo{Name: root.Name, Hash: root.Hash, ChunkSize: ChunkSize, Options: string(options)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := &snapshot{info: info, nodes: nodes, chunks: map[string]chunkRef{}}
+	var kept []*snapshot
+	for _, old := range s.snapshots {
+		if old.info.Hash == info.Hash {
+			// The tree is again as a kept build found it
+			snap = old
+		} else {
+			kept = append(kept, old)
+		}
+	}
+	kept = append(kept, snap)
+	s.snapshots = kept[max(0, len(kept)-keptSnapshots):]
+	return info, nil
+}
+
+// snapshot returns the kept build with root hash root, the latest if root
+// is empty.
+func (s *Server) snapshot(root string) (*snapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := len(s.snapshots) - 1; i >= 0; i-- {
+		if root == "" || s.snapshots[i].info.Hash == root {
+			return s.snapshots[i], nil
+		}
+	}
+	return nil, fmt.Errorf("the tree with root %s is no longer served", root)
+}
+
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	info, err := s.Rebuild()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, info)
+}
+
+// handleLevel lists the children of the requested directories.
+func (s *Server) handleLevel(w http.ResponseWriter, r *http.Request) {
+	var req PathsRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequest)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	snap, err := s.snapshot(req.Root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+
+	listings := make(map[string][]Entry, len(req.Paths))
+	for _, p := range req.Paths {
+		dir, ok := snap.nodes[p]
+		if !ok || dir.Type != "directory" {
+			http.Error(w, fmt.Sprintf("%s is not a directory of the tree", p), http.StatusNotFound)
+			return
+		}
+		entries := []Entry{}
+		for _, child := range dir.SortedChildren() {
+			e := entryOf(child)
+			if child.IsFile() {
+				info, err := os.Stat(s.file(path.Join(p, child.Name)))
+				e.Executable = err == nil && info.Mode()&0o100 != 0
+			}
+			entries = append(entries, e)
+		}
+		listings[p] = entries
+	}
+	writeJSON(w, listings)
+}
+
+// handleChunks returns the chunk hashes of the requested files, checking
+// each file against the tree while it is read.
+func (s *Server) handleChunks(w http.ResponseWriter, r *http.Request) {
+	var req PathsRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequest)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	snap, err := s.snapshot(req.Root)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+
+	lists := make(map[string][]string, len(req.Paths))
+	found := map[string]chunkRef{}
+	for _, p := range req.Paths {
+		node, ok := snap.nodes[p]
+		if !ok || !node.IsFile() {
+			http.Error(w, fmt.Sprintf("%s is not a file of the tree", p), http.StatusNotFound)
+			return
+		}
+		hashes, err := chunkFile(s.file(p), node, func(hash string, offset int64, length int) {
+			found[hash] = chunkRef{path: p, offset: offset, length: length}
+		})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("%s: %v", p, err), http.StatusConflict)
+			return
+		}
+		lists[p] = hashes
+	}
+
+	snap.mu.Lock()
+	for hash, ref := range found {
+		snap.chunks[hash] = ref
+	}
+	snap.mu.Unlock()
+	writeJSON(w, lists)
+}
+
+// handleChunk sends one chunk of a file listed before in the build the
+// root query parameter names.
+func (s *Server) handleChunk(w http.ResponseWriter, r *http.Request) {
+	hash := r.PathValue("hash")
+	snap, err := s.snapshot(r.URL.Query().Get("root"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	snap.mu.RLock()
+	ref, ok := snap.chunks[hash]
+	snap.mu.RUnlock()
+	if !ok {
+		http.Error(w, "unknown chunk "+hash, http.StatusNotFound)
+		return
+	}
+
+	data, err := readChunk(s.file(ref.path), ref.offset, ref.length, hash)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s: %v", ref.path, err), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+func (s *Server) file(p string) string {
+	return filepath.Join(s.Tree, filepath.FromSlash(p))
+}
+
+// chunkFile splits the file at name into ChunkSize chunks, calls found for
+// each and returns their hashes. It fails if the file does not match
+// node.
+func chunkFile(name string, node *manifest.Node, found func(hash string, offset int64, length int)) ([]string, error) {
+	f, err := forensic.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	check := node.NewContentCheck()
+	var hashes []string
+	var offset int64
+	buf := make([]byte, ChunkSize)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			sum := sha256.Sum256(buf[:n])
+			hash := hex.EncodeToString(sum[:])
+			check.Write(buf[:n])
+			hashes = append(hashes, hash)
+			if found != nil {
+				found(hash, offset, n)
+			}
+			offset += int64(n)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !check.Matches(node.ContentHash) {
+		return nil, errors.New("changed since the tree was built")
+	}
+	return hashes, nil
+}
+
+// readChunk reads length bytes at offset of the file at name and checks
+// them against hash.
+func readChunk(name string, offset int64, length int, hash string) ([]byte, error) {
+	f, err := forensic.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, length)
+	if _, err := f.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != hash {
+		return nil, errors.New("changed since the tree was built")
+	}
+	return data, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
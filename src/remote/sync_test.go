@@ -0,0 +1,316 @@
+package remote
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"MTFS/pkg/merkle"
+)
+
+// writeFiles writes files, by slash-separated path, under dir. A content
+// starting with "->" makes a symlink to the rest.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		if target, ok := strings.CutPrefix(content, "->"); ok {
+			err = os.Symlink(target, p)
+		} else {
+			err = os.WriteFile(p, []byte(content), 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// goEngine builds trees with the Go engine for the rest of the test.
+func goEngine(t *testing.T) {
+	t.Setenv(EngineEnv, GoEngine)
+	t.Setenv(HashCacheEnv, "0")
+}
+
+// A copy that shares some files with the served tree, holds others it
+// lacks and misses the rest ends up with the served root hash, taking what
+// it can from local files.
+func TestSyncRoundTrip(t *testing.T) {
+	goEngine(t)
+	big := bytes.Repeat([]byte("0123456789abcdef"), ChunkSize/8)
+	served := t.TempDir()
+	writeFiles(t, served, map[string]string{
+		"a":         "hi\n",
+		"sub/b":     "hello\n",
+		"sub/big":   string(big),
+		"sub/moved": "kept content\n",
+		"link":      "->sub/b",
+	})
+	o := merkle.DefaultOptions()
+	o.Symlinks = merkle.RecordSymlinks
+	if err := o.Save(served); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer((&Server{Tree: served, Token: "secret"}).Handler())
+	defer server.Close()
+
+	dest := t.TempDir()
+	edited := slicesReplace(big, ChunkSize+5, 'X')
+	writeFiles(t, dest, map[string]string{
+		"a":         "hi\n",
+		"stale":     "removed\n",
+		"sub/big":   string(edited),
+		"elsewhere": "kept content\n",
+	})
+	if _, err := NewClient(server.URL, "wrong").Sync(dest, nil); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("synced with the wrong token: %v", err)
+	}
+	stats, err := NewClient(server.URL, "secret").Sync(dest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := Build(served)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Root != root.Hash {
+		t.Errorf("synced root %s, served %s", stats.Root, root.Hash)
+	}
+	if stats.Links != 1 || stats.Fetched != 2 || stats.ReusedBytes < int64(ChunkSize) {
+		t.Errorf("got %+v, want the link written and the unchanged chunk reused", stats)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "stale")); !os.IsNotExist(err) {
+		t.Errorf("stale was kept: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "link")); err != nil || target != "sub/b" {
+		t.Errorf("link points to %q, %v", target, err)
+	}
+	got, err := os.ReadFile(filepath.Join(dest, "sub", "big"))
+	if err != nil || !bytes.Equal(got, big) {
+		t.Errorf("sub/big: %d bytes, %v", len(got), err)
+	}
+
+	// Synced again, nothing is transferred
+	stats, err = NewClient(server.URL, "secret").Sync(dest, nil)
+	if err != nil || stats.Levels != 0 || stats.Fetched != 0 {
+		t.Errorf("second sync: %+v, %v", stats, err)
+	}
+}
+
+func slicesReplace(data []byte, i int, b byte) []byte {
+	edited := bytes.Clone(data)
+	edited[i] = b
+	return edited
+}
+
+// A sync started before another rebuilt the tree still lists and fetches
+// the build it started with.
+func TestServerKeepsSnapshots(t *testing.T) {
+	goEngine(t)
+	served := t.TempDir()
+	writeFiles(t, served, map[string]string{"a": "first\n"})
+	s := &Server{Tree: served}
+	first, err := s.Rebuild()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+	c := NewClient(server.URL, "")
+
+	writeFiles(t, served, map[string]string{"b": "second\n"})
+	var latest RootInfo
+	if err := c.call(http.MethodGet, "/v1/root", nil, &latest); err != nil {
+		t.Fatal(err)
+	}
+	if latest.Hash == first.Hash {
+		t.Fatal("the rebuild did not see the new file")
+	}
+	for root, want := range map[string]int{first.Hash: 1, latest.Hash: 2} {
+		listings := map[string][]Entry{}
+		if err := c.call(http.MethodPost, "/v1/level", PathsRequest{Root: root, Paths: []string{"."}}, &listings); err != nil {
+			t.Fatal(err)
+		}
+		if len(listings["."]) != want {
+			t.Errorf("root %s lists %v, want %d entries", root, listings["."], want)
+		}
+	}
+	lists := map[string][]string{}
+	if err := c.call(http.MethodPost, "/v1/chunks", PathsRequest{Root: first.Hash, Paths: []string{"a"}}, &lists); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := c.fetch(first.Hash, lists["a"][0]); err != nil || string(data) != "first\n" {
+		t.Errorf("chunk of the first build: %q, %v", data, err)
+	}
+	if _, err := c.fetch(latest.Hash, lists["a"][0]); err == nil {
+		t.Error("a chunk listed in one build was served from another")
+	}
+
+	// Builds beyond the last few are forgotten
+	for i := range keptSnapshots {
+		writeFiles(t, served, map[string]string{"more" + string(rune('0'+i)): "\n"})
+		if _, err := s.Rebuild(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = c.call(http.MethodPost, "/v1/level", PathsRequest{Root: first.Hash, Paths: []string{"."}}, &map[string][]Entry{})
+	if err == nil || !strings.Contains(err.Error(), "no longer served") {
+		t.Errorf("got %v for a forgotten build", err)
+	}
+}
+
+// hostileServer serves listings and files as given, whatever they name.
+// Files are sent in one chunk.
+func hostileServer(t *testing.T, info RootInfo, listings map[string][]Entry, files map[string]string) string {
+	t.Helper()
+	info.ChunkSize = ChunkSize
+	chunks := map[string]string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/root", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, info)
+	})
+	mux.HandleFunc("POST /v1/level", func(w http.ResponseWriter, r *http.Request) {
+		var req PathsRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		out := map[string][]Entry{}
+		for _, p := range req.Paths {
+			out[p] = listings[p]
+		}
+		writeJSON(w, out)
+	})
+	mux.HandleFunc("POST /v1/chunks", func(w http.ResponseWriter, r *http.Request) {
+		var req PathsRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		out := map[string][]string{}
+		for _, p := range req.Paths {
+			sum := sha256.Sum256([]byte(files[p]))
+			hash := hex.EncodeToString(sum[:])
+			chunks[hash] = files[p]
+			out[p] = []string{hash}
+		}
+		writeJSON(w, out)
+	})
+	mux.HandleFunc("GET /v1/chunk/{hash}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(chunks[r.PathValue("hash")]))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+// fileEntry lists content as a file called name, hashed as the client
+// checks it.
+func fileEntry(t *testing.T, name, content string) Entry {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{name: content})
+	root, err := Build(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entryOf(root.SortedChildren()[0])
+}
+
+// Names that lead out of their directory, links written through and
+// walk options that do not parse fail the sync, and nothing is written
+// outside the copy.
+func TestSyncRefusesHostileServer(t *testing.T) {
+	goEngine(t)
+	tests := []struct {
+		name     string
+		options  string
+		listings map[string][]Entry
+		files    map[string]string
+		want     string
+	}{
+		{name: "dot dot", listings: map[string][]Entry{".": {{Name: "..", Type: "directory", Hash: "x"}}},
+			want: "invalid name"},
+		{name: "dot", listings: map[string][]Entry{".": {{Name: ".", Type: "directory", Hash: "x"}}},
+			want: "invalid name"},
+		{name: "empty", listings: map[string][]Entry{".": {{Name: "", Type: "symlink", Hash: "x", Target: "/"}}},
+			want: "invalid name"},
+		{name: "slash", listings: map[string][]Entry{".": {{Name: "a/b", Type: "symlink", Hash: "x", Target: "/"}}},
+			want: "invalid name"},
+		{name: "backslash", listings: map[string][]Entry{".": {{Name: `..\b`, Type: "symlink", Hash: "x", Target: "/"}}},
+			want: "invalid name"},
+		{name: "nul", listings: map[string][]Entry{".": {{Name: "a\x00b", Type: "symlink", Hash: "x", Target: "/"}}},
+			want: "invalid name"},
+		{name: "nul target", listings: map[string][]Entry{".": {{Name: "l", Type: "symlink", Hash: "x", Target: "/\x00"}}},
+			want: "invalid link target"},
+		{name: "link then directory", listings: map[string][]Entry{
+			".": {{Name: "l", Type: "symlink", Hash: "x", Target: "OUTSIDE"}, {Name: "l", Type: "directory", Hash: "y"}},
+			"l": {fileEntry(t, "f", "evil\n")},
+		}, files: map[string]string{"l/f": "evil\n"}, want: "listed l twice"},
+		{name: "options", options: "MTFS-OPTIONS 1\nsymlinks\tsideways\n", want: "invalid walk option"},
+		{name: "options header", options: "symlinks\trecord\n", want: "no walk options header"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base := t.TempDir()
+			outside := filepath.Join(base, "outside")
+			dest := filepath.Join(base, "dest")
+			if err := os.Mkdir(outside, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			listings := map[string][]Entry{}
+			for p, entries := range test.listings {
+				for _, e := range entries {
+					e.Target = strings.ReplaceAll(e.Target, "OUTSIDE", outside)
+					listings[p] = append(listings[p], e)
+				}
+			}
+			url := hostileServer(t, RootInfo{Name: "dest", Hash: "f00", Options: test.options}, listings, test.files)
+			_, err := NewClient(url, "").Sync(dest, nil)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want an error containing %q", err, test.want)
+			}
+			if entries, _ := os.ReadDir(outside); len(entries) > 0 {
+				t.Errorf("wrote %s outside the dest", entries[0].Name())
+			}
+			if test.options != "" {
+				if _, err := os.Stat(filepath.Join(dest, ".mtfs", OptionsFile)); !os.IsNotExist(err) {
+					t.Errorf("the options were recorded: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// A link already in the copy where the server has a directory is replaced
+// by the directory, not written through.
+func TestSyncReplacesLocalLink(t *testing.T) {
+	goEngine(t)
+	base := t.TempDir()
+	outside := filepath.Join(base, "outside")
+	dest := filepath.Join(base, "dest")
+	if err := os.Mkdir(outside, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dest, map[string]string{"l": "->" + outside})
+
+	f := fileEntry(t, "f", "evil\n")
+	url := hostileServer(t, RootInfo{Name: "dest", Hash: "f00"}, map[string][]Entry{
+		".": {{Name: "l", Type: "directory", Hash: "y"}},
+		"l": {f},
+	}, map[string]string{"l/f": "evil\n"})
+	// The made up root hash never matches
+	if _, err := NewClient(url, "").Sync(dest, nil); err == nil || !strings.Contains(err.Error(), "does not match the remote root") {
+		t.Errorf("got %v", err)
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) > 0 {
+		t.Errorf("wrote %s through the link", entries[0].Name())
+	}
+	if got, err := os.ReadFile(filepath.Join(dest, "l", "f")); err != nil || string(got) != "evil\n" {
+		t.Errorf("l/f: %q, %v", got, err)
+	}
+}
@@ -0,0 +1,435 @@
+// Package script runs automation scripts written in Starlark, a dialect
+// of Python, against the MTFS API: building trees, comparing them,
+// exporting them, storing snapshots and proving trees are covered by a
+// super-root, without recompiling MTFS. Scripts see the API as the mtfs
+// module and Starlark's json module.
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"MTFS/chunking"
+	"MTFS/manifest"
+	"MTFS/monitor"
+	"MTFS/paths"
+	"MTFS/registry"
+	"MTFS/remote"
+	"MTFS/store"
+	"MTFS/superroot"
+
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// DefaultChunkSize is the chunk size snapshots are stored with unless a
+// script gives one, that of the TUI.
+const DefaultChunkSize = 1024 * 1024
+
+// options allow what automation needs beyond the core dialect: sets,
+// while loops, recursion, reassigned globals and statements at the top
+// level.
+var options = &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true, Recursion: true}
+
+// Run runs the script in filename, which sees args as mtfs.args. What it
+// prints goes to standard output. A failing script returns its error with
+// the Starlark backtrace.
+func Run(filename string, args []string) error {
+	list := make([]starlark.Value, len(args))
+	for i, a := range args {
+		list[i] = starlark.String(a)
+	}
+	predeclared := starlark.StringDict{
+		"mtfs": &starlarkstruct.Module{Name: "mtfs", Members: starlark.StringDict{
+			"args":         starlark.NewList(list),
+			"build":        starlark.NewBuiltin("build", build),
+			"read":         starlark.NewBuiltin("read", readExport),
+			"diff":         starlark.NewBuiltin("diff", diff),
+			"trees":        starlark.NewBuiltin("trees", trees),
+			"snapshot":     starlark.NewBuiltin("snapshot", snapshot),
+			"snapshots":    starlark.NewBuiltin("snapshots", snapshots),
+			"super_root":   starlark.NewBuiltin("super_root", superRoot),
+			"prove":        starlark.NewBuiltin("prove", prove),
+			"verify_proof": starlark.NewBuiltin("verify_proof", verifyProof),
+		}},
+		"json": starlarkjson.Module,
+	}
+	predeclared.Freeze()
+
+	loaded := map[string]*module{}
+	thread := &starlark.Thread{
+		Name:  filename,
+		Print: func(_ *starlark.Thread, msg string) { fmt.Println(msg) },
+	}
+	// load() names files relative to the script loading them
+	thread.Load = func(thread *starlark.Thread, name string) (starlark.StringDict, error) {
+		name = filepath.Join(filepath.Dir(thread.Name), name)
+		m, ok := loaded[name]
+		if ok && m == nil {
+			return nil, fmt.Errorf("load cycle through %s", name)
+		} else if ok {
+			return m.globals, m.err
+		}
+		loaded[name] = nil
+		child := &starlark.Thread{Name: name, Print: thread.Print, Load: thread.Load}
+		globals, err := starlark.ExecFileOptions(options, child, name, nil, predeclared)
+		loaded[name] = &module{globals, err}
+		return globals, err
+	}
+
+	_, err := starlark.ExecFileOptions(options, thread, filename, nil, predeclared)
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		return fmt.Errorf("%s", evalErr.Backtrace())
+	}
+	return err
+}
+
+// module is a file loaded by a script.
+type module struct {
+	globals starlark.StringDict
+	err     error
+}
+
+// Tree is a built or loaded tree as scripts see it: its path, empty for
+// a tree loaded from an export, and root hash as attributes, and the
+// files, node and export methods.
+type Tree struct {
+	path string
+	root *manifest.Node
+}
+
+var _ starlark.HasAttrs = (*Tree)(nil)
+
+func (t *Tree) String() string {
+	if t.path == "" {
+		return fmt.Sprintf("<tree %s>", t.root.Hash)
+	}
+	return fmt.Sprintf("<tree %s %s>", t.path, t.root.Hash)
+}
+
+func (t *Tree) Type() string          { return "mtfs.tree" }
+func (t *Tree) Freeze()               {}
+func (t *Tree) Truth() starlark.Bool  { return starlark.True }
+func (t *Tree) Hash() (uint32, error) { return starlark.String(t.root.Hash).Hash() }
+
+func (t *Tree) AttrNames() []string { return []string{"export", "files", "node", "path", "root"} }
+
+func (t *Tree) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "path":
+		return starlark.String(t.path), nil
+	case "root":
+		return starlark.String(t.root.Hash), nil
+	case "files":
+		return starlark.NewBuiltin("files", treeFiles).BindReceiver(t), nil
+	case "node":
+		return starlark.NewBuiltin("node", treeNode).BindReceiver(t), nil
+	case "export":
+		return starlark.NewBuiltin("export", treeExport).BindReceiver(t), nil
+	}
+	return nil, nil
+}
+
+// build(dir) builds a tree, or a registered one by name, with the walk
+// options recorded for it and notes its root hash in the registry.
+func build(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var dir string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "dir", &dir); err != nil {
+		return nil, err
+	}
+	dir = registry.Resolve(dir)
+	if abs, err := paths.Abs(dir); err == nil {
+		dir = abs
+	}
+	root, err := remote.Build(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	if err := registry.Record(dir, root.Hash, ""); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return &Tree{dir, root}, nil
+}
+
+// read(file) reads a tree exported to file.
+func readExport(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "file", &name); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	root, err := manifest.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %v", b.Name(), name, err)
+	}
+	return &Tree{root: root}, nil
+}
+
+// tree.files() lists the paths of the files of the tree.
+func treeFiles(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	var files []starlark.Value
+	b.Receiver().(*Tree).root.Walk(func(p string, node *manifest.Node) error {
+		if node.IsFile() {
+			files = append(files, starlark.String(p))
+		}
+		return nil
+	})
+	return starlark.NewList(files), nil
+}
+
+// tree.node(path) returns the node at a slash-separated path of the
+// tree as a dict, or None if there is none.
+func treeNode(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var p string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "path", &p); err != nil {
+		return nil, err
+	}
+	node := b.Receiver().(*Tree).root
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		if node = node.Children[name]; node == nil {
+			return starlark.None, nil
+		}
+	}
+	leaf := *node
+	leaf.Children = nil
+	return fromGo(thread, leaf)
+}
+
+// tree.export() returns the tree as the JSON the backend exports.
+func treeExport(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	root := b.Receiver().(*Tree).root
+	data, err := json.MarshalIndent(map[string]*manifest.Node{root.Name: root}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(data), nil
+}
+
+// change is a difference between two trees as scripts see it.
+type change struct {
+	Type    monitor.Type `json:"type"`
+	Path    string       `json:"path"`
+	Kind    string       `json:"kind"`
+	OldHash string       `json:"old_hash,omitempty"`
+	NewHash string       `json:"new_hash,omitempty"`
+	Message string       `json:"message"`
+}
+
+// diff(old, new) lists what turns tree old into tree new, as dicts with
+// the type of change (added, removed or modified), path, kind, hashes and
+// a message.
+func diff(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var old, cur *Tree
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "old", &old, "new", &cur); err != nil {
+		return nil, err
+	}
+	changes := []change{}
+	for _, e := range monitor.Diff(old.root, cur.root) {
+		changes = append(changes, change{e.Type, e.Path, e.Kind, e.OldHash, e.NewHash, e.Message})
+	}
+	return fromGo(thread, changes)
+}
+
+// trees() lists the trees of the registry as dicts.
+func trees(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs); err != nil {
+		return nil, err
+	}
+	known, err := registry.Load()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	if known == nil {
+		known = []registry.Tree{}
+	}
+	return fromGo(thread, known)
+}
+
+// openStore opens the store at location, or a registered tree's, with
+// the key in keyFile or, without one, that of the environment.
+func openStore(location, keyFile string, existing bool) (*store.Store, error) {
+	if keyFile == "" {
+		keyFile = os.Getenv(store.KeyFileEnv)
+	}
+	key := store.Key{Passphrase: os.Getenv(store.PassphraseEnv)}
+	if keyFile != "" {
+		var err error
+		if key, err = store.KeyFromFile(keyFile); err != nil {
+			return nil, err
+		}
+	}
+	location = registry.ResolveStore(location)
+	if existing {
+		return store.OpenExisting(location, key)
+	}
+	return store.Open(location, key)
+}
+
+// snapshot(tree, store, key_file=None, chunk_size=1048576, chunking=None)
+// stores the chunks of a built tree and a snapshot of it, and returns what
+// was stored. chunking, such as "fastcdc:16K/64K/256K", cuts
+// content-defined chunks instead of chunks of chunk_size.
+func snapshot(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var t *Tree
+	var location, keyFile string
+	chunkSize := DefaultChunkSize
+	var spec string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "tree", &t, "store", &location, "key_file?", &keyFile, "chunk_size?", &chunkSize, "chunking?", &spec); err != nil {
+		return nil, err
+	}
+	params := chunking.Fixed(chunkSize)
+	if spec != "" {
+		var err error
+		if params, err = chunking.Parse(spec); err != nil {
+			return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		}
+	}
+	if t.path == "" {
+		return nil, fmt.Errorf("%s: %s was loaded from an export; build it to store it", b.Name(), t)
+	}
+	s, err := openStore(location, keyFile, false)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	stats, err := s.Ingest(t.path, t.root, params, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	if err := registry.Record(t.path, t.root.Hash, registry.ResolveStore(location)); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return fromGo(thread, struct {
+		Root string `json:"root"`
+		store.IngestStats
+	}{t.root.Hash, stats})
+}
+
+// snapshots(store, key_file=None) lists the snapshots of a store as
+// dicts with their root hash, when they were stored and what they hold.
+func snapshots(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var location, keyFile string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "store", &location, "key_file?", &keyFile); err != nil {
+		return nil, err
+	}
+	s, err := openStore(location, keyFile, true)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	roots, err := s.Snapshots()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	type summary struct {
+		Root      string `json:"root"`
+		Created   string `json:"created"`
+		ChunkSize int    `json:"chunk_size"`
+		Chunking  string `json:"chunking,omitempty"`
+		Files     int    `json:"files"`
+	}
+	list := []summary{}
+	for _, root := range roots {
+		snap, err := s.LoadSnapshot(root)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", b.Name(), err)
+		}
+		list = append(list, summary{snap.Root, snap.Created, snap.ChunkSize, snap.Chunking, len(snap.Files)})
+	}
+	return fromGo(thread, list)
+}
+
+// super_root(trees) aggregates the root hashes of trees, a dict from
+// names to trees or root hashes, into a super-root document.
+func superRoot(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var dict *starlark.Dict
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "trees", &dict); err != nil {
+		return nil, err
+	}
+	var list []superroot.Tree
+	for _, item := range dict.Items() {
+		name, ok := starlark.AsString(item[0])
+		if !ok {
+			return nil, fmt.Errorf("%s: tree name %s is not a string", b.Name(), item[0])
+		}
+		switch v := item[1].(type) {
+		case *Tree:
+			list = append(list, superroot.Tree{Name: name, RootHash: v.root.Hash})
+		case starlark.String:
+			list = append(list, superroot.Tree{Name: name, RootHash: string(v)})
+		default:
+			return nil, fmt.Errorf("%s: tree %q is a %s, not a tree or root hash", b.Name(), name, v.Type())
+		}
+	}
+	doc, err := superroot.Compute(list)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return fromGo(thread, doc)
+}
+
+// prove(document, name) returns the proof that the tree called name is
+// covered by a super-root document.
+func prove(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	var name string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "document", &v, "name", &name); err != nil {
+		return nil, err
+	}
+	var doc superroot.Document
+	if err := toGo(thread, v, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	proof, err := doc.Prove(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return fromGo(thread, proof)
+}
+
+// verify_proof(proof) reports whether a proof leads from its tree to its
+// super-root.
+func verifyProof(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var v starlark.Value
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "proof", &v); err != nil {
+		return nil, err
+	}
+	var proof superroot.Proof
+	if err := toGo(thread, v, &proof); err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return starlark.Bool(proof.Verify() == nil), nil
+}
+
+// fromGo turns v into Starlark values through its JSON encoding.
+func fromGo(thread *starlark.Thread, v any) (starlark.Value, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Call(thread, starlarkjson.Module.Members["decode"], starlark.Tuple{starlark.String(data)}, nil)
+}
+
+// toGo decodes the Starlark value v into ptr through its JSON encoding.
+func toGo(thread *starlark.Thread, v starlark.Value, ptr any) error {
+	data, err := starlark.Call(thread, starlarkjson.Module.Members["encode"], starlark.Tuple{v}, nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data.(starlark.String)), ptr)
+}
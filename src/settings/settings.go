@@ -0,0 +1,294 @@
+// Package settings keeps the user's settings: the engine hashing trees,
+// the default hash format, the default store location, the theme of the
+// TUI, the commands run as hooks around operations and the I/O limits and
+// priority of the backend. They are written by the setup wizard on first launch to
+// ~/.config/mtfs/config, in the "key = value" form of a tree's
+// .mtfs/config, and serve as defaults for the MTFS_* environment
+// variables, which override them.
+package settings
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"MTFS/quota"
+	"MTFS/remote"
+	"MTFS/store"
+)
+
+// FileEnv names the environment variable overriding the location of the
+// settings file.
+const FileEnv = "MTFS_CONFIG"
+
+// HashFormatEnv names the environment variable the backend reads the
+// hash format from.
+const HashFormatEnv = "MTFS_HASH_FORMAT"
+
+// WorkersEnv names the environment variable the backend reads the number
+// of workers hashing files from.
+const WorkersEnv = "MTFS_WORKERS"
+
+// The environment variables the backend reads its I/O limits and
+// priority from.
+const (
+	IOLimitEnv   = "MTFS_IO_LIMIT"   // bytes read per second
+	IOPSLimitEnv = "MTFS_IOPS_LIMIT" // reads per second
+	NiceEnv      = "MTFS_NICE"       // CPU niceness, 0 to 19
+	IONiceEnv    = "MTFS_IONICE"     // I/O class on Linux: idle or best-effort[:0-7]
+)
+
+// Engine settings: BundledEngine selects the C++ backend built with the
+// TUI and GoEngine the engine built into it. Any other engine is the path
+// of a backend executable.
+const (
+	BundledEngine = "cpp"
+	GoEngine      = remote.GoEngine
+)
+
+// HashFormats are the accepted hash formats, the first being the default.
+var HashFormats = []string{"mtfs", "mtfs-sha512", "mtfs-blake2b", "mtfs-blake3", "git", "git-sha256"}
+
+// Themes are the accepted themes, the first being the default.
+var Themes = []string{"default", "high-contrast", "plain"}
+
+// HookNames are the hooks a command can be set for, each with the key
+// of its name in underscores followed by "_hook", as in
+// "pre_build_hook = ./backup.sh".
+var HookNames = []string{"pre-build", "post-build", "post-verify", "on-change"}
+
+// Settings are the user's settings. Empty fields take their defaults.
+type Settings struct {
+	Engine     string            // BundledEngine, GoEngine or the path of a backend executable
+	HashFormat string            // hash format of trees built without one
+	Store      string            // default store directory or bucket URL
+	Theme      string            // colors of the TUI
+	Hooks      map[string]string // shell command of each hook set, by name
+	Workers    string            // files the backend hashes at once
+	IOLimit    string            // bytes the backend reads per second, as a size
+	IOPSLimit  string            // reads the backend does per second
+	Nice       string            // CPU niceness of the backend
+	IONice     string            // I/O class of the backend
+}
+
+// hookKey returns the key of hook in the settings file.
+func hookKey(hook string) string {
+	return strings.ReplaceAll(hook, "-", "_") + "_hook"
+}
+
+// Backend returns the backend executable chosen, or "" for the bundled
+// one and the Go engine.
+func (s Settings) Backend() string {
+	if s.Engine == BundledEngine || s.Engine == GoEngine {
+		return ""
+	}
+	return s.Engine
+}
+
+// goEngine returns the value of remote.EngineEnv selecting the Go
+// engine if s chooses it, "" otherwise.
+func (s Settings) goEngine() string {
+	if s.Engine == GoEngine {
+		return remote.GoEngine
+	}
+	return ""
+}
+
+// DefaultPath returns the settings file used unless FileEnv says
+// otherwise.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "mtfs", "config"), nil
+}
+
+// Path returns the settings file in use.
+func Path() (string, error) {
+	if name := os.Getenv(FileEnv); name != "" {
+		return name, nil
+	}
+	return DefaultPath()
+}
+
+// Load reads the settings file. found is false if there is none yet.
+func Load() (s Settings, found bool, err error) {
+	name, err := Path()
+	if err != nil {
+		return s, false, err
+	}
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, false, nil
+	} else if err != nil {
+		return s, false, err
+	}
+	defer f.Close()
+
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" {
+			return s, true, fmt.Errorf("%s:%d: expected key = value", name, line)
+		}
+		if seen[key] {
+			return s, true, fmt.Errorf("%s:%d: %s is set twice", name, line, key)
+		}
+		seen[key] = true
+		switch key {
+		case "engine":
+			s.Engine = value
+		case "hash_format":
+			if !valid(value, HashFormats) {
+				return s, true, fmt.Errorf("%s:%d: hash_format must be one of %s", name, line, strings.Join(HashFormats, ", "))
+			}
+			s.HashFormat = value
+		case "store":
+			s.Store = value
+		case "theme":
+			if !valid(value, Themes) {
+				return s, true, fmt.Errorf("%s:%d: theme must be one of %s", name, line, strings.Join(Themes, ", "))
+			}
+			s.Theme = value
+		case "workers":
+			if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+				return s, true, fmt.Errorf("%s:%d: workers must be a positive number", name, line)
+			}
+			s.Workers = value
+		case "io_limit":
+			if _, err := quota.ParseSize(value); err != nil {
+				return s, true, fmt.Errorf("%s:%d: io_limit: %v", name, line, err)
+			}
+			s.IOLimit = value
+		case "iops_limit":
+			if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+				return s, true, fmt.Errorf("%s:%d: iops_limit must be a number of reads per second", name, line)
+			}
+			s.IOPSLimit = value
+		case "nice":
+			if n, err := strconv.Atoi(value); err != nil || n < 0 || n > 19 {
+				return s, true, fmt.Errorf("%s:%d: nice must be from 0 to 19", name, line)
+			}
+			s.Nice = value
+		case "ionice":
+			if !validIONice(value) {
+				return s, true, fmt.Errorf("%s:%d: ionice must be idle, best-effort or best-effort:0 to best-effort:7", name, line)
+			}
+			s.IONice = value
+		default:
+			hook := ""
+			for _, h := range HookNames {
+				if key == hookKey(h) {
+					hook = h
+				}
+			}
+			if hook == "" {
+				return s, true, fmt.Errorf("%s:%d: unknown key %q", name, line, key)
+			}
+			if s.Hooks == nil {
+				s.Hooks = map[string]string{}
+			}
+			s.Hooks[hook] = value
+		}
+	}
+	return s, true, scanner.Err()
+}
+
+// validIONice reports whether value is an I/O class the backend accepts.
+func validIONice(value string) bool {
+	if value == "idle" || value == "best-effort" {
+		return true
+	}
+	level, ok := strings.CutPrefix(value, "best-effort:")
+	return ok && len(level) == 1 && level[0] >= '0' && level[0] <= '7'
+}
+
+func valid(value string, allowed []string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Save writes s to the settings file and returns its path.
+func Save(s Settings) (string, error) {
+	name, err := Path()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString("# MTFS settings, written by the setup wizard. MTFS_* environment\n")
+	b.WriteString("# variables override them.\n")
+	pairs := [][2]string{{"engine", s.Engine}, {"hash_format", s.HashFormat}, {"store", s.Store}, {"theme", s.Theme}}
+	for _, hook := range HookNames {
+		pairs = append(pairs, [2]string{hookKey(hook), s.Hooks[hook]})
+	}
+	pairs = append(pairs, [][2]string{{"workers", s.Workers}, {"io_limit", s.IOLimit}, {"iops_limit", s.IOPSLimit}, {"nice", s.Nice}, {"ionice", s.IONice}}...)
+	for _, kv := range pairs {
+		if kv[1] != "" {
+			fmt.Fprintf(&b, "%s = %s\n", kv[0], kv[1])
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".config-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return name, os.Rename(tmp.Name(), name)
+}
+
+// applied holds the variables Apply set, which later calls may change.
+var applied = map[string]bool{}
+
+// Apply makes s the default of the environment variables the backend and
+// the headless commands read: the engine, backend, hash format, store, workers,
+// I/O limit and priority variables are set from s unless they are set already. Variables set by
+// an earlier Apply follow s, so settings changed mid-session take effect.
+func Apply(s Settings) {
+	for env, value := range map[string]string{
+		remote.BackendEnv: s.Backend(),
+		remote.EngineEnv:  s.goEngine(),
+		HashFormatEnv:     s.HashFormat,
+		store.DirEnv:      s.Store,
+		WorkersEnv:        s.Workers,
+		IOLimitEnv:        s.IOLimit,
+		IOPSLimitEnv:      s.IOPSLimit,
+		NiceEnv:           s.Nice,
+		IONiceEnv:         s.IONice,
+	} {
+		if value == "" || (env == HashFormatEnv && value == HashFormats[0]) {
+			if applied[env] {
+				os.Unsetenv(env)
+				delete(applied, env)
+			}
+			continue
+		}
+		if _, set := os.LookupEnv(env); !set || applied[env] {
+			os.Setenv(env, value)
+			applied[env] = true
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"MTFS/attest"
+	"MTFS/bundle"
+	"MTFS/manifest"
+	"MTFS/remote"
+	"MTFS/settings"
+	"MTFS/signing"
+	"MTFS/state"
+	"MTFS/timestamp"
+
+	"golang.org/x/term"
+)
+
+func verifySignature(args []string) int {
+	flags := flag.NewFlagSet("verify-signature", flag.ExitOnError)
+	keyring := flags.String("keyring", os.Getenv(signing.TrustStoreEnv), "trust store keyring (default $"+signing.TrustStoreEnv+")")
+	flags.Parse(args)
+
+	tree := treeArg(flags, 0)
+
+	gpg := &signing.GPG{Keyring: *keyring}
+	status, err := gpg.CheckRoot(tree)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if status.SignedHash == "" {
+		fmt.Printf("Tree %s has no signed root hash\n", tree)
+		return 1
+	}
+
+	operation.Tree, operation.RootHash = tree, status.SignedHash
+	v := status.Verification
+	fmt.Printf("Root hash: %s\n", status.SignedHash)
+	if v == nil {
+		fmt.Println("Signature: missing")
+		return 1
+	}
+	fmt.Printf("Signature: %s\n", describe(v))
+
+	ok := v.Status == signing.StatusGood
+
+	// The signed export must describe the same root
+	export, err := state.ReadFile(tree, signing.ExportFile)
+	if err == nil {
+		// An export left unsigned is reported but is no failure: only the
+		// root hash is signed then
+		exportOK := true
+		if sig, err := state.ReadFile(tree, signing.ExportSigFile); err == nil {
+			ev, err := gpg.Verify(export, sig)
+			if err != nil {
+				fmt.Printf("Export:    %v\n", err)
+				exportOK = false
+			} else {
+				fmt.Printf("Export:    %s\n", describe(ev))
+				exportOK = ev.Status == signing.StatusGood
+			}
+		} else {
+			fmt.Println("Export:    unsigned")
+		}
+		if root, err := manifest.Parse(export); err == nil && root.Hash != status.SignedHash {
+			fmt.Printf("Export:    root hash %s does not match the signed root\n", root.Hash)
+			exportOK = false
+		}
+		ok = ok && exportOK
+	}
+
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+func verifyAttestation(args []string) int {
+	flags := flag.NewFlagSet("verify-attestation", flag.ExitOnError)
+	keyring := flags.String("keyring", os.Getenv(signing.TrustStoreEnv), "trust store keyring (default $"+signing.TrustStoreEnv+")")
+	noBuild := flags.Bool("no-build", false, "only check the signature, without building the tree")
+	flags.Parse(args)
+
+	tree := treeArg(flags, 0)
+	operation.Tree = tree
+
+	b, err := attest.Read(attest.Path(tree))
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("Tree %s has no attestation badge\n", tree)
+		return 1
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.RootHash = b.RootHash
+	fmt.Printf("Root hash: %s (%s, %s)\n", b.RootHash, b.Algorithm, b.HashFormat)
+	fmt.Printf("Attested:  after a %s at %s by %s\n", b.Operation, b.Timestamp, b.Tool)
+
+	ok := true
+	v, err := b.Verify(&signing.GPG{Keyring: *keyring})
+	if err != nil {
+		fmt.Printf("Signature: %v\n", err)
+		ok = false
+	} else {
+		fmt.Printf("Signature: %s\n", describe(v))
+		ok = v.Status == signing.StatusGood
+	}
+
+	if !*noBuild {
+		// A copy without the state directory is built in the attested
+		// hash format
+		os.Setenv(settings.HashFormatEnv, b.HashFormat)
+		remote.RehashAll()
+		root, err := remote.Build(tree)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if root.Hash == b.RootHash {
+			fmt.Println("Tree:      matches the attested root hash")
+		} else {
+			fmt.Printf("Tree:      root hash %s does not match the attested one\n", root.Hash)
+			ok = false
+		}
+	}
+
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+func describe(v *signing.Verification) string {
+	switch v.Status {
+	case signing.StatusGood:
+		return fmt.Sprintf("good (signed by %s, key %s)", v.Signer, v.KeyID)
+	case signing.StatusUnknownKey:
+		return fmt.Sprintf("key %s is not in the trust store", v.KeyID)
+	case signing.StatusUntrustedKey:
+		return fmt.Sprintf("signed by %s, key %s, which is not trusted (pin %s in $%s to trust it)", v.Signer, v.KeyID, v.Fingerprint, signing.TrustedKeysEnv)
+	default:
+		return fmt.Sprintf("%s (key %s %s)", v.Status, v.KeyID, v.Signer)
+	}
+}
+
+func verifyTimestamp(args []string) int {
+	flags := flag.NewFlagSet("verify-timestamp", flag.ExitOnError)
+	ca := flags.String("ca", os.Getenv(timestamp.CAEnv), "PEM file of the TSA roots (default $"+timestamp.CAEnv+")")
+	flags.Parse(args)
+
+	tree := treeArg(flags, 0)
+	operation.Tree = tree
+
+	roots, err := timestamp.Roots(*ca)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	stored, err := timestamp.Load(tree)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(stored) == 0 {
+		fmt.Printf("Tree %s has no timestamped root hashes\n", tree)
+		return 1
+	}
+
+	ok := true
+	for _, s := range stored {
+		if s.Err == nil {
+			s.Err = s.Token.VerifyChain(roots)
+		}
+		if s.Err != nil {
+			fmt.Printf("%s  invalid: %v\n", s.Hash, s.Err)
+			ok = false
+			continue
+		}
+		fmt.Printf("%s  %s  %s\n", s.Hash, s.Token.Time.Format(time.RFC3339), s.Token.Signer.Subject)
+	}
+
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+// minisignPassphrase returns the passphrase of a minisign secret key from
+// the environment, or else as read from standard input after prompt.
+func minisignPassphrase(prompt string) string {
+	return readPassphrase(signing.MinisignPassphraseEnv, prompt)
+}
+
+// readPassphrase returns the passphrase in the environment variable env,
+// or else reads a line of standard input after prompt, without echoing it
+// when standard input is a terminal.
+func readPassphrase(env, prompt string) string {
+	if passphrase := os.Getenv(env); passphrase != "" {
+		return passphrase
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		passphrase, _ := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(passphrase)
+	}
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
+
+func generateKey(args []string) int {
+	flags := flag.NewFlagSet("keygen", flag.ExitOnError)
+	prefix := flags.String("o", "mtfs", "write the keys to `PREFIX`.pub and PREFIX.key")
+	plain := flags.Bool("no-passphrase", false, "leave the secret key unencrypted")
+	force := flags.Bool("force", false, "replace existing key files")
+	flags.Parse(args)
+
+	pubFile, keyFile := *prefix+".pub", *prefix+".key"
+	if !*force {
+		for _, name := range []string{pubFile, keyFile} {
+			if _, err := os.Stat(name); err == nil {
+				fmt.Fprintf(os.Stderr, "Error: %s exists already; give -force to replace it\n", name)
+				return 1
+			}
+		}
+	}
+	passphrase := ""
+	if !*plain {
+		if passphrase = minisignPassphrase("Passphrase for the secret key: "); passphrase == "" {
+			fmt.Fprintln(os.Stderr, "Error: no passphrase given; give -no-passphrase for an unencrypted key")
+			return 2
+		}
+	}
+	sk, err := signing.GenerateKey()
+	var data []byte
+	if err == nil {
+		data, err = sk.Marshal(passphrase)
+	}
+	if err == nil {
+		err = os.WriteFile(keyFile, data, 0o600)
+	}
+	if err == nil {
+		err = os.WriteFile(pubFile, sk.Public().Marshal(), 0o644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Params["key_id"] = sk.ID.String()
+	fmt.Printf("Created key %s: secret key %s, public key %s\n", sk.ID, keyFile, pubFile)
+	return 0
+}
+
+// signManifest signs an export with a minisign key. The trusted comment
+// holds the root hash, so the signature vouches for it as well as for the
+// file.
+func signManifest(args []string) int {
+	flags := flag.NewFlagSet("sign-manifest", flag.ExitOnError)
+	keyFile := flags.String("key", os.Getenv(signing.MinisignKeyEnv), "secret key file (default $"+signing.MinisignKeyEnv+")")
+	out := flags.String("o", "", "signature file to write (default EXPORT"+signing.MinisigExt+")")
+	comment := flags.String("comment", "", "text to add to the trusted comment")
+	flags.Parse(args)
+	if flags.NArg() != 1 || *keyFile == "" {
+		fmt.Fprintln(os.Stderr, "Error: give the secret key with -key and the export to sign")
+		return 2
+	}
+
+	export, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	root, err := manifest.Parse(export)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	operation.RootHash = root.Hash
+	data, err := os.ReadFile(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	passphrase := ""
+	if signing.SecretKeyEncrypted(data) {
+		passphrase = minisignPassphrase("Passphrase for " + *keyFile + ": ")
+	}
+	sk, err := signing.ParseSecretKey(data, passphrase)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	trusted := fmt.Sprintf("timestamp:%d\tfile:%s\troot:%s", time.Now().Unix(), filepath.Base(flags.Arg(0)), root.Hash)
+	if root.HashFormat != "" {
+		trusted += "\thash_format:" + root.HashFormat
+	}
+	if *comment != "" {
+		trusted += "\t" + strings.ReplaceAll(*comment, "\n", " ")
+	}
+	dest := *out
+	if dest == "" {
+		dest = flags.Arg(0) + signing.MinisigExt
+	}
+	if err := os.WriteFile(dest, sk.Sign(export, trusted), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Params["key_id"] = sk.ID.String()
+	fmt.Printf("Signed root hash %s with key %s: %s\n", root.Hash, sk.ID, dest)
+	return 0
+}
+
+// verifyManifest checks a minisign signature of an export, and that the
+// root hash it signs is the export's.
+func verifyManifest(args []string) int {
+	flags := flag.NewFlagSet("verify-manifest", flag.ExitOnError)
+	pubFile := flags.String("pub", os.Getenv(signing.MinisignPubEnv), "public key file, or the key itself (default $"+signing.MinisignPubEnv+")")
+	sigFile := flags.String("sig", "", "signature file (default EXPORT"+signing.MinisigExt+")")
+	expected := flags.String("root", "", "root hash the export must have")
+	flags.Parse(args)
+	if flags.NArg() != 1 || *pubFile == "" {
+		fmt.Fprintln(os.Stderr, "Error: give the public key with -pub and the export to check")
+		return 2
+	}
+
+	pubData := []byte(*pubFile)
+	if _, err := os.Stat(*pubFile); err == nil {
+		if pubData, err = os.ReadFile(*pubFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	pk, err := signing.ParsePublicKey(pubData)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if *sigFile == "" {
+		*sigFile = flags.Arg(0) + signing.MinisigExt
+	}
+	export, err := os.ReadFile(flags.Arg(0))
+	var sig []byte
+	if err == nil {
+		sig, err = os.ReadFile(*sigFile)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Params["key_id"] = pk.ID.String()
+
+	trusted, err := pk.Verify(export, sig)
+	if err != nil {
+		fmt.Printf("Signature: BAD (%v)\n", err)
+		return 1
+	}
+	fmt.Printf("Signature: good, key %s\n", pk.ID)
+	fmt.Printf("Trusted comment: %s\n", trusted)
+
+	root, err := manifest.Parse(export)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", flags.Arg(0), err)
+		return 1
+	}
+	operation.RootHash = root.Hash
+	fmt.Printf("Root hash: %s\n", root.Hash)
+	ok := true
+	signed := ""
+	for _, field := range strings.Split(trusted, "\t") {
+		if hash, found := strings.CutPrefix(field, "root:"); found {
+			signed = hash
+		}
+	}
+	switch {
+	case signed == "":
+		// Signed by minisign itself: the signature covers the file only
+		fmt.Println("Signed root: none in the trusted comment")
+	case signed != root.Hash:
+		fmt.Printf("Signed root: %s does not match the export\n", signed)
+		ok = false
+	}
+	if *expected != "" && *expected != root.Hash {
+		fmt.Printf("Expected root: %s does not match the export\n", *expected)
+		ok = false
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+func openBundle(args []string) int {
+	flags := flag.NewFlagSet("open-bundle", flag.ExitOnError)
+	outDir := flags.String("o", "", "write the bundled files into `DIR` instead of listing them")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: mtfs_tui open-bundle [-o DIR] FILE")
+		return 2
+	}
+
+	data, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	b, err := bundle.Open(data, readPassphrase(bundle.PassphraseEnv, "Bundle password: "))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	for _, name := range b.Names() {
+		if *outDir == "" {
+			fmt.Printf("%8d  %s\n", len(b.Files[name]), name)
+			continue
+		}
+		if !filepath.IsLocal(name) {
+			fmt.Fprintf(os.Stderr, "Error: refusing to write %q outside %s\n", name, *outDir)
+			return 1
+		}
+		p := filepath.Join(*outDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if err := os.WriteFile(p, b.Files[name], 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Println(p)
+	}
+	return 0
+}
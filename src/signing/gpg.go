@@ -0,0 +1,59 @@
+// Package signing signs exports and root hashes with existing OpenPGP
+// keys by driving the gpg binary, so keys held by gpg-agent (smartcards,
+// cached passphrases) work without MTFS ever touching key material.
+package signing
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// GPG creates detached, ASCII-armored signatures with gpg.
+type GPG struct {
+	// Binary is the gpg executable; empty means "gpg" from PATH.
+	Binary string
+	// KeyID selects the signing key (--local-user); empty uses gpg's
+	// default key.
+	KeyID string
+	// Keyring is the trust store used for verification; empty uses gpg's
+	// default keyring and trust database.
+	Keyring string
+	// Fingerprints pins keys whose good signatures are trusted although
+	// the trust database does not trust them fully; nil for those of
+	// TrustedKeysEnv.
+	Fingerprints []string
+}
+
+// NewGPG returns a GPG signing with keyID and verifying against the trust
+// store configured through TrustStoreEnv.
+func NewGPG(keyID string) *GPG {
+	return &GPG{KeyID: keyID, Keyring: os.Getenv(TrustStoreEnv)}
+}
+
+func (g *GPG) binary() string {
+	if g.Binary == "" {
+		return "gpg"
+	}
+	return g.Binary
+}
+
+// Sign returns a detached armored signature over data.
+func (g *GPG) Sign(data []byte) ([]byte, error) {
+	args := []string{"--batch", "--yes", "--armor", "--detach-sign"}
+	if g.KeyID != "" {
+		args = append(args, "--local-user", g.KeyID)
+	}
+
+	cmd := exec.Command(g.binary(), args...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("gpg sign: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
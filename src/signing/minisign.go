@@ -0,0 +1,283 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Minisign keys and signatures sign exports with ed25519 without gpg.
+// Their files are those of minisign, so either tool can check what the
+// other signed.
+const (
+	// MinisignKeyEnv names the environment variable pointing at the
+	// secret key exports are signed with.
+	MinisignKeyEnv = "MTFS_MINISIGN_KEY"
+	// MinisignPubEnv names the environment variable pointing at the
+	// public key signed exports are checked against.
+	MinisignPubEnv = "MTFS_MINISIGN_PUB"
+	// MinisignPassphraseEnv names the environment variable holding the
+	// passphrase of the secret key, which is asked for otherwise.
+	MinisignPassphraseEnv = "MTFS_MINISIGN_PASSPHRASE"
+	// MinisigExt is added to the name of a signed file for its signature.
+	MinisigExt = ".minisig"
+
+	commentPrefix        = "untrusted comment: "
+	trustedCommentPrefix = "trusted comment: "
+	// minisign's scrypt limits, which put N at 2^20
+	scryptOpsLimit = 33554432
+	scryptMemLimit = 1073741824
+)
+
+// KeyID tells the keys of a signature apart.
+type KeyID [8]byte
+
+// String returns id as minisign shows it.
+func (id KeyID) String() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id[:]))
+}
+
+// PublicKey is the key signatures are checked with.
+type PublicKey struct {
+	ID  KeyID
+	Key ed25519.PublicKey
+}
+
+// SecretKey is the key exports are signed with.
+type SecretKey struct {
+	ID  KeyID
+	Key ed25519.PrivateKey
+}
+
+// GenerateKey returns a new key pair with a random key id.
+func GenerateKey() (*SecretKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sk := &SecretKey{Key: key}
+	if _, err := rand.Read(sk.ID[:]); err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
+
+// Public returns the public key of sk.
+func (sk *SecretKey) Public() *PublicKey {
+	return &PublicKey{ID: sk.ID, Key: sk.Key.Public().(ed25519.PublicKey)}
+}
+
+// Marshal returns pk as the content of a minisign public key file.
+func (pk *PublicKey) Marshal() []byte {
+	data := append(append([]byte("Ed"), pk.ID[:]...), pk.Key...)
+	return []byte(fmt.Sprintf("%sminisign public key %s\n%s\n", commentPrefix, pk.ID, base64.StdEncoding.EncodeToString(data)))
+}
+
+// ParsePublicKey reads a minisign public key file, or just its key line
+// as minisign -P takes it.
+func ParsePublicKey(data []byte) (*PublicKey, error) {
+	lines := keyLines(data)
+	if len(lines) == 0 {
+		return nil, errors.New("minisign: no public key found")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[len(lines)-1])
+	if err != nil || len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != "Ed" {
+		return nil, errors.New("minisign: not an ed25519 public key")
+	}
+	pk := &PublicKey{Key: ed25519.PublicKey(raw[10:])}
+	copy(pk.ID[:], raw[2:10])
+	return pk, nil
+}
+
+// Marshal returns sk as the content of a minisign secret key file,
+// encrypted with a key scrypt derives from passphrase, or unencrypted if
+// passphrase is empty.
+func (sk *SecretKey) Marshal(passphrase string) ([]byte, error) {
+	return sk.marshal(passphrase, scryptOpsLimit, scryptMemLimit)
+}
+
+// marshal is Marshal with the scrypt limits of the key derivation.
+func (sk *SecretKey) marshal(passphrase string, ops, mem uint64) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("Ed")
+	if passphrase == "" {
+		b.Write([]byte{0, 0})
+		b.WriteString("B2")
+		b.Write(make([]byte, 32+8+8))
+	} else {
+		salt := make([]byte, 32)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		b.WriteString("ScB2")
+		b.Write(salt)
+		binary.Write(&b, binary.LittleEndian, ops)
+		binary.Write(&b, binary.LittleEndian, mem)
+	}
+	keynum := append(append([]byte(nil), sk.ID[:]...), sk.Key...)
+	sum := blake2b.Sum256(append([]byte("Ed"), keynum...))
+	keynum = append(keynum, sum[:]...)
+	if passphrase != "" {
+		head := b.Bytes()
+		stream, err := keyStream(passphrase, head[6:38], ops, mem)
+		if err != nil {
+			return nil, err
+		}
+		subtle.XORBytes(keynum, keynum, stream)
+	}
+	b.Write(keynum)
+	comment := "minisign secret key"
+	if passphrase != "" {
+		comment = "minisign encrypted secret key"
+	}
+	return []byte(fmt.Sprintf("%s%s\n%s\n", commentPrefix, comment, base64.StdEncoding.EncodeToString(b.Bytes()))), nil
+}
+
+// SecretKeyEncrypted reports whether the secret key file data needs a
+// passphrase.
+func SecretKeyEncrypted(data []byte) bool {
+	lines := keyLines(data)
+	if len(lines) == 0 {
+		return false
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[len(lines)-1])
+	return err == nil && len(raw) > 4 && string(raw[2:4]) == "Sc"
+}
+
+// ParseSecretKey reads a minisign secret key file, decrypting it with
+// passphrase if it is encrypted.
+func ParseSecretKey(data []byte, passphrase string) (*SecretKey, error) {
+	lines := keyLines(data)
+	if len(lines) == 0 {
+		return nil, errors.New("minisign: no secret key found")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[len(lines)-1])
+	if err != nil || len(raw) != 6+32+8+8+8+64+32 || string(raw[:2]) != "Ed" {
+		return nil, errors.New("minisign: not an ed25519 secret key")
+	}
+	if string(raw[4:6]) != "B2" {
+		return nil, fmt.Errorf("minisign: unknown checksum %q", raw[4:6])
+	}
+	keynum := raw[54:]
+	switch string(raw[2:4]) {
+	case "Sc":
+		if passphrase == "" {
+			return nil, errors.New("minisign: the secret key is encrypted and no passphrase was given")
+		}
+		ops, mem := binary.LittleEndian.Uint64(raw[38:46]), binary.LittleEndian.Uint64(raw[46:54])
+		stream, err := keyStream(passphrase, raw[6:38], ops, mem)
+		if err != nil {
+			return nil, err
+		}
+		subtle.XORBytes(keynum, keynum, stream)
+	case "\x00\x00":
+	default:
+		return nil, fmt.Errorf("minisign: unknown key derivation %q", raw[2:4])
+	}
+	sum := blake2b.Sum256(append([]byte("Ed"), keynum[:72]...))
+	if subtle.ConstantTimeCompare(sum[:], keynum[72:]) != 1 {
+		if string(raw[2:4]) == "Sc" {
+			return nil, errors.New("minisign: wrong passphrase for the secret key")
+		}
+		return nil, errors.New("minisign: the secret key is corrupt")
+	}
+	sk := &SecretKey{Key: ed25519.PrivateKey(append([]byte(nil), keynum[8:72]...))}
+	copy(sk.ID[:], keynum[:8])
+	return sk, nil
+}
+
+// keyStream derives the bytes a secret key is encrypted with as
+// libsodium's scrypt does from minisign's limits.
+func keyStream(passphrase string, salt []byte, ops, mem uint64) ([]byte, error) {
+	ops = max(ops, 32768)
+	const r = 8
+	var logN, p uint64 = 1, 1
+	maxN := ops / (r * 4)
+	if ops >= mem/32 {
+		maxN = mem / (r * 128)
+	}
+	for ; logN < 63; logN++ {
+		if 1<<logN > maxN/2 {
+			break
+		}
+	}
+	if ops >= mem/32 {
+		maxrp := min((ops/4)/(1<<logN), 0x3fffffff)
+		p = maxrp / r
+	}
+	if logN > 24 || p == 0 || p > 16 {
+		// Well beyond what minisign writes; refuse rather than run out
+		// of memory
+		return nil, errors.New("minisign: unsupported key derivation limits")
+	}
+	return scrypt.Key([]byte(passphrase), salt, 1<<logN, r, int(p), 8+64+32)
+}
+
+// Sign returns a minisign signature of message, the file content, with
+// trustedComment signed along with it.
+func (sk *SecretKey) Sign(message []byte, trustedComment string) []byte {
+	hash := blake2b.Sum512(message)
+	sig := ed25519.Sign(sk.Key, hash[:])
+	global := ed25519.Sign(sk.Key, append(append([]byte(nil), sig...), trustedComment...))
+	line := append(append([]byte("ED"), sk.ID[:]...), sig...)
+	return []byte(fmt.Sprintf("%ssignature from minisign secret key %s\n%s\n%s%s\n%s\n", commentPrefix, sk.ID,
+		base64.StdEncoding.EncodeToString(line), trustedCommentPrefix, trustedComment, base64.StdEncoding.EncodeToString(global)))
+}
+
+// Verify checks the minisign signature sig of message against pk and
+// returns its trusted comment. Signatures of the message itself, as
+// older minisign versions made, are accepted too.
+func (pk *PublicKey) Verify(message, sig []byte) (string, error) {
+	lines := strings.Split(strings.TrimRight(string(sig), "\r\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], commentPrefix) || !strings.HasPrefix(lines[2], trustedCommentPrefix) {
+		return "", errors.New("minisign: not a signature file")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 2+8+ed25519.SignatureSize {
+		return "", errors.New("minisign: invalid signature")
+	}
+	var id KeyID
+	copy(id[:], raw[2:10])
+	if id != pk.ID {
+		return "", fmt.Errorf("minisign: signed with key %s, not %s", id, pk.ID)
+	}
+	signed := message
+	switch string(raw[:2]) {
+	case "ED":
+		hash := blake2b.Sum512(message)
+		signed = hash[:]
+	case "Ed":
+	default:
+		return "", fmt.Errorf("minisign: unknown signature algorithm %q", raw[:2])
+	}
+	if !ed25519.Verify(pk.Key, signed, raw[10:]) {
+		return "", errors.New("minisign: the signature does not match the file")
+	}
+	comment := strings.TrimRight(strings.TrimPrefix(lines[2], trustedCommentPrefix), "\r")
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || !ed25519.Verify(pk.Key, append(append([]byte(nil), raw[10:]...), comment...), global) {
+		return "", errors.New("minisign: the trusted comment was altered")
+	}
+	return comment, nil
+}
+
+// keyLines returns the lines of a key file that are not comments.
+func keyLines(data []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, commentPrefix) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,143 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+func generateKey(t *testing.T) *SecretKey {
+	t.Helper()
+	sk, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sk
+}
+
+func TestMinisignSignVerify(t *testing.T) {
+	sk := generateKey(t)
+	pk, err := ParsePublicKey(sk.Public().Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pk.ID != sk.ID || !pk.Key.Equal(sk.Public().Key) {
+		t.Fatal("the public key does not parse back")
+	}
+	// minisign -P takes the key line alone
+	line := strings.Split(string(pk.Marshal()), "\n")[1]
+	if again, err := ParsePublicKey([]byte(line)); err != nil || again.ID != pk.ID {
+		t.Errorf("key line: %v", err)
+	}
+
+	message := []byte(`{"hash": "e5c0249172b3"}`)
+	sig := sk.Sign(message, "timestamp:1767322800\tfile:tree.json")
+	comment, err := pk.Verify(message, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if comment != "timestamp:1767322800\tfile:tree.json" {
+		t.Errorf("got trusted comment %q", comment)
+	}
+
+	// Signatures of the message itself, as older minisign versions made
+	legacy := ed25519.Sign(sk.Key, message)
+	legacySig := fmt.Sprintf("untrusted comment: legacy\n%s\ntrusted comment: old\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), sk.ID[:]...), legacy...)),
+		base64.StdEncoding.EncodeToString(ed25519.Sign(sk.Key, append(legacy, "old"...))))
+	if comment, err := pk.Verify(message, []byte(legacySig)); err != nil || comment != "old" {
+		t.Errorf("legacy signature: got %q, %v", comment, err)
+	}
+}
+
+func TestMinisignRefusesTampered(t *testing.T) {
+	sk := generateKey(t)
+	pk := sk.Public()
+	message := []byte("hi\n")
+	sig := string(sk.Sign(message, "trusted"))
+	lines := strings.Split(sig, "\n")
+
+	flip := func(line string, i int) string {
+		raw, _ := base64.StdEncoding.DecodeString(line)
+		raw[i] ^= 1
+		return base64.StdEncoding.EncodeToString(raw)
+	}
+	otherKey := generateKey(t)
+	sameID := generateKey(t)
+	sameID.ID = sk.ID
+	tests := []struct {
+		name    string
+		message string
+		sig     string
+		pk      *PublicKey
+		want    string
+	}{
+		{"message", "HO\n", sig, pk, "does not match the file"},
+		{"signature", "hi\n", strings.Replace(sig, lines[1], flip(lines[1], 20), 1), pk, "does not match the file"},
+		{"key id", "hi\n", strings.Replace(sig, lines[1], flip(lines[1], 2), 1), pk, "signed with key"},
+		{"algorithm", "hi\n", strings.Replace(sig, lines[1], flip(lines[1], 1), 1), pk, "unknown signature algorithm"},
+		{"trusted comment", "hi\n", strings.Replace(sig, "trusted comment: trusted", "trusted comment: forged", 1), pk, "trusted comment was altered"},
+		{"global signature", "hi\n", strings.Replace(sig, lines[3], flip(lines[3], 5), 1), pk, "trusted comment was altered"},
+		{"truncated", "hi\n", strings.Join(lines[:2], "\n"), pk, "not a signature file"},
+		{"other key", "hi\n", sig, otherKey.Public(), "signed with key"},
+		{"other key with the same id", "hi\n", sig, sameID.Public(), "does not match the file"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.pk.Verify([]byte(test.message), []byte(test.sig)); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want an error containing %q", err, test.want)
+			}
+		})
+	}
+
+	// The signature is of the BLAKE2b hash of the message
+	hash := blake2b.Sum512(message)
+	raw, _ := base64.StdEncoding.DecodeString(lines[1])
+	if !ed25519.Verify(pk.Key, hash[:], raw[10:]) {
+		t.Error("the signature is not of the prehashed message")
+	}
+}
+
+func TestMinisignSecretKey(t *testing.T) {
+	sk := generateKey(t)
+	plain, err := sk.Marshal("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if SecretKeyEncrypted(plain) {
+		t.Error("an unencrypted key needs a passphrase")
+	}
+	if again, err := ParseSecretKey(plain, ""); err != nil || !again.Key.Equal(sk.Key) || again.ID != sk.ID {
+		t.Errorf("unencrypted key: %v", err)
+	}
+
+	// minisign's own limits take a GiB of memory; the format is the same
+	// with lower ones
+	encrypted, err := sk.marshal("pw", 32768, 16<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !SecretKeyEncrypted(encrypted) {
+		t.Error("an encrypted key needs no passphrase")
+	}
+	if again, err := ParseSecretKey(encrypted, "pw"); err != nil || !again.Key.Equal(sk.Key) || again.ID != sk.ID {
+		t.Errorf("encrypted key: %v", err)
+	}
+	for passphrase, want := range map[string]string{"": "no passphrase", "pW": "wrong passphrase"} {
+		if _, err := ParseSecretKey(encrypted, passphrase); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("passphrase %q: got %v, want %q", passphrase, err, want)
+		}
+	}
+
+	lines := strings.Split(string(plain), "\n")
+	raw, _ := base64.StdEncoding.DecodeString(lines[1])
+	raw[60] ^= 1
+	corrupt := lines[0] + "\n" + base64.StdEncoding.EncodeToString(raw) + "\n"
+	if _, err := ParseSecretKey([]byte(corrupt), ""); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("corrupt key: got %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package signing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Files written next to each other in the tree's state directory.
+const (
+	RootFile         = "root"
+	RootSigFile      = "root.asc"
+	ExportFile       = "export.json"
+	ExportSigFile    = "export.json.asc"
+	rootStatementTag = "mtfs-root v1 sha256"
+)
+
+// RootStatement is the exact text that gets signed for a root hash.
+func RootStatement(hash string) []byte {
+	return []byte(fmt.Sprintf("%s %s\n", rootStatementTag, hash))
+}
+
+// ParseRootStatement extracts the root hash from a statement produced by
+// RootStatement.
+func ParseRootStatement(data []byte) (string, error) {
+	line := strings.TrimSpace(string(data))
+	hash, ok := strings.CutPrefix(line, rootStatementTag+" ")
+	if !ok || hash == "" || strings.ContainsAny(hash, " \t\n") {
+		return "", fmt.Errorf("not an MTFS root statement: %q", line)
+	}
+	return hash, nil
+}
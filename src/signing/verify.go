@@ -0,0 +1,250 @@
+package signing
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"unicode"
+
+	"MTFS/state"
+)
+
+// TrustStoreEnv names the environment variable pointing at the keyring
+// that signatures are checked against. When it is unset, gpg's default
+// keyring and trust database are used.
+const TrustStoreEnv = "MTFS_TRUST_STORE"
+
+// TrustedKeysEnv names the environment variable listing the fingerprints,
+// separated by commas or spaces, of keys whose good signatures are trusted
+// whatever gpg's trust database says of them.
+const TrustedKeysEnv = "MTFS_TRUSTED_KEYS"
+
+// Status is the outcome of checking a signature.
+type Status int
+
+const (
+	StatusUnsigned Status = iota
+	StatusGood
+	StatusBad
+	StatusUnknownKey
+	StatusExpiredKey
+	StatusRevokedKey
+	StatusUntrustedKey
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusGood:
+		return "good"
+	case StatusBad:
+		return "BAD"
+	case StatusUnknownKey:
+		return "unknown key"
+	case StatusExpiredKey:
+		return "expired key"
+	case StatusRevokedKey:
+		return "revoked key"
+	case StatusUntrustedKey:
+		return "untrusted key"
+	default:
+		return "unsigned"
+	}
+}
+
+// Verification describes a checked signature.
+type Verification struct {
+	Status      Status
+	KeyID       string
+	Fingerprint string
+	Signer      string
+	// Trust is what gpg's trust database says of the key: "undefined",
+	// "never", "marginal", "fully" or "ultimate"; empty with a trust store.
+	Trust string
+}
+
+// Verify checks a detached signature over data. Keys are looked up in
+// Keyring when set, otherwise in gpg's default keyring. A good signature
+// is only trusted when its key is in Keyring, fully or ultimately trusted
+// in gpg's trust database or pinned by its fingerprint, and reported as
+// made by an untrusted key otherwise. A signature that does not validate
+// is reported through the returned Status, not as an error; errors are
+// reserved for gpg failing to run at all.
+func (g *GPG) Verify(data, sig []byte) (*Verification, error) {
+	pinned := g.Fingerprints
+	if pinned == nil {
+		pinned = strings.FieldsFunc(os.Getenv(TrustedKeysEnv), func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	}
+	args := []string{"--batch", "--no-auto-key-retrieve", "--status-fd", "1"}
+	if g.Keyring != "" {
+		// Everything in the trust store is trusted by definition, but
+		// only what is in it before the signature is checked
+		keys, err := g.keyringFingerprints()
+		if err != nil {
+			return nil, err
+		}
+		pinned = append(slices.Clone(pinned), keys...)
+		args = append(args, "--no-default-keyring", "--keyring", g.Keyring, "--trust-model", "always")
+	}
+
+	sigFile, err := os.CreateTemp("", "mtfs-sig-*.asc")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(sigFile.Name())
+	if _, err := sigFile.Write(sig); err != nil {
+		sigFile.Close()
+		return nil, err
+	}
+	sigFile.Close()
+	args = append(args, "--verify", sigFile.Name(), "-")
+
+	cmd := exec.Command(g.binary(), args...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	v := parseStatus(stdout.Bytes(), pinned)
+	if v.Status == StatusUnsigned {
+		if runErr == nil {
+			runErr = errors.New("no signature status reported")
+		}
+		return nil, fmt.Errorf("gpg verify: %v: %s", runErr, strings.TrimSpace(stderr.String()))
+	}
+	return v, nil
+}
+
+// parseStatus interprets gpg's machine-readable --status-fd output. A good
+// signature by a key neither fully trusted nor pinned is untrusted.
+func parseStatus(out []byte, pinned []string) *Verification {
+	v := &Verification{}
+	var primary string // fingerprint of the primary key of a signing subkey
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(strings.TrimPrefix(scanner.Text(), "[GNUPG:] "))
+		if len(fields) == 0 {
+			continue
+		}
+		if trust, ok := strings.CutPrefix(fields[0], "TRUST_"); ok {
+			v.Trust = strings.ToLower(trust)
+			continue
+		}
+		if len(fields) < 2 {
+			continue
+		}
+		keyword, keyID := fields[0], fields[1]
+		signer := strings.Join(fields[2:], " ")
+
+		switch keyword {
+		case "GOODSIG":
+			v.Status, v.KeyID, v.Signer = StatusGood, keyID, signer
+		case "BADSIG":
+			v.Status, v.KeyID, v.Signer = StatusBad, keyID, signer
+		case "EXPKEYSIG":
+			v.Status, v.KeyID, v.Signer = StatusExpiredKey, keyID, signer
+		case "REVKEYSIG":
+			v.Status, v.KeyID, v.Signer = StatusRevokedKey, keyID, signer
+		case "ERRSIG", "NO_PUBKEY":
+			if v.Status == StatusUnsigned {
+				v.Status, v.KeyID = StatusUnknownKey, keyID
+			}
+		case "VALIDSIG":
+			v.Fingerprint = keyID
+			if len(fields) > 10 {
+				primary = fields[10]
+			}
+		}
+	}
+	if v.Status == StatusGood && v.Trust != "fully" && v.Trust != "ultimate" &&
+		!pins(pinned, v.Fingerprint) && !pins(pinned, primary) {
+		v.Status = StatusUntrustedKey
+	}
+	return v
+}
+
+// pins reports whether fingerprint is one of pinned, which may be written
+// in either case, with spaces or a 0x prefix.
+func pins(pinned []string, fingerprint string) bool {
+	if fingerprint == "" {
+		return false
+	}
+	for _, p := range pinned {
+		p = strings.TrimPrefix(strings.ToUpper(strings.ReplaceAll(p, " ", "")), "0X")
+		if p == strings.ToUpper(fingerprint) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyringFingerprints returns the fingerprints of the keys and subkeys in
+// the trust store.
+func (g *GPG) keyringFingerprints() ([]string, error) {
+	cmd := exec.Command(g.binary(), "--batch", "--no-default-keyring", "--keyring", g.Keyring, "--with-colons", "--list-keys")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("gpg list keys: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	var fingerprints []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if fields := strings.Split(line, ":"); fields[0] == "fpr" && len(fields) > 9 {
+			fingerprints = append(fingerprints, fields[9])
+		}
+	}
+	return fingerprints, nil
+}
+
+// RootStatus is the signature state of a tree's root hash.
+type RootStatus struct {
+	// SignedHash is the root hash covered by the signature, empty if the
+	// tree has no root signature.
+	SignedHash string
+	// Verification is the result of checking the root signature.
+	Verification *Verification
+}
+
+// Valid reports whether the root signature is good and, when current is
+// not empty, covers that root hash.
+func (r *RootStatus) Valid(current string) bool {
+	if r.Verification == nil || r.Verification.Status != StatusGood {
+		return false
+	}
+	return current == "" || current == r.SignedHash
+}
+
+// CheckRoot verifies the root signature stored in the state directory of
+// tree. A tree without a signature yields a RootStatus with a nil
+// Verification.
+func (g *GPG) CheckRoot(tree string) (*RootStatus, error) {
+	statement, err := state.ReadFile(tree, RootFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return &RootStatus{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	hash, err := ParseRootStatement(statement)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := state.ReadFile(tree, RootSigFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return &RootStatus{SignedHash: hash}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	v, err := g.Verify(statement, sig)
+	if err != nil {
+		return nil, err
+	}
+	return &RootStatus{SignedHash: hash, Verification: v}, nil
+}
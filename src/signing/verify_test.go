@@ -0,0 +1,150 @@
+package signing
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	fingerprint = "0123456789ABCDEF0123456789ABCDEF01234567"
+	primary     = "89ABCDEF0123456789ABCDEF0123456789ABCDEF"
+)
+
+// status is the output of gpg --status-fd for a good signature by a subkey
+// of primary, with trust as the TRUST_ line, if any.
+func status(trust string) []byte {
+	lines := []string{
+		"[GNUPG:] NEWSIG",
+		"[GNUPG:] GOODSIG 0123456789ABCDEF Test Signer <test@example.com>",
+		"[GNUPG:] VALIDSIG " + fingerprint + " 2026-01-02 1767322800 0 4 0 22 10 00 " + primary,
+	}
+	if trust != "" {
+		lines = append(lines, "[GNUPG:] "+trust)
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func TestParseStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		out    []byte
+		pinned []string
+		want   Status
+	}{
+		{"ultimate", status("TRUST_ULTIMATE 0 pgp"), nil, StatusGood},
+		{"fully", status("TRUST_FULLY 0 pgp"), nil, StatusGood},
+		{"marginal", status("TRUST_MARGINAL 0 pgp"), nil, StatusUntrustedKey},
+		{"undefined", status("TRUST_UNDEFINED 0 pgp"), nil, StatusUntrustedKey},
+		{"never", status("TRUST_NEVER 0 pgp"), nil, StatusUntrustedKey},
+		{"no trust line", status(""), nil, StatusUntrustedKey},
+		{"pinned", status("TRUST_UNDEFINED 0 pgp"), []string{fingerprint}, StatusGood},
+		{"pinned primary key", status(""), []string{"0x" + strings.ToLower(primary)}, StatusGood},
+		{"pinned with spaces", status(""), []string{"0123 4567 89AB CDEF 0123  4567 89AB CDEF 0123 4567"}, StatusGood},
+		{"other key pinned", status("TRUST_UNDEFINED 0 pgp"), []string{"FFFF" + fingerprint[4:]}, StatusUntrustedKey},
+		{"bad", []byte("[GNUPG:] BADSIG 0123456789ABCDEF Test Signer\n[GNUPG:] TRUST_ULTIMATE 0 pgp\n"), []string{fingerprint}, StatusBad},
+		{"unknown key", []byte("[GNUPG:] ERRSIG 0123456789ABCDEF 22 10 00 1767322800 9 -\n[GNUPG:] NO_PUBKEY 0123456789ABCDEF\n"), nil, StatusUnknownKey},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if v := parseStatus(test.out, test.pinned); v.Status != test.want {
+				t.Errorf("got %v, want %v", v.Status, test.want)
+			}
+		})
+	}
+}
+
+// gpgHome returns a new gpg home directory, with a signing key in it when
+// withKey is set.
+func gpgHome(t *testing.T, withKey bool) string {
+	t.Helper()
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	// gpg-agent's socket path must be short
+	home, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		exec.Command("gpgconf", "--homedir", home, "--kill", "all").Run()
+		os.RemoveAll(home)
+	})
+	if withKey {
+		gpg(t, home, "--passphrase", "", "--quick-gen-key", "Test Signer <test@example.com>", "ed25519", "sign", "never")
+	}
+	return home
+}
+
+func gpg(t *testing.T, home string, args ...string) []byte {
+	t.Helper()
+	cmd := exec.Command("gpg", append([]string{"--homedir", home, "--batch", "--pinentry-mode", "loopback"}, args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("gpg %s: %v", strings.Join(args, " "), err)
+	}
+	return out
+}
+
+func TestVerifyTrust(t *testing.T) {
+	signer := gpgHome(t, true)
+	data := []byte("root hash\n")
+	t.Setenv("GNUPGHOME", signer)
+	sig, err := (&GPG{}).Sign(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := gpg(t, signer, "--armor", "--export")
+
+	// Another home that imported the key without trusting it
+	verifier := gpgHome(t, false)
+	publicFile := filepath.Join(verifier, "signer.asc")
+	if err := os.WriteFile(publicFile, public, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	gpg(t, verifier, "--import", publicFile)
+	keyring := filepath.Join(verifier, "trusted.kbx")
+	gpg(t, verifier, "--no-default-keyring", "--keyring", keyring, "--import", publicFile)
+
+	var keyFingerprint string
+	for _, line := range strings.Split(string(gpg(t, signer, "--with-colons", "--list-keys")), "\n") {
+		if fields := strings.Split(line, ":"); fields[0] == "fpr" && keyFingerprint == "" {
+			keyFingerprint = fields[9]
+		}
+	}
+
+	tests := []struct {
+		name string
+		home string
+		gpg  *GPG
+		want Status
+	}{
+		{"own key", signer, &GPG{}, StatusGood},
+		{"imported key", verifier, &GPG{}, StatusUntrustedKey},
+		{"pinned key", verifier, &GPG{Fingerprints: []string{keyFingerprint}}, StatusGood},
+		{"trust store", verifier, &GPG{Keyring: keyring}, StatusGood},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("GNUPGHOME", test.home)
+			v, err := test.gpg.Verify(data, sig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Status != test.want {
+				t.Errorf("got %v, want %v", v.Status, test.want)
+			}
+			if v, err := test.gpg.Verify([]byte("root hasH\n"), sig); err != nil || v.Status != StatusBad {
+				t.Errorf("other data: got %v, %v", v, err)
+			}
+		})
+	}
+
+	t.Setenv("GNUPGHOME", verifier)
+	t.Setenv(TrustedKeysEnv, "FFFF, "+keyFingerprint)
+	if v, err := (&GPG{}).Verify(data, sig); err != nil || v.Status != StatusGood {
+		t.Errorf("pinned through $%s: got %v, %v", TrustedKeysEnv, v, err)
+	}
+}
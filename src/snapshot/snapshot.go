@@ -0,0 +1,236 @@
+// Package snapshot keeps named snapshots of a tree: its root hash, the
+// list of its entries and how it was built, saved under a name of the
+// user's choosing with the time in the state directory. Unlike the
+// snapshots of a chunk store they hold no content, only what a scan found,
+// so one-off scans add up to a history the tree can be audited against.
+package snapshot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"MTFS/manifest"
+	"MTFS/state"
+)
+
+// Dir is the directory of the state directory snapshots are kept in, one
+// JSON file per name.
+const Dir = "snapshots"
+
+// maxName is the longest name a snapshot may have.
+const maxName = 100
+
+// ErrExists is returned when saving under a name a snapshot already has.
+var ErrExists = errors.New("a snapshot of that name already exists")
+
+// Snapshot is a tree as a scan found it.
+type Snapshot struct {
+	Name        string            `json:"name"`
+	Time        time.Time         `json:"time"`
+	User        string            `json:"user,omitempty"`
+	Host        string            `json:"host,omitempty"`
+	RootHash    string            `json:"root_hash"`
+	HashFormat  string            `json:"hash_format"`
+	Files       int64             `json:"files"`
+	Directories int64             `json:"directories"`
+	Bytes       int64             `json:"bytes"`
+	Options     map[string]string `json:"options,omitempty"` // walk options the tree was built with
+	Tree        json.RawMessage   `json:"tree,omitempty"`    // the JSON export; List leaves it out
+}
+
+// CheckName reports whether name can name a snapshot: up to maxName
+// letters, digits, dots, dashes and underscores, not starting with a dot.
+func CheckName(name string) error {
+	if name == "" {
+		return errors.New("no snapshot name given")
+	}
+	if len(name) > maxName {
+		return fmt.Errorf("snapshot name %q is longer than %d characters", name, maxName)
+	}
+	if name[0] == '.' {
+		return fmt.Errorf("snapshot name %q starts with a dot", name)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+			return fmt.Errorf("snapshot name %q may only hold letters, digits, dots, dashes and underscores", name)
+		}
+	}
+	return nil
+}
+
+// New returns a snapshot named name of tree from its JSON export, taken
+// now.
+func New(tree, name string, export []byte) (*Snapshot, error) {
+	if err := CheckName(name); err != nil {
+		return nil, err
+	}
+	root, err := manifest.Parse(export)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{Name: name, Time: time.Now().UTC(), Host: hostname(), User: username(),
+		RootHash: root.Hash, HashFormat: state.HashFormat(tree), Options: readOptions(tree),
+		Tree: json.RawMessage(strings.TrimSpace(string(export)))}
+	if root.HashFormat != "" {
+		s.HashFormat = root.HashFormat
+	}
+	root.Walk(func(p string, n *manifest.Node) error {
+		switch {
+		case n.IsFile():
+			s.Files++
+			s.Bytes += n.Size
+		case n.Type == "directory" && p != ".":
+			s.Directories++
+		}
+		return nil
+	})
+	return s, nil
+}
+
+// Save stores s in the state directory of tree. It does not replace a
+// snapshot of the same name; Delete it first.
+func Save(tree string, s *Snapshot) error {
+	if err := CheckName(s.Name); err != nil {
+		return err
+	}
+	if _, err := os.Stat(file(tree, s.Name)); err == nil {
+		return fmt.Errorf("%w: %s", ErrExists, s.Name)
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return state.WriteFile(tree, path.Join(Dir, s.Name+".json"), append(data, '\n'))
+}
+
+// Load reads the snapshot of tree named name.
+func Load(tree, name string) (*Snapshot, error) {
+	if err := CheckName(name); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file(tree, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no snapshot named %s", name)
+	} else if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", file(tree, name), err)
+	}
+	return &s, nil
+}
+
+// List returns the snapshots of tree, newest first, without their
+// exports. Files that cannot be read are left out.
+func List(tree string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(state.Path(tree, Dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var list []Snapshot
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || CheckName(name) != nil {
+			continue
+		}
+		s, err := Load(tree, name)
+		if err != nil {
+			continue
+		}
+		s.Tree = nil
+		list = append(list, *s)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Time.After(list[j].Time) })
+	return list, nil
+}
+
+// Delete removes the snapshot of tree named name.
+func Delete(tree, name string) error {
+	if err := CheckName(name); err != nil {
+		return err
+	}
+	err := os.Remove(file(tree, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no snapshot named %s", name)
+	}
+	return err
+}
+
+// Root returns the tree the snapshot recorded.
+func (s *Snapshot) Root() (*manifest.Node, error) {
+	if len(s.Tree) == 0 {
+		return nil, fmt.Errorf("snapshot %s holds no tree", s.Name)
+	}
+	return manifest.Parse(s.Tree)
+}
+
+// Open returns the tree ref stands for: the snapshot of tree named ref,
+// or else the JSON export or snapshot file at the path ref.
+func Open(tree, ref string) (*manifest.Node, error) {
+	if CheckName(ref) == nil {
+		if _, err := os.Stat(file(tree, ref)); err == nil {
+			s, err := Load(tree, ref)
+			if err != nil {
+				return nil, err
+			}
+			return s.Root()
+		}
+	}
+	data, err := os.ReadFile(ref)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s is neither a snapshot of %s nor a file", ref, tree)
+	} else if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if json.Unmarshal(data, &s) == nil && s.RootHash != "" && len(s.Tree) > 0 {
+		return s.Root()
+	}
+	root, err := manifest.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ref, err)
+	}
+	return root, nil
+}
+
+func file(tree, name string) string {
+	return state.Path(tree, Dir, name+".json")
+}
+
+// readOptions returns the walk options recorded for tree, which are
+// lines of a name and a value separated by a tab.
+func readOptions(tree string) map[string]string {
+	data, err := state.ReadFile(tree, state.OptionsFile)
+	if err != nil {
+		return nil
+	}
+	options := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if name, value, ok := strings.Cut(line, "\t"); ok {
+			options[name] = value
+		}
+	}
+	return options
+}
+
+func hostname() string {
+	host, _ := os.Hostname()
+	return host
+}
+
+func username() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
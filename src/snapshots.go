@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"MTFS/manifest"
+	"MTFS/monitor"
+	"MTFS/registry"
+	"MTFS/remote"
+	"MTFS/snapshot"
+)
+
+// diffSnapshots compares two saved trees without building either, going
+// down only into the directories whose hashes differ.
+func diffSnapshots(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	tree := flags.String("tree", ".", "tree whose named snapshots OLD and NEW may be")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Error: give the two snapshots or exports to compare")
+		return 2
+	}
+	*tree = registry.Resolve(*tree)
+	operation.Tree = *tree
+	operation.Params["old"], operation.Params["new"] = flags.Arg(0), flags.Arg(1)
+	var roots [2]*manifest.Node
+	for i := range roots {
+		var err error
+		if roots[i], err = snapshot.Open(*tree, flags.Arg(i)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+	c, err := monitor.CompareRoots(flags.Arg(0), flags.Arg(1), roots[0], roots[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	operation.RootHash = c.Roots[1].Hash
+	for i, name := range c.Trees {
+		fmt.Printf("%s: root %s\n", name, c.Roots[i].Hash)
+	}
+	if len(c.Changes) == 0 {
+		fmt.Println("The trees are identical")
+		return 0
+	}
+	for _, e := range c.Changes {
+		fmt.Printf("%-9s %s: %s\n", e.Type, e.Path, e.Message)
+	}
+	fmt.Printf("%d differences\n", len(c.Changes))
+	return 1
+}
+
+func namedSnapshots(args []string) int {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	switch args[0] {
+	case "save":
+		return saveSnapshot(args[1:])
+	case "list":
+		return listNamedSnapshots(args[1:])
+	case "show":
+		return showSnapshot(args[1:])
+	case "delete":
+		return deleteSnapshot(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Error: unknown snapshot command %q (save, list, show or delete)\n", args[0])
+	return 2
+}
+
+// saveSnapshot builds a tree and saves its root hash and export under a
+// name in its state directory.
+func saveSnapshot(args []string) int {
+	flags := flag.NewFlagSet("snapshot save", flag.ExitOnError)
+	addWalkFlags(flags)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no snapshot name given")
+		return 2
+	}
+	name, tree := flags.Arg(0), treeArg(flags, 1)
+	operation.Tree = tree
+	operation.Params["name"] = name
+	if err := snapshot.CheckName(name); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	reports, err := remote.Report(tree, walkArgs(flags), remote.ExportJSON)
+	var s *snapshot.Snapshot
+	if err == nil {
+		s, err = snapshot.New(tree, name, []byte(strings.Join(reports[1], "\n")))
+	}
+	if err == nil {
+		err = snapshot.Save(tree, s)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.RootHash = s.RootHash
+	fmt.Printf("Saved snapshot %s of %s: root %s, %d files, %d bytes\n", s.Name, tree, s.RootHash, s.Files, s.Bytes)
+	return 0
+}
+
+func listNamedSnapshots(args []string) int {
+	flags := flag.NewFlagSet("snapshot list", flag.ExitOnError)
+	flags.Parse(args)
+	tree := treeArg(flags, 0)
+	operation.Tree = tree
+	list, err := snapshot.List(tree)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(list) == 0 {
+		fmt.Printf("No snapshots of %s are saved yet\n", tree)
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTIME\tROOT HASH\tFILES\tBYTES")
+	for _, s := range list {
+		root := s.RootHash
+		if len(root) > 16 {
+			root = root[:16] + "…"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", s.Name, s.Time.Local().Format("2006-01-02 15:04:05"), root, s.Files, s.Bytes)
+	}
+	w.Flush()
+	return 0
+}
+
+// showSnapshot prints what a named snapshot recorded and, with -files,
+// every entry of its tree with its hash.
+func showSnapshot(args []string) int {
+	flags := flag.NewFlagSet("snapshot show", flag.ExitOnError)
+	files := flags.Bool("files", false, "list the entries of the snapshot with their hashes")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no snapshot name given")
+		return 2
+	}
+	tree := treeArg(flags, 1)
+	operation.Tree = tree
+	s, err := snapshot.Load(tree, flags.Arg(0))
+	var root *manifest.Node
+	if err == nil && *files {
+		root, err = s.Root()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.RootHash = s.RootHash
+	fmt.Printf("Name: %s\n", s.Name)
+	fmt.Printf("Time: %s\n", s.Time.Local().Format(time.RFC3339))
+	if s.User != "" || s.Host != "" {
+		fmt.Printf("By: %s@%s\n", s.User, s.Host)
+	}
+	fmt.Printf("Root hash: %s\n", s.RootHash)
+	fmt.Printf("Hash format: %s\n", s.HashFormat)
+	fmt.Printf("Files: %d\nDirectories: %d\nBytes: %d\n", s.Files, s.Directories, s.Bytes)
+	options := make([]string, 0, len(s.Options))
+	for k := range s.Options {
+		options = append(options, k)
+	}
+	sort.Strings(options)
+	for _, k := range options {
+		fmt.Printf("Option %s: %s\n", k, s.Options[k])
+	}
+	if root == nil {
+		return 0
+	}
+	fmt.Println()
+	root.Walk(func(p string, n *manifest.Node) error {
+		if p != "." {
+			fmt.Printf("%s  %s  %s\n", n.Hash, n.Type, p)
+		}
+		return nil
+	})
+	return 0
+}
+
+func deleteSnapshot(args []string) int {
+	flags := flag.NewFlagSet("snapshot delete", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no snapshot name given")
+		return 2
+	}
+	name, tree := flags.Arg(0), treeArg(flags, 1)
+	operation.Tree = tree
+	operation.Params["name"] = name
+	if err := snapshot.Delete(tree, name); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Deleted snapshot %s of %s\n", name, tree)
+	return 0
+}
@@ -0,0 +1,210 @@
+// Package spdx exports the files of a tree as an SPDX 2.3 JSON document,
+// one file entry per file with its path, checksums and size, so the
+// integrity data MTFS collects can be merged into software bills of
+// materials.
+//
+// The files belong to a package named after the tree, whose verification
+// code is computed as the specification describes, and the document's
+// namespace is derived from the root hash so exports of the same tree
+// state share it.
+package spdx
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"MTFS/forensic"
+	"MTFS/manifest"
+)
+
+// Version is the SPDX version documents are written in.
+const Version = "SPDX-2.3"
+
+// Document is an SPDX document.
+type Document struct {
+	SPDXVersion       string         `json:"spdxVersion"`
+	DataLicense       string         `json:"dataLicense"`
+	SPDXID            string         `json:"SPDXID"`
+	Name              string         `json:"name"`
+	DocumentNamespace string         `json:"documentNamespace"`
+	Comment           string         `json:"comment,omitempty"`
+	CreationInfo      CreationInfo   `json:"creationInfo"`
+	Packages          []Package      `json:"packages"`
+	Files             []File         `json:"files"`
+	Relationships     []Relationship `json:"relationships"`
+}
+
+// CreationInfo records when and by what a document was created.
+type CreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+// Package is the tree as an SPDX package.
+type Package struct {
+	SPDXID               string           `json:"SPDXID"`
+	Name                 string           `json:"name"`
+	DownloadLocation     string           `json:"downloadLocation"`
+	FilesAnalyzed        bool             `json:"filesAnalyzed"`
+	VerificationCode     VerificationCode `json:"packageVerificationCode"`
+	LicenseConcluded     string           `json:"licenseConcluded"`
+	LicenseDeclared      string           `json:"licenseDeclared"`
+	CopyrightText        string           `json:"copyrightText"`
+	LicenseInfoFromFiles []string         `json:"licenseInfoFromFiles"`
+}
+
+// VerificationCode is the SHA-1 over the sorted SHA-1s of a package's
+// files.
+type VerificationCode struct {
+	Value string `json:"packageVerificationCodeValue"`
+}
+
+// File is an SPDX file entry. SPDX 2.3 has no size field, so the size is
+// given in the comment.
+type File struct {
+	SPDXID           string     `json:"SPDXID"`
+	FileName         string     `json:"fileName"`
+	Checksums        []Checksum `json:"checksums"`
+	LicenseConcluded string     `json:"licenseConcluded"`
+	CopyrightText    string     `json:"copyrightText"`
+	Comment          string     `json:"comment"`
+	Size             int64      `json:"-"`
+}
+
+// Checksum is a file checksum.
+type Checksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"checksumValue"`
+}
+
+// Relationship relates two SPDX elements.
+type Relationship struct {
+	Element string `json:"spdxElementId"`
+	Type    string `json:"relationshipType"`
+	Related string `json:"relatedSpdxElement"`
+}
+
+// FromTree reads the files of the tree described by root, checking each
+// against its content hash, and returns the document listing them with
+// their SHA-1, SHA-256 and MD5 checksums. Symbolic links and special files
+// are not listed.
+func FromTree(tree string, root *manifest.Node, created time.Time) (*Document, error) {
+	pkgID := "SPDXRef-Package-" + idPart(root.Name)
+	doc := &Document{
+		SPDXVersion:       Version,
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              root.Name,
+		DocumentNamespace: "https://spdx.org/spdxdocs/" + idPart(root.Name) + "-" + root.Hash,
+		Comment:           "Files of the MTFS tree with root hash " + root.Hash,
+		CreationInfo: CreationInfo{
+			Created:  created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: MTFS"},
+		},
+		Relationships: []Relationship{{Element: "SPDXRef-DOCUMENT", Type: "DESCRIBES", Related: pkgID}},
+	}
+
+	var sha1s []string
+	err := root.Walk(func(p string, node *manifest.Node) error {
+		if !node.IsFile() {
+			return nil
+		}
+		f, err := fileEntry(filepath.Join(tree, filepath.FromSlash(p)), node)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		f.FileName = "./" + p
+		f.SPDXID = "SPDXRef-File-" + idFor(p)
+		doc.Files = append(doc.Files, f)
+		doc.Relationships = append(doc.Relationships, Relationship{Element: pkgID, Type: "CONTAINS", Related: f.SPDXID})
+		sha1s = append(sha1s, f.Checksums[0].Value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(doc.Files) == 0 {
+		return nil, errors.New("the tree has no files to list")
+	}
+
+	sort.Strings(sha1s)
+	code := sha1.Sum([]byte(strings.Join(sha1s, "")))
+	doc.Packages = []Package{{
+		SPDXID:               pkgID,
+		Name:                 root.Name,
+		DownloadLocation:     "NOASSERTION",
+		FilesAnalyzed:        true,
+		VerificationCode:     VerificationCode{Value: hex.EncodeToString(code[:])},
+		LicenseConcluded:     "NOASSERTION",
+		LicenseDeclared:      "NOASSERTION",
+		CopyrightText:        "NOASSERTION",
+		LicenseInfoFromFiles: []string{"NOASSERTION"},
+	}}
+	return doc, nil
+}
+
+func fileEntry(name string, node *manifest.Node) (File, error) {
+	file, err := forensic.Open(name)
+	if err != nil {
+		return File{}, err
+	}
+	defer file.Close()
+
+	check := node.NewContentCheck()
+	s1, s256, m5 := sha1.New(), sha256.New(), md5.New()
+	n, err := io.Copy(io.MultiWriter(check, s1, s256, m5), file)
+	if err != nil {
+		return File{}, err
+	}
+	if !check.Matches(node.ContentHash) {
+		return File{}, errors.New("changed since the tree was built")
+	}
+	return File{
+		Checksums: []Checksum{
+			{Algorithm: "SHA1", Value: hex.EncodeToString(s1.Sum(nil))},
+			{Algorithm: "SHA256", Value: hex.EncodeToString(s256.Sum(nil))},
+			{Algorithm: "MD5", Value: hex.EncodeToString(m5.Sum(nil))},
+		},
+		LicenseConcluded: "NOASSERTION",
+		CopyrightText:    "NOASSERTION",
+		Comment:          fmt.Sprintf("Size: %d bytes", n),
+		Size:             n,
+	}, nil
+}
+
+// Encode returns the document as indented JSON.
+func (d *Document) Encode() ([]byte, error) {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// idFor returns a stable SPDX identifier part for a path: identifiers may
+// only hold letters, digits, dots and dashes.
+func idFor(p string) string {
+	sum := sha256.Sum256([]byte(p))
+	return idPart(p) + "-" + hex.EncodeToString(sum[:4])
+}
+
+// idPart replaces what SPDX identifiers may not contain with dashes.
+func idPart(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '-'
+	}, s)
+}
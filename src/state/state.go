@@ -0,0 +1,75 @@
+// Package state locates the per-tree state directory (.mtfs) that the
+// backend also keeps its build checkpoint in. It is skipped when walking
+// the tree, so nothing stored here changes the root hash.
+package state
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"MTFS/forensic"
+)
+
+// DirName is the name of the state directory inside a tree root.
+const DirName = ".mtfs"
+
+// ConfigFile is the name of the hand-written settings a tree pins in its
+// state directory.
+const ConfigFile = "config"
+
+// OptionsFile is the name of the walk options of the last build, which
+// the backend records in the state directory.
+const OptionsFile = "options"
+
+// Dir returns the state directory of the tree rooted at tree. A tree built
+// from an archive keeps its state beside the archive, in <archive>.mtfs.
+// In forensic mode the state is kept in the case directory instead.
+func Dir(tree string) string {
+	if forensic.Dir() != "" {
+		return forensic.StateDir(tree)
+	}
+	if info, err := os.Stat(tree); err == nil && info.Mode().IsRegular() {
+		return tree + DirName
+	}
+	return filepath.Join(tree, DirName)
+}
+
+// Path returns the location of name inside the state directory of tree.
+func Path(tree string, name ...string) string {
+	return filepath.Join(append([]string{Dir(tree)}, name...)...)
+}
+
+// WriteFile writes data to name inside the state directory of tree,
+// creating the directory if needed.
+func WriteFile(tree, name string, data []byte) error {
+	p := Path(tree, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0o644)
+}
+
+// ReadFile reads name from the state directory of tree.
+func ReadFile(tree, name string) ([]byte, error) {
+	return os.ReadFile(Path(tree, name))
+}
+
+// HashFormat returns the hash format tree was last built with: mtfs, git,
+// git-sha256, mtfs-sha512, mtfs-blake2b or mtfs-blake3. Trees without
+// recorded options were built with mtfs.
+func HashFormat(tree string) string {
+	f, err := os.Open(Path(tree, OptionsFile))
+	if err != nil {
+		return "mtfs"
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "hash_format\t"); ok {
+			return value
+		}
+	}
+	return "mtfs"
+}
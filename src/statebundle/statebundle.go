@@ -0,0 +1,256 @@
+// Package statebundle moves the MTFS state of a tree to another machine:
+// its state directory (config, walk options, signatures, timestamps, log
+// receipts, metrics and reports), its entries in the operation log and
+// optionally its chunk store, in one gzip-compressed tar file. Importing
+// it next to a copy of the tree lets verification continue there.
+package statebundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"MTFS/oplog"
+	"MTFS/state"
+)
+
+// Version is the version of the bundles written by this package.
+const Version = 1
+
+// HistoryFile is the name of the imported operation history inside the
+// state directory. Its entries keep the hashes they had in the log they
+// came from, so they are not added to the local one.
+const HistoryFile = "history.jsonl"
+
+const (
+	infoName     = "mtfs-state.json"
+	historyName  = "history.jsonl"
+	stateDirName = "state/"
+	storeDirName = "store/"
+)
+
+// machineFiles are the files of the state directory that only hold on the
+// machine they were written on: the build checkpoint and hash cache, keyed
+// by modification time, and the lock of a running daemon.
+var machineFiles = map[string]bool{"checkpoint": true, "hashcache": true, "monitor.lock": true}
+
+// Info describes the tree a bundle was exported from.
+type Info struct {
+	Version    int      `json:"version"`
+	Name       string   `json:"name"`
+	Tree       string   `json:"tree"` // directory on the exporting machine
+	Host       string   `json:"host"`
+	Created    string   `json:"created"`
+	RootHash   string   `json:"root_hash,omitempty"`
+	HashFormat string   `json:"hash_format"`
+	Stores     []string `json:"stores,omitempty"` // stores of the tree on the exporting machine
+	Store      bool     `json:"store"`            // whether the bundle holds the first store
+}
+
+// Export writes a bundle of the state of tree, described by info, with
+// history, to w. An imported history of tree is not taken from its state
+// directory, so history should include it. If storeDir is not empty, the chunk store in that
+// directory goes in too.
+func Export(w io.Writer, info Info, tree string, history []oplog.Entry, storeDir string) error {
+	info.Version = Version
+	info.Store = storeDir != ""
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := addFile(tw, infoName, data); err != nil {
+		return err
+	}
+	var lines []byte
+	for _, e := range history {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if err := addFile(tw, historyName, lines); err != nil {
+		return err
+	}
+	if err := addDir(tw, state.Dir(tree), stateDirName, func(rel string) bool { return !machineFiles[rel] && rel != HistoryFile }); err != nil {
+		return err
+	}
+	if storeDir != "" {
+		if err := addDir(tw, storeDir, storeDirName, func(string) bool { return true }); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func addFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// addDir adds the regular files below dir for which keep is true, under
+// prefix.
+func addDir(tw *tar.Writer, dir, prefix string, keep func(rel string) bool) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == dir {
+			return nil
+		}
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || !keep(filepath.ToSlash(rel)) {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return addFile(tw, prefix+filepath.ToSlash(rel), data)
+	})
+}
+
+// Options say where an import goes.
+type Options struct {
+	Tree     string // directory of the tree the state belongs to
+	StoreDir string // directory for the chunk store, if the bundle has one
+	Force    bool   // replace state files the tree has already
+}
+
+// Import reads a bundle from r and writes its state to the tree and its
+// store to the store directory of opts. It returns the description of
+// the bundle and its history, which is also kept in HistoryFile. The
+// state is only written once the whole bundle was read and none of its
+// files is in the way.
+func Import(r io.Reader, opts Options) (*Info, []oplog.Entry, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a state bundle: %w", err)
+	}
+	tr := tar.NewReader(zr)
+	var info *Info
+	var history []oplog.Entry
+	files := map[string][]byte{}
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return info, history, err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(h.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return info, history, fmt.Errorf("bundle entry %q is outside the bundle", h.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return info, history, err
+		}
+
+		switch {
+		case name == infoName:
+			info = &Info{}
+			if err := json.Unmarshal(data, info); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", infoName, err)
+			}
+			if info.Version != Version {
+				return nil, nil, fmt.Errorf("state bundle version %d is not supported", info.Version)
+			}
+			if info.Store && opts.StoreDir == "" {
+				return nil, nil, errors.New("the bundle holds a chunk store; give a directory for it")
+			}
+		case info == nil:
+			return nil, nil, errors.New("not a state bundle: it does not start with " + infoName)
+		case name == historyName:
+			for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+				if line == "" {
+					continue
+				}
+				var e oplog.Entry
+				if err := json.Unmarshal([]byte(line), &e); err != nil {
+					return info, history, fmt.Errorf("%s: %w", historyName, err)
+				}
+				history = append(history, e)
+			}
+			files[HistoryFile] = data
+		case strings.HasPrefix(name, stateDirName):
+			files[strings.TrimPrefix(name, stateDirName)] = data
+		case strings.HasPrefix(name, storeDirName):
+			if opts.StoreDir == "" {
+				return info, history, errors.New("the bundle holds a chunk store; give a directory for it")
+			}
+			dest := filepath.Join(opts.StoreDir, filepath.FromSlash(strings.TrimPrefix(name, storeDirName)))
+			// Objects and snapshots are named by their hash, so one already
+			// there is the same, as long as the store is the same one
+			if name == storeDirName+"config" {
+				if existing, err := os.ReadFile(dest); err == nil && !bytes.Equal(existing, data) {
+					return info, history, fmt.Errorf("%s holds another chunk store; import into a new directory", opts.StoreDir)
+				}
+			}
+			if err := write(dest, data, true); err != nil {
+				return info, history, err
+			}
+		}
+	}
+	if info == nil {
+		return nil, nil, errors.New("not a state bundle: " + infoName + " is missing")
+	}
+
+	// A history imported before is replaced by the new one, which holds it
+	for name := range files {
+		dest := state.Path(opts.Tree, filepath.FromSlash(name))
+		if _, err := os.Stat(dest); err == nil && !opts.Force && name != HistoryFile {
+			return info, history, fmt.Errorf("%s exists already; import with -force to replace it", dest)
+		}
+	}
+	for name, data := range files {
+		if err := write(state.Path(opts.Tree, filepath.FromSlash(name)), data, false); err != nil {
+			return info, history, err
+		}
+	}
+	return info, history, nil
+}
+
+// write writes data to name. Private files are only readable by their
+// owner, as in a store.
+func write(name string, data []byte, private bool) error {
+	dirMode, fileMode := os.FileMode(0o755), os.FileMode(0o644)
+	if private {
+		dirMode, fileMode = 0o700, 0o600
+	}
+	if err := os.MkdirAll(filepath.Dir(name), dirMode); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, fileMode)
+}
+
+// ReadHistory returns the history imported into tree, if any.
+func ReadHistory(tree string) ([]oplog.Entry, error) {
+	entries, err := oplog.Read(state.Path(tree, HistoryFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return entries, err
+}
@@ -0,0 +1,748 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"MTFS/chunking"
+	"MTFS/duplicates"
+	"MTFS/fusefs"
+	"MTFS/manifest"
+	"MTFS/paths"
+	"MTFS/registry"
+	"MTFS/remote"
+	"MTFS/script"
+	"MTFS/store"
+	"MTFS/trends"
+)
+
+// addKeyFlag defines the -key flag of the commands opening a store, the
+// key file to give storeKey.
+func addKeyFlag(flags *flag.FlagSet) *string {
+	return flags.String("key", os.Getenv(store.KeyFileEnv), "key file (default $"+store.KeyFileEnv+", otherwise $"+store.PassphraseEnv+")")
+}
+
+// storeKey returns the key of a store: that in keyFile when it is given,
+// otherwise the passphrase in store.PassphraseEnv.
+func storeKey(keyFile string) (store.Key, error) {
+	if keyFile != "" {
+		return store.KeyFromFile(keyFile)
+	}
+	return store.Key{Passphrase: os.Getenv(store.PassphraseEnv)}, nil
+}
+
+func repairStore(args []string) int {
+	flags := flag.NewFlagSet("repair", flag.ExitOnError)
+	keyFile := addKeyFlag(flags)
+	var from, trees patternList
+	flags.Var(&from, "from", "another `STORE`, opened with the same key, holding copies of the chunks (repeatable)")
+	flags.Var(&trees, "tree", "a tree `DIR` the snapshots were stored from (repeatable)")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Error: give the store to repair")
+		return 2
+	}
+	if len(from) == 0 && len(trees) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: give other stores with -from or trees with -tree to take copies from")
+		return 2
+	}
+
+	key, err := storeKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	dir := registry.ResolveStore(flags.Arg(0))
+	s, err := store.OpenExisting(dir, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Params["store"] = dir
+	var others []*store.Store
+	for _, loc := range from {
+		o, err := store.OpenExisting(registry.ResolveStore(loc), key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		others = append(others, o)
+	}
+	dirs := make([]string, len(trees))
+	for i, t := range trees {
+		dirs[i] = registry.Resolve(t)
+	}
+
+	scrub, err := s.Scrub(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	damaged := scrub.Damaged()
+	if len(damaged) == 0 {
+		fmt.Printf("All %d chunks are intact and present; nothing to repair\n", scrub.Objects)
+		if len(scrub.BadSnapshots) > 0 {
+			fmt.Printf("%d snapshots cannot be read and cannot be repaired\n", len(scrub.BadSnapshots))
+			return 1
+		}
+		return 0
+	}
+	r, err := s.Repair(damaged, others, dirs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	for _, hash := range damaged {
+		if from, ok := r.Repaired[hash]; ok {
+			fmt.Printf("repaired: %s from %s\n", hash, from)
+		}
+	}
+	for _, hash := range r.Unrepaired {
+		fmt.Printf("no intact copy: %s\n", hash)
+	}
+
+	// Check the store again rather than trusting the writes
+	after, err := s.Scrub(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Repaired %d of %d damaged chunks; %d files in snapshots are still affected\n", len(r.Repaired), len(damaged), len(after.Affected))
+	if !after.OK() {
+		return 1
+	}
+	return 0
+}
+
+// scrubStore runs as scrub and as verify-store, its older name.
+func scrubStore(args []string) int {
+	flags := flag.NewFlagSet(operation.Operation, flag.ExitOnError)
+	keyFile := addKeyFlag(flags)
+	flags.Parse(args)
+
+	dir := os.Getenv(store.DirEnv)
+	if flags.NArg() > 0 {
+		dir = registry.ResolveStore(flags.Arg(0))
+	}
+	if dir == "" {
+		fmt.Fprintln(os.Stderr, "Error: no store directory or bucket given")
+		return 2
+	}
+
+	key, err := storeKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	s, err := store.OpenExisting(dir, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Params["store"] = dir
+
+	r, err := s.Scrub(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	corrupt := make([]string, 0, len(r.Corrupt))
+	for hash := range r.Corrupt {
+		corrupt = append(corrupt, hash)
+	}
+	sort.Strings(corrupt)
+	for _, hash := range corrupt {
+		fmt.Printf("corrupt: %s\n", r.Corrupt[hash])
+	}
+	for _, hash := range r.Missing {
+		fmt.Printf("missing: object %s\n", hash)
+	}
+	bad := make([]string, 0, len(r.BadSnapshots))
+	for root := range r.BadSnapshots {
+		bad = append(bad, root)
+	}
+	sort.Strings(bad)
+	for _, root := range bad {
+		fmt.Printf("unreadable snapshot %s: %s\n", root, r.BadSnapshots[root])
+	}
+	for _, d := range r.Affected {
+		fmt.Printf("snapshot %s: %s has %d of %d chunks damaged (%d corrupt, %d missing)\n",
+			d.Root, d.Path, d.Corrupt+d.Missing, d.Chunks, d.Corrupt, d.Missing)
+	}
+
+	fmt.Printf("Checked %d chunks (%d bytes) and %d snapshots: %d corrupt, %d missing, %d unreadable snapshots\n",
+		r.Objects, r.Bytes, r.Snapshots, len(r.Corrupt), len(r.Missing), len(r.BadSnapshots))
+	if len(r.Affected) > 0 {
+		snaps := map[string]bool{}
+		for _, d := range r.Affected {
+			snaps[d.Root] = true
+		}
+		fmt.Printf("%d files in %d snapshots are affected\n", len(r.Affected), len(snaps))
+	}
+	if r.Unreferenced > 0 {
+		fmt.Printf("%d chunks are not referenced by any snapshot; gc deletes them\n", r.Unreferenced)
+	}
+	if !r.OK() {
+		return 1
+	}
+	return 0
+}
+
+// forgetSnapshots deletes snapshots from a store, leaving their chunks to
+// collectGarbage.
+func forgetSnapshots(args []string) int {
+	flags := flag.NewFlagSet("forget", flag.ExitOnError)
+	keyFile := addKeyFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "Error: give the store and the root hashes of the snapshots to delete")
+		return 2
+	}
+
+	key, err := storeKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	dir := registry.ResolveStore(flags.Arg(0))
+	s, err := store.OpenExisting(dir, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Params["store"] = dir
+
+	// Every snapshot is looked up before any is deleted
+	var roots []string
+	for _, prefix := range flags.Args()[1:] {
+		root, err := s.FindSnapshot(prefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		roots = append(roots, root)
+	}
+	operation.Params["snapshots"] = strings.Join(roots, " ")
+	for _, root := range roots {
+		if err := s.DeleteSnapshot(root); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Deleted snapshot %s\n", root)
+	}
+	fmt.Println("Run gc to delete the chunks no other snapshot refers to")
+	return 0
+}
+
+// collectGarbage deletes the chunks of a store no snapshot refers to.
+func collectGarbage(args []string) int {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	keyFile := addKeyFlag(flags)
+	dryRun := flags.Bool("dry-run", false, "list what would be deleted without deleting it")
+	grace := flags.Duration("grace", store.DefaultGrace, "keep unreferenced chunks written this recently, which a running store may be about to refer to")
+	verbose := flags.Bool("v", false, "list every chunk deleted")
+	flags.Parse(args)
+
+	dir := os.Getenv(store.DirEnv)
+	if flags.NArg() > 0 {
+		dir = registry.ResolveStore(flags.Arg(0))
+	}
+	if dir == "" {
+		fmt.Fprintln(os.Stderr, "Error: no store directory or bucket given")
+		return 2
+	}
+	key, err := storeKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	s, err := store.OpenExisting(dir, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Params["store"] = dir
+	if *dryRun {
+		operation.Params["dry_run"] = "true"
+	}
+
+	r, err := s.GC(*grace, *dryRun, nil)
+	if r != nil && *verbose {
+		label := "deleted"
+		if r.DryRun {
+			label = "unreferenced"
+		}
+		for _, hash := range r.Deleted {
+			fmt.Printf("%s: object %s\n", label, hash)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	verb := "Deleted"
+	if r.DryRun {
+		verb = "Would delete"
+	}
+	fmt.Printf("%d snapshots refer to %d of %d chunks\n", r.Snapshots, r.Referenced, r.Objects)
+	fmt.Printf("%s %d unreferenced chunks, reclaiming %d bytes\n", verb, len(r.Deleted), r.Reclaimed)
+	if r.Recent > 0 {
+		fmt.Printf("Kept %d unreferenced chunks written within the last %s\n", r.Recent, *grace)
+	}
+	return 0
+}
+
+func listSnapshots(args []string) int {
+	flags := flag.NewFlagSet("snapshots", flag.ExitOnError)
+	keyFile := addKeyFlag(flags)
+	flags.Parse(args)
+
+	dir := os.Getenv(store.DirEnv)
+	if flags.NArg() > 0 {
+		dir = registry.ResolveStore(flags.Arg(0))
+	}
+	if dir == "" {
+		fmt.Fprintln(os.Stderr, "Error: no store directory or bucket given")
+		return 2
+	}
+
+	key, err := storeKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	s, err := store.OpenExisting(dir, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	roots, err := s.Snapshots()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	var snaps []*store.Snapshot
+	for _, root := range roots {
+		snap, err := s.LoadSnapshot(root)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		snaps = append(snaps, snap)
+	}
+	if len(snaps) == 0 {
+		fmt.Println("No snapshots are stored yet")
+		return 0
+	}
+	sort.SliceStable(snaps, func(i, j int) bool { return snaps[i].Created < snaps[j].Created })
+
+	// Snapshots stored before their additions were recorded show "-"
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STORED\tROOT HASH\tFILES\tBYTES\tCHUNKS\tNEW CHUNKS\tNEW BYTES STORED\tCOMPRESSION")
+	var total, added, written int64
+	for _, snap := range snaps {
+		stored, newChunks, newBytes, ratio := "-", "-", "-", "-"
+		if when, err := time.Parse(time.RFC3339Nano, snap.Created); err == nil {
+			stored = when.Local().Format("2006-01-02 15:04")
+		}
+		var bytes int64
+		chunks := 0
+		for _, f := range snap.Files {
+			bytes += f.Size
+			chunks += len(f.Chunks)
+		}
+		if a := snap.Added; a != nil {
+			newChunks, newBytes = strconv.Itoa(a.NewChunks), strconv.FormatInt(a.StoredBytes, 10)
+			added += a.StoredBytes
+			if a.WrittenBytes > 0 {
+				ratio = fmt.Sprintf("%.2fx", a.CompressionRatio())
+				written += a.WrittenBytes
+			} else {
+				written += a.StoredBytes
+			}
+		}
+		total += bytes
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\t%s\t%s\n", stored, snap.Root, len(snap.Files), bytes, chunks, newChunks, newBytes, ratio)
+	}
+	w.Flush()
+	fmt.Printf("%d snapshots of %d bytes in all; %d bytes were new when stored, written as %d bytes\n", len(snaps), total, added, written)
+	fmt.Printf("Chunks are encrypted with %s; new chunks are compressed with: %s\n", s.Cipher(), s.Compression())
+	return 0
+}
+
+func extractFile(args []string) int {
+	flags := flag.NewFlagSet("extract", flag.ExitOnError)
+	keyFile := addKeyFlag(flags)
+	out := flags.String("o", "", "file to write (default the file's name in the current directory)")
+	force := flags.Bool("force", false, "replace the output file if it exists")
+	flags.Parse(args)
+	if flags.NArg() != 3 {
+		fmt.Fprintln(os.Stderr, "Error: give the store, the root hash of the snapshot and the path of the file")
+		return 2
+	}
+
+	key, err := storeKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	s, err := store.OpenExisting(registry.ResolveStore(flags.Arg(0)), key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	root, err := s.FindSnapshot(flags.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	snap, err := s.LoadSnapshot(root)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	file, ok := snap.File(flags.Arg(2))
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: snapshot %s has no file %s\n", root, flags.Arg(2))
+		return 1
+	}
+	operation.RootHash = root
+	operation.Params["path"] = file.Path
+
+	dest := *out
+	if dest == "" {
+		dest = path.Base(file.Path)
+	}
+	if err := s.ExtractTo(file, dest, *force); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Extracted %s (%d bytes, %d chunks checked) from snapshot %s to %s\n", file.Path, file.Size, len(file.Chunks), root, dest)
+	return 0
+}
+
+// storeTree builds a tree and ingests it into a store, which is created
+// if it does not exist yet.
+func storeTree(args []string) int {
+	flags := flag.NewFlagSet("store", flag.ExitOnError)
+	addWalkFlags(flags)
+	keyFile := addKeyFlag(flags)
+	chunks := &chunkingFlag{chunking.Fixed(script.DefaultChunkSize)}
+	flags.Var(chunks, "chunk-size", "`SIZE` of the chunks stored, or fastcdc:MIN/AVG/MAX for content-defined chunks")
+	cipher := flags.String("cipher", os.Getenv(store.CipherEnv), "`CIPHER` to encrypt a new store with, "+strings.Join(store.Ciphers, " or ")+" (default $"+store.CipherEnv+", otherwise "+store.DefaultCipher+")")
+	compress := flags.String("compress", "", "compress the chunks the store is written from now on with `METHOD`, "+strings.Join(store.Compressions, " or ")+", and keep it as the store's setting")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no store directory or bucket given")
+		return 2
+	}
+	location, tree := registry.ResolveStore(flags.Arg(0)), treeArg(flags, 1)
+	operation.Tree = tree
+	operation.Params["store"] = location
+
+	key, err := storeKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	// An existing store is checked before the tree is built, but a new one
+	// is only created once the tree could be
+	s, err := store.OpenExisting(location, key)
+	missing := errors.Is(err, store.ErrNoStore)
+	switch {
+	case missing && *cipher != "" && !slices.Contains(store.Ciphers, *cipher):
+		err = fmt.Errorf("unknown cipher %q (%s)", *cipher, strings.Join(store.Ciphers, ", "))
+	case missing:
+		err = nil
+	case err == nil && *cipher != "" && *cipher != s.Cipher():
+		err = fmt.Errorf("%s is encrypted with %s; the cipher of a store cannot change", location, s.Cipher())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	reports, err := remote.Report(tree, walkArgs(flags), remote.ExportJSON)
+	var root *manifest.Node
+	if err == nil {
+		root, err = manifest.Parse([]byte(strings.Join(reports[1], "\n")))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.RootHash = root.Hash
+
+	// The cipher is chosen when the store is created
+	if missing {
+		if *cipher == "" {
+			*cipher = store.DefaultCipher
+		}
+		s, err = store.Create(location, key, *cipher)
+	}
+	if err == nil && *compress != "" {
+		err = s.SetCompression(*compress)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	stats, err := s.Ingest(tree, root, chunks.Params, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if err := registry.Record(tree, root.Hash, location); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Stored snapshot %s of %s in %s\n", root.Hash, tree, location)
+	fmt.Printf("%d files of %d bytes in %d chunks; %d chunks of %d bytes were new\n", stats.Files, stats.Bytes, stats.Chunks, stats.NewChunks, stats.StoredBytes)
+	if s.Compression() != store.CompressNone && stats.NewChunks > 0 {
+		fmt.Printf("Compressed with %s to %d bytes (ratio %.2fx)\n", s.Compression(), stats.WrittenBytes, stats.CompressionRatio())
+	}
+	return 0
+}
+
+// restoreSnapshot writes the files of a stored snapshot, or of one of its
+// directories, below a destination directory.
+func restoreSnapshot(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	keyFile := addKeyFlag(flags)
+	force := flags.Bool("force", false, "replace files that exist already")
+	prefix := flags.String("path", "", "only restore the file or directory at `PREFIX` in the snapshot")
+	flags.Parse(args)
+	if flags.NArg() != 3 {
+		fmt.Fprintln(os.Stderr, "Error: give the store, the root hash of the snapshot and the destination directory")
+		return 2
+	}
+
+	key, err := storeKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	s, err := store.OpenExisting(registry.ResolveStore(flags.Arg(0)), key)
+	var root string
+	if err == nil {
+		root, err = s.FindSnapshot(flags.Arg(1))
+	}
+	var snap *store.Snapshot
+	if err == nil {
+		snap, err = s.LoadSnapshot(root)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	dest := flags.Arg(2)
+	operation.RootHash = root
+	operation.Params["dest"] = dest
+	if *prefix != "" {
+		operation.Params["path"] = *prefix
+	}
+
+	stats, err := s.Restore(snap, *prefix, dest, *force, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	for _, f := range stats.Failed {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", f.Err)
+	}
+	fmt.Printf("Restored %d files (%d bytes) of snapshot %s to %s\n", stats.Files, stats.Bytes, root, dest)
+	if stats.Skipped > 0 {
+		fmt.Printf("Left %d existing files alone; give -force to replace them\n", stats.Skipped)
+	}
+	if len(stats.Failed) > 0 {
+		fmt.Printf("%d files could not be restored\n", len(stats.Failed))
+		return 1
+	}
+	return 0
+}
+
+// mountSnapshot serves a stored snapshot as a read-only file system until
+// it is unmounted or the command is interrupted.
+func mountSnapshot(args []string) int {
+	flags := flag.NewFlagSet("mount", flag.ExitOnError)
+	keyFile := addKeyFlag(flags)
+	flags.Parse(args)
+	if flags.NArg() != 3 {
+		fmt.Fprintln(os.Stderr, "Error: give the store, the root hash of the snapshot and the mount point")
+		return 2
+	}
+
+	key, err := storeKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	s, err := store.OpenExisting(registry.ResolveStore(flags.Arg(0)), key)
+	var root string
+	if err == nil {
+		root, err = s.FindSnapshot(flags.Arg(1))
+	}
+	var snap *store.Snapshot
+	if err == nil {
+		snap, err = s.LoadSnapshot(root)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.RootHash = root
+	operation.Params["mountpoint"] = flags.Arg(2)
+
+	fs := fusefs.New(s, snap)
+	failed := false
+	fs.ReadError = func(p string, err error) {
+		failed = true
+		fmt.Fprintf(os.Stderr, "Error: read %s: %v\n", p, err)
+	}
+	m, err := fs.Mount(flags.Arg(2))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Mounted snapshot %s (%d files) read-only at %s\n", root, len(snap.Files), m.Dir)
+	fmt.Printf("Unmount it with umount %s, or interrupt this command\n", m.Dir)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		if err := m.Unmount(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: unmount %s: %v\n", m.Dir, err)
+		}
+	}()
+	if err := m.Serve(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Unmounted %s\n", m.Dir)
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// storedChunkMap maps the chunks of the snapshot of tree with root hash
+// root in location, or in the store tree was last stored in if location
+// is empty. It returns nil if no store is known for the tree or it holds
+// no snapshot of that root.
+func storedChunkMap(tree, root, location, keyFile string) (*duplicates.ChunkMap, error) {
+	explicit := location != ""
+	if !explicit {
+		known, err := registry.Load()
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range known {
+			if paths.Same(t.Path, tree) {
+				location = t.Store()
+			}
+		}
+		if location == "" {
+			return nil, nil
+		}
+	}
+	key, err := storeKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if key.File == nil && key.Passphrase == "" && !explicit {
+		// Only a store asked for is worth asking for its key
+		return nil, nil
+	}
+	s, err := store.OpenExisting(registry.ResolveStore(location), key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.FindSnapshot(root); err != nil {
+		if explicit {
+			return nil, err
+		}
+		return nil, nil
+	}
+	snap, err := s.LoadSnapshot(root)
+	if err != nil {
+		return nil, err
+	}
+	return duplicates.MapSnapshot(snap), nil
+}
+
+func verifySnapshot(args []string) int {
+	flags := flag.NewFlagSet("verify-snapshot", flag.ExitOnError)
+	keyFile := addKeyFlag(flags)
+	all := flags.Bool("all", false, "compare every file, also those whose size and modification time are as stored")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "Error: give the store and the root hash of the snapshot")
+		return 2
+	}
+	tree := treeArg(flags, 2)
+
+	key, err := storeKey(*keyFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	s, err := store.OpenExisting(registry.ResolveStore(flags.Arg(0)), key)
+	var root string
+	if err == nil {
+		root, err = s.FindSnapshot(flags.Arg(1))
+	}
+	var snap *store.Snapshot
+	if err == nil {
+		snap, err = s.LoadSnapshot(root)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Tree, operation.RootHash = tree, root
+
+	report, err := snap.Check(tree, *all, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	for _, p := range report.Missing {
+		fmt.Printf("missing  %s\n", p)
+	}
+	for _, p := range report.Touched {
+		fmt.Printf("touched  %s: modification time changed, content as stored\n", p)
+	}
+	for _, c := range report.Changed {
+		ranges := make([]string, len(c.Ranges))
+		for i, r := range c.Ranges {
+			ranges[i] = fmt.Sprintf("%d-%d", r.Offset, r.Offset+r.Length-1)
+		}
+		fmt.Printf("changed  %s: %d of %d chunks differ, bytes %s", c.Path, c.ChangedChunks, c.Chunks, strings.Join(ranges, ", "))
+		if c.Size != c.StoredSize {
+			fmt.Printf("; %d bytes, was %d", c.Size, c.StoredSize)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Checked %d files against snapshot %s: %d unchanged, %d touched, %d changed, %d missing\n",
+		report.Files, root, report.Unchanged, len(report.Touched), len(report.Changed), len(report.Missing))
+	sample := trends.Sample{Time: time.Now(), Operation: "verify", Files: int64(report.Files - len(report.Missing)), Bytes: -1,
+		Changed: int64(len(report.Changed) + len(report.Missing))}
+	if err := trends.Record(tree, sample); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: trends not recorded: %v\n", err)
+	}
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,131 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// backend holds the files of a store (its config, objects and snapshots)
+// under slash-separated names relative to the store's location.
+type backend interface {
+	// Read returns the content of name, or an error matching
+	// os.ErrNotExist if there is none.
+	Read(name string) ([]byte, error)
+	// Write stores data under name. Readers never see partial content.
+	Write(name string, data []byte) error
+	// Exists reports whether name is stored.
+	Exists(name string) (bool, error)
+	// List returns the names below dir.
+	List(dir string) ([]string, error)
+	// Stat returns the size of name and when it was written, or an error
+	// matching os.ErrNotExist if there is none.
+	Stat(name string) (size int64, written time.Time, err error)
+	// Delete removes name. Removing a name that is not stored is not an
+	// error.
+	Delete(name string) error
+	// Touch marks name as written now, or returns an error matching
+	// os.ErrNotExist if there is none.
+	Touch(name string) error
+}
+
+// newBackend selects the backend for a store location: a bucket for
+// s3:// and gs:// URLs, a local directory otherwise.
+func newBackend(location string) (backend, error) {
+	if strings.HasPrefix(location, "s3://") || strings.HasPrefix(location, "gs://") {
+		return newBucket(location)
+	}
+	return dirBackend(location), nil
+}
+
+// dirBackend keeps a store in a local directory.
+type dirBackend string
+
+func (d dirBackend) path(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(name))
+}
+
+func (d dirBackend) Read(name string) ([]byte, error) {
+	return os.ReadFile(d.path(name))
+}
+
+func (d dirBackend) Write(name string, data []byte) error {
+	p := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	// Write to a temporary name first so a crash never leaves a partial
+	// file under its final name.
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (d dirBackend) Exists(name string) (bool, error) {
+	_, err := os.Stat(d.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d dirBackend) List(dir string) ([]string, error) {
+	var names []string
+	root := d.path(dir)
+	err := filepath.WalkDir(root, func(p string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if p == root && errors.Is(err, os.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+			return nil
+		}
+		rel, err := filepath.Rel(string(d), p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	return names, err
+}
+
+func (d dirBackend) Stat(name string) (int64, time.Time, error) {
+	info, err := os.Stat(d.path(name))
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return info.Size(), info.ModTime(), nil
+}
+
+func (d dirBackend) Touch(name string) error {
+	now := time.Now()
+	return os.Chtimes(d.path(name), now, now)
+}
+
+func (d dirBackend) Delete(name string) error {
+	err := os.Remove(d.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
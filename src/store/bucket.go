@@ -0,0 +1,336 @@
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// EndpointEnv names the environment variable holding the endpoint of
+	// an S3-compatible service, e.g. http://localhost:9000 for MinIO.
+	// Without it s3:// stores use AWS and gs:// stores Google Cloud
+	// Storage.
+	EndpointEnv = "MTFS_S3_ENDPOINT"
+	// AccessKeyEnv, SecretKeyEnv and SessionTokenEnv name the environment
+	// variables holding the credentials requests are signed with. For
+	// Google Cloud Storage they hold an HMAC key.
+	AccessKeyEnv    = "AWS_ACCESS_KEY_ID"
+	SecretKeyEnv    = "AWS_SECRET_ACCESS_KEY"
+	SessionTokenEnv = "AWS_SESSION_TOKEN"
+	// RegionEnv names the environment variable holding the signing
+	// region, "us-east-1" for s3:// and "auto" for gs:// stores if unset.
+	RegionEnv = "AWS_REGION"
+)
+
+// bucket keeps a store in an S3-compatible bucket, below an optional
+// prefix. Requests use path-style URLs and AWS Signature Version 4, which
+// AWS, Google Cloud Storage (with HMAC keys), MinIO and Ceph all accept.
+type bucket struct {
+	endpoint  *url.URL
+	name      string
+	prefix    string // ends in "/" unless empty
+	region    string
+	accessKey string
+	secretKey string
+	token     string
+	client    *http.Client
+	now       func() time.Time
+}
+
+// newBucket configures the bucket of an s3://bucket/prefix or
+// gs://bucket/prefix location from the environment.
+func newBucket(location string) (*bucket, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("store: invalid bucket location %q", location)
+	}
+	b := &bucket{
+		name:      u.Host,
+		prefix:    strings.Trim(u.Path, "/"),
+		region:    os.Getenv(RegionEnv),
+		accessKey: os.Getenv(AccessKeyEnv),
+		secretKey: os.Getenv(SecretKeyEnv),
+		token:     os.Getenv(SessionTokenEnv),
+		client:    &http.Client{Timeout: 60 * time.Second},
+		now:       time.Now,
+	}
+	if b.prefix != "" {
+		b.prefix += "/"
+	}
+	if b.accessKey == "" || b.secretKey == "" {
+		return nil, fmt.Errorf("store: %s and %s are required for %s", AccessKeyEnv, SecretKeyEnv, location)
+	}
+
+	endpoint := os.Getenv(EndpointEnv)
+	switch {
+	case u.Scheme == "gs":
+		if b.region == "" {
+			b.region = "auto"
+		}
+		if endpoint == "" {
+			endpoint = "https://storage.googleapis.com"
+		}
+	case b.region == "":
+		b.region = "us-east-1"
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + b.region + ".amazonaws.com"
+	}
+	if b.endpoint, err = url.Parse(strings.TrimSuffix(endpoint, "/")); err != nil || b.endpoint.Host == "" {
+		return nil, fmt.Errorf("store: invalid endpoint %q in $%s", endpoint, EndpointEnv)
+	}
+	return b, nil
+}
+
+func (b *bucket) Read(name string) ([]byte, error) {
+	resp, err := b.do(http.MethodGet, b.prefix+name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := b.check(resp, name); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (b *bucket) Write(name string, data []byte) error {
+	resp, err := b.do(http.MethodPut, b.prefix+name, nil, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return b.check(resp, name)
+}
+
+func (b *bucket) Exists(name string) (bool, error) {
+	resp, err := b.do(http.MethodHead, b.prefix+name, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	return true, b.check(resp, name)
+}
+
+func (b *bucket) Stat(name string) (int64, time.Time, error) {
+	resp, err := b.do(http.MethodHead, b.prefix+name, nil, nil)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	resp.Body.Close()
+	if err := b.check(resp, name); err != nil {
+		return 0, time.Time{}, err
+	}
+	written, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("store: %s in bucket %s: no modification time", name, b.name)
+	}
+	return resp.ContentLength, written, nil
+}
+
+// Touch copies name onto itself, which S3 and Cloud Storage only allow
+// when the metadata is replaced, giving it a new modification time.
+func (b *bucket) Touch(name string) error {
+	source := "/" + b.name + "/" + b.prefix + name
+	resp, err := b.doWith(http.MethodPut, b.prefix+name, nil, nil, map[string]string{
+		"X-Amz-Copy-Source":        uriEncode(source, false),
+		"X-Amz-Metadata-Directive": "REPLACE",
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return b.check(resp, name)
+}
+
+func (b *bucket) Delete(name string) error {
+	resp, err := b.do(http.MethodDelete, b.prefix+name, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return b.check(resp, name)
+}
+
+// listResult is the part of a ListObjectsV2 response that is used.
+type listResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (b *bucket) List(dir string) ([]string, error) {
+	var names []string
+	query := url.Values{"list-type": {"2"}, "prefix": {b.prefix + dir + "/"}}
+	for {
+		resp, err := b.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result listResult
+		if err = b.check(resp, dir); err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			names = append(names, strings.TrimPrefix(c.Key, b.prefix))
+		}
+		if !result.IsTruncated {
+			return names, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// check turns an unsuccessful response into an error; a missing object
+// matches os.ErrNotExist.
+func (b *bucket) check(resp *http.Response, name string) error {
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("store: %s in bucket %s: %w", name, b.name, os.ErrNotExist)
+	case resp.StatusCode/100 != 2:
+		return fmt.Errorf("store: %s in bucket %s: %s returned %s", name, b.name, b.endpoint.Host, resp.Status)
+	}
+	return nil
+}
+
+// do sends a signed request for key, or for the bucket itself if key is
+// empty.
+func (b *bucket) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	return b.doWith(method, key, query, body, nil)
+}
+
+// doWith is do with headers added to the request, and signed.
+func (b *bucket) doWith(method, key string, query url.Values, body []byte, headers map[string]string) (*http.Response, error) {
+	u := *b.endpoint
+	u.Path += "/" + b.name
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	if b.token != "" {
+		req.Header.Set("X-Amz-Security-Token", b.token)
+	}
+	b.sign(req, body)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("store: bucket %s: %w", b.name, err)
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header covering the
+// host and every header already set.
+func (b *bucket) sign(req *http.Request, body []byte) {
+	now := b.now().UTC()
+	stamp := now.Format("20060102T150405Z")
+	payload := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := now.Format("20060102") + "/" + b.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + b.secretKey)
+	for _, part := range []string{now.Format("20060102"), b.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+b.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by name, as Signature Version 4
+// requires.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters and,
+// unless encodeSlash is set, slashes.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"MTFS/chunking"
+	"MTFS/forensic"
+)
+
+// Range is a span of bytes of a file.
+type Range struct {
+	Offset int64
+	Length int64
+}
+
+// ChangedFile is a file of a snapshot whose content in the tree is no
+// longer the stored one.
+type ChangedFile struct {
+	Path       string
+	Size       int64 // size in the tree
+	StoredSize int64
+	Chunks     int // chunks compared, the larger of the stored and current count
+	// ChangedChunks are the chunks that differ, were added or are gone,
+	// and Ranges the bytes they cover, adjacent ones merged.
+	ChangedChunks int
+	Ranges        []Range
+}
+
+// CheckReport is the outcome of checking a tree against a snapshot.
+type CheckReport struct {
+	Files int // files of the snapshot
+	// Unchanged counts the files not read, their size and modification
+	// time being as stored.
+	Unchanged int
+	// Touched are the files whose modification time changed but whose
+	// chunks all match the stored ones.
+	Touched []string
+	Changed []ChangedFile
+	// Missing are the files of the snapshot that are gone from the tree
+	// or no longer regular files.
+	Missing []string
+}
+
+// OK reports whether the tree still holds every file as stored.
+func (r *CheckReport) OK() bool {
+	return len(r.Changed) == 0 && len(r.Missing) == 0
+}
+
+// Check compares the files of snap with their copies in tree. A file
+// whose size and modification time are as stored is taken as unchanged
+// unless all is set; any other is split into chunks as the snapshot was,
+// each compared with the stored chunk hashes, so a large file that was
+// only touched, or changed in a few places, is reported with the byte
+// ranges that differ rather than as changed as a whole. Files stored
+// before modification times were recorded are always compared. Files
+// added to the tree since are not looked for. progress, if not nil, is
+// called after each file.
+func (snap *Snapshot) Check(tree string, all bool, progress func(checked, total int)) (*CheckReport, error) {
+	r := &CheckReport{Files: len(snap.Files)}
+	params, err := snap.Params()
+	if err != nil {
+		return r, err
+	}
+	splitter := chunking.NewSplitter(params)
+	for i, file := range snap.Files {
+		name := filepath.Join(tree, filepath.FromSlash(file.Path))
+		info, err := os.Lstat(name)
+		switch {
+		case errors.Is(err, os.ErrNotExist) || err == nil && !info.Mode().IsRegular():
+			r.Missing = append(r.Missing, file.Path)
+		case err != nil:
+			return r, err
+		case !all && file.ModTime != 0 && info.ModTime().UnixNano() == file.ModTime && info.Size() == file.Size:
+			r.Unchanged++
+		default:
+			changed, err := snap.compareChunks(name, file, splitter, params.CDC)
+			if err != nil {
+				return r, err
+			}
+			if changed.ChangedChunks > 0 {
+				r.Changed = append(r.Changed, changed)
+			} else {
+				r.Touched = append(r.Touched, file.Path)
+			}
+		}
+		if progress != nil {
+			progress(i+1, len(snap.Files))
+		}
+	}
+	return r, nil
+}
+
+// compareChunks splits the file at name with splitter and compares its
+// chunks with the stored chunks of file. Fixed-size chunks are compared
+// in place; content-defined ones, whose cuts move with the content, are
+// looked for among the stored ones, a changed chunk covering the bytes
+// in the file and a gone one none.
+func (snap *Snapshot) compareChunks(name string, file SnapshotFile, splitter *chunking.Splitter, cdc bool) (ChangedFile, error) {
+	c := ChangedFile{Path: file.Path, StoredSize: file.Size}
+	f, err := forensic.Open(name)
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+
+	differs := func(offset, length int64) {
+		c.ChangedChunks++
+		if last := len(c.Ranges) - 1; last >= 0 && c.Ranges[last].Offset+c.Ranges[last].Length == offset {
+			c.Ranges[last].Length += length
+			return
+		}
+		c.Ranges = append(c.Ranges, Range{offset, length})
+	}
+	stored := snap.Spans(file)
+	unseen := map[string]int{}
+	for _, hash := range file.Chunks {
+		unseen[hash]++
+	}
+	whole := sha256.New()
+	i := 0
+	err = splitter.Split(f, func(chunk []byte) error {
+		n := int64(len(chunk))
+		whole.Write(chunk)
+		hash := Hash(chunk)
+		switch {
+		case cdc && unseen[hash] > 0:
+			unseen[hash]--
+		case cdc:
+			differs(c.Size, n)
+		case i >= len(file.Chunks):
+			differs(c.Size, n)
+		case hash != file.Chunks[i]:
+			// A changed chunk covers its bytes in the file and in the
+			// stored copy
+			differs(c.Size, max(n, stored[i].Length))
+		}
+		c.Size += n
+		i++
+		return nil
+	})
+	if err != nil {
+		return c, err
+	}
+	c.Chunks = max(i, len(file.Chunks))
+	if cdc {
+		for _, left := range unseen {
+			c.ChangedChunks += left
+		}
+		// Chunks in another order are all found but still change it
+		if c.ChangedChunks == 0 && hex.EncodeToString(whole.Sum(nil)) != file.ContentHash {
+			differs(0, c.Size)
+		}
+		return c, nil
+	}
+	// Stored chunks past the end of a file that shrank
+	for ; i < len(file.Chunks); i++ {
+		differs(stored[i].Offset, stored[i].Length)
+	}
+	return c, nil
+}
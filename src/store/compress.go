@@ -0,0 +1,112 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression settings of a store.
+const (
+	CompressNone = "none"
+	CompressZstd = "zstd"
+)
+
+// Compressions lists the compression settings a store can have.
+var Compressions = []string{CompressNone, CompressZstd}
+
+// maxChunk bounds what a compressed object may expand to, so a forged
+// object cannot exhaust memory; no chunking cuts larger chunks.
+const maxChunk = 256 << 20
+
+// zstdMagic starts every zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+var (
+	codecOnce sync.Once
+	encoder   *zstd.Encoder
+	decoder   *zstd.Decoder
+	codecErr  error
+)
+
+// codecs returns the zstd encoder and decoder, which are safe to share.
+func codecs() (*zstd.Encoder, *zstd.Decoder, error) {
+	codecOnce.Do(func() {
+		if encoder, codecErr = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1)); codecErr != nil {
+			return
+		}
+		decoder, codecErr = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxChunk))
+	})
+	return encoder, decoder, codecErr
+}
+
+// Compression returns how new objects of s are compressed.
+func (s *Store) Compression() string {
+	if s.cfg.Compression == "" {
+		return CompressNone
+	}
+	return s.cfg.Compression
+}
+
+// SetCompression sets how objects written to s from now on are
+// compressed, and records it in the store. Objects stored before are
+// read as they are, so the setting can change at any time.
+func (s *Store) SetCompression(compression string) error {
+	if !slices.Contains(Compressions, compression) {
+		return fmt.Errorf("store: unknown compression %q (%s)", compression, strings.Join(Compressions, ", "))
+	}
+	if compression == s.Compression() {
+		return nil
+	}
+	cfg := s.cfg
+	cfg.Compression = compression
+	if compression == CompressNone {
+		cfg.Compression = ""
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := s.files.Write(configFile, data); err != nil {
+		return err
+	}
+	s.cfg = cfg
+	return nil
+}
+
+// pack returns data as an object of s holds it: compressed if s
+// compresses and that makes it smaller, as it is otherwise.
+func (s *Store) pack(data []byte) ([]byte, error) {
+	if s.Compression() != CompressZstd {
+		return data, nil
+	}
+	enc, _, err := codecs()
+	if err != nil {
+		return nil, err
+	}
+	packed := enc.EncodeAll(data, make([]byte, 0, len(data)))
+	if len(packed) >= len(data) {
+		return data, nil
+	}
+	return packed, nil
+}
+
+// unpack returns the chunk named hash an object holds as data. Objects
+// are stored compressed only when their plaintext does not already hash
+// to their name, so a chunk that happens to be a zstd frame is read as it
+// is.
+func unpack(hash string, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, zstdMagic) || Hash(data) == hash {
+		return data, nil
+	}
+	_, dec, err := codecs()
+	if err != nil {
+		return nil, err
+	}
+	return dec.DecodeAll(data, nil)
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// DefaultGrace is how long GC leaves unreferenced objects alone after
+// they were written, by default.
+const DefaultGrace = time.Hour
+
+// GCReport is the outcome of a garbage collection.
+type GCReport struct {
+	DryRun     bool
+	Snapshots  int // live snapshots the references were taken from
+	Objects    int // objects stored when the collection started
+	Referenced int // objects a live snapshot refers to
+	// Deleted are the unreferenced objects removed, or that would be in
+	// a dry run.
+	Deleted []string
+	// Reclaimed is the space the deleted objects took in the store.
+	Reclaimed int64
+	// Recent counts the unreferenced objects kept because they were
+	// written within the grace period.
+	Recent int
+}
+
+// DeleteSnapshot removes the snapshot of root from the store. Its chunks
+// stay until GC finds no other snapshot refers to them.
+func (s *Store) DeleteSnapshot(root string) error {
+	if ok, err := s.files.Exists(snapshotName(root)); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("store: no snapshot of root %s", root)
+	}
+	return s.files.Delete(snapshotName(root))
+}
+
+// GC deletes the objects no snapshot refers to, such as the chunks only
+// deleted snapshots held. Objects written within grace are kept, as an
+// ingest running meanwhile writes its chunks before its snapshot; the
+// stored chunks it reuses count as written when it found them. A
+// snapshot that cannot be read stops the collection before anything is
+// deleted, as the chunks it refers to are unknown. With dryRun set
+// nothing is deleted, and the report tells what would be. progress, if
+// not nil, is called after each unreferenced object.
+func (s *Store) GC(grace time.Duration, dryRun bool, progress func(done, total int)) (*GCReport, error) {
+	r := &GCReport{DryRun: dryRun}
+	// The objects are listed first, so those of snapshots saved since
+	// are recent rather than unreferenced
+	objects, err := s.Objects()
+	if err != nil {
+		return nil, err
+	}
+	r.Objects = len(objects)
+	started := time.Now()
+
+	roots, err := s.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(roots)
+	referenced := map[string]bool{}
+	for _, root := range roots {
+		snap, err := s.LoadSnapshot(root)
+		if err != nil {
+			return nil, fmt.Errorf("%w; nothing was deleted", err)
+		}
+		r.Snapshots++
+		for _, file := range snap.Files {
+			for _, hash := range file.Chunks {
+				referenced[hash] = true
+			}
+		}
+	}
+
+	var unreferenced []string
+	for _, hash := range objects {
+		if referenced[hash] {
+			r.Referenced++
+		} else {
+			unreferenced = append(unreferenced, hash)
+		}
+	}
+	for i, hash := range unreferenced {
+		size, written, err := s.files.Stat(objectName(hash))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			// Deleted meanwhile
+		case err != nil:
+			return r, err
+		case started.Sub(written) < grace:
+			r.Recent++
+		default:
+			if !dryRun {
+				if err := s.files.Delete(objectName(hash)); err != nil {
+					return r, err
+				}
+				s.mu.Lock()
+				delete(s.known, hash)
+				s.mu.Unlock()
+			}
+			r.Deleted = append(r.Deleted, hash)
+			r.Reclaimed += size
+		}
+		if progress != nil {
+			progress(i+1, len(unreferenced))
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"MTFS/forensic"
+)
+
+// RepairReport is the outcome of a repair.
+type RepairReport struct {
+	// Repaired maps the objects written again to where their content was
+	// found: the location of a store or a file of a tree.
+	Repaired map[string]string
+	// Unrepaired are the damaged objects no copy was found of.
+	Unrepaired []string
+}
+
+// chunkAt is where a chunk sits in a file of a snapshot.
+type chunkAt struct {
+	path   string
+	offset int64
+	size   int
+}
+
+// Repair writes the damaged objects again, corrupt ones over their bad
+// copy, from surviving copies of their content: the objects of the same
+// name in other stores, or the chunks of the same hash still in the files
+// of trees the snapshots were stored from. Every copy is checked against
+// the object's hash before it is written.
+func (s *Store) Repair(damaged []string, others []*Store, trees []string) (*RepairReport, error) {
+	r := &RepairReport{Repaired: map[string]string{}}
+	if len(damaged) == 0 {
+		return r, nil
+	}
+	wanted := map[string]bool{}
+	for _, hash := range damaged {
+		wanted[hash] = true
+	}
+
+	// Where the damaged chunks were cut from, to look for them in trees
+	places := map[string][]chunkAt{}
+	if len(trees) > 0 {
+		roots, err := s.Snapshots()
+		if err != nil {
+			return nil, err
+		}
+		for _, root := range roots {
+			snap, err := s.LoadSnapshot(root)
+			if err != nil {
+				continue
+			}
+			for _, file := range snap.Files {
+				spans := snap.Spans(file)
+				for i, hash := range file.Chunks {
+					if wanted[hash] {
+						places[hash] = append(places[hash], chunkAt{file.Path, spans[i].Offset, int(spans[i].Length)})
+					}
+				}
+			}
+		}
+	}
+
+	sort.Strings(damaged)
+	for _, hash := range damaged {
+		data, from := findCopy(hash, others, trees, places[hash])
+		if data == nil {
+			r.Unrepaired = append(r.Unrepaired, hash)
+			continue
+		}
+		if _, err := s.write(hash, data); err != nil {
+			return r, err
+		}
+		r.Repaired[hash] = from
+	}
+	return r, nil
+}
+
+// findCopy returns the content of the object named hash from the first
+// store or tree holding it intact, and where it was found.
+func findCopy(hash string, others []*Store, trees []string, places []chunkAt) ([]byte, string) {
+	for _, o := range others {
+		if data, err := o.Get(hash); err == nil {
+			return data, o.Location
+		}
+	}
+	for _, tree := range trees {
+		for _, at := range places {
+			name := filepath.Join(tree, filepath.FromSlash(at.path))
+			if data, err := readChunkAt(name, at); err == nil && Hash(data) == hash {
+				return data, name
+			}
+		}
+	}
+	return nil, ""
+}
+
+func readChunkAt(name string, at chunkAt) ([]byte, error) {
+	f, err := forensic.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, at.size)
+	n, err := io.ReadFull(io.NewSectionReader(f, at.offset, int64(at.size)), buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RestoreStats summarises a restore.
+type RestoreStats struct {
+	Files   int   // files restored
+	Bytes   int64 // bytes they hold
+	Skipped int   // files left alone because they exist already
+	Failed  []RestoreError
+}
+
+// RestoreError is a file of a snapshot that could not be restored.
+type RestoreError struct {
+	Path string
+	Err  error
+}
+
+// Restore extracts the files of snap at or below prefix, a slash-separated
+// path relative to the tree, "" or "." for all of them, into dest with
+// their paths below it. Each file is checked as Extract checks it and only
+// appears once it checked out, with the modification time it was stored
+// with. Existing files are only replaced if replace is set. A file that
+// fails is recorded in the stats and the others are still restored.
+// progress, if not nil, is called after each file.
+func (s *Store) Restore(snap *Snapshot, prefix, dest string, replace bool, progress func(RestoreStats)) (RestoreStats, error) {
+	var stats RestoreStats
+	prefix = path.Clean(strings.TrimPrefix(filepath.ToSlash(prefix), "/"))
+	var files []SnapshotFile
+	for _, f := range snap.Files {
+		if prefix == "." || f.Path == prefix || strings.HasPrefix(f.Path, prefix+"/") {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return stats, fmt.Errorf("store: snapshot %s has no file at or below %s", snap.Root, prefix)
+	}
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return stats, err
+	}
+
+	for _, f := range files {
+		if !filepath.IsLocal(filepath.FromSlash(f.Path)) {
+			// Snapshots only record paths inside their tree
+			stats.Failed = append(stats.Failed, RestoreError{f.Path, fmt.Errorf("%s leaves the destination", f.Path)})
+			continue
+		}
+		target := filepath.Join(dest, filepath.FromSlash(f.Path))
+		if _, err := os.Lstat(target); err == nil && !replace {
+			stats.Skipped++
+		} else if err := s.restoreFile(f, target, replace); err != nil {
+			stats.Failed = append(stats.Failed, RestoreError{f.Path, err})
+		} else {
+			stats.Files++
+			stats.Bytes += f.Size
+		}
+		if progress != nil {
+			progress(stats)
+		}
+	}
+	return stats, nil
+}
+
+func (s *Store) restoreFile(f SnapshotFile, target string, replace bool) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if err := s.ExtractTo(f, target, replace); err != nil {
+		return err
+	}
+	if f.ModTime != 0 {
+		mtime := time.Unix(0, f.ModTime)
+		return os.Chtimes(target, mtime, mtime)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package store
+
+import "sort"
+
+// ScrubReport is the outcome of a scrub.
+type ScrubReport struct {
+	Objects int   // objects read back
+	Bytes   int64 // plaintext bytes of the intact ones
+	// Corrupt maps the objects that failed authentication or no longer
+	// match their name to the error reading them.
+	Corrupt map[string]string
+	// Missing are the objects a snapshot refers to that are not stored.
+	Missing   []string
+	Snapshots int
+	// BadSnapshots maps the snapshots that could not be read to the
+	// error reading them; the files they describe are unknown.
+	BadSnapshots map[string]string
+	Affected     []Damage
+	// Unreferenced counts the objects no snapshot refers to.
+	Unreferenced int
+}
+
+// Damage is a file of a snapshot some chunks of which are corrupt or
+// missing.
+type Damage struct {
+	Root    string
+	Path    string
+	Chunks  int // chunks of the file
+	Corrupt int
+	Missing int
+}
+
+// OK reports whether the scrub found nothing wrong.
+func (r *ScrubReport) OK() bool {
+	return len(r.Corrupt) == 0 && len(r.Missing) == 0 && len(r.BadSnapshots) == 0
+}
+
+// Scrub reads back every object of the store, decrypting it and checking
+// it against the hash it is named by, then goes through every snapshot
+// to find the files that corrupt or missing objects affect. progress, if
+// not nil, is called after each object.
+func (s *Store) Scrub(progress func(checked, total int)) (*ScrubReport, error) {
+	objects, err := s.Objects()
+	if err != nil {
+		return nil, err
+	}
+	r := &ScrubReport{Corrupt: map[string]string{}, BadSnapshots: map[string]string{}}
+	stored := make(map[string]bool, len(objects))
+	for i, hash := range objects {
+		stored[hash] = true
+		data, err := s.Get(hash)
+		if err != nil {
+			r.Corrupt[hash] = err.Error()
+		} else {
+			r.Bytes += int64(len(data))
+		}
+		r.Objects++
+		if progress != nil {
+			progress(i+1, len(objects))
+		}
+	}
+
+	roots, err := s.Snapshots()
+	if err != nil {
+		return r, err
+	}
+	sort.Strings(roots)
+	referenced := map[string]bool{}
+	missing := map[string]bool{}
+	for _, root := range roots {
+		r.Snapshots++
+		snap, err := s.LoadSnapshot(root)
+		if err != nil {
+			r.BadSnapshots[root] = err.Error()
+			continue
+		}
+		for _, file := range snap.Files {
+			d := Damage{Root: root, Path: file.Path, Chunks: len(file.Chunks)}
+			for _, hash := range file.Chunks {
+				referenced[hash] = true
+				switch {
+				case !stored[hash]:
+					d.Missing++
+					missing[hash] = true
+				case r.Corrupt[hash] != "":
+					d.Corrupt++
+				}
+			}
+			if d.Corrupt > 0 || d.Missing > 0 {
+				r.Affected = append(r.Affected, d)
+			}
+		}
+	}
+	for hash := range missing {
+		r.Missing = append(r.Missing, hash)
+	}
+	sort.Strings(r.Missing)
+	for hash := range stored {
+		if !referenced[hash] {
+			r.Unreferenced++
+		}
+	}
+	return r, nil
+}
+
+// Damaged returns the corrupt and missing objects, for Repair.
+func (r *ScrubReport) Damaged() []string {
+	damaged := append([]string(nil), r.Missing...)
+	for hash := range r.Corrupt {
+		damaged = append(damaged, hash)
+	}
+	sort.Strings(damaged)
+	return damaged
+}
@@ -0,0 +1,341 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"MTFS/chunking"
+	"MTFS/forensic"
+	"MTFS/manifest"
+)
+
+const snapshotsDir = "snapshots"
+
+// Snapshot records how to reassemble every file of a built tree from
+// stored chunks. It is kept encrypted, so file names do not leak either.
+type Snapshot struct {
+	Root      string `json:"root"`
+	ChunkSize int    `json:"chunk_size"` // the average of content-defined chunks
+	// Chunking is set for content-defined chunks, as chunking.Parse reads
+	// it; other snapshots are cut every ChunkSize bytes.
+	Chunking string         `json:"chunking,omitempty"`
+	Files    []SnapshotFile `json:"files"`
+	// Created and Added are missing from snapshots stored before they
+	// were recorded.
+	Created string       `json:"created,omitempty"`
+	Added   *IngestStats `json:"added,omitempty"`
+}
+
+// SnapshotFile lists the chunks of one file in order.
+type SnapshotFile struct {
+	Path        string   `json:"path"`
+	Size        int64    `json:"size"`
+	ContentHash string   `json:"content_hash"`
+	Chunks      []string `json:"chunks"`
+	// ChunkSizes are the lengths of the chunks of content-defined
+	// snapshots.
+	ChunkSizes []int `json:"chunk_sizes,omitempty"`
+	// ModTime is the modification time of the file when it was stored, in
+	// nanoseconds since the epoch; zero in snapshots stored before it was
+	// recorded.
+	ModTime int64 `json:"mtime,omitempty"`
+}
+
+// IngestStats summarises an ingest. Chunks already in the store, from
+// earlier snapshots or earlier in this one, only count in Chunks and
+// Bytes; NewChunks and StoredBytes are what the snapshot added, and
+// WrittenBytes what those took once compressed.
+type IngestStats struct {
+	Files        int   `json:"files"`
+	Chunks       int   `json:"chunks"`
+	NewChunks    int   `json:"new_chunks"`
+	Bytes        int64 `json:"bytes"`
+	StoredBytes  int64 `json:"stored_bytes"`
+	WrittenBytes int64 `json:"written_bytes,omitempty"` // not recorded by older versions
+}
+
+// CompressionRatio returns how many bytes of new chunks each byte
+// written stands for, 1 if nothing was written or it was not recorded.
+func (st IngestStats) CompressionRatio() float64 {
+	if st.WrittenBytes == 0 {
+		return 1
+	}
+	return float64(st.StoredBytes) / float64(st.WrittenBytes)
+}
+
+// Ingest splits every file of the tree described by root into chunks as
+// params says, stores them, and saves a snapshot under the root hash.
+// Files that changed since the build are reported as errors rather than
+// stored under stale hashes. progress, if not nil, is called after each
+// file.
+func (s *Store) Ingest(tree string, root *manifest.Node, params chunking.Params, progress func(IngestStats)) (IngestStats, error) {
+	var stats IngestStats
+	if err := params.Check(); err != nil {
+		return stats, fmt.Errorf("store: %w", err)
+	}
+
+	snap := Snapshot{Root: root.Hash, ChunkSize: params.Size}
+	if params.CDC {
+		snap.ChunkSize, snap.Chunking = params.Avg, params.String()
+	}
+	splitter := chunking.NewSplitter(params)
+	err := root.Walk(func(p string, node *manifest.Node) error {
+		if !node.IsFile() {
+			return nil
+		}
+		check := node.NewContentCheck()
+		file, err := s.ingestFile(filepath.Join(tree, filepath.FromSlash(p)), splitter, params.CDC, check, &stats)
+		if err != nil {
+			return err
+		}
+		if !check.Matches(node.ContentHash) {
+			return fmt.Errorf("store: %s changed since the tree was built", p)
+		}
+		file.Path = p
+		snap.Files = append(snap.Files, file)
+		stats.Files++
+		if progress != nil {
+			progress(stats)
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	// A tree state stored before keeps the record of what it added then
+	snap.Created, snap.Added = time.Now().UTC().Format(time.RFC3339Nano), &stats
+	if old, err := s.LoadSnapshot(root.Hash); err == nil && old.Added != nil {
+		snap.Created, snap.Added = old.Created, old.Added
+	}
+	return stats, s.SaveSnapshot(&snap)
+}
+
+func (s *Store) ingestFile(path string, splitter *chunking.Splitter, sized bool, check *manifest.ContentCheck, stats *IngestStats) (SnapshotFile, error) {
+	var file SnapshotFile
+	f, err := forensic.Open(path)
+	if err != nil {
+		return file, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return file, err
+	}
+	file.ModTime = info.ModTime().UnixNano()
+
+	whole := sha256.New()
+	err = splitter.Split(f, func(chunk []byte) error {
+		n := len(chunk)
+		whole.Write(chunk)
+		check.Write(chunk)
+		hash, written, err := s.Put(chunk)
+		if err != nil {
+			return err
+		}
+		file.Chunks = append(file.Chunks, hash)
+		if sized {
+			file.ChunkSizes = append(file.ChunkSizes, n)
+		}
+		file.Size += int64(n)
+		stats.Chunks++
+		stats.Bytes += int64(n)
+		if written > 0 {
+			stats.NewChunks++
+			stats.StoredBytes += int64(n)
+			stats.WrittenBytes += int64(written)
+		}
+		return nil
+	})
+	if err != nil {
+		return file, err
+	}
+	file.ContentHash = hex.EncodeToString(whole.Sum(nil))
+	return file, nil
+}
+
+// Params returns how the files of snap were cut into chunks.
+func (snap *Snapshot) Params() (chunking.Params, error) {
+	if snap.Chunking == "" {
+		return chunking.Fixed(snap.ChunkSize), nil
+	}
+	return chunking.Parse(snap.Chunking)
+}
+
+// Span is where a chunk is in a file.
+type Span struct {
+	Offset, Length int64
+}
+
+// Spans returns where each chunk of file is in it.
+func (snap *Snapshot) Spans(file SnapshotFile) []Span {
+	spans := make([]Span, len(file.Chunks))
+	var offset int64
+	for i := range spans {
+		length := min(int64(snap.ChunkSize), file.Size-offset)
+		if i < len(file.ChunkSizes) {
+			length = int64(file.ChunkSizes[i])
+		}
+		spans[i] = Span{offset, length}
+		offset += length
+	}
+	return spans
+}
+
+func snapshotName(root string) string {
+	return snapshotsDir + "/" + root
+}
+
+// SaveSnapshot encrypts and stores snap under its root hash.
+func (s *Store) SaveSnapshot(snap *Snapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	sealed, err := s.seal(data, []byte("snapshot "+snap.Root))
+	if err != nil {
+		return err
+	}
+	return s.files.Write(snapshotName(snap.Root), sealed)
+}
+
+// LoadSnapshot reads the snapshot stored for a root hash.
+func (s *Store) LoadSnapshot(root string) (*Snapshot, error) {
+	sealed, err := s.files.Read(snapshotName(path.Base(root)))
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.open(sealed, []byte("snapshot "+root))
+	if err != nil {
+		return nil, fmt.Errorf("store: snapshot %s fails authentication", root)
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("store: parse snapshot %s: %w", root, err)
+	}
+	return &snap, nil
+}
+
+// Snapshots returns the root hashes that have a stored snapshot.
+func (s *Store) Snapshots() ([]string, error) {
+	names, err := s.files.List(snapshotsDir)
+	if err != nil {
+		return nil, err
+	}
+	roots := make([]string, 0, len(names))
+	for _, name := range names {
+		roots = append(roots, path.Base(name))
+	}
+	return roots, nil
+}
+
+// FindSnapshot returns the root hash of the snapshot whose root starts
+// with prefix, which must name one snapshot only.
+func (s *Store) FindSnapshot(prefix string) (string, error) {
+	roots, err := s.Snapshots()
+	if err != nil {
+		return "", err
+	}
+	var found []string
+	for _, root := range roots {
+		if root == prefix {
+			return root, nil
+		}
+		if prefix != "" && strings.HasPrefix(root, prefix) {
+			found = append(found, root)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("store: no snapshot of root %s", prefix)
+	case 1:
+		return found[0], nil
+	}
+	return "", fmt.Errorf("store: %d snapshots have roots starting with %s; give more of the root hash", len(found), prefix)
+}
+
+// File returns the file of snap at p, a slash-separated path relative to
+// the tree.
+func (snap *Snapshot) File(p string) (SnapshotFile, bool) {
+	p = path.Clean(strings.TrimPrefix(filepath.ToSlash(p), "/"))
+	for _, f := range snap.Files {
+		if f.Path == p {
+			return f, true
+		}
+	}
+	return SnapshotFile{}, false
+}
+
+// Extract reassembles file from its stored chunks into w. Every chunk is
+// checked against its hash before it is written, and the whole file is
+// hashed as it is written, so there is no second pass over the output.
+// The first chunk that fails stops the restore, and the error names it by
+// index, byte offset and hash. w only holds the file as it was stored if
+// Extract succeeds.
+func (s *Store) Extract(file SnapshotFile, w io.Writer) error {
+	whole := sha256.New()
+	out := io.MultiWriter(w, whole)
+	var offset int64
+	for i, hash := range file.Chunks {
+		data, err := s.Get(hash)
+		if err != nil {
+			// Get's errors name the object
+			return fmt.Errorf("%s: chunk %d of %d at byte %d: %w", file.Path, i+1, len(file.Chunks), offset, err)
+		}
+		if offset+int64(len(data)) > file.Size {
+			return fmt.Errorf("store: %s: chunk %d of %d at byte %d (%s) runs past the file's %d bytes", file.Path, i+1, len(file.Chunks), offset, hash, file.Size)
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+		offset += int64(len(data))
+	}
+	if offset != file.Size {
+		return fmt.Errorf("store: %s reassembles to %d bytes, not %d", file.Path, offset, file.Size)
+	}
+	if got := hex.EncodeToString(whole.Sum(nil)); got != file.ContentHash {
+		return fmt.Errorf("store: %s reassembles to content hash %s, not %s", file.Path, got, file.ContentHash)
+	}
+	return nil
+}
+
+// ExtractTo extracts file to dest, which is only created once the file
+// was reassembled and checked, and only replaced if replace is set.
+func (s *Store) ExtractTo(file SnapshotFile, dest string, replace bool) error {
+	if _, err := os.Lstat(dest); err == nil && !replace {
+		return fmt.Errorf("%s exists already", dest)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := s.Extract(file, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	if !replace {
+		// Linking fails if dest appeared in the meantime
+		if err := os.Link(tmp.Name(), dest); errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s exists already", dest)
+		} else if err != nil {
+			return err
+		}
+		return nil
+	}
+	return os.Rename(tmp.Name(), dest)
+}
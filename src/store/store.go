@@ -0,0 +1,393 @@
+// Package store keeps file chunks in a content-addressed object store
+// encrypted at rest with AES-256-GCM or XChaCha20-Poly1305. Objects are
+// named by the SHA-256 of their plaintext, the same chunk hashes the
+// backend computes, so the store can live on untrusted storage, a local
+// directory or an S3-compatible bucket, while every chunk read back is
+// still checked against its hash.
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// DirEnv names the environment variable holding the default store
+	// location, a directory or an s3:// or gs:// bucket URL.
+	DirEnv = "MTFS_STORE"
+	// KeyFileEnv names the environment variable pointing at a key file.
+	KeyFileEnv = "MTFS_STORE_KEY"
+	// PassphraseEnv names the environment variable holding a passphrase,
+	// used by headless commands when no key file is given.
+	PassphraseEnv = "MTFS_STORE_PASSPHRASE"
+	// CipherEnv names the environment variable holding the cipher new
+	// stores are encrypted with, DefaultCipher if it is unset.
+	CipherEnv = "MTFS_STORE_CIPHER"
+
+	configFile = "config"
+	objectsDir = "objects"
+
+	kdfPBKDF2     = "pbkdf2-sha256"
+	kdfKeyFile    = "hkdf-sha256"
+	pbkdf2Rounds  = 600000
+	minKeyFileLen = 32
+	keyCheck      = "mtfs-store key check"
+)
+
+// Ciphers a store can be encrypted with.
+const (
+	CipherAESGCM  = "aes-256-gcm"
+	CipherXChaCha = "xchacha20-poly1305"
+	DefaultCipher = CipherAESGCM
+)
+
+// Ciphers lists the ciphers a store can be created with.
+var Ciphers = []string{CipherAESGCM, CipherXChaCha}
+
+var (
+	// ErrWrongKey is returned when a store is opened with the wrong key.
+	ErrWrongKey = errors.New("store: wrong key or passphrase")
+	// ErrNoStore is returned when opening a store that does not exist.
+	ErrNoStore = errors.New("store: there is no store")
+)
+
+// Key is the secret a store is opened with: either a passphrase or the
+// contents of a key file.
+type Key struct {
+	Passphrase string
+	File       []byte
+}
+
+// KeyFromFile reads a key file. It must hold at least 32 bytes, ideally
+// random ones.
+func KeyFromFile(path string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+	if len(data) < minKeyFileLen {
+		return Key{}, fmt.Errorf("store: key file %s is shorter than %d bytes", path, minKeyFileLen)
+	}
+	return Key{File: data}, nil
+}
+
+// config is stored unencrypted next to the objects and describes how the
+// key is derived.
+type config struct {
+	Version    int    `json:"version"`
+	Cipher     string `json:"cipher"`
+	KDF        string `json:"kdf"`
+	Salt       []byte `json:"salt"`
+	Iterations int    `json:"iterations,omitempty"`
+	Check      []byte `json:"check"`
+	// Compression is how new objects are compressed, "" for not at all
+	Compression string `json:"compression,omitempty"`
+}
+
+// Store is an opened object store.
+type Store struct {
+	// Location is the directory or bucket URL the store was opened at.
+	Location string
+	files    backend
+	aead     cipher.AEAD
+	cfg      config
+
+	// known holds the objects found in or written to the store since it
+	// was opened, so a chunk shared by many files or snapshots costs one
+	// existence check, a round trip for buckets. Found objects are
+	// touched by that check, so GC takes them for recently written.
+	mu    sync.Mutex
+	known map[string]bool
+}
+
+// Open opens the store at location, a directory or an s3://bucket/prefix
+// or gs://bucket/prefix URL, creating it for key with the cipher CipherEnv
+// names if it does not exist.
+func Open(location string, key Key) (*Store, error) {
+	cipher := os.Getenv(CipherEnv)
+	if cipher == "" {
+		cipher = DefaultCipher
+	} else if !slices.Contains(Ciphers, cipher) {
+		return nil, fmt.Errorf("store: unknown cipher %q in $%s (%s)", cipher, CipherEnv, strings.Join(Ciphers, ", "))
+	}
+	return openStore(location, key, cipher)
+}
+
+// OpenExisting opens the store at location like Open, but fails with
+// ErrNoStore if there is none rather than creating it, for reading from a
+// store.
+func OpenExisting(location string, key Key) (*Store, error) {
+	return openStore(location, key, "")
+}
+
+// Create creates a store at location for key, encrypted with cipher, one
+// of Ciphers. It fails if there is a store at location already.
+func Create(location string, key Key, cipher string) (*Store, error) {
+	if !slices.Contains(Ciphers, cipher) {
+		return nil, fmt.Errorf("store: unknown cipher %q (%s)", cipher, strings.Join(Ciphers, ", "))
+	}
+	files, err := newBackend(location)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := files.Read(configFile); !errors.Is(err, os.ErrNotExist) {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("store: there is a store at %s already", location)
+	}
+	return newStore(location, files, key, cipher)
+}
+
+// openStore opens the store at location, creating it encrypted with
+// cipher if there is none, unless cipher is "".
+func openStore(location string, key Key, cipher string) (*Store, error) {
+	files, err := newBackend(location)
+	if err != nil {
+		return nil, err
+	}
+	data, err := files.Read(configFile)
+	if errors.Is(err, os.ErrNotExist) {
+		if cipher == "" {
+			return nil, fmt.Errorf("%w at %s", ErrNoStore, location)
+		}
+		return newStore(location, files, key, cipher)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("store: parse config: %w", err)
+	}
+	if cfg.Version != 1 || !slices.Contains(Ciphers, cfg.Cipher) {
+		return nil, fmt.Errorf("store: unsupported store version %d (%s)", cfg.Version, cfg.Cipher)
+	}
+	aead, err := deriveAEAD(cfg, key)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Compression != "" && cfg.Compression != CompressZstd {
+		return nil, fmt.Errorf("store: unsupported compression %q", cfg.Compression)
+	}
+	s := &Store{Location: location, files: files, aead: aead, cfg: cfg, known: map[string]bool{}}
+	// open decrypts in place, and the check is written again with the
+	// setting of the store
+	if _, err := s.open(bytes.Clone(cfg.Check), []byte(keyCheck)); err != nil {
+		return nil, ErrWrongKey
+	}
+	return s, nil
+}
+
+func newStore(location string, files backend, key Key, cipher string) (*Store, error) {
+	cfg := config{Version: 1, Cipher: cipher, Salt: make([]byte, 16)}
+	if _, err := rand.Read(cfg.Salt); err != nil {
+		return nil, err
+	}
+	if key.File != nil {
+		cfg.KDF = kdfKeyFile
+	} else {
+		cfg.KDF, cfg.Iterations = kdfPBKDF2, pbkdf2Rounds
+	}
+
+	aead, err := deriveAEAD(cfg, key)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{Location: location, files: files, aead: aead, known: map[string]bool{}}
+	if cfg.Check, err = s.seal([]byte(keyCheck), []byte(keyCheck)); err != nil {
+		return nil, err
+	}
+	s.cfg = cfg
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := files.Write(configFile, data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func deriveAEAD(cfg config, key Key) (cipher.AEAD, error) {
+	var secret []byte
+	var err error
+	switch cfg.KDF {
+	case kdfPBKDF2:
+		if key.Passphrase == "" {
+			return nil, errors.New("store: a passphrase is required to open this store")
+		}
+		secret, err = pbkdf2.Key(sha256.New, key.Passphrase, cfg.Salt, cfg.Iterations, 32)
+	case kdfKeyFile:
+		if key.File == nil {
+			return nil, errors.New("store: a key file is required to open this store")
+		}
+		secret, err = hkdf.Key(sha256.New, key.File, cfg.Salt, "mtfs store", 32)
+	default:
+		return nil, fmt.Errorf("store: unknown key derivation %q", cfg.KDF)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Cipher == CipherXChaCha {
+		return chacha20poly1305.NewX(secret)
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Cipher returns the cipher s is encrypted with.
+func (s *Store) Cipher() string {
+	return s.cfg.Cipher
+}
+
+// seal encrypts plaintext as nonce || ciphertext. additional binds the
+// ciphertext to its name so objects cannot be swapped on disk.
+func (s *Store) seal(plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize(), s.aead.NonceSize()+len(plaintext)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func (s *Store) open(sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < s.aead.NonceSize() {
+		return nil, errors.New("store: object is truncated")
+	}
+	// Decrypt in place: sealed is not used again
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	return s.aead.Open(ciphertext[:0], nonce, ciphertext, additional)
+}
+
+// Hash returns the name data is stored under.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func objectName(hash string) string {
+	return objectsDir + "/" + hash[:2] + "/" + hash
+}
+
+func (s *Store) exists(hash string) (bool, error) {
+	s.mu.Lock()
+	known := s.known[hash]
+	s.mu.Unlock()
+	if known {
+		return true, nil
+	}
+	// Touched, the object is within the grace period of a GC running
+	// meanwhile, which would otherwise delete a chunk whose snapshots are
+	// gone before the snapshot about to reuse it is saved
+	err := s.files.Touch(objectName(hash))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	s.remember(hash)
+	return true, nil
+}
+
+func (s *Store) remember(hash string) {
+	s.mu.Lock()
+	s.known[hash] = true
+	s.mu.Unlock()
+}
+
+// Put stores data and returns its hash. Data that is already stored, by
+// this snapshot or an earlier one, is not written again; written is the
+// size of the new object once compressed, before encryption, and 0 if
+// none was written; chunks are never empty.
+func (s *Store) Put(data []byte) (hash string, written int, err error) {
+	hash = Hash(data)
+	if ok, err := s.exists(hash); err != nil || ok {
+		return hash, 0, err
+	}
+	n, err := s.write(hash, data)
+	if err != nil {
+		return "", 0, err
+	}
+	return hash, n, nil
+}
+
+// write stores data as the object named hash, compressed as s says, and
+// returns the size it was stored at before encryption.
+func (s *Store) write(hash string, data []byte) (int, error) {
+	packed, err := s.pack(data)
+	if err != nil {
+		return 0, err
+	}
+	sealed, err := s.seal(packed, []byte(hash))
+	if err != nil {
+		return 0, err
+	}
+	if err := s.files.Write(objectName(hash), sealed); err != nil {
+		return 0, err
+	}
+	s.remember(hash)
+	return len(packed), nil
+}
+
+// Get reads and decrypts the object named hash and checks that its
+// plaintext still hashes to that name.
+func (s *Store) Get(hash string) ([]byte, error) {
+	if len(hash) != sha256.Size*2 {
+		return nil, fmt.Errorf("store: invalid object name %q", hash)
+	}
+	sealed, err := s.files.Read(objectName(hash))
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.open(sealed, []byte(hash))
+	if err != nil {
+		return nil, fmt.Errorf("store: object %s fails authentication", hash)
+	}
+	if data, err = unpack(hash, data); err != nil {
+		return nil, fmt.Errorf("store: object %s does not decompress: %w", hash, err)
+	}
+	if Hash(data) != hash {
+		return nil, fmt.Errorf("store: object %s does not match its hash", hash)
+	}
+	return data, nil
+}
+
+// Objects returns the names of all stored objects.
+func (s *Store) Objects() ([]string, error) {
+	stored, err := s.files.List(objectsDir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(stored))
+	for _, p := range stored {
+		name := path.Base(p)
+		if len(name) == sha256.Size*2 {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,234 @@
+package store_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"MTFS/chunking"
+	"MTFS/manifest"
+	"MTFS/pkg/merkle"
+	"MTFS/store"
+)
+
+var files = map[string]string{
+	"a":          "hi\n",
+	"empty":      "",
+	"sub/text":   strings.Repeat("compressible line\n", 5000),
+	"sub/random": string(noise(300 * 1024)),
+}
+
+// noise returns n bytes of xorshift noise, which do not compress.
+func noise(n int) []byte {
+	out := make([]byte, n)
+	x := uint64(1)
+	for i := range out {
+		x ^= x << 13
+		x ^= x >> 7
+		x ^= x << 17
+		out[i] = byte(x >> 56)
+	}
+	return out
+}
+
+// buildTree writes files to a new tree, builds it with the Go engine and
+// returns the tree and its export.
+func buildTree(t *testing.T) (string, *manifest.Node) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree, err := merkle.NewBuilder().Build(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := manifest.Parse([]byte(tree.JSON()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, root
+}
+
+// A snapshot stored with every cipher and compression, in fixed-size and
+// content-defined chunks, restores to the files it was taken of.
+func TestRoundTrip(t *testing.T) {
+	tree, root := buildTree(t)
+	keys := map[string]store.Key{
+		"passphrase": {Passphrase: "pw"},
+		"key file":   {File: noise(32)},
+	}
+	cdc, err := chunking.FastCDC(1024, 4096, 16384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cipher := range store.Ciphers {
+		for _, compression := range store.Compressions {
+			for _, params := range []chunking.Params{chunking.Fixed(4096), cdc} {
+				for keyName, key := range keys {
+					t.Run(cipher+"/"+compression+"/"+params.String()+"/"+keyName, func(t *testing.T) {
+						roundTrip(t, tree, root, key, cipher, compression, params)
+					})
+				}
+			}
+		}
+	}
+}
+
+func roundTrip(t *testing.T, tree string, root *manifest.Node, key store.Key, cipher, compression string, params chunking.Params) {
+	location := t.TempDir()
+	s, err := store.Create(location, key, cipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetCompression(compression); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := s.Ingest(tree, root, params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != len(files) {
+		t.Errorf("stored %d files, want %d", stats.Files, len(files))
+	}
+	if compression == store.CompressZstd && stats.WrittenBytes >= stats.StoredBytes {
+		t.Errorf("compressed %d bytes to %d", stats.StoredBytes, stats.WrittenBytes)
+	}
+
+	// Opened again, the store knows its settings and reads the snapshot
+	s, err = store.OpenExisting(location, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Cipher() != cipher || s.Compression() != compression {
+		t.Errorf("reopened with %s and %s", s.Cipher(), s.Compression())
+	}
+	snap, err := s.LoadSnapshot(root.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := t.TempDir()
+	restored, err := s.Restore(snap, ".", dest, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Files != len(files) || len(restored.Failed) > 0 {
+		t.Errorf("restored %d files, failed %v", restored.Files, restored.Failed)
+	}
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil || !bytes.Equal(got, []byte(content)) {
+			t.Errorf("%s: restored %d bytes, %v", name, len(got), err)
+		}
+	}
+}
+
+func TestWrongKey(t *testing.T) {
+	location := t.TempDir()
+	if _, err := store.Create(location, store.Key{Passphrase: "pw"}, store.DefaultCipher); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.OpenExisting(location, store.Key{Passphrase: "other"}); err == nil {
+		t.Error("opened with another passphrase")
+	}
+	if _, err := store.OpenExisting(t.TempDir(), store.Key{Passphrase: "pw"}); !errors.Is(err, store.ErrNoStore) {
+		t.Errorf("got %v for no store, want ErrNoStore", err)
+	}
+}
+
+// A stored object that was changed fails to read, whatever it was
+// encrypted and compressed with.
+func TestTamperedObject(t *testing.T) {
+	for _, cipher := range store.Ciphers {
+		for _, compression := range store.Compressions {
+			t.Run(cipher+"/"+compression, func(t *testing.T) {
+				location := t.TempDir()
+				s, err := store.Create(location, store.Key{Passphrase: "pw"}, cipher)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := s.SetCompression(compression); err != nil {
+					t.Fatal(err)
+				}
+				data := []byte(files["sub/text"])
+				hash, _, err := s.Put(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, err := s.Get(hash); err != nil || !bytes.Equal(got, data) {
+					t.Fatalf("read back %d bytes, %v", len(got), err)
+				}
+				name := filepath.Join(location, "objects", hash[:2], hash)
+				sealed, err := os.ReadFile(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sealed[len(sealed)/2] ^= 1
+				if err := os.WriteFile(name, sealed, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				s, err = store.OpenExisting(location, store.Key{Passphrase: "pw"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := s.Get(hash); err == nil || !strings.Contains(err.Error(), "fails authentication") {
+					t.Errorf("got %v, want the object to fail authentication", err)
+				}
+			})
+		}
+	}
+}
+
+// A chunk an ingest finds stored counts as written then, so GC running
+// before its snapshot is saved keeps it, however old it is.
+func TestGCKeepsReusedChunks(t *testing.T) {
+	location := t.TempDir()
+	key := store.Key{Passphrase: "pw"}
+	s, err := store.Create(location, key, store.DefaultCipher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _, err := s.Put([]byte("hi\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	name := filepath.Join(location, "objects", hash[:2], hash)
+	if err := os.Chtimes(name, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another ingest, in a store opened afresh
+	s, err = store.OpenExisting(location, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, written, err := s.Put([]byte("hi\n")); err != nil || again != hash || written != 0 {
+		t.Fatalf("stored the chunk again: %s, %d, %v", again, written, err)
+	}
+	r, err := s.GC(time.Hour, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Deleted) > 0 || r.Recent != 1 {
+		t.Errorf("deleted %v, kept %d recent chunks", r.Deleted, r.Recent)
+	}
+
+	// Left unreferenced, it goes
+	if err := os.Chtimes(name, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := s.GC(time.Hour, false, nil); err != nil || len(r.Deleted) != 1 {
+		t.Errorf("deleted %v, %v", r.Deleted, err)
+	}
+}
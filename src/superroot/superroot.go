@@ -0,0 +1,153 @@
+// Package superroot aggregates the root hashes of several trees into one
+// super-root, so a single fingerprint can be published for many datasets.
+// The super-root is the RFC 6962 Merkle tree hash over one leaf per tree,
+// in the order the trees are listed, and any tree's inclusion in it is
+// proven with an audit path, as transparency logs do.
+package superroot
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"MTFS/translog"
+)
+
+// Version is the version of the documents written by this package.
+const Version = 1
+
+// Tree is a tree covered by a super-root.
+type Tree struct {
+	Name     string `json:"name"`
+	RootHash string `json:"root_hash"`
+}
+
+// leaf returns the leaf hash of t: the root hash and the name, so a proof
+// also shows which tree it is about.
+func (t Tree) leaf() []byte {
+	return translog.LeafHash([]byte(t.RootHash + " " + t.Name))
+}
+
+// Document is a super-root with the trees it covers.
+type Document struct {
+	Version   int    `json:"version"`
+	Created   string `json:"created"`
+	Trees     []Tree `json:"trees"`
+	SuperRoot string `json:"super_root"`
+}
+
+// Proof shows that a tree is covered by a super-root.
+type Proof struct {
+	Version   int      `json:"version"`
+	Tree      Tree     `json:"tree"`
+	LeafIndex int64    `json:"leaf_index"`
+	TreeSize  int64    `json:"tree_size"`
+	AuditPath []string `json:"audit_path"`
+	SuperRoot string   `json:"super_root"`
+}
+
+// Compute returns the super-root over trees, in their order. Names must
+// be unique and every tree needs a root hash.
+func Compute(trees []Tree) (*Document, error) {
+	if len(trees) == 0 {
+		return nil, errors.New("no trees to cover")
+	}
+	seen := map[string]bool{}
+	for _, t := range trees {
+		switch {
+		case seen[t.Name]:
+			return nil, fmt.Errorf("tree %q is listed twice", t.Name)
+		case t.RootHash == "":
+			return nil, fmt.Errorf("tree %q has no root hash; build it first", t.Name)
+		}
+		seen[t.Name] = true
+	}
+	return &Document{
+		Version:   Version,
+		Created:   time.Now().UTC().Format(time.RFC3339),
+		Trees:     trees,
+		SuperRoot: hex.EncodeToString(translog.RootHash(leaves(trees))),
+	}, nil
+}
+
+func leaves(trees []Tree) [][]byte {
+	out := make([][]byte, len(trees))
+	for i, t := range trees {
+		out[i] = t.leaf()
+	}
+	return out
+}
+
+// Check recomputes the super-root of d from its trees.
+func (d *Document) Check() error {
+	if got := hex.EncodeToString(translog.RootHash(leaves(d.Trees))); got != d.SuperRoot {
+		return fmt.Errorf("the trees listed give super-root %s, not %s", got, d.SuperRoot)
+	}
+	return nil
+}
+
+// Prove returns the proof that the tree called name is covered by d.
+func (d *Document) Prove(name string) (*Proof, error) {
+	if err := d.Check(); err != nil {
+		return nil, err
+	}
+	for i, t := range d.Trees {
+		if t.Name != name {
+			continue
+		}
+		p := &Proof{Version: Version, Tree: t, LeafIndex: int64(i), TreeSize: int64(len(d.Trees)), SuperRoot: d.SuperRoot}
+		for _, h := range translog.InclusionPath(leaves(d.Trees), i) {
+			p.AuditPath = append(p.AuditPath, hex.EncodeToString(h))
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("tree %q is not covered by super-root %s", name, d.SuperRoot)
+}
+
+// Verify checks that the audit path of p leads from its tree to its
+// super-root.
+func (p *Proof) Verify() error {
+	root, err := hex.DecodeString(p.SuperRoot)
+	if err != nil {
+		return fmt.Errorf("super-root: %w", err)
+	}
+	path := make([][]byte, len(p.AuditPath))
+	for i, h := range p.AuditPath {
+		if path[i], err = hex.DecodeString(h); err != nil {
+			return fmt.Errorf("audit path: %w", err)
+		}
+	}
+	err = translog.VerifyInclusion(p.Tree.leaf(), p.LeafIndex, p.TreeSize, path, root)
+	switch {
+	case errors.Is(err, translog.ErrNotIncluded):
+		return fmt.Errorf("tree %q with root %s is not covered by super-root %s: the audit path leads to another root", p.Tree.Name, p.Tree.RootHash, p.SuperRoot)
+	case err != nil:
+		return fmt.Errorf("tree %q: %s", p.Tree.Name, strings.TrimPrefix(err.Error(), "transparency log: "))
+	}
+	return nil
+}
+
+// Save writes v, a document or a proof, as indented JSON to name.
+func Save(name string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0o644)
+}
+
+// Load reads a document or a proof written by Save from name into v.
+func Load(name string, v any) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package timestamp
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"MTFS/signing"
+	"MTFS/state"
+)
+
+const (
+	// Dir is the directory inside .mtfs holding one token per root hash.
+	Dir = "timestamps"
+	// CAEnv names the environment variable pointing at a PEM bundle of
+	// TSA roots. Without it the system roots are used, which do not hold
+	// the root of DefaultTSA.
+	CAEnv = "MTFS_TSA_CA"
+	// DefaultTSACA is where the root certificate of DefaultTSA is
+	// published, to point CAEnv at.
+	DefaultTSACA = "https://freetsa.org/files/cacert.pem"
+)
+
+// URL returns the configured TSA, falling back to DefaultTSA.
+func URL() string {
+	if url := os.Getenv(TSAEnv); url != "" {
+		return url
+	}
+	return DefaultTSA
+}
+
+// File returns the name of the token for hash inside the state directory.
+func File(hash string) string {
+	return filepath.Join(Dir, hash+".tsr")
+}
+
+// Stored is a token read back from the state directory of a tree.
+type Stored struct {
+	// Hash is the root hash the token was requested for.
+	Hash  string
+	Token *Token
+	// Err is set when the token could not be parsed or does not cover
+	// the root statement of Hash.
+	Err error
+}
+
+// Load reads every token stored for tree, oldest first. A tree without
+// timestamps yields no tokens and no error.
+func Load(tree string) ([]Stored, error) {
+	paths, err := filepath.Glob(state.Path(tree, Dir, "*.tsr"))
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make([]Stored, 0, len(paths))
+	for _, p := range paths {
+		s := Stored{Hash: strings.TrimSuffix(filepath.Base(p), ".tsr")}
+		der, err := os.ReadFile(p)
+		if err == nil {
+			s.Token, err = Parse(der)
+		}
+		if err == nil && !s.Token.Covers(signing.RootStatement(s.Hash)) {
+			err = errors.New("token does not cover this root hash")
+		}
+		s.Err = err
+		stored = append(stored, s)
+	}
+
+	sort.SliceStable(stored, func(i, j int) bool {
+		if stored[i].Token == nil || stored[j].Token == nil {
+			return stored[j].Token == nil && stored[i].Token != nil
+		}
+		return stored[i].Token.Time.Before(stored[j].Token.Time)
+	})
+	return stored, nil
+}
+
+// Roots loads the TSA roots in the PEM file path, usually the one CAEnv
+// names. Without one it returns nil to use the system pool, unless the
+// configured TSA is DefaultTSA, whose tokens would never verify.
+func Roots(path string) (*x509.CertPool, error) {
+	if path == "" {
+		if URL() == DefaultTSA {
+			return nil, fmt.Errorf("the root of %s is not among the system roots: set %s to a PEM file of it, published at %s", DefaultTSA, CAEnv, DefaultTSACA)
+		}
+		return nil, nil
+	}
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
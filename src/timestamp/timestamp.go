@@ -0,0 +1,333 @@
+// Package timestamp obtains and checks RFC 3161 timestamp tokens, which
+// let a trusted timestamping authority (TSA) vouch that a root hash
+// existed at a given time.
+package timestamp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultTSA is used when no TSA is configured.
+	DefaultTSA = "https://freetsa.org/tsr"
+	// TSAEnv names the environment variable selecting the TSA URL. When it
+	// is set, every successful build is timestamped automatically.
+	TSAEnv = "MTFS_TSA_URL"
+)
+
+var (
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type messageImprint struct {
+	HashAlgorithm algorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString asn1.RawValue  `asn1:"optional"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status pkiStatusInfo
+	Token  asn1.RawValue `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    algorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm algorithmIdentifier
+	Signature          []byte
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Accuracy       accuracy  `asn1:"optional"`
+	Ordering       bool      `asn1:"optional"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// Token is a parsed timestamp response.
+type Token struct {
+	// Raw is the DER TimeStampResp exactly as returned by the TSA.
+	Raw []byte
+	// Time is the time the TSA vouches for.
+	Time time.Time
+	// Serial is the TSA's serial number for the token.
+	Serial *big.Int
+	// HashedMessage is the SHA-256 digest the token covers.
+	HashedMessage []byte
+	// Nonce is the nonce of the request the token answers, nil if it
+	// carried none.
+	Nonce *big.Int
+	// Signer is the TSA certificate that signed the token, if it was
+	// included in the response.
+	Signer *x509.Certificate
+
+	certificates []*x509.Certificate
+}
+
+// Request asks the TSA at url for a token over the SHA-256 digest of data.
+func Request(url string, data []byte) (*Token, error) {
+	digest := sha256.Sum256(data)
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	req, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: algorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: digest[:],
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(url, "application/timestamp-query", bytes.NewReader(req))
+	if err != nil {
+		return nil, fmt.Errorf("timestamp request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("timestamp request: TSA returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("timestamp request: %w", err)
+	}
+
+	token, err := Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Covers(data) {
+		return nil, errors.New("timestamp request: TSA returned a token for a different digest")
+	}
+	// A token without the nonce could be a replay of an older one
+	if token.Nonce == nil || token.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("timestamp request: TSA returned a token for a different request (nonce mismatch)")
+	}
+	return token, nil
+}
+
+// Parse decodes a DER TimeStampResp and checks the TSA signature against
+// the certificate embedded in it.
+func Parse(der []byte) (*Token, error) {
+	var resp timeStampResp
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, fmt.Errorf("parse timestamp: %w", err)
+	}
+	// 0 = granted, 1 = granted with modifications
+	if resp.Status.Status > 1 {
+		return nil, fmt.Errorf("parse timestamp: TSA rejected the request (status %d)", resp.Status.Status)
+	}
+	if len(resp.Token.FullBytes) == 0 {
+		return nil, errors.New("parse timestamp: response carries no token")
+	}
+
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(resp.Token.FullBytes, &ci); err != nil {
+		return nil, fmt.Errorf("parse timestamp token: %w", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("parse timestamp token: unexpected content type %v", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("parse timestamp token: %w", err)
+	}
+	if !sd.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return nil, fmt.Errorf("parse timestamp token: unexpected content type %v", sd.EncapContentInfo.EContentType)
+	}
+
+	// The explicit [0] wrapper holds an OCTET STRING with the DER TSTInfo
+	var content []byte
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent.Bytes, &content); err != nil {
+		return nil, fmt.Errorf("parse timestamp token: %w", err)
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(content, &info); err != nil {
+		return nil, fmt.Errorf("parse timestamp info: %w", err)
+	}
+	if !info.MessageImprint.HashAlgorithm.Algorithm.Equal(oidSHA256) {
+		return nil, errors.New("parse timestamp info: token does not use SHA-256")
+	}
+
+	token := &Token{
+		Raw:           der,
+		Time:          info.GenTime,
+		Serial:        info.SerialNumber,
+		HashedMessage: info.MessageImprint.HashedMessage,
+		Nonce:         info.Nonce,
+	}
+	if len(sd.Certificates.Bytes) > 0 {
+		certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse timestamp certificates: %w", err)
+		}
+		token.certificates = certs
+	}
+	if err := token.checkSignature(content, sd.SignerInfos); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// checkSignature verifies the CMS signature over the TSTInfo and records
+// the certificate that made it.
+func (t *Token) checkSignature(content []byte, signers []signerInfo) error {
+	if len(signers) != 1 {
+		return fmt.Errorf("timestamp signature: expected one signer, found %d", len(signers))
+	}
+	si := signers[0]
+	if !si.DigestAlgorithm.Algorithm.Equal(oidSHA256) {
+		return errors.New("timestamp signature: only SHA-256 digests are supported")
+	}
+	if len(si.SignedAttrs.Bytes) == 0 {
+		return errors.New("timestamp signature: signed attributes are missing")
+	}
+
+	// The message digest attribute binds the signature to the TSTInfo
+	contentDigest := sha256.Sum256(content)
+	if digest, err := messageDigest(si.SignedAttrs.Bytes); err != nil {
+		return err
+	} else if !bytes.Equal(digest, contentDigest[:]) {
+		return errors.New("timestamp signature: message digest does not match the token")
+	}
+
+	// Signed attributes are signed as an explicit SET, not the [0] tag
+	signed := append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+	for _, cert := range t.certificates {
+		var algo x509.SignatureAlgorithm
+		switch cert.PublicKeyAlgorithm {
+		case x509.RSA:
+			algo = x509.SHA256WithRSA
+		case x509.ECDSA:
+			algo = x509.ECDSAWithSHA256
+		case x509.Ed25519:
+			algo = x509.PureEd25519
+		default:
+			continue
+		}
+		if cert.CheckSignature(algo, signed, si.Signature) == nil {
+			t.Signer = cert
+			return nil
+		}
+	}
+	if len(t.certificates) == 0 {
+		// Nothing to check against; the caller sees Signer == nil
+		return nil
+	}
+	return errors.New("timestamp signature: no embedded certificate validates the signature")
+}
+
+func messageDigest(attrs []byte) ([]byte, error) {
+	for rest := attrs; len(rest) > 0; {
+		var attr attribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return nil, fmt.Errorf("timestamp signature: %w", err)
+		}
+		if attr.Type.Equal(oidMessageDigest) {
+			var digest []byte
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &digest); err != nil {
+				return nil, fmt.Errorf("timestamp signature: %w", err)
+			}
+			return digest, nil
+		}
+	}
+	return nil, errors.New("timestamp signature: message digest attribute is missing")
+}
+
+// Covers reports whether the token was issued for data.
+func (t *Token) Covers(data []byte) bool {
+	digest := sha256.Sum256(data)
+	return bytes.Equal(t.HashedMessage, digest[:])
+}
+
+// VerifyChain checks that the signing certificate chains up to roots and
+// is valid for timestamping at the token's time.
+func (t *Token) VerifyChain(roots *x509.CertPool) error {
+	if t.Signer == nil {
+		return errors.New("timestamp token carries no signing certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range t.certificates {
+		intermediates.AddCert(cert)
+	}
+	_, err := t.Signer.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   t.Time,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	})
+	return err
+}
@@ -0,0 +1,266 @@
+package timestamp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var genTime = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// tsa is a timestamping authority answering requests with tokens signed
+// by a self-signed ECDSA certificate. alter may change the TSTInfo before
+// it is signed, and tamper the DER TSTInfo after.
+type tsa struct {
+	key    *ecdsa.PrivateKey
+	cert   *x509.Certificate
+	alter  func(info *tstInfo)
+	tamper func(der []byte)
+}
+
+func newTSA(t *testing.T) *tsa {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test TSA"},
+		NotBefore:    genTime.Add(-time.Hour),
+		NotAfter:     genTime.Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tsa{key: key, cert: cert}
+}
+
+func (s *tsa) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var req timeStampReq
+	if _, err := asn1.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, err := s.respond(tstInfo{
+		Version:        1,
+		Policy:         asn1.ObjectIdentifier{1, 2, 3},
+		MessageImprint: req.MessageImprint,
+		SerialNumber:   big.NewInt(42),
+		GenTime:        genTime,
+		Nonce:          req.Nonce,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(resp)
+}
+
+// respond returns the DER TimeStampResp of a token for info.
+func (s *tsa) respond(info tstInfo) ([]byte, error) {
+	if s.alter != nil {
+		s.alter(&info)
+	}
+	content, err := asn1.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(content)
+	if s.tamper != nil {
+		s.tamper(content)
+	}
+	octets, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, err
+	}
+	attr, err := asn1.Marshal(attribute{Type: oidMessageDigest, Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: octets}})
+	if err != nil {
+		return nil, err
+	}
+	signed, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attr})
+	if err != nil {
+		return nil, err
+	}
+	signedDigest := sha256.Sum256(signed)
+	sig, err := ecdsa.SignASN1(rand.Reader, s.key, signedDigest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	eContent, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	sha256ID := algorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	algorithms, err := asn1.Marshal(sha256ID)
+	if err != nil {
+		return nil, err
+	}
+	sd, err := asn1.Marshal(signedData{
+		Version:          3,
+		DigestAlgorithms: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: algorithms},
+		EncapContentInfo: encapsulatedContentInfo{
+			EContentType: oidTSTInfo,
+			EContent:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: eContent},
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: s.cert.Raw},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                asn1.RawValue{Tag: asn1.TagInteger, Bytes: []byte{1}},
+			DigestAlgorithm:    sha256ID,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attr},
+			SignatureAlgorithm: algorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+			Signature:          sig,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ci, err := asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(timeStampResp{Token: asn1.RawValue{FullBytes: ci}})
+}
+
+func (s *tsa) request(t *testing.T, data string) (*Token, error) {
+	t.Helper()
+	server := httptest.NewServer(s)
+	defer server.Close()
+	return Request(server.URL, []byte(data))
+}
+
+func TestRequest(t *testing.T) {
+	s := newTSA(t)
+	token, err := s.request(t, "root hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !token.Time.Equal(genTime) || token.Serial.Int64() != 42 || token.Nonce == nil {
+		t.Errorf("got time %v, serial %v and nonce %v", token.Time, token.Serial, token.Nonce)
+	}
+	if !token.Covers([]byte("root hash")) || token.Covers([]byte("root hasH")) {
+		t.Error("the token covers the wrong data")
+	}
+	if token.Signer == nil || !token.Signer.Equal(s.cert) {
+		t.Error("the signer is not the TSA certificate")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(s.cert)
+	if err := token.VerifyChain(roots); err != nil {
+		t.Error(err)
+	}
+	if err := token.VerifyChain(x509.NewCertPool()); err == nil {
+		t.Error("the chain verified against no roots")
+	}
+
+	// The raw response parses to the same token again
+	again, err := Parse(token.Raw)
+	if err != nil || again.Nonce.Cmp(token.Nonce) != 0 {
+		t.Errorf("reparsed: %v, nonce %v", err, again.Nonce)
+	}
+}
+
+func TestRequestRefusesTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		alter  func(info *tstInfo)
+		tamper func(der []byte)
+		want   string
+	}{
+		{"missing nonce", func(info *tstInfo) { info.Nonce = nil }, nil, "nonce mismatch"},
+		{"other nonce", func(info *tstInfo) { info.Nonce = new(big.Int).Add(info.Nonce, big.NewInt(1)) }, nil, "nonce mismatch"},
+		{"other digest", func(info *tstInfo) { info.MessageImprint.HashedMessage = make([]byte, 32) }, nil, "different digest"},
+		{"tampered token", nil, func(der []byte) { der[len(der)-1] ^= 1 }, "message digest does not match"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTSA(t)
+			s.alter, s.tamper = test.alter, test.tamper
+			if _, err := s.request(t, "root hash"); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want an error containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestParseRefusesForeignSignature(t *testing.T) {
+	s := newTSA(t)
+	data, err := s.respond(tstInfo{Version: 1, Policy: asn1.ObjectIdentifier{1, 2, 3}, SerialNumber: big.NewInt(1), GenTime: genTime,
+		MessageImprint: messageImprint{HashAlgorithm: algorithmIdentifier{Algorithm: oidSHA256}, HashedMessage: make([]byte, 32)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Signed by another key, the token carries a certificate that does not
+	// validate it
+	s.cert = newTSA(t).cert
+	forged, err := s.respond(tstInfo{Version: 1, Policy: asn1.ObjectIdentifier{1, 2, 3}, SerialNumber: big.NewInt(1), GenTime: genTime,
+		MessageImprint: messageImprint{HashAlgorithm: algorithmIdentifier{Algorithm: oidSHA256}, HashedMessage: make([]byte, 32)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Parse(data); err != nil {
+		t.Errorf("genuine token: %v", err)
+	}
+	if _, err := Parse(forged); err == nil || !strings.Contains(err.Error(), "no embedded certificate") {
+		t.Errorf("forged token: got %v", err)
+	}
+}
+
+// The roots of the TSA come from a PEM file; without one, those of the
+// system, except for the default TSA, which they do not hold.
+func TestRoots(t *testing.T) {
+	s := newTSA(t)
+	name := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.cert.Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	token, err := s.request(t, "root hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, err := Roots(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := token.VerifyChain(roots); err != nil {
+		t.Error(err)
+	}
+
+	t.Setenv(TSAEnv, "")
+	if _, err := Roots(""); err == nil || !strings.Contains(err.Error(), CAEnv) {
+		t.Errorf("got %v without roots for the default TSA", err)
+	}
+	t.Setenv(TSAEnv, "https://tsa.example/tsr")
+	if roots, err := Roots(""); err != nil || roots != nil {
+		t.Errorf("got %v, %v without roots for another TSA", roots, err)
+	}
+	if _, err := Roots(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Error("loaded a missing file")
+	}
+}
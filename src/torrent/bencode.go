@@ -0,0 +1,45 @@
+package torrent
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// dict is a bencoded dictionary; its keys are written in byte order.
+type dict map[string]any
+
+// raw is a value that is already bencoded.
+type raw []byte
+
+// bencode encodes dictionaries, byte strings, strings and integers.
+func bencode(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case raw:
+		return append(b, v...)
+	case dict:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = append(b, 'd')
+		for _, k := range keys {
+			b = bencode(b, k)
+			b = bencode(b, v[k])
+		}
+		return append(b, 'e')
+	case []byte:
+		b = strconv.AppendInt(b, int64(len(v)), 10)
+		return append(append(b, ':'), v...)
+	case string:
+		return bencode(b, []byte(v))
+	case int64:
+		b = strconv.AppendInt(append(b, 'i'), v, 10)
+		return append(b, 'e')
+	case int:
+		return bencode(b, int64(v))
+	default:
+		panic(fmt.Sprintf("bencode: unsupported type %T", v))
+	}
+}
@@ -0,0 +1,176 @@
+// Package torrent turns a tree into a BitTorrent v2 (BEP 52) torrent, so a
+// directory fingerprinted by MTFS can be seeded and verified by torrent
+// clients.
+//
+// Every file gets its own SHA-256 Merkle tree over 16 KiB blocks. Its root
+// ("pieces root") is part of the info dictionary, whose SHA-256 is the v2
+// info-hash, and the layer of the tree that covers one piece each is
+// stored in the "piece layers" of the torrent.
+package torrent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+)
+
+const (
+	// BlockSize is the size of the leaf blocks of each file's Merkle tree.
+	BlockSize = 16 * 1024
+	// MaxPieceLength caps the piece length chosen by PieceLengthFor.
+	MaxPieceLength = 16 * 1024 * 1024
+	// targetPieces is the piece count PieceLengthFor stays below when it can.
+	targetPieces = 2048
+)
+
+// File is a file of a torrent.
+type File struct {
+	Path       string // slash-separated, relative to the torrent's directory
+	Length     int64
+	PiecesRoot []byte // root of the file's Merkle tree, nil for empty files
+	PieceLayer []byte // concatenated piece hashes, nil unless the file spans several pieces
+}
+
+// Torrent is a v2 torrent of a directory.
+type Torrent struct {
+	Name        string
+	PieceLength int64
+	Files       []File
+}
+
+// PieceLengthFor returns the smallest power of two from BlockSize up to
+// MaxPieceLength that splits total bytes into at most a few thousand pieces.
+func PieceLengthFor(total int64) int64 {
+	length := int64(BlockSize)
+	for length < MaxPieceLength && total/length > targetPieces {
+		length *= 2
+	}
+	return length
+}
+
+// Info returns the bencoded info dictionary, the input of the info-hash.
+func (t *Torrent) Info() []byte {
+	fileTree := dict{}
+	for _, f := range t.Files {
+		dir := fileTree
+		parts := strings.Split(f.Path, "/")
+		for _, part := range parts[:len(parts)-1] {
+			sub, ok := dir[part].(dict)
+			if !ok {
+				sub = dict{}
+				dir[part] = sub
+			}
+			dir = sub
+		}
+		entry := dict{"length": f.Length}
+		if f.PiecesRoot != nil {
+			entry["pieces root"] = f.PiecesRoot
+		}
+		dir[parts[len(parts)-1]] = dict{"": entry}
+	}
+	return bencode(nil, dict{
+		"name":         t.Name,
+		"piece length": t.PieceLength,
+		"meta version": 2,
+		"file tree":    fileTree,
+	})
+}
+
+// InfoHash returns the v2 info-hash in hex.
+func (t *Torrent) InfoHash() string {
+	sum := sha256.Sum256(t.Info())
+	return hex.EncodeToString(sum[:])
+}
+
+// Magnet returns a magnet link for the torrent.
+func (t *Torrent) Magnet() string {
+	return "magnet:?xt=urn:btmh:1220" + t.InfoHash() + "&dn=" + url.QueryEscape(t.Name)
+}
+
+// Encode returns the content of the .torrent file.
+func (t *Torrent) Encode() []byte {
+	layers := dict{}
+	for _, f := range t.Files {
+		if f.PieceLayer != nil {
+			layers[string(f.PiecesRoot)] = f.PieceLayer
+		}
+	}
+	return bencode(nil, dict{"created by": "MTFS", "info": raw(t.Info()), "piece layers": layers})
+}
+
+// hashFile reads a file and returns its pieces root and, if it spans more
+// than one piece, its piece layer.
+func hashFile(r io.Reader, pieceLength int64) (root, layer []byte, length int64, err error) {
+	blocksPerPiece := int(pieceLength / BlockSize)
+	var pieces, blocks [][]byte
+	block := make([]byte, BlockSize)
+	for {
+		n, err := io.ReadFull(r, block)
+		if n > 0 {
+			sum := sha256.Sum256(block[:n])
+			blocks = append(blocks, sum[:])
+			length += int64(n)
+		}
+		if len(blocks) == blocksPerPiece {
+			pieces = append(pieces, merkleRoot(blocks, blocksPerPiece, zeroHash))
+			blocks = nil
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	// A file of at most one piece is a tree of its blocks only
+	switch {
+	case length == 0:
+		return nil, nil, 0, nil
+	case length <= pieceLength && len(pieces) == 1:
+		return pieces[0], nil, length, nil
+	case length <= pieceLength:
+		return merkleRoot(blocks, 1, zeroHash), nil, length, nil
+	}
+	if len(blocks) > 0 {
+		pieces = append(pieces, merkleRoot(blocks, blocksPerPiece, zeroHash))
+	}
+	padPiece := merkleRoot(nil, blocksPerPiece, zeroHash)
+	return merkleRoot(pieces, 1, padPiece), joinHashes(pieces), length, nil
+}
+
+var zeroHash = make([]byte, sha256.Size)
+
+// merkleRoot pads hashes to a power of two, and to at least width, with
+// pad and hashes pairs of them until one is left.
+func merkleRoot(hashes [][]byte, width int, pad []byte) []byte {
+	size := 1
+	for size < len(hashes) || size < width {
+		size *= 2
+	}
+	level := make([][]byte, size)
+	copy(level, hashes)
+	for i := len(hashes); i < size; i++ {
+		level[i] = pad
+	}
+	for len(level) > 1 {
+		next := make([][]byte, len(level)/2)
+		for i := range next {
+			sum := sha256.Sum256(append(append([]byte{}, level[2*i]...), level[2*i+1]...))
+			next[i] = sum[:]
+		}
+		level = next
+	}
+	return level[0]
+}
+
+func joinHashes(hashes [][]byte) []byte {
+	var b []byte
+	for _, h := range hashes {
+		b = append(b, h...)
+	}
+	return b
+}
@@ -0,0 +1,76 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"MTFS/forensic"
+	"MTFS/manifest"
+)
+
+// FromTree reads the files of the tree described by root and builds its
+// torrent, named after the root directory, with a piece length chosen by
+// PieceLengthFor. It fails if a file no longer matches its content hash or
+// cannot be part of a torrent.
+func FromTree(tree string, root *manifest.Node) (*Torrent, error) {
+	var total int64
+	root.Walk(func(p string, node *manifest.Node) error {
+		if node.IsFile() {
+			total += node.Size
+		}
+		return nil
+	})
+	pieceLength := PieceLengthFor(total)
+
+	t := &Torrent{Name: root.Name, PieceLength: pieceLength}
+	err := root.Walk(func(p string, node *manifest.Node) error {
+		switch node.Type {
+		case "directory":
+			return nil // Implied by the paths of their files
+		case "file":
+		case "error":
+			return fmt.Errorf("%s could not be read when the tree was built (%s); rebuild it first", p, node.Error)
+		default:
+			return fmt.Errorf("%s: a %s cannot be part of a torrent", p, node.Type)
+		}
+
+		f, err := forensic.Open(filepath.Join(tree, filepath.FromSlash(p)))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		check := node.NewContentCheck()
+		piecesRoot, layer, length, err := hashFile(io.TeeReader(f, check), pieceLength)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		if !check.Matches(node.ContentHash) {
+			return fmt.Errorf("%s: changed since the tree was built", p)
+		}
+		t.Files = append(t.Files, File{Path: p, Length: length, PiecesRoot: piecesRoot, PieceLayer: layer})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(t.Files) == 0 {
+		return nil, errors.New("the tree has no files to share")
+	}
+	return t, nil
+}
+
+// Summary describes the files of t in tree order, one line each.
+func (t *Torrent) Summary() []string {
+	var lines []string
+	for _, f := range t.Files {
+		pieces := (f.Length + t.PieceLength - 1) / t.PieceLength
+		root := "(empty)"
+		if f.PiecesRoot != nil {
+			root = fmt.Sprintf("%x", f.PiecesRoot)
+		}
+		lines = append(lines, fmt.Sprintf("%s  %d pieces  %s", root, pieces, f.Path))
+	}
+	return lines
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"MTFS/signing"
+	"MTFS/translog"
+)
+
+func verifyLog(args []string) int {
+	flags := flag.NewFlagSet("verify-log", flag.ExitOnError)
+	flags.Parse(args)
+
+	tree := treeArg(flags, 0)
+	operation.Tree = tree
+
+	receipts, err := translog.Receipts(tree)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(receipts) == 0 {
+		fmt.Printf("Tree %s has no published root hashes\n", tree)
+		return 1
+	}
+
+	hashes := make([]string, 0, len(receipts))
+	for hash := range receipts {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	key, err := translog.LoadKey(os.Getenv(translog.KeyEnv))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	ok := true
+	for _, hash := range hashes {
+		receipt := receipts[hash]
+		head, err := translog.NewClient(receipt.Log, key).CheckInclusion(receipt, signing.RootStatement(hash))
+		if err == nil {
+			err = translog.SaveReceipt(tree, hash, receipt)
+		}
+		if err != nil {
+			fmt.Printf("%s  not included: %v\n", hash, err)
+			ok = false
+			continue
+		}
+		fmt.Printf("%s  entry %d of %d in %s\n", hash, receipt.LeafIndex, head.TreeSize, receipt.Log)
+	}
+
+	if !ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,47 @@
+package translog
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"MTFS/state"
+)
+
+// Dir is the directory inside .mtfs holding one receipt per root hash.
+const Dir = "translog"
+
+// File returns the name of the receipt for hash inside the state directory.
+func File(hash string) string {
+	return filepath.Join(Dir, hash+".json")
+}
+
+// SaveReceipt stores r for the root hash of tree.
+func SaveReceipt(tree, hash string, r *Receipt) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return state.WriteFile(tree, File(hash), append(data, '\n'))
+}
+
+// Receipts returns the stored receipts of tree keyed by root hash.
+func Receipts(tree string) (map[string]*Receipt, error) {
+	paths, err := filepath.Glob(state.Path(tree, Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	receipts := make(map[string]*Receipt, len(paths))
+	for _, p := range paths {
+		data, err := state.ReadFile(tree, filepath.Join(Dir, filepath.Base(p)))
+		if err != nil {
+			return nil, err
+		}
+		var r Receipt
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, err
+		}
+		receipts[strings.TrimSuffix(filepath.Base(p), ".json")] = &r
+	}
+	return receipts, nil
+}
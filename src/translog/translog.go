@@ -0,0 +1,401 @@
+// Package translog publishes root hashes to an append-only transparency
+// log and checks that they were included. It speaks the Merkle tree
+// hashing and proof format of RFC 6962 (Certificate Transparency):
+//
+//	POST {log}/add-entry               {"data": base64}  -> {"leaf_index": n}
+//	GET  {log}/get-sth                                   -> {"tree_size": n, "timestamp": ms, "sha256_root_hash": base64, "tree_head_signature": base64}
+//	GET  {log}/get-proof-by-hash?hash=&tree_size=        -> {"leaf_index": n, "audit_path": [base64]}
+//	GET  {log}/get-sth-consistency?first=&second=        -> {"consistency": [base64]}
+//
+// The log is not trusted to report inclusion: tree heads must be signed
+// by its key, audit paths are checked locally against them, and every
+// head must extend the last one seen for an entry, as a consistency proof
+// shows, so the log cannot rewrite its history unnoticed.
+package translog
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// URLEnv names the environment variable selecting the log. When it
+	// is set, every successful build is published automatically.
+	URLEnv = "MTFS_LOG_URL"
+	// KeyEnv names the environment variable holding the PEM file of the
+	// public key the log signs its tree heads with.
+	KeyEnv = "MTFS_LOG_KEY"
+)
+
+// ErrNotIncluded is returned by VerifyInclusion when the audit path leads
+// to another root.
+var ErrNotIncluded = errors.New("transparency log: entry is not included in the log's tree head")
+
+// ErrInconsistent is returned by VerifyConsistency when the newer tree
+// head does not extend the older one: the log rewrote its history.
+var ErrInconsistent = errors.New("transparency log: tree head is not consistent with the last one seen")
+
+// Receipt records where an entry was added to a log.
+type Receipt struct {
+	Log       string `json:"log"`
+	LeafIndex int64  `json:"leaf_index"`
+	LeafHash  []byte `json:"leaf_hash"`
+	Published string `json:"published"`
+	// Head is the last tree head the entry was proven in, which the
+	// next one must be consistent with.
+	Head *TreeHead `json:"head,omitempty"`
+}
+
+// TreeHead is the log's current size and root, signed by the log.
+type TreeHead struct {
+	TreeSize  int64  `json:"tree_size"`
+	Timestamp uint64 `json:"timestamp"` // milliseconds since the epoch
+	RootHash  []byte `json:"sha256_root_hash"`
+	// Signature is an RFC 5246 DigitallySigned structure over the RFC
+	// 6962 TreeHeadSignature of the fields above.
+	Signature []byte `json:"tree_head_signature"`
+}
+
+// Signed returns the RFC 6962 TreeHeadSignature of h, which the log signs:
+// version v1, signature type tree_hash, timestamp, tree size and root.
+func (h *TreeHead) Signed() []byte {
+	b := []byte{0, 1}
+	b = binary.BigEndian.AppendUint64(b, h.Timestamp)
+	b = binary.BigEndian.AppendUint64(b, uint64(h.TreeSize))
+	return append(b, h.RootHash...)
+}
+
+// Verify checks the signature of h with key, an ECDSA or RSA public key
+// signing SHA-256 digests.
+func (h *TreeHead) Verify(key crypto.PublicKey) error {
+	sig := h.Signature
+	if len(sig) < 4 || len(sig) != 4+int(binary.BigEndian.Uint16(sig[2:4])) {
+		return errors.New("transparency log: tree head signature is malformed")
+	}
+	// Hash algorithm 4 is SHA-256; signature algorithms 1 and 3 are RSA
+	// and ECDSA
+	if sig[0] != 4 {
+		return fmt.Errorf("transparency log: tree head signed with hash algorithm %d, not SHA-256", sig[0])
+	}
+	digest := sha256.Sum256(h.Signed())
+	valid := false
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		valid = sig[1] == 3 && ecdsa.VerifyASN1(key, digest[:], sig[4:])
+	case *rsa.PublicKey:
+		valid = sig[1] == 1 && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig[4:]) == nil
+	default:
+		return fmt.Errorf("transparency log: unsupported log key type %T", key)
+	}
+	if !valid {
+		return errors.New("transparency log: tree head signature does not verify with the log key")
+	}
+	return nil
+}
+
+// LoadKey reads the PEM public key of a log from the file name, as KeyEnv
+// names it.
+func LoadKey(name string) (crypto.PublicKey, error) {
+	if name == "" {
+		return nil, fmt.Errorf("transparency log: set %s to the log's public key, to check its tree heads", KeyEnv)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("transparency log: %s is not a PEM key", name)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("transparency log: %s: %w", name, err)
+	}
+	return key, nil
+}
+
+// LeafHash is the RFC 6962 hash of a log entry.
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// RootHash is the RFC 6962 Merkle tree hash over leaves, which are hashed
+// with LeafHash already.
+func RootHash(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leaves[0]
+	}
+	k := split(len(leaves))
+	return nodeHash(RootHash(leaves[:k]), RootHash(leaves[k:]))
+}
+
+// InclusionPath returns the RFC 6962 audit path of the leaf at index in
+// the tree over leaves, which VerifyInclusion checks.
+func InclusionPath(leaves [][]byte, index int) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := split(len(leaves))
+	if index < k {
+		return append(InclusionPath(leaves[:k], index), RootHash(leaves[k:]))
+	}
+	return append(InclusionPath(leaves[k:], index-k), RootHash(leaves[:k]))
+}
+
+// split returns the largest power of two smaller than n, where RFC 6962
+// splits a tree of n leaves.
+func split(n int) int {
+	k := 1
+	for k*2 < n {
+		k *= 2
+	}
+	return k
+}
+
+// Client talks to one log.
+type Client struct {
+	URL  string
+	Key  crypto.PublicKey // the tree heads are signed with
+	HTTP *http.Client
+}
+
+// NewClient returns a client for the log at url, whose tree heads are
+// signed with key.
+func NewClient(url string, key crypto.PublicKey) *Client {
+	return &Client{URL: strings.TrimSuffix(url, "/"), Key: key, HTTP: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Publish appends data to the log.
+func (c *Client) Publish(data []byte) (*Receipt, error) {
+	body, err := json.Marshal(map[string][]byte{"data": data})
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		LeafIndex int64 `json:"leaf_index"`
+	}
+	if err := c.call(http.MethodPost, "/add-entry", bytes.NewReader(body), &resp); err != nil {
+		return nil, err
+	}
+	return &Receipt{
+		Log:       c.URL,
+		LeafIndex: resp.LeafIndex,
+		LeafHash:  LeafHash(data),
+		Published: time.Now().UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// Head fetches the current tree head and checks its signature.
+func (c *Client) Head() (*TreeHead, error) {
+	var head TreeHead
+	if err := c.call(http.MethodGet, "/get-sth", nil, &head); err != nil {
+		return nil, err
+	}
+	if len(head.RootHash) != sha256.Size {
+		return nil, errors.New("transparency log: tree head has no valid root hash")
+	}
+	if err := head.Verify(c.Key); err != nil {
+		return nil, err
+	}
+	return &head, nil
+}
+
+// CheckInclusion fetches an audit path for data, the entry r was given
+// for, and checks it against the log's current tree head, which must be
+// consistent with the last head r records. It returns the head the entry
+// was proven in, and records it in r.
+func (c *Client) CheckInclusion(r *Receipt, data []byte) (*TreeHead, error) {
+	leaf := LeafHash(data)
+	if !bytes.Equal(leaf, r.LeafHash) {
+		return nil, errors.New("transparency log: the receipt is for another entry")
+	}
+	head, err := c.Head()
+	if err != nil {
+		return nil, err
+	}
+	if r.Head != nil {
+		if err := c.checkConsistency(r.Head, head); err != nil {
+			return nil, err
+		}
+	}
+
+	query := url.Values{
+		"hash":      {base64.StdEncoding.EncodeToString(leaf)},
+		"tree_size": {fmt.Sprint(head.TreeSize)},
+	}
+	var proof struct {
+		LeafIndex int64    `json:"leaf_index"`
+		AuditPath [][]byte `json:"audit_path"`
+	}
+	if err := c.call(http.MethodGet, "/get-proof-by-hash?"+query.Encode(), nil, &proof); err != nil {
+		return nil, err
+	}
+	if proof.LeafIndex != r.LeafIndex {
+		return nil, fmt.Errorf("transparency log: entry proven at index %d, but it was added at %d", proof.LeafIndex, r.LeafIndex)
+	}
+	if err := VerifyInclusion(leaf, proof.LeafIndex, head.TreeSize, proof.AuditPath, head.RootHash); err != nil {
+		return nil, err
+	}
+	r.Head = head
+	return head, nil
+}
+
+// checkConsistency checks that the tree head cur extends old.
+func (c *Client) checkConsistency(old, cur *TreeHead) error {
+	if cur.TreeSize < old.TreeSize {
+		return fmt.Errorf("transparency log: the log shrank from %d to %d entries", old.TreeSize, cur.TreeSize)
+	}
+	var proof struct {
+		Consistency [][]byte `json:"consistency"`
+	}
+	if cur.TreeSize > old.TreeSize {
+		query := url.Values{"first": {fmt.Sprint(old.TreeSize)}, "second": {fmt.Sprint(cur.TreeSize)}}
+		if err := c.call(http.MethodGet, "/get-sth-consistency?"+query.Encode(), nil, &proof); err != nil {
+			return err
+		}
+	}
+	return VerifyConsistency(old.TreeSize, cur.TreeSize, proof.Consistency, old.RootHash, cur.RootHash)
+}
+
+func (c *Client) call(method, path string, body io.Reader, out any) error {
+	req, err := http.NewRequest(method, c.URL+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("transparency log: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("transparency log: %s returned %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+		return fmt.Errorf("transparency log: %s: %w", path, err)
+	}
+	return nil
+}
+
+// VerifyInclusion checks an RFC 6962 audit path for the leaf at index in a
+// tree of size entries against root.
+func VerifyInclusion(leaf []byte, index, size int64, path [][]byte, root []byte) error {
+	if index < 0 || index >= size {
+		return fmt.Errorf("transparency log: leaf index %d is outside a tree of size %d", index, size)
+	}
+
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range path {
+		if sn == 0 {
+			return errors.New("transparency log: audit path is too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return errors.New("transparency log: audit path is too short")
+	}
+	if !bytes.Equal(r, root) {
+		return ErrNotIncluded
+	}
+	return nil
+}
+
+// VerifyConsistency checks an RFC 6962 consistency proof that the tree of
+// size2 entries with root2 extends the tree of its first size1 entries
+// with root1, as RFC 9162 section 2.1.4.2 does.
+func VerifyConsistency(size1, size2 int64, proof [][]byte, root1, root2 []byte) error {
+	switch {
+	case size1 < 0 || size1 > size2:
+		return fmt.Errorf("transparency log: a tree of %d entries cannot extend one of %d", size2, size1)
+	case size1 == size2:
+		if len(proof) > 0 || !bytes.Equal(root1, root2) {
+			return ErrInconsistent
+		}
+		return nil
+	case size1 == 0:
+		if len(proof) > 0 {
+			return errors.New("transparency log: consistency proof is too long")
+		}
+		return nil
+	case len(proof) == 0:
+		return errors.New("transparency log: consistency proof is empty")
+	}
+
+	// A first tree of a power of two entries is a subtree of the second
+	if size1&(size1-1) == 0 {
+		proof = append([][]byte{root1}, proof...)
+	}
+	fn, sn := size1-1, size2-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return errors.New("transparency log: consistency proof is too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			fr, sr = nodeHash(c, fr), nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return errors.New("transparency log: consistency proof is too short")
+	}
+	if !bytes.Equal(fr, root1) || !bytes.Equal(sr, root2) {
+		return ErrInconsistent
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package translog
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func leaves(n int) [][]byte {
+	var l [][]byte
+	for i := range n {
+		l = append(l, LeafHash([]byte(fmt.Sprintf("entry %d", i))))
+	}
+	return l
+}
+
+// consistencyProof returns the RFC 6962 proof that the tree over leaves
+// extends the tree over its first m.
+func consistencyProof(leaves [][]byte, m int) [][]byte {
+	if m == 0 || m == len(leaves) {
+		return nil
+	}
+	return subproof(leaves, m, true)
+}
+
+func subproof(leaves [][]byte, m int, complete bool) [][]byte {
+	if m == len(leaves) {
+		if complete {
+			return nil
+		}
+		return [][]byte{RootHash(leaves)}
+	}
+	k := split(len(leaves))
+	if m <= k {
+		return append(subproof(leaves[:k], m, complete), RootHash(leaves[k:]))
+	}
+	return append(subproof(leaves[k:], m-k, false), RootHash(leaves[:k]))
+}
+
+// tampered returns proof with one bit of its element i flipped.
+func tampered(proof [][]byte, i int) [][]byte {
+	out := make([][]byte, len(proof))
+	for j, p := range proof {
+		out[j] = bytes.Clone(p)
+	}
+	out[i][0] ^= 1
+	return out
+}
+
+func TestVerifyInclusion(t *testing.T) {
+	for size := 1; size <= 17; size++ {
+		l := leaves(size)
+		root := RootHash(l)
+		for i := range size {
+			path := InclusionPath(l, i)
+			if err := VerifyInclusion(l[i], int64(i), int64(size), path, root); err != nil {
+				t.Fatalf("leaf %d of %d: %v", i, size, err)
+			}
+			for j := range path {
+				if err := VerifyInclusion(l[i], int64(i), int64(size), tampered(path, j), root); !errors.Is(err, ErrNotIncluded) {
+					t.Fatalf("leaf %d of %d, element %d tampered: got %v", i, size, j, err)
+				}
+			}
+			if size > 1 {
+				if err := VerifyInclusion(l[(i+1)%size], int64(i), int64(size), path, root); err == nil {
+					t.Fatalf("leaf %d of %d: another leaf verified", i, size)
+				}
+			}
+		}
+	}
+}
+
+func TestVerifyConsistency(t *testing.T) {
+	for size2 := 1; size2 <= 17; size2++ {
+		l := leaves(size2)
+		root2 := RootHash(l)
+		for size1 := 0; size1 <= size2; size1++ {
+			root1 := RootHash(l[:size1])
+			proof := consistencyProof(l, size1)
+			if err := VerifyConsistency(int64(size1), int64(size2), proof, root1, root2); err != nil {
+				t.Fatalf("%d to %d: %v", size1, size2, err)
+			}
+			for j := range proof {
+				if err := VerifyConsistency(int64(size1), int64(size2), tampered(proof, j), root1, root2); err == nil {
+					t.Fatalf("%d to %d, element %d tampered: verified", size1, size2, j)
+				}
+			}
+			if size1 > 0 {
+				// A first tree with another entry is not extended
+				other := slices.Clone(l[:size1])
+				other[size1-1] = LeafHash([]byte("rewritten"))
+				if err := VerifyConsistency(int64(size1), int64(size2), proof, RootHash(other), root2); err == nil {
+					t.Fatalf("%d to %d: rewritten history verified", size1, size2)
+				}
+			}
+		}
+	}
+	if err := VerifyConsistency(3, 2, nil, nil, nil); err == nil {
+		t.Error("a smaller tree extended a larger one")
+	}
+}
+
+// testLog is an RFC 6962 log in memory, signing its heads with key.
+type testLog struct {
+	key     *ecdsa.PrivateKey
+	entries [][]byte
+	// Set to misbehave
+	shiftIndex int64
+	forgeHead  bool
+	shrink     int
+}
+
+func (l *testLog) head() *TreeHead {
+	size := len(l.entries) - l.shrink
+	var hashes [][]byte
+	for _, e := range l.entries[:size] {
+		hashes = append(hashes, LeafHash(e))
+	}
+	h := &TreeHead{TreeSize: int64(size), Timestamp: 1700000000000, RootHash: RootHash(hashes)}
+	digest := sha256.Sum256(h.Signed())
+	sig, err := ecdsa.SignASN1(rand.Reader, l.key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	if l.forgeHead {
+		digest[0] ^= 1
+		if sig, err = ecdsa.SignASN1(rand.Reader, l.key, digest[:]); err != nil {
+			panic(err)
+		}
+	}
+	h.Signature = binary.BigEndian.AppendUint16([]byte{4, 3}, uint16(len(sig)))
+	h.Signature = append(h.Signature, sig...)
+	return h
+}
+
+func (l *testLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var hashes [][]byte
+	for _, e := range l.entries {
+		hashes = append(hashes, LeafHash(e))
+	}
+	number := func(name string) int {
+		n, _ := strconv.Atoi(r.URL.Query().Get(name))
+		return n
+	}
+	var resp any
+	switch r.URL.Path {
+	case "/add-entry":
+		var req struct {
+			Data []byte `json:"data"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		l.entries = append(l.entries, req.Data)
+		resp = map[string]int{"leaf_index": len(l.entries) - 1}
+	case "/get-sth":
+		resp = l.head()
+	case "/get-proof-by-hash":
+		hash, _ := base64.StdEncoding.DecodeString(r.URL.Query().Get("hash"))
+		size := number("tree_size")
+		for i, h := range hashes[:size] {
+			if bytes.Equal(h, hash) {
+				resp = map[string]any{"leaf_index": int64(i) + l.shiftIndex, "audit_path": InclusionPath(hashes[:size], i)}
+			}
+		}
+	case "/get-sth-consistency":
+		resp = map[string]any{"consistency": consistencyProof(hashes[:number("second")], number("first"))}
+	}
+	if resp == nil {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newTestLog(t *testing.T) (*testLog, *Client) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &testLog{key: key}
+	server := httptest.NewServer(l)
+	t.Cleanup(server.Close)
+	return l, NewClient(server.URL, &key.PublicKey)
+}
+
+// publish adds data to the log after other entries and checks it was
+// included.
+func publish(t *testing.T, l *testLog, c *Client, data string) *Receipt {
+	t.Helper()
+	for i := range 3 {
+		l.entries = append(l.entries, []byte(fmt.Sprintf("before %s %d", data, i)))
+	}
+	r, err := c.Publish([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CheckInclusion(r, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestCheckInclusion(t *testing.T) {
+	l, c := newTestLog(t)
+	r := publish(t, l, c, "root 1")
+	if r.LeafIndex != 3 || r.Head == nil || r.Head.TreeSize != 4 {
+		t.Fatalf("got receipt %+v", r)
+	}
+
+	// The log grows, and the entry is proven in its new head, which the
+	// receipt keeps
+	publish(t, l, c, "root 2")
+	head, err := c.CheckInclusion(r, []byte("root 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.TreeSize != 8 || r.Head.TreeSize != 8 {
+		t.Errorf("proven in a head of %d entries, receipt has %d, want 8", head.TreeSize, r.Head.TreeSize)
+	}
+	if _, err := c.CheckInclusion(r, []byte("root 2")); err == nil {
+		t.Error("the receipt of root 1 proved root 2")
+	}
+}
+
+func TestCheckInclusionRefusesMisbehavingLog(t *testing.T) {
+	tests := []struct {
+		name      string
+		misbehave func(l *testLog)
+		want      string
+	}{
+		{"forged head", func(l *testLog) { l.forgeHead = true }, "does not verify"},
+		{"other index", func(l *testLog) { l.shiftIndex = 1 }, "proven at index 4"},
+		{"shrunk", func(l *testLog) { l.shrink = 2 }, "shrank"},
+		{"rewritten", func(l *testLog) { l.entries[0] = []byte("rewritten") }, ErrInconsistent.Error()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, c := newTestLog(t)
+			r := publish(t, l, c, "root")
+			l.entries = append(l.entries, []byte("later"))
+			test.misbehave(l)
+			if _, err := c.CheckInclusion(r, []byte("root")); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want an error containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestHeadNeedsLogKey(t *testing.T) {
+	l, c := newTestLog(t)
+	l.entries = append(l.entries, []byte("entry"))
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Key = &other.PublicKey
+	if _, err := c.Head(); err == nil {
+		t.Error("a head signed by another key verified")
+	}
+	if _, err := LoadKey(""); err == nil || !strings.Contains(err.Error(), KeyEnv) {
+		t.Errorf("got %v, want %s asked for", err, KeyEnv)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"MTFS/forensic"
+	"MTFS/oplog"
+	"MTFS/paths"
+	"MTFS/quota"
+	"MTFS/registry"
+	"MTFS/remote"
+	"MTFS/state"
+	"MTFS/statebundle"
+)
+
+func trees(args []string) int {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	switch args[0] {
+	case "list":
+		return listTrees(args[1:])
+	case "add":
+		return addTree(args[1:])
+	case "remove":
+		return removeTree(args[1:])
+	case "config":
+		return configTree(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Error: unknown trees command %q (list, add, remove or config)\n", args[0])
+	return 2
+}
+
+func listTrees(args []string) int {
+	flags := flag.NewFlagSet("trees list", flag.ExitOnError)
+	flags.Parse(args)
+	name, err := registry.Path()
+	if err == nil && name == "" {
+		err = registry.ErrOff
+	}
+	var known []registry.Tree
+	if err == nil {
+		known, err = registry.Load()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if len(known) == 0 {
+		fmt.Println("No trees are registered yet")
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPATH\tROOT HASH\tSTORES\tUPDATED")
+	for _, t := range known {
+		root := t.RootHash
+		if len(root) > 16 {
+			root = root[:16] + "…"
+		}
+		if root == "" {
+			root = "-"
+		}
+		stores := strings.Join(t.Stores, ",")
+		if stores == "" {
+			stores = "-"
+		}
+		updated := t.Updated
+		if when, err := time.Parse(time.RFC3339Nano, t.Updated); err == nil {
+			updated = when.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", t.Name, t.Path, root, stores, updated)
+	}
+	w.Flush()
+	return 0
+}
+
+func addTree(args []string) int {
+	flags := flag.NewFlagSet("trees add", flag.ExitOnError)
+	name := flags.String("name", "", "name of the tree (default: the name of its directory)")
+	flags.Parse(args)
+	dir := "."
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+	if info, err := os.Stat(dir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	} else if !info.IsDir() && !info.Mode().IsRegular() {
+		fmt.Fprintf(os.Stderr, "Error: %s is neither a directory nor an archive\n", dir)
+		return 1
+	}
+	t, err := registry.Add(dir, *name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Tree = t.Path
+	fmt.Printf("Registered %s as %s\n", t.Path, t.Name)
+	return 0
+}
+
+func removeTree(args []string) int {
+	flags := flag.NewFlagSet("trees remove", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no tree name given")
+		return 2
+	}
+	t, err := registry.Remove(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Tree = t.Path
+	fmt.Printf("Forgot %s (%s); its files and stores are left as they are\n", t.Name, t.Path)
+	return 0
+}
+
+// treeConfigKeys are the keys of a tree's .mtfs/config; include and
+// exclude may be repeated.
+var treeConfigKeys = map[string]bool{
+	"hash_format": true, "chunk_size": true, "include": true, "exclude": true,
+	"symlinks": true, "hidden": true, "max_depth": true, "min_file_size": true, "max_file_size": true,
+	"one_file_system": true, "xattrs": true, "metadata": true, "special_files": true, "normalize": true,
+	"case_insensitive": true, "apple_metadata": true, "io_limit": true, "iops_limit": true,
+	quota.SizeKey: true, quota.FilesKey: true, quota.GrowthKey: true,
+}
+
+// configTree shows the settings a registered tree pins in its
+// .mtfs/config and the options it was last built with, or changes them:
+// KEY=VALUE replaces every line of KEY, repeated for include and exclude,
+// and KEY= removes them. Values are checked by the next build.
+func configTree(args []string) int {
+	flags := flag.NewFlagSet("trees config", flag.ExitOnError)
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no tree name given")
+		return 2
+	}
+	t, ok, err := registry.Lookup(flags.Arg(0))
+	if err == nil && !ok {
+		err = fmt.Errorf("no tree is registered as %q", flags.Arg(0))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Tree = t.Path
+
+	data, err := state.ReadFile(t.Path, state.ConfigFile)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+
+	if flags.NArg() == 1 {
+		fmt.Printf("%s (%s)\n\nPinned in %s:\n", t.Name, t.Path, state.Path(t.Path, state.ConfigFile))
+		pinned := 0
+		for _, line := range lines {
+			if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+				fmt.Printf("  %s\n", trimmed)
+				pinned++
+			}
+		}
+		if pinned == 0 {
+			fmt.Println("  nothing")
+		}
+		options, err := state.ReadFile(t.Path, state.OptionsFile)
+		if err != nil {
+			return 0
+		}
+		fmt.Println("\nLast built with:")
+		for _, line := range strings.Split(strings.TrimSpace(string(options)), "\n")[1:] {
+			key, value, _ := strings.Cut(line, "\t")
+			fmt.Printf("  %s = %s\n", key, value)
+		}
+		return 0
+	}
+
+	set := map[string][]string{}
+	var order []string
+	for _, arg := range flags.Args()[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || !treeConfigKeys[key] {
+			fmt.Fprintf(os.Stderr, "Error: %q is not KEY=VALUE with one of the keys of .mtfs/config\n", arg)
+			return 2
+		}
+		if _, seen := set[key]; !seen {
+			order = append(order, key)
+			set[key] = nil
+		}
+		if value != "" {
+			set[key] = append(set[key], value)
+		}
+	}
+	var kept []string
+	for _, line := range lines {
+		key, _, _ := strings.Cut(line, "=")
+		if _, replaced := set[strings.TrimSpace(key)]; !replaced {
+			kept = append(kept, line)
+		}
+	}
+	for _, key := range order {
+		for _, value := range set[key] {
+			kept = append(kept, key+" = "+value)
+		}
+	}
+	if err := state.WriteFile(t.Path, state.ConfigFile, []byte(strings.Join(kept, "\n")+"\n")); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Updated %s; the next build of %s uses it\n", state.Path(t.Path, state.ConfigFile), t.Name)
+	return 0
+}
+
+func exportState(args []string) int {
+	flags := flag.NewFlagSet("export-state", flag.ExitOnError)
+	out := flags.String("o", "", "bundle file to write (default: NAME.mtfs-state.tar.gz)")
+	withStore := flags.Bool("with-store", false, "also bundle the chunk store the tree was last stored in")
+	flags.Parse(args)
+	tree, err := paths.Abs(treeArg(flags, 0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Tree = tree
+
+	info := statebundle.Info{Name: filepath.Base(tree), Tree: tree, HashFormat: state.HashFormat(tree), Created: time.Now().UTC().Format(time.RFC3339)}
+	info.Host, _ = os.Hostname()
+	known, err := registry.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: registry not read: %v\n", err)
+	}
+	for _, t := range known {
+		if paths.Same(t.Path, tree) {
+			info.Name, info.RootHash, info.Stores = t.Name, t.RootHash, t.Stores
+		}
+	}
+	operation.RootHash = info.RootHash
+	storeDir := ""
+	if *withStore {
+		storeDir = registry.Tree{Stores: info.Stores}.Store()
+		switch {
+		case storeDir == "":
+			fmt.Fprintf(os.Stderr, "Error: no store is known for %s; store it from the TUI first\n", tree)
+			return 1
+		case strings.Contains(storeDir, "://"):
+			fmt.Fprintf(os.Stderr, "Error: %s is a bucket; it can be reached from the other machine as it is\n", storeDir)
+			return 1
+		}
+	}
+
+	// The history of the tree is what was imported with it before, then
+	// what was done to it here
+	history, err := statebundle.ReadHistory(tree)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if name, err := oplog.Path(); err == nil && name != "" {
+		entries, err := oplog.Read(name)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Warning: operation log not read: %v\n", err)
+		}
+		for _, e := range entries {
+			if e.Tree == tree {
+				history = append(history, e)
+			}
+		}
+	}
+
+	if *out == "" {
+		*out = info.Name + ".mtfs-state.tar.gz"
+	}
+	if err := forensic.CheckOutput(tree, *out); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	f, err := os.Create(*out)
+	if err == nil {
+		err = statebundle.Export(f, info, tree, history, storeDir)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(*out)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Exported the state of %s (%d history entries) to %s\n", tree, len(history), *out)
+	if storeDir != "" {
+		fmt.Printf("The chunk store %s is included\n", storeDir)
+	}
+	return 0
+}
+
+func importState(args []string) int {
+	flags := flag.NewFlagSet("import-state", flag.ExitOnError)
+	storeDir := flags.String("store", "", "directory to put a bundled chunk store in")
+	name := flags.String("name", "", "name to register the tree under (default: its name on the exporting machine)")
+	force := flags.Bool("force", false, "replace state files the tree has already")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no bundle given")
+		return 2
+	}
+	tree := "."
+	if flags.NArg() > 1 {
+		tree = flags.Arg(1)
+	}
+	tree, err := paths.Abs(tree)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.Tree = tree
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+	info, history, err := statebundle.Import(f, statebundle.Options{Tree: tree, StoreDir: *storeDir, Force: *force})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	operation.RootHash = info.RootHash
+	fmt.Printf("Imported the state of %s from %s (%d history entries) into %s\n", info.Tree, info.Host, len(history), tree)
+
+	if *name == "" {
+		*name = info.Name
+	}
+	t, err := registry.Add(tree, *name)
+	if err != nil {
+		// Another tree may have the name here
+		t, err = registry.Add(tree, "")
+	}
+	if err == nil {
+		store := ""
+		if info.Store {
+			store = *storeDir
+		} else if len(info.Stores) > 0 && strings.Contains(info.Stores[0], "://") {
+			store = info.Stores[0]
+		}
+		err = registry.Record(tree, info.RootHash, store)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s not registered: %v\n", tree, err)
+	} else {
+		fmt.Printf("Registered as %s\n", t.Name)
+	}
+
+	if info.RootHash == "" {
+		return 0
+	}
+	root, err := remote.Build(tree)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s could not be built to compare it with the exported root hash: %v\n", tree, err)
+		return 1
+	}
+	if root.Hash != info.RootHash {
+		fmt.Printf("%s has root %s, not the exported %s\n", tree, root.Hash, info.RootHash)
+		return 1
+	}
+	fmt.Printf("%s has the exported root hash %s\n", tree, root.Hash)
+	return 0
+}
@@ -0,0 +1,189 @@
+// Package trends keeps the history of a tree's size and churn: every
+// build, daemon scan and snapshot verification notes how many files and
+// bytes the tree held and, when it is known, how many paths changed since
+// the operation before, in the state directory, so the TUI can chart
+// them and unexpected growth or churn stands out.
+package trends
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"MTFS/state"
+)
+
+// File is the name of the history inside the state directory.
+const File = "trends"
+
+const (
+	header = "MTFS-TRENDS 1"
+	// keep is how many samples the history holds.
+	keep = 1000
+)
+
+// Sample is what an operation found. Counts it did not establish are -1.
+type Sample struct {
+	Time      time.Time
+	Operation string // build, scan or verify
+	Files     int64
+	Bytes     int64
+	Changed   int64 // paths added, removed or modified since the operation before
+}
+
+// Record adds s to the history of tree, dropping the oldest samples
+// beyond keep.
+func Record(tree string, s Sample) error {
+	history, err := Load(tree)
+	if err != nil {
+		return err
+	}
+	history = append(history, s)
+	if len(history) > keep {
+		history = history[len(history)-keep:]
+	}
+	var b strings.Builder
+	b.WriteString(header + "\n")
+	for _, s := range history {
+		fmt.Fprintf(&b, "%s\t%s\t%d\t%d\t%d\n", s.Time.UTC().Format(time.RFC3339), s.Operation, s.Files, s.Bytes, s.Changed)
+	}
+	return state.WriteFile(tree, File, []byte(b.String()))
+}
+
+// Load reads the history of tree, oldest first.
+func Load(tree string) ([]Sample, error) {
+	data, err := state.ReadFile(tree, File)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if lines[0] != header {
+		return nil, fmt.Errorf("%s: not a trend history", state.Path(tree, File))
+	}
+	var history []Sample
+	for _, line := range lines[1:] {
+		var s Sample
+		var when string
+		if _, err := fmt.Sscanf(line, "%s\t%s\t%d\t%d\t%d", &when, &s.Operation, &s.Files, &s.Bytes, &s.Changed); err != nil {
+			continue
+		}
+		if s.Time, err = time.Parse(time.RFC3339, when); err != nil {
+			continue
+		}
+		history = append(history, s)
+	}
+	return history, nil
+}
+
+// Series is one measure of the samples that established it, oldest
+// first.
+type Series struct {
+	Times  []time.Time
+	Values []float64
+}
+
+// Of picks a measure out of history, leaving out the samples without it.
+func Of(history []Sample, measure func(Sample) int64) Series {
+	var s Series
+	for _, sample := range history {
+		if v := measure(sample); v >= 0 {
+			s.Times = append(s.Times, sample.Time)
+			s.Values = append(s.Values, float64(v))
+		}
+	}
+	return s
+}
+
+// Last returns the newest n values of s.
+func (s Series) Last(n int) Series {
+	if len(s.Values) <= n {
+		return s
+	}
+	return Series{s.Times[len(s.Times)-n:], s.Values[len(s.Values)-n:]}
+}
+
+// Sparkline draws values as a line of block characters scaled to their
+// range, as the backend's statistics do.
+func Sparkline(values []float64) string {
+	blocks := []rune("▁▂▃▄▅▆▇█")
+	if len(values) == 0 {
+		return ""
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	line := make([]rune, len(values))
+	for i, v := range values {
+		level := 3
+		if hi > lo {
+			level = int((v-lo)/(hi-lo)*7 + 0.5)
+		}
+		line[i] = blocks[level]
+	}
+	return string(line)
+}
+
+// Bar draws value as a bar of up to width cells, scaled to top.
+func Bar(value, top float64, width int) string {
+	filled := 0
+	if top > 0 {
+		filled = int(value/top*float64(width) + 0.5)
+	}
+	if value > 0 && filled == 0 {
+		filled = 1
+	}
+	return strings.Repeat("█", filled) + strings.Repeat(" ", width-filled)
+}
+
+// UnusualFactor is how many times the typical step the last step of a
+// series must be to stand out.
+const UnusualFactor = 3
+
+// Unusual reports whether the last step of values, its change from the
+// value before when steps is set, or the last value itself otherwise, is
+// more than UnusualFactor times the average of those before it. It needs
+// a few earlier ones to compare with, and steps of less than a percent of
+// the value never stand out.
+func Unusual(values []float64, steps bool) bool {
+	if steps {
+		if len(values) < 2 || math.Abs(values[len(values)-1]-values[len(values)-2]) < values[len(values)-2]/100 {
+			return false
+		}
+		deltas := make([]float64, len(values)-1)
+		for i := range deltas {
+			deltas[i] = math.Abs(values[i+1] - values[i])
+		}
+		values = deltas
+	}
+	if len(values) < 4 {
+		return false
+	}
+	last, before := values[len(values)-1], values[:len(values)-1]
+	var sum float64
+	for _, v := range before {
+		sum += v
+	}
+	typical := sum / float64(len(before))
+	return last > 0 && last > UnusualFactor*math.Max(typical, 1)
+}
+
+// FormatChange writes the change from a to b as "+12" or "-3", with the
+// relative change when a is not zero.
+func FormatChange(a, b float64, format func(float64) string) string {
+	sign := "+"
+	if b < a {
+		sign = "-"
+	}
+	text := sign + format(math.Abs(b-a))
+	if a != 0 {
+		text += " (" + sign + strconv.FormatFloat(math.Abs(b-a)/a*100, 'f', 1, 64) + "%)"
+	}
+	return text
+}
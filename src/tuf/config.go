@@ -0,0 +1,182 @@
+package tuf
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"MTFS/forensic"
+	"MTFS/state"
+)
+
+// ConfigFile is the name of the TUF settings inside .mtfs.
+const ConfigFile = "tuf.json"
+
+// Roles are the top-level roles generated, in signing order.
+var Roles = []string{"root", "targets", "snapshot", "timestamp"}
+
+// Duration is a time.Duration that also accepts a "d" (days) suffix in
+// JSON, e.g. "365d" or "12h".
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		*d = Duration(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// RoleConfig configures the keys and expiry of one role.
+type RoleConfig struct {
+	// Expires is how long freshly generated metadata stays valid.
+	Expires Duration `json:"expires"`
+	// Keys are PEM ed25519 private keys, relative to the keys directory.
+	// Missing key files are generated.
+	Keys      []string `json:"keys"`
+	Threshold int      `json:"threshold"`
+}
+
+// Config is read from .mtfs/tuf.json; every field is optional.
+type Config struct {
+	// Output is where metadata is written, relative to the tree.
+	Output string `json:"output"`
+	// KeysDir holds the role keys, relative to the tree unless absolute.
+	KeysDir string                `json:"keys_dir"`
+	Roles   map[string]RoleConfig `json:"roles"`
+}
+
+var defaultExpiry = map[string]time.Duration{
+	"root":      365 * 24 * time.Hour,
+	"targets":   90 * 24 * time.Hour,
+	"snapshot":  7 * 24 * time.Hour,
+	"timestamp": 24 * time.Hour,
+}
+
+// LoadConfig reads the TUF settings of tree and fills in defaults.
+func LoadConfig(tree string) (*Config, error) {
+	cfg := &Config{}
+	data, err := state.ReadFile(tree, ConfigFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("tuf: %s: %w", ConfigFile, err)
+		}
+	}
+
+	// In forensic mode the state directory is outside the tree
+	stateDir := state.DirName
+	if forensic.Dir() != "" {
+		stateDir = state.Dir(tree)
+	}
+	if cfg.Output == "" {
+		cfg.Output = filepath.Join(stateDir, "tuf", "metadata")
+	}
+	if cfg.KeysDir == "" {
+		cfg.KeysDir = filepath.Join(stateDir, "tuf", "keys")
+	}
+	if cfg.Roles == nil {
+		cfg.Roles = map[string]RoleConfig{}
+	}
+	for _, role := range Roles {
+		rc := cfg.Roles[role]
+		if rc.Expires <= 0 {
+			rc.Expires = Duration(defaultExpiry[role])
+		}
+		if len(rc.Keys) == 0 {
+			rc.Keys = []string{role + ".key"}
+		}
+		if rc.Threshold <= 0 {
+			rc.Threshold = 1
+		}
+		if rc.Threshold > len(rc.Keys) {
+			return nil, fmt.Errorf("tuf: role %s has threshold %d but only %d keys", role, rc.Threshold, len(rc.Keys))
+		}
+		cfg.Roles[role] = rc
+	}
+	return cfg, nil
+}
+
+// resolve makes p relative to tree unless it is absolute.
+func resolve(tree, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(tree, p)
+}
+
+// loadKey reads an ed25519 private key, generating it if the file does
+// not exist yet.
+func loadKey(path string) (ed25519.PrivateKey, error) {
+	key, err := readKey(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return generateKey(path)
+	}
+	return key, err
+}
+
+// readKey reads an ed25519 private key.
+func readKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("tuf: %s is not a PEM key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("tuf: %s: %w", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("tuf: %s is not an ed25519 key", path)
+	}
+	return priv, nil
+}
+
+func generateKey(path string) (ed25519.PrivateKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
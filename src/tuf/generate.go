@@ -0,0 +1,273 @@
+package tuf
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"time"
+
+	"MTFS/forensic"
+	"MTFS/manifest"
+)
+
+// Result summarises a generation run.
+type Result struct {
+	Dir      string
+	Targets  int
+	Versions map[string]int
+	// RootChanged is set when root.json was (re)written.
+	RootChanged bool
+}
+
+// Generate writes signed TUF metadata for the files of the tree described
+// by root. Targets, snapshot and timestamp get a new version on every run;
+// root only when its keys or thresholds change or it would expire before
+// the new targets metadata. A root with other keys is also signed by the
+// old root keys, which must still be in the keys directory. The files are
+// read again for their SHA-256, and must not have changed since the build.
+func Generate(tree string, root *manifest.Node, now time.Time) (*Result, error) {
+	cfg, err := LoadConfig(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	out := resolve(tree, cfg.Output)
+	for _, dir := range []string{out, resolve(tree, cfg.KeysDir)} {
+		if err := forensic.CheckOutput(tree, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := map[string][]ed25519.PrivateKey{}
+	for _, role := range Roles {
+		for _, name := range cfg.Roles[role].Keys {
+			key, err := loadKey(filepath.Join(resolve(tree, cfg.KeysDir), name))
+			if err != nil {
+				return nil, err
+			}
+			keys[role] = append(keys[role], key)
+		}
+	}
+
+	if err := os.MkdirAll(out, 0o755); err != nil {
+		return nil, err
+	}
+	res := &Result{Dir: out, Versions: map[string]int{}}
+
+	// root.json
+	rootMeta := Root{
+		Type:        "root",
+		SpecVersion: SpecVersion,
+		Keys:        map[string]Key{},
+		Roles:       map[string]Role{},
+	}
+	for _, role := range Roles {
+		r := Role{Threshold: cfg.Roles[role].Threshold, KeyIDs: []string{}}
+		for _, key := range keys[role] {
+			pub := publicKey(key.Public().(ed25519.PublicKey))
+			rootMeta.Keys[pub.ID()] = pub
+			r.KeyIDs = append(r.KeyIDs, pub.ID())
+		}
+		rootMeta.Roles[role] = r
+	}
+	var prevRoot Root
+	prevVersion := previousVersion(out, "root", &prevRoot)
+	rootMeta.Version = prevVersion
+	// Root is also re-signed when targets signed now could outlive it
+	targetsExpiry := expires(now, time.Duration(cfg.Roles["targets"].Expires))
+	if prevVersion == 0 || !sameRoot(prevRoot, rootMeta) || prevRoot.Expires < targetsExpiry {
+		rootMeta.Version = prevVersion + 1
+		rootMeta.Expires = expires(now, time.Duration(cfg.Roles["root"].Expires))
+		signers := keys["root"]
+		if prevVersion > 0 && !sameRoot(prevRoot, rootMeta) {
+			// Clients only trust a new root signed by the old one too
+			old, err := oldRootKeys(resolve(tree, cfg.KeysDir), prevRoot)
+			if err != nil {
+				return nil, err
+			}
+			signers = signersOf(signers, old)
+		}
+		data, err := sign(rootMeta, signers)
+		if err != nil {
+			return nil, err
+		}
+		// Clients walk root versions one at a time, so every version is
+		// kept as N.root.json as well.
+		if err := write(out, fmt.Sprintf("%d.root.json", rootMeta.Version), data); err != nil {
+			return nil, err
+		}
+		if err := write(out, "root.json", data); err != nil {
+			return nil, err
+		}
+		res.RootChanged = true
+	}
+	res.Versions["root"] = rootMeta.Version
+
+	// targets.json
+	targets := Targets{
+		Type:        "targets",
+		SpecVersion: SpecVersion,
+		Version:     previousVersion(out, "targets", nil) + 1,
+		Expires:     targetsExpiry,
+		Targets:     map[string]TargetFile{},
+	}
+	err = root.Walk(func(p string, node *manifest.Node) error {
+		if !node.IsFile() || p == "." {
+			return nil
+		}
+		sum, err := fileHash(filepath.Join(tree, filepath.FromSlash(p)), node)
+		if err != nil {
+			return fmt.Errorf("tuf: %s: %w", p, err)
+		}
+		targets.Targets[p] = TargetFile{Length: node.Size, Hashes: map[string]string{"sha256": sum}}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	targetsData, err := sign(targets, keys["targets"])
+	if err != nil {
+		return nil, err
+	}
+	if err := write(out, "targets.json", targetsData); err != nil {
+		return nil, err
+	}
+	res.Targets = len(targets.Targets)
+	res.Versions["targets"] = targets.Version
+
+	// snapshot.json
+	snapshot := Meta{
+		Type:        "snapshot",
+		SpecVersion: SpecVersion,
+		Version:     previousVersion(out, "snapshot", nil) + 1,
+		Expires:     expires(now, time.Duration(cfg.Roles["snapshot"].Expires)),
+		Meta:        map[string]MetaFile{"targets.json": describe(targets.Version, targetsData)},
+	}
+	snapshotData, err := sign(snapshot, keys["snapshot"])
+	if err != nil {
+		return nil, err
+	}
+	if err := write(out, "snapshot.json", snapshotData); err != nil {
+		return nil, err
+	}
+	res.Versions["snapshot"] = snapshot.Version
+
+	// timestamp.json is never versioned in its file name
+	timestamp := Meta{
+		Type:        "timestamp",
+		SpecVersion: SpecVersion,
+		Version:     previousVersion(out, "timestamp", nil) + 1,
+		Expires:     expires(now, time.Duration(cfg.Roles["timestamp"].Expires)),
+		Meta:        map[string]MetaFile{"snapshot.json": describe(snapshot.Version, snapshotData)},
+	}
+	timestampData, err := sign(timestamp, keys["timestamp"])
+	if err != nil {
+		return nil, err
+	}
+	if err := write(out, "timestamp.json", timestampData); err != nil {
+		return nil, err
+	}
+	res.Versions["timestamp"] = timestamp.Version
+
+	return res, nil
+}
+
+// fileHash returns the SHA-256 of the file name, which node describes.
+// The content hash of a tree is only plain SHA-256 in some hash formats,
+// so the file is read again, and checked against node.
+func fileHash(name string, node *manifest.Node) (string, error) {
+	file, err := forensic.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	check, sum := node.NewContentCheck(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(check, sum), file); err != nil {
+		return "", err
+	}
+	if !check.Matches(node.ContentHash) {
+		return "", errors.New("changed since the tree was built")
+	}
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+// oldRootKeys returns the keys of dir that signed prev, the root being
+// replaced, and fails unless there are as many as its threshold.
+func oldRootKeys(dir string, prev Root) ([]ed25519.PrivateKey, error) {
+	role := prev.Roles["root"]
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	var old []ed25519.PrivateKey
+	for _, name := range names {
+		key, err := readKey(name)
+		if err != nil {
+			continue
+		}
+		if slices.Contains(role.KeyIDs, publicKey(key.Public().(ed25519.PublicKey)).ID()) {
+			old = append(old, key)
+		}
+	}
+	if len(old) < role.Threshold {
+		return nil, fmt.Errorf("tuf: the root keys changed, and the new root.json must also be signed by %d of the old root keys, but %s holds %d of them",
+			role.Threshold, dir, len(old))
+	}
+	return old, nil
+}
+
+// signersOf returns keys followed by those of more it does not hold.
+func signersOf(keys, more []ed25519.PrivateKey) []ed25519.PrivateKey {
+	signers := slices.Clone(keys)
+	for _, key := range more {
+		if !slices.ContainsFunc(signers, func(k ed25519.PrivateKey) bool { return k.Equal(key) }) {
+			signers = append(signers, key)
+		}
+	}
+	return signers
+}
+
+func describe(version int, data []byte) MetaFile {
+	sum := sha256.Sum256(data)
+	return MetaFile{Version: version, Length: int64(len(data)), Hashes: map[string]string{"sha256": hex.EncodeToString(sum[:])}}
+}
+
+// previousVersion returns the version of the existing role metadata in
+// dir, or 0. When signed is not nil the signed part is decoded into it.
+func previousVersion(dir, role string, signed any) int {
+	data, err := os.ReadFile(filepath.Join(dir, role+".json"))
+	if err != nil {
+		return 0
+	}
+	var env struct {
+		Signed json.RawMessage `json:"signed"`
+	}
+	var header struct {
+		Version int `json:"version"`
+	}
+	if json.Unmarshal(data, &env) != nil || json.Unmarshal(env.Signed, &header) != nil {
+		return 0
+	}
+	if signed != nil {
+		json.Unmarshal(env.Signed, signed)
+	}
+	return header.Version
+}
+
+func sameRoot(a, b Root) bool {
+	return reflect.DeepEqual(a.Keys, b.Keys) &&
+		reflect.DeepEqual(a.Roles, b.Roles)
+}
+
+func write(dir, name string, data []byte) error {
+	return os.WriteFile(filepath.Join(dir, name), data, 0o644)
+}
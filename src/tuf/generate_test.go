@@ -0,0 +1,215 @@
+package tuf
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"MTFS/manifest"
+	"MTFS/pkg/merkle"
+	"MTFS/state"
+)
+
+var now = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// buildTree writes files to a new tree, builds it in format and returns
+// the tree and its export.
+func buildTree(t *testing.T, format string, files map[string]string) (string, *manifest.Node) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o := merkle.DefaultOptions()
+	f, err := merkle.ParseHashFormat(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.HashFormat = f
+	b := merkle.NewBuilder()
+	b.Options = &o
+	tree, err := b.Build(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root, err := manifest.Parse([]byte(tree.JSON()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, root
+}
+
+func writeConfig(t *testing.T, tree string, cfg string) {
+	t.Helper()
+	if err := state.WriteFile(tree, ConfigFile, []byte(cfg)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readRole reads the metadata of role written for tree, checks it is
+// signed by the threshold of the keys root trusts for it and decodes its
+// signed part into signed.
+func readRole(t *testing.T, tree string, name string, root Root, role string, signed any) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(tree, state.DirName, "tuf", "metadata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyRole(data, root, role); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	var env struct {
+		Signed json.RawMessage `json:"signed"`
+	}
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(env.Signed, signed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// verifyRole checks that data, a metadata file, is signed by the threshold
+// of the keys root trusts for role, as a TUF client does.
+func verifyRole(data []byte, root Root, role string) error {
+	var env struct {
+		Signatures []Signature      `json:"signatures"`
+		Signed     *json.RawMessage `json:"signed"`
+	}
+	if err := json.Unmarshal(data, &env); err != nil {
+		return err
+	}
+	var signed any
+	if err := json.Unmarshal(*env.Signed, &signed); err != nil {
+		return err
+	}
+	msg, err := canonical(signed)
+	if err != nil {
+		return err
+	}
+	valid := map[string]bool{}
+	for _, sig := range env.Signatures {
+		key, trusted := root.Keys[sig.KeyID]
+		if !trusted || !slices.Contains(root.Roles[role].KeyIDs, sig.KeyID) {
+			continue
+		}
+		pub, _ := hex.DecodeString(key.KeyVal["public"])
+		raw, _ := hex.DecodeString(sig.Sig)
+		if ed25519.Verify(pub, msg, raw) {
+			valid[sig.KeyID] = true
+		}
+	}
+	if threshold := root.Roles[role].Threshold; len(valid) < threshold {
+		return fmt.Errorf("%d valid signatures, %d needed", len(valid), threshold)
+	}
+	return nil
+}
+
+// readRoot reads the root metadata written for tree, which must be signed
+// by the keys it lists itself.
+func readRoot(t *testing.T, tree string, name string) Root {
+	t.Helper()
+	var root Root
+	readRole(t, tree, name, Root{}, "root", &root)
+	readRole(t, tree, name, root, "root", &root)
+	return root
+}
+
+func TestGenerateListsSHA256(t *testing.T) {
+	want := sha256.Sum256([]byte("hi\n"))
+	for _, format := range []string{"mtfs", "git", "git-sha256", "mtfs-sha512", "mtfs-blake2b", "mtfs-blake3"} {
+		t.Run(format, func(t *testing.T) {
+			tree, root := buildTree(t, format, map[string]string{"a": "hi\n", "b": ""})
+			if _, err := Generate(tree, root, now); err != nil {
+				t.Fatal(err)
+			}
+			rootMeta := readRoot(t, tree, "root.json")
+			var targets Targets
+			readRole(t, tree, "targets.json", rootMeta, "targets", &targets)
+			got := targets.Targets["a"]
+			if got.Length != 3 || got.Hashes["sha256"] != hex.EncodeToString(want[:]) {
+				t.Errorf("a: got %+v, want length 3 and sha256 %x", got, want)
+			}
+			empty := sha256.Sum256(nil)
+			if got := targets.Targets["b"].Hashes["sha256"]; got != hex.EncodeToString(empty[:]) {
+				t.Errorf("b: got sha256 %s, want %x", got, empty)
+			}
+		})
+	}
+}
+
+func TestGenerateRefusesChangedFile(t *testing.T) {
+	tree, root := buildTree(t, "mtfs", map[string]string{"a": "hi\n"})
+	if err := os.WriteFile(filepath.Join(tree, "a"), []byte("HO\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Generate(tree, root, now); err == nil || !strings.Contains(err.Error(), "changed since") {
+		t.Fatalf("got %v, want the changed file refused", err)
+	}
+}
+
+func TestRotatedRootSignedByOldKeys(t *testing.T) {
+	tree, root := buildTree(t, "mtfs", map[string]string{"a": "hi\n"})
+	if _, err := Generate(tree, root, now); err != nil {
+		t.Fatal(err)
+	}
+	v1 := readRoot(t, tree, "root.json")
+
+	writeConfig(t, tree, `{"roles": {"root": {"keys": ["root2.key"]}}}`)
+	res, err := Generate(tree, root, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.RootChanged || res.Versions["root"] != 2 {
+		t.Fatalf("got %+v, want root version 2 written", res)
+	}
+	data, err := os.ReadFile(filepath.Join(tree, state.DirName, "tuf", "metadata", "2.root.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A client trusting version 1 accepts version 2, which then vouches
+	// for itself
+	if err := verifyRole(data, v1, "root"); err != nil {
+		t.Errorf("old root keys: %v", err)
+	}
+	var v2 Root
+	readRole(t, tree, "2.root.json", v1, "root", &v2)
+	if err := verifyRole(data, v2, "root"); err != nil {
+		t.Errorf("new root keys: %v", err)
+	}
+
+	// Tampered with, it is neither
+	tampered := strings.Replace(string(data), `"version":2`, `"version":3`, 1)
+	if tampered == string(data) {
+		t.Fatal("no version to tamper with")
+	}
+	for _, trusted := range []Root{v1, v2} {
+		if err := verifyRole([]byte(tampered), trusted, "root"); err == nil {
+			t.Error("tampered root.json verified")
+		}
+	}
+}
+
+func TestRotationNeedsOldRootKeys(t *testing.T) {
+	tree, root := buildTree(t, "mtfs", map[string]string{"a": "hi\n"})
+	if _, err := Generate(tree, root, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(tree, state.DirName, "tuf", "keys", "root.key")); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(t, tree, `{"roles": {"root": {"keys": ["root2.key"]}}}`)
+	if _, err := Generate(tree, root, now); err == nil || !strings.Contains(err.Error(), "old root keys") {
+		t.Fatalf("got %v, want the rotation refused without the old root key", err)
+	}
+}
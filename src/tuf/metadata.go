@@ -0,0 +1,143 @@
+// Package tuf generates The Update Framework metadata (root, targets,
+// snapshot and timestamp roles) for a built tree, so its files can be
+// served to TUF clients. Metadata is signed with ed25519 keys.
+package tuf
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// SpecVersion is the TUF specification version the metadata declares.
+const SpecVersion = "1.0.31"
+
+// Key is a public key as listed in root metadata.
+type Key struct {
+	KeyType string            `json:"keytype"`
+	Scheme  string            `json:"scheme"`
+	KeyVal  map[string]string `json:"keyval"`
+}
+
+func publicKey(pub ed25519.PublicKey) Key {
+	return Key{KeyType: "ed25519", Scheme: "ed25519", KeyVal: map[string]string{"public": hex.EncodeToString(pub)}}
+}
+
+// ID returns the key ID: the SHA-256 of the key's canonical JSON.
+func (k Key) ID() string {
+	data, err := canonical(k)
+	if err != nil {
+		panic(err) // a Key always encodes
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Role lists the keys trusted for a role and how many must sign.
+type Role struct {
+	KeyIDs    []string `json:"keyids"`
+	Threshold int      `json:"threshold"`
+}
+
+// Root is the signed part of root.json.
+type Root struct {
+	Type               string          `json:"_type"`
+	SpecVersion        string          `json:"spec_version"`
+	Version            int             `json:"version"`
+	Expires            string          `json:"expires"`
+	ConsistentSnapshot bool            `json:"consistent_snapshot"`
+	Keys               map[string]Key  `json:"keys"`
+	Roles              map[string]Role `json:"roles"`
+}
+
+// TargetFile describes one file of the tree.
+type TargetFile struct {
+	Length int64             `json:"length"`
+	Hashes map[string]string `json:"hashes"`
+}
+
+// Targets is the signed part of targets.json.
+type Targets struct {
+	Type        string                `json:"_type"`
+	SpecVersion string                `json:"spec_version"`
+	Version     int                   `json:"version"`
+	Expires     string                `json:"expires"`
+	Targets     map[string]TargetFile `json:"targets"`
+}
+
+// MetaFile describes another metadata file.
+type MetaFile struct {
+	Version int               `json:"version"`
+	Length  int64             `json:"length,omitempty"`
+	Hashes  map[string]string `json:"hashes,omitempty"`
+}
+
+// Meta is the signed part of snapshot.json and timestamp.json.
+type Meta struct {
+	Type        string              `json:"_type"`
+	SpecVersion string              `json:"spec_version"`
+	Version     int                 `json:"version"`
+	Expires     string              `json:"expires"`
+	Meta        map[string]MetaFile `json:"meta"`
+}
+
+// Signature is one signature over the canonical signed part.
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// Envelope is a metadata file as written to disk.
+type Envelope struct {
+	Signatures []Signature `json:"signatures"`
+	Signed     any         `json:"signed"`
+}
+
+// expires formats the expiry as TUF requires: UTC, second precision.
+func expires(now time.Time, d time.Duration) string {
+	return now.Add(d).UTC().Truncate(time.Second).Format(time.RFC3339)
+}
+
+// sign signs the canonical form of signed with every key and returns the
+// metadata file contents.
+func sign(signed any, keys []ed25519.PrivateKey) ([]byte, error) {
+	msg, err := canonical(signed)
+	if err != nil {
+		return nil, err
+	}
+	env := Envelope{Signed: signed, Signatures: []Signature{}}
+	for _, key := range keys {
+		env.Signatures = append(env.Signatures, Signature{
+			KeyID: publicKey(key.Public().(ed25519.PublicKey)).ID(),
+			Sig:   hex.EncodeToString(ed25519.Sign(key, msg)),
+		})
+	}
+	return canonical(env)
+}
+
+// canonical encodes v as canonical JSON: object keys sorted, no
+// insignificant whitespace and no HTML escaping.
+func canonical(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	// Round-trip through generic values so struct fields are sorted too
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var generic any
+	if err := dec.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(generic); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
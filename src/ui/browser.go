@@ -0,0 +1,270 @@
+package ui
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"MTFS/backend"
+	"MTFS/protocol"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// browserPage shows the tree as an outline that directories expand in.
+const browserPage = "browser"
+
+// browserBatch is how many entries of a directory are added at a time, so
+// expanding one with very many does not hold up the UI; the rest are
+// added when the "more" node after them is selected.
+const browserBatch = 500
+
+// browserEntry is what a node of the browser stands for: an entry of the
+// tree, or the entries of dir not added yet.
+type browserEntry struct {
+	entry   protocol.ListEntry
+	path    string // slash-separated path in the tree, "." for the root
+	dir     *tview.TreeNode
+	listing []protocol.ListEntry // entries of a directory, once listed
+	listed  bool
+	loading bool // the listing was asked for
+	loaded  int  // entries of listing added to its tree node
+	more    bool
+}
+
+// printTree browses the tree built. Only the root is listed at first;
+// each directory is listed by the backend when it is first expanded on
+// Enter, so the tree is never exported as a whole.
+func (tui *MerkleTUI) printTree() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "browse"
+	tui.updateStatus("Loading tree...")
+	tui.writeOutput("[yellow]═══ Tree Structure ═══[white]")
+	var res *protocol.ListResult
+	tui.call(func(b backend.Backend) (err error) {
+		res, err = b.List(".")
+		return err
+	}, func(err error) {
+		tui.currentAction = ""
+		if err != nil {
+			tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", tview.Escape(err.Error())))
+			tui.updateStatus("Loading tree failed")
+			return
+		}
+		tui.showWarnings(res.Warnings)
+		tui.updateStatus("Ready")
+		tui.showBrowser(res)
+	})
+}
+
+// showBrowser lists root, the listing of the root directory, in a tree
+// view. Enter expands or collapses a directory and shows the details of
+// other entries in the output pane, i shows those of any entry, and p
+// prints the whole tree there as text.
+func (tui *MerkleTUI) showBrowser(root *protocol.ListResult) {
+	newNode := func(e protocol.ListEntry, p string) *tview.TreeNode {
+		node := tview.NewTreeNode(tui.browserLabel(e)).SetReference(&browserEntry{entry: e, path: p})
+		if !tui.plain {
+			node.SetColor(browserColor(e))
+		}
+		return node
+	}
+	// load adds the next batch of the listed entries of the directory node
+	// stands for and returns the first of them
+	load := func(node *tview.TreeNode) *tview.TreeNode {
+		dir := node.GetReference().(*browserEntry)
+		end := min(dir.loaded+browserBatch, len(dir.listing))
+		var first *tview.TreeNode
+		for _, e := range dir.listing[dir.loaded:end] {
+			added := newNode(e, path.Join(dir.path, e.Name))
+			if first == nil {
+				first = added
+			}
+			node.AddChild(added)
+		}
+		dir.loaded = end
+		if rest := len(dir.listing) - end; rest > 0 {
+			more := tview.NewTreeNode(fmt.Sprintf("… %s more entries", groupDigits(int64(rest)))).
+				SetReference(&browserEntry{entry: dir.entry, path: dir.path, dir: node, more: true})
+			node.AddChild(more)
+		}
+		return first
+	}
+
+	rootNode := newNode(root.Directory, ".")
+	rootEntry := rootNode.GetReference().(*browserEntry)
+	rootEntry.listing, rootEntry.listed = root.Entries, true
+	load(rootNode)
+	view := tview.NewTreeView().SetRoot(rootNode).SetCurrentNode(rootNode)
+	// expand lists the directory node stands for, unless it was, and
+	// shows its first entries
+	expand := func(node *tview.TreeNode) {
+		dir := node.GetReference().(*browserEntry)
+		if dir.listed {
+			load(node)
+			node.SetExpanded(true)
+			return
+		}
+		if dir.loading {
+			return
+		}
+		dir.loading = true
+		tui.updateStatus(fmt.Sprintf("Listing %s...", dir.path))
+		var res *protocol.ListResult
+		tui.call(func(b backend.Backend) (err error) {
+			res, err = b.List(dir.path)
+			return err
+		}, func(err error) {
+			dir.loading = false
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", tview.Escape(err.Error())))
+				tui.updateStatus("Listing failed")
+				return
+			}
+			tui.showWarnings(res.Warnings)
+			dir.listing, dir.listed = res.Entries, true
+			load(node)
+			node.SetExpanded(true)
+			tui.updateStatus(fmt.Sprintf("%s  %s", dir.path, dir.entry.Hash))
+		})
+	}
+	view.SetSelectedFunc(func(node *tview.TreeNode) {
+		entry, ok := node.GetReference().(*browserEntry)
+		switch {
+		case !ok:
+		case entry.more:
+			// Replaced by the next batch, and another "more" node if need be
+			entry.dir.RemoveChild(node)
+			view.SetCurrentNode(load(entry.dir))
+		case entry.entry.Type == "directory" && entry.entry.Entries > 0:
+			if entry.loaded == 0 {
+				expand(node)
+			} else {
+				node.SetExpanded(!node.IsExpanded())
+			}
+		default:
+			tui.showEntry(entry.path, entry.entry)
+		}
+	})
+	view.SetChangedFunc(func(node *tview.TreeNode) {
+		if entry, ok := node.GetReference().(*browserEntry); ok && !entry.more {
+			tui.updateStatus(fmt.Sprintf("%s  %s", entry.path, entry.entry.Hash))
+		}
+	})
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape:
+			tui.closePage(browserPage)
+			tui.updateStatus("Ready")
+			return nil
+		case event.Key() == tcell.KeyRune && event.Rune() == 'i':
+			if entry, ok := view.GetCurrentNode().GetReference().(*browserEntry); ok && !entry.more {
+				tui.showEntry(entry.path, entry.entry)
+			}
+			return nil
+		case event.Key() == tcell.KeyRune && event.Rune() == 'p':
+			tui.closePage(browserPage)
+			tui.currentAction = "print_tree"
+			tui.updateStatus("Printing tree structure...")
+			tui.report(backend.Backend.PrintTree, tui.processPrintTreeOutput)
+			return nil
+		}
+		return event
+	})
+	view.SetBorder(true).SetTitle(fmt.Sprintf("%s: %s (Enter: expand, i: details, p: print as text, Esc: close)",
+		tview.Escape(root.Directory.Name), tui.size(root.Directory.Size)))
+	tui.pages.AddPage(browserPage, view, true, true)
+	tui.app.SetFocus(view)
+}
+
+// browserLabel names e in the browser with its size and abbreviated hash.
+func (tui *MerkleTUI) browserLabel(e protocol.ListEntry) string {
+	name := e.Name
+	var details []string
+	switch e.Type {
+	case "directory":
+		name += "/"
+		details = append(details, tui.size(e.Size), fmt.Sprintf("%s entries", groupDigits(int64(e.Entries))))
+		if e.Entries == 1 {
+			details[1] = "1 entry"
+		}
+	case "file":
+		details = append(details, tui.size(e.Size))
+		if e.HardlinkOf != "" {
+			details = append(details, "hardlink of "+e.HardlinkOf)
+		}
+	case "symlink":
+		name += " → " + e.Target
+	case "error":
+		details = append(details, "unreadable: "+e.Error)
+	default:
+		details = append(details, e.Type)
+	}
+	hash := e.Hash
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+	details = append(details, hash)
+	return tview.Escape(name + "  " + strings.Join(details, ", "))
+}
+
+// browserColor colors an entry of the browser by its type.
+func browserColor(e protocol.ListEntry) tcell.Color {
+	switch e.Type {
+	case "directory":
+		return tcell.ColorTeal
+	case "file":
+		return tview.Styles.PrimaryTextColor
+	case "error":
+		return tcell.ColorRed
+	}
+	return tcell.ColorYellow
+}
+
+// entryIcon pictures the type of e in the details of an entry.
+func entryIcon(e protocol.ListEntry) string {
+	switch e.Type {
+	case "directory":
+		return "📁"
+	case "file":
+		return "📄"
+	case "symlink":
+		return "🔗"
+	case "error":
+		return "⚠"
+	}
+	return "⚙"
+}
+
+// showEntry writes the details of the entry e at p to the output pane.
+func (tui *MerkleTUI) showEntry(p string, e protocol.ListEntry) {
+	tui.writeOutput(fmt.Sprintf("[yellow]%s %s (%s)[white]", entryIcon(e), tview.Escape(p), e.Type))
+	tui.writeOutput(fmt.Sprintf("   [green]🔐 Hash: %s[white]", e.Hash))
+	if e.Type == "file" || e.Type == "directory" {
+		tui.writeOutput(fmt.Sprintf("   [blue]📏 Size: %s[white]", tui.size(e.Size)))
+	}
+	if e.Type == "file" {
+		tui.writeOutput(fmt.Sprintf("   [magenta]🧩 Chunks: %s[white]", groupDigits(int64(e.Chunks))))
+		if e.ContentHash != "" && e.ContentHash != e.Hash {
+			tui.writeOutput(fmt.Sprintf("   [green]🔐 Content Hash: %s[white]", e.ContentHash))
+		}
+	}
+	for _, field := range [][2]string{{"Target", e.Target}, {"Device", e.Device}, {"Error", e.Error}, {"Hardlink of", e.HardlinkOf}} {
+		if field[1] != "" {
+			tui.writeOutput(fmt.Sprintf("   %s: %s", field[0], tview.Escape(field[1])))
+		}
+	}
+	if e.MetadataHash != "" {
+		tui.writeOutput(fmt.Sprintf("   [cyan]🏷  Metadata Hash: %s[white]", e.MetadataHash))
+		for _, name := range sortedKeys(e.Metadata) {
+			tui.writeOutput(fmt.Sprintf("   [cyan]    %s = %s[white]", name, tview.Escape(e.Metadata[name])))
+		}
+	}
+	if e.XattrsHash != "" {
+		tui.writeOutput(fmt.Sprintf("   [cyan]🏷  Xattrs Hash: %s[white]", e.XattrsHash))
+	}
+}
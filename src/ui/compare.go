@@ -0,0 +1,220 @@
+package ui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"MTFS/manifest"
+	"MTFS/monitor"
+	"MTFS/paths"
+	"MTFS/registry"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+const (
+	// comparePickPage lists the trees the current one can be compared
+	// with.
+	comparePickPage = "compare-pick"
+	// comparisonPage shows what differs between two trees.
+	comparisonPage = "comparison"
+)
+
+// compareTrees offers the trees open in other workspaces and those of the
+// registry to compare the current tree with, or a directory or tree name
+// to type in.
+func (tui *MerkleTUI) compareTrees() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	type candidate struct{ name, path, where string }
+	var candidates []candidate
+	seen := map[string]bool{tui.treePath: true}
+	if tui.peers != nil {
+		for _, p := range tui.peers() {
+			if !seen[p] {
+				seen[p] = true
+				candidates = append(candidates, candidate{filepath.Base(p), p, "open in another workspace"})
+			}
+		}
+	}
+	trees, err := registry.Load()
+	if err != nil {
+		tui.log.Warn("registry not loaded", "err", err)
+	}
+	for _, t := range trees {
+		if !seen[t.Path] {
+			seen[t.Path] = true
+			candidates = append(candidates, candidate{t.Name, t.Path, "registered"})
+		}
+	}
+
+	list := tview.NewList()
+	for i, c := range candidates {
+		var shortcut rune
+		if i < 9 {
+			shortcut = rune('1' + i)
+		}
+		path := c.path
+		list.AddItem(tview.Escape(c.name), tview.Escape(fmt.Sprintf("%s (%s)", c.path, c.where)), shortcut, func() {
+			tui.closePage(comparePickPage)
+			tui.compareWith(path)
+		})
+	}
+	list.AddItem("Other tree…", "Type a directory or the name of a registered tree", 'o', func() {
+		tui.closePage(comparePickPage)
+		tui.currentAction = "compare_path"
+		tui.writeOutput("[blue]Enter the directory or registered name of the tree to compare with.[white]")
+		tui.input.SetLabel("Compare with: ")
+		tui.app.SetFocus(tui.input)
+	})
+	list.SetDoneFunc(func() { tui.closePage(comparePickPage) })
+	list.SetSelectedTextColor(tcell.ColorBlack)
+	list.SetSelectedBackgroundColor(tcell.ColorWhite)
+	list.SetBorder(true).SetTitle(fmt.Sprintf("Compare %s with (Esc: close)", filepath.Base(tui.treePath)))
+	tui.pages.AddPage(comparePickPage, list, true, true)
+	tui.app.SetFocus(list)
+}
+
+// compareWith builds the current tree and other side by side and shows
+// what turns the current tree into other.
+func (tui *MerkleTUI) compareWith(other string) {
+	other = registry.Resolve(other)
+	if abs, err := paths.Abs(other); err == nil {
+		other = abs
+	}
+	tree := tui.treePath
+	tui.updateStatus("Comparing trees...")
+	tui.writeOutput(fmt.Sprintf("[yellow]═══ Comparing %s with %s ═══[white]", tree, other))
+	tui.spawn(func() {
+		c, err := monitor.Compare(tree, other)
+		tui.app.QueueUpdateDraw(func() {
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+				tui.recordOn(tree, "compare", "", err, "with", other)
+				tui.updateStatus("Comparison failed")
+				return
+			}
+			tui.recordOn(tree, "compare", c.Roots[0].Hash, nil, "with", other)
+			tui.showComparison(c)
+			tui.updateStatus("Ready")
+		})
+	})
+}
+
+// importExport asks for an export written earlier to verify the tree
+// against.
+func (tui *MerkleTUI) importExport() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "import_path"
+	tui.updateStatus("Verifying against an export...")
+	tui.writeOutput("[yellow]═══ Import & Verify ═══[white]")
+	tui.writeOutput("[blue]Enter a JSON, CBOR or protobuf export of this tree. The tree is hashed again and every path that no longer matches it is listed.[white]")
+	tui.input.SetText("")
+	tui.input.SetLabel("Export file: ")
+	tui.app.SetFocus(tui.input)
+}
+
+// processImportOutput compares the tree as exported now with the
+// imported export.
+func (tui *MerkleTUI) processImportOutput(data []byte) {
+	tui.currentAction = ""
+	cur, err := manifest.Parse(data)
+	var c *monitor.Comparison
+	if err == nil {
+		c, err = monitor.CompareRoots(tui.importPath, tui.treePath, tui.imported, cur)
+	}
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		tui.record("import-verify", "", err, "export", tui.importPath)
+		tui.updateStatus("Verification failed")
+		return
+	}
+	if len(c.Changes) > 0 {
+		err = fmt.Errorf("%d paths no longer match the export", len(c.Changes))
+	}
+	tui.record("import-verify", cur.Hash, err, "export", tui.importPath)
+	if old := tui.imported; old.MaxDepth != cur.MaxDepth || old.MinFileSize != cur.MinFileSize || old.MaxFileSize != cur.MaxFileSize {
+		tui.writeOutput(fmt.Sprintf("[yellow]⚠ The export was filtered to %s and the tree to %s; the verify-export command builds the tree with the export's filters.[white]",
+			describeFilters(old), describeFilters(cur)))
+	}
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", err))
+		tui.showComparison(c)
+	} else {
+		tui.writeOutput(fmt.Sprintf("[green]✓ The tree matches %s (root %s)[white]", tui.importPath, cur.Hash))
+	}
+	tui.updateStatus("Ready")
+}
+
+// describeFilters names the depth and size filters an export records.
+func describeFilters(root *manifest.Node) string {
+	var filters []string
+	if root.MaxDepth > 0 {
+		filters = append(filters, fmt.Sprintf("max depth %d", root.MaxDepth))
+	}
+	if root.MinFileSize > 0 {
+		filters = append(filters, "min file size "+humanBytes(root.MinFileSize))
+	}
+	if root.MaxFileSize > 0 {
+		filters = append(filters, "max file size "+humanBytes(root.MaxFileSize))
+	}
+	if len(filters) == 0 {
+		return "no filters"
+	}
+	return strings.Join(filters, ", ")
+}
+
+// showComparison summarizes c in the output pane and lists its changes in
+// the comparison view.
+func (tui *MerkleTUI) showComparison(c *monitor.Comparison) {
+	if len(c.Changes) == 0 {
+		tui.writeOutput(fmt.Sprintf("[green]✓ The trees are identical (root %s)[white]", c.Roots[0].Hash))
+		return
+	}
+	counts := map[monitor.Type]int64{}
+	for _, e := range c.Changes {
+		counts[e.Type]++
+	}
+	summary := fmt.Sprintf("%s added, %s removed, %s modified",
+		groupDigits(counts[monitor.Added]), groupDigits(counts[monitor.Removed]), groupDigits(counts[monitor.Modified]))
+	tui.writeOutput(fmt.Sprintf("[yellow]%s[white]", summary))
+
+	colors := map[monitor.Type]tcell.Color{
+		monitor.Added:    tcell.ColorGreen,
+		monitor.Removed:  tcell.ColorRed,
+		monitor.Modified: tcell.ColorYellow,
+	}
+	table := tview.NewTable().SetSelectable(true, false).SetFixed(1, 0)
+	for col, title := range []string{"Change", "Path", "Details"} {
+		table.SetCell(0, col, tview.NewTableCell(title).SetSelectable(false).SetTextColor(tcell.ColorYellow))
+	}
+	for i, e := range c.Changes {
+		color := colors[e.Type]
+		if tui.plain {
+			color = tview.Styles.PrimaryTextColor
+		}
+		table.SetCell(i+1, 0, tview.NewTableCell(string(e.Type)).SetTextColor(color))
+		table.SetCell(i+1, 1, tview.NewTableCell(tview.Escape(e.Path)))
+		table.SetCell(i+1, 2, tview.NewTableCell(tview.Escape(e.Message)).SetExpansion(1))
+	}
+	table.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			tui.closePage(comparisonPage)
+		}
+	})
+	names := make([]string, len(c.Trees))
+	for i, t := range c.Trees {
+		names[i] = filepath.Base(t)
+	}
+	table.SetBorder(true).SetTitle(fmt.Sprintf("%s: %s (Esc: close)", strings.Join(names, " → "), summary))
+	table.Select(1, 0)
+	tui.pages.AddPage(comparisonPage, table, true, true)
+	tui.app.SetFocus(table)
+}
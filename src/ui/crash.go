@@ -0,0 +1,160 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"MTFS/logging"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// protocolLines is how many of the last lines exchanged with the backend
+// a crash report shows.
+const protocolLines = 200
+
+// protocolLineBytes is how much of a line exchanged with the backend a
+// crash report keeps, so an export of a large tree does not take it over.
+const protocolLineBytes = 2000
+
+// transcript keeps the last lines exchanged with the backend.
+type transcript struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (t *transcript) add(line string) {
+	if len(line) > protocolLineBytes {
+		line = strings.ToValidUTF8(line[:protocolLineBytes], "") + "…"
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lines = append(t.lines, line)
+	if len(t.lines) > protocolLines {
+		t.lines = t.lines[len(t.lines)-protocolLines:]
+	}
+}
+
+func (t *transcript) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.Join(t.lines, "\n")
+}
+
+// crashState is what the panic handler needs to put the terminal back.
+type crashState struct {
+	mu       sync.Mutex // held for good by the first goroutine to crash
+	screenMu sync.Mutex
+	screen   tcell.Screen
+	restore  []func()
+}
+
+// OnCrash adds f to what is done to restore the terminal before a crash
+// report is printed, such as finishing a screen the TUI runs inside.
+func (tui *MerkleTUI) OnCrash(f func()) {
+	tui.crashState.screenMu.Lock()
+	defer tui.crashState.screenMu.Unlock()
+	tui.crashState.restore = append(tui.crashState.restore, f)
+}
+
+// watchScreen remembers the screen tview draws on, so a panic on any
+// goroutine can restore the terminal.
+func (tui *MerkleTUI) watchScreen(screen tcell.Screen) bool {
+	tui.crashState.screenMu.Lock()
+	tui.crashState.screen = screen
+	tui.crashState.screenMu.Unlock()
+	return false
+}
+
+// spawn runs f on its own goroutine under the panic handler.
+func (tui *MerkleTUI) spawn(f func()) {
+	go func() {
+		defer tui.recoverPanic()
+		f()
+	}()
+}
+
+// recoverPanic, deferred on every goroutine of the TUI, turns a panic
+// into a crash report instead of leaving the terminal in raw mode.
+func (tui *MerkleTUI) recoverPanic() {
+	if p := recover(); p != nil {
+		tui.crash(p, debug.Stack())
+	}
+}
+
+// crash restores the terminal, writes a crash report with the stack,
+// the current action and the last lines exchanged with the backend,
+// tells where it went and exits.
+func (tui *MerkleTUI) crash(p any, stack []byte) {
+	tui.crashState.mu.Lock()
+
+	tui.crashState.screenMu.Lock()
+	if tui.crashState.screen != nil {
+		tui.crashState.screen.Fini()
+	}
+	for _, f := range tui.crashState.restore {
+		f()
+	}
+	tui.crashState.screenMu.Unlock()
+
+	var logPath string
+	if tui.log != nil {
+		logPath = tui.log.Path
+	}
+	report := tui.crashReport(p, stack)
+	path, err := saveCrashReport(report, logPath)
+	if err == nil && tui.log != nil {
+		tui.log.Error("TUI crashed", "panic", fmt.Sprint(p), "report", path)
+	}
+	tui.cleanup()
+
+	fmt.Fprintf(os.Stderr, "MTFS crashed: %v\n", p)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "The crash report could not be saved (%v):\n\n%s", err, report)
+	} else {
+		fmt.Fprintf(os.Stderr, "A crash report was saved to %s\n", path)
+	}
+	os.Exit(2)
+}
+
+func (tui *MerkleTUI) crashReport(p any, stack []byte) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "MTFS crash report\n\n")
+	fmt.Fprintf(&b, "Time:    %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&b, "Panic:   %v\n", p)
+	fmt.Fprintf(&b, "Go:      %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	action := tui.currentAction
+	if action == "" {
+		action = "none"
+	}
+	fmt.Fprintf(&b, "Action:  %s\n", action)
+	if tui.treeBuilt {
+		fmt.Fprintf(&b, "Tree:    %s (root %s)\n", tui.treePath, tui.rootHash)
+	}
+	if tui.log != nil && tui.log.Path != "" {
+		fmt.Fprintf(&b, "Log:     %s\n", tui.log.Path)
+	}
+	fmt.Fprintf(&b, "\nStack:\n%s\n", stack)
+	fmt.Fprintf(&b, "Last lines exchanged with the backend (> sent, < received):\n%s\n", tui.protocol.String())
+	return b.String()
+}
+
+// saveCrashReport writes report next to the log file, or to the temporary
+// directory if there is no place for it there.
+func saveCrashReport(report, logPath string) (string, error) {
+	if logPath == "" {
+		logPath, _ = logging.DefaultPath()
+	}
+	dir := os.TempDir()
+	if logPath != "" && os.MkdirAll(filepath.Dir(logPath), 0o755) == nil {
+		dir = filepath.Dir(logPath)
+	}
+	name := filepath.Join(dir, "crash-"+time.Now().Format("20060102-150405")+".txt")
+	return name, os.WriteFile(name, []byte(report), 0o600)
+}
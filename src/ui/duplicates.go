@@ -0,0 +1,282 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"MTFS/duplicates"
+	"MTFS/manifest"
+	"MTFS/store"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+const (
+	// duplicatesPage lists the groups of identical files of the tree.
+	duplicatesPage = "duplicates"
+	// similarPage lists the pairs of files of the tree sharing chunks.
+	similarPage = "similar"
+)
+
+// dedupTop is how many of the most duplicated files the statistics list.
+const dedupTop = 5
+
+// showDedup adds to the statistics how much deduplicating the chunks of
+// the tree saves, from the snapshot of its current root in the store it
+// was last stored in. The store is only opened if its key is given in
+// the environment, as the statistics ask for nothing.
+func (tui *MerkleTUI) showDedup() {
+	t, ok := tui.registered()
+	if !ok || t.Store() == "" {
+		return
+	}
+	dir, root := t.Store(), tui.rootHash
+	key := store.Key{Passphrase: os.Getenv(store.PassphraseEnv)}
+	if keyFile := os.Getenv(store.KeyFileEnv); keyFile != "" {
+		var err error
+		if key, err = store.KeyFromFile(keyFile); err != nil {
+			tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+			return
+		}
+	} else if key.Passphrase == "" {
+		tui.writeOutput(fmt.Sprintf("[blue]Set %s or %s to see what deduplication saves in %s.[white]", store.KeyFileEnv, store.PassphraseEnv, tview.Escape(dir)))
+		return
+	}
+	tui.spawn(func() {
+		s, err := store.OpenExisting(dir, key)
+		var snap *store.Snapshot
+		if err == nil {
+			if _, err = s.FindSnapshot(root); err != nil {
+				// Not stored in this state
+				return
+			}
+			snap, err = s.LoadSnapshot(root)
+		}
+		tui.app.QueueUpdateDraw(func() {
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+				return
+			}
+			for _, line := range duplicates.MapSnapshot(snap).Dedup(dedupTop).Summary() {
+				tui.processStatsOutput(line)
+			}
+		})
+	})
+}
+
+func (tui *MerkleTUI) findDuplicates() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "duplicates_export"
+	tui.updateStatus("Finding duplicates...")
+	tui.writeOutput("[yellow]═══ Duplicate Files ═══[white]")
+	tui.requestExport()
+}
+
+// processDuplicatesOutput groups the files of the exported tree by their
+// content.
+func (tui *MerkleTUI) processDuplicatesOutput(data []byte) {
+	tui.currentAction = ""
+
+	root, err := manifest.Parse(data)
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		tui.updateStatus("Finding duplicates failed")
+		return
+	}
+	groups := duplicates.Find(root)
+	sum := duplicates.Summarize(groups)
+	tui.record("duplicates", root.Hash, nil, "groups", fmt.Sprint(sum.Groups))
+	tui.updateStatus("Ready")
+	if sum.Groups == 0 {
+		tui.writeOutput("[green]✓ No two files have the same content[white]")
+		return
+	}
+	tui.writeOutput(fmt.Sprintf("[yellow]%s groups of identical files, %s files, %s reclaimable[white]",
+		groupDigits(int64(sum.Groups)), groupDigits(int64(sum.Files)), tui.size(sum.Wasted)))
+	tui.showDuplicates(groups)
+}
+
+// showDuplicates lists groups in a table, by most reclaimable space
+// first; s switches to the next order and Enter lists the paths of a
+// group in the output pane.
+func (tui *MerkleTUI) showDuplicates(groups []duplicates.Group) {
+	sum := duplicates.Summarize(groups)
+	order := 0
+	table := tview.NewTable().SetSelectable(true, false).SetFixed(1, 0)
+	fill := func() {
+		duplicates.Sort(groups, duplicates.Orders[order])
+		table.Clear()
+		for col, title := range []string{"Reclaimable", "Files", "Size", "Paths"} {
+			table.SetCell(0, col, tview.NewTableCell(title).SetSelectable(false).SetTextColor(tcell.ColorYellow))
+		}
+		for i, g := range groups {
+			paths := g.Paths[0]
+			if len(g.Paths) > 1 {
+				paths += fmt.Sprintf(" and %d more", len(g.Paths)-1)
+			}
+			table.SetCell(i+1, 0, tview.NewTableCell(tui.size(g.Wasted())).SetAlign(tview.AlignRight))
+			table.SetCell(i+1, 1, tview.NewTableCell(groupDigits(int64(len(g.Paths)))).SetAlign(tview.AlignRight))
+			table.SetCell(i+1, 2, tview.NewTableCell(tui.size(g.Size)).SetAlign(tview.AlignRight))
+			table.SetCell(i+1, 3, tview.NewTableCell(tview.Escape(paths)).SetExpansion(1))
+		}
+		table.SetTitle(fmt.Sprintf("Duplicates: %s reclaimable, by %s (s: sort, Enter: paths, Esc: close)",
+			tui.size(sum.Wasted), duplicates.Orders[order]))
+		table.Select(1, 0)
+	}
+	table.SetSelectedFunc(func(row, _ int) {
+		if row < 1 || row > len(groups) {
+			return
+		}
+		g := groups[row-1]
+		tui.writeOutput(fmt.Sprintf("[cyan]%s files of %s (%s), %s reclaimable:[white]",
+			groupDigits(int64(len(g.Paths))), tui.size(g.Size), g.Hash, tui.size(g.Wasted())))
+		for _, p := range g.Paths {
+			tui.writeOutput("  " + tview.Escape(p))
+		}
+	})
+	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyRune && event.Rune() == 's' {
+			order = (order + 1) % len(duplicates.Orders)
+			fill()
+			return nil
+		}
+		return event
+	})
+	table.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			tui.closePage(duplicatesPage)
+		}
+	})
+	table.SetBorder(true)
+	fill()
+	tui.pages.AddPage(duplicatesPage, table, true, true)
+	tui.app.SetFocus(table)
+}
+
+func (tui *MerkleTUI) findSimilar() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "similar_min"
+	tui.updateStatus("Finding similar files...")
+	tui.writeOutput("[yellow]═══ Similar Files ═══[white]")
+	tui.writeOutput(fmt.Sprintf("[blue]Files larger than one chunk are split into %s, and pairs sharing at least this share of the larger file are listed.[white]", tui.describeChunking(tui.builtChunking)))
+	tui.input.SetText("50")
+	tui.input.SetLabel("Minimum similarity (%): ")
+	tui.app.SetFocus(tui.input)
+}
+
+// processSimilarOutput looks for the pairs of files of the exported tree
+// sharing chunks.
+func (tui *MerkleTUI) processSimilarOutput(data []byte) {
+	tui.currentAction = ""
+
+	root, err := manifest.Parse(data)
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		tui.updateStatus("Finding similar files failed")
+		return
+	}
+	tree, params, threshold := tui.treePath, tui.builtChunking, tui.similarMin
+	tui.spawn(func() {
+		pairs, err := duplicates.Similar(tree, root, params, threshold, func(files int) {
+			tui.app.QueueUpdateDraw(func() {
+				tui.updateStatus(fmt.Sprintf("Read %s files", groupDigits(int64(files))))
+			})
+		})
+		tui.app.QueueUpdateDraw(func() {
+			tui.record("similar", root.Hash, err, "min", fmt.Sprintf("%g%%", threshold*100), "pairs", fmt.Sprint(len(pairs)))
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+				tui.updateStatus("Finding similar files failed")
+				return
+			}
+			tui.updateStatus("Ready")
+			if len(pairs) == 0 {
+				tui.writeOutput(fmt.Sprintf("[green]✓ No two files share %g%% of their chunks[white]", threshold*100))
+				return
+			}
+			tui.writeOutput(fmt.Sprintf("[yellow]%s pairs of similar files[white]", groupDigits(int64(len(pairs)))))
+			tui.showSimilar(pairs)
+		})
+	})
+}
+
+// showSimilar lists pairs in a table, most similar first.
+func (tui *MerkleTUI) showSimilar(pairs []duplicates.Pair) {
+	table := tview.NewTable().SetSelectable(true, false).SetFixed(1, 0)
+	for col, title := range []string{"Similarity", "Shared", "File", "Similar file"} {
+		table.SetCell(0, col, tview.NewTableCell(title).SetSelectable(false).SetTextColor(tcell.ColorYellow))
+	}
+	for i, p := range pairs {
+		table.SetCell(i+1, 0, tview.NewTableCell(fmt.Sprintf("%.0f%%", p.Similarity()*100)).SetAlign(tview.AlignRight))
+		table.SetCell(i+1, 1, tview.NewTableCell(tui.size(p.SharedBytes)).SetAlign(tview.AlignRight))
+		table.SetCell(i+1, 2, tview.NewTableCell(tview.Escape(fmt.Sprintf("%s (%s)", p.A, tui.size(p.SizeA)))).SetExpansion(1))
+		table.SetCell(i+1, 3, tview.NewTableCell(tview.Escape(fmt.Sprintf("%s (%s)", p.B, tui.size(p.SizeB)))).SetExpansion(1))
+	}
+	table.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			tui.closePage(similarPage)
+		}
+	})
+	table.SetBorder(true).SetTitle(fmt.Sprintf("Similar files: %s pairs (Esc: close)", groupDigits(int64(len(pairs)))))
+	table.Select(1, 0)
+	tui.pages.AddPage(similarPage, table, true, true)
+	tui.app.SetFocus(table)
+}
+
+func (tui *MerkleTUI) exportChunkMap() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "chunkmap_path"
+	tui.updateStatus("Exporting chunk map...")
+	tui.writeOutput("[yellow]═══ Chunk Map ═══[white]")
+	tui.writeOutput(fmt.Sprintf("[blue]Files are split into %s, and every chunk is listed with the files and offsets it is found at, as JSON.[white]", tui.describeChunking(tui.builtChunking)))
+	tui.input.SetText(filepath.Base(tui.treePath) + ".chunks.json")
+	tui.input.SetLabel("Chunk map file: ")
+	tui.app.SetFocus(tui.input)
+}
+
+// processChunkMapOutput maps the chunks of the exported tree and writes
+// the map to the chosen file.
+func (tui *MerkleTUI) processChunkMapOutput(data []byte) {
+	tui.currentAction = ""
+
+	root, err := manifest.Parse(data)
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		tui.updateStatus("Chunk map export failed")
+		return
+	}
+	tree, params, out := tui.treePath, tui.builtChunking, tui.chunkMapPath
+	tui.spawn(func() {
+		m, err := duplicates.MapTree(tree, root, params, func(files int) {
+			tui.app.QueueUpdateDraw(func() {
+				tui.updateStatus(fmt.Sprintf("Read %s files", groupDigits(int64(files))))
+			})
+		})
+		if err == nil {
+			err = m.Write(out)
+		}
+		tui.app.QueueUpdateDraw(func() {
+			tui.record("chunk-map", root.Hash, err, "file", out)
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+				tui.updateStatus("Chunk map export failed")
+				return
+			}
+			shared := len(m.Shared().Chunks)
+			tui.writeOutput(fmt.Sprintf("[green]✓ %s chunks written to %s, %s of them found at more than one place[white]",
+				groupDigits(int64(len(m.Chunks))), out, groupDigits(int64(shared))))
+			tui.updateStatus("Ready")
+		})
+	})
+}
@@ -0,0 +1,175 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"MTFS/store"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+const (
+	// snapshotsPage lists the snapshots of a store to extract a file from.
+	snapshotsPage = "snapshots"
+	// snapshotFilesPage lists the files of a snapshot.
+	snapshotFilesPage = "snapshot-files"
+)
+
+// extractFromStore asks for a store, then lists its snapshots and their
+// files to restore one file from its stored chunks. The tree does not
+// need to be built, nor to exist any more.
+func (tui *MerkleTUI) extractFromStore() {
+	tui.currentAction = "store_dir"
+	tui.storeUse = tui.openSnapshots
+	tui.updateStatus("Extracting file...")
+	tui.writeOutput("[yellow]═══ Extract File from Store ═══[white]")
+	tui.writeOutput("[blue]Enter the store directory or s3:// or gs:// bucket URL holding the snapshot.[white]")
+	dir := os.Getenv(store.DirEnv)
+	if t, ok := tui.registered(); ok && t.Store() != "" {
+		dir = t.Store()
+	}
+	tui.input.SetText(dir)
+	tui.input.SetLabel("Store: ")
+	tui.app.SetFocus(tui.input)
+}
+
+// openSnapshots opens the store with key and lists its snapshots, the
+// most recently stored first.
+func (tui *MerkleTUI) openSnapshots(key store.Key) {
+	tui.currentAction = ""
+	tui.input.SetLabel("Input: ")
+	tui.app.SetFocus(tui.menu)
+	dir := tui.storeDir
+	tui.updateStatus("Reading snapshots...")
+	tui.spawn(func() {
+		s, err := store.OpenExisting(dir, key)
+		var snaps []*store.Snapshot
+		if err == nil {
+			var roots []string
+			if roots, err = s.Snapshots(); err == nil {
+				for _, root := range roots {
+					snap, err := s.LoadSnapshot(root)
+					if err != nil {
+						tui.log.Warn("snapshot not read", "root", root, "err", err)
+						continue
+					}
+					snaps = append(snaps, snap)
+				}
+			}
+		}
+		tui.app.QueueUpdateDraw(func() {
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+				tui.updateStatus("Extracting file failed")
+				return
+			}
+			tui.updateStatus("Ready")
+			if len(snaps) == 0 {
+				tui.writeOutput(fmt.Sprintf("[blue]%s holds no snapshots yet.[white]", dir))
+				return
+			}
+			tui.showSnapshots(s, snaps)
+		})
+	})
+}
+
+func (tui *MerkleTUI) showSnapshots(s *store.Store, snaps []*store.Snapshot) {
+	sort.SliceStable(snaps, func(i, j int) bool { return snaps[i].Created > snaps[j].Created })
+	list := tview.NewList()
+	for i, snap := range snaps {
+		var size int64
+		for _, f := range snap.Files {
+			size += f.Size
+		}
+		detail := fmt.Sprintf("%s files, %s", groupDigits(int64(len(snap.Files))), tui.size(size))
+		if when, err := time.Parse(time.RFC3339Nano, snap.Created); err == nil {
+			detail = "stored " + when.Local().Format("2006-01-02 15:04") + "  " + detail
+		}
+		var shortcut rune
+		if i < 9 {
+			shortcut = rune('1' + i)
+		}
+		list.AddItem(snap.Root, detail, shortcut, func() {
+			tui.closePage(snapshotsPage)
+			tui.showSnapshotFiles(s, snap)
+		})
+	}
+	list.SetDoneFunc(func() { tui.closePage(snapshotsPage) })
+	list.SetSelectedTextColor(tcell.ColorBlack)
+	list.SetSelectedBackgroundColor(tcell.ColorWhite)
+	list.SetBorder(true).SetTitle(fmt.Sprintf("Snapshots in %s (Enter: files, Esc: close)", tview.Escape(s.Location)))
+	tui.pages.AddPage(snapshotsPage, list, true, true)
+	tui.app.SetFocus(list)
+}
+
+func (tui *MerkleTUI) showSnapshotFiles(s *store.Store, snap *store.Snapshot) {
+	table := tview.NewTable().SetSelectable(true, false).SetFixed(1, 0)
+	for col, title := range []string{"Path", "Size"} {
+		table.SetCell(0, col, tview.NewTableCell(title).SetSelectable(false).SetTextColor(tcell.ColorYellow))
+	}
+	for i, f := range snap.Files {
+		table.SetCell(i+1, 0, tview.NewTableCell(tview.Escape(f.Path)).SetExpansion(1))
+		table.SetCell(i+1, 1, tview.NewTableCell(tui.size(f.Size)).SetAlign(tview.AlignRight))
+	}
+	table.SetSelectedFunc(func(row, _ int) {
+		if row < 1 || row > len(snap.Files) {
+			return
+		}
+		tui.closePage(snapshotFilesPage)
+		tui.extractStore, tui.extractRoot, tui.extractFile = s, snap.Root, snap.Files[row-1]
+		tui.currentAction = "extract_dest"
+		tui.writeOutput(fmt.Sprintf("[blue]Every chunk of %s is checked against its hash as it is reassembled.[white]", tview.Escape(tui.extractFile.Path)))
+		tui.input.SetText(path.Base(tui.extractFile.Path))
+		tui.input.SetLabel("Save as: ")
+		tui.app.SetFocus(tui.input)
+	})
+	table.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			tui.closePage(snapshotFilesPage)
+		}
+	})
+	table.SetBorder(true).SetTitle(fmt.Sprintf("Files of snapshot %s… (Enter: extract, Esc: close)", snap.Root[:min(len(snap.Root), 16)]))
+	table.Select(1, 0)
+	tui.pages.AddPage(snapshotFilesPage, table, true, true)
+	tui.app.SetFocus(table)
+}
+
+// extractTo writes the chosen file to dest, which is only created once
+// the file was checked.
+func (tui *MerkleTUI) extractTo(dest string) {
+	s, root, file := tui.extractStore, tui.extractRoot, tui.extractFile
+	tui.extractStore = nil
+	tui.updateStatus("Extracting file...")
+	tui.spawn(func() {
+		err := s.ExtractTo(file, dest, false)
+		tui.app.QueueUpdateDraw(func() {
+			tui.recordOn("", "extract", root, err, "path", file.Path, "store", s.Location, "file", dest)
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+				tui.updateStatus("Extracting file failed")
+				return
+			}
+			tui.writeOutput(fmt.Sprintf("[green]✓ Extracted %s (%s, %s chunks checked) to %s[white]",
+				tview.Escape(file.Path), tui.size(file.Size), groupDigits(int64(len(file.Chunks))), tview.Escape(dest)))
+			tui.updateStatus("Ready")
+		})
+	})
+}
+
+// extractDest checks the file name entered for the extracted file.
+func extractDest(input string) (string, error) {
+	dest := strings.TrimSpace(input)
+	if dest == "" {
+		return "", fmt.Errorf("enter a file name for the extracted file")
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		return "", fmt.Errorf("%s exists already; enter another name", dest)
+	}
+	return dest, nil
+}
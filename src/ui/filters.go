@@ -0,0 +1,116 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Walk options scoping builds to some files.
+const (
+	includeFlag = "--include"
+	excludeFlag = "--exclude"
+)
+
+// setFilters asks for the include patterns, then the exclude patterns, of
+// the trees this workspace builds.
+func (tui *MerkleTUI) setFilters() {
+	tui.currentAction = "filter_include"
+	tui.updateStatus("Setting include and exclude patterns...")
+	tui.writeOutput("[yellow]═══ Include / Exclude ═══[white]")
+	tui.writeOutput("[blue]Patterns are separated by ':' as in MTFS_INCLUDE and MTFS_EXCLUDE. Patterns containing '/' match the path from the tree root, others match the name: *.go hashes Go files only, node_modules skips every such directory. Leave both empty to hash everything.[white]")
+	tui.input.SetText(strings.Join(tui.filterPatterns(includeFlag), ":"))
+	tui.input.SetLabel("Include patterns: ")
+	tui.app.SetFocus(tui.input)
+}
+
+// filterPatterns returns the patterns given to builds as flag, either as
+// --flag=PATTERN or as --flag PATTERN.
+func (tui *MerkleTUI) filterPatterns(flag string) []string {
+	var patterns []string
+	for i := 0; i < len(tui.backendArgs); i++ {
+		arg := tui.backendArgs[i]
+		if pattern, ok := strings.CutPrefix(arg, flag+"="); ok {
+			patterns = append(patterns, pattern)
+		} else if arg == flag && i+1 < len(tui.backendArgs) {
+			i++
+			patterns = append(patterns, tui.backendArgs[i])
+		}
+	}
+	return patterns
+}
+
+// splitPatterns splits the ':'-separated patterns entered.
+func splitPatterns(text string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(text, ":") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// setIncludes keeps the include patterns entered and asks for the
+// exclude patterns.
+func (tui *MerkleTUI) setIncludes(text string) {
+	tui.includes = splitPatterns(text)
+	tui.currentAction = "filter_exclude"
+	tui.input.SetText(strings.Join(tui.filterPatterns(excludeFlag), ":"))
+	tui.input.SetLabel("Exclude patterns: ")
+}
+
+// setExcludes restarts the backend with the include patterns entered
+// before and the exclude patterns entered now as walk options.
+func (tui *MerkleTUI) setExcludes(text string) {
+	includes, excludes := tui.includes, splitPatterns(text)
+	tui.includes = nil
+	tui.input.SetText("")
+	tui.input.SetLabel("Input: ")
+	tui.app.SetFocus(tui.menu)
+	if strings.Join(includes, "\x00") == strings.Join(tui.filterPatterns(includeFlag), "\x00") &&
+		strings.Join(excludes, "\x00") == strings.Join(tui.filterPatterns(excludeFlag), "\x00") {
+		tui.currentAction = ""
+		tui.writeOutput("[blue]Trees are already built with these patterns.[white]")
+		tui.updateStatus("Ready")
+		return
+	}
+
+	// Copied as in setHashFormat, the other walk options kept
+	var args []string
+	for i := 0; i < len(tui.backendArgs); i++ {
+		arg := tui.backendArgs[i]
+		switch {
+		case arg == includeFlag || arg == excludeFlag:
+			i++
+		case strings.HasPrefix(arg, includeFlag+"="), strings.HasPrefix(arg, excludeFlag+"="):
+		default:
+			args = append(args, arg)
+		}
+	}
+	for _, pattern := range includes {
+		args = append(args, includeFlag+"="+pattern)
+	}
+	for _, pattern := range excludes {
+		args = append(args, excludeFlag+"="+pattern)
+	}
+	if len(args) == 0 {
+		// Without options a tree is built with those recorded by its last
+		// build, patterns and all
+		args = []string{hashFormatFlag + tui.chosenHashFormat()}
+	}
+	tui.backendArgs = args
+	tui.restartBackend()
+	if len(includes) == 0 && len(excludes) == 0 {
+		tui.writeOutput("[green]✓ Trees are built without include or exclude patterns.[white]")
+	} else {
+		tui.writeOutput(fmt.Sprintf("[green]✓ Trees are built including %s and excluding %s.[white]",
+			describePatterns(includes, "every file"), describePatterns(excludes, "nothing")))
+	}
+}
+
+func describePatterns(patterns []string, none string) string {
+	if len(patterns) == 0 {
+		return none
+	}
+	return strings.Join(patterns, ", ")
+}
@@ -0,0 +1,112 @@
+package ui
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// byteCount matches a size the backend prints in bytes, with the
+	// rounded size it may add in parentheses.
+	byteCount = regexp.MustCompile(`(^|[^\d.])(\d+) bytes( \(\d+(\.\d+)? [KMGT]?B\))?`)
+	// labelledCount matches the counts the backend prints after a label.
+	labelledCount = regexp.MustCompile(`((?:Total files|Total directories|Children|Chunks|Hardlinks|Special files): )(\d+)`)
+	// nounCount matches the counts the backend prints before a noun.
+	nounCount = regexp.MustCompile(`(^|[^\d.])(\d+)( (?:files|directories|chunks|entries)\b)`)
+	// progressShare matches the share done in a progress line of a build
+	// that was pre-scanned.
+	progressShare = regexp.MustCompile(`\((\d+(?:\.\d+)?)%\)`)
+)
+
+// progressBar draws the share done of a progress line as a bar of width
+// cells, "" if the line has none.
+func progressBar(line string, width int) string {
+	m := progressShare.FindStringSubmatch(line)
+	if m == nil {
+		return ""
+	}
+	percent, _ := strconv.ParseFloat(m[1], 64)
+	return shareBar(percent, width)
+}
+
+// shareBar draws percent as a bar of width cells.
+func shareBar(percent float64, width int) string {
+	filled := min(width, max(0, int(percent/100*float64(width)+0.5)))
+	return "[" + strings.Repeat("█", filled) + strings.Repeat("░", width-filled) + "]"
+}
+
+// humanBytes formats n with binary units, as 1.4 GiB.
+func humanBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	units := []string{"KiB", "MiB", "GiB", "TiB", "PiB"}
+	size := float64(n) / 1024
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
+
+// groupDigits formats n with thousands separators, as 12,345.
+func groupDigits(n int64) string {
+	digits := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return sign + digits
+}
+
+// size formats n with binary units, or as exact bytes if raw sizes are
+// on.
+func (tui *MerkleTUI) size(n int64) string {
+	if tui.rawSizes {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	return humanBytes(n)
+}
+
+// humanize rewrites the sizes and counts in a line of backend output the
+// way the rest of the interface shows them.
+func (tui *MerkleTUI) humanize(line string) string {
+	line = byteCount.ReplaceAllStringFunc(line, func(m string) string {
+		parts := byteCount.FindStringSubmatch(m)
+		n, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			return m
+		}
+		return parts[1] + tui.size(n)
+	})
+	group := func(re *regexp.Regexp) func(string) string {
+		return func(m string) string {
+			parts := re.FindStringSubmatch(m)
+			n, err := strconv.ParseInt(parts[2], 10, 64)
+			if err != nil {
+				return m
+			}
+			return parts[1] + groupDigits(n) + m[len(parts[1])+len(parts[2]):]
+		}
+	}
+	line = labelledCount.ReplaceAllStringFunc(line, group(labelledCount))
+	return nounCount.ReplaceAllStringFunc(line, group(nounCount))
+}
+
+// toggleRawSizes switches between sizes with binary units and exact
+// bytes for the output that follows.
+func (tui *MerkleTUI) toggleRawSizes() {
+	tui.rawSizes = !tui.rawSizes
+	if tui.rawSizes {
+		tui.writeOutput("[blue]Sizes are now shown in exact bytes.[white]")
+	} else {
+		tui.writeOutput("[blue]Sizes are now shown in KiB, MiB and GiB.[white]")
+	}
+	tui.updateStatus(tui.statusMessage)
+}
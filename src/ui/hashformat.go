@@ -0,0 +1,70 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"MTFS/settings"
+)
+
+// hashFormatFlag is the walk option choosing the hash format.
+const hashFormatFlag = "--hash-format="
+
+// chooseHashFormat asks for the hash format, and with it the hash
+// algorithm, of the trees this workspace builds.
+func (tui *MerkleTUI) chooseHashFormat() {
+	tui.currentAction = "hash_format"
+	tui.updateStatus("Choosing hash algorithm...")
+	tui.writeOutput("[yellow]═══ Hash Algorithm ═══[white]")
+	tui.writeOutput("[blue]mtfs hashes chunks, files and directories with SHA-256; mtfs-sha512, mtfs-blake2b and mtfs-blake3 hash them the same way with SHA-512, BLAKE2b or BLAKE3, which only the Go engine has. git and git-sha256 give Git blob and tree ids. Exports record the format, so the content of a file is checked with the same algorithm.[white]")
+	tui.input.SetText(tui.chosenHashFormat())
+	tui.input.SetLabel("Hash format (" + strings.Join(settings.HashFormats, ", ") + "): ")
+	tui.app.SetFocus(tui.input)
+}
+
+// chosenHashFormat returns the hash format builds use: the one given as
+// a walk option, or the default of the settings.
+func (tui *MerkleTUI) chosenHashFormat() string {
+	for _, arg := range tui.backendArgs {
+		if format, ok := strings.CutPrefix(arg, hashFormatFlag); ok {
+			return format
+		}
+	}
+	if format := os.Getenv(settings.HashFormatEnv); format != "" {
+		return format
+	}
+	return settings.HashFormats[0]
+}
+
+// setHashFormat restarts the backend with the hash format entered as a
+// walk option.
+func (tui *MerkleTUI) setHashFormat(text string) {
+	format := strings.TrimSpace(text)
+	if !slices.Contains(settings.HashFormats, format) {
+		tui.writeOutput(fmt.Sprintf("[red]✗ Unknown hash format %q; choose one of %s.[white]", format, strings.Join(settings.HashFormats, ", ")))
+		return
+	}
+	tui.input.SetText("")
+	tui.input.SetLabel("Input: ")
+	tui.app.SetFocus(tui.menu)
+	if format == tui.chosenHashFormat() {
+		tui.currentAction = ""
+		tui.writeOutput(fmt.Sprintf("[blue]Trees are already built with the %s hash format.[white]", format))
+		tui.updateStatus("Ready")
+		return
+	}
+
+	// The arguments may be shared with other workspaces, so they are
+	// copied rather than changed in place
+	args := []string{hashFormatFlag + format}
+	for _, arg := range tui.backendArgs {
+		if !strings.HasPrefix(arg, hashFormatFlag) {
+			args = append(args, arg)
+		}
+	}
+	tui.backendArgs = args
+	tui.restartBackend()
+	tui.writeOutput(fmt.Sprintf("[green]✓ Trees are built with the %s hash format.[white]", format))
+}
@@ -0,0 +1,252 @@
+package ui
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"MTFS/oplog"
+	"MTFS/state"
+	"MTFS/statebundle"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+const (
+	// historyPage shows the operation history of the current tree.
+	historyPage = "history"
+	// reportPage shows a report opened from the history.
+	reportPage = "report"
+	// reportsDir is the directory of the state directory reports are
+	// kept in.
+	reportsDir = "reports"
+	// maxReports is how many reports a tree keeps.
+	maxReports = 100
+)
+
+// historyOps are the operations the history lists.
+var historyOps = map[string]bool{"build": true, "verify": true, "store": true, "snapshot-save": true}
+
+// beginReport starts collecting what is written to the output pane as
+// the report of op, which is saved when op is recorded.
+func (tui *MerkleTUI) beginReport(op string) {
+	tui.reportOp = op
+	tui.reportStart = time.Now()
+	tui.reportLines = nil
+}
+
+// finishReport saves the report of op, if one is being collected, and
+// adds its location, digest and the duration of op to e.
+func (tui *MerkleTUI) finishReport(tree, op string, e *oplog.Entry) {
+	if tui.reportOp != op || tree == "" {
+		return
+	}
+	tui.reportOp = ""
+	e.Duration = time.Since(tui.reportStart).Round(time.Millisecond).String()
+	data := []byte(strings.Join(tui.reportLines, "\n") + "\n")
+	name := path.Join(reportsDir, tui.reportStart.UTC().Format("20060102T150405.000Z")+"-"+op+".txt")
+	if err := state.WriteFile(tree, name, data); err != nil {
+		tui.log.Warn("report not saved", "operation", op, "err", err)
+		return
+	}
+	sum := sha256.Sum256(data)
+	e.Report, e.ReportSum = name, hex.EncodeToString(sum[:])
+	pruneReports(tree)
+}
+
+// pruneReports removes all but the newest maxReports reports of tree.
+func pruneReports(tree string) {
+	entries, err := os.ReadDir(state.Path(tree, reportsDir))
+	if err != nil || len(entries) <= maxReports {
+		return
+	}
+	// Names start with the time, so they sort oldest first
+	for _, entry := range entries[:len(entries)-maxReports] {
+		os.Remove(state.Path(tree, reportsDir, entry.Name()))
+	}
+}
+
+// showHistory lists the builds, verifications and snapshots of the
+// current tree from the operation log, newest first. Selecting one
+// opens its report.
+func (tui *MerkleTUI) showHistory() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	name, err := oplog.Path()
+	if err == nil && name == "" {
+		err = fmt.Errorf("the operation log is off (%s=off)", oplog.FileEnv)
+	}
+	var entries []oplog.Entry
+	if err == nil {
+		entries, err = oplog.Read(name)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		return
+	}
+
+	// A tree moved from another machine brings the history it had there,
+	// whose reports are now in this tree
+	imported, err := statebundle.ReadHistory(tui.treePath)
+	if err != nil {
+		tui.log.Warn("imported history not read", "tree", tui.treePath, "err", err)
+	}
+	for i := range imported {
+		imported[i].Tree = tui.treePath
+	}
+	var history []oplog.Entry
+	for _, e := range append(imported, entries...) {
+		if e.Tree == tui.treePath && historyOps[e.Operation] {
+			history = append(history, e)
+		}
+	}
+	if len(history) == 0 {
+		tui.writeOutput(fmt.Sprintf("[blue]No builds, verifications or snapshots of %s are recorded yet.[white]", tui.treePath))
+		return
+	}
+	// Imported entries are numbered in another log, so only their times
+	// order them
+	sort.SliceStable(history, func(i, j int) bool { return entryTime(history[i]).After(entryTime(history[j])) })
+
+	table := tview.NewTable().SetSelectable(true, false).SetFixed(1, 0)
+	for col, title := range []string{"Time", "Operation", "Duration", "Root hash", "Result"} {
+		table.SetCell(0, col, tview.NewTableCell(title).SetSelectable(false).SetTextColor(tcell.ColorYellow).SetExpansion(1))
+	}
+	for i, e := range history {
+		when := e.Time
+		if t, err := time.Parse(time.RFC3339Nano, e.Time); err == nil {
+			when = t.Local().Format("2006-01-02 15:04:05")
+		}
+		root := shortHash(e.RootHash)
+		result, color := e.Result, tcell.ColorGreen
+		if e.Result != oplog.OK {
+			color = tcell.ColorRed
+		}
+		if tui.plain {
+			color = tview.Styles.PrimaryTextColor
+		}
+		for col, text := range []string{when, e.Operation, e.Duration, root, result} {
+			cell := tview.NewTableCell(tview.Escape(text)).SetExpansion(1)
+			if col == 4 {
+				cell.SetTextColor(color)
+			}
+			table.SetCell(i+1, col, cell)
+		}
+	}
+	table.SetSelectedFunc(func(row, _ int) {
+		if row > 0 {
+			tui.showReport(history[row-1])
+		}
+	})
+	table.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			tui.closePage(historyPage)
+		}
+	})
+	table.SetBorder(true).SetTitle(fmt.Sprintf("History of %s (Enter: report, Esc: close)", tui.treePath))
+	table.Select(1, 0)
+	tui.pages.AddPage(historyPage, table, true, true)
+	tui.app.SetFocus(table)
+}
+
+// showReport shows an operation of the history with its stored report.
+func (tui *MerkleTUI) showReport(e oplog.Entry) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[yellow]%s[white] by %s@%s at %s\n", e.Operation, e.User, e.Host, e.Time)
+	fmt.Fprintf(&b, "Result: %s", e.Result)
+	if e.Error != "" {
+		fmt.Fprintf(&b, " (%s)", tview.Escape(e.Error))
+	}
+	b.WriteString("\n")
+	if e.Duration != "" {
+		fmt.Fprintf(&b, "Duration: %s\n", e.Duration)
+	}
+	if e.RootHash != "" {
+		fmt.Fprintf(&b, "Root hash: %s\n", e.RootHash)
+	}
+	for _, k := range sortedKeys(e.Params) {
+		fmt.Fprintf(&b, "%s: %s\n", k, tview.Escape(e.Params[k]))
+	}
+	b.WriteString("\n")
+
+	if e.Report == "" {
+		b.WriteString("[blue]No report was stored for this operation.[white]\n")
+	} else if data, err := state.ReadFile(e.Tree, e.Report); err != nil {
+		fmt.Fprintf(&b, "[red]✗ The report cannot be read: %v[white]\n", tview.Escape(err.Error()))
+	} else {
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != e.ReportSum {
+			b.WriteString("[red]✗ The report was changed after it was recorded.[white]\n\n")
+		}
+		b.WriteString(tview.Escape(string(data)))
+	}
+
+	view := tview.NewTextView().SetDynamicColors(true).SetScrollable(true).SetWrap(true).
+		SetText(tui.colored(b.String()))
+	view.SetDoneFunc(func(tcell.Key) {
+		tui.pages.RemovePage(reportPage)
+		_, history := tui.pages.GetFrontPage()
+		tui.app.SetFocus(history)
+	})
+	view.SetBorder(true).SetTitle(fmt.Sprintf("%s report (Esc: back)", e.Operation))
+	tui.pages.AddPage(reportPage, view, true, true)
+	tui.app.SetFocus(view)
+}
+
+// refreshVerifiedBadge looks up in the background whether the current
+// root hash was verified before, and with what result.
+func (tui *MerkleTUI) refreshVerifiedBadge() {
+	tree, root := tui.treePath, tui.rootHash
+	tui.spawn(func() {
+		name, err := oplog.Path()
+		if err != nil || name == "" {
+			return
+		}
+		entries, err := oplog.Read(name)
+		if err != nil {
+			return
+		}
+		result := ""
+		for _, e := range entries {
+			if e.Operation == "verify" && e.Tree == tree && e.RootHash == root {
+				result = e.Result
+			}
+		}
+		tui.app.QueueUpdateDraw(func() {
+			// A verification run meanwhile is newer
+			if tui.treePath == tree && tui.rootHash == root && tui.verified == "" {
+				tui.verified = result
+				tui.updateStatus(tui.statusMessage)
+			}
+		})
+	})
+}
+
+// closePage removes a page shown over the main one and returns to the
+// menu.
+func (tui *MerkleTUI) closePage(name string) {
+	tui.pages.RemovePage(name)
+	tui.app.SetFocus(tui.menu)
+}
+
+func entryTime(e oplog.Entry) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, e.Time)
+	return t
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,167 @@
+package ui
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"MTFS/forensic"
+	"MTFS/inclusion"
+	"MTFS/manifest"
+
+	"github.com/rivo/tview"
+)
+
+// proveFile asks for an entry of the tree to prove is part of it, then
+// for the file to write the proof to.
+func (tui *MerkleTUI) proveFile() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	if strings.HasPrefix(tui.hashFormat, "git") {
+		tui.writeOutput(fmt.Sprintf("[red]✗ The tree is hashed in the %s format; inclusion proofs need an mtfs format.[white]", tui.hashFormat))
+		return
+	}
+	tui.currentAction = "proof_path"
+	tui.updateStatus("Proving inclusion...")
+	tui.writeOutput("[yellow]═══ Inclusion Proof ═══[white]")
+	tui.writeOutput("[blue]Enter a path of the tree, relative to it or absolute; the proof lists the sibling hashes from it up to the root.[white]")
+	tui.input.SetText("")
+	tui.input.SetLabel("Path in tree: ")
+	tui.app.SetFocus(tui.input)
+}
+
+// setProofPath takes the entry to prove and asks where to write the
+// proof.
+func (tui *MerkleTUI) setProofPath(text string) {
+	p := strings.TrimSpace(text)
+	if filepath.IsAbs(p) {
+		rel, err := filepath.Rel(tui.treePath, p)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			tui.writeOutput(fmt.Sprintf("[red]✗ %s is not inside %s[white]", tview.Escape(p), tview.Escape(tui.treePath)))
+			return
+		}
+		p = rel
+	}
+	p = path.Clean(filepath.ToSlash(p))
+	if p == "." || p == "" {
+		tui.writeOutput("[red]✗ Enter a path inside the tree.[white]")
+		return
+	}
+	tui.proofPath = p
+	tui.currentAction = "proof_file"
+	tui.input.SetText(path.Base(p) + ".proof.json")
+	tui.input.SetLabel("Proof file: ")
+}
+
+// setProofFile takes the file to write the proof to and exports the tree
+// to prove from.
+func (tui *MerkleTUI) setProofFile(text string) {
+	out := strings.TrimSpace(text)
+	if out == "" {
+		tui.writeOutput("[red]✗ Enter a file name for the proof.[white]")
+		return
+	}
+	if err := forensic.CheckOutput(tui.treePath, out); err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		return
+	}
+	tui.proofFile = out
+	tui.currentAction = "proof_export"
+	tui.requestExport()
+	tui.input.SetLabel("Input: ")
+	tui.app.SetFocus(tui.menu)
+}
+
+// processProofOutput proves the chosen entry is part of the exported
+// tree and writes the proof.
+func (tui *MerkleTUI) processProofOutput(data []byte) {
+	tui.currentAction = ""
+	root, err := manifest.Parse(data)
+	var proof *inclusion.Proof
+	if err == nil {
+		proof, err = inclusion.Prove(root, tui.proofPath)
+	}
+	if err == nil {
+		err = proof.Save(tui.proofFile)
+	}
+	tui.record("prove", tui.rootHash, err, "path", tui.proofPath, "file", tui.proofFile)
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", tview.Escape(err.Error())))
+		tui.updateStatus("Proof failed")
+		return
+	}
+
+	tui.writeOutput(fmt.Sprintf("[cyan]%s (%s): %s[white]", tview.Escape(proof.Path), proof.Type, proof.LeafHash))
+	for _, level := range proof.Levels {
+		dir := level.Directory + "/"
+		if level.Directory == "." {
+			dir = "root"
+		}
+		tui.writeOutput(fmt.Sprintf("  [blue]%s[white] %d siblings", tview.Escape(dir), len(level.Siblings)))
+	}
+	tui.writeOutput(fmt.Sprintf("[cyan]🔐 Root hash: %s[white]", proof.RootHash))
+	tui.writeOutput(fmt.Sprintf("[green]✓ Proof written to %s[white]", tview.Escape(tui.proofFile)))
+	tui.updateStatus("Ready")
+}
+
+// verifyProof asks for a proof written by proveFile, then for the root
+// hash to check it against.
+func (tui *MerkleTUI) verifyProof() {
+	tui.currentAction = "proof_load"
+	tui.updateStatus("Verifying inclusion proof...")
+	tui.writeOutput("[yellow]═══ Verify Inclusion Proof ═══[white]")
+	tui.input.SetText("")
+	tui.input.SetLabel("Proof file: ")
+	tui.app.SetFocus(tui.input)
+}
+
+// loadProof reads the proof to verify and asks for the known root hash,
+// that of the current tree by default.
+func (tui *MerkleTUI) loadProof(text string) {
+	proof, err := inclusion.Load(strings.TrimSpace(text))
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", tview.Escape(err.Error())))
+		return
+	}
+	tui.proof = proof
+	tui.currentAction = "proof_root"
+	tui.writeOutput(fmt.Sprintf("[blue]Proof of %s, recording root hash %s. Enter the root hash you trust.[white]", tview.Escape(proof.Path), proof.RootHash))
+	tui.input.SetText(tui.rootHash)
+	tui.input.SetLabel("Root hash: ")
+}
+
+// checkProof verifies the loaded proof against the root hash entered
+// and, when that is the root hash of the current tree, that the file the
+// proof is about still has the proven content.
+func (tui *MerkleTUI) checkProof(text string) {
+	rootHash := strings.TrimSpace(text)
+	proof := tui.proof
+	tui.proof, tui.currentAction = nil, ""
+	tui.input.SetLabel("Input: ")
+	tui.app.SetFocus(tui.menu)
+
+	if rootHash == "" {
+		rootHash = proof.RootHash
+		tui.writeOutput("[yellow]Warning: no root hash given; the proof is only checked against the one it records.[white]")
+	}
+	err := proof.Verify(rootHash)
+	tui.record("verify-proof", rootHash, err, "path", proof.Path)
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %s: %s[white]", tview.Escape(proof.Path), tview.Escape(err.Error())))
+		tui.updateStatus("Proof rejected")
+		return
+	}
+	tui.writeOutput(fmt.Sprintf("[green]✓ %s (%s) is in the tree with root %s[white]", tview.Escape(proof.Path), proof.LeafHash, rootHash))
+	if tui.treeBuilt && rootHash == tui.rootHash && proof.Type == "file" {
+		file := filepath.Join(tui.treePath, filepath.FromSlash(proof.Path))
+		if err := proof.CheckFile(file); err != nil {
+			tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", tview.Escape(err.Error())))
+		} else {
+			tui.writeOutput(fmt.Sprintf("[green]✓ %s has the proven content[white]", tview.Escape(file)))
+		}
+	}
+	tui.updateStatus("Ready")
+}
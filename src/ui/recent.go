@@ -0,0 +1,125 @@
+package ui
+
+import (
+	"fmt"
+
+	"MTFS/monitor"
+	"MTFS/paths"
+	"MTFS/registry"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// recentPage lists the registered trees to open one.
+const recentPage = "recent"
+
+// openRecent lists the trees of the registry, most recently used first.
+// Selecting one builds it.
+func (tui *MerkleTUI) openRecent() {
+	trees, err := registry.Load()
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		return
+	}
+	if len(trees) == 0 {
+		tui.writeOutput("[blue]No trees are registered yet. Built trees are added, as are those given to `mtfs trees add`.[white]")
+		return
+	}
+	tui.chooseTree("Open recent tree (Enter: build, Esc: close)", trees, func(t registry.Tree) string {
+		if t.RootHash == "" {
+			return ""
+		}
+		return "  root " + shortHash(t.RootHash)
+	})
+}
+
+// attachDaemon lists the registered trees a daemon is watching, with the
+// root hash of its last scan. Selecting one builds it, so it can be
+// compared with what the daemon saw.
+func (tui *MerkleTUI) attachDaemon() {
+	trees, err := registry.Load()
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		return
+	}
+	var watched []registry.Tree
+	for _, t := range trees {
+		if monitor.Watching(t.Path) {
+			watched = append(watched, t)
+		}
+	}
+	if len(watched) == 0 {
+		tui.writeOutput("[blue]No daemon is watching a registered tree. Start one with `mtfs daemon DIR`.[white]")
+		return
+	}
+	tui.chooseTree("Trees watched by a daemon (Enter: build, Esc: close)", watched, func(t registry.Tree) string {
+		root, err := monitor.LastScan(t.Path)
+		if err != nil || root == nil {
+			return "  not scanned yet"
+		}
+		return "  last scan root " + shortHash(root.Hash)
+	})
+}
+
+// chooseTree lists trees under title, each detailed by its path, what
+// detail returns and its store. Selecting one builds it.
+func (tui *MerkleTUI) chooseTree(title string, trees []registry.Tree, detail func(registry.Tree) string) {
+	list := tview.NewList()
+	for i, t := range trees {
+		text := t.Path + detail(t)
+		if s := t.Store(); s != "" {
+			text += "  store " + s
+		}
+		var shortcut rune
+		if i < 9 {
+			shortcut = rune('1' + i)
+		}
+		path := t.Path
+		list.AddItem(tview.Escape(t.Name), tview.Escape(text), shortcut, func() {
+			tui.closePage(recentPage)
+			tui.buildTree()
+			tui.input.SetText(path)
+			tui.handleInput()
+		})
+	}
+	list.SetDoneFunc(func() { tui.closePage(recentPage) })
+	list.SetSelectedTextColor(tcell.ColorBlack)
+	list.SetSelectedBackgroundColor(tcell.ColorWhite)
+	list.SetBorder(true).SetTitle(title)
+	tui.pages.AddPage(recentPage, list, true, true)
+	tui.app.SetFocus(list)
+}
+
+// shortHash cuts hash to its first 16 digits.
+func shortHash(hash string) string {
+	if len(hash) > 16 {
+		return hash[:16] + "…"
+	}
+	return hash
+}
+
+// register notes the root hash of tree, and the store its chunks went
+// to if any, in the registry.
+func (tui *MerkleTUI) register(tree, root, store string) {
+	if tree == "" {
+		return
+	}
+	if err := registry.Record(tree, root, store); err != nil {
+		tui.log.Warn("tree not recorded in the registry", "tree", tree, "err", err)
+	}
+}
+
+// registered returns the registry entry of the current tree.
+func (tui *MerkleTUI) registered() (registry.Tree, bool) {
+	trees, err := registry.Load()
+	if err != nil {
+		return registry.Tree{}, false
+	}
+	for _, t := range trees {
+		if paths.Same(t.Path, tui.treePath) {
+			return t, true
+		}
+	}
+	return registry.Tree{}, false
+}
@@ -0,0 +1,210 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"MTFS/settings"
+	"MTFS/store"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// setupPage is the page the setup wizard shows its steps on.
+const setupPage = "setup"
+
+// applyTheme colors the interface after theme. The plain theme, like
+// NO_COLOR, drops colors unless ColorsEnv turns them on.
+func (tui *MerkleTUI) applyTheme(theme string) {
+	switch os.Getenv(ColorsEnv) {
+	case "off", "0", "false", "never":
+		tui.plain = true
+	case "":
+		tui.plain = os.Getenv("NO_COLOR") != "" || theme == "plain"
+	default:
+		tui.plain = false
+	}
+
+	border, title, selected := tview.Styles.BorderColor, tview.Styles.TitleColor, tcell.ColorWhite
+	if theme == "high-contrast" {
+		border, title, selected = tcell.ColorYellow, tcell.ColorYellow, tcell.ColorYellow
+	}
+	for _, box := range []*tview.Box{tui.menu.Box, tui.output.Box, tui.input.Box, tui.status.Box} {
+		box.SetBorderColor(border).SetTitleColor(title)
+	}
+	tui.menu.SetSelectedBackgroundColor(selected)
+	tui.updateStatus("Ready")
+}
+
+// runSetup walks the user through the settings on first launch, saves
+// them and only then starts the backend, which depends on them.
+func (tui *MerkleTUI) runSetup() {
+	s := settings.Settings{Engine: settings.BundledEngine, HashFormat: settings.HashFormats[0], Theme: settings.Themes[0]}
+	tui.setupWizard(s, false)
+}
+
+// editSettings runs the setup wizard again from the saved settings. A
+// changed engine or hash format restarts the backend, which forgets the
+// tree it built.
+func (tui *MerkleTUI) editSettings() {
+	s, _, err := settings.Load()
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		return
+	}
+	if s.Engine == "" {
+		s.Engine = settings.BundledEngine
+	}
+	if s.HashFormat == "" {
+		s.HashFormat = settings.HashFormats[0]
+	}
+	if s.Theme == "" {
+		s.Theme = settings.Themes[0]
+	}
+	tui.setupWizard(s, true)
+}
+
+// setupWizard asks for each setting, starting from s, then saves them.
+// When editing, each step shows the current value and the first one can
+// be cancelled without saving.
+func (tui *MerkleTUI) setupWizard(s settings.Settings, editing bool) {
+	old := s
+	now := func(value string) string {
+		if !editing {
+			return ""
+		}
+		if value == "" {
+			value = "none"
+		}
+		return "\n\nNow: " + value
+	}
+
+	var askEngine, askEnginePath, askHashFormat, askStore, askTheme, finish func()
+	askEngine = func() {
+		intro, skip := "Welcome to MTFS. No settings were found, so a few questions first "+
+			"(they can be changed later under Settings).", "Skip setup"
+		if editing {
+			intro, skip = "Settings of MTFS, saved to the settings file.", "Cancel"
+		}
+		engine := s.Engine
+		switch engine {
+		case settings.BundledEngine:
+			engine = "C++ backend"
+		case settings.GoEngine:
+			engine = "Go engine"
+		}
+		tui.setupModal(intro+"\n\nWhich engine should hash trees?"+now(engine),
+			[]string{"C++ backend", "Go engine", "Other executable", skip}, func(label string) {
+				switch {
+				case label == "C++ backend":
+					s.Engine = settings.BundledEngine
+					askHashFormat()
+				case label == "Go engine":
+					s.Engine = settings.GoEngine
+					askHashFormat()
+				case label == "Other executable":
+					askEnginePath()
+				case editing:
+					tui.pages.RemovePage(setupPage)
+					tui.app.SetFocus(tui.menu)
+				default:
+					finish()
+				}
+			})
+	}
+	askEnginePath = func() {
+		tui.setupInput("Engine", "Backend executable: ", s.Backend(), func(path string) {
+			if _, err := exec.LookPath(path); path == "" || err != nil {
+				tui.setupModal(fmt.Sprintf("%q is not an executable.", path), []string{"OK"}, func(string) { askEnginePath() })
+				return
+			}
+			s.Engine = path
+			askHashFormat()
+		}, askEngine)
+	}
+	askHashFormat = func() {
+		tui.setupModal("Which hash format should trees use when none is given?\n\n"+
+			"mtfs: MTFS's own Merkle hashes, with SHA-256\n"+
+			"mtfs-sha512, mtfs-blake2b, mtfs-blake3: the same with SHA-512, BLAKE2b or BLAKE3 (BLAKE3 needs the Go engine)\n"+
+			"git: Git blob and tree ids (SHA-1), matching git write-tree\n"+
+			"git-sha256: the same for SHA-256 repositories"+now(s.HashFormat),
+			settings.HashFormats, func(label string) {
+				s.HashFormat = label
+				askStore()
+			})
+	}
+	askStore = func() {
+		value := s.Store
+		if value == "" {
+			value = os.Getenv(store.DirEnv)
+		}
+		tui.setupInput("Default chunk store: a directory, an s3:// or gs:// URL, or none", "Store: ", value, func(dir string) {
+			s.Store = dir
+			askTheme()
+		}, askHashFormat)
+	}
+	askTheme = func() {
+		tui.setupModal("Which theme should the interface use?"+now(s.Theme), settings.Themes, func(label string) {
+			s.Theme = label
+			finish()
+		})
+	}
+	finish = func() {
+		tui.pages.RemovePage(setupPage)
+		tui.app.SetFocus(tui.menu)
+		tui.applyTheme(s.Theme)
+		if path, err := settings.Save(s); err != nil {
+			tui.log.Error("settings not saved", "err", err)
+		} else {
+			tui.writeOutput(fmt.Sprintf("[green]✓ Settings saved to %s[white]", path))
+		}
+		settings.Apply(s)
+		switch {
+		case tui.backend == nil:
+			tui.startBackend()
+		case s.Engine != old.Engine || s.HashFormat != old.HashFormat:
+			tui.restartBackend()
+		}
+	}
+	askEngine()
+}
+
+// setupModal shows a step of the setup wizard answered with a button.
+func (tui *MerkleTUI) setupModal(text string, buttons []string, done func(label string)) {
+	modal := tview.NewModal().
+		SetText(text).
+		AddButtons(buttons).
+		SetDoneFunc(func(_ int, label string) {
+			if label != "" {
+				done(label)
+			}
+		})
+	tui.pages.AddPage(setupPage, modal, true, true)
+	tui.app.SetFocus(modal)
+}
+
+// setupInput shows a step of the setup wizard answered with text. An
+// empty answer is passed on as it is.
+func (tui *MerkleTUI) setupInput(title, label, value string, next func(string), back func()) {
+	form := tview.NewForm().AddInputField(label, value, 50, nil, nil)
+	field := form.GetFormItem(0).(*tview.InputField)
+	form.AddButton("Next", func() { next(field.GetText()) }).
+		AddButton("Back", back).
+		SetCancelFunc(back)
+	form.SetBorder(true).SetTitle(title)
+	tui.pages.AddPage(setupPage, centered(form, 70, 7), true, true)
+	tui.app.SetFocus(form)
+}
+
+// centered places p in the middle of the screen.
+func centered(p tview.Primitive, width, height int) tview.Primitive {
+	return tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(p, height, 1, true).
+			AddItem(nil, 0, 1, false), width, 1, true).
+		AddItem(nil, 0, 1, false)
+}
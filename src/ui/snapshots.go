@@ -0,0 +1,311 @@
+package ui
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"MTFS/manifest"
+	"MTFS/monitor"
+	"MTFS/snapshot"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+const (
+	// namedSnapshotsPage lists the named snapshots of the current tree.
+	namedSnapshotsPage = "named-snapshots"
+	// namedSnapshotPage shows a named snapshot opened from the list.
+	namedSnapshotPage = "named-snapshot"
+	// namedSnapshotDeletePage asks whether to delete a named snapshot.
+	namedSnapshotDeletePage = "named-snapshot-delete"
+)
+
+// showNamedSnapshots lists the named snapshots of the current tree,
+// newest first. Selecting one shows what it recorded; n saves the tree as
+// it was built under a new name and d deletes the selected snapshot. c
+// compares the selected snapshot with the one marked with Space, or with
+// the tree as last built if none is.
+func (tui *MerkleTUI) showNamedSnapshots() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	var list []snapshot.Snapshot
+	marked := ""
+	table := tview.NewTable().SetSelectable(true, false).SetFixed(1, 0)
+	fill := func() error {
+		var err error
+		if list, err = snapshot.List(tui.treePath); err != nil {
+			return err
+		}
+		table.Clear()
+		for col, title := range []string{"Name", "Time", "Root hash", "Files", "Size"} {
+			table.SetCell(0, col, tview.NewTableCell(title).SetSelectable(false).SetTextColor(tcell.ColorYellow).SetExpansion(1))
+		}
+		if len(list) == 0 {
+			table.SetCell(1, 0, tview.NewTableCell("No snapshots are saved yet; press n to save the tree.").SetSelectable(false))
+		}
+		for i, s := range list {
+			root := shortHash(s.RootHash)
+			color := tview.Styles.PrimaryTextColor
+			if s.RootHash == tui.rootHash && !tui.plain {
+				// Snapshots of the tree as it is now stand out
+				color = tcell.ColorGreen
+			}
+			name := s.Name
+			if name == marked {
+				name = "● " + name
+			}
+			for col, text := range []string{name, s.Time.Local().Format("2006-01-02 15:04:05"), root, groupDigits(s.Files), tui.size(s.Bytes)} {
+				table.SetCell(i+1, col, tview.NewTableCell(tview.Escape(text)).SetExpansion(1).SetTextColor(color))
+			}
+		}
+		return nil
+	}
+	if err := fill(); err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		return
+	}
+	table.Select(1, 0)
+
+	table.SetSelectedFunc(func(row, _ int) {
+		if row > 0 && row <= len(list) {
+			tui.showNamedSnapshot(list[row-1].Name)
+		}
+	})
+	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() != tcell.KeyRune {
+			return event
+		}
+		switch event.Rune() {
+		case 'n':
+			tui.closePage(namedSnapshotsPage)
+			tui.askSnapshotName()
+			return nil
+		case 'd':
+			if row, _ := table.GetSelection(); row > 0 && row <= len(list) {
+				tui.confirmSnapshotDelete(list[row-1].Name, table, fill)
+			}
+			return nil
+		case ' ':
+			if row, _ := table.GetSelection(); row > 0 && row <= len(list) {
+				if marked == list[row-1].Name {
+					marked = ""
+				} else {
+					marked = list[row-1].Name
+				}
+				fill()
+			}
+			return nil
+		case 'c':
+			row, _ := table.GetSelection()
+			if row < 1 || row > len(list) {
+				return nil
+			}
+			selected := list[row-1].Name
+			tui.closePage(namedSnapshotsPage)
+			if marked != "" && marked != selected {
+				tui.diffSnapshots(marked, selected)
+			} else {
+				tui.diffWithSnapshot(selected)
+			}
+			return nil
+		}
+		return event
+	})
+	table.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			tui.closePage(namedSnapshotsPage)
+		}
+	})
+	table.SetBorder(true).SetTitle(fmt.Sprintf("Snapshots of %s (Enter: inspect, n: save, d: delete, Space: mark, c: compare, Esc: close)", tui.treePath))
+	tui.pages.AddPage(namedSnapshotsPage, table, true, true)
+	tui.app.SetFocus(table)
+}
+
+// askSnapshotName asks for the name to save the current tree under.
+func (tui *MerkleTUI) askSnapshotName() {
+	tui.currentAction = "snapshot_name"
+	tui.updateStatus("Saving snapshot...")
+	tui.writeOutput("[yellow]═══ Named Snapshot ═══[white]")
+	tui.writeOutput("[blue]The root hash, the entries and the walk options of the tree as it was built are saved under a name of letters, digits, dots, dashes and underscores.[white]")
+	tui.input.SetText(time.Now().Format("2006-01-02_150405"))
+	tui.input.SetLabel("Snapshot name: ")
+	tui.app.SetFocus(tui.input)
+}
+
+// setSnapshotName saves the current tree under the name entered.
+func (tui *MerkleTUI) setSnapshotName(text string) {
+	name := strings.TrimSpace(text)
+	if err := snapshot.CheckName(name); err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", tview.Escape(err.Error())))
+		return
+	}
+	tui.snapshotName = name
+	tui.currentAction = "snapshot_export"
+	tui.input.SetLabel("Input: ")
+	tui.app.SetFocus(tui.menu)
+	tui.requestExport()
+}
+
+// processSnapshotOutput saves the exported tree as a named snapshot.
+func (tui *MerkleTUI) processSnapshotOutput(data []byte) {
+	tui.currentAction = ""
+	s, err := snapshot.New(tui.treePath, tui.snapshotName, data)
+	if err == nil {
+		err = snapshot.Save(tui.treePath, s)
+	}
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", tview.Escape(err.Error())))
+		tui.record("snapshot-save", tui.rootHash, err, "name", tui.snapshotName)
+		tui.updateStatus("Snapshot failed")
+		return
+	}
+	tui.record("snapshot-save", s.RootHash, nil, "name", s.Name)
+	tui.writeOutput(fmt.Sprintf("[green]✓ Saved snapshot %s (%s files, %s)[white]", tview.Escape(s.Name), groupDigits(s.Files), tui.size(s.Bytes)))
+	tui.writeOutput(fmt.Sprintf("[cyan]🔐 Root hash: %s[white]", s.RootHash))
+	tui.updateStatus("Ready")
+}
+
+// confirmSnapshotDelete asks whether to delete the snapshot named name
+// and lists the snapshots again once it is.
+func (tui *MerkleTUI) confirmSnapshotDelete(name string, table *tview.Table, fill func() error) {
+	modal := tview.NewModal().
+		SetText(fmt.Sprintf("Delete snapshot %s of %s?", name, tui.treePath)).
+		AddButtons([]string{"Delete", "Cancel"}).
+		SetDoneFunc(func(_ int, label string) {
+			tui.pages.RemovePage(namedSnapshotDeletePage)
+			tui.app.SetFocus(table)
+			if label != "Delete" {
+				return
+			}
+			err := snapshot.Delete(tui.treePath, name)
+			tui.record("snapshot-delete", "", err, "name", name)
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", tview.Escape(err.Error())))
+				return
+			}
+			tui.writeOutput(fmt.Sprintf("[blue]Deleted snapshot %s.[white]", tview.Escape(name)))
+			row, _ := table.GetSelection()
+			if err := fill(); err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+			}
+			table.Select(max(1, min(row, table.GetRowCount()-1)), 0)
+		})
+	tui.pages.AddPage(namedSnapshotDeletePage, modal, true, true)
+	tui.app.SetFocus(modal)
+}
+
+// showNamedSnapshot shows what the snapshot named name recorded, with
+// every entry of its tree.
+func (tui *MerkleTUI) showNamedSnapshot(name string) {
+	var b strings.Builder
+	s, err := snapshot.Load(tui.treePath, name)
+	var root *manifest.Node
+	if err == nil {
+		root, err = s.Root()
+	}
+	if err != nil {
+		fmt.Fprintf(&b, "[red]✗ %s[white]\n", tview.Escape(err.Error()))
+	} else {
+		fmt.Fprintf(&b, "[yellow]%s[white] by %s@%s at %s\n", tview.Escape(s.Name), s.User, s.Host, s.Time.Local().Format(time.RFC3339))
+		fmt.Fprintf(&b, "Root hash: %s\n", s.RootHash)
+		if s.RootHash == tui.rootHash {
+			b.WriteString("[green]✓ The tree as last built has this root hash.[white]\n")
+		} else {
+			b.WriteString("[yellow]The tree as last built has another root hash.[white]\n")
+		}
+		fmt.Fprintf(&b, "Hash format: %s\n", s.HashFormat)
+		fmt.Fprintf(&b, "%s files in %s directories, %s\n", groupDigits(s.Files), groupDigits(s.Directories), tui.size(s.Bytes))
+		options := make([]string, 0, len(s.Options))
+		for k := range s.Options {
+			options = append(options, k)
+		}
+		sort.Strings(options)
+		for _, k := range options {
+			fmt.Fprintf(&b, "%s: %s\n", k, tview.Escape(s.Options[k]))
+		}
+		b.WriteString("\n[yellow]Entries[white]\n")
+		root.Walk(func(p string, n *manifest.Node) error {
+			if p == "." {
+				return nil
+			}
+			size := ""
+			if n.IsFile() {
+				size = " (" + tui.size(n.Size) + ")"
+			}
+			fmt.Fprintf(&b, "%s  %s%s\n", shortHash(n.Hash), tview.Escape(p), size)
+			return nil
+		})
+	}
+
+	view := tview.NewTextView().SetDynamicColors(true).SetScrollable(true).SetWrap(false).
+		SetText(tui.colored(b.String()))
+	view.SetDoneFunc(func(tcell.Key) {
+		tui.pages.RemovePage(namedSnapshotPage)
+		_, list := tui.pages.GetFrontPage()
+		tui.app.SetFocus(list)
+	})
+	view.SetBorder(true).SetTitle(fmt.Sprintf("Snapshot %s (Esc: back)", name))
+	tui.pages.AddPage(namedSnapshotPage, view, true, true)
+	tui.app.SetFocus(view)
+}
+
+// diffSnapshots shows what turns the snapshot named old into the one
+// named cur, going down only into the directories whose hashes differ.
+func (tui *MerkleTUI) diffSnapshots(old, cur string) {
+	tui.writeOutput(fmt.Sprintf("[yellow]═══ Comparing snapshot %s with %s ═══[white]", tview.Escape(old), tview.Escape(cur)))
+	var roots [2]*manifest.Node
+	var err error
+	for i, name := range []string{old, cur} {
+		if err == nil {
+			roots[i], err = snapshot.Open(tui.treePath, name)
+		}
+	}
+	var c *monitor.Comparison
+	if err == nil {
+		c, err = monitor.CompareRoots(old, cur, roots[0], roots[1])
+	}
+	tui.processSnapshotDiff(c, err, old, cur)
+}
+
+// diffWithSnapshot shows what turns the snapshot named name into the
+// tree as last built, once it is exported.
+func (tui *MerkleTUI) diffWithSnapshot(name string) {
+	tui.writeOutput(fmt.Sprintf("[yellow]═══ Comparing snapshot %s with the tree ═══[white]", tview.Escape(name)))
+	tui.snapshotName = name
+	tui.currentAction = "snapshot_diff_export"
+	tui.updateStatus("Comparing with snapshot...")
+	tui.requestExport()
+}
+
+// processSnapshotDiffOutput compares the exported tree with the snapshot
+// chosen.
+func (tui *MerkleTUI) processSnapshotDiffOutput(data []byte) {
+	tui.currentAction = ""
+	old, err := snapshot.Open(tui.treePath, tui.snapshotName)
+	var cur *manifest.Node
+	if err == nil {
+		cur, err = manifest.Parse(data)
+	}
+	var c *monitor.Comparison
+	if err == nil {
+		c, err = monitor.CompareRoots(tui.snapshotName, tui.treePath, old, cur)
+	}
+	tui.processSnapshotDiff(c, err, tui.snapshotName, tui.treePath)
+}
+
+func (tui *MerkleTUI) processSnapshotDiff(c *monitor.Comparison, err error, old, cur string) {
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", tview.Escape(err.Error())))
+		tui.record("diff", "", err, "old", old, "new", cur)
+		tui.updateStatus("Comparison failed")
+		return
+	}
+	tui.record("diff", c.Roots[1].Hash, nil, "old", old, "new", cur)
+	tui.showComparison(c)
+	tui.updateStatus("Ready")
+}
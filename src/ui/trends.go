@@ -0,0 +1,120 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"MTFS/trends"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+const (
+	// trendsPage charts the size and churn of the current tree.
+	trendsPage = "trends"
+	// sparkWidth is how many samples the sparklines span.
+	sparkWidth = 60
+	// recentSamples is how many samples the bar charts list.
+	recentSamples = 10
+	// barWidth is the width of the longest bar.
+	barWidth = 24
+)
+
+// showTrends charts the total size, file count and changed files of the
+// current tree over its builds, daemon scans and snapshot verifications,
+// and warns when the last of them grew or changed it far more than
+// usual.
+func (tui *MerkleTUI) showTrends() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	history, err := trends.Load(tui.treePath)
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		return
+	}
+	if len(history) == 0 {
+		tui.writeOutput(fmt.Sprintf("[blue]No builds, scans or verifications of %s are recorded yet.[white]", tui.treePath))
+		return
+	}
+
+	size := trends.Of(history, func(s trends.Sample) int64 { return s.Bytes })
+	files := trends.Of(history, func(s trends.Sample) int64 { return s.Files })
+	changed := trends.Of(history, func(s trends.Sample) int64 { return s.Changed })
+	bytes := func(v float64) string { return tui.size(int64(v)) }
+	count := func(v float64) string { return groupDigits(int64(v)) }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d samples since %s\n\n", len(history), history[0].Time.Local().Format("2006-01-02 15:04"))
+	if trends.Unusual(size.Values, true) {
+		last := size.Values[len(size.Values)-2:]
+		fmt.Fprintf(&b, "[red]⚠ Unusual growth: the size changed by %s, over %d times the usual change.[white]\n", trends.FormatChange(last[0], last[1], bytes), trends.UnusualFactor)
+	}
+	if trends.Unusual(files.Values, true) {
+		last := files.Values[len(files.Values)-2:]
+		fmt.Fprintf(&b, "[red]⚠ Unusual growth: the file count changed by %s, over %d times the usual change.[white]\n", trends.FormatChange(last[0], last[1], count), trends.UnusualFactor)
+	}
+	if trends.Unusual(changed.Values, false) {
+		fmt.Fprintf(&b, "[red]⚠ Unusual churn: %s paths changed, over %d times the usual number.[white]\n", count(changed.Values[len(changed.Values)-1]), trends.UnusualFactor)
+	}
+
+	b.WriteString(tui.sparkline("Total size", size, bytes, true))
+	b.WriteString(tui.sparkline("Files", files, count, true))
+	b.WriteString(tui.sparkline("Changed", changed, count, false))
+
+	b.WriteString("\n[yellow]Recent samples[white]\n")
+	recent := history
+	if len(recent) > recentSamples {
+		recent = recent[len(recent)-recentSamples:]
+	}
+	var maxBytes, maxChanged float64
+	for _, s := range recent {
+		maxBytes = max(maxBytes, float64(s.Bytes))
+		maxChanged = max(maxChanged, float64(s.Changed))
+	}
+	for _, s := range recent {
+		sizeText, sizeBar := "-", strings.Repeat(" ", barWidth)
+		if s.Bytes >= 0 {
+			sizeText, sizeBar = tui.size(s.Bytes), trends.Bar(float64(s.Bytes), maxBytes, barWidth)
+		}
+		changedText, changedBar := "-", ""
+		if s.Changed >= 0 {
+			changedText, changedBar = count(float64(s.Changed)), trends.Bar(float64(s.Changed), maxChanged, barWidth/2)
+		}
+		fmt.Fprintf(&b, "%s  %-6s [blue]%s[white] %10s  %8s files  [magenta]%s[white] %s changed\n",
+			s.Time.Local().Format("2006-01-02 15:04"), s.Operation, sizeBar, sizeText, count(float64(s.Files)), changedBar, changedText)
+	}
+
+	view := tview.NewTextView().SetDynamicColors(true).SetScrollable(true).SetWrap(false).
+		SetText(tui.colored(b.String()))
+	view.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEscape {
+			tui.closePage(trendsPage)
+		}
+	})
+	view.SetBorder(true).SetTitle(fmt.Sprintf("Trends of %s (Esc: close)", tui.treePath))
+	tui.pages.AddPage(trendsPage, view, true, true)
+	tui.app.SetFocus(view)
+}
+
+// sparkline writes a line of the trends page: the newest values of s as a
+// sparkline, then the change from the first to the last of them when
+// change is set, or their range otherwise.
+func (tui *MerkleTUI) sparkline(label string, s trends.Series, format func(float64) string, change bool) string {
+	if len(s.Values) == 0 {
+		return fmt.Sprintf("%-11s [blue]not recorded yet[white]\n", label)
+	}
+	s = s.Last(sparkWidth)
+	lo, hi := s.Values[0], s.Values[0]
+	for _, v := range s.Values {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	first, last := s.Values[0], s.Values[len(s.Values)-1]
+	summary := fmt.Sprintf("min %s, max %s", format(lo), format(hi))
+	if change {
+		summary = fmt.Sprintf("%s → %s (%s), %s", format(first), format(last), trends.FormatChange(first, last, format), summary)
+	}
+	return fmt.Sprintf("%-11s [green]%s[white]  %s\n", label, trends.Sparkline(s.Values), summary)
+}
@@ -1,15 +1,48 @@
 package ui
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"log/slog"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	"MTFS/attest"
+	"MTFS/audit"
+	"MTFS/backend"
+	"MTFS/bundle"
+	"MTFS/chunking"
+	"MTFS/forensic"
+	"MTFS/hooks"
+	"MTFS/inclusion"
+	"MTFS/ipfs"
+	"MTFS/logging"
+	"MTFS/manifest"
+	"MTFS/oplog"
+	"MTFS/paths"
+	"MTFS/protocol"
+	"MTFS/quota"
+	"MTFS/registry"
+	"MTFS/remote"
+	"MTFS/settings"
+	"MTFS/signing"
+	"MTFS/spdx"
+	"MTFS/state"
+	"MTFS/store"
+	"MTFS/timestamp"
+	"MTFS/torrent"
+	"MTFS/translog"
+	"MTFS/trends"
+	"MTFS/tuf"
+	"MTFS/verity"
+	"MTFS/watch"
+
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 )
@@ -21,27 +54,119 @@ type MerkleTUI struct {
 	output        *tview.TextView
 	input         *tview.InputField
 	status        *tview.TextView
-	cppProcess    *exec.Cmd
-	stdin         io.WriteCloser
-	stdout        io.ReadCloser
-	scanner       *bufio.Scanner
+	workers       *tview.TextView // files the workers of a build are hashing, hidden when idle
+	layout        *tview.Flex
+	backend       backend.Backend // nil until started
 	currentAction string
 	treeBuilt     bool
-	outputBuffer  []string
+	treePath      string
+	rootHash      string
+	signKeyID     string
+	statusMessage string
+	sigBadge      string
+	signed        bool   // the current root hash has a good signature
+	verified      string // result of the last verification of the current root hash
+	hashFormat    string // hash format the tree was last built with
+	chunkSize     int
+	builtChunk    int
+	chunking      chunking.Params // how the store and the duplicate finders cut files
+	builtChunking chunking.Params
+	storeDir      string
+	storeKey      store.Key
+	storeUse      func(store.Key) // what to do once the store is chosen
+	extractStore  *store.Store
+	extractRoot   string
+	extractFile   store.SnapshotFile
+	bundlePath    string
+	bundlePass    string
+	cidQuery      string
+	torrentPath   string
+	spdxPath      string
+	exportPath    string         // file option 6 writes to, "" to show the JSON
+	importPath    string         // export the tree is verified against
+	imported      *manifest.Node // and its tree
+	similarMin    float64
+	snapshotName  string // name to save the tree under or compare it with
+	chunkMapPath  string
+	proofPath     string           // entry of the tree to prove
+	proofFile     string           // file to write its proof to
+	proof         *inclusion.Proof // proof to verify
+	watcher       *watch.Watcher   // nil unless a directory is watched
+	baseline      *audit.Baseline
+	includes      []string // include patterns entered, awaiting the excludes
+	backendArgs   []string
+	log           *logging.Logger
+	plain         bool
+	rawSizes      bool
+	treeFiles     int64
+	treeDirs      int64
+	treeBytes     int64
+	unreadable    int // error leaves of the last build
+	quotaAlerts   []quota.Alert
+	reportOp      string
+	reportStart   time.Time
+	reportLines   []string
+	verifyErr     error
+	protocol      transcript
+	crashState    crashState
+	quit          func()          // ends the session when Exit is chosen
+	onStatus      func()          // called when the status bar changes
+	peers         func() []string // trees open in other workspaces
+	lastFocus     tview.Primitive // focus to restore when switched back to
+}
+
+// ColorsEnv names the environment variable turning the colors of the
+// output pane and status bar on or off. Without it, colors are off if
+// NO_COLOR is set or the theme is plain.
+const ColorsEnv = "MTFS_COLORS"
+
+// colorTag matches the color tags of the output pane, which are left out
+// of the log file.
+var colorTag = regexp.MustCompile(`\[([a-zA-Z]+|#[0-9a-zA-Z]{6}|-)?(:([a-zA-Z]+|#[0-9a-zA-Z]{6}|-)?(:([lbidrus]+|-)?)?)?\]`)
+
+// NewMerkleTUI starts the backend with backendArgs (walk options such as
+// --include and --exclude) and sets up the interface around it.
+func NewMerkleTUI(backendArgs ...string) *MerkleTUI {
+	tui := newMerkleTUI(tview.NewApplication(), nil, backendArgs)
+	tui.app.SetInputCapture(tui.captureKey)
+	tui.app.SetBeforeDrawFunc(tui.watchScreen)
+	return tui
 }
 
-func NewMerkleTUI() *MerkleTUI {
-	app := tview.NewApplication()
-	
+// newMerkleTUI sets up a session on app with a backend of its own. It
+// logs to the log file of parent if given, otherwise it opens one.
+func newMerkleTUI(app *tview.Application, parent *logging.Logger, backendArgs []string) *MerkleTUI {
 	tui := &MerkleTUI{
-		app:          app,
-		pages:        tview.NewPages(),
-		outputBuffer: make([]string, 0),
+		app:         app,
+		pages:       tview.NewPages(),
+		chunkSize:   1024 * 1024,
+		chunking:    chunking.Fixed(1024 * 1024),
+		backendArgs: backendArgs,
+		quit:        app.Stop,
 	}
-	
+
 	tui.setupUI()
-	tui.startCppProcess()
-	
+	display := logging.NewDisplayHandler(logging.DisplayLevel, tui.show)
+	if parent != nil {
+		tui.log = parent.WithDisplay(display)
+	} else {
+		log, err := logging.Open(display)
+		tui.log = log
+		if err != nil {
+			tui.log.Warn("logging to the output pane only", "err", err)
+		}
+	}
+	s, found, err := settings.Load()
+	if err != nil {
+		tui.log.Warn("settings not loaded", "err", err)
+	}
+	tui.applyTheme(s.Theme)
+	if found || err != nil {
+		tui.startBackend()
+	} else {
+		tui.runSetup()
+	}
+
 	return tui
 }
 
@@ -49,13 +174,43 @@ func (tui *MerkleTUI) setupUI() {
 	// Create main menu
 	tui.menu = tview.NewList().
 		AddItem("Build Merkle tree from directory", "Create tree structure", '1', tui.buildTree).
-		AddItem("Print tree structure", "Display tree hierarchy", '2', tui.printTree).
+		AddItem("Browse tree structure", "Expand directories, hashes and sizes", '2', tui.printTree).
 		AddItem("Print file objects", "Show file details", '3', tui.printFiles).
 		AddItem("Show statistics", "Display tree stats", '4', tui.showStats).
 		AddItem("Verify tree integrity", "Check tree validity", '5', tui.verifyTree).
-		AddItem("Export tree to JSON", "Export as JSON", '6', tui.exportJSON).
+		AddItem("Export tree", "As JSON, CBOR or protobuf", '6', tui.exportJSON).
 		AddItem("Set chunk size", "Configure chunk size", '7', tui.setChunkSize).
-		AddItem("Exit", "Quit application", '8', tui.exit)
+		AddItem("Choose hash algorithm", "SHA-256, SHA-512, BLAKE2b or BLAKE3", '9', tui.chooseHashFormat).
+		AddItem("Include / exclude patterns", "Hash only some files, skip others", 'F', tui.setFilters).
+		AddItem("Sign export and root hash", "Detached GPG signatures", 's', tui.signExport).
+		AddItem("Timestamp root hash", "RFC 3161 trusted timestamp", 't', tui.timestampRoot).
+		AddItem("Publish root hash to transparency log", "Append-only log entry", 'l', tui.publishRoot).
+		AddItem("Export password-protected bundle", "Encrypted export and signatures", 'b', tui.exportBundle).
+		AddItem("Generate TUF metadata", "Signed root/targets/snapshot/timestamp", 'u', tui.generateTUF).
+		AddItem("Enable fs-verity on files", "Kernel-enforced file digests", 'f', tui.enableVerity).
+		AddItem("Cross-check fs-verity digests", "Compare kernel digests with the tree", 'k', tui.checkVerity).
+		AddItem("Compute IPFS CIDs", "List or look up UnixFS CIDs", 'i', tui.computeCIDs).
+		AddItem("Export BitTorrent v2 torrent", "Piece layers and info-hash", 'r', tui.exportTorrent).
+		AddItem("Export SPDX file entries", "Checksums and sizes for SBOMs", 'x', tui.exportSPDX).
+		AddItem("Audit against hashdeep baseline or checksums", "Matched, moved, new, changed, missing", 'a', tui.auditTree).
+		AddItem("Store chunks in encrypted store", "AES-256-GCM object store", 'e', tui.storeChunks).
+		AddItem("Extract file from store", "Restore one file of a snapshot", 'g', tui.extractFromStore).
+		AddItem("Find duplicates", "Identical files and reclaimable space", 'd', tui.findDuplicates).
+		AddItem("Find similar files", "Pairs sharing most of their chunks", 'n', tui.findSimilar).
+		AddItem("Export chunk map", "Files and offsets of every chunk", 'm', tui.exportChunkMap).
+		AddItem("Prove file inclusion", "Sibling hashes from a path up to the root", 'j', tui.proveFile).
+		AddItem("Verify inclusion proof", "Check a proof against a known root hash", 'y', tui.verifyProof).
+		AddItem("Watch directory", "Re-hash changes as they happen; again to stop", 'q', tui.watchDirectory).
+		AddItem("Compare with another tree", "Added, removed and modified paths", 'c', tui.compareTrees).
+		AddItem("Import & verify export", "Paths that no longer match an earlier export", 'I', tui.importExport).
+		AddItem("Preview build (dry run)", "What a build would hash and skip", 'w', tui.previewBuild).
+		AddItem("Open recent tree", "Build a tree of the registry", 'o', tui.openRecent).
+		AddItem("Operation history", "Past builds, verifications and snapshots", 'h', tui.showHistory).
+		AddItem("Trends", "Size, file count and churn over time", 'v', tui.showTrends).
+		AddItem("Named snapshots", "Save, inspect and delete root hashes", '0', tui.showNamedSnapshots).
+		AddItem("Toggle exact byte sizes", "KiB/MiB/GiB or exact bytes", 'z', tui.toggleRawSizes).
+		AddItem("Settings", "Engine, hash format, store and theme", 'p', tui.editSettings).
+		AddItem("Exit", "Close this workspace; the last one quits", '8', tui.exit)
 
 	tui.menu.SetBorder(true).SetTitle("Merkle Tree File System CLI")
 	tui.menu.SetSelectedTextColor(tcell.ColorBlack)
@@ -77,16 +232,23 @@ func (tui *MerkleTUI) setupUI() {
 	// Create status bar
 	tui.status = tview.NewTextView().
 		SetDynamicColors(true).
-		SetText("[green]Ready[white] | Tree: [red]Not Built[white] | Press Tab to navigate")
+		SetText(tui.colored("[green]Ready[white] | Tree: [red]Not Built[white] | Press Tab to navigate"))
 	tui.status.SetBorder(true).SetTitle("Status")
 
+	// Create worker pane, shown while a build hashes files
+	tui.workers = tview.NewTextView().
+		SetDynamicColors(true)
+	tui.workers.SetBorder(true).SetTitle("Workers")
+
 	// Create main layout
 	mainLayout := tview.NewFlex().SetDirection(tview.FlexRow).
 		AddItem(tview.NewFlex().SetDirection(tview.FlexColumn).
 			AddItem(tui.menu, 0, 1, true).
-			AddItem(tui.output, 0, 2, false), 0, 4, false).
+			AddItem(tui.output, 0, 2, false), 0, 4, true).
+		AddItem(tui.workers, 0, 0, false).
 		AddItem(tui.input, 3, 0, false).
 		AddItem(tui.status, 3, 0, false)
+	tui.layout = mainLayout
 
 	// Input field handlers
 	tui.input.SetDoneFunc(func(key tcell.Key) {
@@ -95,103 +257,252 @@ func (tui *MerkleTUI) setupUI() {
 		}
 	})
 
-	// Set up key bindings
-	tui.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-		switch event.Key() {
-		case tcell.KeyTab:
-			if tui.app.GetFocus() == tui.menu {
-				tui.app.SetFocus(tui.input)
-			} else {
-				tui.app.SetFocus(tui.menu)
-			}
-			return nil
-		case tcell.KeyEscape:
+	tui.pages.AddPage("main", mainLayout, true, true)
+}
+
+// captureKey handles the key bindings of the session: Tab moves between
+// the menu and the input field, Escape returns to the menu.
+func (tui *MerkleTUI) captureKey(event *tcell.EventKey) *tcell.EventKey {
+	// Pages over the main one, such as the setup wizard, keep Tab and
+	// Escape for themselves
+	if front, _ := tui.pages.GetFrontPage(); front != "main" {
+		return event
+	}
+	switch event.Key() {
+	case tcell.KeyTab:
+		if tui.app.GetFocus() == tui.menu {
+			tui.app.SetFocus(tui.input)
+		} else {
 			tui.app.SetFocus(tui.menu)
-			return nil
 		}
-		return event
-	})
-
-	tui.pages.AddPage("main", mainLayout, true, true)
+		return nil
+	case tcell.KeyEscape:
+		tui.app.SetFocus(tui.menu)
+		return nil
+	}
+	return event
 }
 
-func (tui *MerkleTUI) startCppProcess() {
-	// Start the C++ executable
-	tui.cppProcess = exec.Command("merkle/mtfs")
-	
-	var err error
-	tui.stdin, err = tui.cppProcess.StdinPipe()
+// startBackend starts the engine chosen in the settings. Whether it
+// came up is shown once it answers.
+func (tui *MerkleTUI) startBackend() {
+	b, err := backend.Open(tui.backendArgs, tui.protocol.add)
 	if err != nil {
-		tui.writeOutput(fmt.Sprintf("[red]Error creating stdin pipe: %v[white]", err))
+		tui.log.Error("cannot start the backend", "path", remote.BackendPath(), "err", err)
 		return
 	}
-	
-	tui.stdout, err = tui.cppProcess.StdoutPipe()
+	tui.backend = b
+	tui.log.Debug("backend started", "go", remote.UseGoEngine(), "args", tui.backendArgs)
+	tui.spawn(func() {
+		err := b.Ready()
+		tui.app.QueueUpdateDraw(func() {
+			tui.processReady(err)
+		})
+	})
+}
+
+// processReady notes that the engine came up, or shows why it did not,
+// such as invalid walk options.
+func (tui *MerkleTUI) processReady(err error) {
 	if err != nil {
-		tui.writeOutput(fmt.Sprintf("[red]Error creating stdout pipe: %v[white]", err))
+		for i, line := range strings.Split(err.Error(), "\n") {
+			if i == 0 {
+				line = "[red]✗ " + tview.Escape(line) + "[white]"
+			} else {
+				line = tview.Escape(line)
+			}
+			tui.writeOutput(line)
+		}
+		tui.updateStatus("Backend failed to start")
+		return
+	}
+	tui.log.Debug("engine ready")
+}
+
+// call runs op on the backend in the background, then hands its error to
+// done on the UI goroutine; op keeps its result where done finds it.
+// Nothing runs without a backend.
+func (tui *MerkleTUI) call(op func(b backend.Backend) error, done func(err error)) {
+	b := tui.backend
+	if b == nil {
 		return
 	}
-	
-	err = tui.cppProcess.Start()
+	action := tui.currentAction
+	tui.log.Debug("backend request", "action", action)
+	tui.spawn(func() {
+		err := op(b)
+		tui.log.Debug("backend response", "action", action, "err", err)
+		tui.app.QueueUpdateDraw(func() {
+			tui.showWorkers(nil)
+			done(err)
+		})
+	})
+}
+
+// progress shows the progress lines of an operation as they come.
+func (tui *MerkleTUI) progress(progress protocol.ProgressResult) {
+	tui.app.QueueUpdateDraw(func() {
+		tui.processProgress(progress)
+	})
+}
+
+// report has the backend report with get and shows the lines with show.
+func (tui *MerkleTUI) report(get func(b backend.Backend) (*protocol.Report, error), show func(string)) {
+	var report *protocol.Report
+	tui.call(func(b backend.Backend) (err error) {
+		report, err = get(b)
+		return err
+	}, func(err error) {
+		tui.processReport(report, err, show)
+	})
+}
+
+// requestExport has the backend export the tree for the current action,
+// which processExport hands it to.
+func (tui *MerkleTUI) requestExport() {
+	var res *protocol.ExportResult
+	tui.call(func(b backend.Backend) (err error) {
+		res, err = b.Export()
+		return err
+	}, func(err error) {
+		tui.processExport(res, err)
+	})
+}
+
+// showWarnings shows what the engine warned of while running a request.
+func (tui *MerkleTUI) showWarnings(warnings []string) {
+	for _, w := range warnings {
+		tui.writeOutput(fmt.Sprintf("[yellow]Warning: %s[white]", tview.Escape(w)))
+	}
+}
+
+func (tui *MerkleTUI) processBuildOutput(res *protocol.BuildResult, err error) {
 	if err != nil {
-		tui.writeOutput(fmt.Sprintf("[red]Error starting C++ process: %v[white]", err))
+		tui.writeOutput(fmt.Sprintf("[red]✗ Error: %s[white]", tview.Escape(err.Error())))
+		tui.record("build", "", err)
+		tui.updateStatus("Build failed")
+		tui.afterOperation(hooks.PostBuild, "", err)
 		return
 	}
-	
-	tui.scanner = bufio.NewScanner(tui.stdout)
-	
-	// Start reading output in a goroutine
-	go tui.readOutput()
+
+	tui.showWarnings(res.Warnings)
+	tui.writeOutput("[green]✓ Merkle tree built successfully![white]")
+	tui.writeOutput("[blue]Tree is now ready for operations.[white]")
+	tui.rootHash = res.RootHash
+	tui.writeOutput(fmt.Sprintf("[cyan]🔐 Root hash: %s[white]", res.RootHash))
+	tui.refreshSignatureBadge()
+	if os.Getenv(timestamp.TSAEnv) != "" {
+		tui.requestTimestamp()
+	}
+	if logURL := os.Getenv(translog.URLEnv); logURL != "" {
+		tui.publishToLog(logURL)
+	}
+	tui.treeFiles, tui.treeDirs, tui.treeBytes = res.Files, res.Directories, res.Bytes
+	tui.writeOutput(fmt.Sprintf("[blue]📦 %s[white]", tui.humanize(fmt.Sprintf("Tree size: %d files, %d directories, %d bytes", res.Files, res.Directories, res.Bytes))))
+	if res.Cached > 0 {
+		tui.writeOutput(fmt.Sprintf("[blue]♻ %s[white]", tui.humanize(fmt.Sprintf("Hash cache: %d unchanged files not hashed again", res.Cached))))
+	}
+	tui.unreadable = len(res.Unreadable)
+	tui.showSkipped(res)
+	tui.showScope(res)
+	tui.writeOutput(fmt.Sprintf("[cyan]⚡ Build throughput: %s[white]", res.Throughput))
+
+	tui.checkQuota()
+	var unreadable string
+	if tui.unreadable > 0 {
+		unreadable = strconv.Itoa(tui.unreadable)
+	}
+	var exceeded []string
+	for _, a := range tui.quotaAlerts {
+		exceeded = append(exceeded, a.Key)
+	}
+	tui.record("build", tui.rootHash, nil, "chunk_size", strconv.Itoa(tui.builtChunk), "options", strings.Join(tui.backendArgs, " "),
+		"unreadable", unreadable, "quota_exceeded", strings.Join(exceeded, ","))
+	previous, _ := tui.registered()
+	tui.recordTrend(previous.RootHash)
+	tui.register(tui.treePath, tui.rootHash, "")
+	tui.hashFormat = state.HashFormat(tui.treePath)
+	tui.refreshVerifiedBadge()
+	tui.updateStatus("Ready")
+	if keyID := os.Getenv(attest.KeyEnv); keyID != "" {
+		tui.attestRoot("build", keyID)
+	}
+	tui.afterOperation(hooks.PostBuild, previous.RootHash, nil)
 }
 
-func (tui *MerkleTUI) readOutput() {
-	for tui.scanner.Scan() {
-		line := tui.scanner.Text()
-		tui.outputBuffer = append(tui.outputBuffer, line)
-		
-		// Process output based on current action
-		tui.app.QueueUpdateDraw(func() {
-			tui.processOutput(line)
-		})
+// showSkipped shows what a build or dry run left out of the tree.
+func (tui *MerkleTUI) showSkipped(res *protocol.BuildResult) {
+	if len(res.Unreadable) > 0 {
+		tui.writeOutput(fmt.Sprintf("[red]⚠ Unreadable entries: %d (recorded as error leaves)[white]", len(res.Unreadable)))
+	}
+	for _, u := range res.Unreadable {
+		tui.writeOutput(fmt.Sprintf("[red]⚠ Unreadable: %s (%s)[white]", tview.Escape(u.Path), tview.Escape(u.Reason)))
+	}
+	for _, skip := range res.Skipped {
+		tui.writeOutput(fmt.Sprintf("[yellow]Skipped %d entries (%s).[white]", skip.Count, tview.Escape(skip.Reason)))
+	}
+	for _, cycle := range res.Cycles {
+		tui.writeOutput(fmt.Sprintf("[yellow]Symlink cycle: %s (not followed)[white]", tview.Escape(cycle)))
+	}
+	if res.SkippedList != "" {
+		tui.writeOutput(fmt.Sprintf("[yellow]Skipped entries are listed in %s.[white]", tview.Escape(res.SkippedList)))
 	}
 }
 
-func (tui *MerkleTUI) processOutput(line string) {
-	switch tui.currentAction {
-	case "build":
-		tui.processBuildOutput(line)
-	case "print_tree":
-		tui.processPrintTreeOutput(line)
-	case "print_files":
-		tui.processPrintFilesOutput(line)
-	case "stats":
-		tui.processStatsOutput(line)
-	case "verify":
-		tui.processVerifyOutput(line)
-	case "export":
-		tui.processExportOutput(line)
-	case "chunk":
-		tui.processChunkOutput(line)
-	default:
-		tui.writeOutput(line)
+// showScope shows the walk options and tree config a build or dry run
+// applied, and what else the engine reported.
+func (tui *MerkleTUI) showScope(res *protocol.BuildResult) {
+	for _, note := range res.Notes {
+		tui.writeOutput(fmt.Sprintf("[yellow]%s[white]", tview.Escape(note)))
+	}
+	if res.Scope != "" {
+		tui.writeOutput(fmt.Sprintf("[blue]Scope: %s[white]", tview.Escape(res.Scope)))
+	}
+	if res.ConfigFile != "" {
+		tui.writeOutput(fmt.Sprintf("[blue]Tree config: %s (chunk size %d bytes)[white]", tview.Escape(res.ConfigFile), res.ConfigChunkSize))
 	}
 }
 
-func (tui *MerkleTUI) processBuildOutput(line string) {
-	if strings.Contains(line, "Merkle tree built successfully") {
-		tui.writeOutput("[green]✓ Merkle tree built successfully![white]")
-		tui.writeOutput("[blue]Tree is now ready for operations.[white]")
-	} else if strings.Contains(line, "Error:") {
-		tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", line))
-	} else if strings.Contains(line, "Enter directory path:") {
-		// Skip this line as we handle it in UI
+// checkQuota compares the tree just built with its quotas and notes the
+// usage for growth checks.
+func (tui *MerkleTUI) checkQuota() {
+	if tui.treeFiles < 0 {
 		return
-	} else {
-		tui.writeOutput(fmt.Sprintf("[yellow]%s[white]", line))
+	}
+	alerts, err := quota.Check(tui.treePath, quota.Usage{Files: tui.treeFiles, Bytes: tui.treeBytes}, time.Now())
+	if err != nil {
+		tui.log.Warn("quotas not checked", "tree", tui.treePath, "err", err)
+	}
+	tui.quotaAlerts = alerts
+	tui.showQuotaAlerts()
+}
+
+// recordTrend adds the tree just built to its trend history. Whether
+// paths changed is only known when the root hash stayed previous.
+func (tui *MerkleTUI) recordTrend(previous string) {
+	if tui.treeFiles < 0 {
+		return
+	}
+	changed := int64(-1)
+	if previous == tui.rootHash {
+		changed = 0
+	}
+	sample := trends.Sample{Time: time.Now(), Operation: "build", Files: tui.treeFiles, Bytes: tui.treeBytes, Changed: changed}
+	if err := trends.Record(tui.treePath, sample); err != nil {
+		tui.log.Warn("trends not recorded", "tree", tui.treePath, "err", err)
+	}
+}
+
+// showQuotaAlerts warns of the quotas the tree exceeded when it was
+// built, so build and verification reports carry them.
+func (tui *MerkleTUI) showQuotaAlerts() {
+	for _, a := range tui.quotaAlerts {
+		tui.writeOutput(fmt.Sprintf("[red]⚠ Quota exceeded: %s[white]", tui.humanize(a.Message)))
 	}
 }
 
 func (tui *MerkleTUI) processPrintTreeOutput(line string) {
+	line = tui.humanize(line)
 	if strings.HasPrefix(line, "├─") || strings.HasPrefix(line, "└─") || strings.HasPrefix(line, "│") {
 		// Tree structure lines
 		tui.writeOutput(fmt.Sprintf("[cyan]%s[white]", line))
@@ -205,7 +516,15 @@ func (tui *MerkleTUI) processPrintTreeOutput(line string) {
 }
 
 func (tui *MerkleTUI) processPrintFilesOutput(line string) {
-	if strings.Contains(line, "File:") {
+	if !strings.HasPrefix(line, "    ") {
+		line = tui.humanize(line)
+	}
+	if strings.Contains(line, "Xattrs Hash:") || strings.Contains(line, "Metadata Hash:") {
+		tui.writeOutput(fmt.Sprintf("   [cyan]🏷  %s[white]", line))
+	} else if strings.HasPrefix(line, "    ") && strings.Contains(line, " = ") {
+		// Attribute and metadata values are arbitrary text
+		tui.writeOutput(fmt.Sprintf("   [cyan]%s[white]", tview.Escape(line)))
+	} else if strings.Contains(line, "File:") {
 		tui.writeOutput(fmt.Sprintf("[yellow]📁 %s[white]", line))
 	} else if strings.Contains(line, "Hash:") {
 		tui.writeOutput(fmt.Sprintf("   [green]🔐 %s[white]", line))
@@ -219,212 +538,1558 @@ func (tui *MerkleTUI) processPrintFilesOutput(line string) {
 }
 
 func (tui *MerkleTUI) processStatsOutput(line string) {
+	line = tui.humanize(line)
 	if strings.Contains(line, "Total files:") {
 		tui.writeOutput(fmt.Sprintf("[yellow]📄 %s[white]", line))
 	} else if strings.Contains(line, "Total directories:") {
 		tui.writeOutput(fmt.Sprintf("[blue]📁 %s[white]", line))
-	} else if strings.Contains(line, "Total size:") {
+	} else if strings.Contains(line, "Total size:") || strings.HasPrefix(line, "Stored size:") {
 		tui.writeOutput(fmt.Sprintf("[green]💾 %s[white]", line))
 	} else if strings.Contains(line, "Tree depth:") {
 		tui.writeOutput(fmt.Sprintf("[magenta]🌳 %s[white]", line))
 	} else if strings.Contains(line, "Root hash:") {
 		tui.writeOutput(fmt.Sprintf("[cyan]🔐 %s[white]", line))
+	} else if strings.HasPrefix(line, "Unreadable") {
+		tui.writeOutput(fmt.Sprintf("[red]⚠ %s[white]", tview.Escape(line)))
+	} else if strings.Contains(line, "throughput:") || strings.Contains(line, "time:") {
+		tui.writeOutput(fmt.Sprintf("[white]⚡ %s[white]", line))
+	} else if strings.Contains(line, "history:") || strings.Contains(line, "trend:") {
+		tui.writeOutput(fmt.Sprintf("[cyan]📈 %s[white]", line))
+	} else if strings.HasPrefix(line, "Deduplication:") || strings.HasPrefix(line, "Saved by") {
+		tui.writeOutput(fmt.Sprintf("[magenta]🧩 %s[white]", line))
+	} else if strings.HasPrefix(line, "  ") {
+		tui.writeOutput(fmt.Sprintf("   [cyan]%s[white]", tview.Escape(line)))
 	} else {
 		tui.writeOutput(line)
 	}
 }
 
-func (tui *MerkleTUI) processVerifyOutput(line string) {
-	if strings.Contains(line, "Tree integrity verified: OK") {
+// processReport shows the lines of a report with show, or why the engine
+// could not report.
+func (tui *MerkleTUI) processReport(report *protocol.Report, err error, show func(string)) {
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", tview.Escape(err.Error())))
+		tui.updateStatus("Ready")
+		return
+	}
+	tui.showWarnings(report.Warnings)
+	for _, line := range report.Lines {
+		show(line)
+	}
+	tui.updateStatus("Ready")
+}
+
+func (tui *MerkleTUI) processVerifyOutput(res *protocol.VerifyResult, err error) {
+	if err == nil {
+		tui.showWarnings(res.Warnings)
+	}
+	switch {
+	case err != nil:
+		tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", tview.Escape(err.Error())))
+		tui.verifyErr = err
+	case res.OK:
 		tui.writeOutput("[green]✓ Tree integrity verified: OK[white]")
 		tui.writeOutput("[green]All hashes are valid and consistent.[white]")
-	} else if strings.Contains(line, "Tree integrity check FAILED!") {
+		tui.verifyErr = nil
+	default:
 		tui.writeOutput("[red]✗ Tree integrity check FAILED![white]")
 		tui.writeOutput("[red]Some hashes are invalid or inconsistent.[white]")
-	} else {
-		tui.writeOutput(line)
+		tui.verifyErr = errors.New("tree integrity check failed")
 	}
-}
-
-func (tui *MerkleTUI) processExportOutput(line string) {
-	if strings.HasPrefix(line, "{") && strings.HasSuffix(line, "}") {
-		// JSON output - format it nicely
-		tui.writeOutput("[green]JSON Export:[white]")
-		tui.writeOutput("[cyan]" + line + "[white]")
-		tui.writeOutput("[green]Export completed successfully![white]")
-	} else {
-		tui.writeOutput(line)
+	if err == nil && res.Throughput != "" {
+		tui.writeOutput(fmt.Sprintf("[cyan]⚡ Verify throughput: %s[white]", res.Throughput))
 	}
-}
-
-func (tui *MerkleTUI) processChunkOutput(line string) {
-	if strings.Contains(line, "Chunk size set to") {
-		tui.writeOutput(fmt.Sprintf("[green]✓ %s[white]", line))
-	} else if strings.Contains(line, "Error:") {
-		tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", line))
-	} else {
-		tui.writeOutput(line)
+	tui.showQuotaAlerts()
+	tui.record("verify", tui.rootHash, tui.verifyErr)
+	tui.verified = oplog.OK
+	if tui.verifyErr != nil {
+		tui.verified = oplog.Failed
+	}
+	tui.updateStatus("Ready")
+	if keyID := os.Getenv(attest.KeyEnv); keyID != "" && tui.verifyErr == nil {
+		tui.attestRoot("verify", keyID)
 	}
+	tui.afterOperation(hooks.PostVerify, "", tui.verifyErr)
 }
 
-func (tui *MerkleTUI) writeOutput(text string) {
-	fmt.Fprintf(tui.output, "%s\n", text)
-	tui.output.ScrollToEnd()
-}
+// processExport hands the JSON export of the tree to the action that
+// asked for it.
+func (tui *MerkleTUI) processExport(res *protocol.ExportResult, err error) {
+	if err != nil {
+		tui.currentAction = ""
+		tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", tview.Escape(err.Error())))
+		tui.updateStatus("Export failed")
+		return
+	}
+	tui.showWarnings(res.Warnings)
+	data := []byte(res.Document + "\n")
 
-func (tui *MerkleTUI) sendCommand(cmd string) {
-	if tui.stdin != nil {
-		fmt.Fprintf(tui.stdin, "%s\n", cmd)
+	switch tui.currentAction {
+	case "export":
+		tui.processExportOutput(data)
+	case "sign_export":
+		tui.processSignOutput(data)
+	case "store_export":
+		tui.processStoreOutput(data)
+	case "bundle_export":
+		tui.processBundleOutput(data)
+	case "tuf_export":
+		tui.processTUFOutput(data)
+	case "verity_enable", "verity_check":
+		tui.processVerityOutput(data)
+	case "ipfs_export":
+		tui.processIPFSOutput(data)
+	case "torrent_export":
+		tui.processTorrentOutput(data)
+	case "audit_export":
+		tui.processAuditOutput(data)
+	case "import_export":
+		tui.processImportOutput(data)
+	case "spdx_export":
+		tui.processSPDXOutput(data)
+	case "duplicates_export":
+		tui.processDuplicatesOutput(data)
+	case "similar_export":
+		tui.processSimilarOutput(data)
+	case "chunkmap_export":
+		tui.processChunkMapOutput(data)
+	case "proof_export":
+		tui.processProofOutput(data)
+	case "snapshot_export":
+		tui.processSnapshotOutput(data)
+	case "snapshot_diff_export":
+		tui.processSnapshotDiffOutput(data)
+	default:
+		tui.log.Debug("export not asked for", "action", tui.currentAction)
 	}
 }
 
-func (tui *MerkleTUI) updateStatus(message string) {
-	treeStatus := "[red]Not Built[white]"
-	if tui.treeBuilt {
-		treeStatus = "[green]Built[white]"
+func (tui *MerkleTUI) processExportOutput(data []byte) {
+	if out := tui.exportPath; out != "" {
+		format := manifest.FormatOf(out)
+		root, err := manifest.Parse(data)
+		if err == nil && format != manifest.FormatJSON {
+			data, err = root.Encode(format)
+		}
+		if err == nil {
+			err = os.WriteFile(out, data, 0o644)
+		}
+		tui.record("export", tui.rootHash, err, "file", out, "format", format)
+		if err != nil {
+			tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+			tui.updateStatus("Export failed")
+			return
+		}
+		tui.writeOutput(fmt.Sprintf("[green]✓ Exported %s as %s to %s (%s)[white]", tui.rootHash, format, out, tui.size(int64(len(data)))))
+		tui.updateStatus("Ready")
+		return
+	}
+	tui.writeOutput("[green]JSON Export:[white]")
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		tui.writeOutput("[cyan]" + tview.Escape(line) + "[white]")
 	}
-	tui.status.SetText(fmt.Sprintf("[green]%s[white] | Tree: %s | Press Tab to navigate", message, treeStatus))
+	tui.writeOutput("[green]Export completed successfully![white]")
+	tui.record("export", tui.rootHash, nil)
+	tui.updateStatus("Ready")
 }
 
-func (tui *MerkleTUI) buildTree() {
-	tui.currentAction = "build"
-	tui.updateStatus("Building tree...")
-	tui.writeOutput("[yellow]═══ Building Merkle Tree ═══[white]")
-	tui.writeOutput("[blue]Please enter the directory path to build the tree.[white]")
-	tui.sendCommand("1")
-	tui.input.SetLabel("Directory path: ")
-	tui.app.SetFocus(tui.input)
-}
+func (tui *MerkleTUI) processSignOutput(data []byte) {
 
-func (tui *MerkleTUI) printTree() {
-	if !tui.treeBuilt {
-		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+	root, err := manifest.Parse(data)
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		tui.currentAction = ""
 		return
 	}
-	tui.currentAction = "print_tree"
-	tui.updateStatus("Printing tree structure...")
-	tui.writeOutput("[yellow]═══ Tree Structure ═══[white]")
-	tui.sendCommand("2")
-}
 
-func (tui *MerkleTUI) printFiles() {
-	if !tui.treeBuilt {
-		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+	// gpg-agent may need to ask for a passphrase on this terminal
+	signer := &signing.GPG{KeyID: tui.signKeyID}
+	var exportSig, rootSig []byte
+	tui.app.Suspend(func() {
+		fmt.Println("Signing MTFS export and root hash with GPG...")
+		exportSig, err = signer.Sign(data)
+		if err == nil {
+			rootSig, err = signer.Sign(signing.RootStatement(root.Hash))
+		}
+	})
+	tui.currentAction = ""
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		tui.updateStatus("Signing failed")
+		tui.record("sign", root.Hash, err, "key", tui.signKeyID)
 		return
 	}
-	tui.currentAction = "print_files"
-	tui.updateStatus("Printing file objects...")
-	tui.writeOutput("[yellow]═══ File Objects ═══[white]")
-	tui.sendCommand("3")
+
+	files := map[string][]byte{
+		signing.ExportFile:    data,
+		signing.ExportSigFile: exportSig,
+		signing.RootFile:      signing.RootStatement(root.Hash),
+		signing.RootSigFile:   rootSig,
+	}
+	for _, name := range []string{signing.ExportFile, signing.ExportSigFile, signing.RootFile, signing.RootSigFile} {
+		if err := state.WriteFile(tui.treePath, name, files[name]); err != nil {
+			tui.writeOutput(fmt.Sprintf("[red]✗ Error saving %s: %v[white]", name, err))
+			tui.updateStatus("Signing failed")
+			tui.record("sign", root.Hash, err, "key", tui.signKeyID)
+			return
+		}
+	}
+
+	tui.writeOutput(fmt.Sprintf("[green]✓ Signed root hash %s[white]", root.Hash))
+	tui.record("sign", root.Hash, nil, "key", tui.signKeyID)
+	tui.writeOutput(fmt.Sprintf("[blue]Signatures saved in %s[white]", state.Dir(tui.treePath)))
+	tui.updateStatus("Ready")
+	tui.refreshSignatureBadge()
 }
 
-func (tui *MerkleTUI) showStats() {
-	if !tui.treeBuilt {
-		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+func (tui *MerkleTUI) processStoreOutput(data []byte) {
+	tui.currentAction = ""
+
+	root, err := manifest.Parse(data)
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
 		return
 	}
-	tui.currentAction = "stats"
-	tui.updateStatus("Showing statistics...")
-	tui.writeOutput("[yellow]═══ Tree Statistics ═══[white]")
-	tui.sendCommand("4")
+
+	dir, key, tree, params := tui.storeDir, tui.storeKey, tui.treePath, tui.builtChunking
+	tui.storeKey = store.Key{}
+	tui.beginReport("store")
+	tui.writeOutput(fmt.Sprintf("[blue]📦 Storing chunks of %s in %s[white]", tree, dir))
+	tui.spawn(func() {
+		s, err := store.Open(dir, key)
+		var stats store.IngestStats
+		if err == nil {
+			stats, err = s.Ingest(tree, root, params, func(st store.IngestStats) {
+				tui.app.QueueUpdateDraw(func() {
+					tui.updateStatus(fmt.Sprintf("Stored %s files, %s", groupDigits(int64(st.Files)), tui.size(st.Bytes)))
+				})
+			})
+		}
+		tui.app.QueueUpdateDraw(func() {
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+				tui.record("store", root.Hash, err, "store", dir, "chunking", params.String())
+				tui.updateStatus("Storing chunks failed")
+				return
+			}
+			tui.writeOutput(fmt.Sprintf("[green]✓ Stored %s files (%s chunks, %s new, %s written)[white]",
+				groupDigits(int64(stats.Files)), groupDigits(int64(stats.Chunks)), groupDigits(int64(stats.NewChunks)), tui.size(stats.StoredBytes)))
+			if reused := stats.Bytes - stats.StoredBytes; reused > 0 {
+				tui.writeOutput(fmt.Sprintf("[blue]%s of the tree was already in the store and not written again[white]", tui.size(reused)))
+			}
+			if stats.WrittenBytes > 0 && stats.WrittenBytes < stats.StoredBytes {
+				tui.writeOutput(fmt.Sprintf("[blue]New chunks compressed to %s (ratio %.2fx)[white]", tui.size(stats.WrittenBytes), stats.CompressionRatio()))
+			}
+			tui.writeOutput(fmt.Sprintf("[blue]Snapshot saved for root hash %s[white]", root.Hash))
+			tui.record("store", root.Hash, nil, "store", dir, "chunking", params.String())
+			tui.register(tree, root.Hash, dir)
+			tui.updateStatus("Ready")
+		})
+	})
 }
 
-func (tui *MerkleTUI) verifyTree() {
-	if !tui.treeBuilt {
-		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+func (tui *MerkleTUI) processBundleOutput(data []byte) {
+	tui.currentAction = ""
+	password := tui.bundlePass
+	tui.bundlePass = ""
+
+	root, err := manifest.Parse(data)
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
 		return
 	}
-	tui.currentAction = "verify"
-	tui.updateStatus("Verifying tree integrity...")
-	tui.writeOutput("[yellow]═══ Tree Verification ═══[white]")
-	tui.sendCommand("5")
-}
+	b := &bundle.Bundle{Files: map[string][]byte{
+		signing.ExportFile: data,
+		signing.RootFile:   signing.RootStatement(root.Hash),
+	}}
+	tui.addBundleAttestations(b, root.Hash, data)
 
-func (tui *MerkleTUI) exportJSON() {
-	if !tui.treeBuilt {
-		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+	sealed, err := bundle.Seal(b, password)
+	if err == nil {
+		err = os.WriteFile(tui.bundlePath, sealed, 0o600)
+	}
+	tui.record("bundle", root.Hash, err, "file", tui.bundlePath, "contents", strings.Join(b.Names(), ","))
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		tui.updateStatus("Bundle export failed")
 		return
 	}
-	tui.currentAction = "export"
-	tui.updateStatus("Exporting to JSON...")
-	tui.writeOutput("[yellow]═══ JSON Export ═══[white]")
-	tui.sendCommand("6")
+	tui.writeOutput(fmt.Sprintf("[green]✓ Encrypted bundle written to %s[white]", tui.bundlePath))
+	tui.writeOutput(fmt.Sprintf("[blue]Contains: %s[white]", strings.Join(b.Names(), ", ")))
+	tui.updateStatus("Ready")
 }
 
-func (tui *MerkleTUI) setChunkSize() {
-	tui.currentAction = "chunk"
-	tui.updateStatus("Setting chunk size...")
-	tui.writeOutput("[yellow]═══ Chunk Size Configuration ═══[white]")
-	tui.sendCommand("7")
-	tui.input.SetLabel("Chunk size (bytes): ")
-	tui.app.SetFocus(tui.input)
-}
+func (tui *MerkleTUI) processTUFOutput(data []byte) {
+	tui.currentAction = ""
 
-func (tui *MerkleTUI) exit() {
-	tui.updateStatus("Exiting...")
-	tui.writeOutput("[yellow]═══ Exiting Application ═══[white]")
-	tui.sendCommand("8")
-	time.Sleep(100 * time.Millisecond) // Give time for cleanup
-	tui.app.Stop()
-}
+	root, err := manifest.Parse(data)
+	var res *tuf.Result
+	if err == nil {
+		res, err = tuf.Generate(tui.treePath, root, time.Now())
+		tui.record("tuf", root.Hash, err)
+	}
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		tui.updateStatus("TUF generation failed")
+		return
+	}
 
-func (tui *MerkleTUI) handleInput() {
-	inputText := tui.input.GetText()
-	tui.input.SetText("")
-	
-	switch tui.currentAction {
-	case "build":
-		tui.sendCommand(inputText)
-		tui.writeOutput(fmt.Sprintf("[blue]🔨 Building tree from: %s[white]", inputText))
-		tui.treeBuilt = true
-		tui.currentAction = ""
-		tui.input.SetLabel("Input: ")
-		tui.app.SetFocus(tui.menu)
-		
-	case "chunk":
-		// Validate chunk size
-		if _, err := strconv.Atoi(inputText); err != nil {
-			tui.writeOutput("[red]✗ Invalid chunk size. Please enter a number.[white]")
-			return
-		}
-		tui.sendCommand(inputText)
-		tui.writeOutput(fmt.Sprintf("[blue]🔧 Setting chunk size to: %s bytes[white]", inputText))
-		tui.currentAction = ""
-		tui.input.SetLabel("Input: ")
-		tui.app.SetFocus(tui.menu)
-		
-	default:
-		// Handle general input
-		tui.sendCommand(inputText)
-		tui.app.SetFocus(tui.menu)
+	tui.writeOutput(fmt.Sprintf("[green]✓ Metadata for %s targets written to %s[white]", groupDigits(int64(res.Targets)), res.Dir))
+	for _, role := range tuf.Roles {
+		tui.writeOutput(fmt.Sprintf("[blue]  %-9s version %d[white]", role, res.Versions[role]))
+	}
+	if res.RootChanged {
+		tui.writeOutput(fmt.Sprintf("[yellow]root.json was re-signed; publish %d.root.json with the other files.[white]", res.Versions["root"]))
 	}
-	
 	tui.updateStatus("Ready")
 }
 
-func (tui *MerkleTUI) Run() error {
-	return tui.app.SetRoot(tui.pages, true).Run()
-}
+func (tui *MerkleTUI) processVerityOutput(data []byte) {
+	enable := tui.currentAction == "verity_enable"
+	tui.currentAction = ""
 
-func (tui *MerkleTUI) cleanup() {
-	if tui.stdin != nil {
-		tui.stdin.Close()
+	root, err := manifest.Parse(data)
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		return
 	}
-	if tui.stdout != nil {
-		tui.stdout.Close()
+	tree := tui.treePath
+	tui.spawn(func() {
+		var res *verity.Result
+		if enable {
+			res, err = verity.Protect(tree, root, func(done int) {
+				tui.app.QueueUpdateDraw(func() {
+					tui.updateStatus(fmt.Sprintf("Enabling fs-verity: %s files", groupDigits(int64(done))))
+				})
+			})
+		} else {
+			res, err = verity.Check(tree, root)
+		}
+		tui.app.QueueUpdateDraw(func() {
+			op := "verity-check"
+			if enable {
+				op = "verity-enable"
+			}
+			if err == nil && len(res.Problems) > 0 {
+				tui.record(op, root.Hash, fmt.Errorf("%d of %d files have problems", len(res.Problems), res.Files))
+			} else {
+				tui.record(op, root.Hash, err)
+			}
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+				tui.updateStatus("fs-verity failed")
+				return
+			}
+			for _, p := range res.Problems {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %s: %s[white]", p.Path, p.Message))
+			}
+			verb := "match the tree"
+			if enable {
+				verb = "protected"
+			}
+			color := "green"
+			if len(res.Problems) > 0 {
+				color = "yellow"
+			}
+			tui.writeOutput(fmt.Sprintf("[%s]%s of %s files %s[white]", color, groupDigits(int64(res.OK)), groupDigits(int64(res.Files)), verb))
+			tui.updateStatus("Ready")
+		})
+	})
+}
+
+// processIPFSOutput computes the CIDs of the exported tree, then lists
+// them or looks up the CID that was asked for.
+func (tui *MerkleTUI) processIPFSOutput(data []byte) {
+	tui.currentAction = ""
+
+	root, err := manifest.Parse(data)
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		return
 	}
-	if tui.cppProcess != nil {
-		tui.cppProcess.Process.Kill()
-		tui.cppProcess.Wait()
+	query := tui.cidQuery
+	version, list := ipfs.ParseVersion(query)
+	if !list {
+		version, _ = ipfs.VersionOf(query)
 	}
+	tree := tui.treePath
+	tui.spawn(func() {
+		entries, err := ipfs.Compute(tree, root, version)
+		tui.app.QueueUpdateDraw(func() {
+			tui.record("ipfs", root.Hash, err, "query", query)
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+				tui.updateStatus("IPFS CIDs failed")
+				return
+			}
+			if list {
+				for _, e := range entries {
+					tui.writeOutput(fmt.Sprintf("[cyan]%s[white]  %s", e.CID, e.Path))
+				}
+				tui.writeOutput(fmt.Sprintf("[green]%s CIDs (%s)[white]", groupDigits(int64(len(entries))), version))
+			} else {
+				found := ipfs.Find(entries, query)
+				for _, e := range found {
+					tui.writeOutput(fmt.Sprintf("[green]✓ %s is %s (%s)[white]", query, e.Path, e.Type))
+				}
+				if len(found) == 0 {
+					tui.writeOutput(fmt.Sprintf("[yellow]No node of the tree has CID %s[white]", query))
+				}
+			}
+			tui.updateStatus("Ready")
+		})
+	})
+}
+
+// processTorrentOutput hashes the files of the exported tree into a
+// BitTorrent v2 torrent and writes it to the chosen file.
+func (tui *MerkleTUI) processTorrentOutput(data []byte) {
+	tui.currentAction = ""
+
+	root, err := manifest.Parse(data)
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		return
+	}
+	tree, out := tui.treePath, tui.torrentPath
+	tui.spawn(func() {
+		t, err := torrent.FromTree(tree, root)
+		if err == nil {
+			err = os.WriteFile(out, t.Encode(), 0o644)
+		}
+		tui.app.QueueUpdateDraw(func() {
+			tui.record("torrent", root.Hash, err, "file", out)
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+				tui.updateStatus("Torrent export failed")
+				return
+			}
+			for _, line := range t.Summary() {
+				tui.writeOutput("[cyan]" + line + "[white]")
+			}
+			tui.writeOutput(fmt.Sprintf("[green]✓ Torrent of %s files written to %s (pieces of %s)[white]", groupDigits(int64(len(t.Files))), out, tui.size(t.PieceLength)))
+			tui.writeOutput(fmt.Sprintf("[blue]Info-hash (v2): %s[white]", t.InfoHash()))
+			tui.writeOutput(fmt.Sprintf("[blue]Magnet: %s[white]", t.Magnet()))
+			tui.updateStatus("Ready")
+		})
+	})
+}
+
+// processSPDXOutput lists the files of the exported tree in an SPDX
+// document and writes it to the chosen file.
+func (tui *MerkleTUI) processSPDXOutput(data []byte) {
+	tui.currentAction = ""
+
+	root, err := manifest.Parse(data)
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		return
+	}
+	tree, out := tui.treePath, tui.spdxPath
+	tui.spawn(func() {
+		doc, err := spdx.FromTree(tree, root, time.Now())
+		var encoded []byte
+		if err == nil {
+			encoded, err = doc.Encode()
+		}
+		if err == nil {
+			err = os.WriteFile(out, encoded, 0o644)
+		}
+		tui.app.QueueUpdateDraw(func() {
+			tui.record("spdx", root.Hash, err, "file", out)
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+				tui.updateStatus("SPDX export failed")
+				return
+			}
+			var total int64
+			for _, f := range doc.Files {
+				total += f.Size
+			}
+			tui.writeOutput(fmt.Sprintf("[green]✓ %s entries for %s files (%s) written to %s[white]", spdx.Version, groupDigits(int64(len(doc.Files))), tui.size(total), out))
+			tui.writeOutput(fmt.Sprintf("[blue]Package verification code: %s[white]", doc.Packages[0].VerificationCode.Value))
+			tui.updateStatus("Ready")
+		})
+	})
+}
+
+// processAuditOutput compares the files of the exported tree with the
+// loaded hashdeep baseline or checksum file.
+func (tui *MerkleTUI) processAuditOutput(data []byte) {
+	tui.currentAction = ""
+
+	root, err := manifest.Parse(data)
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		return
+	}
+	tree, baseline := tui.treePath, tui.baseline
+	tui.spawn(func() {
+		report, err := audit.Run(tree, root, baseline, "")
+		tui.app.QueueUpdateDraw(func() {
+			if err == nil && !report.Passed() {
+				tui.record("audit", root.Hash, errors.New("files differ from the baseline"))
+			} else {
+				tui.record("audit", root.Hash, err)
+			}
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+				tui.updateStatus("Audit failed")
+				return
+			}
+			for _, res := range report.Results {
+				switch res.Status {
+				case audit.Moved:
+					tui.writeOutput(fmt.Sprintf("[yellow]%s: moved from %s[white]", res.Path, res.Known))
+				case audit.New, audit.Changed, audit.Missing:
+					tui.writeOutput(fmt.Sprintf("[red]%s: %s[white]", res.Path, res.Status))
+				}
+			}
+			color := "[red]"
+			if report.Passed() {
+				color = "[green]"
+			}
+			for _, line := range report.Summary() {
+				tui.writeOutput(color + line + "[white]")
+			}
+			tui.updateStatus("Ready")
+		})
+	})
+}
+
+// addBundleAttestations adds the stored signatures, timestamp and log
+// receipt of the tree when they belong to this export.
+func (tui *MerkleTUI) addBundleAttestations(b *bundle.Bundle, hash string, export []byte) {
+	if stored, err := state.ReadFile(tui.treePath, signing.RootFile); err == nil && bytes.Equal(stored, b.Files[signing.RootFile]) {
+		if sig, err := state.ReadFile(tui.treePath, signing.RootSigFile); err == nil {
+			b.Files[signing.RootSigFile] = sig
+		}
+	}
+	if stored, err := state.ReadFile(tui.treePath, signing.ExportFile); err == nil && bytes.Equal(stored, export) {
+		if sig, err := state.ReadFile(tui.treePath, signing.ExportSigFile); err == nil {
+			b.Files[signing.ExportSigFile] = sig
+		}
+	}
+	for _, name := range []string{timestamp.File(hash), translog.File(hash)} {
+		if data, err := state.ReadFile(tui.treePath, name); err == nil {
+			b.Files[filepath.ToSlash(name)] = data
+		}
+	}
+}
+
+// refreshSignatureBadge checks the stored root signature of the current
+// tree in the background and shows the result in the status bar.
+func (tui *MerkleTUI) refreshSignatureBadge() {
+	tree, current := tui.treePath, tui.rootHash
+	tui.spawn(func() {
+		badge, signed := "[red]✗ check failed[white]", false
+		status, err := signing.NewGPG("").CheckRoot(tree)
+		if err == nil {
+			badge = signatureBadge(status, current)
+			signed = status.Verification != nil && status.Verification.Status == signing.StatusGood && status.Valid(current)
+		}
+		tui.app.QueueUpdateDraw(func() {
+			tui.sigBadge, tui.signed = badge, signed
+			tui.updateStatus(tui.statusMessage)
+		})
+	})
+}
+
+func signatureBadge(status *signing.RootStatus, current string) string {
+	v := status.Verification
+	switch {
+	case status.SignedHash == "" || v == nil:
+		return "[gray]unsigned[white]"
+	case v.Status != signing.StatusGood:
+		return fmt.Sprintf("[red]✗ %s[white]", v.Status)
+	case !status.Valid(current):
+		return "[yellow]⚠ signed root is outdated[white]"
+	default:
+		return fmt.Sprintf("[green]✓ signed by %s[white]", v.Signer)
+	}
+}
+
+// processPreviewOutput shows the report of a dry run.
+func (tui *MerkleTUI) processPreviewOutput(res *protocol.BuildResult, err error) {
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ Error: %s[white]", tview.Escape(err.Error())))
+		tui.currentAction = ""
+		tui.updateStatus("Preview failed")
+		return
+	}
+	tui.showWarnings(res.Warnings)
+	tui.writeOutput("Dry run: nothing was hashed or recorded.")
+	tui.writeOutput(fmt.Sprintf("[green]📦 %s[white]", tui.humanize(fmt.Sprintf("Would hash: %d files, %d directories, %d bytes", res.Files, res.Directories, res.Bytes))))
+	tui.showSkipped(res)
+	for _, skip := range res.WouldSkip {
+		tui.writeOutput(fmt.Sprintf("[yellow]Would skip: %s (%s)[white]", tview.Escape(skip.Path), tview.Escape(skip.Reason)))
+	}
+	tui.showScope(res)
+	tui.updateStatus("Ready")
+}
+
+func (tui *MerkleTUI) processChunkOutput(res *protocol.ChunkSizeResult, err error) {
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ Error: %s[white]", tview.Escape(err.Error())))
+		return
+	}
+	tui.showWarnings(res.Warnings)
+	tui.chunkSize = int(res.ChunkSize)
+	tui.chunking = chunking.Fixed(tui.chunkSize)
+	tui.record("set-chunk-size", "", nil, "chunk_size", strconv.Itoa(tui.chunkSize))
+	tui.writeOutput(fmt.Sprintf("[green]✓ Chunk size set to %d bytes.[white]", res.ChunkSize))
+}
+
+// setChunking takes content-defined chunking for the store and the
+// duplicate finders. The backend keeps cutting the chunks it lists every
+// chunk size bytes.
+func (tui *MerkleTUI) setChunking(p chunking.Params) {
+	tui.chunking = p
+	tui.record("set-chunk-size", "", nil, "chunking", p.String())
+	tui.writeOutput(fmt.Sprintf("[green]✓ Content-defined chunking (FastCDC) set: %s[white]", tui.describeChunking(p)))
+	tui.writeOutput(fmt.Sprintf("[blue]Stored snapshots, similar files and chunk maps use it from the next build; the tree still lists chunks of %d bytes.[white]", tui.chunkSize))
+}
+
+// describeChunking returns how p cuts files, in words.
+func (tui *MerkleTUI) describeChunking(p chunking.Params) string {
+	if !p.CDC {
+		return "chunks of " + tui.size(int64(p.Size))
+	}
+	return fmt.Sprintf("content-defined chunks of %s to %s, %s on average", tui.size(int64(p.Min)), tui.size(int64(p.Max)), tui.size(int64(p.Avg)))
+}
+
+// record adds an operation on the current tree to the operation log.
+// params are key and value pairs.
+func (tui *MerkleTUI) record(op, root string, err error, params ...string) {
+	tui.recordOn(tui.treePath, op, root, err, params...)
+}
+
+// recordOn adds an operation on tree to the operation log.
+func (tui *MerkleTUI) recordOn(tree, op, root string, err error, params ...string) {
+	e := oplog.Entry{Operation: op, Tree: tree, RootHash: root, Result: oplog.OK}
+	for i := 0; i+1 < len(params); i += 2 {
+		if params[i+1] != "" {
+			if e.Params == nil {
+				e.Params = map[string]string{}
+			}
+			e.Params[params[i]] = params[i+1]
+		}
+	}
+	if err != nil {
+		e.Result, e.Error = oplog.Failed, err.Error()
+	}
+	tui.finishReport(tree, op, &e)
+	if e, err := oplog.Record(e); err != nil {
+		tui.log.Warn("operation not recorded in the operation log", "operation", op, "err", err)
+	} else if e.Hash != "" {
+		tui.log.Debug("operation recorded", "operation", op, "seq", e.Seq, "hash", e.Hash)
+	}
+}
+
+// processProgress shows a progress line of the engine in the status bar
+// instead of the output pane, and the files its workers are hashing above
+// it.
+func (tui *MerkleTUI) processProgress(progress protocol.ProgressResult) {
+	line := progress.Line
+	if bar := progressBar(line, 20); bar != "" {
+		line = tview.Escape(bar) + " " + line
+	}
+	tui.showWorkers(progress.Workers)
+	tui.updateStatus(line)
+}
+
+// writeOutput logs text, which the output pane then shows as is. Lines
+// reporting a failure are logged as errors, warnings as warnings.
+func (tui *MerkleTUI) writeOutput(text string) {
+	plain := strings.TrimSpace(colorTag.ReplaceAllString(text, ""))
+	level := slog.LevelInfo
+	switch {
+	case strings.HasPrefix(plain, "✗"), strings.HasPrefix(plain, "Error"):
+		level = slog.LevelError
+	case strings.HasPrefix(plain, "Warning"):
+		level = slog.LevelWarn
+	}
+	tui.log.Log(context.Background(), level, plain, logging.DisplayKey, text)
+	if tui.reportOp != "" {
+		tui.reportLines = append(tui.reportLines, plain)
+	}
+}
+
+// show writes an event to the output pane: its display form if it has
+// one, otherwise its message and attributes colored by level.
+func (tui *MerkleTUI) show(r slog.Record) {
+	var text string
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == logging.DisplayKey {
+			text = a.Value.String()
+			return false
+		}
+		return true
+	})
+	if text == "" {
+		text = tview.Escape(strings.TrimSpace(r.Message + " " + logging.Text(r)))
+		switch {
+		case r.Level >= slog.LevelError:
+			text = "[red]✗ " + text + "[white]"
+		case r.Level >= slog.LevelWarn:
+			text = "[yellow]" + text + "[white]"
+		}
+	}
+	fmt.Fprintln(tui.output, tui.colored(text))
+	tui.output.ScrollToEnd()
+}
+
+// colored returns text with its color tags, or without them if colors
+// are off.
+func (tui *MerkleTUI) colored(text string) string {
+	if tui.plain {
+		return colorTag.ReplaceAllString(text, "")
+	}
+	return text
+}
+
+func (tui *MerkleTUI) updateStatus(message string) {
+	tui.statusMessage = message
+	treeStatus := "[red]Not Built[white]"
+	if tui.treeBuilt {
+		treeStatus = "[green]Built[white]"
+		if tui.treeFiles >= 0 {
+			treeStatus += fmt.Sprintf(" (%s files, %s)", groupDigits(tui.treeFiles), tui.size(tui.treeBytes))
+		}
+		if tui.sigBadge != "" {
+			treeStatus += " | Signature: " + tui.sigBadge
+		}
+		if len(tui.quotaAlerts) > 0 {
+			treeStatus += fmt.Sprintf(" | [red]⚠ %d over quota[white]", len(tui.quotaAlerts))
+		}
+	}
+	if tui.watcher != nil {
+		treeStatus += fmt.Sprintf(" | [cyan]Watching %s: %s[white]", tview.Escape(filepath.Base(tui.watcher.Dir)), tui.watcher.Root())
+	}
+	tui.status.SetText(tui.colored(fmt.Sprintf("[green]%s[white] | Tree: %s | Press Tab to navigate", message, treeStatus)))
+	if tui.onStatus != nil {
+		tui.onStatus()
+	}
+}
+
+func (tui *MerkleTUI) buildTree() {
+	tui.currentAction = "build"
+	tui.updateStatus("Building tree...")
+	tui.writeOutput("[yellow]═══ Building Merkle Tree ═══[white]")
+	tui.writeOutput("[blue]Please enter the directory path to build the tree.[white]")
+	tui.input.SetLabel("Directory path: ")
+	tui.app.SetFocus(tui.input)
+}
+
+func (tui *MerkleTUI) previewBuild() {
+	tui.currentAction = "preview"
+	tui.updateStatus("Previewing build...")
+	tui.writeOutput("[yellow]═══ Build Preview (dry run) ═══[white]")
+	tui.writeOutput("[blue]Enter the directory to walk with the walk options and ignore rules; nothing is hashed or recorded.[white]")
+	tui.input.SetLabel("Directory path: ")
+	tui.app.SetFocus(tui.input)
+}
+
+func (tui *MerkleTUI) printFiles() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "print_files"
+	tui.updateStatus("Printing file objects...")
+	tui.writeOutput("[yellow]═══ File Objects ═══[white]")
+	tui.report(backend.Backend.PrintFiles, tui.processPrintFilesOutput)
+}
+
+func (tui *MerkleTUI) showStats() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "stats"
+	tui.updateStatus("Showing statistics...")
+	tui.writeOutput("[yellow]═══ Tree Statistics ═══[white]")
+	var report *protocol.Report
+	tui.call(func(b backend.Backend) (err error) {
+		report, err = b.Stats()
+		return err
+	}, func(err error) {
+		tui.processReport(report, err, tui.processStatsOutput)
+		if err == nil {
+			tui.showDedup()
+		}
+	})
+}
+
+func (tui *MerkleTUI) verifyTree() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "verify"
+	tui.beginReport("verify")
+	tui.updateStatus("Verifying tree integrity...")
+	tui.writeOutput("[yellow]═══ Tree Verification ═══[white]")
+	var res *protocol.VerifyResult
+	tui.call(func(b backend.Backend) (err error) {
+		res, err = b.Verify(tui.progress)
+		return err
+	}, func(err error) {
+		tui.processVerifyOutput(res, err)
+	})
+}
+
+func (tui *MerkleTUI) exportJSON() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "export_path"
+	tui.updateStatus("Exporting tree...")
+	tui.writeOutput("[yellow]═══ Tree Export ═══[white]")
+	tui.writeOutput("[blue]Enter a file to write the export to, as JSON, or as CBOR or protobuf if it ends in .cbor or .pb; leave it empty to show the JSON here.[white]")
+	tui.input.SetText("")
+	tui.input.SetLabel("Export file: ")
+	tui.app.SetFocus(tui.input)
+}
+
+func (tui *MerkleTUI) setChunkSize() {
+	tui.currentAction = "chunk"
+	tui.updateStatus("Setting chunk size...")
+	tui.writeOutput("[yellow]═══ Chunk Size Configuration ═══[white]")
+	tui.writeOutput(fmt.Sprintf("[blue]Files are cut into %s. Enter a chunk size such as 1048576 or 64K, or fastcdc:MIN/AVG/MAX (e.g. fastcdc:16K/64K/256K) for content-defined chunks, which keep matching when bytes are inserted or removed.[white]", tui.describeChunking(tui.chunking)))
+	tui.input.SetLabel("Chunk size (bytes or fastcdc:MIN/AVG/MAX): ")
+	tui.app.SetFocus(tui.input)
+}
+
+func (tui *MerkleTUI) signExport() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "sign"
+	tui.updateStatus("Signing export...")
+	tui.writeOutput("[yellow]═══ GPG Signing ═══[white]")
+	tui.writeOutput("[blue]Enter the GPG key ID to sign with, or leave empty for the default key.[white]")
+	tui.input.SetLabel("GPG key ID: ")
+	tui.app.SetFocus(tui.input)
+}
+
+func (tui *MerkleTUI) timestampRoot() {
+	if tui.rootHash == "" {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.writeOutput("[yellow]═══ Trusted Timestamp ═══[white]")
+	tui.requestTimestamp()
+}
+
+// requestTimestamp asks the configured TSA to timestamp the current root
+// hash in the background and stores the token in .mtfs/timestamps.
+func (tui *MerkleTUI) requestTimestamp() {
+	tree, hash, url := tui.treePath, tui.rootHash, timestamp.URL()
+	tui.writeOutput(fmt.Sprintf("[blue]🕒 Requesting timestamp from %s[white]", url))
+	tui.updateStatus("Timestamping root hash...")
+	tui.spawn(func() {
+		token, err := timestamp.Request(url, signing.RootStatement(hash))
+		if err == nil {
+			err = state.WriteFile(tree, timestamp.File(hash), token.Raw)
+		}
+		tui.app.QueueUpdateDraw(func() {
+			tui.recordOn(tree, "timestamp", hash, err, "tsa", url)
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ Timestamp failed: %v[white]", err))
+				tui.updateStatus("Timestamp failed")
+				return
+			}
+			tui.writeOutput(fmt.Sprintf("[green]✓ Root hash %s timestamped at %s[white]", hash, token.Time.Format(time.RFC3339)))
+			if token.Signer != nil {
+				tui.writeOutput(fmt.Sprintf("[blue]Issued by %s[white]", token.Signer.Subject))
+			}
+			roots, err := timestamp.Roots(os.Getenv(timestamp.CAEnv))
+			if err == nil {
+				err = token.VerifyChain(roots)
+			}
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[yellow]⚠ verify-timestamp will not accept the token: %s[white]", tview.Escape(err.Error())))
+			}
+			tui.updateStatus("Ready")
+		})
+	})
+}
+
+// attestRoot writes the attestation badge of the current tree, signed
+// with keyID, after op established its root hash.
+func (tui *MerkleTUI) attestRoot(op, keyID string) {
+	var name string
+	var err error
+	// gpg-agent may need to ask for a passphrase on this terminal
+	tui.app.Suspend(func() {
+		fmt.Printf("Signing the attestation badge of %s with GPG...\n", tui.treePath)
+		name, err = attest.Write(tui.treePath, tui.rootHash, op, keyID)
+	})
+	tui.record("attest", tui.rootHash, err, "after", op, "key", keyID)
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ Attestation badge not written: %v[white]", err))
+		return
+	}
+	tui.writeOutput(fmt.Sprintf("[green]✓ Attestation badge written to %s[white]", tview.Escape(name)))
+}
+
+func (tui *MerkleTUI) publishRoot() {
+	if tui.rootHash == "" {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	logURL := os.Getenv(translog.URLEnv)
+	if logURL == "" {
+		tui.writeOutput(fmt.Sprintf("[red]✗ No transparency log configured. Set %s to the log URL.[white]", translog.URLEnv))
+		return
+	}
+	tui.writeOutput("[yellow]═══ Transparency Log ═══[white]")
+	tui.publishToLog(logURL)
+}
+
+// publishToLog appends the current root statement to the log at logURL in
+// the background, confirms its inclusion and keeps the receipt in
+// .mtfs/translog.
+func (tui *MerkleTUI) publishToLog(logURL string) {
+	tree, hash := tui.treePath, tui.rootHash
+	tui.writeOutput(fmt.Sprintf("[blue]📜 Publishing root hash to %s[white]", logURL))
+	tui.updateStatus("Publishing root hash...")
+	tui.spawn(func() {
+		key, err := translog.LoadKey(os.Getenv(translog.KeyEnv))
+		client := translog.NewClient(logURL, key)
+		statement := signing.RootStatement(hash)
+		var receipt *translog.Receipt
+		if err == nil {
+			receipt, err = client.Publish(statement)
+		}
+		if err == nil {
+			err = translog.SaveReceipt(tree, hash, receipt)
+		}
+		var head *translog.TreeHead
+		if err == nil {
+			// The receipt keeps the head, which later ones must extend
+			if head, err = client.CheckInclusion(receipt, statement); err == nil {
+				err = translog.SaveReceipt(tree, hash, receipt)
+			}
+		}
+		tui.app.QueueUpdateDraw(func() {
+			tui.recordOn(tree, "publish", hash, err, "log", logURL)
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ Publishing failed: %v[white]", err))
+				tui.updateStatus("Publishing failed")
+				return
+			}
+			tui.writeOutput(fmt.Sprintf("[green]✓ Root hash %s logged as entry %d[white]", hash, receipt.LeafIndex))
+			tui.writeOutput(fmt.Sprintf("[blue]Inclusion confirmed in a log of %s entries[white]", groupDigits(head.TreeSize)))
+			tui.updateStatus("Ready")
+		})
+	})
+}
+
+func (tui *MerkleTUI) exportBundle() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "bundle_path"
+	tui.updateStatus("Exporting bundle...")
+	tui.writeOutput("[yellow]═══ Password-Protected Bundle ═══[white]")
+	tui.writeOutput("[blue]The export and any matching signatures are encrypted into one file.[white]")
+	tui.input.SetText(filepath.Base(tui.treePath) + ".mtfsb")
+	tui.input.SetLabel("Bundle file: ")
+	tui.app.SetFocus(tui.input)
+}
+
+func (tui *MerkleTUI) generateTUF() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "tuf_export"
+	tui.updateStatus("Generating TUF metadata...")
+	tui.writeOutput("[yellow]═══ TUF Metadata ═══[white]")
+	tui.requestExport()
+}
+
+func (tui *MerkleTUI) enableVerity() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "verity_enable"
+	tui.updateStatus("Enabling fs-verity...")
+	tui.writeOutput("[yellow]═══ Enable fs-verity ═══[white]")
+	tui.writeOutput("[blue]Protected files become read-only until they are deleted.[white]")
+	tui.requestExport()
+}
+
+func (tui *MerkleTUI) checkVerity() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "verity_check"
+	tui.updateStatus("Checking fs-verity digests...")
+	tui.writeOutput("[yellow]═══ fs-verity Cross-Check ═══[white]")
+	tui.requestExport()
+}
+
+func (tui *MerkleTUI) computeCIDs() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "ipfs_query"
+	tui.updateStatus("Computing IPFS CIDs...")
+	tui.writeOutput("[yellow]═══ IPFS CIDs ═══[white]")
+	tui.writeOutput("[blue]Enter a CID to find it in the tree, or v0 (ipfs add) / v1 (ipfs add --cid-version 1) to list every CID.[white]")
+	tui.input.SetText("v0")
+	tui.input.SetLabel("CID or version: ")
+	tui.app.SetFocus(tui.input)
+}
+
+func (tui *MerkleTUI) exportTorrent() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "torrent_path"
+	tui.updateStatus("Exporting torrent...")
+	tui.writeOutput("[yellow]═══ BitTorrent v2 Torrent ═══[white]")
+	tui.writeOutput("[blue]Seed the torrent from the parent directory of the tree.[white]")
+	tui.input.SetText(filepath.Base(tui.treePath) + ".torrent")
+	tui.input.SetLabel("Torrent file: ")
+	tui.app.SetFocus(tui.input)
+}
+
+func (tui *MerkleTUI) exportSPDX() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "spdx_path"
+	tui.updateStatus("Exporting SPDX...")
+	tui.writeOutput("[yellow]═══ SPDX File Entries ═══[white]")
+	tui.writeOutput("[blue]Files are listed with SHA-1, SHA-256 and MD5 checksums and their sizes.[white]")
+	tui.input.SetText(filepath.Base(tui.treePath) + ".spdx.json")
+	tui.input.SetLabel("SPDX file: ")
+	tui.app.SetFocus(tui.input)
+}
+
+func (tui *MerkleTUI) auditTree() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "baseline_path"
+	tui.updateStatus("Auditing tree...")
+	tui.writeOutput("[yellow]═══ Hashdeep Audit ═══[white]")
+	tui.writeOutput("[blue]Enter a known-files list written by hashdeep (size, md5, sha1 and sha256 columns are checked), or a sha256sum, sha1sum or md5sum checksum file.[white]")
+	tui.input.SetText("")
+	tui.input.SetLabel("Baseline: ")
+	tui.app.SetFocus(tui.input)
+}
+
+func (tui *MerkleTUI) storeChunks() {
+	if !tui.treeBuilt {
+		tui.writeOutput("[red]✗ Build the tree first (option 1).[white]")
+		return
+	}
+	tui.currentAction = "store_dir"
+	tui.storeUse = tui.ingest
+	tui.updateStatus("Storing chunks...")
+	tui.writeOutput("[yellow]═══ Encrypted Chunk Store ═══[white]")
+	tui.writeOutput("[blue]Enter the store directory or an s3:// or gs:// bucket URL. It is created on first use.[white]")
+	// The store the tree went to last time comes before the default one
+	dir := os.Getenv(store.DirEnv)
+	if t, ok := tui.registered(); ok && t.Store() != "" {
+		dir = t.Store()
+	}
+	tui.input.SetText(dir)
+	tui.input.SetLabel("Store: ")
+	tui.app.SetFocus(tui.input)
+}
+
+// ingest exports the tree so its file list is known, then stores the
+// chunks once the export has been read in processStoreOutput.
+func (tui *MerkleTUI) ingest(key store.Key) {
+	tui.input.SetLabel("Input: ")
+	tui.app.SetFocus(tui.menu)
+	if !strings.Contains(tui.storeDir, "://") {
+		if err := forensic.CheckOutput(tui.treePath, tui.storeDir); err != nil {
+			tui.currentAction = ""
+			tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+			tui.updateStatus("Storing chunks failed")
+			return
+		}
+	}
+	tui.storeKey = key
+	tui.currentAction = "store_export"
+	tui.requestExport()
+}
+
+func (tui *MerkleTUI) exit() {
+	tui.updateStatus("Exiting...")
+	tui.writeOutput("[yellow]═══ Exiting Application ═══[white]")
+	// The backend is closed with the session
+	tui.quit()
+}
+
+// startBuild has the backend build the tree at path, whose absolute path
+// is tree.
+func (tui *MerkleTUI) startBuild(path, tree string) {
+	tui.beginReport("build")
+	var res *protocol.BuildResult
+	tui.call(func(b backend.Backend) (err error) {
+		res, err = b.BuildTree(path, tui.progress)
+		return err
+	}, func(err error) {
+		tui.processBuildOutput(res, err)
+	})
+	tui.writeOutput(fmt.Sprintf("[blue]🔨 Building tree from: %s[white]", path))
+	tui.treeBuilt = true
+	tui.treeFiles, tui.treeDirs, tui.treeBytes = -1, 0, 0
+	tui.unreadable, tui.quotaAlerts = 0, nil
+	tui.builtChunk, tui.builtChunking = tui.chunkSize, tui.chunking
+	tui.signed, tui.verified = false, ""
+	tui.treePath = tree
+	tui.input.SetLabel("Input: ")
+	tui.app.SetFocus(tui.menu)
+}
+
+// runHook runs command as hook for the operation c in the background,
+// shows what it wrote and records it in the operation log. done, if not
+// nil, is then called with the error of the hook.
+func (tui *MerkleTUI) runHook(hook, command string, c hooks.Context, done func(error)) {
+	tui.writeOutput(fmt.Sprintf("[blue]↪ Running %s hook: %s[white]", hook, tview.Escape(command)))
+	tui.spawn(func() {
+		output, err := hooks.Run(hook, command, c)
+		tui.app.QueueUpdateDraw(func() {
+			for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+				if line != "" {
+					tui.writeOutput("  " + tview.Escape(line))
+				}
+			}
+			tui.recordOn(c.Tree, "hook", c.Root, err, "hook", hook)
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ The %s hook failed: %v[white]", hook, err))
+			}
+			if done != nil {
+				done(err)
+			}
+		})
+	})
+}
+
+// afterOperation runs the hook set for the operation that just ended on
+// the current tree, if any, and the on-change hook when a build changed
+// the root hash from previous.
+func (tui *MerkleTUI) afterOperation(hook, previous string, opErr error) {
+	c := hooks.Context{Tree: tui.treePath, Root: tui.rootHash, Previous: previous, Err: opErr}
+	if opErr != nil && hook == hooks.PostBuild {
+		c.Root = ""
+	}
+	if command := hooks.Command(hook); command != "" {
+		tui.runHook(hook, command, c, nil)
+	}
+	if hook != hooks.PostBuild || opErr != nil || previous == "" || previous == tui.rootHash {
+		return
+	}
+	if command := hooks.Command(hooks.OnChange); command != "" {
+		tui.runHook(hooks.OnChange, command, c, nil)
+	}
+}
+
+func (tui *MerkleTUI) handleInput() {
+	inputText := tui.input.GetText()
+	tui.input.SetText("")
+
+	switch tui.currentAction {
+	case "build":
+		// A registered tree can be given by name
+		inputText = registry.Resolve(inputText)
+		if err := forensic.CheckTree(inputText); err != nil {
+			tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+			return
+		}
+		tree := inputText
+		if abs, err := paths.Abs(inputText); err == nil {
+			tree = abs
+		}
+		command := hooks.Command(hooks.PreBuild)
+		if command == "" {
+			tui.startBuild(inputText, tree)
+			return
+		}
+		// The build is only asked for once the hook lets it go
+		tui.updateStatus("Running pre-build hook...")
+		tui.runHook(hooks.PreBuild, command, hooks.Context{Tree: tree}, func(err error) {
+			if err != nil {
+				tui.writeOutput("[blue]The tree was not built. Enter another path, or fix the hook and enter this one again.[white]")
+				tui.updateStatus("Pre-build hook failed")
+				return
+			}
+			tui.startBuild(inputText, tree)
+		})
+
+	case "preview":
+		// A registered tree can be given by name
+		inputText = registry.Resolve(inputText)
+		if err := forensic.CheckTree(inputText); err != nil {
+			tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+			return
+		}
+		var res *protocol.BuildResult
+		tui.call(func(b backend.Backend) (err error) {
+			res, err = b.PreviewBuild(inputText, tui.progress)
+			return err
+		}, func(err error) {
+			tui.processPreviewOutput(res, err)
+		})
+		tui.writeOutput(fmt.Sprintf("[blue]🔎 Walking: %s[white]", tview.Escape(inputText)))
+		tui.input.SetLabel("Input: ")
+		tui.app.SetFocus(tui.menu)
+
+	case "chunk":
+		// Validate chunk size
+		p, err := chunking.Parse(inputText)
+		if err != nil {
+			tui.writeOutput("[red]✗ Invalid chunk size. Please enter a number of bytes, or fastcdc:MIN/AVG/MAX.[white]")
+			return
+		}
+		if p.CDC {
+			tui.setChunking(p)
+			tui.input.SetLabel("Input: ")
+			tui.app.SetFocus(tui.menu)
+			break
+		}
+		size := int64(p.Size)
+		var res *protocol.ChunkSizeResult
+		tui.call(func(b backend.Backend) (err error) {
+			res, err = b.SetChunkSize(size)
+			return err
+		}, func(err error) {
+			tui.processChunkOutput(res, err)
+		})
+		tui.writeOutput(fmt.Sprintf("[blue]🔧 Setting chunk size to: %d bytes[white]", size))
+		tui.input.SetLabel("Input: ")
+		tui.app.SetFocus(tui.menu)
+
+	case "sign":
+		tui.signKeyID = strings.TrimSpace(inputText)
+		tui.currentAction = "sign_export"
+		tui.requestExport()
+		tui.input.SetLabel("Input: ")
+		tui.app.SetFocus(tui.menu)
+		return
+
+	case "store_dir":
+		tui.storeDir = strings.TrimSpace(inputText)
+		if tui.storeDir == "" {
+			tui.writeOutput("[red]✗ Enter a store directory or bucket URL.[white]")
+			return
+		}
+		if keyFile := os.Getenv(store.KeyFileEnv); keyFile != "" {
+			key, err := store.KeyFromFile(keyFile)
+			if err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+				return
+			}
+			tui.storeUse(key)
+			return
+		}
+		tui.currentAction = "store_passphrase"
+		tui.input.SetMaskCharacter('*')
+		tui.input.SetLabel("Store passphrase: ")
+		return
+
+	case "store_passphrase":
+		tui.input.SetMaskCharacter(0)
+		if inputText == "" {
+			tui.writeOutput("[red]✗ The passphrase cannot be empty.[white]")
+			tui.input.SetMaskCharacter('*')
+			return
+		}
+		tui.storeUse(store.Key{Passphrase: inputText})
+		return
+
+	case "extract_dest":
+		dest, err := extractDest(inputText)
+		if err != nil {
+			tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+			return
+		}
+		tui.currentAction = ""
+		tui.input.SetLabel("Input: ")
+		tui.app.SetFocus(tui.menu)
+		tui.extractTo(dest)
+		return
+
+	case "ipfs_query":
+		query := strings.TrimSpace(inputText)
+		if _, ok := ipfs.ParseVersion(query); !ok {
+			if _, err := ipfs.VersionOf(query); err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %q: %v[white]", query, err))
+				return
+			}
+		}
+		tui.cidQuery = query
+		tui.currentAction = "ipfs_export"
+		tui.requestExport()
+		tui.input.SetLabel("Input: ")
+		tui.app.SetFocus(tui.menu)
+		return
+
+	case "torrent_path":
+		tui.torrentPath = strings.TrimSpace(inputText)
+		if tui.torrentPath == "" {
+			tui.writeOutput("[red]✗ Enter a file name for the torrent.[white]")
+			return
+		}
+		if err := forensic.CheckOutput(tui.treePath, tui.torrentPath); err != nil {
+			tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+			return
+		}
+		tui.currentAction = "torrent_export"
+		tui.requestExport()
+		tui.input.SetLabel("Input: ")
+		tui.app.SetFocus(tui.menu)
+		return
+
+	case "export_path":
+		tui.exportPath = strings.TrimSpace(inputText)
+		if tui.exportPath != "" {
+			if err := forensic.CheckOutput(tui.treePath, tui.exportPath); err != nil {
+				tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+				return
+			}
+		}
+		tui.currentAction = "export"
+		tui.requestExport()
+		tui.input.SetLabel("Input: ")
+		tui.app.SetFocus(tui.menu)
+		return
+
+	case "spdx_path":
+		tui.spdxPath = strings.TrimSpace(inputText)
+		if tui.spdxPath == "" {
+			tui.writeOutput("[red]✗ Enter a file name for the SPDX document.[white]")
+			return
+		}
+		if err := forensic.CheckOutput(tui.treePath, tui.spdxPath); err != nil {
+			tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+			return
+		}
+		tui.currentAction = "spdx_export"
+		tui.requestExport()
+		tui.input.SetLabel("Input: ")
+		tui.app.SetFocus(tui.menu)
+		return
+
+	case "import_path":
+		name := strings.TrimSpace(inputText)
+		data, err := os.ReadFile(name)
+		var root *manifest.Node
+		if err == nil {
+			root, err = manifest.Parse(data)
+		}
+		if err != nil {
+			tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+			return
+		}
+		tui.importPath, tui.imported = name, root
+		tui.writeOutput(fmt.Sprintf("[blue]Loaded %s: root %s, hashing the tree again...[white]", name, root.Hash))
+		tui.currentAction = "import_export"
+		tui.requestExport()
+		tui.input.SetLabel("Input: ")
+		tui.app.SetFocus(tui.menu)
+		return
+
+	case "compare_path":
+		other := strings.TrimSpace(inputText)
+		if other == "" {
+			tui.writeOutput("[red]✗ Enter a directory or tree name to compare with.[white]")
+			return
+		}
+		tui.currentAction = ""
+		tui.input.SetLabel("Input: ")
+		tui.app.SetFocus(tui.menu)
+		tui.compareWith(other)
+		return
+
+	case "similar_min":
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(inputText), "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			tui.writeOutput("[red]✗ Enter a share of the larger file from 1 to 100 percent.[white]")
+			return
+		}
+		tui.similarMin = percent / 100
+		tui.currentAction = "similar_export"
+		tui.requestExport()
+		tui.input.SetLabel("Input: ")
+		tui.app.SetFocus(tui.menu)
+		return
+
+	case "chunkmap_path":
+		tui.chunkMapPath = strings.TrimSpace(inputText)
+		if tui.chunkMapPath == "" {
+			tui.writeOutput("[red]✗ Enter a file name for the chunk map.[white]")
+			return
+		}
+		if err := forensic.CheckOutput(tui.treePath, tui.chunkMapPath); err != nil {
+			tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+			return
+		}
+		tui.currentAction = "chunkmap_export"
+		tui.requestExport()
+		tui.input.SetLabel("Input: ")
+		tui.app.SetFocus(tui.menu)
+		return
+
+	case "proof_path":
+		tui.setProofPath(inputText)
+		return
+
+	case "proof_file":
+		tui.setProofFile(inputText)
+		return
+
+	case "proof_load":
+		tui.loadProof(inputText)
+		return
+
+	case "proof_root":
+		tui.checkProof(inputText)
+		return
+
+	case "watch_dir":
+		tui.startWatch(inputText)
+		return
+
+	case "hash_format":
+		tui.setHashFormat(inputText)
+		return
+
+	case "filter_include":
+		tui.setIncludes(inputText)
+		return
+
+	case "filter_exclude":
+		tui.setExcludes(inputText)
+		return
+
+	case "snapshot_name":
+		tui.setSnapshotName(inputText)
+		return
+
+	case "baseline_path":
+		baseline, err := audit.LoadBaseline(strings.TrimSpace(inputText))
+		if err != nil {
+			tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+			return
+		}
+		tui.baseline = baseline
+		tui.currentAction = "audit_export"
+		tui.requestExport()
+		tui.input.SetLabel("Input: ")
+		tui.app.SetFocus(tui.menu)
+		return
+
+	case "bundle_path":
+		tui.bundlePath = strings.TrimSpace(inputText)
+		if tui.bundlePath == "" {
+			tui.writeOutput("[red]✗ Enter a file name for the bundle.[white]")
+			return
+		}
+		if err := forensic.CheckOutput(tui.treePath, tui.bundlePath); err != nil {
+			tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+			return
+		}
+		tui.currentAction = "bundle_password"
+		tui.input.SetMaskCharacter('*')
+		tui.input.SetLabel("Bundle password: ")
+		return
+
+	case "bundle_password":
+		if inputText == "" {
+			tui.writeOutput("[red]✗ The password cannot be empty.[white]")
+			return
+		}
+		tui.bundlePass = inputText
+		tui.currentAction = "bundle_confirm"
+		tui.input.SetLabel("Repeat password: ")
+		return
+
+	case "bundle_confirm":
+		if inputText != tui.bundlePass {
+			tui.writeOutput("[red]✗ Passwords do not match. Enter the password again.[white]")
+			tui.currentAction = "bundle_password"
+			tui.input.SetLabel("Bundle password: ")
+			return
+		}
+		tui.input.SetMaskCharacter(0)
+		tui.currentAction = "bundle_export"
+		tui.requestExport()
+		tui.input.SetLabel("Input: ")
+		tui.app.SetFocus(tui.menu)
+		return
+
+	default:
+		// Nothing asked for input: operations are chosen from the menu
+		tui.app.SetFocus(tui.menu)
+	}
+
+	tui.updateStatus("Ready")
+}
+
+func (tui *MerkleTUI) Run() error {
+	defer tui.log.Close()
+	defer tui.recoverPanic()
+	tui.log.Debug("session started", "log", tui.log.Path)
+	err := tui.app.SetRoot(tui.pages, true).Run()
+	if err != nil {
+		tui.log.Error("TUI stopped", "err", err)
+	} else {
+		tui.log.Debug("session ended")
+	}
+	return err
+}
+
+func (tui *MerkleTUI) cleanup() {
+	tui.stopWatch()
+	tui.stopBackend()
+	tui.log.Close()
+}
+
+func (tui *MerkleTUI) stopBackend() {
+	if tui.backend == nil {
+		return
+	}
+	if err := tui.backend.Close(); err != nil {
+		tui.log.Debug("backend stopped", "err", err)
+	}
+	tui.backend = nil
+}
+
+// restartBackend starts a fresh backend, such as one with new settings.
+// The tree the old one built is gone with it.
+func (tui *MerkleTUI) restartBackend() {
+	tui.stopBackend()
+	tui.treeBuilt, tui.currentAction = false, ""
+	tui.startBackend()
+	tui.updateStatus("Backend restarted")
+	tui.writeOutput("[blue]The backend was restarted with the new settings; build the tree again.[white]")
 }
 
 func main() {
 	tui := NewMerkleTUI()
 	defer tui.cleanup()
-	
+
 	if err := tui.Run(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error running TUI: %v\n", err)
 		os.Exit(1)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,133 @@
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"MTFS/forensic"
+	"MTFS/paths"
+	"MTFS/pkg/merkle"
+	"MTFS/protocol"
+	"MTFS/registry"
+	"MTFS/watch"
+
+	"github.com/rivo/tview"
+)
+
+// watchedShown is how many changed paths an update lists before it only
+// counts the others.
+const watchedShown = 5
+
+// watchDirectory asks for a directory to watch, the tree's by default,
+// or stops the watch running.
+func (tui *MerkleTUI) watchDirectory() {
+	if tui.watcher != nil {
+		tui.stopWatch()
+		tui.writeOutput("[blue]Stopped watching.[white]")
+		tui.updateStatus("Ready")
+		return
+	}
+	tui.currentAction = "watch_dir"
+	tui.updateStatus("Watching directory...")
+	tui.writeOutput("[yellow]═══ Watch Directory ═══[white]")
+	tui.writeOutput("[blue]The directory is built in process, then the entries that change are hashed again as they change, with the directories above them. Choose this option again to stop.[white]")
+	tui.input.SetText(tui.treePath)
+	tui.input.SetLabel("Directory to watch: ")
+	tui.app.SetFocus(tui.input)
+}
+
+// startWatch builds the directory entered and watches it.
+func (tui *MerkleTUI) startWatch(text string) {
+	// A registered tree can be given by name
+	dir := registry.Resolve(strings.TrimSpace(text))
+	if err := forensic.CheckTree(dir); err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %v[white]", err))
+		return
+	}
+	if abs, err := paths.Abs(dir); err == nil {
+		dir = abs
+	}
+	tui.currentAction = ""
+	tui.input.SetText("")
+	tui.input.SetLabel("Input: ")
+	tui.app.SetFocus(tui.menu)
+	tui.updateStatus("Building " + dir + " to watch...")
+
+	chunkSize := tui.chunkSize
+	args := append([]string{"mtfs"}, tui.backendArgs...)
+	tui.spawn(func() {
+		builder, err := merkle.NewSession(args)
+		var w *watch.Watcher
+		if err == nil {
+			builder.ChunkSize = int64(chunkSize)
+			var lines protocol.ProgressLines
+			builder.Progress = func(line string) {
+				if progress, _ := lines.Add(line); progress != nil {
+					tui.progress(*progress)
+				}
+			}
+			w, err = watch.Start(builder, dir, watch.DefaultDelay, tui.watchUpdate)
+		}
+		tui.app.QueueUpdateDraw(func() {
+			tui.showWorkers(nil)
+			tui.processWatchStart(dir, w, err)
+		})
+	})
+}
+
+// processWatchStart reports the tree a watch started with.
+func (tui *MerkleTUI) processWatchStart(dir string, w *watch.Watcher, err error) {
+	tui.record("watch", "", err, "dir", dir, "chunk_size", strconv.Itoa(tui.chunkSize))
+	if err != nil {
+		tui.writeOutput(fmt.Sprintf("[red]✗ %s[white]", tview.Escape(err.Error())))
+		tui.updateStatus("Watch failed")
+		return
+	}
+	if tui.watcher != nil {
+		// Another watch started while this one built
+		tui.watcher.Close()
+	}
+	tui.watcher = w
+	s := w.Stats()
+	tui.writeOutput(fmt.Sprintf("[green]✓ Watching %s (%s files, %s)[white]", tview.Escape(w.Dir), groupDigits(int64(s.Files)), tui.size(s.Bytes)))
+	tui.writeOutput(fmt.Sprintf("[cyan]🔐 Root hash: %s[white]", w.Root()))
+	tui.updateStatus("Ready")
+}
+
+// watchUpdate shows an update of the watch, from its goroutine.
+func (tui *MerkleTUI) watchUpdate(u watch.Update) {
+	tui.app.QueueUpdateDraw(func() {
+		if tui.watcher == nil {
+			return
+		}
+		stamp := u.Time.Format(time.TimeOnly)
+		if u.Err != nil {
+			tui.writeOutput(fmt.Sprintf("[red]✗ %s %s[white]", stamp, tview.Escape(u.Err.Error())))
+		}
+		if len(u.Paths) > 0 {
+			shown := u.Paths
+			if len(shown) > watchedShown {
+				shown = shown[:watchedShown]
+			}
+			changed := tview.Escape(strings.Join(shown, ", "))
+			if more := len(u.Paths) - len(shown); more > 0 {
+				changed += fmt.Sprintf(" and %d more", more)
+			}
+			tui.writeOutput(fmt.Sprintf("[blue]%s[white] %s [cyan]→ %s[white]", stamp, changed, u.Root))
+		}
+		tui.updateStatus(tui.statusMessage)
+	})
+}
+
+// stopWatch stops the watch running, if any.
+func (tui *MerkleTUI) stopWatch() {
+	if tui.watcher == nil {
+		return
+	}
+	if err := tui.watcher.Close(); err != nil {
+		tui.log.Debug("watch stopped", "err", err)
+	}
+	tui.watcher = nil
+}
@@ -0,0 +1,43 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"MTFS/protocol"
+
+	"github.com/rivo/tview"
+)
+
+// workersShown is how many workers the worker pane lists before it only
+// counts the others.
+const workersShown = 8
+
+// showWorkers lists the files the workers of a build are hashing in the
+// worker pane, which is hidden when there are none.
+func (tui *MerkleTUI) showWorkers(workers []protocol.WorkerProgress) {
+	if len(workers) == 0 {
+		tui.layout.ResizeItem(tui.workers, 0, 0)
+		tui.workers.Clear()
+		return
+	}
+	shown := workers
+	if len(shown) > workersShown {
+		shown = shown[:workersShown]
+	}
+	lines := make([]string, 0, len(shown)+1)
+	for _, w := range shown {
+		percent := 100.0
+		if w.Size > 0 {
+			percent = float64(min(w.Bytes, w.Size)) / float64(w.Size) * 100
+		}
+		lines = append(lines, fmt.Sprintf("[cyan]#%-2d[white] %s %5.1f%% %s of %s  %s", w.Worker,
+			tview.Escape(shareBar(percent, 10)), percent, tui.size(w.Bytes), tui.size(w.Size), tview.Escape(w.Path)))
+	}
+	if more := len(workers) - len(shown); more > 0 {
+		lines = append(lines, fmt.Sprintf("and %d more", more))
+	}
+	tui.workers.SetTitle(fmt.Sprintf("Workers (%d busy)", len(workers)))
+	tui.workers.SetText(tui.colored(strings.Join(lines, "\n")))
+	tui.layout.ResizeItem(tui.workers, len(lines)+2, 0)
+}
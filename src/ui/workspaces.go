@@ -0,0 +1,319 @@
+package ui
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"MTFS/forensic"
+	"MTFS/logging"
+	"MTFS/monitor"
+	"MTFS/oplog"
+	"MTFS/settings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Workspaces runs several sessions in one terminal, each with its own
+// backend, tree, output pane and status bar, under a tab bar to switch
+// between them. Ctrl-T opens a workspace, Ctrl-N and Ctrl-P switch to
+// the next and previous one, Alt-1 to Alt-9 to a given one, and Exit
+// closes the current one.
+type Workspaces struct {
+	app         *tview.Application
+	tabs        *tview.TextView
+	pages       *tview.Pages
+	log         *logging.Logger
+	backendArgs []string
+	list        []*MerkleTUI
+	ids         map[*MerkleTUI]string
+	active      int
+	nextID      int
+	restore     []func()
+}
+
+// NewWorkspaces opens the first workspace, whose backend is started
+// with backendArgs like those opened later.
+func NewWorkspaces(backendArgs ...string) *Workspaces {
+	ws := &Workspaces{
+		app:         tview.NewApplication(),
+		tabs:        tview.NewTextView().SetDynamicColors(true).SetWrap(false),
+		pages:       tview.NewPages(),
+		backendArgs: backendArgs,
+		ids:         map[*MerkleTUI]string{},
+	}
+	log, err := logging.Open(nil)
+	ws.log = log
+	ws.app.SetInputCapture(ws.captureKey)
+	ws.app.SetBeforeDrawFunc(func(screen tcell.Screen) bool {
+		for _, tui := range ws.list {
+			tui.watchScreen(screen)
+		}
+		return false
+	})
+	ws.add()
+	if err != nil {
+		ws.current().log.Warn("logging to the output pane only", "err", err)
+	}
+	return ws
+}
+
+// OnCrash adds f to what is done to restore the terminal if any
+// workspace crashes.
+func (ws *Workspaces) OnCrash(f func()) {
+	ws.restore = append(ws.restore, f)
+}
+
+// OpenRecent, NewTree, AttachDaemon and EditSettings start the current
+// workspace on a task once Run shows it, as chosen on the landing screen.
+// The setup wizard of a first launch comes first and drops the task.
+func (ws *Workspaces) OpenRecent()   { ws.start((*MerkleTUI).openRecent) }
+func (ws *Workspaces) NewTree()      { ws.start((*MerkleTUI).buildTree) }
+func (ws *Workspaces) AttachDaemon() { ws.start((*MerkleTUI).attachDaemon) }
+func (ws *Workspaces) EditSettings() { ws.start((*MerkleTUI).editSettings) }
+
+func (ws *Workspaces) start(task func(*MerkleTUI)) {
+	tui := ws.current()
+	// Queued for Run to do, so the focus it sets outlasts the one Run
+	// gives the root; queueing waits for Run, hence the goroutine
+	tui.spawn(func() {
+		ws.app.QueueUpdateDraw(func() {
+			if front, _ := tui.pages.GetFrontPage(); front != setupPage {
+				task(tui)
+			}
+		})
+	})
+}
+
+// Run shows the workspaces until the last one is closed.
+func (ws *Workspaces) Run() error {
+	defer ws.cleanup()
+	defer func() {
+		if p := recover(); p != nil {
+			if tui := ws.current(); tui != nil {
+				tui.crash(p, debug.Stack())
+			}
+			panic(p)
+		}
+	}()
+	ws.log.Debug("session started", "log", ws.log.Path)
+	stop := make(chan struct{})
+	defer close(stop)
+	// Under the panic handler of the first workspace, which restores the
+	// terminal and stops the backends of all of them
+	ws.current().spawn(func() { ws.refreshTabs(stop) })
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(ws.tabs, 1, 0, false).
+		AddItem(ws.pages, 0, 1, true)
+	err := ws.app.SetRoot(layout, true).Run()
+	if err != nil {
+		ws.log.Error("TUI stopped", "err", err)
+	} else {
+		ws.log.Debug("session ended")
+	}
+	return err
+}
+
+func (ws *Workspaces) cleanup() {
+	for _, tui := range ws.list {
+		tui.cleanup()
+	}
+	ws.log.Close()
+}
+
+func (ws *Workspaces) current() *MerkleTUI {
+	if ws.active < 0 || ws.active >= len(ws.list) {
+		return nil
+	}
+	return ws.list[ws.active]
+}
+
+// add opens a workspace and switches to it.
+func (ws *Workspaces) add() {
+	tui := newMerkleTUI(ws.app, ws.log, ws.backendArgs)
+	ws.nextID++
+	id := strconv.Itoa(ws.nextID)
+	ws.ids[tui] = id
+	tui.quit = func() { ws.close(tui) }
+	tui.onStatus = ws.drawTabs
+	tui.peers = func() []string {
+		var trees []string
+		for _, other := range ws.list {
+			if other != tui && other.treeBuilt {
+				trees = append(trees, other.treePath)
+			}
+		}
+		return trees
+	}
+	// Every backend goes when one workspace crashes
+	tui.OnCrash(func() {
+		for _, other := range ws.list {
+			if other != tui {
+				other.cleanup()
+			}
+		}
+		for _, f := range ws.restore {
+			f()
+		}
+	})
+	ws.list = append(ws.list, tui)
+	ws.pages.AddPage(id, tui.pages, true, false)
+	ws.log.Debug("workspace opened", "workspace", id)
+	ws.switchTo(len(ws.list) - 1)
+}
+
+// close closes the workspace of tui and stops when none is left.
+func (ws *Workspaces) close(tui *MerkleTUI) {
+	for i, t := range ws.list {
+		if t != tui {
+			continue
+		}
+		tui.cleanup()
+		ws.pages.RemovePage(ws.ids[tui])
+		ws.log.Debug("workspace closed", "workspace", ws.ids[tui])
+		delete(ws.ids, tui)
+		ws.list = append(ws.list[:i], ws.list[i+1:]...)
+		if len(ws.list) == 0 {
+			ws.app.Stop()
+			return
+		}
+		// The closed workspace has no focus to remember
+		ws.active = -1
+		ws.switchTo(min(i, len(ws.list)-1))
+		return
+	}
+}
+
+// switchTo shows workspace i, with the focus it had when it was left.
+func (ws *Workspaces) switchTo(i int) {
+	if tui := ws.current(); tui != nil {
+		tui.lastFocus = ws.app.GetFocus()
+	}
+	ws.active = i
+	tui := ws.list[i]
+	ws.pages.SwitchToPage(ws.ids[tui])
+	focus := tui.lastFocus
+	if focus == nil {
+		// A new workspace may show the setup wizard
+		if front, p := tui.pages.GetFrontPage(); front != "main" {
+			focus = p
+		} else {
+			focus = tui.menu
+		}
+	}
+	ws.app.SetFocus(focus)
+	ws.drawTabs()
+}
+
+// drawTabs shows each workspace with the tree it has open and its
+// badges.
+func (ws *Workspaces) drawTabs() {
+	var b strings.Builder
+	plain := false
+	for i, tui := range ws.list {
+		name := "new"
+		if tui.treePath != "" {
+			name = filepath.Base(tui.treePath)
+		}
+		name = tview.Escape(name) + tui.badges()
+		label := fmt.Sprintf(" %d %s ", i+1, name)
+		switch {
+		case i == ws.active && tui.plain:
+			label = fmt.Sprintf(">%d %s<", i+1, name)
+		case i == ws.active:
+			label = "[black:white]" + label + "[-:-]"
+		}
+		plain = plain || tui.plain
+		b.WriteString(label)
+	}
+	hint := "  ✎ signed ✓ verified ◉ watched · Ctrl-T new · Ctrl-N/Ctrl-P switch · Alt-1…9 go to · Exit closes"
+	if !plain {
+		hint = "[gray]" + hint + "[-]"
+	}
+	if forensic.Dir() != "" {
+		mode := "  FORENSIC (read-only, case " + tview.Escape(forensic.Dir()) + ")"
+		if !plain {
+			mode = "[red::b]" + mode + "[-::-]"
+		}
+		hint = mode + hint
+	}
+	ws.tabs.SetText(b.String() + hint)
+}
+
+// badges sums up the state of the tree of tui: its hash format unless it
+// is the default one, whether its root hash is signed and was verified,
+// and whether a daemon watches it.
+func (tui *MerkleTUI) badges() string {
+	if !tui.treeBuilt {
+		return ""
+	}
+	badge := func(text, color string) string {
+		if tui.plain {
+			return text
+		}
+		return "[" + color + "]" + text + "[-]"
+	}
+	var b strings.Builder
+	if tui.hashFormat != "" && tui.hashFormat != settings.HashFormats[0] {
+		b.WriteString(" " + tui.hashFormat)
+	}
+	if tui.signed {
+		b.WriteString(" " + badge("✎", "green"))
+	}
+	switch tui.verified {
+	case oplog.OK:
+		b.WriteString(" " + badge("✓", "green"))
+	case oplog.Failed:
+		b.WriteString(" " + badge("✗", "red"))
+	}
+	if monitor.Watching(tui.treePath) {
+		b.WriteString(" " + badge("◉", "blue"))
+	}
+	return b.String()
+}
+
+// refreshTabs redraws the tabs every few seconds until stop is closed,
+// so daemons started or stopped meanwhile show.
+func (ws *Workspaces) refreshTabs(stop <-chan struct{}) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ws.app.QueueUpdateDraw(ws.drawTabs)
+		}
+	}
+}
+
+// captureKey switches workspaces and hands other keys to the current
+// one.
+func (ws *Workspaces) captureKey(event *tcell.EventKey) *tcell.EventKey {
+	switch event.Key() {
+	case tcell.KeyCtrlT:
+		ws.add()
+		return nil
+	case tcell.KeyCtrlN:
+		ws.switchTo((ws.active + 1) % len(ws.list))
+		return nil
+	case tcell.KeyCtrlP:
+		ws.switchTo((ws.active + len(ws.list) - 1) % len(ws.list))
+		return nil
+	case tcell.KeyRune:
+		if r := event.Rune(); event.Modifiers()&tcell.ModAlt != 0 && r >= '1' && r <= '9' {
+			if i := int(r - '1'); i < len(ws.list) {
+				ws.switchTo(i)
+			}
+			return nil
+		}
+	}
+	if tui := ws.current(); tui != nil {
+		return tui.captureKey(event)
+	}
+	return event
+}
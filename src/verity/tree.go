@@ -0,0 +1,137 @@
+package verity
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"MTFS/forensic"
+	"MTFS/manifest"
+)
+
+// Problem describes a file that failed an operation.
+type Problem struct {
+	Path    string
+	Message string
+}
+
+// Result summarises Protect or Check.
+type Result struct {
+	Files    int
+	OK       int
+	Problems []Problem
+}
+
+func (r *Result) problem(p, format string, args ...any) {
+	r.Problems = append(r.Problems, Problem{Path: p, Message: fmt.Sprintf(format, args...)})
+}
+
+// Protect enables fs-verity on every file of the tree described by root
+// whose content still matches the tree, and records the digests. progress,
+// if not nil, is called after each file.
+func Protect(tree string, root *manifest.Node, progress func(done int)) (*Result, error) {
+	if err := forensic.Refuse("enable fs-verity, which makes the files of " + tree + " read-only"); err != nil {
+		return nil, err
+	}
+	rec, err := LoadRecord(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{}
+	err = root.Walk(func(p string, node *manifest.Node) error {
+		if !node.IsFile() {
+			return nil
+		}
+		res.Files++
+		defer func() {
+			if progress != nil {
+				progress(res.Files)
+			}
+		}()
+
+		path := filepath.Join(tree, filepath.FromSlash(p))
+		f, err := forensic.Open(path)
+		if err != nil {
+			res.problem(p, "%v", err)
+			return nil
+		}
+		// The content is checked the way the tree hashed it
+		check := node.NewContentCheck()
+		digest, _, err := Compute(io.TeeReader(f, check))
+		f.Close()
+		if err != nil {
+			res.problem(p, "%v", err)
+			return nil
+		}
+		if !check.Matches(node.ContentHash) {
+			res.problem(p, "changed since the tree was built")
+			return nil
+		}
+
+		if err := Enable(path); err != nil {
+			if errors.Is(err, ErrUnsupported) {
+				return err
+			}
+			res.problem(p, "enable: %v", err)
+			return nil
+		}
+		measured, err := Measure(path)
+		if err != nil {
+			res.problem(p, "measure: %v", err)
+			return nil
+		}
+		if measured != digest {
+			res.problem(p, "kernel digest %s differs from the computed %s", measured, digest)
+			return nil
+		}
+		rec[p] = Entry{Digest: digest, ContentHash: node.ContentHash}
+		res.OK++
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	return res, rec.Save(tree)
+}
+
+// Check cross-checks the digests the kernel enforces for the files of the
+// tree against the recorded digests and the content hashes in root.
+func Check(tree string, root *manifest.Node) (*Result, error) {
+	rec, err := LoadRecord(tree)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{}
+	err = root.Walk(func(p string, node *manifest.Node) error {
+		if !node.IsFile() {
+			return nil
+		}
+		res.Files++
+
+		entry, ok := rec[p]
+		if !ok {
+			res.problem(p, "no recorded fs-verity digest")
+			return nil
+		}
+		measured, err := Measure(filepath.Join(tree, filepath.FromSlash(p)))
+		switch {
+		case errors.Is(err, ErrUnsupported):
+			return err
+		case errors.Is(err, ErrNotEnabled):
+			res.problem(p, "fs-verity is no longer enabled")
+		case err != nil:
+			res.problem(p, "measure: %v", err)
+		case measured != entry.Digest:
+			res.problem(p, "kernel digest %s differs from the recorded %s", measured, entry.Digest)
+		case entry.ContentHash != node.ContentHash:
+			res.problem(p, "protected content differs from the Merkle tree")
+		default:
+			res.OK++
+		}
+		return nil
+	})
+	return res, err
+}
@@ -0,0 +1,129 @@
+// Package verity enables Linux fs-verity on the files of a tree and
+// cross-checks the kernel-enforced file digests against the Merkle tree.
+//
+// The fs-verity digest of each file is also computed in user space while
+// its content hash is checked, so a recorded digest is only ever paired
+// with content the MTFS tree vouches for.
+package verity
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+
+	"MTFS/state"
+)
+
+const (
+	// BlockSize is the Merkle tree block size used when enabling verity.
+	BlockSize = 4096
+	// RecordFile is the name of the digest record inside .mtfs.
+	RecordFile = "verity.json"
+
+	hashAlgSHA256 = 1
+)
+
+// ErrUnsupported is returned when the platform or filesystem does not
+// support fs-verity.
+var ErrUnsupported = errors.New("fs-verity is not supported on this filesystem")
+
+// ErrNotEnabled is returned by Measure for files without fs-verity.
+var ErrNotEnabled = errors.New("fs-verity is not enabled on this file")
+
+// Entry pairs the fs-verity digest of a file with its MTFS content hash.
+type Entry struct {
+	Digest      string `json:"digest"`
+	ContentHash string `json:"content_hash"`
+}
+
+// Record maps slash-separated paths relative to the tree root to entries.
+type Record map[string]Entry
+
+// LoadRecord reads the digest record of tree; a missing record is empty.
+func LoadRecord(tree string) (Record, error) {
+	rec := Record{}
+	data, err := state.ReadFile(tree, RecordFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return rec, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Save writes the record to the state directory of tree.
+func (r Record) Save(tree string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return state.WriteFile(tree, RecordFile, append(data, '\n'))
+}
+
+// Compute reads f and returns its fs-verity SHA-256 digest (no salt,
+// BlockSize blocks) together with the plain SHA-256 of its content.
+func Compute(f io.Reader) (digest, contentHash string, err error) {
+	content := sha256.New()
+	var level [][]byte // hashes of the current lowest level
+	var size int64
+
+	block := make([]byte, BlockSize)
+	for {
+		n, err := io.ReadFull(f, block)
+		if n > 0 {
+			content.Write(block[:n])
+			size += int64(n)
+			clear(block[n:])
+			sum := sha256.Sum256(block)
+			level = append(level, sum[:])
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	rootHash := make([]byte, sha256.Size)
+	if size > 0 {
+		rootHash = merkleRoot(level)
+	}
+
+	// struct fsverity_descriptor
+	desc := make([]byte, 256)
+	desc[0] = 1 // version
+	desc[1] = hashAlgSHA256
+	desc[2] = 12 // log2(BlockSize)
+	binary.LittleEndian.PutUint64(desc[8:], uint64(size))
+	copy(desc[16:], rootHash)
+	sum := sha256.Sum256(desc)
+	return hex.EncodeToString(sum[:]), hex.EncodeToString(content.Sum(nil)), nil
+}
+
+// merkleRoot packs hashes into blocks level by level until a single hash
+// is left. A file of one block has no tree; its block hash is the root.
+func merkleRoot(level [][]byte) []byte {
+	perBlock := BlockSize / sha256.Size
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += perBlock {
+			block := make([]byte, BlockSize)
+			for j, h := range level[i:min(i+perBlock, len(level))] {
+				copy(block[j*sha256.Size:], h)
+			}
+			sum := sha256.Sum256(block)
+			next = append(next, sum[:])
+		}
+		level = next
+	}
+	return level[0]
+}
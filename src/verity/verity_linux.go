@@ -0,0 +1,85 @@
+//go:build linux
+
+package verity
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	fsIocEnableVerity  = 0x40806685 // _IOW('f', 133, struct fsverity_enable_arg)
+	fsIocMeasureVerity = 0xc0046686 // _IOWR('f', 134, struct fsverity_digest)
+)
+
+// fsverityEnableArg mirrors struct fsverity_enable_arg.
+type fsverityEnableArg struct {
+	Version       uint32
+	HashAlgorithm uint32
+	BlockSize     uint32
+	SaltSize      uint32
+	SaltPtr       uint64
+	SigSize       uint32
+	_             uint32
+	SigPtr        uint64
+	_             [11]uint64
+}
+
+// Enable turns on fs-verity for the file at path. The file becomes
+// read-only; enabling a file that already has verity is not an error.
+func Enable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	arg := fsverityEnableArg{Version: 1, HashAlgorithm: hashAlgSHA256, BlockSize: BlockSize}
+	err = ioctl(f, fsIocEnableVerity, unsafe.Pointer(&arg))
+	if errors.Is(err, syscall.EEXIST) {
+		return nil
+	}
+	return err
+}
+
+// Measure returns the digest the kernel enforces for the file at path.
+func Measure(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// struct fsverity_digest followed by room for the digest itself
+	var buf struct {
+		Alg    uint16
+		Size   uint16
+		Digest [64]byte
+	}
+	buf.Size = uint16(len(buf.Digest))
+	if err := ioctl(f, fsIocMeasureVerity, unsafe.Pointer(&buf)); err != nil {
+		if errors.Is(err, syscall.ENODATA) {
+			return "", ErrNotEnabled
+		}
+		return "", err
+	}
+	if buf.Alg != hashAlgSHA256 {
+		return "", errors.New("fs-verity digest does not use SHA-256")
+	}
+	return hex.EncodeToString(buf.Digest[:buf.Size]), nil
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg))
+	switch errno {
+	case 0:
+		return nil
+	case syscall.EOPNOTSUPP, syscall.ENOTTY:
+		return ErrUnsupported
+	default:
+		return errno
+	}
+}
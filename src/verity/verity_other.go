@@ -0,0 +1,13 @@
+//go:build !linux
+
+package verity
+
+// Enable is only available on Linux.
+func Enable(path string) error {
+	return ErrUnsupported
+}
+
+// Measure is only available on Linux.
+func Measure(path string) (string, error) {
+	return "", ErrUnsupported
+}
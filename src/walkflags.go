@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"MTFS/chunking"
+	"MTFS/pkg/merkle"
+	"MTFS/settings"
+)
+
+// patternList collects the values of a repeatable pattern flag.
+type patternList []string
+
+func (p *patternList) String() string { return strings.Join(*p, ",") }
+
+func (p *patternList) Set(v string) error {
+	if v == "" {
+		return errors.New("empty pattern")
+	}
+	*p = append(*p, v)
+	return nil
+}
+
+// choice is a flag accepting one of a fixed set of values.
+type choice struct {
+	value   string
+	allowed []string
+}
+
+func (c *choice) String() string { return c.value }
+
+func (c *choice) Set(v string) error {
+	for _, a := range c.allowed {
+		if v == a {
+			c.value = v
+			return nil
+		}
+	}
+	return fmt.Errorf("must be one of %s", strings.Join(c.allowed, ", "))
+}
+
+// metadataFields is a flag accepting the metadata fields hashed with each
+// entry, as a comma-separated list.
+type metadataFields string
+
+func (m *metadataFields) String() string { return string(*m) }
+
+func (m *metadataFields) Set(v string) error {
+	if _, _, err := merkle.MetadataFields(v); err != nil {
+		return errors.New("must be mode, owner, mtime and xattrs, comma-separated, or none")
+	}
+	*m = metadataFields(v)
+	return nil
+}
+
+// byteSize is a flag accepting sizes such as 512, 64K or 2G, with the
+// binary multiples the backend uses.
+type byteSize string
+
+func (b *byteSize) String() string { return string(*b) }
+
+func (b *byteSize) Set(v string) error {
+	if _, err := parseByteSize(v); err != nil {
+		return err
+	}
+	*b = byteSize(v)
+	return nil
+}
+
+// chunkingFlag is a flag accepting a chunk size, or content-defined
+// chunking as fastcdc:MIN/AVG/MAX.
+type chunkingFlag struct {
+	chunking.Params
+}
+
+func (c *chunkingFlag) Set(v string) error {
+	p, err := chunking.Parse(v)
+	if err != nil {
+		return err
+	}
+	c.Params = p
+	return nil
+}
+
+// workers is the number of workers hashing files, which the backend
+// reads from its environment: it is not recorded with the trees built.
+type workers int
+
+func (w *workers) String() string { return strconv.Itoa(int(*w)) }
+
+func (w *workers) Set(v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return errors.New("must be a positive number")
+	}
+	*w = workers(n)
+	return os.Setenv(settings.WorkersEnv, v)
+}
+
+func parseByteSize(s string) (uint64, error) {
+	digits := strings.ToUpper(s)
+	if d, ok := strings.CutSuffix(digits, "IB"); ok && d != "" && strings.ContainsAny(d[len(d)-1:], "KMGT") {
+		digits = d
+	} else {
+		digits = strings.TrimSuffix(digits, "B")
+	}
+	shift := 0
+	if i := strings.LastIndexAny(digits, "KMGT"); i >= 0 && i == len(digits)-1 {
+		shift = 10 * (strings.IndexByte("KMGT", digits[i]) + 1)
+		digits = digits[:i]
+	}
+	n, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil || n > (1<<63)>>shift {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n << shift, nil
+}
+
+// parseWalkFlags checks the walk options given to the interactive UI and
+// returns them as arguments for the backend. The backend records them
+// with each tree it builds, so later builds of the tree are scoped the
+// same way without repeating them.
+func parseWalkFlags(args []string) ([]string, error) {
+	flags := flag.NewFlagSet("mtfs_tui", flag.ContinueOnError)
+	addWalkFlags(flags)
+	flags.Usage = func() {
+		printUsage()
+		fmt.Fprintln(os.Stderr, "\nOptions:")
+		flags.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "\nPatterns containing '/' match the path from the tree root, others match the name.")
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		err := fmt.Errorf("unexpected argument %q", flags.Arg(0))
+		fmt.Fprintln(os.Stderr, err)
+		flags.Usage()
+		return nil, err
+	}
+	return walkArgs(flags), nil
+}
+
+// addWalkFlags defines the walk options of the backend in flags.
+func addWalkFlags(flags *flag.FlagSet) {
+	var includes, excludes patternList
+	var minFileSize, maxFileSize byteSize
+	var jobs workers
+	var metadata metadataFields
+	symlinks := &choice{value: "follow", allowed: []string{"follow", "record", "skip"}}
+	hidden := &choice{value: "include", allowed: []string{"include", "skip"}}
+	appleMetadata := &choice{value: "skip", allowed: []string{"skip", "include"}}
+	special := &choice{value: "skip", allowed: []string{"skip", "record", "error"}}
+	normalize := &choice{value: "none", allowed: []string{"none", "nfc", "nfd"}}
+	hashFormat := &choice{value: "mtfs", allowed: settings.HashFormats}
+	flags.Var(&includes, "include", "only hash files matching `PATTERN` (repeatable)")
+	flags.Var(&excludes, "exclude", "skip files and directories matching `PATTERN` (repeatable)")
+	flags.Var(symlinks, "symlinks", "symbolic link `POLICY`: follow, record (hash the link target) or skip")
+	flags.Var(hidden, "hidden", "dotfiles and dot-directories: include or skip")
+	flags.Var(appleMetadata, "apple-metadata", "AppleDouble ._ files, .DS_Store, __MACOSX and com.apple.* attributes: skip or include")
+	flags.Uint("max-depth", 0, "do not read directories more than `N` levels below the root (0 for no limit)")
+	flags.Var(&minFileSize, "min-file-size", "do not hash files smaller than `SIZE`, e.g. 1 to skip empty files (0 for no limit)")
+	flags.Var(&maxFileSize, "max-file-size", "do not hash files larger than `SIZE`, e.g. 512M or 2G (0 for no limit)")
+	oneFileSystem := flags.Bool("one-file-system", false, "do not read directories on other file systems than the root")
+	flags.BoolVar(oneFileSystem, "x", false, "stay on one file system, as -one-file-system")
+	flags.Bool("xattrs", false, "hash extended attributes and ACLs with each entry")
+	flags.Var(&metadata, "metadata", "hash `FIELDS` of each entry below the root: mode, owner, mtime and xattrs, comma-separated, or none")
+	flags.Var(special, "special-files", "devices, sockets and FIFOs: skip, record (type and device number only) or error")
+	flags.Var(normalize, "normalize", "Unicode `FORM` of hashed names: none (as stored), nfc or nfd")
+	flags.Bool("case-insensitive", false, "skip names that only differ in case from an earlier one, as Windows and macOS would")
+	flags.Var(&jobs, "j", "hash `N` files at once (default: CPU count)")
+	flags.Var(&jobs, "workers", "hash `N` files at once, as -j")
+	flags.Var(hashFormat, "hash-format", "`FORMAT` of the hashes: mtfs (SHA-256), mtfs-sha512, mtfs-blake2b or mtfs-blake3, or git / git-sha256 to match the tree ids of a Git checkout")
+}
+
+// walkArgs returns the walk options set in flags, parsed after
+// addWalkFlags, as arguments for the backend. Flags of the command
+// itself are left out.
+func walkArgs(flags *flag.FlagSet) []string {
+	walk := flag.NewFlagSet("walk", flag.ContinueOnError)
+	addWalkFlags(walk)
+	var backend []string
+	flags.Visit(func(f *flag.Flag) {
+		if walk.Lookup(f.Name) == nil {
+			return
+		}
+		switch v := f.Value.(type) {
+		case *workers:
+			// Set in the environment already
+		case *patternList:
+			for _, p := range *v {
+				backend = append(backend, "--"+f.Name+"="+p)
+			}
+		default:
+			name := f.Name
+			if name == "x" {
+				name = "one-file-system"
+			}
+			backend = append(backend, "--"+name+"="+v.String())
+		}
+	})
+	return backend
+}
@@ -0,0 +1,194 @@
+// Package watch keeps the tree of a directory up to date as it changes.
+// File system notifications tell which entries were created, modified or
+// removed; once they settle, only those entries are hashed again, with
+// the directories above them, so the root hash follows the directory
+// without rebuilding it. Changes reached only through a followed symbolic
+// link to outside the tree are not noticed.
+package watch
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"MTFS/pkg/merkle"
+	"MTFS/state"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultDelay is how long changes must settle before they are hashed.
+const DefaultDelay = 300 * time.Millisecond
+
+// Update is the outcome of hashing a batch of changes again.
+type Update struct {
+	Time  time.Time
+	Root  string   // root hash after the update
+	Paths []string // slash-separated entries that changed, relative to the root
+	Err   error    // why the tree may not reflect the changes
+}
+
+// Watcher follows the changes to a built tree.
+type Watcher struct {
+	Dir string
+
+	builder *merkle.Builder
+	tree    *merkle.Tree
+	events  *fsnotify.Watcher
+	delay   time.Duration
+	notify  func(Update)
+	done    chan struct{}
+	stopped chan struct{}
+
+	mu   sync.Mutex
+	root string
+}
+
+// Start builds the tree of dir with builder and watches it, calling
+// notify from another goroutine with each update, until Close.
+func Start(builder *merkle.Builder, dir string, delay time.Duration, notify func(Update)) (*Watcher, error) {
+	// Notifications name entries under the path the directory is watched as
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := builder.Build(dir)
+	if err != nil {
+		return nil, err
+	}
+	events, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{Dir: dir, builder: builder, tree: tree, events: events, delay: delay, notify: notify,
+		done: make(chan struct{}), stopped: make(chan struct{}), root: tree.Root.Hash}
+	if err := w.add(w.Dir); err != nil {
+		events.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// Root returns the root hash of the tree as of the last update.
+func (w *Watcher) Root() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.root
+}
+
+// Stats returns the counts of the tree as of the last update.
+func (w *Watcher) Stats() merkle.Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.tree.Stats()
+}
+
+// Close stops watching. Changes not hashed yet are dropped.
+func (w *Watcher) Close() error {
+	close(w.done)
+	err := w.events.Close()
+	<-w.stopped
+	return err
+}
+
+// add watches the directory at p and those below it, except the state
+// directory, which every build writes to.
+func (w *Watcher) add(p string) error {
+	return filepath.WalkDir(p, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Gone already, or unreadable: its update says so
+			if p == w.Dir {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if d.Name() == state.DirName && filepath.Dir(p) == w.Dir {
+			return filepath.SkipDir
+		}
+		if err := w.events.Add(p); err != nil && p == w.Dir {
+			return err
+		}
+		return nil
+	})
+}
+
+// run collects the changed entries and hashes them again once no other
+// change came for the delay.
+func (w *Watcher) run() {
+	defer close(w.stopped)
+	pending := map[string]bool{}
+	timer := time.NewTimer(w.delay)
+	timer.Stop()
+	for {
+		select {
+		case <-w.done:
+			timer.Stop()
+			return
+		case e, ok := <-w.events.Events:
+			if !ok {
+				return
+			}
+			rel, ok := w.relPath(e.Name)
+			if !ok {
+				continue
+			}
+			if e.Has(fsnotify.Create) {
+				// A new directory may hold entries before it is watched;
+				// walking it again finds them
+				w.add(e.Name)
+			}
+			pending[rel] = true
+			timer.Reset(w.delay)
+		case err, ok := <-w.events.Errors:
+			if !ok {
+				return
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				// Changes were lost, so the whole tree is walked again
+				pending["."] = true
+				timer.Reset(w.delay)
+				continue
+			}
+			w.notify(Update{Time: time.Now(), Root: w.Root(), Err: err})
+		case <-timer.C:
+			paths := make([]string, 0, len(pending))
+			for rel := range pending {
+				paths = append(paths, rel)
+			}
+			sort.Strings(paths)
+			clear(pending)
+			w.notify(w.update(paths))
+		}
+	}
+}
+
+// update hashes the entries at paths again.
+func (w *Watcher) update(paths []string) Update {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.builder.Update(w.tree, paths...)
+	w.root = w.tree.Root.Hash
+	return Update{Time: time.Now(), Root: w.root, Paths: paths, Err: err}
+}
+
+// relPath returns the slash-separated path of name relative to the tree
+// root, or false if it is in the state directory.
+func (w *Watcher) relPath(name string) (string, bool) {
+	rel, err := filepath.Rel(w.Dir, name)
+	if err != nil {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == state.DirName || strings.HasPrefix(rel, state.DirName+"/") {
+		return "", false
+	}
+	return rel, true
+}